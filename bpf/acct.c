@@ -21,8 +21,69 @@ struct acct_event_t {
   u16 dstport;
   u32 netns;
   u8 proto;
+  u8 family;
+  u16 zone;
+  u8 tcp_state;
+  u32 ifindex;
+  u64 real_ts;
+  u32 seq;
+  u32 cpu;
 };
 
+// Conntrack entry and interface of the packet being accounted,
+// stashed by the kprobe for use by the kretprobe.
+struct ct_stash_t {
+  struct nf_conn *ct;
+  u32 ifindex;
+};
+
+// Key of the address allowlist tries. The address family is matched
+// as part of the prefix, so the prefix length of an IPv4 network a.b.c.d/n
+// is 8 + n.
+struct lpm_key_t {
+  u32 prefixlen;
+  u8 family;
+  u8 addr[16];
+  u8 pad[3];
+};
+
+// Cooldown of flows exceeding a byte rate, in bytes per second
+// over both directions since the flow's previous event.
+struct cooldown_class_t {
+  u64 min_rate;
+  u64 cooldown;
+};
+
+// Sampling state of a flow, kept from the previous event sent to userspace.
+struct flow_state_t {
+  u64 next;     // deadline of the next event
+  u64 ts;       // timestamp of the previous event
+  u64 bytes;    // total bytes at the previous event
+  u64 cooldown; // cooldown applied after the previous event
+};
+
+// Indices of values in the config map.
+#define CONFIG_COOLDOWN 0
+#define CONFIG_FILTER_SRC 1
+#define CONFIG_FILTER_DST 2
+#define CONFIG_FILTER_PROTO 3
+#define CONFIG_FILTER_PORT 4
+#define CONFIG_DISABLE_IPV4 5
+#define CONFIG_DISABLE_IPV6 6
+#define CONFIG_COOLDOWN_CLASSES 7
+#define CONFIG_MIN_BYTES 8
+#define CONFIG_SAMPLE_RATE 9
+#define CONFIG_REAL_TIME 10
+#define CONFIG_FILTER_NETNS 11
+
+// Indices of the event streams in the seq map.
+#define SEQ_UPDATE 0
+#define SEQ_END 1
+
+// Maximum amount of entries in the cooldown_class map, must match
+// maxCooldownClasses in pkg/bpf.
+#define MAX_COOLDOWN_CLASSES 4
+
 // get_acct_ext gets a reference to the nf_conn's accounting extension.
 // Returns non-zero on error.
 __attribute__((always_inline))
@@ -102,6 +163,7 @@ static void extract_tuple(struct acct_event_t *data, struct nf_conn *ct) {
   bpf_probe_read(&tuplehash, sizeof(tuplehash), &ct->tuplehash);
 
   data->proto = tuplehash[IP_CT_DIR_ORIGINAL].tuple.dst.protonum;
+  data->family = tuplehash[IP_CT_DIR_ORIGINAL].tuple.src.l3num;
 
   data->srcaddr = tuplehash[IP_CT_DIR_ORIGINAL].tuple.src.u3;
   data->dstaddr = tuplehash[IP_CT_DIR_ORIGINAL].tuple.dst.u3;
@@ -111,6 +173,23 @@ static void extract_tuple(struct acct_event_t *data, struct nf_conn *ct) {
 
 }
 
+// extract_zone extracts the nf_conn's conntrack zone ID into an acct_event_t.
+// The zone remains zero if the kernel is built without zone support.
+__attribute__((always_inline))
+static void extract_zone(struct acct_event_t *data, struct nf_conn *ct) {
+#ifdef CONFIG_NF_CONNTRACK_ZONES
+  bpf_probe_read(&data->zone, sizeof(data->zone), &ct->zone.id);
+#endif
+}
+
+// extract_tcp_state extracts the conntrack state of a TCP flow into an acct_event_t.
+// Must be called after extract_tuple.
+__attribute__((always_inline))
+static void extract_tcp_state(struct acct_event_t *data, struct nf_conn *ct) {
+  if (data->proto == IPPROTO_TCP)
+    bpf_probe_read(&data->tcp_state, sizeof(data->tcp_state), &ct->proto.tcp.state);
+}
+
 // extract_netns extracts the nf_conn's network namespace inode number into an acct_event_t.
 __attribute__((always_inline))
 static void extract_netns(struct acct_event_t *data, struct nf_conn *ct) {
@@ -128,6 +207,34 @@ static void extract_netns(struct acct_event_t *data, struct nf_conn *ct) {
   }
 }
 
+#ifdef ACCT_RINGBUF
+
+// Size of the event ring buffers in bytes, must match ringBufSize in pkg/bpf.
+#define RINGBUF_SIZE (1 << 22)
+
+static int (*bpf_ringbuf_output)(void *ringbuf, void *data, u64 size, u64 flags) =
+	(void *) BPF_FUNC_ringbuf_output;
+
+struct bpf_map_def SEC("maps/ringbuf_acct_update") ringbuf_acct_update = {
+	.type = BPF_MAP_TYPE_RINGBUF,
+	.max_entries = RINGBUF_SIZE,
+	.pinning = 0,
+	.namespace = "",
+};
+
+struct bpf_map_def SEC("maps/ringbuf_acct_end") ringbuf_acct_end = {
+	.type = BPF_MAP_TYPE_RINGBUF,
+	.max_entries = RINGBUF_SIZE,
+	.pinning = 0,
+	.namespace = "",
+};
+
+// submit_event sends an event to userspace over the ring buffer with the given name.
+#define submit_event(ctx, name, data) \
+  bpf_ringbuf_output(&ringbuf_##name, data, sizeof(*data), 0)
+
+#else
+
 struct bpf_map_def SEC("maps/perf_acct_update") perf_acct_update = {
 	.type = BPF_MAP_TYPE_PERF_EVENT_ARRAY,
 	.key_size = sizeof(int),
@@ -146,10 +253,16 @@ struct bpf_map_def SEC("maps/perf_acct_end") perf_acct_end = {
 	.namespace = "",
 };
 
+// submit_event sends an event to userspace over the perf buffer with the given name.
+#define submit_event(ctx, name, data) \
+  bpf_perf_event_output(ctx, &perf_##name, CUR_CPU_IDENTIFIER, data, sizeof(*data))
+
+#endif // ACCT_RINGBUF
+
 struct bpf_map_def SEC("maps/nextupd") nextupd = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(int),
-	.value_size = sizeof(void *),
+	.value_size = sizeof(struct flow_state_t),
 	.max_entries = 1024,
 	.pinning = 0,
 	.namespace = "",
@@ -158,7 +271,7 @@ struct bpf_map_def SEC("maps/nextupd") nextupd = {
 struct bpf_map_def SEC("maps/currct") currct = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(int),
-	.value_size = sizeof(void *),
+	.value_size = sizeof(struct ct_stash_t),
 	.max_entries = 1024,
 	.pinning = 0,
 	.namespace = "",
@@ -168,40 +281,267 @@ struct bpf_map_def SEC("maps/config") config = {
 	.type = BPF_MAP_TYPE_HASH,
 	.key_size = sizeof(int),
 	.value_size = sizeof(void *),
-	.max_entries = 1,
+	.max_entries = 12,
 	.pinning = 0,
 	.namespace = "",
 };
 
+struct bpf_map_def SEC("maps/filter_src") filter_src = {
+	.type = BPF_MAP_TYPE_LPM_TRIE,
+	.key_size = sizeof(struct lpm_key_t),
+	.value_size = sizeof(u8),
+	.max_entries = 1024,
+	.map_flags = BPF_F_NO_PREALLOC,
+	.pinning = 0,
+	.namespace = "",
+};
 
-SEC("kprobe/__nf_ct_refresh_acct")
-int kprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
+struct bpf_map_def SEC("maps/filter_dst") filter_dst = {
+	.type = BPF_MAP_TYPE_LPM_TRIE,
+	.key_size = sizeof(struct lpm_key_t),
+	.value_size = sizeof(u8),
+	.max_entries = 1024,
+	.map_flags = BPF_F_NO_PREALLOC,
+	.pinning = 0,
+	.namespace = "",
+};
 
-  struct nf_conn *ct = (struct nf_conn *) PT_REGS_PARM1(ctx);
+// Allowlists of protocols and destination ports, indexed
+// by protocol and port number respectively.
+struct bpf_map_def SEC("maps/filter_proto") filter_proto = {
+	.type = BPF_MAP_TYPE_ARRAY,
+	.key_size = sizeof(u32),
+	.value_size = sizeof(u8),
+	.max_entries = 256,
+	.pinning = 0,
+	.namespace = "",
+};
 
-  u32 pid = bpf_get_current_pid_tgid();
+struct bpf_map_def SEC("maps/filter_port") filter_port = {
+	.type = BPF_MAP_TYPE_ARRAY,
+	.key_size = sizeof(u32),
+	.value_size = sizeof(u8),
+	.max_entries = 65536,
+	.pinning = 0,
+	.namespace = "",
+};
 
-	// stash the conntrack pointer for lookup on return
-	bpf_map_update_elem(&currct, &pid, &ct, BPF_ANY);
+// Allowlist of network namespaces, keyed by inode number. Must match
+// maxNetNS in pkg/bpf.
+struct bpf_map_def SEC("maps/filter_netns") filter_netns = {
+	.type = BPF_MAP_TYPE_HASH,
+	.key_size = sizeof(u32),
+	.value_size = sizeof(u8),
+	.max_entries = 256,
+	.pinning = 0,
+	.namespace = "",
+};
 
-	return 0;
+// Sequence number of the last event submitted on each stream, by CPU.
+struct bpf_map_def SEC("maps/seq") seq = {
+	.type = BPF_MAP_TYPE_PERCPU_ARRAY,
+	.key_size = sizeof(u32),
+	.value_size = sizeof(u32),
+	.max_entries = 2,
+	.pinning = 0,
+	.namespace = "",
+};
+
+// Cooldown classes sorted by descending minimum rate. Unused
+// entries have a zero cooldown.
+struct bpf_map_def SEC("maps/cooldown_class") cooldown_class = {
+	.type = BPF_MAP_TYPE_ARRAY,
+	.key_size = sizeof(u32),
+	.value_size = sizeof(struct cooldown_class_t),
+	.max_entries = MAX_COOLDOWN_CLASSES,
+	.pinning = 0,
+	.namespace = "",
+};
+
+// filter_enabled checks if the filter at cfg_key is enabled in the config map.
+__attribute__((always_inline))
+static int filter_enabled(u32 cfg_key) {
+  u64 *enabled = bpf_map_lookup_elem(&config, &cfg_key);
+  return enabled && *enabled;
 }
 
-SEC("kretprobe/__nf_ct_refresh_acct")
-int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
+#ifdef ACCT_REALTIME
 
-  u32 pid = bpf_get_current_pid_tgid();
-  u64 ts = bpf_ktime_get_ns();
+static unsigned long long (*bpf_ktime_get_tai_ns)(void) =
+	(void *) BPF_FUNC_ktime_get_tai_ns;
 
-  // Look up the conntrack structure stashed by the kprobe.
-  struct nf_conn **ctp;
-  ctp = bpf_map_lookup_elem(&currct, &pid);
-	if (ctp == 0)
-		return 0;
+// extract_real_ts records the wall clock time of an event into an acct_event_t
+// if enabled in the config map. The kernel only exposes the TAI clock to BPF,
+// which is converted to UTC in userspace.
+__attribute__((always_inline))
+static void extract_real_ts(struct acct_event_t *data) {
+  if (filter_enabled(CONFIG_REAL_TIME))
+    data->real_ts = bpf_ktime_get_tai_ns();
+}
 
-  // Dereference and delete from the stash table.
-  struct nf_conn *ct = *ctp;
-  bpf_map_delete_elem(&currct, &pid);
+#else
+
+// extract_real_ts is a no-op on kernels that can't read the wall clock from BPF.
+#define extract_real_ts(data)
+
+#endif // ACCT_REALTIME
+
+// stamp_seq stamps an event with the next sequence number of the given stream
+// on the current CPU, so userspace can detect gaps left by lost events.
+// Sequence numbers start at 1 and skip 0 when wrapping around.
+__attribute__((always_inline))
+static void stamp_seq(struct acct_event_t *data, u32 stream) {
+
+  u32 *seqp = bpf_map_lookup_elem(&seq, &stream);
+  if (!seqp)
+    return;
+
+  if (!++(*seqp))
+    ++(*seqp);
+
+  data->seq = *seqp;
+  data->cpu = bpf_get_smp_processor_id();
+}
+
+// filter_index looks up an index in an allowlist array if the allowlist
+// is enabled in the config map. Returns non-zero if the index is not allowed.
+__attribute__((always_inline))
+static int filter_index(void *array, u32 cfg_key, u32 idx) {
+
+  if (!filter_enabled(cfg_key))
+    return 0;
+
+  u8 *allow = bpf_map_lookup_elem(array, &idx);
+  if (!allow || !*allow)
+    return -1;
+
+  return 0;
+}
+
+// filter_addr looks up an address in an allowlist trie if the allowlist
+// is enabled in the config map. Returns non-zero if the address is not allowed.
+__attribute__((always_inline))
+static int filter_addr(void *trie, u32 cfg_key, u8 family, union nf_inet_addr *addr) {
+
+  if (!filter_enabled(cfg_key))
+    return 0;
+
+  struct lpm_key_t key = {
+    .prefixlen = 8 + sizeof(key.addr) * 8,
+    .family = family,
+  };
+  __builtin_memcpy(key.addr, addr, sizeof(key.addr));
+
+  if (!bpf_map_lookup_elem(trie, &key))
+    return -1;
+
+  return 0;
+}
+
+// filter_flow checks the flow's address family, protocol, destination port,
+// source and destination addresses and network namespace against the
+// configured allowlists.
+// The port allowlist only applies to TCP, UDP, SCTP and UDPLite flows.
+// Returns non-zero if the flow should not be sent to userspace.
+__attribute__((always_inline))
+static int filter_flow(struct acct_event_t *data) {
+
+  if (data->family == AF_INET && filter_enabled(CONFIG_DISABLE_IPV4))
+    return -1;
+
+  if (data->family == AF_INET6 && filter_enabled(CONFIG_DISABLE_IPV6))
+    return -1;
+
+  if (filter_index(&filter_proto, CONFIG_FILTER_PROTO, data->proto))
+    return -1;
+
+  if (data->proto == IPPROTO_TCP || data->proto == IPPROTO_UDP ||
+      data->proto == IPPROTO_SCTP || data->proto == IPPROTO_UDPLITE) {
+    if (filter_index(&filter_port, CONFIG_FILTER_PORT, ntohs(data->dstport)))
+      return -1;
+  }
+
+  if (filter_addr(&filter_src, CONFIG_FILTER_SRC, data->family, &data->srcaddr))
+    return -1;
+
+  if (filter_addr(&filter_dst, CONFIG_FILTER_DST, data->family, &data->dstaddr))
+    return -1;
+
+  if (filter_enabled(CONFIG_FILTER_NETNS) && !bpf_map_lookup_elem(&filter_netns, &data->netns))
+    return -1;
+
+  return 0;
+}
+
+// flow_sampled checks if a flow is among the 1 in N flows reported when
+// sampling is configured. Flows are selected by a multiplicative hash of their
+// connection ID, so all events of a flow get the same verdict. Must match
+// Sampled in pkg/bpf.
+__attribute__((always_inline))
+static int flow_sampled(struct nf_conn *ct) {
+
+  u32 cfg_key = CONFIG_SAMPLE_RATE;
+  u64 *rate = bpf_map_lookup_elem(&config, &cfg_key);
+  if (!rate || *rate <= 1)
+    return 1;
+
+  u32 hash = ((u64)(u32)ct * 0x9E3779B97F4A7C15ULL) >> 32;
+
+  return hash % *rate == 0;
+}
+
+// flow_cooldown returns the cooldown of the first class whose minimum rate
+// the flow exceeds, or def if the flow isn't in any class. The rate is only
+// measured if at least a millisecond has passed since the previous event,
+// otherwise the flow keeps its previous cooldown.
+__attribute__((always_inline))
+static u64 flow_cooldown(struct flow_state_t *state, u64 ts, u64 bytes, u64 def) {
+
+  if (!state || !filter_enabled(CONFIG_COOLDOWN_CLASSES))
+    return def;
+
+  u64 ms = (ts - state->ts) / 1000000;
+  if (!ms)
+    return state->cooldown ? state->cooldown : def;
+
+  u64 rate = (bytes - state->bytes) * 1000 / ms;
+
+#pragma unroll
+  for (u32 i = 0; i < MAX_COOLDOWN_CLASSES; i++) {
+    struct cooldown_class_t *c = bpf_map_lookup_elem(&cooldown_class, &i);
+    if (c && c->cooldown && rate >= c->min_rate)
+      return c->cooldown;
+  }
+
+  return def;
+}
+
+// skb_ifindex returns the packet's input interface, or its output interface
+// for locally-generated packets.
+__attribute__((always_inline))
+static u32 skb_ifindex(struct sk_buff *skb) {
+
+  u32 ifindex = 0;
+
+  bpf_probe_read(&ifindex, sizeof(ifindex), &skb->skb_iif);
+  if (!ifindex) {
+    struct net_device *dev;
+    bpf_probe_read(&dev, sizeof(dev), &skb->dev);
+    if (dev)
+      bpf_probe_read(&ifindex, sizeof(ifindex), &dev->ifindex);
+  }
+
+  return ifindex;
+}
+
+// acct_refresh reports an update event of a conntrack entry whose counters
+// were just refreshed, received on the given interface.
+__attribute__((always_inline))
+static int acct_refresh(void *ctx, struct nf_conn *ct, u32 ifindex, u64 ts) {
+
+  // Skip flows left out by sampling before doing any work.
+  if (!flow_sampled(ct))
+    return 0;
 
   // Obtain reference to accounting conntrack extension.
   struct nf_conn_acct *acct_ext = 0;
@@ -209,7 +549,7 @@ int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
     return 0;
 
   // Initialize cooldown value in the config map to 2 seconds.
-  u64 config_cd = 0;
+  u32 config_cd = CONFIG_COOLDOWN;
   u64 def_cd = 2000000000;
   bpf_map_update_elem(&config, &config_cd, &def_cd, BPF_NOEXIST);
 
@@ -218,12 +558,20 @@ int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
     .start = 0,
     .ts = ts,
     .cid = (u32)ct,
+    .ifindex = ifindex,
   };
 
   // Pull counters onto the BPF stack first, so that we can make event rate
   // limiting decisions based on packet counters without doing unnecessary work.
   extract_counters(&data, acct_ext);
 
+  // Only report flows that moved enough bytes to matter for accounting,
+  // the others are reported when they're destroyed.
+  u32 config_mb = CONFIG_MIN_BYTES;
+  u64 *mbp = bpf_map_lookup_elem(&config, &config_mb);
+  if (mbp && data.bytes_orig + data.bytes_ret < *mbp)
+    return 0;
+
   // Sample accounting events from the kernel using a hybrid rate limiting model.
   // On every event that is sent, a future deadline is set for that specific flow
   // equal to the cooldown time. Every packet that is handled when the dealine has
@@ -243,10 +591,10 @@ int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
   u64 pkts_total = (data.packets_orig + data.packets_ret);
 
   // Look up when the next event is scheduled to be sent to userspace.
-  u64 *nextp = bpf_map_lookup_elem(&nextupd, &ct);
+  struct flow_state_t *statep = bpf_map_lookup_elem(&nextupd, &ct);
   u64 next = 0;
-  if (nextp)
-    next = *nextp;
+  if (statep)
+    next = statep->next;
 
   // The deadline has not yet expired, but we allow certain exceptions.
   if (ts < next) {
@@ -265,27 +613,45 @@ int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
 
   // Extract proto, src/dst address and ports.
   extract_tuple(&data, ct);
+
   // Extract network namespace identifier (inode).
   extract_netns(&data, ct);
+
+  // Drop flows that don't match the allowlists.
+  if (filter_flow(&data))
+    return 0;
+
+  // Extract conntrack zone ID.
+  extract_zone(&data, ct);
+  // Extract TCP connection state.
+  extract_tcp_state(&data, ct);
   // Extract conntrack connection mark.
   bpf_probe_read(&data.connmark, sizeof(data.connmark), &ct->mark);
+  // Record the event's wall clock time.
+  extract_real_ts(&data);
+  // Number the event within the update stream.
+  stamp_seq(&data, SEQ_UPDATE);
 
   // Submit event to userspace.
-  bpf_perf_event_output(ctx, &perf_acct_update, CUR_CPU_IDENTIFIER, &data, sizeof(data));
+  submit_event(ctx, acct_update, &data);
 
-  // Set the deadline to the current timestamp plus the cooldown period.
-  next = ts + cd;
-  bpf_map_update_elem(&nextupd, &ct, &next, BPF_ANY);
+  // Set the deadline to the current timestamp plus the cooldown period
+  // of the flow's rate class.
+  u64 bytes_total = data.bytes_orig + data.bytes_ret;
+  struct flow_state_t state = {
+    .ts = ts,
+    .bytes = bytes_total,
+    .cooldown = flow_cooldown(statep, ts, bytes_total, cd),
+  };
+  state.next = ts + state.cooldown;
+  bpf_map_update_elem(&nextupd, &ct, &state, BPF_ANY);
 
   return 0;
 }
 
-SEC("kprobe/nf_conntrack_free")
-int kprobe__nf_conntrack_free(struct pt_regs *ctx) {
-
-  u64 ts = bpf_ktime_get_ns();
-
-  struct nf_conn *ct = (struct nf_conn *) PT_REGS_PARM1(ctx);
+// acct_free reports the end event of a conntrack entry being freed.
+__attribute__((always_inline))
+static int acct_free(void *ctx, struct nf_conn *ct, u64 ts) {
 
   // Remove next-update entry for connection.
   bpf_map_delete_elem(&nextupd, &ct);
@@ -293,6 +659,9 @@ int kprobe__nf_conntrack_free(struct pt_regs *ctx) {
   // Below this point, the kprobe can return early,
   // make sure all bookkeeping is handled above.
 
+  if (!flow_sampled(ct))
+    return 0;
+
   struct nf_conn_acct *acct_ext = 0;
   if (get_acct_ext(&acct_ext, ct))
     return 0;
@@ -310,13 +679,100 @@ int kprobe__nf_conntrack_free(struct pt_regs *ctx) {
   extract_counters(&data, acct_ext);
   extract_tuple(&data, ct);
   extract_netns(&data, ct);
+
+  if (filter_flow(&data))
+    return 0;
+
+  extract_zone(&data, ct);
+  extract_tcp_state(&data, ct);
   bpf_probe_read(&data.connmark, sizeof(data.connmark), &ct->mark);
+  extract_real_ts(&data);
+  stamp_seq(&data, SEQ_END);
 
-  bpf_perf_event_output(ctx, &perf_acct_end, CUR_CPU_IDENTIFIER, &data, sizeof(data));
+  submit_event(ctx, acct_end, &data);
 
   return 0;
 }
 
+SEC("kprobe/__nf_ct_refresh_acct")
+int kprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
+
+  struct nf_conn *ct = (struct nf_conn *) PT_REGS_PARM1(ctx);
+  struct sk_buff *skb = (struct sk_buff *) PT_REGS_PARM3(ctx);
+
+  u32 pid = bpf_get_current_pid_tgid();
+
+  struct ct_stash_t stash = {
+    .ct = ct,
+    .ifindex = skb_ifindex(skb),
+  };
+
+	// stash the conntrack pointer for lookup on return
+	bpf_map_update_elem(&currct, &pid, &stash, BPF_ANY);
+
+	return 0;
+}
+
+SEC("kretprobe/__nf_ct_refresh_acct")
+int kretprobe____nf_ct_refresh_acct(struct pt_regs *ctx) {
+
+  u32 pid = bpf_get_current_pid_tgid();
+  u64 ts = bpf_ktime_get_ns();
+
+  // Look up the conntrack structure stashed by the kprobe.
+  struct ct_stash_t *stashp;
+  stashp = bpf_map_lookup_elem(&currct, &pid);
+	if (stashp == 0)
+		return 0;
+
+  // Dereference and delete from the stash table.
+  struct nf_conn *ct = stashp->ct;
+  u32 ifindex = stashp->ifindex;
+  bpf_map_delete_elem(&currct, &pid);
+
+  return acct_refresh(ctx, ct, ifindex, ts);
+}
+
+SEC("kprobe/nf_conntrack_free")
+int kprobe__nf_conntrack_free(struct pt_regs *ctx) {
+
+  u64 ts = bpf_ktime_get_ns();
+
+  struct nf_conn *ct = (struct nf_conn *) PT_REGS_PARM1(ctx);
+
+  return acct_free(ctx, ct, ts);
+}
+
+#ifdef ACCT_FENTRY
+
+// Tracing programs attached through fentry/fexit trampolines instead of the
+// kprobes above, if the running kernel has BTF. Their context holds the
+// arguments of the traced function, so the arguments of an fexit program
+// don't need to be stashed on entry.
+
+SEC("fexit/__nf_ct_refresh_acct")
+int fexit____nf_ct_refresh_acct(u64 *ctx) {
+
+  u64 ts = bpf_ktime_get_ns();
+
+  struct nf_conn *ct = (struct nf_conn *) ctx[0];
+  struct sk_buff *skb = (struct sk_buff *) ctx[2];
+
+  return acct_refresh(ctx, ct, skb_ifindex(skb), ts);
+}
+
+SEC("fentry/nf_conntrack_free")
+int fentry__nf_conntrack_free(u64 *ctx) {
+
+  u64 ts = bpf_ktime_get_ns();
+
+  struct nf_conn *ct = (struct nf_conn *) ctx[0];
+
+  return acct_free(ctx, ct, ts);
+}
+
+#endif // ACCT_FENTRY
+
 char _license[] SEC("license") = "GPL";
 
 __u32 _version SEC("version") = 0xFFFFFFFE;
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/generator"
+)
+
+var (
+	benchRate   float64
+	benchEvents uint64
+	benchFlows  int
+	benchIPv6   float64
+	benchSeed   int64
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Feed synthetic events through the pipeline and report sink throughput.",
+	Long: `Bench generates synthetic events resembling the traffic of a busy gateway
+and feeds them through the pipeline, its enrichers and the configured sinks,
+without loading the BPF probe. When all events are generated, the sinks are
+flushed and the throughput and allocations of the pipeline are reported.`,
+	RunE:         bench,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().Float64VarP(&benchRate, "rate", "r", 10000, "events per second, 0 generates events as fast as the pipeline accepts them")
+	benchCmd.Flags().Uint64VarP(&benchEvents, "events", "n", 1000000, "amount of events to generate")
+	benchCmd.Flags().IntVar(&benchFlows, "flows", 10000, "amount of concurrent flows")
+	benchCmd.Flags().Float64Var(&benchIPv6, "ipv6", 0.2, "share of IPv6 flows")
+	benchCmd.Flags().Int64Var(&benchSeed, "seed", 0, "seed of the random number generator")
+}
+
+func bench(cmd *cobra.Command, args []string) error {
+
+	scfg, err := types.DecodeSinkConfigMap(viper.GetStringMap(cfgSinks))
+	if err != nil {
+		return err
+	}
+
+	pcfg, err := probeConfig()
+	if err != nil {
+		return errors.Wrap(err, "probe configuration")
+	}
+
+	pipe, err := newPipeline(pcfg)
+	if err != nil {
+		return err
+	}
+
+	pipe.SetGenerator(generator.New(generator.Config{
+		Flows: benchFlows,
+		IPv6:  benchIPv6,
+		Seed:  benchSeed,
+	}), benchRate, benchEvents)
+
+	if err := initRegisterSinks(scfg, pipe); err != nil {
+		return errors.Wrap(err, "initialize and register sinks")
+	}
+
+	if err := initRegisterEnrichers(pipe); err != nil {
+		return errors.Wrap(err, "initialize and register enrichers")
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	if err := pipe.Init(); err != nil {
+		return errors.Wrap(err, "initialize pipeline")
+	}
+	if err := pipe.Start(); err != nil {
+		return errors.Wrap(err, "start pipeline")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-pipe.Replayed():
+	case s := <-sig:
+		log.Info("Stopping benchmark with signal ", s)
+	}
+
+	// Stopping the pipeline waits for the sinks to write all events.
+	if err := pipe.Stop(); err != nil {
+		return errors.Wrap(err, "stop pipeline")
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	events := pipe.Stats().EventsTotal
+	if events == 0 {
+		return nil
+	}
+
+	fmt.Printf("Processed %d events in %s (%.0f events/s)\n",
+		events, elapsed.Round(time.Millisecond), float64(events)/elapsed.Seconds())
+	fmt.Printf("Allocated %.1f objects, %.0f bytes per event\n\n",
+		float64(after.Mallocs-before.Mallocs)/float64(events),
+		float64(after.TotalAlloc-before.TotalAlloc)/float64(events))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SINK\tPUSHED\tDROPPED\tERRORS\tBATCHES\tBATCHES DROPPED\tEVENTS/S")
+
+	for _, s := range pipe.GetSinks() {
+		ss := s.Stats()
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.0f\n", s.Name(),
+			ss.EventsPushed, ss.EventsDropped+ss.QueueDropped, ss.EventErrors,
+			ss.BatchesSent, ss.BatchesDropped, float64(ss.EventsPushed)/elapsed.Seconds())
+	}
+
+	return w.Flush()
+}
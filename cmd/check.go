@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check whether the BPF probe can be loaded on this machine.",
+	Long: `Check verifies that the running kernel is supported by one of the bundled
+probes, that conntrack accounting is enabled, that the kernel functions the
+probe attaches to are present, that bpf() is permitted and that the locked
+memory limit fits the probe's buffers. Every failed check is printed with a
+suggestion for resolving it. Exits non-zero if a required check fails.`,
+	RunE:         check,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+func check(cmd *cobra.Command, args []string) error {
+
+	pcfg, err := probeConfig()
+	if err != nil {
+		return errors.Wrap(err, "probe configuration")
+	}
+
+	var failed int
+	for _, c := range bpf.Preflight(pcfg) {
+		switch {
+		case c.Err == nil:
+			fmt.Printf("[ ok ] %s: %s\n", c.Name, c.Detail)
+			continue
+		case c.Optional:
+			fmt.Printf("[warn] %s: %s\n", c.Name, c.Err)
+		default:
+			fmt.Printf("[fail] %s: %s\n", c.Name, c.Err)
+			failed++
+		}
+
+		if c.Hint != "" {
+			fmt.Printf("       %s\n", c.Hint)
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	if viper.GetBool(cfgSysctlManage) {
+		fmt.Println("sysctl_manage is enabled, conntracct enables the conntrack sysctls on startup.")
+	}
+	if viper.GetBool(cfgNetlinkFallback) {
+		fmt.Println("netlink_fallback is enabled, conntracct receives conntrack events over netlink when the probe can't be loaded.")
+	}
+
+	return fmt.Errorf("required checks failed: %d", failed)
+}
@@ -2,40 +2,247 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+	"github.com/ti-mo/conntracct/internal/enrich/container"
+	"github.com/ti-mo/conntracct/internal/enrich/flowid"
+	"github.com/ti-mo/conntracct/internal/enrich/iface"
+	"github.com/ti-mo/conntracct/internal/enrich/kubernetes"
+	"github.com/ti-mo/conntracct/internal/enrich/netns"
+	"github.com/ti-mo/conntracct/internal/enrich/process"
+	"github.com/ti-mo/conntracct/internal/enrich/scope"
+	"github.com/ti-mo/conntracct/internal/enrich/static"
+	"github.com/ti-mo/conntracct/internal/enrich/subnet"
 	"github.com/ti-mo/conntracct/internal/pipeline"
 	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/internal/sinks/helpers"
 	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
 )
 
 var (
 	// Key names in configuration file.
 	cfgAPIEnabled    = "api_enabled"
 	cfgAPIEndpoint   = "api_endpoint"
+	cfgAPIStreamRate = "api_stream_rate"
+	cfgAPIOrigins    = "api_stream_origins"
+	cfgAPIPProf      = "api_pprof"
+	cfgGRPCEnabled   = "grpc_enabled"
+	cfgGRPCEndpoint  = "grpc_endpoint"
 	cfgSysctlManage  = "sysctl_manage"
+	cfgSysctlRestore = "sysctl_restore"
 	cfgPProfEnabled  = "pprof_enabled"
 	cfgPProfEndpoint = "pprof_endpoint"
+	cfgPProfBlock    = "pprof_block_rate"
+	cfgPProfMutex    = "pprof_mutex_fraction"
+
+	cfgHandoverSocket  = "handover_socket"
+	cfgHandoverTimeout = "handover_timeout"
+
+	cfgLogLevel      = "log_level"
+	cfgLogFormat     = "log_format"
+	cfgLogFile       = "log_file"
+	cfgLogMaxSize    = "log_max_size_mb"
+	cfgLogMaxBackups = "log_max_backups"
+	cfgLogModules    = "log_modules"
+
+	cfgMetricsEnabled  = "metrics_enabled"
+	cfgMetricsEndpoint = "metrics_endpoint"
 
 	cfgSinks = "sinks"
 
+	cfgK8sEnabled    = "k8s_enabled"
+	cfgK8sKubeconfig = "k8s_kubeconfig"
+	cfgK8sNode       = "k8s_node"
+	cfgK8sLabels     = "k8s_labels"
+
+	cfgContainerEnabled   = "container_enabled"
+	cfgContainerRuntime   = "container_runtime"
+	cfgContainerSocket    = "container_socket"
+	cfgContainerNamespace = "container_namespace"
+	cfgContainerRefresh   = "container_refresh"
+	cfgContainerProcPath  = "container_proc_path"
+
+	cfgIfaceEnabled = "iface_enabled"
+
+	cfgFlowIDEnabled = "flowid_enabled"
+
+	cfgScopeEnabled = "scope_enabled"
+
+	cfgNetNSEnabled = "netns_enabled"
+	cfgNetNSDir     = "netns_dir"
+	cfgNetNSRefresh = "netns_refresh"
+
+	cfgSubnetEnabled  = "subnet_enabled"
+	cfgSubnetPrefixes = "subnet_prefixes"
+	cfgSubnetReplace  = "subnet_replace"
+
+	cfgProcessEnabled  = "process_enabled"
+	cfgProcessRefresh  = "process_refresh"
+	cfgProcessProcPath = "process_proc_path"
+
+	cfgStaticTags     = "static_tags"
+	cfgStaticHostname = "static_hostname"
+
+	cfgAggEnabled  = "aggregate_enabled"
+	cfgAggKeys     = "aggregate_keys"
+	cfgAggInterval = "aggregate_interval"
+
+	cfgRatesEnabled = "rates_enabled"
+	cfgTopEnabled   = "top_enabled"
+
+	cfgFlowCacheMaxFlows = "flow_cache_max_flows"
+	cfgFlowCacheTTL      = "flow_cache_ttl"
+
+	cfgStaleTimeout  = "stale_timeout"
+	cfgResetInterval = "reset_detect_interval"
+
+	cfgPipelineWorkers = "pipeline_workers"
+
+	cfgInputInclude = "input_include"
+	cfgInputExclude = "input_exclude"
+
+	cfgQueueUpdateSize  = "queue_update_size"
+	cfgQueueDestroySize = "queue_destroy_size"
+	cfgQueueBackpress   = "queue_backpressure"
+
+	cfgBootstrap       = "bootstrap_enabled"
+	cfgNetlinkFallback = "netlink_fallback"
+
+	cfgProbeCooldown = "probe_cooldown_ms"
+	cfgCooldownCls   = "probe_cooldown_classes"
+	cfgProbeMinBytes = "probe_min_bytes"
+	cfgSampleRate    = "probe_sample_rate"
+	cfgRealTime      = "probe_real_time"
+	cfgPinPath       = "probe_pin_path"
+	cfgFilterSrcNets = "filter_src_nets"
+	cfgFilterDstNets = "filter_dst_nets"
+	cfgFilterProtos  = "filter_protos"
+	cfgFilterPorts   = "filter_dst_ports"
+	cfgFilterNetNS   = "filter_netns"
+	cfgDisableIPv4   = "disable_ipv4"
+	cfgDisableIPv6   = "disable_ipv6"
+
+	cfgPerfPageCount   = "perf_page_count"
+	cfgRingBufSize     = "ringbuf_size"
+	cfgWakeupWatermark = "wakeup_watermark"
+
 	// Default application configuration.
 	cfgDefaults = map[string]interface{}{
 		// HTTP API endpoint.
 		cfgAPIEnabled:  true,
 		cfgAPIEndpoint: "localhost:8000",
 
+		// Maximum events per second sent to each client of /events.
+		cfgAPIStreamRate: 100,
+
+		// Origins of web pages allowed to open /events, none by default.
+		cfgAPIOrigins: []string{},
+
+		// Serve pprof and execution trace endpoints on the API listener.
+		cfgAPIPProf: false,
+
+		// gRPC API endpoint, eg. for streaming events with 'conntracct events'.
+		cfgGRPCEnabled:  false,
+		cfgGRPCEndpoint: "localhost:8001",
+
+		// Log info and above as text to stderr. Log files are rotated at
+		// log_max_size_mb, keeping log_max_backups older files.
+		cfgLogLevel:      "info",
+		cfgLogFormat:     "text",
+		cfgLogMaxSize:    100,
+		cfgLogMaxBackups: 5,
+
 		// Sinks for accounting data.
 		cfgSinks: map[string]interface{}{
 			"stdout": map[string]interface{}{
-				"type":        "stdout",
-				"sourcePorts": true,
+				"type":          "stdout",
+				"enableSrcPort": true,
 			},
 		},
 
+		// Tag events with Kubernetes pod metadata.
+		cfgK8sEnabled: false,
+
+		// Tag events with the metadata of the container owning their netns.
+		cfgContainerEnabled: false,
+		cfgContainerRuntime: "docker",
+
+		// Tag events with the name of their interface.
+		cfgIfaceEnabled: false,
+
+		// Tag events with a unique ID of their flow.
+		cfgFlowIDEnabled: false,
+
+		// Tag events with the scope of their addresses.
+		cfgScopeEnabled: false,
+
+		// Tag events with the name of their network namespace.
+		cfgNetNSEnabled: false,
+
+		// Tag events with the prefixes of their addresses.
+		cfgSubnetEnabled: false,
+		cfgSubnetReplace: false,
+
+		// Tag locally-originated flows with the PID and command of their process.
+		cfgProcessEnabled: false,
+
+		// Tags attached to every event, eg. site or role.
+		cfgStaticTags:     map[string]string{},
+		cfgStaticHostname: false,
+
+		// Roll up the traffic of flows by key instead of sending every event.
+		cfgAggEnabled:  false,
+		cfgAggKeys:     []string{"src_addr", "dst_addr", "dst_port", "proto"},
+		cfgAggInterval: "10s",
+
+		// Compute the throughput of flows between their events.
+		cfgRatesEnabled: false,
+
+		// Keep a table of all live flows, served by the API on /top and /flows.
+		cfgTopEnabled: false,
+
+		// Bound the flows held by the rate tracker and the live flow table.
+		cfgFlowCacheMaxFlows: 1048576,
+		cfgFlowCacheTTL:      "1h",
+
+		// Synthesize destroy events for flows without events for this long.
+		cfgStaleTimeout: "0s",
+
+		// Check for conntrack table flushes and module reloads this often.
+		cfgResetInterval: "0s",
+
+		// Amount of workers delivering events to the sinks in parallel.
+		cfgPipelineWorkers: 1,
+
+		// Events buffered between the probe and the pipeline, dropped when full.
+		cfgQueueUpdateSize:  1024,
+		cfgQueueDestroySize: 1024,
+		cfgQueueBackpress:   "drop",
+
+		// Send an update event for all flows in the conntrack table on startup.
+		cfgBootstrap: true,
+
+		// Receive conntrack events over netlink if the BPF probe can't be loaded.
+		cfgNetlinkFallback: true,
+
+		// Minimum time between update events of a flow.
+		cfgProbeCooldown: 2000,
+
 		// Automatically manage Conntrack-related sysctls of the host.
-		cfgSysctlManage: true,
+		cfgSysctlManage:  true,
+		cfgSysctlRestore: true,
+
+		// Expose pipeline, probe and sink statistics to Prometheus.
+		cfgMetricsEnabled:  false,
+		cfgMetricsEndpoint: "localhost:9810",
+
+		// Time to wait for the running instance to exit when taking over.
+		cfgHandoverTimeout: "30s",
 
 		// Run a pprof endpoint during operation. (live profiling)
 		cfgPProfEnabled:  false,
@@ -50,22 +257,293 @@ func init() {
 	}
 }
 
-// initRegisterSinks initializes a list of sinks according to their types
-// and registers them to the given pipeline.
+// initRegisterSinks initializes a list of sinks according to their types,
+// connects them to their dead-letter sinks and registers them to the given pipeline.
 func initRegisterSinks(cl []types.SinkConfig, pipe *pipeline.Pipeline) error {
 
+	ss := make([]sinks.Sink, 0, len(cl))
+
 	for _, cfg := range cl {
 		// Create and initialize a new sink based on the SinkConfig.
 		sink, err := sinks.New(cfg)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("creating sink '%s'", cfg.Name))
 		}
+		ss = append(ss, sink)
+	}
+
+	ss, err := sinks.ConnectDeadLetters(ss, cl)
+	if err != nil {
+		return errors.Wrap(err, "connecting dead-letter sinks")
+	}
+
+	if err := sinks.ConnectFailover(ss, cl); err != nil {
+		return errors.Wrap(err, "connecting failover groups")
+	}
 
+	for _, sink := range ss {
 		// Register created sink with pipeline.
 		if err := pipe.RegisterSink(sink); err != nil {
-			return errors.Wrap(err, fmt.Sprintf("registering sink '%s' to pipeline", cfg.Name))
+			return errors.Wrap(err, fmt.Sprintf("registering sink '%s' to pipeline", sink.Name()))
 		}
 	}
 
 	return nil
 }
+
+// initRegisterEnrichers initializes the enrichers enabled in the
+// configuration and registers them to the given pipeline.
+func initRegisterEnrichers(pipe *pipeline.Pipeline) error {
+
+	if viper.GetBool(cfgK8sEnabled) {
+		k, err := kubernetes.New(kubernetes.Config{
+			Kubeconfig: viper.GetString(cfgK8sKubeconfig),
+			Node:       viper.GetString(cfgK8sNode),
+			Labels:     viper.GetStringSlice(cfgK8sLabels),
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating kubernetes enricher")
+		}
+
+		pipe.RegisterEnricher(k)
+	}
+
+	if viper.GetBool(cfgContainerEnabled) {
+		rt, err := containerRuntime(viper.GetString(cfgContainerRuntime))
+		if err != nil {
+			return err
+		}
+
+		c, err := container.New(rt, container.Config{
+			Refresh:  viper.GetDuration(cfgContainerRefresh),
+			ProcPath: viper.GetString(cfgContainerProcPath),
+		})
+		if err != nil {
+			rt.Close()
+			return errors.Wrap(err, "creating container enricher")
+		}
+
+		pipe.RegisterEnricher(c)
+	}
+
+	if viper.GetBool(cfgIfaceEnabled) {
+		i, err := iface.New()
+		if err != nil {
+			return errors.Wrap(err, "creating interface enricher")
+		}
+
+		pipe.RegisterEnricher(i)
+	}
+
+	if viper.GetBool(cfgNetNSEnabled) {
+		n, err := netns.New(netnsConfig())
+		if err != nil {
+			return errors.Wrap(err, "creating netns enricher")
+		}
+
+		pipe.RegisterEnricher(n)
+	}
+
+	if viper.GetBool(cfgFlowIDEnabled) {
+		pipe.RegisterEnricher(flowid.New())
+	}
+
+	if viper.GetBool(cfgScopeEnabled) {
+		pipe.RegisterEnricher(scope.New())
+	}
+
+	if viper.GetBool(cfgSubnetEnabled) {
+		s, err := subnet.New(subnet.Config{
+			Prefixes: viper.GetStringSlice(cfgSubnetPrefixes),
+			Replace:  viper.GetBool(cfgSubnetReplace),
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating subnet enricher")
+		}
+
+		pipe.RegisterEnricher(s)
+	}
+
+	if viper.GetBool(cfgProcessEnabled) {
+		p, err := process.New(process.Config{
+			Refresh:  viper.GetDuration(cfgProcessRefresh),
+			ProcPath: viper.GetString(cfgProcessProcPath),
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating process enricher")
+		}
+
+		pipe.RegisterEnricher(p)
+	}
+
+	if tags := viper.GetStringMapString(cfgStaticTags); len(tags) != 0 || viper.GetBool(cfgStaticHostname) {
+		s, err := static.New(static.Config{
+			Tags:     tags,
+			Hostname: viper.GetBool(cfgStaticHostname),
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating static enricher")
+		}
+
+		pipe.RegisterEnricher(s)
+	}
+
+	return nil
+}
+
+// containerRuntime returns the container.Runtime with the given name.
+func containerRuntime(name string) (container.Runtime, error) {
+
+	socket := viper.GetString(cfgContainerSocket)
+
+	switch name {
+	case "docker":
+		return container.NewDocker(socket), nil
+	case "containerd":
+		rt, err := container.NewContainerd(socket, viper.GetString(cfgContainerNamespace))
+		if err != nil {
+			return nil, errors.Wrap(err, "connecting to containerd")
+		}
+		return rt, nil
+	default:
+		return nil, fmt.Errorf("unknown container runtime '%s'", name)
+	}
+}
+
+// netnsConfig builds the configuration of the network namespace enricher,
+// also used for resolving the namespaces of the netns allowlist.
+func netnsConfig() netns.Config {
+	return netns.Config{
+		Dir:     viper.GetString(cfgNetNSDir),
+		Refresh: viper.GetDuration(cfgNetNSRefresh),
+	}
+}
+
+// probeConfig builds a bpf.Config for the accounting probe
+// from the application configuration.
+func probeConfig() (bpf.Config, error) {
+
+	src, err := parseNets(viper.GetStringSlice(cfgFilterSrcNets))
+	if err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgFilterSrcNets)
+	}
+
+	dst, err := parseNets(viper.GetStringSlice(cfgFilterDstNets))
+	if err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgFilterDstNets)
+	}
+
+	protos, err := parseProtos(viper.GetStringSlice(cfgFilterProtos))
+	if err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgFilterProtos)
+	}
+
+	ports, err := parsePortRanges(viper.GetStringSlice(cfgFilterPorts))
+	if err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgFilterPorts)
+	}
+
+	ns, err := netns.Resolve(netnsConfig(), viper.GetStringSlice(cfgFilterNetNS))
+	if err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgFilterNetNS)
+	}
+
+	var classes []cooldownClass
+	if err := viper.UnmarshalKey(cfgCooldownCls, &classes); err != nil {
+		return bpf.Config{}, errors.Wrap(err, cfgCooldownCls)
+	}
+
+	cls := make([]bpf.CooldownClass, 0, len(classes))
+	for _, c := range classes {
+		cls = append(cls, bpf.CooldownClass{MinRate: c.MinRate, CooldownMillis: c.CooldownMillis})
+	}
+
+	return bpf.Config{
+		CooldownMillis: uint32(viper.GetInt(cfgProbeCooldown)),
+		SrcNets:        src,
+		DstNets:        dst,
+		Protos:         protos,
+		DstPorts:       ports,
+		NetNS:          ns,
+		DisableIPv4:    viper.GetBool(cfgDisableIPv4),
+		DisableIPv6:    viper.GetBool(cfgDisableIPv6),
+
+		CooldownClasses: cls,
+		MinBytes:        uint64(viper.GetInt64(cfgProbeMinBytes)),
+		SampleRate:      uint32(viper.GetInt(cfgSampleRate)),
+		RealTime:        viper.GetBool(cfgRealTime),
+		PinPath:         viper.GetString(cfgPinPath),
+		PerfPageCount:   viper.GetInt(cfgPerfPageCount),
+		RingBufSize:     viper.GetInt(cfgRingBufSize),
+		WakeupWatermark: uint32(viper.GetInt(cfgWakeupWatermark)),
+	}, nil
+}
+
+// inputFilter returns the filter selecting the events entering the pipeline.
+func inputFilter() pipeline.InputFilter {
+	return pipeline.InputFilter{
+		Include: viper.GetString(cfgInputInclude),
+		Exclude: viper.GetString(cfgInputExclude),
+	}
+}
+
+// cooldownClass is an entry of the probe_cooldown_classes list.
+type cooldownClass struct {
+	MinRate        uint64 `mapstructure:"min_rate"`
+	CooldownMillis uint32 `mapstructure:"cooldown_ms"`
+}
+
+// parseNets parses a list of networks in CIDR notation.
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+
+	return out, nil
+}
+
+// parseProtos parses a list of protocol names or numbers.
+func parseProtos(protos []string) ([]uint8, error) {
+
+	out := make([]uint8, 0, len(protos))
+	for _, p := range protos {
+		n, err := helpers.ParseProto(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+
+	return out, nil
+}
+
+// parsePortRanges parses a list of ports or port ranges like '1-1024'.
+func parsePortRanges(ranges []string) ([]bpf.PortRange, error) {
+
+	out := make([]bpf.PortRange, 0, len(ranges))
+	for _, r := range ranges {
+		first, last := r, r
+		if i := strings.IndexByte(r, '-'); i != -1 {
+			first, last = r[:i], r[i+1:]
+		}
+
+		f, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range '%s'", r)
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+		if err != nil || l < f {
+			return nil, fmt.Errorf("invalid port range '%s'", r)
+		}
+
+		out = append(out, bpf.PortRange{First: uint16(f), Last: uint16(l)})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+// Amount of busiest flows included in a stats dump.
+const dumpTopFlows = 10
+
+// statsDump is a snapshot of the pipeline's statistics, logged on SIGUSR1.
+type statsDump struct {
+	Pipeline   pipeline.Stats                 `json:"pipeline"`
+	Probe      pipeline.ProbeStats            `json:"probe"`
+	Middleware []pipeline.MiddlewareStats     `json:"middleware"`
+	Sinks      map[string]types.SinkStatsData `json:"sinks"`
+	FlowCaches map[string]flowcache.Stats     `json:"flow_caches"`
+
+	// Summary of the live flow table, if enabled.
+	Flows *pipeline.FlowSummary `json:"flows,omitempty"`
+}
+
+// dumpStats logs a snapshot of the statistics of the pipeline, its probe
+// and sinks, and a summary of its live flow table, as JSON.
+func dumpStats(pipe *pipeline.Pipeline) {
+
+	d := statsDump{
+		Pipeline:   pipe.Stats(),
+		Probe:      pipe.ProbeStats(),
+		Middleware: pipe.MiddlewareStats(),
+		Sinks:      make(map[string]types.SinkStatsData),
+		FlowCaches: pipe.FlowCacheStats(),
+	}
+
+	for _, s := range pipe.GetSinks() {
+		d.Sinks[s.Name()] = s.Stats()
+	}
+
+	if fs, err := pipe.FlowSummary(dumpTopFlows); err == nil {
+		d.Flows = &fs
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		log.Errorf("Failed to encode stats: %s", err)
+		return
+	}
+
+	log.Infof("Stats: %s", b)
+}
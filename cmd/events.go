@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+var (
+	eventsFilter  string
+	eventsUpdate  bool
+	eventsDestroy bool
+)
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Stream the live events of a running conntracct instance.",
+	Long: `Events streams the events of a running conntracct instance over its
+gRPC API and prints them as JSON, one per line, until interrupted. The instance
+needs to have grpc_enabled set. Events can be filtered with an expression in
+the syntax of sink filters, eg. 'proto == 6 && dst_port == 443'.`,
+	RunE:         events,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+
+	eventsCmd.Flags().StringVarP(&eventsFilter, "filter", "f", "", "filter expression")
+	eventsCmd.Flags().BoolVar(&eventsUpdate, "update", false, "only stream update events")
+	eventsCmd.Flags().BoolVar(&eventsDestroy, "destroy", false, "only stream destroy events")
+}
+
+func events(cmd *cobra.Command, args []string) error {
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	conn, err := grpc.Dial(viper.GetString(cfgGRPCEndpoint),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return errors.Wrap(err, "connecting to gRPC server")
+	}
+	defer conn.Close()
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		"filter":  eventsFilter,
+		"update":  eventsUpdate,
+		"destroy": eventsDestroy,
+	})
+	if err != nil {
+		return err
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true},
+		"/conntracct.Conntracct/StreamEvents")
+	if err != nil {
+		return errors.Wrap(err, "streaming events")
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return errors.Wrap(err, "streaming events")
+	}
+	if err := stream.CloseSend(); err != nil {
+		return errors.Wrap(err, "streaming events")
+	}
+
+	for {
+		e := new(schema.Event)
+		err := stream.RecvMsg(e)
+		if err == io.EOF || ctx.Err() != nil {
+			printDropped(stream.Trailer())
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "streaming events")
+		}
+
+		b, err := protojson.Marshal(e)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+}
+
+// printDropped prints the amount of events dropped by the server
+// for being streamed too slowly, if any.
+func printDropped(md metadata.MD) {
+	if d := md.Get("dropped-events"); len(d) > 0 && d[0] != "0" {
+		fmt.Fprintf(os.Stderr, "%s events dropped by the server\n", d[0])
+	}
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// reloader applies changes to the configuration file to a running pipeline.
+type reloader struct {
+	pipe *pipeline.Pipeline
+
+	// Configuration the pipeline is currently running with.
+	probe bpf.Config
+	sinks map[string]types.SinkConfig
+}
+
+// newReloader returns a reloader for a pipeline running with the given
+// probe and sink configurations.
+func newReloader(pipe *pipeline.Pipeline, pcfg bpf.Config, scfg []types.SinkConfig) *reloader {
+
+	r := &reloader{
+		pipe:  pipe,
+		probe: pcfg,
+		sinks: make(map[string]types.SinkConfig, len(scfg)),
+	}
+
+	for _, sc := range scfg {
+		r.sinks[sc.Name] = sc
+	}
+
+	return r
+}
+
+// reload re-reads the configuration file and applies it to the pipeline.
+// Sinks with an unchanged configuration are kept, all others are recreated.
+// The running sinks are left untouched if the new configuration is invalid.
+func (r *reloader) reload() error {
+
+	if err := viper.ReadInConfig(); err != nil {
+		return errors.Wrap(err, "reading configuration file")
+	}
+
+	scfg, err := types.DecodeSinkConfigMap(viper.GetStringMap(cfgSinks))
+	if err != nil {
+		return err
+	}
+
+	pcfg, err := probeConfig()
+	if err != nil {
+		return errors.Wrap(err, "probe configuration")
+	}
+
+	// Only cooldowns and protocol/port allowlists can be changed in the kernel
+	// without reloading the probe and losing its flow state.
+	if !reflect.DeepEqual(r.probe.SrcNets, pcfg.SrcNets) ||
+		!reflect.DeepEqual(r.probe.DstNets, pcfg.DstNets) ||
+		r.probe.DisableIPv4 != pcfg.DisableIPv4 ||
+		r.probe.DisableIPv6 != pcfg.DisableIPv6 ||
+		r.probe.SampleRate != pcfg.SampleRate ||
+		r.probe.RealTime != pcfg.RealTime ||
+		r.probe.PinPath != pcfg.PinPath {
+		log.Warn("Changes to network allowlists, address families, sampling, real time stamps and the pin path require a restart, ignoring")
+
+		pcfg.SrcNets, pcfg.DstNets = r.probe.SrcNets, r.probe.DstNets
+		pcfg.DisableIPv4, pcfg.DisableIPv6 = r.probe.DisableIPv4, r.probe.DisableIPv6
+		pcfg.SampleRate, pcfg.RealTime = r.probe.SampleRate, r.probe.RealTime
+		pcfg.PinPath = r.probe.PinPath
+	}
+
+	if err := r.pipe.Reconfigure(pcfg); err != nil {
+		return errors.Wrap(err, "reconfiguring probe")
+	}
+	r.probe = pcfg
+
+	// Replaces the input filter set over the API, if any.
+	if err := r.pipe.SetInputFilter(inputFilter()); err != nil {
+		return err
+	}
+
+	// Index running sinks by name so unchanged ones can be carried over.
+	running := make(map[string]sinks.Sink)
+	for _, s := range r.pipe.GetSinks() {
+		running[s.Name()] = s
+	}
+
+	next := make([]sinks.Sink, 0, len(scfg))
+	nextCfg := make(map[string]types.SinkConfig, len(scfg))
+	var created []sinks.Sink
+
+	for _, sc := range scfg {
+		nextCfg[sc.Name] = sc
+
+		if s, ok := running[sc.Name]; ok && reflect.DeepEqual(r.sinks[sc.Name], sc) {
+			next = append(next, s)
+			continue
+		}
+
+		s, err := sinks.New(sc)
+		if err != nil {
+			// Release the sinks created so far.
+			for _, c := range created {
+				_ = c.Close()
+			}
+			return errors.Wrap(err, fmt.Sprintf("creating sink '%s'", sc.Name))
+		}
+		created = append(created, s)
+		next = append(next, s)
+
+		log.Infof("Created accounting sink '%s'", sc.Name)
+	}
+
+	// Kept sinks are reconnected too, their dead-letter sink may have changed.
+	next, err = sinks.ConnectDeadLetters(next, scfg)
+	if err != nil {
+		for _, c := range created {
+			_ = c.Close()
+		}
+		return errors.Wrap(err, "connecting dead-letter sinks")
+	}
+
+	// Kept sinks are regrouped too, restarting their groups' health checks.
+	if err := sinks.ConnectFailover(next, scfg); err != nil {
+		for _, c := range created {
+			_ = c.Close()
+		}
+		return errors.Wrap(err, "connecting failover groups")
+	}
+
+	if err := r.pipe.ReplaceSinks(next); err != nil {
+		return errors.Wrap(err, "replacing sinks")
+	}
+
+	// Close sinks that were removed or recreated, writing their buffered events.
+	kept := make(map[sinks.Sink]bool, len(next))
+	for _, s := range next {
+		kept[s] = true
+	}
+	for name, s := range running {
+		if kept[s] {
+			continue
+		}
+		if err := s.Close(); err != nil {
+			log.Errorf("Error closing accounting sink '%s': %s", name, err)
+			continue
+		}
+		log.Infof("Closed accounting sink '%s'", name)
+	}
+
+	r.sinks = nextCfg
+
+	return nil
+}
@@ -6,10 +6,12 @@ import (
 	"path"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/ti-mo/conntracct/internal/logging"
 )
 
 var (
@@ -17,6 +19,10 @@ var (
 
 	cfgFile string
 	debug   bool
+
+	logLevel  string
+	logFormat string
+	logFile   string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -26,7 +32,7 @@ var rootCmd = &cobra.Command{
 	Long: `Conntracct is a tool for extracting network flow information from Linux hosts.
 It hooks into Conntrack's accounting (acct) subsystem using eBPF to receive
 low-overhead updates to connection packet counters.`,
-	PersistentPreRun: rootPreRun,
+	PersistentPreRunE: rootPreRun,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -42,6 +48,9 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default conntracct.yml in $HOME/.config/ or /etc/conntracct/)")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug logging")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level, overrides log_level")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "log format, text or json, overrides log_format")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "file to log to instead of stderr, overrides log_file")
 }
 
 // initConfig sets up Viper with config search paths and an env prefix.
@@ -79,9 +88,34 @@ func initConfig() {
 
 // rootPreRun runs after all commands have been initialized and config
 // flags have been bound.
-func rootPreRun(*cobra.Command, []string) {
+func rootPreRun(*cobra.Command, []string) error {
+
+	lcfg := logging.Config{
+		Level:      viper.GetString(cfgLogLevel),
+		Format:     viper.GetString(cfgLogFormat),
+		File:       viper.GetString(cfgLogFile),
+		MaxSize:    viper.GetInt64(cfgLogMaxSize) << 20,
+		MaxBackups: viper.GetInt(cfgLogMaxBackups),
+		Modules:    viper.GetStringMapString(cfgLogModules),
+	}
+
+	if logLevel != "" {
+		lcfg.Level = logLevel
+	}
 	// Enable debug logging if debug flag enabled.
 	if debug {
-		log.SetLevel(log.DebugLevel)
+		lcfg.Level = "debug"
+	}
+	if logFormat != "" {
+		lcfg.Format = logFormat
 	}
+	if logFile != "" {
+		lcfg.File = logFile
+	}
+
+	if err := logging.Init(lcfg); err != nil {
+		return errors.Wrap(err, "logging configuration")
+	}
+
+	return nil
 }
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -12,10 +15,18 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/ti-mo/conntracct/internal/apiserver"
+	"github.com/ti-mo/conntracct/internal/capture"
 	"github.com/ti-mo/conntracct/internal/config"
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/internal/handover"
+	"github.com/ti-mo/conntracct/internal/metrics"
 	"github.com/ti-mo/conntracct/internal/pipeline"
 	"github.com/ti-mo/conntracct/internal/pprof"
+	"github.com/ti-mo/conntracct/internal/privdrop"
+	"github.com/ti-mo/conntracct/internal/rpcserver"
 	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/internal/systemd"
+	"github.com/ti-mo/conntracct/pkg/bpf"
 )
 
 // runCmd represents the run command
@@ -26,16 +37,35 @@ var runCmd = &cobra.Command{
 	SilenceUsage: true, // Don't show usage when RunE returns error.
 }
 
+var (
+	replayFile  string
+	replaySpeed float64
+	takeover    bool
+	dropUser    string
+	dropGroup   string
+)
+
 func init() {
 	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().StringVar(&replayFile, "replay", "", "replay the events of a capture file instead of receiving them from the kernel")
+	runCmd.Flags().Float64Var(&replaySpeed, "replay-speed", 1, "playback speed of the replayed capture, 0 replays events as fast as possible")
+	runCmd.Flags().StringVar(&dropUser, "user", "", "drop root privileges to this user once the probe is attached")
+	runCmd.Flags().StringVar(&dropGroup, "group", "", "group to drop root privileges to, the user's primary group by default")
+	runCmd.Flags().BoolVar(&takeover, "takeover", false, "take over accounting from the instance listening on the handover socket")
 }
 
 func run(cmd *cobra.Command, args []string) error {
 
+	if dropGroup != "" && dropUser == "" {
+		return errors.New("--group requires --user to be set")
+	}
+
 	// Listen on for pprof sessions if enabled.
 	if viper.GetBool(cfgPProfEnabled) {
 		pprof.ListenAndServe(viper.GetString(cfgPProfEndpoint))
 	}
+	pprof.SetProfileRates(viper.GetInt(cfgPProfBlock), viper.GetInt(cfgPProfMutex))
 
 	scfg, err := types.DecodeSinkConfigMap(viper.GetStringMap(cfgSinks))
 	if err != nil {
@@ -45,45 +75,323 @@ func run(cmd *cobra.Command, args []string) error {
 	// Log decoded config map to debug.
 	log.Debugf("Sink configuration: %+v", scfg)
 
-	pipe := pipeline.New()
+	pcfg, err := probeConfig()
+	if err != nil {
+		return errors.Wrap(err, "probe configuration")
+	}
+
+	pipe, err := newPipeline(pcfg)
+	if err != nil {
+		return err
+	}
+
+	if replayFile != "" {
+		f, err := os.Open(replayFile)
+		if err != nil {
+			return errors.Wrap(err, "opening capture")
+		}
+		defer f.Close()
+
+		r, err := capture.NewReader(f)
+		if err != nil {
+			return errors.Wrap(err, "reading capture")
+		}
+		pipe.SetReplay(r, replaySpeed)
+	}
 
 	if err := initRegisterSinks(scfg, pipe); err != nil {
 		return errors.Wrap(err, "initialize and register sinks")
 	}
 
+	if err := initRegisterEnrichers(pipe); err != nil {
+		return errors.Wrap(err, "initialize and register enrichers")
+	}
+
+	// Connect to the running instance before starting the probe, so it only
+	// takes over if there's an instance to take over from.
+	hsPath := viper.GetString(cfgHandoverSocket)
+	var hc *handover.Conn
+	if takeover && replayFile == "" {
+		if hsPath == "" {
+			return fmt.Errorf("--takeover requires %s to be set", cfgHandoverSocket)
+		}
+
+		hc, err = handover.Dial(hsPath)
+		if err == handover.ErrNotRunning {
+			log.Warnf("No instance listening on '%s', starting without taking over", hsPath)
+		} else if err != nil {
+			return errors.Wrap(err, "take over")
+		}
+	}
+
+	// Set when handing over to a new instance, which is replied to once
+	// the pipeline is stopped.
+	var hreq *handover.Request
+	var herr error
+
+	// Enable conntrack accounting before loading the probe, flows would be
+	// reported with zero counters otherwise. Replaying doesn't touch the kernel.
+	if viper.GetBool(cfgSysctlManage) && replayFile == "" {
+		restore, err := config.Init()
+		if viper.GetBool(cfgSysctlRestore) {
+			// Runs after the pipeline is stopped. The instance taking over
+			// relies on the sysctls staying enabled.
+			defer func() {
+				if hreq != nil {
+					return
+				}
+				if err := restore(); err != nil {
+					log.Errorf("Failed to restore sysctls: %s", err)
+				}
+			}()
+		}
+		if err != nil {
+			return errors.Wrap(err, "apply system configuration")
+		}
+	}
+
 	// Initialize and start accounting pipeline.
 	if err := pipe.Init(); err != nil {
 		return errors.Wrap(err, "initialize pipeline")
 	}
-	if err := pipe.Start(); err != nil {
+	if hc != nil {
+		ts, err := pipe.TakeOver()
+		if err != nil {
+			hc.Close()
+			return errors.Wrap(err, "start pipeline")
+		}
+
+		// Wait for the running instance to exit before binding the API listeners.
+		if err := hc.TakeOver(ts, viper.GetDuration(cfgHandoverTimeout)); err != nil {
+			log.Errorf("Failed to take over, events may be lost or reported twice: %s", err)
+		} else {
+			log.Info("Took over from the running instance")
+		}
+	} else if err := pipe.Start(); err != nil {
 		return errors.Wrap(err, "start pipeline")
 	}
 
-	// Initialize and run the API server if enabled.
+	// Account flows that existed before the probe was loaded. Flows are
+	// already known to the sinks when taking over.
+	if viper.GetBool(cfgBootstrap) && replayFile == "" && hc == nil {
+		if err := pipe.Bootstrap(); err != nil {
+			log.Warnf("Failed to bootstrap flows from the conntrack table: %s", err)
+		}
+	}
+
+	// Sockets passed by systemd socket activation, if any.
+	sockets, err := systemd.Listeners()
+	if err != nil {
+		return errors.Wrap(err, "socket activation")
+	}
+
+	// Initialize and run the API server if enabled, on the socket named
+	// api or the only socket passed by systemd, if any.
 	if viper.GetBool(cfgAPIEnabled) {
 		if err := apiserver.Init(pipe); err != nil {
 			return err
 		}
-		if err := apiserver.Run(viper.GetString(cfgAPIEndpoint)); err != nil {
+		apiserver.SetStreamRate(viper.GetInt(cfgAPIStreamRate))
+		apiserver.SetStreamOrigins(viper.GetStringSlice(cfgAPIOrigins))
+		apiserver.SetPProf(viper.GetBool(cfgAPIPProf))
+
+		if l := apiSocket(sockets); l != nil {
+			err = apiserver.Serve(l)
+		} else {
+			err = apiserver.Run(viper.GetString(cfgAPIEndpoint))
+		}
+		if err != nil {
 			return err
 		}
 	}
 
+	// Initialize and run the gRPC server if enabled.
+	if viper.GetBool(cfgGRPCEnabled) {
+		if err := rpcserver.Init(pipe); err != nil {
+			return err
+		}
+		if err := rpcserver.Run(viper.GetString(cfgGRPCEndpoint)); err != nil {
+			return err
+		}
+	}
+
+	// Serve Prometheus metrics if enabled.
+	if viper.GetBool(cfgMetricsEnabled) {
+		if err := metrics.ListenAndServe(viper.GetString(cfgMetricsEndpoint), pipe); err != nil {
+			return errors.Wrap(err, "start metrics endpoint")
+		}
+	}
+
+	// Listen for a new instance taking over.
+	var hreqs <-chan *handover.Request
+	if hsPath != "" && replayFile == "" {
+		hs, err := handover.Listen(hsPath)
+		if err != nil {
+			return err
+		}
+		defer hs.Close()
+		hreqs = hs.Requests()
+
+		// Runs after the pipeline is stopped and its sinks are flushed.
+		defer func() {
+			if hreq == nil {
+				return
+			}
+			if err := hreq.Reply(herr); err != nil {
+				log.Errorf("Failed to reply to handover request: %s", err)
+			}
+		}()
+	}
+
+	// Sinks are flushed even if the probe fails to detach, which it does
+	// after dropping privileges.
 	defer func() {
 		if err := pipe.Stop(); err != nil {
-			log.Fatalf("Failure stopping pipeline: %v", err)
+			log.Errorf("Failure stopping pipeline: %v", err)
 		}
 	}()
 
-	if err := config.Init(); err != nil {
-		return errors.Wrap(err, "apply system configuration")
+	// The probe is attached and all listeners are bound, drop to an
+	// unprivileged user right away.
+	if dropUser != "" {
+		if err := privdrop.Drop(dropUser, dropGroup); err != nil {
+			return errors.Wrap(err, "drop privileges")
+		}
+	}
+
+	// Send keepalives to the systemd watchdog while the probe makes progress.
+	wd, err := systemd.WatchdogInterval()
+	if err != nil {
+		return err
+	}
+	if wd > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go watchdog(pipe, wd, done)
 	}
 
-	// Wait for program to be interrupted.
+	notify(systemd.Ready)
+	defer notify(systemd.Stopping)
+
+	rl := newReloader(pipe, pcfg, scfg)
+
+	// Wait for program to be interrupted or for the capture to be replayed,
+	// reload the configuration on SIGHUP and log stats on SIGUSR1.
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGUSR1 {
+				dumpStats(pipe)
+				continue
+			}
+			if s != syscall.SIGHUP {
+				log.Info("Exiting with signal ", s)
+				return nil
+			}
 
-	log.Info("Exiting with signal ", <-sig)
+			notify(systemd.Reloading)
+			err := rl.reload()
+			notify(systemd.Ready)
+			if err != nil {
+				log.Errorf("Failed to reload configuration: %v", err)
+				continue
+			}
+			log.Info("Reloaded configuration")
+		case hreq = <-hreqs:
+			log.Info("Handing over to a new instance")
+			if herr = pipe.HandOver(hreq.Time); herr != nil {
+				log.Errorf("Failed to hand over, events may be reported twice: %s", herr)
+			}
+			return nil
+		case <-pipe.Replayed():
+			log.Info("Exiting after replaying capture")
+			return nil
+		}
+	}
+}
+
+// apiSocket returns the socket named api or the only socket passed by
+// socket activation, nil if there's none.
+func apiSocket(sockets map[string]net.Listener) net.Listener {
+
+	if l, ok := sockets["api"]; ok || len(sockets) != 1 {
+		return l
+	}
+
+	for _, l := range sockets {
+		return l
+	}
 
 	return nil
 }
+
+// notify sends a state to systemd, if conntracct runs as a Type=notify service.
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		log.Warnf("Failed to notify systemd: %s", err)
+	}
+}
+
+// watchdog sends keepalives to the systemd watchdog at half its interval until
+// done is closed. Keepalives are skipped while the pipeline's probe is stalled,
+// so systemd restarts the service once it's been stalled for the interval.
+func watchdog(pipe *pipeline.Pipeline, interval time.Duration, done <-chan struct{}) {
+
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		if err := pipe.Alive(interval / 2); err != nil {
+			log.Errorf("Skipping watchdog keepalive: %s", err)
+			continue
+		}
+		notify(systemd.Watchdog)
+	}
+}
+
+// newPipeline returns a pipeline with the processing stages enabled
+// in the configuration.
+func newPipeline(pcfg bpf.Config) (*pipeline.Pipeline, error) {
+
+	pipe := pipeline.New(pcfg)
+	pipe.SetNetlinkFallback(viper.GetBool(cfgNetlinkFallback))
+	pipe.SetFlowCache(flowcache.Config{
+		MaxFlows: viper.GetInt(cfgFlowCacheMaxFlows),
+		TTL:      viper.GetDuration(cfgFlowCacheTTL),
+	})
+	pipe.SetStaleTimeout(viper.GetDuration(cfgStaleTimeout))
+	pipe.SetResetDetection(viper.GetDuration(cfgResetInterval))
+	pipe.SetRates(viper.GetBool(cfgRatesEnabled))
+	pipe.SetTop(viper.GetBool(cfgTopEnabled))
+	pipe.SetWorkers(viper.GetInt(cfgPipelineWorkers))
+
+	if err := pipe.SetInputFilter(inputFilter()); err != nil {
+		return nil, err
+	}
+
+	bp, err := bpf.ParseBackpressure(viper.GetString(cfgQueueBackpress))
+	if err != nil {
+		return nil, errors.Wrap(err, "queue configuration")
+	}
+	pipe.SetQueues(viper.GetInt(cfgQueueUpdateSize), viper.GetInt(cfgQueueDestroySize), bp)
+
+	if viper.GetBool(cfgAggEnabled) {
+		if err := pipe.SetAggregation(pipeline.AggregateConfig{
+			Keys:     viper.GetStringSlice(cfgAggKeys),
+			Interval: viper.GetDuration(cfgAggInterval),
+		}); err != nil {
+			return nil, errors.Wrap(err, "aggregation configuration")
+		}
+	}
+
+	return pipe, nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+)
+
+var (
+	topAmount   int
+	topInterval time.Duration
+)
+
+// topCmd represents the top command
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show the busiest live flows of a running conntracct instance.",
+	Long: `Top periodically queries the API server of a running conntracct instance
+for its busiest live flows and displays them in an interactive terminal view.
+The instance needs to have top_enabled and api_enabled set.
+
+Keys: '<' and '>' select the sort column, 'r' reverses the order,
+'p' pauses updates and 'q' quits.`,
+	RunE:         top,
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.AddCommand(topCmd)
+
+	topCmd.Flags().IntVarP(&topAmount, "amount", "n", 50, "amount of flows to display")
+	topCmd.Flags().DurationVarP(&topInterval, "interval", "i", 2*time.Second, "refresh interval")
+}
+
+func top(cmd *cobra.Command, args []string) error {
+
+	u := url.URL{
+		Scheme: "http",
+		Host:   viper.GetString(cfgAPIEndpoint),
+		Path:   "/top",
+	}
+
+	c := &topClient{
+		client: &http.Client{Timeout: topInterval},
+		url:    u,
+		amount: topAmount,
+	}
+
+	// Fail early if the instance can't be reached or has no top flow table.
+	if _, err := c.fetch(pipeline.TopByRate); err != nil {
+		return err
+	}
+
+	return newTopView(c, topInterval).run()
+}
+
+// topClient queries the top flow endpoint of the API server.
+type topClient struct {
+	client *http.Client
+	url    url.URL
+	amount int
+}
+
+// fetch returns the client's amount of top flows in the given order.
+func (c *topClient) fetch(by string) ([]pipeline.TopFlow, error) {
+
+	u := c.url
+	u.RawQuery = url.Values{
+		"n":  {strconv.Itoa(c.amount)},
+		"by": {by},
+	}.Encode()
+
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "querying top flows")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("querying top flows: %s: %s", resp.Status, b)
+	}
+
+	var flows []pipeline.TopFlow
+	if err := json.NewDecoder(resp.Body).Decode(&flows); err != nil {
+		return nil, errors.Wrap(err, "decoding top flows")
+	}
+
+	return flows, nil
+}
+
+// hostPort joins an address and port, omitting zero ports.
+func hostPort(addr net.IP, port uint16) string {
+	if port == 0 {
+		return addr.String()
+	}
+	return net.JoinHostPort(addr.String(), strconv.Itoa(int(port)))
+}
+
+// humanBytes formats an amount of bytes with a binary unit prefix.
+func humanBytes(b float64) string {
+
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+)
+
+// topColumn is a column of the top view.
+type topColumn struct {
+	title string
+	// Order in which the server selects flows when sorting by this column.
+	by    string
+	value func(f pipeline.TopFlow) string
+	less  func(a, b pipeline.TopFlow) bool
+}
+
+var topColumns = []topColumn{
+	{"PROTO", pipeline.TopByRate,
+		func(f pipeline.TopFlow) string { return f.Proto },
+		func(a, b pipeline.TopFlow) bool { return a.Proto < b.Proto }},
+	{"SOURCE", pipeline.TopByRate,
+		func(f pipeline.TopFlow) string { return hostPort(f.SrcAddr, f.SrcPort) },
+		func(a, b pipeline.TopFlow) bool { return bytes.Compare(a.SrcAddr.To16(), b.SrcAddr.To16()) < 0 }},
+	{"DESTINATION", pipeline.TopByRate,
+		func(f pipeline.TopFlow) string { return hostPort(f.DstAddr, f.DstPort) },
+		func(a, b pipeline.TopFlow) bool { return bytes.Compare(a.DstAddr.To16(), b.DstAddr.To16()) < 0 }},
+	{"RATE/S", pipeline.TopByRate,
+		func(f pipeline.TopFlow) string { return humanBytes(f.BytesRate) },
+		func(a, b pipeline.TopFlow) bool { return a.BytesRate > b.BytesRate }},
+	{"PKTS/S", pipeline.TopByRate,
+		func(f pipeline.TopFlow) string { return fmt.Sprintf("%.0f", f.PacketsRate) },
+		func(a, b pipeline.TopFlow) bool { return a.PacketsRate > b.PacketsRate }},
+	{"ORIG", pipeline.TopByBytes,
+		func(f pipeline.TopFlow) string { return humanBytes(float64(f.BytesOrig)) },
+		func(a, b pipeline.TopFlow) bool { return a.BytesOrig > b.BytesOrig }},
+	{"RET", pipeline.TopByBytes,
+		func(f pipeline.TopFlow) string { return humanBytes(float64(f.BytesRet)) },
+		func(a, b pipeline.TopFlow) bool { return a.BytesRet > b.BytesRet }},
+}
+
+// Default sort column, RATE/S.
+const topDefaultColumn = 3
+
+// topView is an interactive terminal view of a conntracct instance's top flows.
+type topView struct {
+	client   *topClient
+	interval time.Duration
+
+	app    *tview.Application
+	table  *tview.Table
+	status *tview.TextView
+
+	// Protects the view's state, modified by key events and the refresh worker.
+	mu      sync.Mutex
+	flows   []pipeline.TopFlow
+	column  int
+	reverse bool
+	paused  bool
+}
+
+func newTopView(c *topClient, interval time.Duration) *topView {
+
+	v := &topView{
+		client:   c,
+		interval: interval,
+		app:      tview.NewApplication(),
+		table:    tview.NewTable().SetFixed(1, 0).SetSelectable(true, false),
+		status:   tview.NewTextView().SetDynamicColors(true),
+		column:   topDefaultColumn,
+	}
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(v.table, 0, 1, true).
+		AddItem(v.status, 1, 0, false)
+
+	v.app.SetRoot(layout, true).SetInputCapture(v.key)
+
+	return v
+}
+
+// run refreshes the view until the user quits.
+func (v *topView) run() error {
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go v.worker(done)
+
+	return v.app.Run()
+}
+
+// worker fetches the top flows on every interval until done is closed.
+func (v *topView) worker(done chan struct{}) {
+
+	t := time.NewTicker(v.interval)
+	defer t.Stop()
+
+	for {
+		v.refresh()
+
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// refresh fetches the top flows in the order of the sort column, unless paused.
+func (v *topView) refresh() {
+
+	v.mu.Lock()
+	paused, by := v.paused, topColumns[v.column].by
+	v.mu.Unlock()
+
+	if paused {
+		return
+	}
+
+	flows, err := v.client.fetch(by)
+
+	v.app.QueueUpdateDraw(func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		if err != nil {
+			v.status.SetText(fmt.Sprintf("[red]%s", err))
+			return
+		}
+
+		v.flows = flows
+		v.draw()
+	})
+}
+
+// key handles key events, passing unhandled keys on to the table.
+func (v *topView) key(ev *tcell.EventKey) *tcell.EventKey {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	switch ev.Rune() {
+	case 'q':
+		v.app.Stop()
+		return nil
+	case '<':
+		v.column = (v.column + len(topColumns) - 1) % len(topColumns)
+	case '>':
+		v.column = (v.column + 1) % len(topColumns)
+	case 'r':
+		v.reverse = !v.reverse
+	case 'p':
+		v.paused = !v.paused
+	default:
+		return ev
+	}
+
+	v.draw()
+
+	return nil
+}
+
+// draw renders the table and status line. mu must be held.
+func (v *topView) draw() {
+
+	less := topColumns[v.column].less
+	sort.SliceStable(v.flows, func(i, j int) bool {
+		if v.reverse {
+			return less(v.flows[j], v.flows[i])
+		}
+		return less(v.flows[i], v.flows[j])
+	})
+
+	v.table.Clear()
+
+	for c, col := range topColumns {
+		title := col.title
+		if c == v.column {
+			title = "[::r]" + title + "[::-]"
+		}
+		v.table.SetCell(0, c, tview.NewTableCell(title).
+			SetSelectable(false).SetAttributes(tcell.AttrBold).SetExpansion(1))
+	}
+
+	for r, f := range v.flows {
+		for c, col := range topColumns {
+			cell := tview.NewTableCell(tview.Escape(col.value(f))).SetExpansion(1)
+			if c > 2 {
+				cell.SetAlign(tview.AlignRight)
+			}
+			v.table.SetCell(r+1, c, cell)
+		}
+	}
+
+	state := []string{fmt.Sprintf("%d flows", len(v.flows)), "sort: " + topColumns[v.column].title}
+	if v.reverse {
+		state = append(state, "reversed")
+	}
+	if v.paused {
+		state = append(state, "[yellow]paused[-]")
+	}
+	v.status.SetText(strings.Join(state, " | ") + " | < > sort, r reverse, p pause, q quit")
+}
@@ -1,12 +1,15 @@
 package apiserver
 
 import (
+	"net"
 	"net/http"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gorilla/mux"
 	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/pprof"
 )
 
 var (
@@ -15,6 +18,9 @@ var (
 
 	// Whether or not package was successfully initialized
 	initSuccess bool
+
+	// Serve the pprof endpoints under /debug/pprof/.
+	servePProf bool
 )
 
 // Init configures the package with handles to the objects it manipulates.
@@ -32,7 +38,13 @@ func Init(p *pipeline.Pipeline) error {
 	return nil
 }
 
-// Run the HTTP listener.
+// SetPProf enables the pprof profile and execution trace endpoints under
+// /debug/pprof/. Must be called before Run.
+func SetPProf(enable bool) {
+	servePProf = enable
+}
+
+// Run the HTTP listener on addr.
 func Run(addr string) error {
 
 	// Check if the package was properly initialized
@@ -40,18 +52,47 @@ func Run(addr string) error {
 		return errNotInit
 	}
 
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listening for API connections")
+	}
+
+	return Serve(l)
+}
+
+// Serve the API on the given listener, eg. a socket passed by systemd.
+func Serve(l net.Listener) error {
+
+	// Check if the package was properly initialized
+	if !initSuccess {
+		return errNotInit
+	}
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/stats", HandleStats)
+	r.HandleFunc("/flush", HandleFlush).Methods(http.MethodPost)
+	r.HandleFunc("/filter", HandleGetFilter).Methods(http.MethodGet)
+	r.HandleFunc("/filter", HandleSetFilter).Methods(http.MethodPut)
+	r.HandleFunc("/sinks", HandleListSinks).Methods(http.MethodGet)
+	r.HandleFunc("/sinks/{name}", HandleAddSink).Methods(http.MethodPut)
+	r.HandleFunc("/sinks/{name}", HandleRemoveSink).Methods(http.MethodDelete)
+	r.HandleFunc("/top", HandleTop).Methods(http.MethodGet)
+	r.HandleFunc("/flows", HandleFlows).Methods(http.MethodGet)
+	r.HandleFunc("/events", HandleEvents).Methods(http.MethodGet)
+
+	if servePProf {
+		r.PathPrefix("/debug/pprof/").Handler(pprof.Handler())
+	}
 
 	http.Handle("/", r)
 	go func() {
-		if err := http.ListenAndServe(addr, r); err != nil {
+		if err := http.Serve(l, r); err != nil {
 			log.Fatalf("Error in http listener: %s", err)
 		}
 	}()
 
-	log.Infof("API server listening on address '%s'", addr)
+	log.Infof("API server listening on address '%s'", l.Addr())
 
 	return nil
 }
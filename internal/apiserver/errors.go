@@ -5,4 +5,14 @@ import "errors"
 var (
 	errNotInit = errors.New("apiserver package not initialized, call Init() first")
 	errNoPipe  = errors.New("ceci n'est pas une pipe")
+
+	errRuntimeDeadLetter = errors.New("dead-letter sinks can only be set in the configuration file")
+	errRuntimeStandby    = errors.New("standby sinks can only be set in the configuration file")
+	errRuntimeCommand    = errors.New("plugin commands can only be set in the configuration file, use an address")
+	errRuntimePath       = errors.New("sinks writing files can only be set in the configuration file")
+
+	errEventType  = errors.New("event type must be 'update' or 'destroy'")
+	errStreamRate = errors.New("rate must be a positive amount of events per second")
+
+	errStreamOrigin = errors.New("origin not allowed")
 )
@@ -1,7 +1,16 @@
 package apiserver
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
 )
 
 // HandleStats returns statistics about the application.
@@ -9,9 +18,238 @@ func HandleStats(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 
-	write(w, "Pipeline: %v\n", pipe.Stats)
+	write(w, "Pipeline: %v\n", pipe.Stats())
+	write(w, "Probe: %v\n", pipe.ProbeStats())
 
 	for _, s := range pipe.GetSinks() {
 		write(w, "Sink '%s': %v\n", s.Name(), s.Stats())
 	}
 }
+
+// HandleFlush flushes all sinks and waits for their buffered events to be
+// written, or until the client goes away.
+func HandleFlush(w http.ResponseWriter, r *http.Request) {
+
+	if err := pipe.Flush(r.Context()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		write(w, "%s\n", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetFilter returns the pipeline's input filter as a JSON object
+// holding its include and exclude expressions.
+func HandleGetFilter(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(pipe.InputFilter()); err != nil {
+		log.Errorf("Error writing input filter: %s", err)
+	}
+}
+
+// HandleSetFilter replaces the pipeline's input filter with the JSON object in
+// the request body, in the format returned by HandleGetFilter. Omitted
+// expressions are cleared. The filter is replaced when the configuration is
+// reloaded.
+func HandleSetFilter(w http.ResponseWriter, r *http.Request) {
+
+	var f pipeline.InputFilter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "decoding input filter: %s\n", err)
+		return
+	}
+
+	if err := pipe.SetInputFilter(f); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListSinks returns the names of the sinks registered to the pipeline.
+func HandleListSinks(w http.ResponseWriter, r *http.Request) {
+
+	w.WriteHeader(http.StatusOK)
+
+	for _, s := range pipe.GetSinks() {
+		write(w, "%s\n", s.Name())
+	}
+}
+
+// HandleAddSink creates a sink from the JSON-encoded parameters in the request
+// body, in the format of a sink in the configuration file, and registers it
+// to the pipeline. Sinks added at runtime are removed when the configuration
+// is reloaded.
+func HandleAddSink(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["name"]
+
+	var params map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "decoding sink parameters: %s\n", err)
+		return
+	}
+
+	scfg, err := types.DecodeSinkConfigMap(map[string]interface{}{name: params})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+	sc := scfg[0]
+
+	// Dead-letter and standby sinks are resolved across all sinks of the configuration file.
+	if sc.DeadLetter != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", errRuntimeDeadLetter)
+		return
+	}
+	if len(sc.Standby) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", errRuntimeStandby)
+		return
+	}
+
+	// Sinks added at runtime don't run commands or write files, the API
+	// doesn't authenticate its clients.
+	if sc.Command != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", errRuntimeCommand)
+		return
+	}
+	if sc.Path != "" || sc.SpoolDir != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", errRuntimePath)
+		return
+	}
+
+	s, err := sinks.New(sc)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+
+	if err := pipe.RegisterSink(s); err != nil {
+		if cerr := s.Close(); cerr != nil {
+			log.Errorf("Error closing accounting sink '%s': %s", name, cerr)
+		}
+
+		if err == pipeline.ErrSinkExists {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		write(w, "%s\n", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleRemoveSink unregisters a sink from the pipeline and closes it,
+// waiting for its buffered events to be written.
+func HandleRemoveSink(w http.ResponseWriter, r *http.Request) {
+
+	name := mux.Vars(r)["name"]
+
+	s, err := pipe.RemoveSink(name)
+	if err == pipeline.ErrSinkNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		write(w, "%s\n", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		write(w, "%s\n", err)
+		return
+	}
+
+	if err := s.Close(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		write(w, "closing sink: %s\n", err)
+		return
+	}
+
+	log.Infof("Closed accounting sink '%s'", name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTop returns the largest live flows as a JSON array. The amount of
+// flows is set by the 'n' query parameter, defaulting to 10, and their order
+// by 'by', either 'rate' (bytes per second, default) or 'bytes' (total).
+func HandleTop(w http.ResponseWriter, r *http.Request) {
+
+	n := 10
+	if q := r.URL.Query().Get("n"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			write(w, "invalid amount of flows '%s'\n", q)
+			return
+		}
+		n = v
+	}
+
+	flows, err := pipe.Top(n, r.URL.Query().Get("by"))
+	if err == pipeline.ErrTopDisabled {
+		w.WriteHeader(http.StatusNotFound)
+		write(w, "%s\n", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(flows); err != nil {
+		log.Errorf("Error writing top flows: %s", err)
+	}
+}
+
+// HandleFlows returns all live flows as a JSON array, ordered by connection ID.
+// Flows can be filtered by the 'addr' query parameter, an address or network
+// containing the flow's source or destination address, by 'port', its source
+// or destination port, by 'proto', a protocol name or number, and by 'netns',
+// the inode number of the flow's network namespace.
+func HandleFlows(w http.ResponseWriter, r *http.Request) {
+
+	ff, err := flowFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+
+	flows, err := pipe.Flows(ff)
+	if err == pipeline.ErrTopDisabled {
+		w.WriteHeader(http.StatusNotFound)
+		write(w, "%s\n", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		write(w, "%s\n", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(flows); err != nil {
+		log.Errorf("Error writing flows: %s", err)
+	}
+}
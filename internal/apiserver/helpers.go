@@ -4,6 +4,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks/helpers"
 )
 
 // write wraps fmt.Fprintf and calls log.Fatal() on error.
@@ -12,3 +18,52 @@ func write(w io.Writer, format string, a ...interface{}) {
 		log.Fatalf("error writing to http stream: %s", err)
 	}
 }
+
+// flowFilter parses the 'addr', 'port', 'proto' and 'netns' query parameters
+// into a pipeline.FlowFilter.
+func flowFilter(q url.Values) (pipeline.FlowFilter, error) {
+
+	var ff pipeline.FlowFilter
+
+	if s := q.Get("addr"); s != "" {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return ff, fmt.Errorf("invalid address '%s'", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		ff.Net = n
+	}
+
+	if s := q.Get("port"); s != "" {
+		p, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || p == 0 {
+			return ff, fmt.Errorf("invalid port '%s'", s)
+		}
+		ff.Port = uint16(p)
+	}
+
+	if s := q.Get("proto"); s != "" {
+		p, err := helpers.ParseProto(s)
+		if err != nil {
+			return ff, err
+		}
+		ff.Proto = p
+	}
+
+	if s := q.Get("netns"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 {
+			return ff, fmt.Errorf("invalid netns '%s'", s)
+		}
+		ff.NetNS = uint32(n)
+	}
+
+	return ff, nil
+}
@@ -0,0 +1,168 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"golang.org/x/time/rate"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Amount of events buffered for a WebSocket client before dropping them.
+const streamBufferSize = 1024
+
+// Maximum amount of events per second sent to a WebSocket client,
+// unlimited if zero.
+var streamRate = 100
+
+// SetStreamRate sets the maximum amount of events per second sent to each
+// client of the event stream, zero for no limit. Must be called before Run.
+func SetStreamRate(r int) {
+	streamRate = r
+}
+
+// Origins of web pages allowed to open the event stream, eg.
+// 'https://grafana.example.com', or '*' for any origin.
+var streamOrigins []string
+
+// SetStreamOrigins sets the origins of web pages allowed to open the event
+// stream. Browsers are rejected if the list is empty, clients not sending an
+// Origin header are always accepted. Must be called before Run.
+func SetStreamOrigins(origins []string) {
+	streamOrigins = origins
+}
+
+// HandleEvents upgrades the connection to a WebSocket and streams live
+// events to the client as JSON messages. Events can be filtered by the
+// 'addr', 'port', 'proto' and 'netns' query parameters like on /flows, and
+// by 'type', either 'update' or 'destroy'. Events beyond the stream's rate
+// limit, or beyond 'rate' events per second if lower, are dropped.
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+
+	ff, err := flowFilter(q)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", err)
+		return
+	}
+
+	var update, destroy bool
+	switch q.Get("type") {
+	case "":
+		update, destroy = true, true
+	case "update":
+		update = true
+	case "destroy":
+		destroy = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		write(w, "%s\n", errEventType)
+		return
+	}
+
+	limit := streamRate
+	if s := q.Get("rate"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			write(w, "%s\n", errStreamRate)
+			return
+		}
+		if v < limit || limit <= 0 {
+			limit = v
+		}
+	}
+
+	rl := rate.NewLimiter(rate.Limit(limit), limit)
+	if limit <= 0 {
+		rl = rate.NewLimiter(rate.Inf, 0)
+	}
+
+	match := func(e bpf.Event) bool {
+		if e.Destroy && !destroy || !e.Destroy && !update {
+			return false
+		}
+		return ff.Match(e)
+	}
+
+	// Only let dashboards served from allowed origins open the stream, any
+	// web page visited from a machine reaching the API could otherwise.
+	websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			streamEvents(ws, match, rl)
+		},
+	}.ServeHTTP(w, r)
+}
+
+// checkOrigin rejects WebSocket handshakes from web pages served from
+// origins not in streamOrigins.
+func checkOrigin(cfg *websocket.Config, r *http.Request) error {
+
+	// Browsers always send an Origin, other clients don't need to.
+	o := r.Header.Get("Origin")
+	if o == "" {
+		return nil
+	}
+
+	for _, a := range streamOrigins {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), o) {
+			var err error
+			cfg.Origin, err = websocket.Origin(cfg, r)
+			return err
+		}
+	}
+
+	log.Debugf("Rejected event stream from '%s', origin '%s' not allowed", r.RemoteAddr, o)
+
+	return errStreamOrigin
+}
+
+// streamEvents sends the events matching match to a WebSocket client
+// until it goes away, dropping events not allowed by the limiter.
+func streamEvents(ws *websocket.Conn, match func(bpf.Event) bool, rl *rate.Limiter) {
+
+	defer ws.Close()
+
+	t := pipe.Tap(streamBufferSize, match)
+	defer t.Close()
+
+	// Detect the client going away, it isn't expected to send anything.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		var b [64]byte
+		for {
+			if _, err := ws.Read(b[:]); err != nil {
+				return
+			}
+		}
+	}()
+
+	var limited uint64
+	defer func() {
+		log.Debugf("Event stream to '%s' closed, %d events dropped by the rate limit, %d by the buffer",
+			ws.Request().RemoteAddr, limited, t.Dropped())
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case e := <-t.C:
+			if !rl.Allow() {
+				limited++
+				continue
+			}
+			if err := websocket.Message.Send(ws, string(e.AppendJSON(nil, false))); err != nil {
+				return
+			}
+		}
+	}
+}
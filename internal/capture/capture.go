@@ -0,0 +1,173 @@
+// Package capture implements a binary file format for recording streams of
+// accounting events, to be replayed through the pipeline later on.
+//
+// A capture starts with a header holding a magic string, the format version,
+// a byte order marker and the boot time of the recording machine. Every event
+// is stored in a frame holding a flags byte, the time since the start of the
+// capture in nanoseconds and the event in the binary format sent by the probe.
+// Events are stored in the recording machine's native byte order.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+	"unsafe"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	magic   = "CTRACCT\x00"
+	version = 3
+
+	headerLength = len(magic) + 2 + 2 + 8
+	frameLength  = 1 + 8 + bpf.EventLength
+
+	// Length of the frames of version 1 captures, recorded before the
+	// wall clock time stamp was added to events.
+	frameLengthV1 = frameLength - 16
+
+	// Length of the frames of version 2 captures, recorded before
+	// sequence numbers were added to events.
+	frameLengthV2 = frameLength - 8
+
+	byteOrderMark uint16 = 0x0102
+)
+
+// Frame flags.
+const (
+	flagDestroy uint8 = 1 << iota
+)
+
+// Frame is an event read from a capture.
+type Frame struct {
+	// Time between the start of the capture and the event being recorded.
+	Offset time.Duration
+
+	// The recorded event. Destroy is set on destroy events.
+	Event bpf.Event
+}
+
+// Writer writes events to a capture.
+type Writer struct {
+	w     *bufio.Writer
+	start time.Time
+}
+
+// NewWriter writes a capture header to w and returns a Writer appending
+// events to it. bootTime is the machine's boot time, used to convert the
+// events' time stamps when replaying on another boot.
+func NewWriter(w io.Writer, bootTime time.Time) (*Writer, error) {
+
+	bw := bufio.NewWriter(w)
+
+	h := make([]byte, headerLength)
+	copy(h, magic)
+	binary.BigEndian.PutUint16(h[8:10], version)
+	*(*uint16)(unsafe.Pointer(&h[10])) = byteOrderMark
+	binary.BigEndian.PutUint64(h[12:20], uint64(bootTime.UnixNano()))
+
+	if _, err := bw.Write(h); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: bw, start: time.Now()}, nil
+}
+
+// Write buffers an event to be written to the capture. Not safe for concurrent use.
+func (w *Writer) Write(e bpf.Event) error {
+
+	b, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var f [9]byte
+	if e.Destroy {
+		f[0] |= flagDestroy
+	}
+	binary.BigEndian.PutUint64(f[1:], uint64(time.Since(w.start)))
+
+	if _, err := w.w.Write(f[:]); err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+
+	return err
+}
+
+// Flush writes all buffered events to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads events from a capture.
+type Reader struct {
+	r        *bufio.Reader
+	bootTime time.Time
+	buf      []byte
+}
+
+// NewReader reads a capture header from r and returns a Reader
+// for the capture's events.
+func NewReader(r io.Reader) (*Reader, error) {
+
+	br := bufio.NewReader(r)
+
+	h := make([]byte, headerLength)
+	if _, err := io.ReadFull(br, h); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errMagic
+		}
+		return nil, err
+	}
+
+	if string(h[:8]) != magic {
+		return nil, errMagic
+	}
+	fl := frameLength
+	switch binary.BigEndian.Uint16(h[8:10]) {
+	case version:
+	case 1:
+		fl = frameLengthV1
+	case 2:
+		fl = frameLengthV2
+	default:
+		return nil, errVersion
+	}
+	if *(*uint16)(unsafe.Pointer(&h[10])) != byteOrderMark {
+		return nil, errByteOrder
+	}
+
+	return &Reader{
+		r:        br,
+		bootTime: time.Unix(0, int64(binary.BigEndian.Uint64(h[12:20]))),
+		buf:      make([]byte, fl),
+	}, nil
+}
+
+// BootTime returns the boot time of the machine the capture was recorded on.
+func (r *Reader) BootTime() time.Time {
+	return r.bootTime
+}
+
+// Next reads the next frame of the capture. Returns io.EOF at the end of
+// the capture and io.ErrUnexpectedEOF if the last frame is incomplete,
+// eg. when the recording process crashed.
+func (r *Reader) Next() (Frame, error) {
+
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return Frame{}, err
+	}
+
+	var f Frame
+	if err := f.Event.UnmarshalBinary(r.buf[9:]); err != nil {
+		return Frame{}, err
+	}
+	f.Event.Destroy = r.buf[0]&flagDestroy != 0
+	f.Offset = time.Duration(binary.BigEndian.Uint64(r.buf[1:9]))
+
+	return f, nil
+}
@@ -0,0 +1,71 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestCapture(t *testing.T) {
+
+	var buf bytes.Buffer
+	boot := time.Unix(1500000000, 0)
+
+	w, err := NewWriter(&buf, boot)
+	require.NoError(t, err)
+
+	in := []bpf.Event{
+		{ConnectionID: 1, SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2), Family: bpf.FamilyIPv4, BytesOrig: 100},
+		{ConnectionID: 1, SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2), Family: bpf.FamilyIPv4, BytesOrig: 200, Destroy: true},
+	}
+	for _, e := range in {
+		require.NoError(t, w.Write(e))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	assert.True(t, boot.Equal(r.BootTime()))
+
+	for _, e := range in {
+		f, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, e.String(), f.Event.String())
+	}
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// Version 1 captures hold events without a wall clock time stamp,
+	// version 2 captures events without sequence numbers.
+	b, err := in[0].MarshalBinary()
+	require.NoError(t, err)
+	for v, n := range map[uint16]int{1: bpf.EventLength - 16, 2: bpf.EventLength - 8} {
+		old := make([]byte, headerLength)
+		copy(old, magic)
+		binary.BigEndian.PutUint16(old[8:10], v)
+		*(*uint16)(unsafe.Pointer(&old[10])) = byteOrderMark
+		old = append(old, make([]byte, 9)...)
+		old = append(old, b[:n]...)
+
+		r, err = NewReader(bytes.NewReader(old))
+		require.NoError(t, err)
+		f, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, in[0].String(), f.Event.String())
+		_, err = r.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+
+	_, err = NewReader(bytes.NewReader([]byte("bogus")))
+	assert.Equal(t, errMagic, err)
+}
@@ -0,0 +1,9 @@
+package capture
+
+import "errors"
+
+var (
+	errMagic     = errors.New("not a conntracct capture")
+	errVersion   = errors.New("unsupported capture version")
+	errByteOrder = errors.New("capture was recorded on a machine with a different byte order")
+)
@@ -3,6 +3,7 @@ package config
 import "github.com/ti-mo/conntracct/pkg/bpf"
 
 // Init sets up the host to make conntracct function correctly.
-func Init() error {
+// The returned function undoes the changes made to the host.
+func Init() (func() error, error) {
 	return bpf.Sysctls(true)
 }
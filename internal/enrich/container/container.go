@@ -0,0 +1,170 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultRefresh  = 10 * time.Second
+	defaultProcPath = "/proc"
+
+	// Maximum duration of a single listing of the runtime's containers.
+	listTimeout = 5 * time.Second
+)
+
+// Runtime is a container runtime that can be queried for running containers.
+type Runtime interface {
+	Containers(context.Context) ([]Container, error)
+	Close() error
+}
+
+// Container holds the metadata of a running container.
+type Container struct {
+	ID    string
+	Name  string
+	Image string
+
+	// PID of the container's init process.
+	PID int
+}
+
+// Config is the configuration of the container enricher.
+type Config struct {
+	// Interval at which the runtime is queried for containers.
+	Refresh time.Duration
+
+	// Path of the host's procfs, used to resolve container PIDs
+	// to network namespaces.
+	ProcPath string
+}
+
+// Enricher attaches the metadata of the container owning an event's network
+// namespace. Network namespaces are resolved by periodically listing the
+// runtime's containers and looking up the namespace of their init process.
+type Enricher struct {
+	rt  Runtime
+	cfg Config
+
+	// map[uint32]Container, keyed by netns inode number.
+	netns atomic.Value
+
+	stop chan struct{}
+}
+
+// New returns an Enricher resolving network namespaces to containers of the
+// given Runtime. Performs an initial listing of containers before returning.
+func New(rt Runtime, cfg Config) (*Enricher, error) {
+
+	if cfg.Refresh == 0 {
+		cfg.Refresh = defaultRefresh
+	}
+	if cfg.ProcPath == "" {
+		cfg.ProcPath = defaultProcPath
+	}
+
+	e := Enricher{
+		rt:   rt,
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+
+	if err := e.refresh(); err != nil {
+		return nil, err
+	}
+
+	go e.refreshWorker()
+
+	return &e, nil
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "container"
+}
+
+// Enrich tags the event with the metadata of the container
+// owning the event's network namespace.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+
+	c, ok := e.netns.Load().(map[uint32]Container)[ev.NetNS]
+	if !ok {
+		return
+	}
+
+	ev.SetTag("container_id", c.ID)
+	ev.SetTag("container_name", c.Name)
+	ev.SetTag("container_image", c.Image)
+}
+
+// Close stops refreshing and closes the runtime connection.
+func (e *Enricher) Close() error {
+	close(e.stop)
+	return e.rt.Close()
+}
+
+// refresh lists the runtime's containers and replaces the enricher's
+// netns lookup table.
+func (e *Enricher) refresh() error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	cs, err := e.rt.Containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	m := make(map[uint32]Container, len(cs))
+	for _, c := range cs {
+		ino, err := netnsInode(e.cfg.ProcPath, c.PID)
+		if err != nil {
+			// Container exited since it was listed.
+			continue
+		}
+
+		m[ino] = c
+	}
+
+	e.netns.Store(m)
+
+	return nil
+}
+
+// refreshWorker refreshes the lookup table until the enricher is closed.
+func (e *Enricher) refreshWorker() {
+
+	t := time.NewTicker(e.cfg.Refresh)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := e.refresh(); err != nil {
+				log.Warnf("Container enricher: error listing containers: %s", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// netnsInode returns the inode number of the network namespace of pid.
+func netnsInode(proc string, pid int) (uint32, error) {
+
+	fi, err := os.Stat(path.Join(proc, fmt.Sprint(pid), "ns", "net"))
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(fi.Sys().(*syscall.Stat_t).Ino), nil
+}
@@ -0,0 +1,44 @@
+package container
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+type fakeRuntime []Container
+
+func (f fakeRuntime) Containers(context.Context) ([]Container, error) { return f, nil }
+func (f fakeRuntime) Close() error                                    { return nil }
+
+func TestEnrich(t *testing.T) {
+
+	// The test process stands in for a container's init process.
+	ino, err := netnsInode(defaultProcPath, os.Getpid())
+	require.NoError(t, err)
+
+	e, err := New(fakeRuntime{
+		{ID: "abc", Name: "web", Image: "nginx:latest", PID: os.Getpid()},
+		{ID: "gone", PID: -1},
+	}, Config{})
+	require.NoError(t, err)
+	defer e.Close()
+
+	ev := bpf.Event{NetNS: ino}
+	e.Enrich(&ev)
+
+	assert.Equal(t, map[string]string{
+		"container_id":    "abc",
+		"container_name":  "web",
+		"container_image": "nginx:latest",
+	}, ev.Tags)
+
+	ev = bpf.Event{NetNS: ino + 1}
+	e.Enrich(&ev)
+	assert.Nil(t, ev.Tags)
+}
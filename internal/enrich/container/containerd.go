@@ -0,0 +1,85 @@
+package container
+
+import (
+	"context"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+)
+
+const (
+	defaultContainerdSocket    = "/run/containerd/containerd.sock"
+	defaultContainerdNamespace = "k8s.io"
+
+	// Label holding the container name of containers created by the CRI plugin.
+	labelCRIContainerName = "io.kubernetes.container.name"
+)
+
+// Containerd is a Runtime querying containerd's API over its unix socket.
+type Containerd struct {
+	client    *containerd.Client
+	namespace string
+}
+
+// NewContainerd returns a Containerd runtime connecting to the given socket,
+// listing containers in the given namespace. Uses defaults for empty arguments.
+func NewContainerd(socket, namespace string) (*Containerd, error) {
+
+	if socket == "" {
+		socket = defaultContainerdSocket
+	}
+	if namespace == "" {
+		namespace = defaultContainerdNamespace
+	}
+
+	c, err := containerd.New(socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Containerd{client: c, namespace: namespace}, nil
+}
+
+// Containers returns the containers with a running task known to containerd.
+func (c *Containerd) Containers(ctx context.Context) ([]Container, error) {
+
+	ctx = namespaces.WithNamespace(ctx, c.namespace)
+
+	cs, err := c.client.Containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Container, 0, len(cs))
+	for _, ct := range cs {
+		t, err := ct.Task(ctx, nil)
+		if err != nil {
+			// Container has no running task.
+			continue
+		}
+
+		info, err := ct.Info(ctx)
+		if err != nil {
+			continue
+		}
+
+		name := info.Labels[labelCRIContainerName]
+		if name == "" {
+			name = info.ID
+		}
+
+		out = append(out, Container{
+			ID:    info.ID,
+			Name:  name,
+			Image: info.Image,
+			PID:   int(t.Pid()),
+		})
+	}
+
+	return out, nil
+}
+
+// Close closes the connection to containerd.
+func (c *Containerd) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,108 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// Docker is a Runtime querying the Docker Engine API over its unix socket.
+type Docker struct {
+	client *http.Client
+}
+
+// dockerInspect holds the fields of a container inspect response
+// used by the enricher.
+type dockerInspect struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+	State struct {
+		Pid int `json:"Pid"`
+	} `json:"State"`
+}
+
+// NewDocker returns a Docker runtime connecting to the given socket,
+// or the default socket if empty.
+func NewDocker(socket string) *Docker {
+
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+
+	return &Docker{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Containers returns the running containers known to Docker.
+func (d *Docker) Containers(ctx context.Context) ([]Container, error) {
+
+	var list []struct {
+		ID string `json:"Id"`
+	}
+	if err := d.get(ctx, "/containers/json", &list); err != nil {
+		return nil, err
+	}
+
+	out := make([]Container, 0, len(list))
+	for _, l := range list {
+		// The list endpoint doesn't return PIDs, inspect every container.
+		var c dockerInspect
+		if err := d.get(ctx, "/containers/"+l.ID+"/json", &c); err != nil {
+			// Container was removed since it was listed.
+			continue
+		}
+
+		out = append(out, Container{
+			ID:    c.ID,
+			Name:  strings.TrimPrefix(c.Name, "/"),
+			Image: c.Config.Image,
+			PID:   c.State.Pid,
+		})
+	}
+
+	return out, nil
+}
+
+// Close releases the idle connections to the Docker socket.
+func (d *Docker) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// get decodes the JSON response of a GET request to the Docker API into v.
+func (d *Docker) get(ctx context.Context, path string, v interface{}) error {
+
+	// Host is ignored, requests are sent over the unix socket.
+	req, err := http.NewRequest(http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(errFmtStatus, path, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
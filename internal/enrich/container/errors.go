@@ -0,0 +1,5 @@
+package container
+
+const (
+	errFmtStatus = "docker api %s: unexpected response status %d"
+)
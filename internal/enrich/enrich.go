@@ -0,0 +1,19 @@
+package enrich
+
+import "github.com/ti-mo/conntracct/pkg/bpf"
+
+// An Enricher attaches metadata to accounting events
+// before they are delivered to sinks.
+type Enricher interface {
+
+	// Get the enricher's name.
+	Name() string
+
+	// Attach metadata to an event as tags, using Event.SetTag.
+	// Implementation MUST be thread-safe and should not block,
+	// it is called in the pipeline's hot path.
+	Enrich(*bpf.Event)
+
+	// Release the enricher's resources.
+	Close() error
+}
@@ -0,0 +1,120 @@
+package flowid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Flows that haven't been seen for this long are forgotten, in case their
+// destroy event was lost. Checked at most once every sweepInterval.
+const (
+	flowTimeout   = time.Hour
+	sweepInterval = time.Minute
+)
+
+// flow is a live flow known to the Enricher.
+type flow struct {
+	id string
+
+	// Timestamp and counters of the flow's most recent event.
+	ts                  uint64
+	bytesOrig, bytesRet uint64
+}
+
+// Enricher tags events with a unique identifier of their flow, as flow_id.
+// Conntrack reuses connection IDs soon after a flow is destroyed, the flow
+// ID tells apart flows sharing a connection ID.
+//
+// A flow is assigned a random UUID on its first event and forgets it after
+// its destroy event. Counters going backwards also mean the connection ID
+// was reused.
+type Enricher struct {
+	mu        sync.Mutex
+	flows     map[uint32]flow
+	lastSweep uint64
+}
+
+// New returns an Enricher assigning IDs to flows.
+func New() *Enricher {
+	return &Enricher{flows: make(map[uint32]flow)}
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "flowid"
+}
+
+// Enrich tags the event with the ID of its flow.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.flows[ev.ConnectionID]
+	if !ok || ev.BytesOrig < f.bytesOrig || ev.BytesRet < f.bytesRet {
+		f = flow{id: newID()}
+	}
+
+	ev.SetTag("flow_id", f.id)
+
+	if ev.Destroy {
+		delete(e.flows, ev.ConnectionID)
+		return
+	}
+
+	f.ts, f.bytesOrig, f.bytesRet = ev.Timestamp, ev.BytesOrig, ev.BytesRet
+	e.flows[ev.ConnectionID] = f
+
+	e.sweep(ev.Timestamp)
+}
+
+// Close releases the enricher's flow table.
+func (e *Enricher) Close() error {
+
+	e.mu.Lock()
+	e.flows = make(map[uint32]flow)
+	e.mu.Unlock()
+
+	return nil
+}
+
+// sweep forgets flows that have been idle for longer than flowTimeout.
+// mu must be held.
+func (e *Enricher) sweep(now uint64) {
+
+	if now-e.lastSweep < uint64(sweepInterval) {
+		return
+	}
+	e.lastSweep = now
+
+	for id, f := range e.flows {
+		if now-f.ts > uint64(flowTimeout) {
+			delete(e.flows, id)
+		}
+	}
+}
+
+// newID returns a random (version 4) UUID.
+func newID() string {
+
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	hex.Encode(b[9:13], u[4:6])
+	hex.Encode(b[14:18], u[6:8])
+	hex.Encode(b[19:23], u[8:10])
+	hex.Encode(b[24:], u[10:])
+	b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+
+	return string(b[:])
+}
@@ -0,0 +1,36 @@
+package flowid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	e := New()
+
+	enrich := func(ev bpf.Event) string {
+		e.Enrich(&ev)
+		return ev.Tags["flow_id"]
+	}
+
+	id := enrich(bpf.Event{ConnectionID: 1, Timestamp: uint64(time.Second), BytesOrig: 100})
+	require.Len(t, id, 36)
+	assert.Equal(t, byte('4'), id[14])
+
+	assert.Equal(t, id, enrich(bpf.Event{ConnectionID: 1, Timestamp: uint64(2 * time.Second), BytesOrig: 200}))
+	assert.NotEqual(t, id, enrich(bpf.Event{ConnectionID: 2, BytesOrig: 200}))
+
+	// Decreasing counters mean a new flow with the same connection ID.
+	reused := enrich(bpf.Event{ConnectionID: 1, Timestamp: uint64(3 * time.Second), BytesOrig: 10})
+	assert.NotEqual(t, id, reused)
+
+	// The flow is forgotten after its destroy event.
+	assert.Equal(t, reused, enrich(bpf.Event{ConnectionID: 1, BytesOrig: 20, Destroy: true}))
+	assert.NotEqual(t, reused, enrich(bpf.Event{ConnectionID: 1, Timestamp: uint64(4 * time.Second), BytesOrig: 30}))
+}
@@ -0,0 +1,121 @@
+package iface
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Enricher attaches the name of the interface an event's packet was seen on.
+// Interface names are kept up to date by subscribing to netlink link updates.
+//
+// Interface indices are local to a network namespace, so only events in the
+// network namespace conntracct is running in are tagged.
+type Enricher struct {
+	// Inode number of the network namespace links are resolved in.
+	netns uint32
+
+	// map[uint32]string, keyed by ifindex. Replaced on every link update.
+	mu    sync.Mutex
+	names atomic.Value
+
+	done chan struct{}
+}
+
+// New returns an Enricher resolving interface indices in the current
+// network namespace.
+func New() (*Enricher, error) {
+
+	fi, err := os.Stat("/proc/self/ns/net")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting network namespace")
+	}
+
+	e := Enricher{
+		netns: uint32(fi.Sys().(*syscall.Stat_t).Ino),
+		done:  make(chan struct{}),
+	}
+
+	// Subscribe before listing links to not miss any updates in between.
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(updates, e.done); err != nil {
+		return nil, errors.Wrap(err, "subscribing to link updates")
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		close(e.done)
+		return nil, errors.Wrap(err, "listing links")
+	}
+
+	names := make(map[uint32]string, len(links))
+	for _, l := range links {
+		names[uint32(l.Attrs().Index)] = l.Attrs().Name
+	}
+	e.names.Store(names)
+
+	go e.updateWorker(updates)
+
+	return &e, nil
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "interface"
+}
+
+// Enrich tags the event with the name of its interface.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+
+	if ev.Ifindex == 0 || ev.NetNS != e.netns {
+		return
+	}
+
+	if n, ok := e.names.Load().(map[uint32]string)[ev.Ifindex]; ok {
+		ev.SetTag("interface", n)
+	}
+}
+
+// Close stops receiving link updates.
+func (e *Enricher) Close() error {
+	close(e.done)
+	return nil
+}
+
+// updateWorker applies link updates to the enricher's name table
+// until the update channel is closed.
+func (e *Enricher) updateWorker(updates chan netlink.LinkUpdate) {
+	for u := range updates {
+		e.update(u.Header.Type, uint32(u.Attrs().Index), u.Attrs().Name)
+	}
+}
+
+// update replaces the name table with a copy containing the given change.
+func (e *Enricher) update(typ uint16, idx uint32, name string) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	old := e.names.Load().(map[uint32]string)
+
+	names := make(map[uint32]string, len(old)+1)
+	for k, v := range old {
+		names[k] = v
+	}
+
+	switch typ {
+	case unix.RTM_NEWLINK:
+		names[idx] = name
+	case unix.RTM_DELLINK:
+		delete(names, idx)
+	}
+
+	e.names.Store(names)
+}
@@ -0,0 +1,33 @@
+package iface
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	e := Enricher{netns: 1}
+	e.names.Store(map[uint32]string{1: "lo"})
+
+	// Interface renamed after a flap.
+	e.update(unix.RTM_NEWLINK, 2, "wan0")
+	e.update(unix.RTM_DELLINK, 1, "lo")
+
+	ev := bpf.Event{NetNS: 1, Ifindex: 2}
+	e.Enrich(&ev)
+	assert.Equal(t, "wan0", ev.Tags["interface"])
+
+	// Indices in other network namespaces are not resolved.
+	ev = bpf.Event{NetNS: 2, Ifindex: 2}
+	e.Enrich(&ev)
+	assert.Nil(t, ev.Tags)
+
+	ev = bpf.Event{NetNS: 1, Ifindex: 1}
+	e.Enrich(&ev)
+	assert.Nil(t, ev.Tags)
+}
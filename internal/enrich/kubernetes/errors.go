@@ -0,0 +1,7 @@
+package kubernetes
+
+import "errors"
+
+var (
+	errSyncTimeout = errors.New("timed out waiting for initial list of pods")
+)
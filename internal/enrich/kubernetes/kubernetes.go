@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	// Name of the pod informer's IP address index.
+	indexPodIP = "ip"
+
+	// Interval at which the informer re-lists all pods.
+	resyncPeriod = 10 * time.Minute
+
+	// Maximum time to wait for the initial list of pods.
+	syncTimeout = time.Minute
+)
+
+// Config is the configuration of the Kubernetes enricher.
+type Config struct {
+	// Path to a kubeconfig file. Uses the in-cluster configuration when empty.
+	Kubeconfig string
+
+	// Only watch pods scheduled on this node when non-empty.
+	Node string
+
+	// Pod labels attached to events as tags.
+	Labels []string
+}
+
+// Enricher attaches the name, namespace and labels of the pods owning
+// an event's source and destination addresses.
+type Enricher struct {
+	labels []string
+
+	indexer cache.Indexer
+	stop    chan struct{}
+}
+
+// New returns an Enricher watching pods through the Kubernetes API.
+// Blocks until the initial list of pods is received.
+func New(cfg Config) (*Enricher, error) {
+
+	rc, err := restConfig(cfg.Kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading kubernetes client configuration")
+	}
+
+	cs, err := kubernetes.NewForConfig(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []informers.SharedInformerOption
+	if cfg.Node != "" {
+		sel := fields.OneTermEqualSelector("spec.nodeName", cfg.Node).String()
+		opts = append(opts, informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = sel
+		}))
+	}
+
+	f := informers.NewSharedInformerFactoryWithOptions(cs, resyncPeriod, opts...)
+	inf := f.Core().V1().Pods().Informer()
+	if err := inf.AddIndexers(cache.Indexers{indexPodIP: podIPs}); err != nil {
+		return nil, err
+	}
+
+	e := Enricher{
+		labels:  cfg.Labels,
+		indexer: inf.GetIndexer(),
+		stop:    make(chan struct{}),
+	}
+
+	f.Start(e.stop)
+
+	// Give up waiting for the initial sync after a while.
+	timeout := time.AfterFunc(syncTimeout, func() { e.Close() })
+	defer timeout.Stop()
+
+	if !cache.WaitForCacheSync(e.stop, inf.HasSynced) {
+		return nil, errSyncTimeout
+	}
+
+	return &e, nil
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "kubernetes"
+}
+
+// Enrich tags the event with the metadata of the pods holding its
+// source and destination addresses.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+	e.tagPod(ev, "src_", ev.SrcAddr.String())
+	e.tagPod(ev, "dst_", ev.DstAddr.String())
+}
+
+// Close stops watching the Kubernetes API. Safe to call multiple times.
+func (e *Enricher) Close() error {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+
+	return nil
+}
+
+// tagPod sets tags with the given prefix on the event
+// if a pod with the given IP address is known.
+func (e *Enricher) tagPod(ev *bpf.Event, prefix, ip string) {
+
+	objs, err := e.indexer.ByIndex(indexPodIP, ip)
+	if err != nil || len(objs) == 0 {
+		return
+	}
+
+	// Addresses of finished pods can be reused by running pods,
+	// prefer pods that are running.
+	pod := objs[0].(*corev1.Pod)
+	for _, o := range objs[1:] {
+		if p := o.(*corev1.Pod); p.Status.Phase == corev1.PodRunning {
+			pod = p
+			break
+		}
+	}
+
+	ev.SetTag(prefix+"pod", pod.Name)
+	ev.SetTag(prefix+"namespace", pod.Namespace)
+
+	for _, l := range e.labels {
+		if v, ok := pod.Labels[l]; ok {
+			ev.SetTag(prefix+"label_"+l, v)
+		}
+	}
+}
+
+// podIPs is an index function returning the IP addresses of a pod.
+// Pods in the host's network namespace are not indexed, their address
+// belongs to the node.
+func podIPs(obj interface{}) ([]string, error) {
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+
+	ips := make([]string, 0, len(pod.Status.PodIPs))
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+
+	return ips, nil
+}
+
+// restConfig loads a kubeconfig file, or the in-cluster configuration
+// if path is empty.
+func restConfig(path string) (*rest.Config, error) {
+	if path == "" {
+		return rest.InClusterConfig()
+	}
+
+	return clientcmd.BuildConfigFromFlags("", path)
+}
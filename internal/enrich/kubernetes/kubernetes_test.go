@@ -0,0 +1,42 @@
+package kubernetes
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	idx := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{indexPodIP: podIPs})
+
+	pod := func(ns, name, ip string, phase corev1.PodPhase, hostNet bool) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: map[string]string{"app": name}},
+			Spec:       corev1.PodSpec{HostNetwork: hostNet},
+			Status:     corev1.PodStatus{Phase: phase, PodIPs: []corev1.PodIP{{IP: ip}}},
+		}
+	}
+
+	require.NoError(t, idx.Add(pod("default", "done", "10.0.0.1", corev1.PodSucceeded, false)))
+	require.NoError(t, idx.Add(pod("default", "web", "10.0.0.1", corev1.PodRunning, false)))
+	require.NoError(t, idx.Add(pod("kube-system", "proxy", "10.0.0.2", corev1.PodRunning, true)))
+
+	e := Enricher{labels: []string{"app"}, indexer: idx}
+
+	ev := bpf.Event{SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2)}
+	e.Enrich(&ev)
+
+	assert.Equal(t, map[string]string{
+		"src_pod":       "web",
+		"src_namespace": "default",
+		"src_label_app": "web",
+	}, ev.Tags, "running pod preferred, host network pods ignored")
+}
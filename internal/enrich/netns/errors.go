@@ -0,0 +1,5 @@
+package netns
+
+const (
+	errFmtUnknown = "unknown network namespace '%s'"
+)
@@ -0,0 +1,206 @@
+package netns
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// HostName is the name of the network namespace conntracct is running in.
+const HostName = "host"
+
+const (
+	defaultDir      = "/var/run/netns"
+	defaultRefresh  = 10 * time.Second
+	defaultProcPath = "/proc"
+)
+
+// Config is the configuration of the network namespace enricher.
+type Config struct {
+	// Directory holding the named network namespaces, like those
+	// created by 'ip netns add'.
+	Dir string
+
+	// Interval at which Dir is scanned for namespaces.
+	Refresh time.Duration
+
+	// Path of the procfs to look up conntracct's own namespace in.
+	ProcPath string
+}
+
+func (cfg *Config) defaults() {
+	if cfg.Dir == "" {
+		cfg.Dir = defaultDir
+	}
+	if cfg.Refresh == 0 {
+		cfg.Refresh = defaultRefresh
+	}
+	if cfg.ProcPath == "" {
+		cfg.ProcPath = defaultProcPath
+	}
+}
+
+// Enricher attaches the name of an event's network namespace, as netns_name.
+// Names are resolved by periodically scanning the directory of named
+// namespaces. conntracct's own namespace is named HostName, unless it's
+// named in the directory.
+type Enricher struct {
+	cfg Config
+
+	// map[uint32]string, keyed by inode number. Replaced on every scan.
+	names atomic.Value
+
+	stop chan struct{}
+}
+
+// New returns an Enricher resolving the namespaces in the configured
+// directory. Performs an initial scan before returning.
+func New(cfg Config) (*Enricher, error) {
+
+	cfg.defaults()
+
+	names, err := scan(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := Enricher{cfg: cfg, stop: make(chan struct{})}
+	e.names.Store(names)
+
+	go e.refreshWorker()
+
+	return &e, nil
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "netns"
+}
+
+// Enrich tags the event with the name of its network namespace.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+	if n, ok := e.names.Load().(map[uint32]string)[ev.NetNS]; ok {
+		ev.SetTag("netns_name", n)
+	}
+}
+
+// Close stops scanning for namespaces.
+func (e *Enricher) Close() error {
+	close(e.stop)
+	return nil
+}
+
+// refreshWorker rescans the namespace directory until the enricher is closed.
+func (e *Enricher) refreshWorker() {
+
+	t := time.NewTicker(e.cfg.Refresh)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			names, err := scan(e.cfg)
+			if err != nil {
+				log.Warnf("Netns enricher: error scanning namespaces: %s", err)
+				continue
+			}
+			e.names.Store(names)
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Resolve returns the inode numbers of the given network namespaces, for
+// filtering events by namespace. Namespaces are given by their name in the
+// configured directory, HostName, or their inode number.
+func Resolve(cfg Config, names []string) ([]uint32, error) {
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cfg.defaults()
+
+	m, err := scan(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := make(map[string]uint32, len(m))
+	for ino, n := range m {
+		inodes[n] = ino
+	}
+	if _, ok := inodes[HostName]; !ok {
+		// The host namespace can also be named in the directory.
+		if ino, err := inode(path.Join(cfg.ProcPath, "self", "ns", "net")); err == nil {
+			inodes[HostName] = ino
+		}
+	}
+
+	out := make([]uint32, 0, len(names))
+	for _, n := range names {
+		if ino, ok := inodes[n]; ok {
+			out = append(out, ino)
+			continue
+		}
+		ino, err := strconv.ParseUint(n, 10, 32)
+		if err != nil {
+			return nil, errors.Errorf(errFmtUnknown, n)
+		}
+		out = append(out, uint32(ino))
+	}
+
+	return out, nil
+}
+
+// scan returns the names of the namespaces in the configured directory and
+// of conntracct's own namespace, keyed by inode number. A missing directory
+// holds no namespaces.
+func scan(cfg Config) (map[uint32]string, error) {
+
+	host, err := inode(path.Join(cfg.ProcPath, "self", "ns", "net"))
+	if err != nil {
+		return nil, errors.Wrap(err, "getting network namespace")
+	}
+
+	names := map[uint32]string{host: HostName}
+
+	des, err := os.ReadDir(cfg.Dir)
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "listing network namespaces")
+	}
+
+	for _, de := range des {
+		// Namespaces can be deleted while scanning.
+		ino, err := inode(path.Join(cfg.Dir, de.Name()))
+		if err != nil {
+			continue
+		}
+		names[ino] = de.Name()
+	}
+
+	return names, nil
+}
+
+// inode returns the inode number of the file at the given path.
+func inode(p string) (uint32, error) {
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(fi.Sys().(*syscall.Stat_t).Ino), nil
+}
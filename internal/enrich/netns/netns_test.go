@@ -0,0 +1,53 @@
+package netns
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	// Regular files stand in for the namespaces' bind mounts.
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "blue"), nil, 0o644))
+
+	blue, err := inode(path.Join(dir, "blue"))
+	require.NoError(t, err)
+	host, err := inode("/proc/self/ns/net")
+	require.NoError(t, err)
+
+	e, err := New(Config{Dir: dir})
+	require.NoError(t, err)
+	defer e.Close()
+
+	ev := bpf.Event{NetNS: blue}
+	e.Enrich(&ev)
+	assert.Equal(t, "blue", ev.Tags["netns_name"])
+
+	ev = bpf.Event{NetNS: host}
+	e.Enrich(&ev)
+	assert.Equal(t, HostName, ev.Tags["netns_name"])
+
+	ev = bpf.Event{NetNS: 1}
+	e.Enrich(&ev)
+	assert.Nil(t, ev.Tags)
+
+	inodes, err := Resolve(Config{Dir: dir}, []string{"blue", HostName, "42"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{blue, host, 42}, inodes)
+
+	_, err = Resolve(Config{Dir: dir}, []string{"red"})
+	assert.EqualError(t, err, "unknown network namespace 'red'")
+
+	// A missing directory only holds the host namespace.
+	inodes, err = Resolve(Config{Dir: path.Join(dir, "missing")}, []string{HostName, strconv.Itoa(int(blue))})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{host, blue}, inodes)
+}
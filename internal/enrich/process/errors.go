@@ -0,0 +1,6 @@
+package process
+
+const (
+	errFmtLine     = "malformed socket table line '%s'"
+	errFmtEndpoint = "malformed socket table endpoint '%s'"
+)
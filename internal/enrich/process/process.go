@@ -0,0 +1,370 @@
+package process
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultRefresh  = 5 * time.Second
+	defaultProcPath = "/proc"
+
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// Socket tables in procfs and the protocol of their sockets.
+var socketTables = []struct {
+	name  string
+	proto uint8
+}{
+	{"tcp", protoTCP},
+	{"tcp6", protoTCP},
+	{"udp", protoUDP},
+	{"udp6", protoUDP},
+}
+
+// Config is the configuration of the process enricher.
+type Config struct {
+	// Interval at which procfs is scanned for sockets and their processes.
+	Refresh time.Duration
+
+	// Path of the procfs to scan.
+	ProcPath string
+}
+
+// Process is a process owning a socket.
+type Process struct {
+	PID  int
+	Comm string
+}
+
+// socket identifies a socket by its protocol and local and remote
+// endpoints. Addresses are 4 bytes long for IPv4.
+type socket struct {
+	proto              uint8
+	local, remote      string
+	localPort, remPort uint16
+}
+
+// Enricher attaches the PID and command name of the local process owning an
+// event's flow. Sockets are resolved by periodically scanning procfs' socket
+// tables and the file descriptors of all processes, so flows of processes
+// living shorter than the refresh interval may not be tagged.
+//
+// Socket tables are local to a network namespace, so only events in the
+// network namespace conntracct is running in are tagged.
+type Enricher struct {
+	cfg Config
+
+	// Inode number of the network namespace sockets are resolved in.
+	netns uint32
+
+	// map[socket]Process. Replaced on every scan.
+	sockets atomic.Value
+
+	stop chan struct{}
+}
+
+// New returns an Enricher scanning the configured procfs. Performs an
+// initial scan before returning.
+func New(cfg Config) (*Enricher, error) {
+
+	if cfg.Refresh == 0 {
+		cfg.Refresh = defaultRefresh
+	}
+	if cfg.ProcPath == "" {
+		cfg.ProcPath = defaultProcPath
+	}
+
+	fi, err := os.Stat(path.Join(cfg.ProcPath, "self", "ns", "net"))
+	if err != nil {
+		return nil, errors.Wrap(err, "getting network namespace")
+	}
+
+	e := Enricher{
+		cfg:   cfg,
+		netns: uint32(fi.Sys().(*syscall.Stat_t).Ino),
+		stop:  make(chan struct{}),
+	}
+
+	if err := e.refresh(); err != nil {
+		return nil, err
+	}
+
+	go e.refreshWorker()
+
+	return &e, nil
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "process"
+}
+
+// Enrich tags the event with the PID and command name of the process owning
+// the local end of its flow, as pid and comm.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+
+	if ev.NetNS != e.netns || ev.Proto != protoTCP && ev.Proto != protoUDP {
+		return
+	}
+
+	p, ok := e.lookup(ev)
+	if !ok {
+		return
+	}
+
+	ev.SetTag("pid", strconv.Itoa(p.PID))
+	ev.SetTag("comm", p.Comm)
+}
+
+// Close stops scanning procfs.
+func (e *Enricher) Close() error {
+	close(e.stop)
+	return nil
+}
+
+// lookup returns the process owning the socket of the event's flow.
+// Locally-originated flows are matched by their source endpoint, flows
+// terminating locally by their destination endpoint. Sockets without a
+// remote endpoint, like listening or unconnected UDP sockets, are matched
+// by their local endpoint alone, or only their port if bound to any address.
+func (e *Enricher) lookup(ev *bpf.Event) (Process, bool) {
+
+	sockets := e.sockets.Load().(map[socket]Process)
+
+	src, dst := addrKey(ev.SrcAddr), addrKey(ev.DstAddr)
+
+	for _, s := range []socket{
+		{ev.Proto, src, dst, ev.SrcPort, ev.DstPort},
+		{ev.Proto, dst, src, ev.DstPort, ev.SrcPort},
+		{ev.Proto, src, "", ev.SrcPort, 0},
+		{ev.Proto, dst, "", ev.DstPort, 0},
+		{ev.Proto, "", "", ev.SrcPort, 0},
+		{ev.Proto, "", "", ev.DstPort, 0},
+	} {
+		if p, ok := sockets[s]; ok {
+			return p, true
+		}
+	}
+
+	return Process{}, false
+}
+
+// refresh scans procfs and replaces the enricher's socket lookup table.
+func (e *Enricher) refresh() error {
+
+	inodes := make(map[uint64]socket)
+	for _, t := range socketTables {
+		if err := readSockets(path.Join(e.cfg.ProcPath, "net", t.name), t.proto, inodes); err != nil {
+			return err
+		}
+	}
+
+	owners, err := socketOwners(e.cfg.ProcPath, inodes)
+	if err != nil {
+		return err
+	}
+
+	m := make(map[socket]Process, len(owners))
+	for ino, p := range owners {
+		m[inodes[ino]] = p
+	}
+
+	e.sockets.Store(m)
+
+	return nil
+}
+
+// refreshWorker refreshes the lookup table until the enricher is closed.
+func (e *Enricher) refreshWorker() {
+
+	t := time.NewTicker(e.cfg.Refresh)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := e.refresh(); err != nil {
+				log.Warnf("Process enricher: error scanning procfs: %s", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// readSockets adds the sockets in the procfs socket table at the given path
+// to inodes, keyed by their inode number. Missing tables are skipped, eg.
+// when IPv6 is disabled.
+func readSockets(p string, proto uint8, inodes map[uint64]socket) error {
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return parseSockets(f, proto, inodes)
+}
+
+// parseSockets parses a procfs socket table, eg. /proc/net/tcp.
+func parseSockets(r io.Reader, proto uint8, inodes map[uint64]socket) error {
+
+	s := bufio.NewScanner(r)
+
+	// Skip the header.
+	s.Scan()
+
+	for s.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 {
+			return fmt.Errorf(errFmtLine, s.Text())
+		}
+
+		local, lport, err := parseEndpoint(fields[1])
+		if err != nil {
+			return err
+		}
+		remote, rport, err := parseEndpoint(fields[2])
+		if err != nil {
+			return err
+		}
+
+		ino, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf(errFmtLine, s.Text())
+		}
+
+		// Sockets in TIME_WAIT no longer belong to a process.
+		if ino == 0 {
+			continue
+		}
+
+		if rport == 0 {
+			remote = ""
+		}
+		if net.IP(local).IsUnspecified() {
+			local = ""
+		}
+
+		inodes[ino] = socket{proto, local, remote, lport, rport}
+	}
+
+	return s.Err()
+}
+
+// parseEndpoint parses an address and port of a procfs socket table,
+// eg. '0100007F:0035'. Addresses are a list of 32-bit words in host byte
+// order, the port is big endian.
+func parseEndpoint(s string) (string, uint16, error) {
+
+	i := strings.IndexByte(s, ':')
+	if i == -1 {
+		return "", 0, fmt.Errorf(errFmtEndpoint, s)
+	}
+
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return "", 0, fmt.Errorf(errFmtEndpoint, s)
+	}
+
+	for w := 0; w < len(b); w += 4 {
+		binary.BigEndian.PutUint32(b[w:], binary.NativeEndian.Uint32(b[w:]))
+	}
+
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf(errFmtEndpoint, s)
+	}
+
+	return addrKey(b), uint16(port), nil
+}
+
+// socketOwners returns the processes holding a file descriptor of the
+// given sockets, keyed by inode number. Sockets shared by multiple
+// processes, eg. after a fork, are attributed to the first one found.
+func socketOwners(proc string, inodes map[uint64]socket) (map[uint64]Process, error) {
+
+	dirs, err := os.ReadDir(proc)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64]Process)
+
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+
+		fdDir := path.Join(proc, d.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// Process exited since it was listed, or isn't accessible.
+			continue
+		}
+
+		var comm string
+		for _, fd := range fds {
+			l, err := os.Readlink(path.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(l, "socket:[") {
+				continue
+			}
+
+			ino, err := strconv.ParseUint(strings.TrimSuffix(l[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := inodes[ino]; !ok {
+				continue
+			}
+			if _, ok := owners[ino]; ok {
+				continue
+			}
+
+			if comm == "" {
+				b, err := os.ReadFile(path.Join(proc, d.Name(), "comm"))
+				if err != nil {
+					break
+				}
+				comm = strings.TrimSpace(string(b))
+			}
+
+			owners[ino] = Process{PID: pid, Comm: comm}
+		}
+	}
+
+	return owners, nil
+}
+
+// addrKey returns the lookup key of an address, IPv4 addresses and
+// IPv4-mapped IPv6 addresses are represented by their 4 bytes.
+func addrKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return string(v4)
+	}
+	return string(ip.To16())
+}
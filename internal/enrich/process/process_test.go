@@ -0,0 +1,90 @@
+package process
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestParseSockets(t *testing.T) {
+
+	// Addresses in little endian, as on x86.
+	table := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0035 0200007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:0050 0200007F:D432 06 00000000:00000000 00:00000000 00000000     0        0 0 1 0000000000000000 100 0 0 10 0
+`
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("socket table fixture is little endian")
+	}
+
+	inodes := make(map[uint64]socket)
+	require.NoError(t, parseSockets(strings.NewReader(table), protoTCP, inodes))
+
+	assert.Equal(t, map[uint64]socket{
+		1001: {protoTCP, "", "", 22, 0},
+		1002: {protoTCP, "\x7f\x00\x00\x01", "\x7f\x00\x00\x02", 53, 54321},
+	}, inodes)
+
+	err := parseSockets(strings.NewReader("header\n0: 0100007F 1\n"), protoTCP, inodes)
+	assert.Error(t, err)
+}
+
+func TestEnrich(t *testing.T) {
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	c, err := net.Dial("tcp4", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	e, err := New(Config{})
+	require.NoError(t, err)
+	defer e.Close()
+
+	comm, err := os.ReadFile("/proc/self/comm")
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"pid":  strconv.Itoa(os.Getpid()),
+		"comm": strings.TrimSpace(string(comm)),
+	}
+
+	local, remote := c.LocalAddr().(*net.TCPAddr), c.RemoteAddr().(*net.TCPAddr)
+
+	// Locally-originated flow, matched by its connected socket.
+	ev := bpf.Event{
+		NetNS:   e.netns,
+		Proto:   protoTCP,
+		SrcAddr: local.IP, SrcPort: uint16(local.Port),
+		DstAddr: remote.IP, DstPort: uint16(remote.Port),
+	}
+	e.Enrich(&ev)
+	assert.Equal(t, want, ev.Tags)
+
+	// Flow to the listening socket from another host.
+	ev = bpf.Event{
+		NetNS:   e.netns,
+		Proto:   protoTCP,
+		SrcAddr: net.IPv4(192, 0, 2, 1), SrcPort: 40000,
+		DstAddr: remote.IP, DstPort: uint16(remote.Port),
+	}
+	e.Enrich(&ev)
+	assert.Equal(t, want, ev.Tags)
+
+	// Events in other network namespaces are not tagged.
+	ev.Tags = nil
+	ev.NetNS++
+	e.Enrich(&ev)
+	assert.Nil(t, ev.Tags)
+}
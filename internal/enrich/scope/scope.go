@@ -0,0 +1,68 @@
+package scope
+
+import (
+	"net"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Scopes of addresses, returned by Of.
+const (
+	Loopback  = "loopback"
+	LinkLocal = "link-local"
+	Private   = "private"
+	Multicast = "multicast"
+	Public    = "public"
+)
+
+// Shared address space used by carrier-grade NAT, not routed on the internet. (RFC 6598)
+var sharedNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// Of returns the scope of an address. Private addresses are those of
+// RFC 1918, RFC 6598 and IPv6 unique local addresses. (fc00::/7)
+func Of(ip net.IP) string {
+
+	switch {
+	case ip.IsLoopback():
+		return Loopback
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		return LinkLocal
+	case ip.IsPrivate(), sharedNet.Contains(ip):
+		return Private
+	case ip.IsMulticast():
+		return Multicast
+	}
+
+	return Public
+}
+
+// HostInternal returns true if traffic between src and dst doesn't leave
+// the host, when either is a loopback address or both are the same.
+func HostInternal(src, dst net.IP) bool {
+	return src.IsLoopback() || dst.IsLoopback() || src.Equal(dst)
+}
+
+// Enricher tags events with the scopes of their source and destination
+// addresses, as src_scope and dst_scope.
+type Enricher struct{}
+
+// New returns an Enricher classifying events' addresses.
+func New() *Enricher {
+	return &Enricher{}
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "scope"
+}
+
+// Enrich tags the event with the scopes of its addresses.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+	ev.SetTag("src_scope", Of(ev.SrcAddr))
+	ev.SetTag("dst_scope", Of(ev.DstAddr))
+}
+
+// Close is a no-op, the enricher holds no resources.
+func (e *Enricher) Close() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package scope
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestOf(t *testing.T) {
+
+	tests := map[string]string{
+		"127.0.0.1":   Loopback,
+		"::1":         Loopback,
+		"169.254.1.1": LinkLocal,
+		"fe80::1":     LinkLocal,
+		"10.1.2.3":    Private,
+		"172.16.0.1":  Private,
+		"192.168.1.1": Private,
+		"100.64.1.1":  Private,
+		"fd00::1":     Private,
+		"224.0.0.251": LinkLocal,
+		"239.1.2.3":   Multicast,
+		"192.0.2.1":   Public,
+		"2001:db8::1": Public,
+	}
+
+	for addr, scope := range tests {
+		assert.Equal(t, scope, Of(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestEnrich(t *testing.T) {
+
+	ev := bpf.Event{SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.ParseIP("2001:db8::1")}
+	New().Enrich(&ev)
+
+	assert.Equal(t, map[string]string{"src_scope": Private, "dst_scope": Public}, ev.Tags)
+
+	assert.True(t, HostInternal(net.IPv4(10, 0, 0, 1), net.IPv4(127, 0, 0, 1)))
+	assert.True(t, HostInternal(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 1)))
+	assert.False(t, HostInternal(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)))
+}
@@ -0,0 +1,5 @@
+package static
+
+import "errors"
+
+var errEmptyKey = errors.New("tag with empty key")
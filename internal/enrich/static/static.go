@@ -0,0 +1,65 @@
+package static
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Config is the configuration of the static enricher.
+type Config struct {
+	// Tags attached to every event, eg. site or role.
+	Tags map[string]string
+
+	// Also tag events with the machine's hostname, as host.
+	Hostname bool
+}
+
+// Enricher attaches a fixed set of tags to every event, eg. to tell apart
+// the events of multiple machines sending to the same sink.
+type Enricher struct {
+	tags map[string]string
+}
+
+// New returns an Enricher for the given tags.
+func New(cfg Config) (*Enricher, error) {
+
+	e := &Enricher{tags: make(map[string]string, len(cfg.Tags)+1)}
+
+	for k, v := range cfg.Tags {
+		if k == "" {
+			return nil, errEmptyKey
+		}
+		e.tags[k] = v
+	}
+
+	// Explicitly configured host tags take precedence.
+	if _, ok := e.tags["host"]; cfg.Hostname && !ok {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting hostname")
+		}
+		e.tags["host"] = h
+	}
+
+	return e, nil
+}
+
+// Name returns the name of the Enricher.
+func (e *Enricher) Name() string {
+	return "static"
+}
+
+// Enrich attaches the configured tags to the event.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+	for k, v := range e.tags {
+		ev.SetTag(k, v)
+	}
+}
+
+// Close is a no-op, the Enricher holds no resources.
+func (e *Enricher) Close() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package static
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	e, err := New(Config{Tags: map[string]string{"site": "ams1", "role": "edge"}, Hostname: true})
+	require.NoError(t, err)
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	ev := bpf.Event{Tags: map[string]string{"interface": "eth0"}}
+	e.Enrich(&ev)
+	assert.Equal(t, map[string]string{
+		"interface": "eth0",
+		"site":      "ams1",
+		"role":      "edge",
+		"host":      host,
+	}, ev.Tags)
+
+	// A configured host tag is not overridden by the hostname.
+	e, err = New(Config{Tags: map[string]string{"host": "gw"}, Hostname: true})
+	require.NoError(t, err)
+
+	ev = bpf.Event{}
+	e.Enrich(&ev)
+	assert.Equal(t, map[string]string{"host": "gw"}, ev.Tags)
+
+	_, err = New(Config{Tags: map[string]string{"": "x"}})
+	assert.EqualError(t, err, errEmptyKey.Error())
+}
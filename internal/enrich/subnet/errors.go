@@ -0,0 +1,5 @@
+package subnet
+
+import "errors"
+
+var errDuplicatePrefix = errors.New("duplicate prefix")
@@ -0,0 +1,147 @@
+package subnet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Config is the configuration of the subnet enricher.
+type Config struct {
+	// Prefixes events are tagged with, eg. '10.1.2.0/24' or
+	// '10.1.2.0/24=customer-a' to also tag a name.
+	Prefixes []string
+
+	// Replace events' addresses with the network address of their prefix,
+	// instead of only tagging them.
+	Replace bool
+}
+
+// prefix is a network in the enricher's table.
+type prefix struct {
+	net  *net.IPNet
+	cidr string
+	name string
+}
+
+// Enricher tags events with the longest configured prefixes containing
+// their source and destination addresses, as src_net and dst_net,
+// and the prefixes' names as src_net_name and dst_net_name.
+type Enricher struct {
+	replace bool
+
+	// Prefixes by prefix length and network address, and the prefix
+	// lengths in the table from longest to shortest.
+	v4, v6 map[int]map[string]*prefix
+	lv4    []int
+	lv6    []int
+}
+
+// New returns an Enricher for the given prefix table.
+func New(cfg Config) (*Enricher, error) {
+
+	e := &Enricher{
+		replace: cfg.Replace,
+		v4:      make(map[int]map[string]*prefix),
+		v6:      make(map[int]map[string]*prefix),
+	}
+
+	for _, p := range cfg.Prefixes {
+		cidr, name := p, ""
+		if i := strings.IndexByte(p, '='); i != -1 {
+			cidr, name = p[:i], p[i+1:]
+		}
+
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("prefix '%s': %v", p, err)
+		}
+
+		table := e.v6
+		if n.IP.To4() != nil {
+			table = e.v4
+		}
+
+		ones, _ := n.Mask.Size()
+		if table[ones] == nil {
+			table[ones] = make(map[string]*prefix)
+		}
+		if _, ok := table[ones][string(n.IP)]; ok {
+			return nil, fmt.Errorf("prefix '%s': %v", p, errDuplicatePrefix)
+		}
+		table[ones][string(n.IP)] = &prefix{net: n, cidr: n.String(), name: strings.TrimSpace(name)}
+	}
+
+	e.lv4 = lengths(e.v4)
+	e.lv6 = lengths(e.v6)
+
+	return e, nil
+}
+
+// lengths returns the prefix lengths in a table, longest first.
+func lengths(t map[int]map[string]*prefix) []int {
+
+	out := make([]int, 0, len(t))
+	for l := range t {
+		out = append(out, l)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(out)))
+
+	return out
+}
+
+// Name returns the name of the enricher.
+func (e *Enricher) Name() string {
+	return "subnet"
+}
+
+// Enrich tags the event with the prefixes of its addresses.
+func (e *Enricher) Enrich(ev *bpf.Event) {
+
+	if p := e.lookup(ev.SrcAddr); p != nil {
+		ev.SetTag("src_net", p.cidr)
+		if p.name != "" {
+			ev.SetTag("src_net_name", p.name)
+		}
+		if e.replace {
+			ev.SrcAddr = p.net.IP
+		}
+	}
+
+	if p := e.lookup(ev.DstAddr); p != nil {
+		ev.SetTag("dst_net", p.cidr)
+		if p.name != "" {
+			ev.SetTag("dst_net_name", p.name)
+		}
+		if e.replace {
+			ev.DstAddr = p.net.IP
+		}
+	}
+}
+
+// lookup returns the longest prefix containing ip, or nil.
+func (e *Enricher) lookup(ip net.IP) *prefix {
+
+	table, lens, bits := e.v6, e.lv6, 128
+	if ip4 := ip.To4(); ip4 != nil {
+		table, lens, bits, ip = e.v4, e.lv4, 32, ip4
+	} else if ip = ip.To16(); ip == nil {
+		return nil
+	}
+
+	for _, l := range lens {
+		if p, ok := table[l][string(ip.Mask(net.CIDRMask(l, bits)))]; ok {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Close is a no-op, the enricher holds no resources.
+func (e *Enricher) Close() error {
+	return nil
+}
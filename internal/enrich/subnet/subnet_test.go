@@ -0,0 +1,48 @@
+package subnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEnrich(t *testing.T) {
+
+	e, err := New(Config{Prefixes: []string{
+		"10.0.0.0/8",
+		"10.1.2.0/24=customer-a",
+		"2001:db8::/32 = customer-b",
+	}})
+	require.NoError(t, err)
+
+	ev := bpf.Event{SrcAddr: net.IPv4(10, 1, 2, 3), DstAddr: net.IPv4(10, 9, 9, 9)}
+	e.Enrich(&ev)
+	assert.Equal(t, map[string]string{
+		"src_net":      "10.1.2.0/24",
+		"src_net_name": "customer-a",
+		"dst_net":      "10.0.0.0/8",
+	}, ev.Tags)
+
+	ev = bpf.Event{SrcAddr: net.ParseIP("2001:db8::1"), DstAddr: net.IPv4(192, 168, 0, 1)}
+	e.Enrich(&ev)
+	assert.Equal(t, map[string]string{
+		"src_net":      "2001:db8::/32",
+		"src_net_name": "customer-b",
+	}, ev.Tags)
+
+	// Addresses are replaced by their network address.
+	e.replace = true
+	ev = bpf.Event{SrcAddr: net.IPv4(10, 1, 2, 3), DstAddr: net.IPv4(192, 168, 0, 1)}
+	e.Enrich(&ev)
+	assert.Equal(t, "10.1.2.0", ev.SrcAddr.String())
+	assert.Equal(t, "192.168.0.1", ev.DstAddr.String())
+
+	_, err = New(Config{Prefixes: []string{"10.0.0.0/8", "10.1.0.0/8"}})
+	assert.Error(t, err)
+	_, err = New(Config{Prefixes: []string{"10.0.0.0"}})
+	assert.Error(t, err)
+}
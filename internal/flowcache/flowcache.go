@@ -0,0 +1,225 @@
+// Package flowcache implements a bounded table holding per-flow state,
+// keyed by connection ID, shared by the pipeline stages tracking flows.
+package flowcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Config is the configuration of a Cache.
+type Config struct {
+	// Maximum amount of flows in the cache. Adding a flow to a full cache
+	// evicts the least recently updated flow. Zero means unbounded.
+	MaxFlows int
+
+	// Flows that haven't been updated for this long are evicted, in case
+	// their destroy event was lost. Zero never expires flows.
+	TTL time.Duration
+}
+
+// Stats holds statistics about a Cache.
+type Stats struct {
+	// amount of flows in the cache
+	Flows uint64 `json:"flows"`
+
+	// amount of flows evicted to make room for new ones
+	Evicted uint64 `json:"evicted"`
+
+	// amount of flows evicted after not being updated for the TTL,
+	// or by ExpireBefore
+	Expired uint64 `json:"expired"`
+}
+
+// entry is a flow in the cache, linked in order of the time stamp of its
+// most recent update.
+type entry[V any] struct {
+	id    uint32
+	ts    uint64
+	value V
+
+	prev, next *entry[V]
+}
+
+// Cache holds a value of type V for each flow. Flows are kept in order of
+// the time stamp of their last update, so the least recently updated ones
+// are evicted first, both when the cache is full and when they expire.
+// Updates may arrive out of order, eg. from different CPUs. Flows are
+// expired relative to the most recent update time stamp, not the wall clock.
+//
+// A Cache is not safe for concurrent use, its users guard it with their
+// own lock. Stats can be called concurrently.
+type Cache[V any] struct {
+	max int
+	ttl uint64
+
+	flows map[uint32]*entry[V]
+
+	// Sentinel of the circular list of entries, sorted by time stamp,
+	// most recently updated first.
+	lru entry[V]
+
+	// Time stamp of the most recent update.
+	last uint64
+
+	size    uint64
+	evicted uint64
+	expired uint64
+}
+
+// New returns an empty Cache with the given configuration.
+func New[V any](cfg Config) *Cache[V] {
+
+	c := &Cache[V]{
+		max:   cfg.MaxFlows,
+		ttl:   uint64(cfg.TTL),
+		flows: make(map[uint32]*entry[V]),
+	}
+	c.lru.prev, c.lru.next = &c.lru, &c.lru
+
+	return c
+}
+
+// Get returns the value of a flow, and whether the flow is in the cache.
+func (c *Cache[V]) Get(id uint32) (V, bool) {
+
+	e, ok := c.flows[id]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+// Put sets the value of a flow updated at ktime time stamp ts. Evicts the
+// least recently updated flows if the cache is full and those that expired.
+func (c *Cache[V]) Put(id uint32, ts uint64, v V) {
+
+	if ts > c.last {
+		c.last = ts
+	}
+
+	e, ok := c.flows[id]
+	if ok {
+		c.unlink(e)
+	} else {
+		if c.max > 0 && len(c.flows) >= c.max {
+			c.remove(c.lru.prev)
+			atomic.AddUint64(&c.evicted, 1)
+		}
+
+		e = &entry[V]{id: id}
+		c.flows[id] = e
+	}
+
+	e.ts, e.value = ts, v
+	c.insert(e)
+
+	c.expire()
+
+	atomic.StoreUint64(&c.size, uint64(len(c.flows)))
+}
+
+// Delete removes a flow from the cache.
+func (c *Cache[V]) Delete(id uint32) {
+
+	if e, ok := c.flows[id]; ok {
+		c.remove(e)
+		atomic.StoreUint64(&c.size, uint64(len(c.flows)))
+	}
+}
+
+// Len returns the amount of flows in the cache.
+func (c *Cache[V]) Len() int {
+	return len(c.flows)
+}
+
+// Range calls fn for every flow in the cache, most recently updated first,
+// until fn returns false. fn must not modify the cache.
+func (c *Cache[V]) Range(fn func(id uint32, v V) bool) {
+	for e := c.lru.next; e != &c.lru; e = e.next {
+		if !fn(e.id, e.value) {
+			return
+		}
+	}
+}
+
+// ExpireBefore evicts the flows last updated before ktime time stamp ts,
+// least recently updated first, calling fn for each of them.
+func (c *Cache[V]) ExpireBefore(ts uint64, fn func(id uint32, v V)) {
+
+	for e := c.lru.prev; e != &c.lru && e.ts < ts; e = c.lru.prev {
+		c.remove(e)
+		atomic.AddUint64(&c.expired, 1)
+		fn(e.id, e.value)
+	}
+
+	atomic.StoreUint64(&c.size, uint64(len(c.flows)))
+}
+
+// ExpireBeforeIf evicts the flows last updated before ktime time stamp ts
+// for which fn returns true, least recently updated first. fn is called for
+// every flow last updated before ts and must not modify the cache.
+func (c *Cache[V]) ExpireBeforeIf(ts uint64, fn func(id uint32, v V) bool) {
+
+	for e := c.lru.prev; e != &c.lru && e.ts < ts; {
+		prev := e.prev
+		if fn(e.id, e.value) {
+			c.remove(e)
+			atomic.AddUint64(&c.expired, 1)
+		}
+		e = prev
+	}
+
+	atomic.StoreUint64(&c.size, uint64(len(c.flows)))
+}
+
+// Stats returns the cache's statistics.
+func (c *Cache[V]) Stats() Stats {
+	return Stats{
+		Flows:   atomic.LoadUint64(&c.size),
+		Evicted: atomic.LoadUint64(&c.evicted),
+		Expired: atomic.LoadUint64(&c.expired),
+	}
+}
+
+// expire evicts the least recently updated flows that haven't been
+// updated for longer than the TTL.
+func (c *Cache[V]) expire() {
+
+	if c.ttl == 0 {
+		return
+	}
+
+	for e := c.lru.prev; e != &c.lru && c.last-e.ts > c.ttl; e = c.lru.prev {
+		c.remove(e)
+		atomic.AddUint64(&c.expired, 1)
+	}
+}
+
+// remove unlinks an entry and deletes it from the cache.
+func (c *Cache[V]) remove(e *entry[V]) {
+	c.unlink(e)
+	delete(c.flows, e.id)
+}
+
+func (c *Cache[V]) unlink(e *entry[V]) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+}
+
+// insert links an entry in order of its time stamp, after the entries
+// updated at the same time or later. Updates mostly arrive in order, so
+// the entry is usually linked at the front.
+func (c *Cache[V]) insert(e *entry[V]) {
+
+	prev := &c.lru
+	for prev.next != &c.lru && prev.next.ts > e.ts {
+		prev = prev.next
+	}
+
+	e.prev, e.next = prev, prev.next
+	prev.next.prev = e
+	prev.next = e
+}
@@ -0,0 +1,96 @@
+package flowcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+
+	c := New[string](Config{MaxFlows: 2, TTL: time.Minute})
+
+	c.Put(1, uint64(time.Second), "a")
+	c.Put(2, uint64(2*time.Second), "b")
+
+	// Updating a flow makes it the most recently updated one.
+	c.Put(1, uint64(3*time.Second), "c")
+
+	// Adding a flow to the full cache evicts flow 2.
+	c.Put(3, uint64(4*time.Second), "d")
+	_, ok := c.Get(2)
+	assert.False(t, ok)
+
+	v, ok := c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, "c", v)
+
+	var ids []uint32
+	c.Range(func(id uint32, _ string) bool {
+		ids = append(ids, id)
+		return true
+	})
+	assert.Equal(t, []uint32{3, 1}, ids)
+
+	// Flow 1 expires a minute after its last update.
+	c.Put(3, uint64(time.Minute+4*time.Second), "e")
+	assert.Equal(t, 1, c.Len())
+
+	c.Put(4, uint64(2*time.Minute), "f")
+	var expired []string
+	c.ExpireBefore(uint64(2*time.Minute), func(_ uint32, v string) {
+		expired = append(expired, v)
+	})
+	assert.Equal(t, []string{"e"}, expired)
+
+	c.Delete(4)
+	assert.Zero(t, c.Len())
+
+	assert.Equal(t, Stats{Flows: 0, Evicted: 1, Expired: 2}, c.Stats())
+}
+
+func TestCacheOutOfOrder(t *testing.T) {
+
+	c := New[string](Config{MaxFlows: 3, TTL: time.Minute})
+
+	// Updates from different CPUs arrive out of order.
+	c.Put(1, uint64(3*time.Second), "a")
+	c.Put(2, uint64(time.Second), "b")
+	c.Put(3, uint64(2*time.Second), "c")
+
+	var ids []uint32
+	c.Range(func(id uint32, _ string) bool {
+		ids = append(ids, id)
+		return true
+	})
+	assert.Equal(t, []uint32{1, 3, 2}, ids)
+
+	// Flow 2 has the oldest update and is evicted first.
+	c.Put(4, uint64(4*time.Second), "d")
+	_, ok := c.Get(2)
+	assert.False(t, ok)
+
+	// Flow 3 expires even though it was put after flow 1.
+	var expired []uint32
+	c.ExpireBefore(uint64(3*time.Second), func(id uint32, _ string) {
+		expired = append(expired, id)
+	})
+	assert.Equal(t, []uint32{3}, expired)
+
+	// Flows behind newer ones expire with the TTL.
+	c.Put(5, uint64(time.Minute+3500*time.Millisecond), "e")
+	_, ok = c.Get(1)
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// Only the matching flows expire.
+	c.Put(6, uint64(time.Minute+time.Second), "f")
+	c.ExpireBeforeIf(uint64(2*time.Minute), func(_ uint32, v string) bool {
+		return v != "e"
+	})
+	v, ok := c.Get(5)
+	assert.True(t, ok)
+	assert.Equal(t, "e", v)
+	assert.Equal(t, 1, c.Len())
+}
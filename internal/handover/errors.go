@@ -0,0 +1,8 @@
+package handover
+
+import "errors"
+
+const errFmtHandover = "running instance failed to hand over: %s"
+
+// ErrNotRunning is returned by Dial if no instance listens for handover requests.
+var ErrNotRunning = errors.New("no running instance to take over from")
@@ -0,0 +1,178 @@
+// Package handover implements the protocol a new conntracct instance uses to
+// take over accounting from a running one, eg. during a binary upgrade.
+//
+// The running instance listens on a unix socket. The new instance connects,
+// starts its probe in takeover mode and sends the probe's handover time
+// stamp. The running instance stops reporting events raised from then on,
+// stops its pipeline so its sinks write all earlier events, replies and
+// exits. Every event is reported by exactly one of the instances. The new
+// instance waits for the connection to be closed by the exiting process
+// before binding the listeners of its APIs.
+package handover
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// request is sent by the instance taking over.
+type request struct {
+	Time uint64 `json:"time"`
+}
+
+// reply is sent by the instance handing over once it's stopped.
+type reply struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Request is a handover requested by a new instance, received by a Server.
+type Request struct {
+	// Monotonic time stamp of the first events reported by the new instance.
+	Time uint64
+
+	conn net.Conn
+}
+
+// Reply tells the new instance the handover is complete, or failed with err.
+// The connection stays open until the process exits, the new instance waits
+// for it to be closed.
+func (r *Request) Reply(err error) error {
+
+	var rep reply
+	if err != nil {
+		rep.Error = err.Error()
+	}
+
+	return json.NewEncoder(r.conn).Encode(rep)
+}
+
+// Server listens for handover requests on a unix socket.
+type Server struct {
+	l    net.Listener
+	reqs chan *Request
+}
+
+// Listen starts listening for handover requests on the unix socket at path,
+// replacing a stale socket left by a previous instance.
+func Listen(path string) (*Server, error) {
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "removing stale handover socket")
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "listening for handover requests")
+	}
+
+	// Only allow the owner, usually root, to take over.
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	s := &Server{l: l, reqs: make(chan *Request)}
+	go s.serve()
+
+	log.Infof("Listening for handover requests on '%s'", path)
+
+	return s, nil
+}
+
+// Requests returns a channel receiving handover requests. Only one
+// request is received, the server stops accepting connections after it.
+func (s *Server) Requests() <-chan *Request {
+	return s.reqs
+}
+
+// Close stops listening for handover requests and removes the socket.
+func (s *Server) Close() error {
+	return s.l.Close()
+}
+
+// serve accepts connections until a valid handover request is received.
+func (s *Server) serve() {
+
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		var req request
+		if err := json.NewDecoder(c).Decode(&req); err != nil || req.Time == 0 {
+			log.Warnf("Ignoring malformed handover request: %v", err)
+			c.Close()
+			continue
+		}
+
+		s.reqs <- &Request{Time: req.Time, conn: c}
+
+		return
+	}
+}
+
+// Conn is the connection of an instance taking over to the running instance.
+type Conn struct {
+	c net.Conn
+}
+
+// Dial connects to the running instance listening on the unix socket at
+// path. Returns ErrNotRunning if no instance is listening.
+func Dial(path string) (*Conn, error) {
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrNotRunning
+		}
+		return nil, errors.Wrap(err, "connecting to running instance")
+	}
+
+	return &Conn{c: c}, nil
+}
+
+// Close closes the connection without taking over.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// TakeOver asks the running instance to hand over from the time stamp ts,
+// returned by the TakeOver of the new instance's pipeline. It returns once
+// the running instance replied and exited, or after the timeout.
+func (c *Conn) TakeOver(ts uint64, timeout time.Duration) error {
+
+	defer c.c.Close()
+
+	if err := c.c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(c.c).Encode(request{Time: ts}); err != nil {
+		return errors.Wrap(err, "sending handover request")
+	}
+
+	dec := json.NewDecoder(c.c)
+
+	var rep reply
+	if err := dec.Decode(&rep); err != nil {
+		return errors.Wrap(err, "reading handover reply")
+	}
+	if rep.Error != "" {
+		return errors.Errorf(errFmtHandover, rep.Error)
+	}
+
+	// The connection is closed when the running instance exits.
+	if err := dec.Decode(&rep); err != nil && err != io.EOF {
+		return errors.Wrap(err, "waiting for running instance to exit")
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package handover
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandover(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "handover.sock")
+
+	_, err := Dial(path)
+	assert.Equal(t, ErrNotRunning, err)
+
+	s, err := Listen(path)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		c, err := Dial(path)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- c.TakeOver(42, time.Second)
+	}()
+
+	req := <-s.Requests()
+	assert.EqualValues(t, 42, req.Time)
+	require.NoError(t, req.Reply(errors.New("sinks failed")))
+
+	// Closing the connection stands in for the process exiting.
+	req.conn.Close()
+	assert.EqualError(t, <-done, "running instance failed to hand over: sinks failed")
+
+	require.NoError(t, s.Close())
+}
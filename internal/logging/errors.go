@@ -0,0 +1,6 @@
+package logging
+
+const (
+	errFmtFormat = "unknown log format '%s', expected text or json"
+	errFmtModule = "unknown log module '%s', expected bpf, pipeline, sinks, enrich or api"
+)
@@ -0,0 +1,181 @@
+// Package logging configures the process-wide logrus logger: its level and
+// format, an output file rotated by size, and levels for individual modules.
+package logging
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats of log entries.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Modules with their own log level, by the files or directories of the
+// code logging, below the repository root. The first match wins.
+var modules = []struct {
+	name, prefix string
+}{
+	// Loading and running the probe, or its netlink fallback.
+	{"bpf", "pkg/bpf/"},
+	{"bpf", "internal/pipeline/acct.go"},
+	{"bpf", "internal/pipeline/netlink.go"},
+	{"bpf", "internal/pipeline/bootstrap.go"},
+
+	{"pipeline", "internal/pipeline/"},
+	{"sinks", "internal/sinks/"},
+	{"enrich", "internal/enrich/"},
+	{"api", "internal/apiserver/"},
+	{"api", "internal/rpcserver/"},
+	{"api", "internal/metrics/"},
+}
+
+// Config is the configuration of the logger.
+type Config struct {
+	// Level of entries logged, eg. info or debug. Defaults to info.
+	Level string
+
+	// FormatText or FormatJSON. Defaults to FormatText.
+	Format string
+
+	// Path of the file to log to instead of stderr.
+	File string
+
+	// Size in bytes at which the log file is rotated, zero disables rotation.
+	// MaxBackups rotated files are kept, as File.1 to File.<MaxBackups>.
+	MaxSize    int64
+	MaxBackups int
+
+	// Levels of entries logged by modules, overriding Level, eg. sinks: debug.
+	// Modules are bpf, pipeline, sinks, enrich and api.
+	Modules map[string]string
+}
+
+// Init configures the process-wide logger.
+func Init(cfg Config) error {
+
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	var f log.Formatter
+	switch cfg.Format {
+	case "", FormatText:
+		f = &log.TextFormatter{}
+	case FormatJSON:
+		f = &log.JSONFormatter{}
+	default:
+		return errors.Errorf(errFmtFormat, cfg.Format)
+	}
+
+	if len(cfg.Modules) > 0 {
+		mf := &moduleFormatter{inner: f, level: level, levels: make(map[string]log.Level)}
+		for name, l := range cfg.Modules {
+			if !knownModule(name) {
+				return errors.Errorf(errFmtModule, name)
+			}
+			ml, err := parseLevel(l)
+			if err != nil {
+				return errors.Wrapf(err, "module '%s'", name)
+			}
+			mf.levels[name] = ml
+
+			// The logger needs to let through the most verbose module's entries.
+			if ml > level {
+				level = ml
+			}
+		}
+
+		// The formatter finds the module of entries by their caller.
+		log.SetReportCaller(true)
+		f = mf
+	}
+
+	if cfg.File != "" {
+		w, err := newRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(w)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+
+	log.SetFormatter(f)
+	log.SetLevel(level)
+
+	return nil
+}
+
+// parseLevel parses a log level, empty meaning info.
+func parseLevel(s string) (log.Level, error) {
+	if s == "" {
+		return log.InfoLevel, nil
+	}
+	return log.ParseLevel(s)
+}
+
+// knownModule returns true if name is one of the modules.
+func knownModule(name string) bool {
+	for _, m := range modules {
+		if m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleOf returns the module of the source file at path, empty if none.
+func moduleOf(path string) string {
+
+	// Strip the path up to the repository root, which depends on the build.
+	for _, dir := range []string{"/internal/", "/pkg/"} {
+		if i := strings.LastIndex(path, dir); i >= 0 {
+			path = path[i+1:]
+			break
+		}
+	}
+
+	for _, m := range modules {
+		if strings.HasPrefix(path, m.prefix) {
+			return m.name
+		}
+	}
+
+	return ""
+}
+
+// moduleFormatter drops entries below the level of the module logging them,
+// and formats the others using inner.
+type moduleFormatter struct {
+	inner log.Formatter
+
+	// Level of entries outside of modules or of modules without a level.
+	level  log.Level
+	levels map[string]log.Level
+}
+
+func (mf *moduleFormatter) Format(e *log.Entry) ([]byte, error) {
+
+	level := mf.level
+	if e.Caller != nil {
+		if l, ok := mf.levels[moduleOf(e.Caller.File)]; ok {
+			level = l
+		}
+
+		// Callers are only reported for finding the module.
+		e.Caller = nil
+	}
+
+	if e.Level > level {
+		return nil, nil
+	}
+
+	return mf.inner.Format(e)
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModuleOf(t *testing.T) {
+	assert.Equal(t, "bpf", moduleOf("/src/conntracct/internal/pipeline/acct.go"))
+	assert.Equal(t, "pipeline", moduleOf("github.com/ti-mo/conntracct/internal/pipeline/top.go"))
+	assert.Equal(t, "sinks", moduleOf("/src/conntracct/internal/sinks/kafka/kafka.go"))
+	assert.Equal(t, "", moduleOf("/src/conntracct/cmd/run.go"))
+}
+
+func TestModuleFormatter(t *testing.T) {
+
+	mf := &moduleFormatter{
+		inner:  &log.TextFormatter{DisableTimestamp: true},
+		level:  log.InfoLevel,
+		levels: map[string]log.Level{"sinks": log.DebugLevel, "pipeline": log.WarnLevel},
+	}
+
+	entry := func(level log.Level, file string) *log.Entry {
+		return &log.Entry{Logger: log.New(), Level: level, Message: "msg", Caller: &runtime.Frame{File: file}}
+	}
+
+	b, err := mf.Format(entry(log.DebugLevel, "/src/internal/sinks/stdout/stdout.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "msg")
+
+	b, _ = mf.Format(entry(log.InfoLevel, "/src/internal/pipeline/top.go"))
+	assert.Empty(t, b)
+
+	b, _ = mf.Format(entry(log.DebugLevel, "/src/cmd/run.go"))
+	assert.Empty(t, b)
+
+	// Callers aren't logged.
+	b, _ = mf.Format(entry(log.InfoLevel, "/src/cmd/run.go"))
+	assert.NotContains(t, string(b), "run.go")
+}
+
+func TestRotatingFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "conntracct.log")
+
+	rf, err := newRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+
+	for _, s := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := rf.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	read := func(p string) string {
+		b, err := ioutil.ReadFile(p)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// The oldest file is dropped.
+	assert.Equal(t, "dddddddd\n", read(path))
+	assert.Equal(t, "cccccccc\n", read(path+".1"))
+	assert.Equal(t, "bbbbbbbb\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestInitErrors(t *testing.T) {
+	assert.Error(t, Init(Config{Level: "loud"}))
+	assert.Error(t, Init(Config{Format: "xml"}))
+	assert.Error(t, Init(Config{Modules: map[string]string{"kernel": "debug"}}))
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file renamed to path.1 once it exceeds maxSize bytes,
+// shifting up older files up to path.<backups>.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// newRotatingFile opens the log file at path for appending.
+func newRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// open opens the log file, creating it if needed.
+func (rf *rotatingFile) open() error {
+
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f, rf.size = f, st.Size()
+
+	return nil
+}
+
+// rotate shifts the backups, moves the log file to the first backup and
+// opens a new log file. The log file is truncated without backups.
+func (rf *rotatingFile) rotate() error {
+
+	rf.f.Close()
+
+	if rf.backups == 0 {
+		if err := os.Truncate(rf.path, 0); err != nil {
+			return err
+		}
+		return rf.open()
+	}
+
+	var rerr error
+	for i := rf.backups - 1; i > 0 && rerr == nil; i-- {
+		rerr = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		if os.IsNotExist(rerr) {
+			rerr = nil
+		}
+	}
+	if rerr == nil {
+		rerr = os.Rename(rf.path, rf.path+".1")
+	}
+
+	// Keep logging to the current file if it couldn't be rotated.
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	return rerr
+}
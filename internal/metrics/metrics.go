@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const namespace = "conntracct"
+
+var (
+	eventsDesc = prometheus.NewDesc(namespace+"_pipeline_events_total",
+		"Amount of events received from the kernel.", []string{"type"}, nil)
+	bytesDesc = prometheus.NewDesc(namespace+"_pipeline_bytes_total",
+		"Amount of bytes read from the probe's buffers.", []string{"type"}, nil)
+	queueDesc = prometheus.NewDesc(namespace+"_pipeline_queue_length",
+		"Length of the pipeline's event queue.", []string{"type"}, nil)
+	pushErrorsDesc = prometheus.NewDesc(namespace+"_pipeline_push_errors_total",
+		"Amount of events sinks failed to accept.", nil, nil)
+
+	resetsDesc = prometheus.NewDesc(namespace+"_pipeline_conntrack_resets_total",
+		"Amount of conntrack table flushes and module reloads detected.", []string{"kind"}, nil)
+
+	flowCacheFlowsDesc = prometheus.NewDesc(namespace+"_flow_cache_flows",
+		"Amount of flows held by the flow table.", []string{"cache"}, nil)
+	flowCacheEvictionsDesc = prometheus.NewDesc(namespace+"_flow_cache_evictions_total",
+		"Amount of flows evicted from the flow table, by reason.", []string{"cache", "reason"}, nil)
+
+	readEventsDesc = prometheus.NewDesc(namespace+"_probe_events_read_total",
+		"Amount of events read from the probe's buffers.", nil, nil)
+	readBytesDesc = prometheus.NewDesc(namespace+"_probe_bytes_read_total",
+		"Amount of bytes read from the probe's buffers.", nil, nil)
+	deliveredDesc = prometheus.NewDesc(namespace+"_probe_consumer_delivered_total",
+		"Amount of events delivered to the pipeline's queues.", []string{"consumer"}, nil)
+	lostSamplesDesc = prometheus.NewDesc(namespace+"_probe_lost_samples_total",
+		"Amount of events dropped by the kernel due to full perf buffers.", nil, nil)
+	lostCPUDesc = prometheus.NewDesc(namespace+"_probe_cpu_lost_samples_total",
+		"Amount of events dropped by the kernel due to a full perf buffer, by CPU.", []string{"cpu"}, nil)
+	seqLostDesc = prometheus.NewDesc(namespace+"_probe_seq_lost_total",
+		"Amount of events lost between the kernel and the probe, detected by gaps in their sequence numbers.", nil, nil)
+	seqLostCPUDesc = prometheus.NewDesc(namespace+"_probe_cpu_seq_lost_total",
+		"Amount of events lost between the kernel and the probe, detected by gaps in their sequence numbers, by CPU.", []string{"cpu"}, nil)
+	lostConsumerDesc = prometheus.NewDesc(namespace+"_probe_consumer_lost_total",
+		"Amount of events dropped due to full pipeline queues.", []string{"consumer"}, nil)
+	lostKernelDesc = prometheus.NewDesc(namespace+"_probe_consumer_kernel_lost_total",
+		"Amount of events of the kind a consumer subscribes to dropped by the kernel.", []string{"consumer"}, nil)
+
+	sinkPushedDesc = prometheus.NewDesc(namespace+"_sink_events_pushed_total",
+		"Amount of events pushed into the sink.", []string{"sink"}, nil)
+	sinkDroppedDesc = prometheus.NewDesc(namespace+"_sink_events_dropped_total",
+		"Amount of events failed to be pushed into the sink.", []string{"sink"}, nil)
+	sinkErrorsDesc = prometheus.NewDesc(namespace+"_sink_event_errors_total",
+		"Amount of events the sink failed to encode.", []string{"sink"}, nil)
+	sinkQueueLenDesc = prometheus.NewDesc(namespace+"_sink_queue_length",
+		"Length of the sink's queue.", []string{"sink"}, nil)
+	sinkQueueDroppedDesc = prometheus.NewDesc(namespace+"_sink_queue_dropped_total",
+		"Amount of events dropped by the sink's queue policy.", []string{"sink"}, nil)
+	sinkSpoolBytesDesc = prometheus.NewDesc(namespace+"_sink_spool_bytes",
+		"Size of the events in the sink's on-disk spool.", []string{"sink"}, nil)
+	sinkStandbyDesc = prometheus.NewDesc(namespace+"_sink_standby",
+		"Whether the sink is an inactive member of a failover group.", []string{"sink"}, nil)
+	sinkBatchLenDesc = prometheus.NewDesc(namespace+"_sink_batch_length",
+		"Length of the sink's current batch.", []string{"sink"}, nil)
+	sinkBatchWatermarkDesc = prometheus.NewDesc(namespace+"_sink_batch_watermark",
+		"Batch length at which the sink sends its batch.", []string{"sink"}, nil)
+	sinkBatchesSentDesc = prometheus.NewDesc(namespace+"_sink_batches_sent_total",
+		"Amount of batches flushed by the sink.", []string{"sink"}, nil)
+	sinkBatchesDroppedDesc = prometheus.NewDesc(namespace+"_sink_batches_dropped_total",
+		"Amount of batches failed to be flushed by the sink.", []string{"sink"}, nil)
+	sinkEventsSentDesc = prometheus.NewDesc(namespace+"_sink_events_sent_total",
+		"Amount of events written to the sink's backend.", []string{"sink"}, nil)
+	sinkEventsLostDesc = prometheus.NewDesc(namespace+"_sink_events_lost_total",
+		"Amount of events in batches failed to be written to the sink's backend.", []string{"sink"}, nil)
+	sinkSendErrorsDesc = prometheus.NewDesc(namespace+"_sink_send_errors_total",
+		"Amount of failed write attempts of the sink, including retried ones.", []string{"sink"}, nil)
+	sinkRetriesDesc = prometheus.NewDesc(namespace+"_sink_retries_total",
+		"Amount of write attempts retried by the sink.", []string{"sink"}, nil)
+	sinkFlushLatencyDesc = prometheus.NewDesc(namespace+"_sink_flush_duration_seconds",
+		"Time taken by the sink to write a batch to its backend.", []string{"sink"}, nil)
+)
+
+// Collector is a prometheus.Collector exposing the statistics of a Pipeline,
+// its probe and its sinks. Statistics are read on every scrape.
+type Collector struct {
+	pipe *pipeline.Pipeline
+}
+
+// NewCollector returns a Collector for the given Pipeline.
+func NewCollector(p *pipeline.Pipeline) *Collector {
+	return &Collector{pipe: p}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventsDesc
+	ch <- bytesDesc
+	ch <- queueDesc
+	ch <- pushErrorsDesc
+	ch <- resetsDesc
+	ch <- flowCacheFlowsDesc
+	ch <- flowCacheEvictionsDesc
+	ch <- readEventsDesc
+	ch <- readBytesDesc
+	ch <- deliveredDesc
+	ch <- lostSamplesDesc
+	ch <- lostCPUDesc
+	ch <- seqLostDesc
+	ch <- seqLostCPUDesc
+	ch <- lostConsumerDesc
+	ch <- lostKernelDesc
+	ch <- sinkPushedDesc
+	ch <- sinkDroppedDesc
+	ch <- sinkErrorsDesc
+	ch <- sinkQueueLenDesc
+	ch <- sinkQueueDroppedDesc
+	ch <- sinkSpoolBytesDesc
+	ch <- sinkStandbyDesc
+	ch <- sinkBatchLenDesc
+	ch <- sinkBatchWatermarkDesc
+	ch <- sinkBatchesSentDesc
+	ch <- sinkBatchesDroppedDesc
+	ch <- sinkEventsSentDesc
+	ch <- sinkEventsLostDesc
+	ch <- sinkSendErrorsDesc
+	ch <- sinkRetriesDesc
+	ch <- sinkFlushLatencyDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+
+	s := c.pipe.Stats()
+
+	counter(ch, eventsDesc, s.EventsUpdate, "update")
+	counter(ch, eventsDesc, s.EventsDestroy, "destroy")
+	counter(ch, bytesDesc, s.AcctBytesUpdate, "update")
+	counter(ch, bytesDesc, s.AcctBytesDestroy, "destroy")
+	gauge(ch, queueDesc, s.AcctUpdateQueueLen, "update")
+	gauge(ch, queueDesc, s.AcctDestroyQueueLen, "destroy")
+	counter(ch, pushErrorsDesc, s.PushErrors)
+	counter(ch, resetsDesc, s.ConntrackFlushes, pipeline.ResetFlush)
+	counter(ch, resetsDesc, s.ConntrackReloads, pipeline.ResetReload)
+
+	for name, fs := range c.pipe.FlowCacheStats() {
+		gauge(ch, flowCacheFlowsDesc, fs.Flows, name)
+		counter(ch, flowCacheEvictionsDesc, fs.Evicted, name, "size")
+		counter(ch, flowCacheEvictionsDesc, fs.Expired, name, "ttl")
+	}
+
+	ps := c.pipe.ProbeStats()
+	counter(ch, readEventsDesc, ps.EventsRead)
+	counter(ch, readBytesDesc, ps.BytesRead)
+	for name, n := range ps.Delivered {
+		counter(ch, deliveredDesc, n, name)
+	}
+	counter(ch, lostSamplesDesc, ps.LostSamples)
+	for cpu, lost := range ps.LostPerCPU {
+		counter(ch, lostCPUDesc, lost, strconv.Itoa(cpu))
+	}
+	counter(ch, seqLostDesc, ps.SeqLost)
+	for cpu, lost := range ps.SeqLostPerCPU {
+		counter(ch, seqLostCPUDesc, lost, strconv.Itoa(cpu))
+	}
+	for name, lost := range ps.LostConsumer {
+		counter(ch, lostConsumerDesc, lost, name)
+	}
+	for name, lost := range ps.LostKernel {
+		counter(ch, lostKernelDesc, lost, name)
+	}
+
+	for _, sink := range c.pipe.GetSinks() {
+		ss := sink.Stats()
+		counter(ch, sinkPushedDesc, ss.EventsPushed, sink.Name())
+		counter(ch, sinkDroppedDesc, ss.EventsDropped, sink.Name())
+		counter(ch, sinkErrorsDesc, ss.EventErrors, sink.Name())
+		gauge(ch, sinkQueueLenDesc, ss.QueueLength, sink.Name())
+		counter(ch, sinkQueueDroppedDesc, ss.QueueDropped, sink.Name())
+		gauge(ch, sinkSpoolBytesDesc, ss.SpoolBytes, sink.Name())
+		var standby uint64
+		if ss.Standby {
+			standby = 1
+		}
+		gauge(ch, sinkStandbyDesc, standby, sink.Name())
+		gauge(ch, sinkBatchLenDesc, ss.BatchLength, sink.Name())
+		gauge(ch, sinkBatchWatermarkDesc, ss.BatchWatermark, sink.Name())
+		counter(ch, sinkBatchesSentDesc, ss.BatchesSent, sink.Name())
+		counter(ch, sinkBatchesDroppedDesc, ss.BatchesDropped, sink.Name())
+		counter(ch, sinkEventsSentDesc, ss.EventsSent, sink.Name())
+		counter(ch, sinkEventsLostDesc, ss.EventsLost, sink.Name())
+		counter(ch, sinkSendErrorsDesc, ss.SendErrors, sink.Name())
+		counter(ch, sinkRetriesDesc, ss.Retries, sink.Name())
+		histogram(ch, sinkFlushLatencyDesc, ss.FlushLatency, sink.Name())
+	}
+}
+
+func counter(ch chan<- prometheus.Metric, d *prometheus.Desc, v uint64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+}
+
+func gauge(ch chan<- prometheus.Metric, d *prometheus.Desc, v uint64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, float64(v), labels...)
+}
+
+// histogram converts a LatencyHistogram to a Prometheus histogram in seconds,
+// with cumulative buckets.
+func histogram(ch chan<- prometheus.Metric, d *prometheus.Desc, h types.LatencyHistogram, labels ...string) {
+
+	buckets := make(map[float64]uint64, len(types.FlushLatencyBuckets))
+
+	var n uint64
+	for i, le := range types.FlushLatencyBuckets {
+		if i < len(h.Buckets) {
+			n += h.Buckets[i]
+		}
+		buckets[le.Seconds()] = n
+	}
+
+	ch <- prometheus.MustNewConstHistogram(d, h.Count, time.Duration(h.Sum).Seconds(), buckets, labels...)
+}
+
+// ListenAndServe starts a Prometheus metrics endpoint for the given Pipeline
+// on addr, serving on /metrics.
+func ListenAndServe(addr string, p *pipeline.Pipeline) error {
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(NewCollector(p)); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+
+	log.Infof("Metrics endpoint listening on address '%s'", addr)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/generator"
+)
+
+func TestCollector(t *testing.T) {
+
+	p := pipeline.New(bpf.Config{})
+	p.SetGenerator(generator.New(generator.Config{Seed: 1}), 0, 3)
+
+	s, err := sinks.New(types.SinkConfig{Name: "out", Type: types.StdOut})
+	require.NoError(t, err)
+	require.NoError(t, p.RegisterSink(s))
+
+	// Deliver 3 generated update events.
+	require.NoError(t, p.Init())
+	require.NoError(t, p.Start())
+	<-p.Replayed()
+	require.NoError(t, p.Stop())
+
+	c := NewCollector(p)
+
+	// 9 pipeline metrics, 4 probe metrics without consumers, 16 sink metrics.
+	assert.Equal(t, 29, testutil.CollectAndCount(c))
+
+	err = testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP conntracct_pipeline_events_total Amount of events received from the kernel.
+# TYPE conntracct_pipeline_events_total counter
+conntracct_pipeline_events_total{type="destroy"} 0
+conntracct_pipeline_events_total{type="update"} 3
+`), "conntracct_pipeline_events_total")
+	assert.NoError(t, err)
+}
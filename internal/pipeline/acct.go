@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -9,6 +10,21 @@ import (
 	"github.com/ti-mo/conntracct/pkg/bpf"
 )
 
+// Maximum amount of events taken from a queue and delivered in one batch.
+const deliverBatchSize = 64
+
+// Default amount of events buffered between the probe and the pipeline's
+// update and destroy workers.
+const defaultQueueSize = 1024
+
+// queueSize returns the size of an event queue, or its default if zero.
+func queueSize(n int) int {
+	if n <= 0 {
+		return defaultQueueSize
+	}
+	return n
+}
+
 // Init initializes the pipeline. Only runs once, subsequent calls are no-ops.
 func (p *Pipeline) Init() error {
 
@@ -20,36 +36,69 @@ func (p *Pipeline) Init() error {
 	return err
 }
 
-// initAcct initializes the accounting probe and consumers.
+// initAcct initializes the accounting probe and consumers, the replay source
+// if a capture or generator is set, or the netlink source if the probe can't be loaded and
+// the fallback is enabled.
 // Should only be called once, eg. gated behind a sync.Once.
 func (p *Pipeline) initAcct() error {
-	cfg := bpf.Config{CooldownMillis: 2000}
+
+	// Store channel reference so we can launch consumers on them.
+	p.acctUpdateChan = make(chan bpf.Event, queueSize(p.updateQueueSize))
+	p.acctDestroyChan = make(chan bpf.Event, queueSize(p.destroyQueueSize))
+
+	if p.replay != nil {
+		p.acctReplay = newReplaySource(p.replay, p.replaySpeed, p.replayOffset, p.acctUpdateChan, p.acctDestroyChan)
+		return nil
+	}
 
 	// Create a new accounting probe.
-	ap, err := bpf.NewProbe(cfg)
+	ap, err := bpf.NewProbe(p.probeConfig)
 	if err != nil {
-		return errors.Wrap(err, "initializing BPF probe")
+		if !p.netlinkFallback {
+			return errors.Wrap(err, "initializing BPF probe")
+		}
+		log.Warnf("Failed to initialize BPF probe, falling back to netlink: %s", err)
+
+		n, err := newNetlinkSource(p.probeConfig, p.backpressure, p.acctUpdateChan, p.acctDestroyChan)
+		if err != nil {
+			return errors.Wrap(err, "initializing netlink source")
+		}
+		p.acctNetlink = n
+
+		return nil
 	}
 	log.Infof("Inserted probe version %s", ap.Kernel().Version)
 
-	// Store channel reference so we can launch consumers on them.
-	p.acctUpdateChan = make(chan bpf.Event, 1024)
-	p.acctDestroyChan = make(chan bpf.Event, 1024)
-
 	// Register accounting update/destroy event consumers.
 	au := bpf.NewConsumer("AcctUpdate", p.acctUpdateChan, bpf.ConsumerUpdate)
+	au.SetBackpressure(p.backpressure)
 	if err := ap.RegisterConsumer(au); err != nil {
 		return errors.Wrap(err, "registering update consumer to probe")
 	}
 	log.Debug("Registered pipeline consumer AcctUpdate")
 
 	ad := bpf.NewConsumer("AcctDestroy", p.acctDestroyChan, bpf.ConsumerDestroy)
+	ad.SetBackpressure(p.backpressure)
 	if err := ap.RegisterConsumer(ad); err != nil {
 		return errors.Wrap(err, "registering destroy consumer to probe")
 	}
 	log.Debug("Registered pipeline consumer AcctDestroy")
 
-	// Save the Probe reference to the pipeline.
+	if p.onLost != nil {
+		au.SetOnLost(p.onLost)
+		ad.SetOnLost(p.onLost)
+	}
+
+	if p.onLostSamples != nil {
+		ap.SetOnLostSamples(p.onLostSamples)
+	}
+
+	if p.onSeqGap != nil {
+		ap.SetOnSeqGap(p.onSeqGap)
+	}
+
+	// Save the Probe and Consumer references to the pipeline.
+	p.acctConsumers = []*bpf.Consumer{au, ad}
 	p.acctProbe = ap
 
 	return nil
@@ -58,89 +107,412 @@ func (p *Pipeline) initAcct() error {
 // Start starts all resources registered to the pipeline.
 func (p *Pipeline) Start() error {
 
-	if p.acctProbe == nil {
+	if p.acctProbe == nil && p.acctNetlink == nil && p.acctReplay == nil {
 		return errAcctNotInitialized
 	}
 
 	var err error
 	p.start.Do(func() {
-		err = p.startAcct()
+		_, err = p.startAcct(false)
 	})
 
 	return err
 }
 
+// TakeOver starts the pipeline like Start, its probe taking over from the
+// probe of another conntracct instance. Returns the handover time stamp to
+// be passed to HandOver of the other instance's pipeline.
+func (p *Pipeline) TakeOver() (uint64, error) {
+
+	if p.acctProbe == nil {
+		return 0, errHandoverNoProbe
+	}
+
+	var ts uint64
+	var err error
+	p.start.Do(func() {
+		ts, err = p.startAcct(true)
+	})
+
+	return ts, err
+}
+
+// HandOver makes the pipeline's probe drop events raised at or after the
+// time stamp returned by TakeOver of the instance taking over. The pipeline
+// is to be stopped afterwards, delivering the events raised before.
+func (p *Pipeline) HandOver(ts uint64) error {
+
+	if p.acctProbe == nil {
+		return errHandoverNoProbe
+	}
+	p.acctProbe.HandOver(ts)
+
+	return nil
+}
+
 // startAcct starts the Probe and starts goroutines reading Events from
-// update and destroy channels.
-func (p *Pipeline) startAcct() error {
+// update and destroy channels. If takeover is set, the Probe takes over
+// from another probe and the handover time stamp is returned.
+func (p *Pipeline) startAcct(takeover bool) (uint64, error) {
+
+	if p.workers > 1 {
+		p.startPool(p.workers)
+	}
 
 	// Start the conntracct event consumer.
+	p.acctWorkers.Add(2)
 	go p.acctUpdateWorker()
 	go p.acctDestroyWorker()
 
+	if p.aggregator != nil {
+		p.aggDone = make(chan struct{})
+		p.aggWorker.Add(1)
+		go p.aggregateWorker()
+	}
+
+	if p.staleTimeout > 0 || p.resetInterval > 0 {
+		p.flowsDone = make(chan struct{})
+	}
+	if p.staleTimeout > 0 {
+		p.flowWorkers.Add(1)
+		go p.staleWorker()
+	}
+	if p.resetInterval > 0 {
+		p.flowWorkers.Add(1)
+		go p.resetWorker()
+	}
+
+	if p.acctReplay != nil {
+		p.acctReplay.start()
+
+		log.Info("Started replaying events")
+
+		return 0, nil
+	}
+
+	if p.acctNetlink != nil {
+		if err := p.acctNetlink.start(); err != nil {
+			return 0, errors.Wrap(err, "starting netlink source")
+		}
+
+		log.Info("Started netlink event source and workers")
+
+		return 0, nil
+	}
+
 	// Start the Probe.
-	if err := p.acctProbe.Start(); err != nil {
-		return errors.Wrap(err, "starting Probe")
+	var ts uint64
+	var err error
+	if takeover {
+		ts, err = p.acctProbe.TakeOver()
+	} else {
+		err = p.acctProbe.Start()
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "starting Probe")
+	}
+
+	if ok, err := p.acctProbe.Trampolines(); ok {
+		log.Info("Attached probe through fentry/fexit trampolines")
+	} else if err != nil {
+		log.Infof("Attached probe through kprobes, tracing programs not supported: %s", err)
 	}
 
 	log.Info("Started accounting probe and workers")
 
-	return nil
+	return ts, nil
+}
+
+// stopAcct stops the probe, netlink or replay source and closes the event channels,
+// so the workers exit after draining them.
+func (p *Pipeline) stopAcct() error {
+
+	if p.acctReplay != nil {
+		p.acctReplay.stop()
+		close(p.acctUpdateChan)
+		close(p.acctDestroyChan)
+
+		return nil
+	}
+
+	if p.acctNetlink != nil {
+		err := p.acctNetlink.stop()
+		close(p.acctUpdateChan)
+		close(p.acctDestroyChan)
+
+		return errors.Wrap(err, "stopping netlink source")
+	}
+
+	// Stop the accounting probe. A probe failing to detach its kprobes, eg.
+	// without root, has still delivered all of its events.
+	serr := p.acctProbe.Stop()
+
+	// Close the consumers' event channels, even if removing one fails,
+	// so the pipeline's workers are never left waiting on them.
+	for _, c := range p.acctConsumers {
+		if err := p.acctProbe.RemoveConsumer(c); err != nil && serr == nil {
+			serr = errors.Wrapf(err, "removing consumer '%s'", c.Name())
+		}
+		c.Close()
+	}
+
+	return serr
 }
 
-// acctUpdateWorker reads from the pipeline's update event channel
-// and delivers events to all registered sinks listening for update events.
-// This code closely resembles acctDestroyWorker due to this being in the hot
-// path, avoiding as much branching and unnecessary work as possible.
+// acctUpdateWorker reads from the pipeline's update event channel and
+// delivers events to all registered sinks listening for update events, or
+// hands them to the fanout worker of their flow if the pipeline has a pool.
+// Events queued in the channel are delivered in batches.
 func (p *Pipeline) acctUpdateWorker() {
+	defer p.acctWorkers.Done()
+	r := p.newReader()
 	for {
 		ae, ok := <-p.acctUpdateChan
 		if !ok {
-			log.Debug("Pipeline's update event channel closed, stopping worker.")
 			break
 		}
+		ok = r.drain(ae, p.acctUpdateChan)
 
 		// Record pipeline statistics.
-		atomic.AddUint64(&p.Stats.EventsTotal, 1)
-		atomic.AddUint64(&p.Stats.AcctBytesTotal, bpf.EventLength)
-		atomic.AddUint64(&p.Stats.EventsUpdate, 1)
-		atomic.AddUint64(&p.Stats.AcctBytesUpdate, bpf.EventLength)
-		atomic.StoreUint64(&p.Stats.AcctUpdateQueueLen, uint64(len(p.acctUpdateChan)))
-
-		// Fan out to all registered accounting sinks.
-		p.acctSinkMu.RLock()
-		for _, s := range p.acctSinks {
-			if s.WantUpdate() {
-				s.Push(ae)
-			}
+		atomic.AddUint64(&r.stats.eventsUpdate, uint64(len(r.batch)))
+		atomic.StoreUint32(&r.stats.updateQueueLen, uint32(len(p.acctUpdateChan)))
+
+		p.process(r)
+
+		if !ok {
+			break
 		}
-		p.acctSinkMu.RUnlock()
 	}
+	log.Debug("Pipeline's update event channel closed, stopping worker.")
 }
 
 // acctDestroyWorker is a copy of acctUpdateWorker, but for destroy events.
 func (p *Pipeline) acctDestroyWorker() {
+	defer p.acctWorkers.Done()
+	r := p.newReader()
 	for {
 		ae, ok := <-p.acctDestroyChan
 		if !ok {
-			log.Debug("Pipeline's destroy event channel closed, stopping worker.")
 			break
 		}
+		ok = r.drain(ae, p.acctDestroyChan)
 
 		// Record pipeline statistics.
-		atomic.AddUint64(&p.Stats.EventsTotal, 1)
-		atomic.AddUint64(&p.Stats.AcctBytesTotal, bpf.EventLength)
-		atomic.AddUint64(&p.Stats.EventsDestroy, 1)
-		atomic.AddUint64(&p.Stats.AcctBytesDestroy, bpf.EventLength)
-		atomic.StoreUint64(&p.Stats.AcctDestroyQueueLen, uint64(len(p.acctDestroyChan)))
-
-		// Fan out to all registered accounting sinks.
-		p.acctSinkMu.RLock()
-		for _, s := range p.acctSinks {
-			if s.WantDestroy() {
-				s.Push(ae)
+		atomic.AddUint64(&r.stats.eventsDestroy, uint64(len(r.batch)))
+		atomic.StoreUint32(&r.stats.destroyQueueLen, uint32(len(p.acctDestroyChan)))
+
+		// Flag the events' counters as the flows' totals.
+		for i := range r.batch {
+			e := &r.batch[i]
+			e.Destroy = true
+			e.Duration = p.flowDuration(*e)
+		}
+
+		p.process(r)
+
+		if !ok {
+			break
+		}
+	}
+	log.Debug("Pipeline's destroy event channel closed, stopping worker.")
+}
+
+// drain fills the batch of r with e and the events queued behind it in ch,
+// up to deliverBatchSize events. Returns false if ch was closed.
+func (r *reader) drain(e bpf.Event, ch <-chan bpf.Event) bool {
+
+	r.batch = append(r.batch[:0], e)
+
+	for len(r.batch) < deliverBatchSize {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			r.batch = append(r.batch, e)
+		default:
+			return true
+		}
+	}
+
+	return true
+}
+
+// process delivers the batch of r, or hands its events to the fanout
+// workers of their flows if the pipeline has a pool.
+func (p *Pipeline) process(r *reader) {
+
+	if p.pool == nil {
+		p.deliver(r, r.batch)
+		return
+	}
+
+	for _, e := range r.batch {
+		p.pool.dispatch(e)
+	}
+	clear(r.batch)
+}
+
+// deliver runs a batch of events through the pipeline's stages and fans them
+// out to all registered sinks listening for their kind of events. This is the
+// hot path, avoiding as much branching and unnecessary work as possible.
+// The stages are used without locking, through the reader r.
+//
+// Events are run through the stages one at a time, in place, and pushed
+// into each sink as a batch. es is cleared when done, so it doesn't pin the
+// events' addresses and tags. Stages retaining an event beyond the call must
+// retain a Clone, as its addresses are shared with the decoder.
+func (p *Pipeline) deliver(r *reader, es []bpf.Event) {
+
+	st := p.acquire(r)
+	defer r.release()
+
+	defer clear(es)
+
+	// Amount of events left after the stages, and of destroy events among them.
+	var n, destroys int
+
+	for i := range es {
+		e := &es[i]
+
+		// Drop unwanted events before spending any work on them.
+		if st.input != nil && !st.input.match(*e) {
+			atomic.AddUint64(&r.stats.eventsFiltered, 1)
+			continue
+		}
+
+		if p.stale != nil {
+			p.stale.update(*e, e.Destroy)
+		}
+
+		// Attach metadata to the event.
+		for _, en := range st.enrichers {
+			en.Enrich(e)
+		}
+
+		var ok bool
+		if *e, ok = runMiddleware(st.middleware, *e); !ok {
+			continue
+		}
+
+		if p.rates != nil {
+			p.rates.update(e, e.Destroy)
+		}
+
+		if p.top != nil {
+			p.top.update(*e, e.Destroy)
+		}
+
+		pushTaps(st.taps, *e)
+
+		// Sinks receive rollups instead when aggregating.
+		if p.aggregator != nil {
+			p.aggregator.add(*e, e.Destroy)
+			continue
+		}
+
+		if e.Destroy {
+			destroys++
+		}
+		if n != i {
+			es[n] = *e
+		}
+		n++
+	}
+
+	if n == 0 {
+		return
+	}
+	out := es[:n]
+
+	// Fan out to all registered accounting sinks.
+	for _, s := range st.sinks {
+		update, destroy := s.WantUpdate(), s.WantDestroy()
+
+		ses := out
+		switch {
+		case update && destroy:
+		// Skip batches without any of the sink's kind of events.
+		case !update && !destroy, !update && destroys == 0, !destroy && destroys == n:
+			continue
+		// Select the sink's kind of events from a mixed batch.
+		case destroys != 0 && destroys != n:
+			r.sel = r.sel[:0]
+			for _, e := range out {
+				if e.Destroy == destroy {
+					r.sel = append(r.sel, e)
+				}
 			}
+			ses = r.sel
+		}
+
+		if dropped, _ := s.PushBatch(ses); dropped != 0 {
+			atomic.AddUint64(&r.stats.pushErrors, uint64(dropped))
+		}
+	}
+
+	clear(r.sel)
+}
+
+// aggregateWorker pushes the aggregator's rollups into the sinks
+// on every interval until aggDone is closed.
+func (p *Pipeline) aggregateWorker() {
+
+	defer p.aggWorker.Done()
+
+	r := p.newReader()
+
+	t := time.NewTicker(p.aggregator.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			p.pushRollups(r)
+		case <-p.aggDone:
+			return
+		}
+	}
+}
+
+// pushRollups pushes the aggregator's rollups into all sinks
+// listening for update events, using the stages through the reader r.
+func (p *Pipeline) pushRollups(r *reader) {
+
+	ts, _ := ktime() // CLOCK_MONOTONIC is always supported
+
+	rs := p.aggregator.flush(ts)
+
+	st := p.acquire(r)
+	defer r.release()
+
+	if len(rs) == 0 {
+		return
+	}
+
+	for _, s := range st.sinks {
+		if !s.WantUpdate() {
+			continue
+		}
+		if dropped, _ := s.PushBatch(rs); dropped != 0 {
+			atomic.AddUint64(&r.stats.pushErrors, uint64(dropped))
 		}
-		p.acctSinkMu.RUnlock()
 	}
 }
+
+// flowDuration returns the time between the start of an event's flow and
+// the event. Returns zero if conntrack didn't record the flow's start.
+func (p *Pipeline) flowDuration(e bpf.Event) time.Duration {
+
+	if e.Start == 0 {
+		return 0
+	}
+
+	d := e.Time(p.clock.Boot()).Sub(time.Unix(0, int64(e.Start)))
+	if d < 0 {
+		// Clocks can be slightly off due to the boot time estimate.
+		return 0
+	}
+
+	return d
+}
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Flows that haven't been seen for this long are forgotten by the aggregator,
+// in case their destroy event was lost.
+const aggFlowTimeout = time.Hour
+
+// AggregateConfig configures the pipeline's aggregation stage, which sums up
+// the traffic of flows with the same key and periodically emits one update
+// event per key instead of forwarding every flow's events.
+type AggregateConfig struct {
+
+	// Fields making up the key of a rollup record. One of src_addr, dst_addr,
+	// src_port, dst_port, proto, family, connmark, netns, zone or tag:<name>.
+	// Addresses can be truncated to a prefix, eg. dst_addr/24 or
+	// dst_addr/24/64 for IPv4 /24s and IPv6 /64s. IPv6 addresses are kept
+	// whole if no IPv6 prefix length is given.
+	Keys []string
+
+	// Interval between rollups.
+	Interval time.Duration
+}
+
+// aggKey is the key of a rollup record. Fields not in the key are zero.
+type aggKey struct {
+	srcAddr, dstAddr [16]byte
+	srcPort, dstPort uint16
+	proto, family    uint8
+	connmark, netns  uint32
+	zone             uint16
+
+	// Key tags as a sorted list of name=value pairs.
+	tags string
+}
+
+// aggFlow holds a flow's counters at its most recent event.
+type aggFlow struct {
+	packetsOrig, bytesOrig uint64
+	packetsRet, bytesRet   uint64
+	seen                   time.Time
+}
+
+// aggregator accumulates the traffic of all flows by key.
+type aggregator struct {
+	srcAddr, dstAddr addrKey
+	fields           map[string]bool
+	tags             []string
+
+	interval time.Duration
+
+	mu      sync.Mutex
+	flows   map[uint32]*aggFlow
+	rollups map[aggKey]*bpf.Event
+}
+
+// addrKey describes whether and how an address is part of the key.
+type addrKey struct {
+	enabled  bool
+	v4, v6   net.IPMask
+	keepIPv6 bool
+}
+
+// newAggregator returns an aggregator for the given configuration.
+func newAggregator(cfg AggregateConfig) (*aggregator, error) {
+
+	if cfg.Interval <= 0 {
+		return nil, errAggInterval
+	}
+
+	a := &aggregator{
+		fields:   make(map[string]bool),
+		interval: cfg.Interval,
+		flows:    make(map[uint32]*aggFlow),
+		rollups:  make(map[aggKey]*bpf.Event),
+	}
+
+	for _, k := range cfg.Keys {
+		switch {
+		case strings.HasPrefix(k, "src_addr"):
+			ak, err := parseAddrKey(strings.TrimPrefix(k, "src_addr"))
+			if err != nil {
+				return nil, fmt.Errorf("aggregation key '%s': %v", k, err)
+			}
+			a.srcAddr = ak
+		case strings.HasPrefix(k, "dst_addr"):
+			ak, err := parseAddrKey(strings.TrimPrefix(k, "dst_addr"))
+			if err != nil {
+				return nil, fmt.Errorf("aggregation key '%s': %v", k, err)
+			}
+			a.dstAddr = ak
+		case strings.HasPrefix(k, "tag:") && len(k) > len("tag:"):
+			a.tags = append(a.tags, strings.TrimPrefix(k, "tag:"))
+		case k == "src_port", k == "dst_port", k == "proto", k == "family",
+			k == "connmark", k == "netns", k == "zone":
+			a.fields[k] = true
+		default:
+			return nil, fmt.Errorf("unknown aggregation key '%s'", k)
+		}
+	}
+
+	sort.Strings(a.tags)
+
+	return a, nil
+}
+
+// parseAddrKey parses the prefix lengths of an address key, eg. '/24/64'.
+func parseAddrKey(s string) (addrKey, error) {
+
+	ak := addrKey{enabled: true, keepIPv6: true}
+	if s == "" {
+		return ak, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(s, "/"), "/")
+	if !strings.HasPrefix(s, "/") || len(parts) > 2 {
+		return ak, errAggPrefix
+	}
+
+	v4, err := strconv.Atoi(parts[0])
+	if err != nil || v4 < 0 || v4 > 32 {
+		return ak, errAggPrefix
+	}
+	ak.v4 = net.CIDRMask(v4, 32)
+
+	if len(parts) == 2 {
+		v6, err := strconv.Atoi(parts[1])
+		if err != nil || v6 < 0 || v6 > 128 {
+			return ak, errAggPrefix
+		}
+		ak.v6 = net.CIDRMask(v6, 128)
+		ak.keepIPv6 = false
+	}
+
+	return ak, nil
+}
+
+// add accounts the traffic of a flow since its previous event. The flow
+// is forgotten after its destroy event.
+func (a *aggregator) add(e bpf.Event, destroy bool) {
+
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Counters are cumulative, only add the difference to the previous event.
+	f, ok := a.flows[e.ConnectionID]
+	if !ok || e.BytesOrig < f.bytesOrig || e.BytesRet < f.bytesRet {
+		f = &aggFlow{}
+	}
+
+	if e.PacketsOrig != f.packetsOrig || e.PacketsRet != f.packetsRet {
+		r := a.rollup(e)
+		r.PacketsOrig += e.PacketsOrig - f.packetsOrig
+		r.BytesOrig += e.BytesOrig - f.bytesOrig
+		r.PacketsRet += e.PacketsRet - f.packetsRet
+		r.BytesRet += e.BytesRet - f.bytesRet
+	}
+
+	if destroy {
+		delete(a.flows, e.ConnectionID)
+		return
+	}
+
+	f.packetsOrig, f.bytesOrig = e.PacketsOrig, e.BytesOrig
+	f.packetsRet, f.bytesRet = e.PacketsRet, e.BytesRet
+	f.seen = now
+	a.flows[e.ConnectionID] = f
+}
+
+// rollup returns the rollup record of the key of the given event,
+// creating it if needed. mu must be held.
+func (a *aggregator) rollup(e bpf.Event) *bpf.Event {
+
+	var k aggKey
+	var tags []string
+
+	if a.srcAddr.enabled {
+		copy(k.srcAddr[:], a.srcAddr.mask(e.SrcAddr, e.Family))
+	}
+	if a.dstAddr.enabled {
+		copy(k.dstAddr[:], a.dstAddr.mask(e.DstAddr, e.Family))
+	}
+	if a.fields["src_port"] {
+		k.srcPort = e.SrcPort
+	}
+	if a.fields["dst_port"] {
+		k.dstPort = e.DstPort
+	}
+	if a.fields["proto"] {
+		k.proto = e.Proto
+	}
+	if a.fields["family"] || a.srcAddr.enabled || a.dstAddr.enabled {
+		k.family = e.Family
+	}
+	if a.fields["connmark"] {
+		k.connmark = e.Connmark
+	}
+	if a.fields["netns"] {
+		k.netns = e.NetNS
+	}
+	if a.fields["zone"] {
+		k.zone = e.Zone
+	}
+	for _, t := range a.tags {
+		if v, ok := e.Tags[t]; ok {
+			tags = append(tags, t+"="+v)
+		}
+	}
+	k.tags = strings.Join(tags, ",")
+
+	if r, ok := a.rollups[k]; ok {
+		return r
+	}
+
+	r := &bpf.Event{
+		SrcPort:  k.srcPort,
+		DstPort:  k.dstPort,
+		Proto:    k.proto,
+		Family:   k.family,
+		Connmark: k.connmark,
+		NetNS:    k.netns,
+		Zone:     k.zone,
+
+		// All flows of a source are sampled at the same rate.
+		SampleRate: e.SampleRate,
+	}
+	if a.srcAddr.enabled {
+		r.SrcAddr = net.IP(append([]byte(nil), k.srcAddr[:]...))
+	}
+	if a.dstAddr.enabled {
+		r.DstAddr = net.IP(append([]byte(nil), k.dstAddr[:]...))
+	}
+	for _, t := range a.tags {
+		if v, ok := e.Tags[t]; ok {
+			r.SetTag(t, v)
+		}
+	}
+
+	a.rollups[k] = r
+
+	return r
+}
+
+// mask truncates an address to the key's prefix length of its family.
+func (ak addrKey) mask(ip net.IP, family uint8) net.IP {
+
+	ip = ip.To16()
+	if ip == nil {
+		return net.IPv6zero
+	}
+
+	if family == bpf.FamilyIPv4 {
+		if ak.v4 == nil {
+			return ip
+		}
+		return ip.To4().Mask(ak.v4).To16()
+	}
+
+	if ak.keepIPv6 {
+		return ip
+	}
+	return ip.Mask(ak.v6)
+}
+
+// flush returns the rollup records accumulated since the previous flush,
+// stamped with the given event timestamp, and forgets idle flows.
+func (a *aggregator) flush(ts uint64) []bpf.Event {
+
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]bpf.Event, 0, len(a.rollups))
+	for _, r := range a.rollups {
+		r.Timestamp = ts
+		out = append(out, *r)
+	}
+	a.rollups = make(map[aggKey]*bpf.Event, len(a.rollups))
+
+	for id, f := range a.flows {
+		if now.Sub(f.seen) > aggFlowTimeout {
+			delete(a.flows, id)
+		}
+	}
+
+	return out
+}
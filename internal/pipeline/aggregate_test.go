@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestAggregator(t *testing.T) {
+
+	a, err := newAggregator(AggregateConfig{
+		Keys:     []string{"dst_addr/24", "proto", "tag:pod"},
+		Interval: time.Second,
+	})
+	require.NoError(t, err)
+
+	ev := func(id uint32, dst net.IP, bytes uint64) bpf.Event {
+		return bpf.Event{
+			ConnectionID: id, DstAddr: dst, Proto: 6, Family: bpf.FamilyIPv4,
+			PacketsOrig: bytes / 10, BytesOrig: bytes, SrcPort: uint16(id),
+			Tags: map[string]string{"pod": "web", "node": "a"},
+		}
+	}
+
+	// Two flows to the same /24, counters are cumulative.
+	a.add(ev(1, net.IPv4(10, 0, 0, 1), 100), false)
+	a.add(ev(1, net.IPv4(10, 0, 0, 1), 300), false)
+	a.add(ev(2, net.IPv4(10, 0, 0, 2), 50), true)
+	a.add(ev(3, net.IPv4(10, 0, 1, 1), 20), false)
+
+	rs := a.flush(42)
+	require.Len(t, rs, 2)
+
+	byDst := make(map[string]bpf.Event)
+	for _, r := range rs {
+		byDst[r.DstAddr.String()] = r
+	}
+
+	r := byDst["10.0.0.0"]
+	assert.EqualValues(t, 350, r.BytesOrig)
+	assert.EqualValues(t, 35, r.PacketsOrig)
+	assert.EqualValues(t, 6, r.Proto)
+	assert.EqualValues(t, 42, r.Timestamp)
+	assert.Zero(t, r.SrcPort)
+	assert.Equal(t, map[string]string{"pod": "web"}, r.Tags)
+	assert.EqualValues(t, 20, byDst["10.0.1.0"].BytesOrig)
+
+	// Only traffic since the previous flush is rolled up.
+	a.add(ev(1, net.IPv4(10, 0, 0, 1), 400), true)
+	a.add(ev(3, net.IPv4(10, 0, 1, 1), 20), false)
+
+	rs = a.flush(43)
+	require.Len(t, rs, 1)
+	assert.EqualValues(t, 100, rs[0].BytesOrig)
+	assert.Len(t, a.flows, 1)
+
+	_, err = newAggregator(AggregateConfig{Keys: []string{"dst_addr/33"}, Interval: time.Second})
+	assert.Error(t, err)
+	_, err = newAggregator(AggregateConfig{Keys: []string{"bogus"}, Interval: time.Second})
+	assert.Error(t, err)
+}
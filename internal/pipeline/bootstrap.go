@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/ti-mo/conntrack"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Bootstrap dumps the conntrack table over netlink and pushes an update event
+// for every flow into the pipeline, so flows that started before the probe was
+// loaded don't go unaccounted until their next update. Only flows in the
+// network namespace of the process are dumped. Must be called after Start.
+//
+// The connection IDs of these events are the ones reported by netlink, which
+// may differ from the IDs in events sent by the probe.
+func (p *Pipeline) Bootstrap() error {
+
+	if p.acctProbe == nil && p.acctNetlink == nil {
+		return errAcctNotInitialized
+	}
+
+	netns, err := netnsInode()
+	if err != nil {
+		return err
+	}
+
+	ts, err := ktime()
+	if err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return errors.Wrap(err, "opening conntrack connection")
+	}
+	defer c.Close()
+
+	flows, err := c.Dump(nil)
+	if err != nil {
+		return errors.Wrap(err, "dumping conntrack table")
+	}
+
+	var n int
+	for _, f := range flows {
+		e := flowEvent(f)
+		e.Timestamp = ts
+		e.NetNS = netns
+
+		if !p.probeConfig.MatchUpdate(e) || !bpf.Sampled(e.ConnectionID, p.probeConfig.SampleRate) {
+			continue
+		}
+		if p.probeConfig.SampleRate > 1 {
+			e.SampleRate = p.probeConfig.SampleRate
+		}
+
+		p.acctUpdateChan <- e
+		n++
+	}
+
+	log.Infof("Bootstrapped %d flows from the conntrack table", n)
+
+	return nil
+}
+
+// flowEvent converts a conntrack flow into an update Event.
+func flowEvent(f conntrack.Flow) bpf.Event {
+
+	e := bpf.Event{
+		ConnectionID: f.ID,
+		Connmark:     f.Mark,
+		SrcAddr:      ip(f.TupleOrig.IP.SourceAddress),
+		DstAddr:      ip(f.TupleOrig.IP.DestinationAddress),
+		PacketsOrig:  f.CountersOrig.Packets,
+		BytesOrig:    f.CountersOrig.Bytes,
+		PacketsRet:   f.CountersReply.Packets,
+		BytesRet:     f.CountersReply.Bytes,
+		Proto:        f.TupleOrig.Proto.Protocol,
+		Family:       bpf.FamilyIPv6,
+		Zone:         f.Zone,
+	}
+
+	if f.TupleOrig.IP.SourceAddress.Is4() {
+		e.Family = bpf.FamilyIPv4
+	}
+
+	// Only set ports for protocols that have them, like the probe.
+	switch {
+	case e.HasPorts():
+		e.SrcPort = f.TupleOrig.Proto.SourcePort
+		e.DstPort = f.TupleOrig.Proto.DestinationPort
+	case e.ICMP():
+		e.ICMPID = f.TupleOrig.Proto.ICMPID
+		e.ICMPType = f.TupleOrig.Proto.ICMPType
+		e.ICMPCode = f.TupleOrig.Proto.ICMPCode
+	}
+
+	if f.ProtoInfo.TCP != nil {
+		e.TCPState = f.ProtoInfo.TCP.State
+	}
+
+	// Only set when the conntrack timestamp extension is enabled.
+	if !f.Timestamp.Start.IsZero() {
+		e.Start = uint64(f.Timestamp.Start.UnixNano())
+	}
+
+	return e
+}
+
+// ip converts a netip.Addr into a 16-byte net.IP.
+func ip(a netip.Addr) net.IP {
+	b := a.As16()
+	return net.IP(b[:])
+}
@@ -2,7 +2,25 @@ package pipeline
 
 import "errors"
 
+const errFmtStalled = "probe's buffers weren't read for %s"
+
 var (
 	errAcctNotInitialized = errors.New("accounting not yet initialized")
 	errSinkNotInit        = errors.New("sink must be initialized before registering with pipeline")
+	errAggInterval        = errors.New("aggregation interval must be positive")
+	errAggPrefix          = errors.New("invalid prefix length")
+	errTopOrder           = errors.New("unknown top flow order")
+	errMiddlewareName     = errors.New("empty middleware name")
+	errHandoverNoProbe    = errors.New("handover requires the BPF probe as event source")
+
+	// ErrTopDisabled is returned when querying the flows of a pipeline
+	// without a live flow table.
+	ErrTopDisabled = errors.New("live flow table not enabled")
+
+	// ErrSinkExists is returned when registering a sink with the name of a registered sink.
+	ErrSinkExists = errors.New("a sink with this name is already registered")
+	// ErrSinkNotFound is returned when removing a sink that is not registered.
+	ErrSinkNotFound = errors.New("sink not found")
+	// ErrMiddlewareExists is returned when registering middleware with the name of registered middleware.
+	ErrMiddlewareExists = errors.New("middleware with this name is already registered")
 )
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// InputFilter holds the expressions selecting the events entering the
+// pipeline, in the syntax of sink filters. Events are dropped before being
+// enriched unless they match Include, and dropped if they match Exclude.
+// Empty expressions are not evaluated. Since enrichers haven't run yet,
+// the expressions can't refer to tags.
+type InputFilter struct {
+	Include string `json:"include"`
+	Exclude string `json:"exclude"`
+}
+
+// inputFilter is a compiled InputFilter.
+type inputFilter struct {
+	include *filter.Filter
+	exclude *filter.Filter
+}
+
+// newInputFilter compiles the expressions of f. Returns nil if both are empty.
+func newInputFilter(f InputFilter) (*inputFilter, error) {
+
+	if f.Include == "" && f.Exclude == "" {
+		return nil, nil
+	}
+
+	var in inputFilter
+	var err error
+
+	if f.Include != "" {
+		if in.include, err = filter.New(f.Include); err != nil {
+			return nil, errors.Wrap(err, "include")
+		}
+	}
+
+	if f.Exclude != "" {
+		if in.exclude, err = filter.New(f.Exclude); err != nil {
+			return nil, errors.Wrap(err, "exclude")
+		}
+	}
+
+	return &in, nil
+}
+
+// match returns true if the Event is let into the pipeline.
+func (in *inputFilter) match(e bpf.Event) bool {
+
+	if in.include != nil && !in.include.Match(e) {
+		return false
+	}
+
+	if in.exclude != nil && in.exclude.Match(e) {
+		return false
+	}
+
+	return true
+}
+
+// SetInputFilter replaces the filter selecting the events entering the
+// pipeline. The running filter is kept if an expression fails to compile.
+// Safe to call while the pipeline is running.
+func (p *Pipeline) SetInputFilter(f InputFilter) error {
+
+	in, err := newInputFilter(f)
+	if err != nil {
+		return errors.Wrap(err, "compiling input filter")
+	}
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	p.inputCfg, p.input = f, in
+	p.publish()
+
+	if in == nil {
+		log.Info("Cleared pipeline input filter")
+	} else {
+		log.Infof("Set pipeline input filter, include: '%s', exclude: '%s'", f.Include, f.Exclude)
+	}
+
+	return nil
+}
+
+// InputFilter returns the filter selecting the events entering the pipeline.
+func (p *Pipeline) InputFilter() InputFilter {
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	return p.inputCfg
+}
@@ -0,0 +1,43 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestInputFilter(t *testing.T) {
+
+	p := &Pipeline{}
+	s := &countSink{name: "count"}
+	require.NoError(t, p.RegisterSink(s))
+
+	f := InputFilter{
+		Include: `proto == 6`,
+		Exclude: `dst_port == 22 || bitand(connmark, 0xff) == 1`,
+	}
+	require.NoError(t, p.SetInputFilter(f))
+	assert.Equal(t, f, p.InputFilter())
+
+	r := p.newReader()
+	p.deliver(r, []bpf.Event{
+		{Proto: 6, DstPort: 443},
+		{Proto: 17, DstPort: 53},
+		{Proto: 6, DstPort: 22},
+		{Proto: 6, DstPort: 80, Connmark: 0x101},
+	})
+	assert.Equal(t, 1, s.n)
+	assert.EqualValues(t, 3, p.Stats().EventsFiltered)
+
+	// Invalid expressions leave the running filter in place.
+	assert.Error(t, p.SetInputFilter(InputFilter{Include: `proto ==`}))
+	assert.Equal(t, f, p.InputFilter())
+
+	// Clearing the filter lets all events in.
+	require.NoError(t, p.SetInputFilter(InputFilter{}))
+	p.deliver(r, []bpf.Event{{Proto: 17}})
+	assert.Equal(t, 2, s.n)
+}
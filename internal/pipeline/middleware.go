@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Middleware is a stage of the pipeline between the probe and the sinks.
+// It is called with every event after enrichment and returns the event to
+// pass on to the next stage, or false to drop it. Middleware is called from
+// the pipeline's update and destroy workers concurrently, so it must be
+// thread-safe, and should not block.
+type Middleware func(bpf.Event) (bpf.Event, bool)
+
+// MiddlewareStats holds statistics about a middleware stage.
+type MiddlewareStats struct {
+	Name string `json:"name"`
+
+	// Amount of events the stage was called with and amount of events it dropped.
+	Events  uint64 `json:"events"`
+	Dropped uint64 `json:"dropped"`
+
+	// Total time spent in the stage.
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// middlewareStage is a Middleware registered to the pipeline.
+type middlewareStage struct {
+	name string
+	fn   Middleware
+
+	events   uint64
+	dropped  uint64
+	duration int64
+}
+
+// RegisterMiddleware registers a Middleware to the pipeline under the given
+// name. Middleware runs in order of registration, after the enrichers and
+// before the event reaches taps, rate tracking, aggregation and sinks.
+func (p *Pipeline) RegisterMiddleware(name string, m Middleware) error {
+
+	if name == "" {
+		return errMiddlewareName
+	}
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	for _, s := range p.middleware {
+		if s.name == name {
+			return ErrMiddlewareExists
+		}
+	}
+
+	p.middleware = append(p.middleware, &middlewareStage{name: name, fn: m})
+	p.publish()
+
+	log.Infof("Registered middleware '%s' to pipeline", name)
+
+	return nil
+}
+
+// MiddlewareStats returns the statistics of the pipeline's middleware
+// stages in order of execution.
+func (p *Pipeline) MiddlewareStats() []MiddlewareStats {
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	out := make([]MiddlewareStats, 0, len(p.middleware))
+	for _, s := range p.middleware {
+		out = append(out, MiddlewareStats{
+			Name:     s.name,
+			Events:   atomic.LoadUint64(&s.events),
+			Dropped:  atomic.LoadUint64(&s.dropped),
+			Duration: time.Duration(atomic.LoadInt64(&s.duration)),
+		})
+	}
+
+	return out
+}
+
+// runMiddleware passes the event through the given middleware stages.
+// Returns false if a stage dropped it.
+func runMiddleware(ms []*middlewareStage, e bpf.Event) (bpf.Event, bool) {
+
+	for _, s := range ms {
+		start := time.Now()
+
+		var ok bool
+		e, ok = s.fn(e)
+
+		atomic.AddInt64(&s.duration, int64(time.Since(start)))
+		atomic.AddUint64(&s.events, 1)
+
+		if !ok {
+			atomic.AddUint64(&s.dropped, 1)
+			return e, false
+		}
+	}
+
+	return e, true
+}
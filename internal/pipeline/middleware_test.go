@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	p := &Pipeline{}
+
+	require.NoError(t, p.RegisterMiddleware("drop-dns", func(e bpf.Event) (bpf.Event, bool) {
+		return e, e.DstPort != 53
+	}))
+	require.NoError(t, p.RegisterMiddleware("tag", func(e bpf.Event) (bpf.Event, bool) {
+		e.SetTag("seen", "yes")
+		return e, true
+	}))
+
+	assert.Equal(t, ErrMiddlewareExists, p.RegisterMiddleware("tag", nil))
+	assert.Equal(t, errMiddlewareName, p.RegisterMiddleware("", nil))
+
+	e, ok := runMiddleware(p.middleware, bpf.Event{DstPort: 443})
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"seen": "yes"}, e.Tags)
+
+	// Dropped events don't reach later stages.
+	e, ok = runMiddleware(p.middleware, bpf.Event{DstPort: 53})
+	assert.False(t, ok)
+	assert.Nil(t, e.Tags)
+
+	s := p.MiddlewareStats()
+	require.Len(t, s, 2)
+
+	assert.Equal(t, "drop-dns", s[0].Name)
+	assert.EqualValues(t, 2, s[0].Events)
+	assert.EqualValues(t, 1, s[0].Dropped)
+
+	assert.Equal(t, "tag", s[1].Name)
+	assert.EqualValues(t, 1, s[1].Events)
+	assert.Zero(t, s[1].Dropped)
+}
@@ -0,0 +1,199 @@
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/netfilter"
+	"golang.org/x/sys/unix"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// netlinkSource receives conntrack events over netlink and delivers them to
+// the pipeline's event channels. Used instead of the BPF probe when it can't
+// be loaded. Conntrack only sends update events on flow state changes, so
+// counters are updated less often than with the probe.
+type netlinkSource struct {
+	conn   *conntrack.Conn
+	events chan conntrack.Event
+	errs   chan error
+
+	update  chan<- bpf.Event
+	destroy chan<- bpf.Event
+
+	// Wait for the pipeline instead of dropping events when its queues are full.
+	block bool
+
+	// Network namespace all flows are received from.
+	netns uint32
+
+	// Allowlists applied to the events, holds a bpf.Config.
+	config atomic.Value
+
+	// Amount of netlink errors, eg. due to full socket buffers, and events
+	// dropped due to full pipeline queues. Accessed atomically.
+	errors      uint64
+	lostUpdate  uint64
+	lostDestroy uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newNetlinkSource opens a netlink connection for receiving conntrack events
+// into the given channels, handling full channels according to bp.
+func newNetlinkSource(cfg bpf.Config, bp bpf.Backpressure, update, destroy chan<- bpf.Event) (*netlinkSource, error) {
+
+	netns, err := netnsInode()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening conntrack connection")
+	}
+
+	s := &netlinkSource{
+		conn:    c,
+		events:  make(chan conntrack.Event, 1024),
+		update:  update,
+		destroy: destroy,
+		block:   bp == bpf.BackpressureBlock,
+		netns:   netns,
+		done:    make(chan struct{}),
+	}
+	s.config.Store(cfg)
+
+	return s, nil
+}
+
+// start subscribes to conntrack events and starts delivering them.
+func (s *netlinkSource) start() error {
+
+	errs, err := s.conn.Listen(s.events, 1, netfilter.GroupsCT)
+	if err != nil {
+		return errors.Wrap(err, "listening for conntrack events")
+	}
+	s.errs = errs
+
+	s.wg.Add(1)
+	go s.worker()
+
+	return nil
+}
+
+// setConfig replaces the allowlists applied to the events.
+func (s *netlinkSource) setConfig(cfg bpf.Config) {
+	s.config.Store(cfg)
+}
+
+// stop closes the netlink connection and waits for the worker to exit.
+func (s *netlinkSource) stop() error {
+
+	close(s.done)
+	err := s.conn.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+// worker converts conntrack events and delivers them to the pipeline's
+// event channels until the source is stopped. Events are dropped when
+// a channel is full unless the source blocks, like the probe's consumers do.
+func (s *netlinkSource) worker() {
+
+	defer s.wg.Done()
+
+	for {
+		select {
+		case ev := <-s.events:
+			if ev.Flow == nil {
+				continue
+			}
+
+			e := flowEvent(*ev.Flow)
+			e.NetNS = s.netns
+			e.Timestamp, _ = ktime() // CLOCK_MONOTONIC is always supported
+
+			cfg := s.config.Load().(bpf.Config)
+			if !cfg.Match(e) || !bpf.Sampled(e.ConnectionID, cfg.SampleRate) {
+				continue
+			}
+			if cfg.SampleRate > 1 {
+				e.SampleRate = cfg.SampleRate
+			}
+
+			switch ev.Type {
+			case conntrack.EventNew, conntrack.EventUpdate:
+				if !cfg.MatchUpdate(e) {
+					continue
+				}
+				if !s.send(s.update, e) {
+					atomic.AddUint64(&s.lostUpdate, 1)
+				}
+			case conntrack.EventDestroy:
+				if !s.send(s.destroy, e) {
+					atomic.AddUint64(&s.lostDestroy, 1)
+				}
+			}
+		case err := <-s.errs:
+			if err == nil {
+				continue
+			}
+			atomic.AddUint64(&s.errors, 1)
+			log.Errorf("Error receiving conntrack events: %s", err)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send sends e to ch. Returns false if e was dropped because ch was full,
+// or because the source was stopped while blocking.
+func (s *netlinkSource) send(ch chan<- bpf.Event, e bpf.Event) bool {
+
+	if s.block {
+		select {
+		case ch <- e:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+
+	select {
+	case ch <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+// netnsInode returns the inode number of the process' network namespace,
+// which identifies flows' namespaces in events sent by the probe.
+func netnsInode() (uint32, error) {
+
+	var st unix.Stat_t
+	if err := unix.Stat("/proc/self/ns/net", &st); err != nil {
+		return 0, errors.Wrap(err, "getting network namespace")
+	}
+
+	return uint32(st.Ino), nil
+}
+
+// ktime returns the current time on the clock used by bpf_ktime_get_ns(),
+// which event timestamps are relative to.
+func ktime() (uint64, error) {
+
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, errors.Wrap(err, "reading monotonic clock")
+	}
+
+	return uint64(ts.Nano()), nil
+}
@@ -1,32 +1,119 @@
 package pipeline
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/ti-mo/conntracct/internal/capture"
+	"github.com/ti-mo/conntracct/internal/enrich"
+	"github.com/ti-mo/conntracct/internal/flowcache"
 	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/pkg/boottime"
 	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/generator"
 )
 
 // Pipeline is a structure representing the conntracct
 // data ingest pipeline.
 type Pipeline struct {
-	Stats Stats
-
 	init  sync.Once
 	start sync.Once
 
+	// Configuration of the accounting probe.
+	probeConfig bpf.Config
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Receive conntrack events over netlink if the probe can't be loaded.
+	netlinkFallback bool
+
+	// Called when the probe's consumers lose events, and when the kernel
+	// reports events lost in the probe's perf buffers.
+	onLost        bpf.LostFunc
+	onLostSamples bpf.LostSamplesFunc
+
+	// Called on gaps in the sequence numbers of the probe's events.
+	onSeqGap bpf.SeqGapFunc
+
+	// Sizes of the update and destroy event queues, and what happens
+	// to events received while they're full.
+	updateQueueSize  int
+	destroyQueueSize int
+	backpressure     bpf.Backpressure
+
+	// Capture or generator to replay instead of receiving events from
+	// the kernel, and the offset added to their time stamps.
+	replay       frameReader
+	replaySpeed  float64
+	replayOffset time.Duration
+
 	// Protected by init.
 	acctProbe       *bpf.Probe
 	acctUpdateChan  chan bpf.Event
 	acctDestroyChan chan bpf.Event
+	acctConsumers   []*bpf.Consumer
+	acctNetlink     *netlinkSource
+	acctReplay      *replaySource
+
+	// Tracks the update and destroy workers, waited on by Stop.
+	acctWorkers sync.WaitGroup
+
+	// Amount of fanout workers, and the pool of fanout workers the update
+	// and destroy workers hand events to if there's more than one.
+	workers int
+	pool    *fanoutPool
+
+	// Size and eviction of the rate tracker's and the live flow table's flows.
+	flowCache flowcache.Config
+
+	// Computes the throughput of flows between their events if enabled.
+	rates *rateTracker
+
+	// Holds the live flows queried by Top, if enabled.
+	top *topTracker
 
-	acctSinkMu sync.RWMutex
+	// Holds the last event of all flows, to synthesize destroy events for
+	// stale flows and on conntrack resets if enabled. The stale flow and
+	// reset workers run until flowsDone is closed.
+	staleTimeout  time.Duration
+	resetInterval time.Duration
+	stale         *staleTracker
+	flowsDone     chan struct{}
+	flowWorkers   sync.WaitGroup
+
+	// Amount of conntrack table flushes and module reloads detected.
+	flushes uint64
+	reloads uint64
+
+	// Rolls up events by key if aggregation is enabled. The aggregation
+	// worker emits rollups until aggDone is closed.
+	aggregator *aggregator
+	aggDone    chan struct{}
+	aggWorker  sync.WaitGroup
+
+	// Serializes changes to the input filter, sinks, enrichers, middleware
+	// and taps, which are published to the workers as a snapshot of stages.
+	acctSinkMu sync.Mutex
+	inputCfg   InputFilter
+	input      *inputFilter
 	acctSinks  []sinks.Sink
+	enrichers  []enrich.Enricher
+	middleware []*middlewareStage
+	taps       []*Tap
+
+	// Current stages and their generation, and the goroutines using them.
+	stages  atomic.Value
+	gen     uint64
+	readers []*reader
 }
 
 // Stats holds various statistics and information about the
-// data processing pipeline.
+// data processing pipeline. Returned by Pipeline.Stats.
 type Stats struct {
 
 	// total amount of event structs received from kernel
@@ -45,11 +132,255 @@ type Stats struct {
 	// length of the Event queues
 	AcctUpdateQueueLen  uint64 `json:"update_queue_length"`
 	AcctDestroyQueueLen uint64 `json:"destroy_queue_length"`
+
+	// amount of events dropped by the input filter
+	EventsFiltered uint64 `json:"events_filtered"`
+
+	// amount of events sinks failed to accept
+	PushErrors uint64 `json:"push_errors"`
+
+	// amount of conntrack table flushes and module reloads detected
+	ConntrackFlushes uint64 `json:"conntrack_flushes"`
+	ConntrackReloads uint64 `json:"conntrack_reloads"`
+}
+
+// ProbeStats holds statistics about events read from the kernel and lost
+// between the kernel and the pipeline. In netlink mode, LostSamples holds the amount of
+// netlink errors, eg. due to full socket buffers.
+type ProbeStats struct {
+
+	// amount of events and bytes read from the probe's perf or ring buffers
+	EventsRead uint64 `json:"events_read"`
+	BytesRead  uint64 `json:"bytes_read"`
+
+	// amount of events delivered to the pipeline's queues, by consumer name
+	Delivered map[string]uint64 `json:"delivered"`
+
+	// amount of events dropped by the kernel due to full perf buffers
+	LostSamples uint64 `json:"lost_samples"`
+
+	// amount of events dropped by the kernel, by CPU whose perf buffer was full
+	LostPerCPU map[int]uint64 `json:"lost_per_cpu"`
+
+	// amount of events lost between the kernel and the probe's readers,
+	// detected by gaps in their sequence numbers, in total and by CPU
+	SeqLost       uint64         `json:"seq_lost"`
+	SeqLostPerCPU map[int]uint64 `json:"seq_lost_per_cpu"`
+
+	// amount of events dropped due to full pipeline queues, by consumer name
+	LostConsumer map[string]uint64 `json:"lost_consumer"`
+
+	// amount of events of the kind a consumer subscribes to that were
+	// dropped by the kernel, by consumer name
+	LostKernel map[string]uint64 `json:"lost_kernel"`
+}
+
+// New creates a new Pipeline structure. The accounting probe
+// will be configured with the given bpf.Config.
+func New(cfg bpf.Config) *Pipeline {
+	return &Pipeline{
+		probeConfig: cfg,
+		clock:       boottime.NewClock(),
+		flowCache:   flowcache.Config{TTL: defaultFlowTTL},
+	}
+}
+
+// SetAggregation enables the pipeline's aggregation stage. Sinks receive
+// rollup records of the traffic of all flows by key as update events
+// instead of the flows' events. Must be called before Start.
+func (p *Pipeline) SetAggregation(cfg AggregateConfig) error {
+
+	a, err := newAggregator(cfg)
+	if err != nil {
+		return err
+	}
+	p.aggregator = a
+
+	return nil
 }
 
-// New creates a new Pipeline structure.
-func New() *Pipeline {
-	return &Pipeline{}
+// SetFlowCache sets the maximum amount of flows held by the rate tracker and
+// the live flow table, and how long idle flows are kept. Must be called
+// before SetRates, SetTop and SetStaleTimeout.
+func (p *Pipeline) SetFlowCache(cfg flowcache.Config) {
+	p.flowCache = cfg
+}
+
+// SetRates sets whether the pipeline computes the throughput of flows since
+// their previous event, delivered to sinks in Event.Rate. Must be called
+// before Start.
+func (p *Pipeline) SetRates(enable bool) {
+	p.rates = nil
+	if enable {
+		p.rates = newRateTracker(p.flowCache)
+	}
+}
+
+// SetTop sets whether the pipeline keeps a table of all live flows
+// to be queried by Top and Flows. Must be called before Start.
+func (p *Pipeline) SetTop(enable bool) {
+	p.top = nil
+	if enable {
+		p.top = newTopTracker(p.flowCache)
+	}
+}
+
+// SetStaleTimeout makes the pipeline synthesize a destroy event for flows
+// that didn't see an event for timeout, holding their last counters, eg. when
+// their destroy events were lost to a conntrack table flush. The events are
+// tagged with stale=true. Zero disables it. Must be called after SetFlowCache
+// and before Start.
+func (p *Pipeline) SetStaleTimeout(timeout time.Duration) {
+	p.staleTimeout = timeout
+	p.trackFlows()
+}
+
+// SetResetDetection makes the pipeline check for conntrack table flushes and
+// reloads of the conntrack module on every interval. Flows seen before a
+// reset that don't send an event in the following interval are closed out
+// with a destroy event tagged with reset=flush or reset=reload, and forgotten
+// by the rate tracker and the live flow table. Flushes only close out flows
+// in the pipeline's network namespace.
+// Zero disables it. Must be called after SetFlowCache and before Start.
+func (p *Pipeline) SetResetDetection(interval time.Duration) {
+	p.resetInterval = interval
+	p.trackFlows()
+}
+
+// trackFlows creates the stale flow tracker if stale flows or resets
+// are to be detected.
+func (p *Pipeline) trackFlows() {
+	p.stale = nil
+	if p.staleTimeout > 0 || p.resetInterval > 0 {
+		p.stale = newStaleTracker(p.staleTimeout, p.flowCache)
+	}
+}
+
+// Top returns the n largest live flows ordered by TopByRate or TopByBytes.
+// Returns all live flows if n is zero. Returns ErrTopDisabled if the
+// pipeline doesn't keep a top flow table.
+func (p *Pipeline) Top(n int, by string) ([]TopFlow, error) {
+
+	if p.top == nil {
+		return nil, ErrTopDisabled
+	}
+
+	return p.top.top(n, by)
+}
+
+// FlowSummary summarizes the live flow table, including the n busiest flows.
+// Returns ErrTopDisabled if the pipeline doesn't keep a live flow table.
+func (p *Pipeline) FlowSummary(n int) (FlowSummary, error) {
+
+	if p.top == nil {
+		return FlowSummary{}, ErrTopDisabled
+	}
+
+	return p.top.summary(n), nil
+}
+
+// Flows returns all live flows matching the given filter, ordered by their
+// connection IDs. Returns ErrTopDisabled if the pipeline doesn't keep a live
+// flow table.
+func (p *Pipeline) Flows(ff FlowFilter) ([]TopFlow, error) {
+
+	if p.top == nil {
+		return nil, ErrTopDisabled
+	}
+
+	return p.top.list(ff), nil
+}
+
+// FlowCacheStats returns the statistics of the pipeline's flow tables by name,
+// 'rates' for the rate tracker, 'top' for the live flow table and 'stale' for
+// the stale flow tracker, if enabled. Flows expired by the stale flow tracker
+// were closed out with a destroy event.
+func (p *Pipeline) FlowCacheStats() map[string]flowcache.Stats {
+
+	s := make(map[string]flowcache.Stats)
+	if p.rates != nil {
+		s["rates"] = p.rates.flows.Stats()
+	}
+	if p.top != nil {
+		s["top"] = p.top.flows.Stats()
+	}
+	if p.stale != nil {
+		s["stale"] = p.stale.flows.Stats()
+	}
+
+	return s
+}
+
+// SetReplay makes the pipeline replay the events of a capture instead of
+// receiving events from the kernel. speed is the playback speed relative
+// to the recording, zero replays events as fast as the pipeline accepts them.
+// Must be called before Init.
+func (p *Pipeline) SetReplay(r *capture.Reader, speed float64) {
+	p.replay = r
+	p.replaySpeed = speed
+
+	// Convert time stamps so events keep their absolute times.
+	p.replayOffset = r.BootTime().Sub(p.clock.Boot())
+}
+
+// SetGenerator makes the pipeline receive the given amount of synthetic
+// events from g at rate events per second, instead of receiving events from
+// the kernel. Zero rate feeds events as fast as the pipeline accepts them,
+// zero events generates events until the pipeline is stopped.
+// Must be called before Init.
+func (p *Pipeline) SetGenerator(g *generator.Generator, rate float64, events uint64) {
+	p.replay = &generatorFrames{gen: g, rate: rate, events: events}
+	p.replaySpeed = 1
+	p.replayOffset = 0
+}
+
+// Replayed returns a channel that's closed when all events of the capture
+// or generator set by SetReplay or SetGenerator have been fed into the
+// pipeline. Returns nil if the pipeline is not replaying events.
+func (p *Pipeline) Replayed() <-chan struct{} {
+	if p.acctReplay == nil {
+		return nil
+	}
+	return p.acctReplay.finished
+}
+
+// SetOnLost sets a function called whenever the pipeline's probe consumers
+// lose events, either in the kernel or due to full pipeline queues. Not called
+// when receiving events over netlink. Must be called before Init.
+func (p *Pipeline) SetOnLost(f bpf.LostFunc) {
+	p.onLost = f
+}
+
+// SetQueues sets the amount of update and destroy events buffered between the
+// probe and the pipeline's workers, zero selecting the default of 1024, and
+// what happens to events received while the queues are full. Dropping them
+// never holds up the probe's readers, blocking loses no events unless the
+// kernel's buffers overflow in the meantime. Must be called before Init.
+func (p *Pipeline) SetQueues(update, destroy int, bp bpf.Backpressure) {
+	p.updateQueueSize = update
+	p.destroyQueueSize = destroy
+	p.backpressure = bp
+}
+
+// SetOnLostSamples sets a function called whenever the kernel reports events
+// lost due to a full perf buffer of the probe, with the CPU of the buffer.
+// Must be called before Init.
+func (p *Pipeline) SetOnLostSamples(f bpf.LostSamplesFunc) {
+	p.onLostSamples = f
+}
+
+// SetOnSeqGap sets a function called whenever a gap is detected in the
+// sequence numbers of the probe's events, left by events lost between the
+// kernel and the probe. Must be called before Init.
+func (p *Pipeline) SetOnSeqGap(f bpf.SeqGapFunc) {
+	p.onSeqGap = f
+}
+
+// SetNetlinkFallback sets whether the pipeline receives conntrack events
+// over netlink when the BPF probe can't be loaded, eg. on kernels without
+// kprobe support. Must be called before Init.
+func (p *Pipeline) SetNetlinkFallback(enable bool) {
+	p.netlinkFallback = enable
 }
 
 // RegisterSink registers a sink for accounting data
@@ -70,25 +401,307 @@ func (p *Pipeline) RegisterSink(s sinks.Sink) error {
 	p.acctSinkMu.Lock()
 	defer p.acctSinkMu.Unlock()
 
+	for _, rs := range p.acctSinks {
+		if rs.Name() == s.Name() {
+			return ErrSinkExists
+		}
+	}
+
 	// Add the acctSink to the pipeline.
 	p.acctSinks = append(p.acctSinks, s)
+	p.publish()
 
 	log.Infof("Registered accounting sink '%s' to pipeline", s.Name())
 
 	return nil
 }
 
+// RemoveSink unregisters the accounting sink with the given name from the
+// pipeline and returns it. Once RemoveSink returns, no more events are
+// pushed into the sink, and it can be closed.
+func (p *Pipeline) RemoveSink(name string) (sinks.Sink, error) {
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	for i, s := range p.acctSinks {
+		if s.Name() != name {
+			continue
+		}
+
+		// Build a new list so slices returned by GetSinks are never modified.
+		ss := make([]sinks.Sink, 0, len(p.acctSinks)-1)
+		ss = append(ss, p.acctSinks[:i]...)
+		p.acctSinks = append(ss, p.acctSinks[i+1:]...)
+		p.publish()
+		p.quiesce()
+
+		log.Infof("Removed accounting sink '%s' from pipeline", name)
+
+		return s, nil
+	}
+
+	return nil, ErrSinkNotFound
+}
+
+// RegisterEnricher registers an enricher to the pipeline. Enrichers are run
+// in order of registration on every event before it is delivered to sinks.
+func (p *Pipeline) RegisterEnricher(e enrich.Enricher) {
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	p.enrichers = append(p.enrichers, e)
+	p.publish()
+
+	log.Infof("Registered enricher '%s' to pipeline", e.Name())
+}
+
+// ReplaceSinks replaces all accounting sinks registered to the pipeline
+// with the given list. Events in flight are delivered to either the old
+// or the new list of sinks. Once ReplaceSinks returns, no more events are
+// pushed into sinks missing from the new list, and they can be closed.
+func (p *Pipeline) ReplaceSinks(ss []sinks.Sink) error {
+
+	for _, s := range ss {
+		if !s.IsInit() {
+			return errSinkNotInit
+		}
+
+		if s.WantDestroy() {
+			warnSysctl()
+		}
+	}
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	// Copy the list so slices returned by GetSinks are never modified.
+	p.acctSinks = append([]sinks.Sink(nil), ss...)
+	p.publish()
+	p.quiesce()
+
+	return nil
+}
+
+// Reconfigure applies the parts of the given probe configuration that can
+// be changed while the probe is running: the cooldown and its classes, the
+// byte threshold and the protocol and destination port allowlists.
+func (p *Pipeline) Reconfigure(cfg bpf.Config) error {
+
+	// Replayed events were filtered when recording them.
+	if p.acctReplay != nil {
+		p.probeConfig = cfg
+		return nil
+	}
+
+	// Allowlists are applied in userspace and the cooldown doesn't
+	// apply when receiving events over netlink.
+	if p.acctNetlink != nil {
+		p.acctNetlink.setConfig(cfg)
+		p.probeConfig = cfg
+		return nil
+	}
+
+	if p.acctProbe == nil {
+		return errAcctNotInitialized
+	}
+
+	if cfg.CooldownMillis != 0 {
+		if err := p.acctProbe.SetCooldown(cfg.CooldownMillis); err != nil {
+			return errors.Wrap(err, "cooldown")
+		}
+	}
+
+	if err := p.acctProbe.SetMinBytes(cfg.MinBytes); err != nil {
+		return errors.Wrap(err, "minimum bytes")
+	}
+
+	if err := p.acctProbe.SetCooldownClasses(cfg.CooldownClasses); err != nil {
+		return errors.Wrap(err, "cooldown classes")
+	}
+
+	if err := p.acctProbe.SetProtoFilter(cfg.Protos); err != nil {
+		return errors.Wrap(err, "protocol filter")
+	}
+
+	if err := p.acctProbe.SetPortFilter(cfg.DstPorts); err != nil {
+		return errors.Wrap(err, "port filter")
+	}
+
+	if err := p.acctProbe.SetNetNSFilter(cfg.NetNS); err != nil {
+		return errors.Wrap(err, "netns filter")
+	}
+
+	p.probeConfig = cfg
+
+	return nil
+}
+
+// Stats returns a snapshot of the pipeline's statistics, summing the
+// counters of all of its workers.
+func (p *Pipeline) Stats() Stats {
+
+	var s Stats
+
+	p.acctSinkMu.Lock()
+	for _, r := range p.readers {
+		s.EventsUpdate += atomic.LoadUint64(&r.stats.eventsUpdate)
+		s.EventsDestroy += atomic.LoadUint64(&r.stats.eventsDestroy)
+		s.EventsFiltered += atomic.LoadUint64(&r.stats.eventsFiltered)
+		s.PushErrors += atomic.LoadUint64(&r.stats.pushErrors)
+		s.AcctUpdateQueueLen += uint64(atomic.LoadUint32(&r.stats.updateQueueLen))
+		s.AcctDestroyQueueLen += uint64(atomic.LoadUint32(&r.stats.destroyQueueLen))
+	}
+	p.acctSinkMu.Unlock()
+
+	s.ConntrackFlushes = atomic.LoadUint64(&p.flushes)
+	s.ConntrackReloads = atomic.LoadUint64(&p.reloads)
+
+	s.EventsTotal = s.EventsUpdate + s.EventsDestroy
+	s.AcctBytesUpdate = s.EventsUpdate * bpf.EventLength
+	s.AcctBytesDestroy = s.EventsDestroy * bpf.EventLength
+	s.AcctBytesTotal = s.EventsTotal * bpf.EventLength
+
+	return s
+}
+
+// ProbeStats returns a snapshot of the accounting probe's statistics.
+// Returns zero stats if the pipeline has not been initialized yet.
+func (p *Pipeline) ProbeStats() ProbeStats {
+
+	ps := ProbeStats{
+		Delivered:    make(map[string]uint64),
+		LostConsumer: make(map[string]uint64),
+		LostKernel:   make(map[string]uint64),
+	}
+
+	if n := p.acctNetlink; n != nil {
+		ps.LostSamples = atomic.LoadUint64(&n.errors)
+		ps.LostConsumer["AcctUpdate"] = atomic.LoadUint64(&n.lostUpdate)
+		ps.LostConsumer["AcctDestroy"] = atomic.LoadUint64(&n.lostDestroy)
+		return ps
+	}
+
+	if p.acctProbe == nil {
+		return ps
+	}
+
+	s := p.acctProbe.Stats()
+	ps.EventsRead = s.EventsRead
+	ps.BytesRead = s.BytesRead
+	ps.LostSamples = s.LostSamples
+	ps.LostPerCPU = s.LostPerCPU
+	ps.SeqLost = s.SeqLost
+	ps.SeqLostPerCPU = s.SeqLostPerCPU
+
+	// The consumers are unregistered from the probe when stopping.
+	for _, c := range p.acctConsumers {
+		cs := c.Stats()
+		ps.Delivered[c.Name()] = cs.Delivered
+		ps.LostConsumer[c.Name()] = cs.Lost
+		ps.LostKernel[c.Name()] = cs.LostKernel
+	}
+
+	return ps
+}
+
+// Alive returns an error if the readers of the pipeline's probe haven't read
+// its buffers within timeout, eg. because they're blocked on full queues.
+// Netlink and replayed sources are always considered alive.
+func (p *Pipeline) Alive(timeout time.Duration) error {
+
+	if p.acctProbe == nil {
+		return nil
+	}
+
+	if age := p.acctProbe.PollAge(); age > timeout {
+		return errors.Errorf(errFmtStalled, age.Round(time.Millisecond))
+	}
+
+	return nil
+}
+
+// Flush flushes all accounting sinks registered to the pipeline, waiting
+// for their buffered events to be written or until ctx is done.
+// All sinks are flushed, the first error encountered is returned.
+func (p *Pipeline) Flush(ctx context.Context) error {
+
+	var ferr error
+	for _, s := range p.GetSinks() {
+		if err := s.Flush(ctx); err != nil && ferr == nil {
+			ferr = errors.Wrapf(err, "flushing sink '%s'", s.Name())
+		}
+	}
+
+	return ferr
+}
+
 // GetSinks gets a list of accounting sinks registered to the pipeline.
 func (p *Pipeline) GetSinks() []sinks.Sink {
 
-	p.acctSinkMu.RLock()
-	defer p.acctSinkMu.RUnlock()
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
 
 	return p.acctSinks
 }
 
 // Stop gracefully tears down all resources of a Pipeline structure.
+// Stops the probe, delivers all queued events to the sinks and closes them,
+// so their buffered events are written before Stop returns.
 func (p *Pipeline) Stop() error {
-	// Stop the accounting probe.
-	return p.acctProbe.Stop()
+
+	// The event source is stopped even if stopping fails, eg. when the probe
+	// can't detach its kprobes after dropping privileges. Queued events are
+	// still delivered and the sinks closed.
+	serr := p.stopAcct()
+	p.acctWorkers.Wait()
+
+	if p.flowsDone != nil {
+		close(p.flowsDone)
+		p.flowWorkers.Wait()
+	}
+
+	if p.pool != nil {
+		p.pool.stop()
+	}
+
+	// Emit the traffic accumulated since the last rollup.
+	if p.aggregator != nil {
+		close(p.aggDone)
+		p.aggWorker.Wait()
+		p.pushRollups(p.newReader())
+	}
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	// Close all enrichers and sinks, even if one of them fails, so the
+	// sinks' buffered events are always written.
+	var cerr error
+	for _, e := range p.enrichers {
+		if err := e.Close(); err != nil {
+			log.Errorf("Error closing enricher '%s': %s", e.Name(), err)
+			if cerr == nil {
+				cerr = errors.Wrapf(err, "closing enricher '%s'", e.Name())
+			}
+		}
+	}
+
+	for _, s := range p.acctSinks {
+		if err := s.Close(); err != nil {
+			log.Errorf("Error closing accounting sink '%s': %s", s.Name(), err)
+			if cerr == nil {
+				cerr = errors.Wrapf(err, "closing sink '%s'", s.Name())
+			}
+			continue
+		}
+		log.Infof("Closed accounting sink '%s'", s.Name())
+	}
+
+	if serr != nil {
+		return errors.Wrap(serr, "stopping event source")
+	}
+
+	return cerr
 }
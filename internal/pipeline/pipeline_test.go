@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/generator"
+)
+
+// failEnricher fails to close.
+type failEnricher struct{}
+
+func (failEnricher) Name() string      { return "fail" }
+func (failEnricher) Enrich(*bpf.Event) {}
+func (failEnricher) Close() error      { return errors.New("closing fail") }
+
+func TestStopClosesSinks(t *testing.T) {
+
+	p := New(bpf.Config{})
+	p.SetGenerator(generator.New(generator.Config{Seed: 1}), 0, 3)
+
+	s := &countSink{name: "count"}
+	require.NoError(t, p.RegisterSink(s))
+	p.RegisterEnricher(failEnricher{})
+
+	require.NoError(t, p.Init())
+	require.NoError(t, p.Start())
+	<-p.Replayed()
+
+	// A failing enricher doesn't keep the sinks from being closed.
+	assert.EqualError(t, p.Stop(), "closing enricher 'fail': closing fail")
+	assert.True(t, s.closed)
+	assert.Equal(t, 3, s.n)
+}
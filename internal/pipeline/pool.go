@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Amount of events buffered in front of each fanout worker.
+const poolQueueSize = 1024
+
+// fanoutPool is a pool of workers delivering events to the pipeline's sinks
+// in parallel. Events are assigned to workers by their connection ID, so the
+// events of a flow are delivered in the order they were received.
+type fanoutPool struct {
+	queues []chan bpf.Event
+
+	wg sync.WaitGroup
+}
+
+// SetWorkers sets the amount of workers delivering events to the sinks in
+// parallel. With a single worker (default), the update and destroy events
+// are delivered by one goroutine each. Must be called before Start.
+func (p *Pipeline) SetWorkers(n int) {
+	p.workers = n
+}
+
+// startPool starts n fanout workers delivering events to the pipeline's sinks.
+func (p *Pipeline) startPool(n int) {
+
+	fp := &fanoutPool{queues: make([]chan bpf.Event, n)}
+
+	fp.wg.Add(n)
+	for i := range fp.queues {
+		q := make(chan bpf.Event, poolQueueSize)
+		fp.queues[i] = q
+
+		r := p.newReader()
+		go func() {
+			defer fp.wg.Done()
+			for {
+				e, ok := <-q
+				if !ok {
+					return
+				}
+				ok = r.drain(e, q)
+				p.deliver(r, r.batch)
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
+
+	p.pool = fp
+}
+
+// dispatch hands the event to the worker of its flow.
+func (fp *fanoutPool) dispatch(e bpf.Event) {
+	fp.queues[poolIndex(e.ConnectionID, len(fp.queues))] <- e
+}
+
+// stop closes the workers' queues and waits for them to deliver
+// the events left in them.
+func (fp *fanoutPool) stop() {
+	for _, q := range fp.queues {
+		close(q)
+	}
+	fp.wg.Wait()
+}
+
+// poolIndex returns the index of the worker of a connection ID out of n.
+// Uses Fibonacci hashing to spread out IDs with common low bits.
+func poolIndex(id uint32, n int) int {
+	return int(uint64(id*2654435769) * uint64(n) >> 32)
+}
@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestPoolOrder(t *testing.T) {
+
+	p := &Pipeline{}
+
+	// Record the order in which the events of every flow are delivered.
+	var mu sync.Mutex
+	seen := make(map[uint32][]uint64)
+	require.NoError(t, p.RegisterMiddleware("record", func(e bpf.Event) (bpf.Event, bool) {
+		mu.Lock()
+		seen[e.ConnectionID] = append(seen[e.ConnectionID], e.PacketsOrig)
+		mu.Unlock()
+		return e, true
+	}))
+
+	p.startPool(4)
+	for i := uint64(0); i < 100; i++ {
+		for id := uint32(0); id < 16; id++ {
+			p.pool.dispatch(bpf.Event{ConnectionID: id << 8, PacketsOrig: i})
+		}
+	}
+	p.pool.stop()
+
+	require.Len(t, seen, 16)
+	for id, pkts := range seen {
+		require.Len(t, pkts, 100, "flow %d", id)
+		for i, n := range pkts {
+			assert.EqualValues(t, i, n, "flow %d", id)
+		}
+	}
+}
+
+func TestPoolIndex(t *testing.T) {
+
+	// IDs sharing their low bits are spread over all workers.
+	used := make(map[int]bool)
+	for id := uint32(0); id < 64; id++ {
+		i := poolIndex(id<<8, 4)
+		require.True(t, i >= 0 && i < 4)
+		used[i] = true
+	}
+	assert.Len(t, used, 4)
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Flows that haven't been seen for this long are forgotten by the flow
+// tables by default, in case their destroy event was lost.
+const defaultFlowTTL = time.Hour
+
+// rateFlow holds a flow's counters and timestamp at its most recent event.
+type rateFlow struct {
+	ts                     uint64
+	packetsOrig, bytesOrig uint64
+	packetsRet, bytesRet   uint64
+}
+
+// newRateFlow returns the state of a flow at an event.
+func newRateFlow(e *bpf.Event) rateFlow {
+	return rateFlow{
+		ts:          e.Timestamp,
+		packetsOrig: e.PacketsOrig,
+		bytesOrig:   e.BytesOrig,
+		packetsRet:  e.PacketsRet,
+		bytesRet:    e.BytesRet,
+	}
+}
+
+// rate returns the event's rate since the flow's previous event at f.
+// Returns false if the event isn't newer than f or its counters went
+// backwards, meaning the connection ID was reused.
+func (f rateFlow) rate(e *bpf.Event) (bpf.Rate, bool) {
+
+	if e.Timestamp <= f.ts || e.BytesOrig < f.bytesOrig || e.BytesRet < f.bytesRet {
+		return bpf.Rate{}, false
+	}
+
+	d := time.Duration(e.Timestamp - f.ts)
+	s := d.Seconds()
+
+	return bpf.Rate{
+		Interval:    d,
+		PacketsOrig: float64(e.PacketsOrig-f.packetsOrig) / s,
+		BytesOrig:   float64(e.BytesOrig-f.bytesOrig) / s,
+		PacketsRet:  float64(e.PacketsRet-f.packetsRet) / s,
+		BytesRet:    float64(e.BytesRet-f.bytesRet) / s,
+	}, true
+}
+
+// rateTracker computes the throughput of flows between their events.
+type rateTracker struct {
+	mu    sync.Mutex
+	flows *flowcache.Cache[rateFlow]
+}
+
+func newRateTracker(cfg flowcache.Config) *rateTracker {
+	return &rateTracker{flows: flowcache.New[rateFlow](cfg)}
+}
+
+// update sets the event's rate since the flow's previous event. The flow
+// is forgotten after its destroy event.
+func (r *rateTracker) update(e *bpf.Event, destroy bool) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.flows.Get(e.ConnectionID); ok {
+		if rate, ok := f.rate(e); ok {
+			e.Rate = rate
+		}
+	}
+
+	if destroy {
+		r.flows.Delete(e.ConnectionID)
+		return
+	}
+
+	r.flows.Put(e.ConnectionID, e.Timestamp, newRateFlow(e))
+}
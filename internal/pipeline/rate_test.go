@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestRateTracker(t *testing.T) {
+
+	r := newRateTracker(flowcache.Config{})
+
+	ev := func(ts time.Duration, bytes uint64) bpf.Event {
+		return bpf.Event{
+			ConnectionID: 1, Timestamp: uint64(ts),
+			PacketsOrig: bytes / 10, BytesOrig: bytes, BytesRet: bytes * 2,
+		}
+	}
+
+	// No rate for the first event of a flow.
+	e := ev(time.Second, 100)
+	r.update(&e, false)
+	assert.Zero(t, e.Rate)
+
+	e = ev(3*time.Second, 500)
+	r.update(&e, false)
+	assert.Equal(t, 2*time.Second, e.Rate.Interval)
+	assert.EqualValues(t, 200, e.Rate.BytesOrig)
+	assert.EqualValues(t, 20, e.Rate.PacketsOrig)
+	assert.EqualValues(t, 400, e.Rate.BytesRet)
+
+	// Decreasing counters mean a new flow with the same ID.
+	e = ev(4*time.Second, 10)
+	r.update(&e, false)
+	assert.Zero(t, e.Rate)
+
+	e = ev(5*time.Second, 110)
+	r.update(&e, true)
+	assert.EqualValues(t, 100, e.Rate.BytesOrig)
+	assert.Zero(t, r.flows.Len())
+}
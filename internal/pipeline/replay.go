@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/capture"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/generator"
+)
+
+// frameReader yields the events delivered by a replaySource,
+// implemented by capture.Reader and generatorFrames.
+type frameReader interface {
+	Next() (capture.Frame, error)
+}
+
+// replaySource reads events from a capture or generator and delivers them to
+// the pipeline's event channels. Used instead of the BPF probe when replaying.
+type replaySource struct {
+	reader frameReader
+
+	// Playback speed relative to the recording, zero means no delay
+	// between events.
+	speed float64
+
+	// Added to events' time stamps to convert them to the local boot time.
+	offset int64
+
+	update  chan<- bpf.Event
+	destroy chan<- bpf.Event
+
+	// Closed when all events have been delivered.
+	finished chan struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newReplaySource returns a replaySource reading events from r into the
+// given channels. offset is added to the events' time stamps.
+func newReplaySource(r frameReader, speed float64, offset time.Duration, update, destroy chan<- bpf.Event) *replaySource {
+	return &replaySource{
+		reader:   r,
+		speed:    speed,
+		offset:   int64(offset),
+		update:   update,
+		destroy:  destroy,
+		finished: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start starts delivering events.
+func (s *replaySource) start() {
+	s.wg.Add(1)
+	go s.worker()
+}
+
+// stop stops delivering events and waits for the worker to exit.
+func (s *replaySource) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// worker delivers the events of the capture at the source's speed until the
+// end of the capture or until the source is stopped. Unlike the probe's
+// consumers, it waits for room in the pipeline's queues instead of dropping
+// events.
+func (s *replaySource) worker() {
+
+	defer s.wg.Done()
+	defer close(s.finished)
+
+	start := time.Now()
+
+	for {
+		f, err := s.reader.Next()
+		if err == io.EOF {
+			log.Info("Finished replaying events")
+			return
+		}
+		if err != nil {
+			log.Errorf("Error reading events to replay: %s", err)
+			return
+		}
+
+		// Wait until the event is due at the playback speed.
+		if s.speed > 0 {
+			due := start.Add(time.Duration(float64(f.Offset) / s.speed))
+			if d := time.Until(due); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-s.done:
+					return
+				}
+			}
+		}
+
+		e := f.Event
+		e.Timestamp = uint64(int64(e.Timestamp) + s.offset)
+
+		ch := s.update
+		if e.Destroy {
+			ch = s.destroy
+		}
+
+		select {
+		case ch <- e:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// generatorFrames yields a fixed amount of synthetic events at a fixed rate.
+type generatorFrames struct {
+	gen    *generator.Generator
+	rate   float64
+	events uint64
+
+	n uint64
+}
+
+// Next returns the generator's next event, time stamped with the current
+// time. Returns io.EOF after the configured amount of events.
+func (g *generatorFrames) Next() (capture.Frame, error) {
+
+	if g.events != 0 && g.n >= g.events {
+		return capture.Frame{}, io.EOF
+	}
+
+	var off time.Duration
+	if g.rate > 0 {
+		off = time.Duration(float64(g.n) / g.rate * float64(time.Second))
+	}
+	g.n++
+
+	ts, _ := ktime() // CLOCK_MONOTONIC is always supported
+
+	return capture.Frame{Offset: off, Event: g.gen.Next(ts)}, nil
+}
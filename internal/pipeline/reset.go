@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of conntrack resets, set as the reset tag of the destroy events
+// closing out the flows seen before the reset.
+const (
+	ResetFlush  = "flush"
+	ResetReload = "reload"
+)
+
+// Tag set on destroy events synthesized on conntrack resets.
+const resetTag = "reset"
+
+// A conntrack table holding at least flushMinFlows flows is considered
+// flushed when it shrinks to flushRatio of its size between two checks.
+const (
+	flushMinFlows = 100
+	flushRatio    = 0.1
+)
+
+// Paths of the size of the conntrack table in conntracct's network namespace
+// and of the list of loaded kernel modules.
+var (
+	conntrackCountPath = "/proc/sys/net/netfilter/nf_conntrack_count"
+	procModulesPath    = "/proc/modules"
+)
+
+// conntrackState is the state of the conntrack table and module at a check.
+type conntrackState struct {
+	// Amount of flows in the conntrack table, -1 if unknown.
+	count int
+
+	// Whether nf_conntrack is loaded as a module, and its load address.
+	// Built-in conntrack is never loaded. The address reads as zero
+	// without CAP_SYSLOG.
+	loaded bool
+	addr   string
+}
+
+// readConntrackState returns the current state of the conntrack table
+// and module.
+func readConntrackState() conntrackState {
+
+	cs := conntrackState{count: -1}
+
+	if b, err := os.ReadFile(conntrackCountPath); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			cs.count = n
+		}
+	}
+
+	f, err := os.Open(procModulesPath)
+	if err != nil {
+		return cs
+	}
+	defer f.Close()
+
+	// Lines hold the module's name, size, references, users, state and address.
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 6 || fields[0] != "nf_conntrack" {
+			continue
+		}
+		cs.loaded, cs.addr = true, fields[5]
+		break
+	}
+
+	return cs
+}
+
+// detectReset returns the kind of conntrack reset that happened between
+// the checks of prev and cur, or the empty string if there was none.
+// A module reloaded between checks at the same address is only detected
+// by its table being empty.
+func detectReset(prev, cur conntrackState) string {
+
+	if prev.loaded && (!cur.loaded || cur.addr != prev.addr) {
+		return ResetReload
+	}
+
+	if prev.count >= flushMinFlows && cur.count >= 0 &&
+		float64(cur.count) <= float64(prev.count)*flushRatio {
+		return ResetFlush
+	}
+
+	return ""
+}
+
+// conntrackReset is a conntrack reset detected at ktime time stamp ts,
+// affecting the flows in network namespace netns, or in all namespaces
+// if zero.
+type conntrackReset struct {
+	kind  string
+	ts    uint64
+	netns uint32
+}
+
+// resetWorker checks for conntrack resets on every interval until
+// flowsDone is closed. Flows surviving a reset keep sending events, the
+// flows that didn't send an event within an interval of detecting the reset
+// are closed out.
+func (p *Pipeline) resetWorker() {
+
+	defer p.flowWorkers.Done()
+
+	r := p.newReader()
+
+	t := time.NewTicker(p.resetInterval)
+	defer t.Stop()
+
+	var pending *conntrackReset
+
+	prev := readConntrackState()
+	for {
+		select {
+		case <-t.C:
+		case <-p.flowsDone:
+			return
+		}
+
+		if pending != nil {
+			n := p.closeOutReset(r, *pending)
+			log.Infof("Closed out %d flows after conntrack %s", n, pending.kind)
+			pending = nil
+		}
+
+		cur := readConntrackState()
+		switch kind := detectReset(prev, cur); kind {
+		case ResetFlush:
+			log.Warnf("Detected conntrack table flush, table went from %d to %d flows",
+				prev.count, cur.count)
+			rs := p.newReset(kind)
+			pending = &rs
+		case ResetReload:
+			log.Warn("Detected conntrack module reload")
+			log.Warn("Restart conntracct once the conntrack module is loaded to receive its events again")
+			rs := p.newReset(kind)
+			pending = &rs
+		}
+		prev = cur
+	}
+}
+
+// newReset records a conntrack reset of the given kind detected now. The
+// conntrack table count is per network namespace, so a flush only affects
+// the flows in the pipeline's namespace. A module reload affects all flows.
+func (p *Pipeline) newReset(kind string) conntrackReset {
+
+	ts, _ := ktime() // CLOCK_MONOTONIC is always supported
+	rs := conntrackReset{kind: kind, ts: ts}
+
+	if kind == ResetReload {
+		atomic.AddUint64(&p.reloads, 1)
+		return rs
+	}
+
+	atomic.AddUint64(&p.flushes, 1)
+
+	netns, err := netnsInode()
+	if err != nil {
+		log.Warnf("Closing out flows of all network namespaces after conntrack flush: %s", err)
+	}
+	rs.netns = netns
+
+	return rs
+}
+
+// closeOutReset closes out the flows affected by a conntrack reset that
+// haven't been seen since, with a destroy event tagged with the kind of the
+// reset. Delivering the events makes the pipeline's flow tables forget the
+// flows. Returns the amount of flows closed out.
+func (p *Pipeline) closeOutReset(r *reader, rs conntrackReset) int {
+
+	now, _ := ktime()
+
+	r.batch = p.stale.closeOut(rs.ts, now, rs.netns, resetTag, rs.kind, r.batch[:0])
+
+	n := len(r.batch)
+	if n != 0 {
+		p.process(r)
+	}
+
+	return n
+}
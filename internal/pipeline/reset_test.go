@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestConntrackState(t *testing.T) {
+
+	dir := t.TempDir()
+	count, modules := filepath.Join(dir, "count"), filepath.Join(dir, "modules")
+
+	defer func(c, m string) { conntrackCountPath, procModulesPath = c, m }(conntrackCountPath, procModulesPath)
+	conntrackCountPath, procModulesPath = count, modules
+
+	require.NoError(t, os.WriteFile(count, []byte("1234\n"), 0644))
+	require.NoError(t, os.WriteFile(modules, []byte(
+		"nf_conntrack_netlink 53248 0 - Live 0xffffffffc0b2c000\n"+
+			"nf_conntrack 172032 6 nf_conntrack_netlink, Live 0xffffffffc0a3e000\n"), 0644))
+
+	cs := readConntrackState()
+	assert.Equal(t, conntrackState{count: 1234, loaded: true, addr: "0xffffffffc0a3e000"}, cs)
+
+	assert.Empty(t, detectReset(cs, conntrackState{count: 1000, loaded: true, addr: cs.addr}))
+	assert.Equal(t, ResetFlush, detectReset(cs, conntrackState{count: 3, loaded: true, addr: cs.addr}))
+	assert.Equal(t, ResetReload, detectReset(cs, conntrackState{count: -1}))
+	assert.Equal(t, ResetReload, detectReset(cs, conntrackState{count: 0, loaded: true, addr: "0xffffffffc0b00000"}))
+
+	// Small tables and built-in conntrack.
+	assert.Empty(t, detectReset(conntrackState{count: 50}, conntrackState{count: 0}))
+	assert.Equal(t, ResetFlush, detectReset(conntrackState{count: 500}, conntrackState{count: 0}))
+}
+
+func TestReset(t *testing.T) {
+
+	p := &Pipeline{}
+	s := &countSink{name: "destroy", destroy: true}
+	require.NoError(t, p.RegisterSink(s))
+
+	p.SetTop(true)
+	p.SetResetDetection(1)
+
+	r := p.newReader()
+	p.deliver(r, []bpf.Event{
+		{ConnectionID: 1, NetNS: 1, Timestamp: 1},
+		{ConnectionID: 2, NetNS: 1, Timestamp: 2},
+		{ConnectionID: 3, NetNS: 2, Timestamp: 3},
+	})
+
+	// Flow 2 survives the flush of namespace 1 and keeps sending events,
+	// flow 3 is in another namespace.
+	rs := conntrackReset{kind: ResetFlush, ts: 10, netns: 1}
+	p.deliver(r, []bpf.Event{{ConnectionID: 2, NetNS: 1, Timestamp: 11}})
+
+	assert.Equal(t, 1, p.closeOutReset(r, rs))
+	assert.Equal(t, 1, s.n)
+
+	fs, err := p.Flows(FlowFilter{})
+	require.NoError(t, err)
+	var ids []uint32
+	for _, f := range fs {
+		ids = append(ids, f.ConnectionID)
+	}
+	assert.ElementsMatch(t, []uint32{2, 3}, ids)
+
+	// Module reloads affect all namespaces.
+	assert.Equal(t, 2, p.closeOutReset(r, conntrackReset{kind: ResetReload, ts: 20}))
+	assert.Equal(t, 3, s.n)
+
+	assert.Equal(t, flowcache.Stats{Expired: 3}, p.FlowCacheStats()["stale"])
+
+	p.newReset(ResetFlush)
+	assert.EqualValues(t, 1, p.Stats().ConntrackFlushes)
+}
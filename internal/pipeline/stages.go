@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/enrich"
+	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Interval at which quiesce checks whether readers moved on from
+// an old generation of stages.
+const quiescePoll = 50 * time.Microsecond
+
+// stages is an immutable snapshot of the input filter, sinks, enrichers,
+// middleware and taps registered to the pipeline. The snapshot is replaced on every
+// registration, so delivering an event never takes a lock.
+type stages struct {
+	gen uint64
+
+	input      *inputFilter
+	sinks      []sinks.Sink
+	enrichers  []enrich.Enricher
+	middleware []*middlewareStage
+	taps       []*Tap
+}
+
+// emptyStages is used before anything is registered to the pipeline.
+var emptyStages = &stages{}
+
+// reader is a goroutine delivering events using the pipeline's stages.
+// Removing a stage waits for readers to stop using the generations of
+// stages that still hold it.
+type reader struct {
+	// Generation of the stages in use, zero when idle.
+	active uint64
+
+	// Statistics of the events delivered by the reader, summed by Stats.
+	stats readerStats
+
+	// Keep readers on separate cache lines.
+	_ [16]byte
+
+	// Events being delivered, and those of them selected for a sink.
+	// Stages receive pointers into batch or copies, so events don't
+	// escape to the heap on their way to the sinks.
+	batch []bpf.Event
+	sel   []bpf.Event
+}
+
+// readerStats are the pipeline statistics counted by a reader. Sharding them
+// across readers keeps workers from contending for the same cache line.
+// Queue lengths are only set by the reader consuming the queue.
+type readerStats struct {
+	eventsUpdate    uint64
+	eventsDestroy   uint64
+	eventsFiltered  uint64
+	pushErrors      uint64
+	updateQueueLen  uint32
+	destroyQueueLen uint32
+}
+
+// newReader returns a reader for a goroutine delivering events.
+func (p *Pipeline) newReader() *reader {
+
+	r := &reader{}
+
+	p.acctSinkMu.Lock()
+	p.readers = append(p.readers, r)
+	p.acctSinkMu.Unlock()
+
+	return r
+}
+
+// acquire returns the pipeline's current stages, marking them in use by r
+// until release is called.
+func (p *Pipeline) acquire(r *reader) *stages {
+
+	// Publish the generation before loading the stages, so quiesce either
+	// sees it or the stages loaded are at least as new as the ones it waits for.
+	atomic.StoreUint64(&r.active, atomic.LoadUint64(&p.gen))
+
+	st, _ := p.stages.Load().(*stages)
+	if st == nil {
+		return emptyStages
+	}
+
+	return st
+}
+
+// release marks r as idle.
+func (r *reader) release() {
+	atomic.StoreUint64(&r.active, 0)
+}
+
+// publish replaces the pipeline's stages with a snapshot of the registered
+// sinks, enrichers, middleware and taps. acctSinkMu must be held.
+func (p *Pipeline) publish() {
+
+	st := &stages{
+		gen:        atomic.LoadUint64(&p.gen) + 1,
+		input:      p.input,
+		sinks:      append([]sinks.Sink(nil), p.acctSinks...),
+		enrichers:  append([]enrich.Enricher(nil), p.enrichers...),
+		middleware: append([]*middlewareStage(nil), p.middleware...),
+		taps:       append([]*Tap(nil), p.taps...),
+	}
+
+	p.stages.Store(st)
+	atomic.StoreUint64(&p.gen, st.gen)
+}
+
+// quiesce waits until no reader uses stages older than the current ones,
+// so stages missing from them are no longer used once it returns.
+// acctSinkMu must be held.
+func (p *Pipeline) quiesce() {
+
+	gen := atomic.LoadUint64(&p.gen)
+
+	for _, r := range p.readers {
+		for {
+			a := atomic.LoadUint64(&r.active)
+			if a == 0 || a >= gen {
+				break
+			}
+			time.Sleep(quiescePoll)
+		}
+	}
+}
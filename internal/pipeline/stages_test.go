@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// countSink counts the events pushed into it, of the kind it wants.
+type countSink struct {
+	name    string
+	destroy bool
+	n       int
+	closed  bool
+}
+
+func (s *countSink) Init(types.SinkConfig) error { return nil }
+func (s *countSink) IsInit() bool                { return true }
+func (s *countSink) Name() string                { return s.name }
+func (s *countSink) WantUpdate() bool            { return !s.destroy }
+func (s *countSink) WantDestroy() bool           { return s.destroy }
+func (s *countSink) Stats() types.SinkStatsData  { return types.SinkStatsData{} }
+func (s *countSink) Flush(context.Context) error { return nil }
+func (s *countSink) Close() error                { s.closed = true; return nil }
+
+func (s *countSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+func (s *countSink) PushBatch(es []bpf.Event) (int, error) {
+	for _, e := range es {
+		if e.Destroy != s.destroy {
+			return len(es), errors.New("unwanted event")
+		}
+	}
+	s.n += len(es)
+	return 0, nil
+}
+
+func TestQuiesce(t *testing.T) {
+
+	p := &Pipeline{}
+	tap := p.Tap(1, nil)
+
+	r := p.newReader()
+	st := p.acquire(r)
+	require.Len(t, st.taps, 1)
+
+	// Closing the tap waits for the reader to release the stages holding it.
+	closed := make(chan struct{})
+	go func() {
+		tap.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("tap closed while its stages were in use")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	r.release()
+	<-closed
+
+	// Readers acquire the new stages without the tap.
+	assert.Empty(t, p.acquire(r).taps)
+	r.release()
+}
+
+func TestDeliver(t *testing.T) {
+
+	p := &Pipeline{}
+	us := &countSink{name: "update"}
+	ds := &countSink{name: "destroy", destroy: true}
+	require.NoError(t, p.RegisterSink(us))
+	require.NoError(t, p.RegisterSink(ds))
+
+	// Sinks only receive their kind of events from mixed batches.
+	r := p.newReader()
+	es := []bpf.Event{{ConnectionID: 1}, {ConnectionID: 2, Destroy: true}, {ConnectionID: 3}}
+	p.deliver(r, es)
+	p.deliver(r, []bpf.Event{{ConnectionID: 4}})
+
+	assert.Equal(t, 3, us.n)
+	assert.Equal(t, 1, ds.n)
+	assert.Zero(t, p.Stats().PushErrors)
+
+	// The delivered events are not retained.
+	assert.Equal(t, make([]bpf.Event, 3), es)
+	assert.Equal(t, make([]bpf.Event, 1), r.sel)
+}
+
+func TestDeliverAllocs(t *testing.T) {
+
+	p := &Pipeline{}
+	s := &countSink{name: "count"}
+	require.NoError(t, p.RegisterSink(s))
+
+	r := p.newReader()
+	es := make([]bpf.Event, 4)
+
+	// Delivering events to the sinks doesn't move them to the heap.
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		for i := range es {
+			es[i] = bpf.Event{ConnectionID: uint32(i), PacketsOrig: 1}
+		}
+		p.deliver(r, es)
+	}))
+	assert.Equal(t, 404, s.n)
+}
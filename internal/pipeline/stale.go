@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Tag set on destroy events synthesized for stale flows.
+const staleTag = "stale"
+
+// Minimum interval at which stale flows are checked for.
+const minStaleInterval = time.Second
+
+// staleTracker holds the most recent event of all live flows, to close out
+// flows that didn't get a destroy event, eg. after the conntrack table was
+// flushed or the conntrack module reloaded.
+type staleTracker struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	flows *flowcache.Cache[bpf.Event]
+}
+
+// newStaleTracker returns a staleTracker considering flows stale after not
+// seeing an event for timeout, or never if zero, only closing out flows on
+// conntrack resets. Flows are only evicted by cfg's MaxFlows.
+func newStaleTracker(timeout time.Duration, cfg flowcache.Config) *staleTracker {
+	return &staleTracker{
+		timeout: timeout,
+		flows:   flowcache.New[bpf.Event](flowcache.Config{MaxFlows: cfg.MaxFlows}),
+	}
+}
+
+// update records the most recent event of a flow. The flow is forgotten
+// after its destroy event.
+func (s *staleTracker) update(e bpf.Event, destroy bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if destroy {
+		s.flows.Delete(e.ConnectionID)
+		return
+	}
+
+	// Tags are set again when the destroy event is delivered. The event
+	// outlives the batch, don't keep storage shared with other events alive.
+	e.Tags, e.Rate = nil, bpf.Rate{}
+	e = e.Clone()
+
+	s.flows.Put(e.ConnectionID, e.Timestamp, e)
+}
+
+// expire appends destroy events to es for the flows that haven't seen an
+// event within timeout of ktime time stamp now. The events hold the flows'
+// last counters, are time stamped at now and tagged as stale.
+func (s *staleTracker) expire(now uint64, es []bpf.Event) []bpf.Event {
+
+	if s.timeout == 0 || now < uint64(s.timeout) {
+		return es
+	}
+
+	return s.closeOut(now-uint64(s.timeout), now, 0, staleTag, "true", es)
+}
+
+// closeOut appends destroy events to es for the flows last seen before ktime
+// time stamp ts in network namespace netns, or in all namespaces if zero,
+// holding the flows' last counters. The events are time
+// stamped at now and tagged with k=v. Their wall clock time, if known, is
+// moved forward to now and they carry no sequence number, as the probe
+// never sent them.
+func (s *staleTracker) closeOut(ts, now uint64, netns uint32, k, v string, es []bpf.Event) []bpf.Event {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flows.ExpireBeforeIf(ts, func(_ uint32, e bpf.Event) bool {
+		if netns != 0 && e.NetNS != netns {
+			return false
+		}
+
+		e.Destroy = true
+		if e.RealTime != 0 {
+			e.RealTime += now - e.Timestamp
+		}
+		e.Timestamp = now
+		e.Seq, e.CPU = 0, 0
+		e.SetTag(k, v)
+		es = append(es, e)
+
+		return true
+	})
+
+	return es
+}
+
+// interval returns the interval at which stale flows are checked for.
+func (s *staleTracker) interval() time.Duration {
+
+	if d := s.timeout / 10; d > minStaleInterval {
+		return d
+	}
+
+	return minStaleInterval
+}
+
+// staleWorker delivers destroy events for stale flows on every interval
+// until flowsDone is closed.
+func (p *Pipeline) staleWorker() {
+
+	defer p.flowWorkers.Done()
+
+	r := p.newReader()
+
+	t := time.NewTicker(p.stale.interval())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			ts, _ := ktime() // CLOCK_MONOTONIC is always supported
+
+			r.batch = p.stale.expire(ts, r.batch[:0])
+			if len(r.batch) != 0 {
+				p.process(r)
+			}
+		case <-p.flowsDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestStaleTracker(t *testing.T) {
+
+	s := newStaleTracker(time.Minute, flowcache.Config{})
+
+	real := time.Unix(1500000000, 0)
+	s.update(bpf.Event{
+		ConnectionID: 1, Timestamp: uint64(time.Second), BytesOrig: 100,
+		RealTime: uint64(real.UnixNano()), Seq: 7, CPU: 1,
+	}, false)
+	s.update(bpf.Event{ConnectionID: 2, Timestamp: uint64(2 * time.Second)}, false)
+	s.update(bpf.Event{ConnectionID: 3, Timestamp: uint64(3 * time.Second)}, false)
+
+	// Destroyed flows are not stale.
+	s.update(bpf.Event{ConnectionID: 2, Timestamp: uint64(4 * time.Second), Destroy: true}, true)
+
+	assert.Empty(t, s.expire(uint64(time.Minute), nil))
+
+	now := uint64(time.Minute + 2*time.Second)
+	es := s.expire(now, nil)
+	require.Len(t, es, 1)
+	assert.EqualValues(t, 1, es[0].ConnectionID)
+	assert.EqualValues(t, 100, es[0].BytesOrig)
+	assert.Equal(t, now, es[0].Timestamp)
+	assert.True(t, real.Add(time.Minute+time.Second).Equal(es[0].Time(time.Time{})))
+	assert.Zero(t, es[0].Seq)
+	assert.Zero(t, es[0].CPU)
+	assert.True(t, es[0].Destroy)
+	assert.Equal(t, map[string]string{staleTag: "true"}, es[0].Tags)
+
+	assert.EqualValues(t, 1, s.flows.Len())
+	assert.Equal(t, 6*time.Second, newStaleTracker(time.Minute, flowcache.Config{}).interval())
+}
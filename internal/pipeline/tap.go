@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"sync/atomic"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Tap receives a copy of the pipeline's events without being registered as
+// a sink, eg. for streaming them to a diagnostic client. Taps receive the
+// events of individual flows, even when the pipeline aggregates them.
+type Tap struct {
+	// Events matching the tap's filter. Closed by Close.
+	C <-chan bpf.Event
+
+	c     chan bpf.Event
+	match func(bpf.Event) bool
+
+	// Amount of events dropped because C was full.
+	dropped uint64
+
+	p *Pipeline
+}
+
+// Tap attaches a Tap with a buffer of the given size to the pipeline.
+// Only events for which match returns true are delivered, all events if
+// match is nil. Events are dropped when the buffer is full, so a slow
+// reader never stalls the pipeline.
+func (p *Pipeline) Tap(size int, match func(bpf.Event) bool) *Tap {
+
+	c := make(chan bpf.Event, size)
+	t := &Tap{C: c, c: c, match: match, p: p}
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	p.taps = append(p.taps, t)
+	p.publish()
+
+	return t
+}
+
+// Close detaches the Tap from its pipeline and closes C.
+func (t *Tap) Close() {
+
+	p := t.p
+
+	p.acctSinkMu.Lock()
+	defer p.acctSinkMu.Unlock()
+
+	for i, pt := range p.taps {
+		if pt != t {
+			continue
+		}
+
+		ts := make([]*Tap, 0, len(p.taps)-1)
+		ts = append(ts, p.taps[:i]...)
+		p.taps = append(ts, p.taps[i+1:]...)
+
+		// Wait for the workers to stop pushing to the tap before closing C.
+		p.publish()
+		p.quiesce()
+
+		close(t.c)
+		return
+	}
+}
+
+// Dropped returns the amount of events dropped because the Tap's buffer
+// was full.
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// pushTaps delivers an event to the given taps.
+func pushTaps(ts []*Tap, e bpf.Event) {
+
+	for _, t := range ts {
+		if t.match != nil && !t.match(e) {
+			continue
+		}
+
+		select {
+		case t.c <- e:
+		default:
+			atomic.AddUint64(&t.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestTap(t *testing.T) {
+
+	p := &Pipeline{}
+
+	all := p.Tap(1, nil)
+	dst := p.Tap(1, func(e bpf.Event) bool { return e.DstPort == 443 })
+
+	r := p.newReader()
+	p.deliver(r, []bpf.Event{{ConnectionID: 1, DstPort: 443}, {ConnectionID: 2, DstPort: 53}})
+
+	e := <-all.C
+	assert.EqualValues(t, 1, e.ConnectionID)
+	assert.EqualValues(t, 1, all.Dropped())
+
+	e = <-dst.C
+	assert.EqualValues(t, 1, e.ConnectionID)
+	assert.Zero(t, dst.Dropped())
+
+	all.Close()
+	_, ok := <-all.C
+	assert.False(t, ok)
+
+	require.Len(t, p.taps, 1)
+	assert.Equal(t, dst, p.taps[0])
+}
@@ -0,0 +1,262 @@
+package pipeline
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/internal/sinks/helpers"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Orders of the top-N flow table.
+const (
+	TopByRate  = "rate"
+	TopByBytes = "bytes"
+)
+
+// TopFlow is a flow in the pipeline's live flow table.
+type TopFlow struct {
+	ConnectionID uint32 `json:"conn_id"`
+	SrcAddr      net.IP `json:"src_addr"`
+	DstAddr      net.IP `json:"dst_addr"`
+	SrcPort      uint16 `json:"src_port"`
+	DstPort      uint16 `json:"dst_port"`
+	Proto        string `json:"proto"`
+	Family       string `json:"family"`
+	TCPState     string `json:"tcp_state,omitempty"`
+	Connmark     uint32 `json:"connmark"`
+	NetNS        uint32 `json:"netns"`
+	Zone         uint16 `json:"zone"`
+
+	PacketsOrig uint64 `json:"packets_orig"`
+	BytesOrig   uint64 `json:"bytes_orig"`
+	PacketsRet  uint64 `json:"packets_ret"`
+	BytesRet    uint64 `json:"bytes_ret"`
+
+	// Throughput in both directions since the flow's previous event,
+	// per second.
+	PacketsRate float64 `json:"packets_rate"`
+	BytesRate   float64 `json:"bytes_rate"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ktime time stamp of the flow's most recent event.
+	ts uint64
+
+	// Protocol number, matched by FlowFilter.
+	proto uint8
+}
+
+// FlowSummary summarizes the pipeline's live flow table.
+type FlowSummary struct {
+	// Amount of live flows, in total and by protocol.
+	Flows   int            `json:"flows"`
+	ByProto map[string]int `json:"by_proto"`
+
+	// Total throughput of the live flows, per second.
+	PacketsRate float64 `json:"packets_rate"`
+	BytesRate   float64 `json:"bytes_rate"`
+
+	// Flows with the highest byte rates.
+	Top []TopFlow `json:"top"`
+}
+
+// FlowFilter selects flows from the pipeline's live flow table, or events
+// by the addresses, ports, protocol and network namespace of their flows.
+// Zero fields match all flows.
+type FlowFilter struct {
+	// Network containing the source or destination address.
+	Net *net.IPNet
+	// Source or destination port.
+	Port uint16
+	// Protocol number.
+	Proto uint8
+	// Inode number of the network namespace.
+	NetNS uint32
+}
+
+// Match returns true if the event matches all non-zero fields of the filter.
+func (ff FlowFilter) Match(e bpf.Event) bool {
+	return ff.match(e.SrcAddr, e.DstAddr, e.SrcPort, e.DstPort, e.Proto, e.NetNS)
+}
+
+// matchFlow returns true if the flow matches all non-zero fields of the filter.
+func (ff FlowFilter) matchFlow(f *TopFlow) bool {
+	return ff.match(f.SrcAddr, f.DstAddr, f.SrcPort, f.DstPort, f.proto, f.NetNS)
+}
+
+func (ff FlowFilter) match(src, dst net.IP, sport, dport uint16, proto uint8, netns uint32) bool {
+
+	if ff.Net != nil && !ff.Net.Contains(src) && !ff.Net.Contains(dst) {
+		return false
+	}
+	if ff.Port != 0 && sport != ff.Port && dport != ff.Port {
+		return false
+	}
+	if ff.Proto != 0 && proto != ff.Proto {
+		return false
+	}
+	if ff.NetNS != 0 && netns != ff.NetNS {
+		return false
+	}
+
+	return true
+}
+
+// topTracker holds the most recent state of all live flows.
+type topTracker struct {
+	mu    sync.Mutex
+	flows *flowcache.Cache[*TopFlow]
+}
+
+func newTopTracker(cfg flowcache.Config) *topTracker {
+	return &topTracker{flows: flowcache.New[*TopFlow](cfg)}
+}
+
+// update records the state of a flow at an event. The flow is removed
+// from the table after its destroy event. The flow's rate is computed
+// from its previous state, independently of the pipeline's rate tracker,
+// which may be disabled.
+func (t *topTracker) update(e bpf.Event, destroy bool) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if destroy {
+		t.flows.Delete(e.ConnectionID)
+		return
+	}
+
+	var rate bpf.Rate
+	if prev, ok := t.flows.Get(e.ConnectionID); ok {
+		rate, _ = prev.rateFlow().rate(&e)
+	}
+
+	// The flow outlives the event, don't keep storage shared
+	// with other events alive.
+	e = e.Clone()
+
+	f := &TopFlow{
+		ConnectionID: e.ConnectionID,
+		SrcAddr:      e.SrcAddr,
+		DstAddr:      e.DstAddr,
+		SrcPort:      e.SrcPort,
+		DstPort:      e.DstPort,
+		Proto:        helpers.ProtoIntStr(e.Proto),
+		Family:       helpers.FamilyStr(e.Family),
+		Connmark:     e.Connmark,
+		NetNS:        e.NetNS,
+		Zone:         e.Zone,
+		PacketsOrig:  e.PacketsOrig,
+		BytesOrig:    e.BytesOrig,
+		PacketsRet:   e.PacketsRet,
+		BytesRet:     e.BytesRet,
+		PacketsRate:  rate.PacketsOrig + rate.PacketsRet,
+		BytesRate:    rate.BytesOrig + rate.BytesRet,
+		Tags:         e.Tags,
+		ts:           e.Timestamp,
+		proto:        e.Proto,
+	}
+	if e.Proto == bpf.ProtoTCP {
+		f.TCPState = helpers.TCPStateStr(e.TCPState)
+	}
+
+	t.flows.Put(e.ConnectionID, e.Timestamp, f)
+}
+
+// rateFlow returns the flow's state for computing the rate of its next event.
+func (f *TopFlow) rateFlow() rateFlow {
+	return rateFlow{
+		ts:          f.ts,
+		packetsOrig: f.PacketsOrig,
+		bytesOrig:   f.BytesOrig,
+		packetsRet:  f.PacketsRet,
+		bytesRet:    f.BytesRet,
+	}
+}
+
+// top returns the n largest flows in the given order.
+func (t *topTracker) top(n int, by string) ([]TopFlow, error) {
+
+	var less func(a, b *TopFlow) bool
+	switch by {
+	case "", TopByRate:
+		less = func(a, b *TopFlow) bool { return a.BytesRate > b.BytesRate }
+	case TopByBytes:
+		less = func(a, b *TopFlow) bool { return a.BytesOrig+a.BytesRet > b.BytesOrig+b.BytesRet }
+	default:
+		return nil, errTopOrder
+	}
+
+	fs := t.live(FlowFilter{})
+
+	sort.Slice(fs, func(i, j int) bool { return less(fs[i], fs[j]) })
+
+	if n > 0 && n < len(fs) {
+		fs = fs[:n]
+	}
+
+	return copyFlows(fs), nil
+}
+
+// summary summarizes the live flows, including the n flows with the highest
+// byte rates.
+func (t *topTracker) summary(n int) FlowSummary {
+
+	fs := t.live(FlowFilter{})
+
+	sum := FlowSummary{Flows: len(fs), ByProto: make(map[string]int)}
+	for _, f := range fs {
+		sum.ByProto[f.Proto]++
+		sum.PacketsRate += f.PacketsRate
+		sum.BytesRate += f.BytesRate
+	}
+
+	sort.Slice(fs, func(i, j int) bool { return fs[i].BytesRate > fs[j].BytesRate })
+	if n < len(fs) {
+		fs = fs[:n]
+	}
+	sum.Top = copyFlows(fs)
+
+	return sum
+}
+
+// list returns all live flows matching the filter, ordered by connection ID.
+func (t *topTracker) list(ff FlowFilter) []TopFlow {
+
+	fs := t.live(ff)
+
+	sort.Slice(fs, func(i, j int) bool { return fs[i].ConnectionID < fs[j].ConnectionID })
+
+	return copyFlows(fs)
+}
+
+// live returns the live flows matching the filter.
+func (t *topTracker) live(ff FlowFilter) []*TopFlow {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fs := make([]*TopFlow, 0, t.flows.Len())
+	t.flows.Range(func(_ uint32, f *TopFlow) bool {
+		if ff.matchFlow(f) {
+			fs = append(fs, f)
+		}
+		return true
+	})
+
+	return fs
+}
+
+// copyFlows returns copies of the given flows.
+func copyFlows(fs []*TopFlow) []TopFlow {
+
+	out := make([]TopFlow, 0, len(fs))
+	for _, f := range fs {
+		out = append(out, *f)
+	}
+
+	return out
+}
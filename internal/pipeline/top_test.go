@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/flowcache"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestTopTracker(t *testing.T) {
+
+	tt := newTopTracker(flowcache.Config{})
+
+	ev := func(id uint32, ts time.Duration, bytes uint64) bpf.Event {
+		return bpf.Event{ConnectionID: id, Timestamp: uint64(ts), BytesOrig: bytes, Proto: 6}
+	}
+
+	// Flow 1 has the most bytes, flow 2 the highest rate.
+	tt.update(ev(1, 0, 10000), false)
+	tt.update(ev(1, time.Second, 10100), false)
+	tt.update(ev(2, 0, 100), false)
+	tt.update(ev(2, time.Second, 1100), false)
+	tt.update(ev(3, time.Second, 50), false)
+
+	fs, err := tt.top(2, TopByRate)
+	require.NoError(t, err)
+	require.Len(t, fs, 2)
+	assert.EqualValues(t, 2, fs[0].ConnectionID)
+	assert.EqualValues(t, 1000, fs[0].BytesRate)
+	assert.Equal(t, "tcp", fs[0].Proto)
+
+	fs, err = tt.top(0, TopByBytes)
+	require.NoError(t, err)
+	require.Len(t, fs, 3)
+	assert.EqualValues(t, 1, fs[0].ConnectionID)
+
+	// Destroyed flows leave the table.
+	tt.update(ev(1, 2*time.Second, 10200), true)
+	fs, _ = tt.top(0, TopByBytes)
+	assert.Len(t, fs, 2)
+
+	sum := tt.summary(1)
+	assert.Equal(t, 2, sum.Flows)
+	assert.Equal(t, map[string]int{"tcp": 2}, sum.ByProto)
+	require.Len(t, sum.Top, 1)
+	assert.EqualValues(t, 2, sum.Top[0].ConnectionID)
+
+	_, err = tt.top(1, "bogus")
+	assert.Error(t, err)
+}
+
+func TestTopTrackerList(t *testing.T) {
+
+	tt := newTopTracker(flowcache.Config{})
+
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+
+	tt.update(bpf.Event{ConnectionID: 2, SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(1, 1, 1, 1), DstPort: 443, Proto: 6}, false)
+	tt.update(bpf.Event{ConnectionID: 1, SrcAddr: net.IPv4(192, 168, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2), DstPort: 53, Proto: 17}, false)
+	tt.update(bpf.Event{ConnectionID: 3, SrcAddr: net.IPv4(192, 168, 0, 1), DstAddr: net.IPv4(1, 1, 1, 1), DstPort: 443, Proto: 6}, false)
+
+	fs := tt.list(FlowFilter{})
+	require.Len(t, fs, 3)
+	assert.EqualValues(t, 1, fs[0].ConnectionID)
+	assert.EqualValues(t, 3, fs[2].ConnectionID)
+
+	fs = tt.list(FlowFilter{Net: n})
+	require.Len(t, fs, 2)
+	assert.EqualValues(t, 1, fs[0].ConnectionID)
+	assert.EqualValues(t, 2, fs[1].ConnectionID)
+
+	fs = tt.list(FlowFilter{Port: 443, Proto: 6})
+	require.Len(t, fs, 2)
+	assert.EqualValues(t, 2, fs[0].ConnectionID)
+
+	assert.Len(t, tt.list(FlowFilter{Net: n, Proto: 17}), 1)
+	assert.Empty(t, tt.list(FlowFilter{Port: 22}))
+
+	assert.True(t, FlowFilter{Net: n, Port: 53}.Match(bpf.Event{DstAddr: net.IPv4(10, 0, 0, 2), DstPort: 53}))
+	assert.False(t, FlowFilter{Net: n, Proto: 6}.Match(bpf.Event{DstAddr: net.IPv4(10, 0, 0, 2), Proto: 17}))
+	assert.False(t, FlowFilter{Net: n, NetNS: 2}.Match(bpf.Event{DstAddr: net.IPv4(10, 0, 0, 2), NetNS: 1}))
+}
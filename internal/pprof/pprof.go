@@ -2,11 +2,12 @@ package pprof
 
 import (
 	"net/http"
+	"runtime"
 
-	log "github.com/sirupsen/logrus"
+	// Registers its handlers on http.DefaultServeMux as a side effect.
+	"net/http/pprof"
 
-	// side effect of registering HTTP handler in default ServeMux
-	_ "net/http/pprof"
+	log "github.com/sirupsen/logrus"
 )
 
 // ListenAndServe starts a pprof endpoint on the given addr
@@ -24,3 +25,26 @@ func ListenAndServe(addr string) {
 		log.Fatal(http.ListenAndServe(addr, ppm))
 	}()
 }
+
+// Handler returns a handler serving the profiles and the execution trace
+// under /debug/pprof/, for mounting on another HTTP server.
+func Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// SetProfileRates enables the block and mutex profiles, which are empty by
+// default. block is the average amount of nanoseconds spent blocked per
+// sampled blocking event, 1 sampling all of them. 1 in mutex contention
+// events is sampled. Zero disables a profile.
+func SetProfileRates(block, mutex int) {
+	runtime.SetBlockProfileRate(block)
+	runtime.SetMutexProfileFraction(mutex)
+}
@@ -0,0 +1,10 @@
+package privdrop
+
+import "errors"
+
+const errFmtRootUser = "user '%s' is root"
+
+var (
+	errNotRoot  = errors.New("dropping privileges requires running as root")
+	errRegained = errors.New("root privileges could be regained after dropping them")
+)
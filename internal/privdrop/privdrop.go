@@ -0,0 +1,117 @@
+// Package privdrop drops the root privileges of the process once the probe
+// is attached and all listeners are bound.
+//
+// The probe's perf or ring buffers are mapped and its maps opened before
+// dropping, reading them and updating the probe's maps through their file
+// descriptors doesn't need privileges. No capabilities are retained: they're
+// per-thread in Linux, and Go can't apply them to all of its threads when
+// built with cgo, which gobpf requires. Changing the user and group applies
+// to all threads and clears the capabilities of the process.
+//
+// Detaching the probe's kprobes and restoring sysctls on exit need root, and
+// fail once privileges are dropped. The probe's programs are detached when
+// the process exits regardless.
+package privdrop
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Drop switches the process to the given user and group, names or numeric
+// IDs. The group defaults to the user's primary group. Supplementary groups
+// are cleared. Returns an error if the process isn't running as root, or if
+// it can regain root after dropping.
+func Drop(name, group string) error {
+
+	if os.Geteuid() != 0 {
+		return errNotRoot
+	}
+
+	u, err := lookupUser(name)
+	if err != nil {
+		return err
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return errors.Wrapf(err, "parsing uid of user '%s'", name)
+	}
+
+	gid, err := lookupGroup(u, group)
+	if err != nil {
+		return err
+	}
+
+	if uid == 0 {
+		return errors.Errorf(errFmtRootUser, name)
+	}
+
+	// The group needs to be changed first, it can't be changed without root.
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return errors.Wrap(err, "clearing supplementary groups")
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return errors.Wrapf(err, "setting gid %d", gid)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return errors.Wrapf(err, "setting uid %d", uid)
+	}
+
+	// Make sure the process can't go back to root.
+	if err := syscall.Setuid(0); err == nil {
+		return errRegained
+	}
+
+	log.Infof("Dropped privileges to uid %d, gid %d", uid, gid)
+
+	return nil
+}
+
+// lookupUser looks up a user by name or numeric ID. Numeric IDs without
+// an entry in the user database are used as is, with a group of the same ID,
+// eg. in containers without /etc/passwd.
+func lookupUser(name string) (*user.User, error) {
+
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+
+	if _, nerr := strconv.Atoi(name); nerr != nil {
+		return nil, errors.Wrapf(err, "looking up user '%s'", name)
+	}
+
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+
+	return &user.User{Uid: name, Gid: name}, nil
+}
+
+// lookupGroup returns the gid of a group given by name or numeric ID,
+// or the primary group of user u if empty.
+func lookupGroup(u *user.User, group string) (int, error) {
+
+	id := u.Gid
+	if group != "" {
+		id = group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		} else if _, nerr := strconv.Atoi(group); nerr != nil {
+			return 0, errors.Wrapf(err, "looking up group '%s'", group)
+		}
+	}
+
+	gid, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing gid '%s'", id)
+	}
+
+	return gid, nil
+}
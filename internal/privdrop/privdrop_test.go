@@ -0,0 +1,31 @@
+package privdrop
+
+import (
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+
+	// Numeric IDs don't need an entry in the user database.
+	u, err := lookupUser("4242424")
+	require.NoError(t, err)
+	assert.Equal(t, "4242424", u.Uid)
+
+	gid, err := lookupGroup(u, "")
+	require.NoError(t, err)
+	assert.Equal(t, 4242424, gid)
+
+	gid, err = lookupGroup(u, "4343434")
+	require.NoError(t, err)
+	assert.Equal(t, 4343434, gid)
+
+	_, err = lookupUser("conntracct-nonexistent")
+	assert.Error(t, err)
+
+	_, err = lookupGroup(&user.User{}, "conntracct-nonexistent")
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+package rpcserver
+
+import "errors"
+
+var (
+	errNotInit = errors.New("rpcserver package not initialized, call Init() first")
+	errNoPipe  = errors.New("ceci n'est pas une pipe")
+
+	errSinkName          = errors.New("sink name must be set")
+	errRuntimeDeadLetter = errors.New("dead-letter sinks can only be set in the configuration file")
+	errRuntimeStandby    = errors.New("standby sinks can only be set in the configuration file")
+	errRuntimeCommand    = errors.New("plugin commands can only be set in the configuration file, use an address")
+	errRuntimePath       = errors.New("sinks writing files can only be set in the configuration file")
+)
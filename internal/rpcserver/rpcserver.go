@@ -0,0 +1,68 @@
+// Package rpcserver implements conntracct's gRPC API, serving the pipeline's
+// statistics, managing its sinks and streaming its events to clients.
+//
+// The service is described in conntracct.proto. Besides events, it only uses
+// the protobuf well-known types, so it can be called by any gRPC client
+// without generated code, eg. grpcurl.
+package rpcserver
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+)
+
+var (
+	// Processing pipeline handle
+	pipe *pipeline.Pipeline
+
+	// Whether or not package was successfully initialized
+	initSuccess bool
+)
+
+// Init configures the package with handles to the objects it manipulates.
+func Init(p *pipeline.Pipeline) error {
+
+	if p != nil {
+		pipe = p
+	} else {
+		return errNoPipe
+	}
+
+	// Mark package as initialized
+	initSuccess = true
+
+	return nil
+}
+
+// Run the gRPC listener.
+func Run(addr string) error {
+
+	// Check if the package was properly initialized
+	if !initSuccess {
+		return errNotInit
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listening for gRPC connections")
+	}
+
+	s := grpc.NewServer()
+	s.RegisterService(&serviceDesc, &server{pipe: pipe, clock: boottime.NewClock()})
+
+	go func() {
+		if err := s.Serve(l); err != nil {
+			log.Fatalf("Error in gRPC listener: %s", err)
+		}
+	}()
+
+	log.Infof("gRPC server listening on address '%s'", addr)
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package rpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/internal/sinks"
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+// Amount of events buffered for a streaming client before dropping them.
+const streamBufferSize = 1024
+
+// server implements the Conntracct service on top of a Pipeline.
+type server struct {
+	pipe *pipeline.Pipeline
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+}
+
+// Stats returns the statistics of the pipeline, its probe and its sinks.
+func (s *server) Stats(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+
+	ss := make(map[string]types.SinkStatsData)
+	for _, sink := range s.pipe.GetSinks() {
+		ss[sink.Name()] = sink.Stats()
+	}
+
+	out, err := toStruct(map[string]interface{}{
+		"pipeline": s.pipe.Stats(),
+		"probe":    s.pipe.ProbeStats(),
+		"sinks":    ss,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return out, nil
+}
+
+// ListSinks returns the names of the sinks registered to the pipeline.
+func (s *server) ListSinks(ctx context.Context, _ *emptypb.Empty) (*structpb.ListValue, error) {
+
+	out := &structpb.ListValue{}
+	for _, sink := range s.pipe.GetSinks() {
+		out.Values = append(out.Values, structpb.NewStringValue(sink.Name()))
+	}
+
+	return out, nil
+}
+
+// AddSink creates a sink from the request's name and parameters
+// and registers it to the pipeline.
+func (s *server) AddSink(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, errSinkName.Error())
+	}
+	params := req.GetFields()["params"].GetStructValue().AsMap()
+
+	scfg, err := types.DecodeSinkConfigMap(map[string]interface{}{name: params})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	sc := scfg[0]
+
+	// Dead-letter and standby sinks are resolved across all sinks of the configuration file.
+	if sc.DeadLetter != "" {
+		return nil, status.Error(codes.InvalidArgument, errRuntimeDeadLetter.Error())
+	}
+	if len(sc.Standby) != 0 {
+		return nil, status.Error(codes.InvalidArgument, errRuntimeStandby.Error())
+	}
+
+	// Sinks added at runtime don't run commands or write files, the API
+	// doesn't authenticate its clients.
+	if sc.Command != "" {
+		return nil, status.Error(codes.InvalidArgument, errRuntimeCommand.Error())
+	}
+	if sc.Path != "" || sc.SpoolDir != "" {
+		return nil, status.Error(codes.InvalidArgument, errRuntimePath.Error())
+	}
+
+	sink, err := sinks.New(sc)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := s.pipe.RegisterSink(sink); err != nil {
+		if cerr := sink.Close(); cerr != nil {
+			log.Errorf("Error closing accounting sink '%s': %s", name, cerr)
+		}
+
+		if err == pipeline.ErrSinkExists {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// RemoveSink unregisters a sink from the pipeline and closes it.
+func (s *server) RemoveSink(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+
+	name := req.GetValue()
+
+	sink, err := s.pipe.RemoveSink(name)
+	if err == pipeline.ErrSinkNotFound {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := sink.Close(); err != nil {
+		return nil, status.Errorf(codes.Internal, "closing sink: %s", err)
+	}
+
+	log.Infof("Closed accounting sink '%s'", name)
+
+	return &emptypb.Empty{}, nil
+}
+
+// Flush flushes all sinks, or until the client goes away.
+func (s *server) Flush(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+
+	if err := s.pipe.Flush(ctx); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// StreamEvents streams the pipeline's events matching the request
+// until the client goes away.
+func (s *server) StreamEvents(req *structpb.Struct, stream grpc.ServerStream) error {
+
+	match, err := eventMatcher(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	t := s.pipe.Tap(streamBufferSize, match)
+	defer t.Close()
+
+	defer func() {
+		stream.SetTrailer(metadata.Pairs("dropped-events", strconv.FormatUint(t.Dropped(), 10)))
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e := <-t.C:
+			if err := stream.SendMsg(schema.FromEvent(e, s.clock.Boot())); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// eventMatcher returns a function matching the events selected by the
+// 'filter', 'update' and 'destroy' fields of a StreamEvents request.
+func eventMatcher(req *structpb.Struct) (func(bpf.Event) bool, error) {
+
+	fields := req.GetFields()
+
+	var f *filter.Filter
+	if expr := fields["filter"].GetStringValue(); expr != "" {
+		var err error
+		if f, err = filter.New(expr); err != nil {
+			return nil, err
+		}
+	}
+
+	update, destroy := fields["update"].GetBoolValue(), fields["destroy"].GetBoolValue()
+	if !update && !destroy {
+		update, destroy = true, true
+	}
+
+	return func(e bpf.Event) bool {
+		if e.Destroy && !destroy || !e.Destroy && !update {
+			return false
+		}
+		return f == nil || f.Match(e)
+	}, nil
+}
+
+// toStruct converts v to a Struct through its JSON representation.
+func toStruct(v interface{}) (*structpb.Struct, error) {
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStruct(m)
+}
@@ -0,0 +1,68 @@
+package rpcserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestEventMatcher(t *testing.T) {
+
+	update := bpf.Event{DstPort: 443, Proto: 6}
+	destroy := bpf.Event{DstPort: 53, Proto: 17, Destroy: true}
+
+	for _, tt := range []struct {
+		name            string
+		req             map[string]interface{}
+		update, destroy bool
+	}{
+		{name: "all", req: nil, update: true, destroy: true},
+		{name: "update", req: map[string]interface{}{"update": true}, update: true},
+		{name: "destroy", req: map[string]interface{}{"destroy": true}, destroy: true},
+		{name: "filter", req: map[string]interface{}{"filter": "proto == 17"}, destroy: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := structpb.NewStruct(tt.req)
+			require.NoError(t, err)
+
+			m, err := eventMatcher(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.update, m(update))
+			assert.Equal(t, tt.destroy, m(destroy))
+		})
+	}
+
+	req, _ := structpb.NewStruct(map[string]interface{}{"filter": "bogus =="})
+	_, err := eventMatcher(req)
+	assert.Error(t, err)
+}
+
+func TestAddSinkRuntime(t *testing.T) {
+
+	var s server
+
+	for _, tt := range []struct {
+		params map[string]interface{}
+		err    error
+	}{
+		{params: map[string]interface{}{"type": "plugin", "command": "/bin/sh"}, err: errRuntimeCommand},
+		{params: map[string]interface{}{"type": "csv", "path": "/etc/cron.d/x"}, err: errRuntimePath},
+		{params: map[string]interface{}{"type": "stdout", "spoolDir": "/root"}, err: errRuntimePath},
+	} {
+		req, err := structpb.NewStruct(map[string]interface{}{"name": "x", "params": tt.params})
+		require.NoError(t, err)
+
+		// Rejected before the sink is created.
+		_, err = s.AddSink(context.Background(), req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, tt.err.Error(), status.Convert(err).Message())
+	}
+}
@@ -0,0 +1,100 @@
+package rpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// serviceName is the fully-qualified name of the Conntracct service
+// in conntracct.proto.
+const serviceName = "conntracct.Conntracct"
+
+// service is the server API of the Conntracct service.
+type service interface {
+	Stats(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	ListSinks(context.Context, *emptypb.Empty) (*structpb.ListValue, error)
+	AddSink(context.Context, *structpb.Struct) (*emptypb.Empty, error)
+	RemoveSink(context.Context, *wrapperspb.StringValue) (*emptypb.Empty, error)
+	Flush(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	StreamEvents(*structpb.Struct, grpc.ServerStream) error // streams schema.Events
+}
+
+// methodHandler is the handler of a unary method of a grpc.ServiceDesc.
+type methodHandler = func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+// serviceDesc describes the Conntracct service to the gRPC server.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*service)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Stats", Handler: unary("Stats", newEmpty,
+			func(s service, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Stats(ctx, req.(*emptypb.Empty))
+			})},
+		{MethodName: "ListSinks", Handler: unary("ListSinks", newEmpty,
+			func(s service, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.ListSinks(ctx, req.(*emptypb.Empty))
+			})},
+		{MethodName: "AddSink", Handler: unary("AddSink", newStruct,
+			func(s service, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.AddSink(ctx, req.(*structpb.Struct))
+			})},
+		{MethodName: "RemoveSink", Handler: unary("RemoveSink", newString,
+			func(s service, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.RemoveSink(ctx, req.(*wrapperspb.StringValue))
+			})},
+		{MethodName: "Flush", Handler: unary("Flush", newEmpty,
+			func(s service, ctx context.Context, req interface{}) (interface{}, error) {
+				return s.Flush(ctx, req.(*emptypb.Empty))
+			})},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(structpb.Struct)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(service).StreamEvents(req, stream)
+			},
+		},
+	},
+	Metadata: "conntracct.proto",
+}
+
+// unary returns the gRPC handler of a unary method, decoding its request
+// into a message returned by newReq and calling f through the server's
+// interceptor, if any.
+func unary(method string, newReq func() interface{},
+	f func(service, context.Context, interface{}) (interface{}, error)) methodHandler {
+
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+		in := newReq()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+
+		if interceptor == nil {
+			return f(srv.(service), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return f(srv.(service), ctx, req)
+		})
+	}
+}
+
+// Constructors of the request messages of unary methods.
+func newEmpty() interface{}  { return new(emptypb.Empty) }
+func newStruct() interface{} { return new(structpb.Struct) }
+func newString() interface{} { return new(wrapperspb.StringValue) }
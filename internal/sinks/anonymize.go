@@ -0,0 +1,26 @@
+package sinks
+
+import (
+	"github.com/ti-mo/conntracct/internal/sinks/anonymize"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// anonymizedSink wraps a Sink, anonymizing the addresses of events
+// before pushing them.
+type anonymizedSink struct {
+	Sink
+
+	anon *anonymize.Anonymizer
+}
+
+// Push an accounting event with anonymized addresses into the underlying sink.
+func (s *anonymizedSink) Push(e bpf.Event) error {
+	return s.Sink.Push(s.anon.Anonymize(e))
+}
+
+// PushBatch pushes a batch of accounting events with anonymized addresses
+// into the underlying sink.
+func (s *anonymizedSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
@@ -0,0 +1,116 @@
+// Package anonymize rewrites the addresses of accounting events before
+// they're pushed to a sink, by hashing or truncating them.
+package anonymize
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultTruncateIPv4Prefix = 24
+	defaultTruncateIPv6Prefix = 48
+)
+
+// Anonymizer rewrites the source and destination addresses of events.
+// Safe for concurrent use.
+type Anonymizer struct {
+	hash bool
+
+	// SipHash key.
+	k0, k1 uint64
+
+	// Amount of leading bits of addresses kept.
+	v4Prefix, v6Prefix int
+}
+
+// New returns an Anonymizer for the given configuration.
+func New(cfg types.AnonymizeConfig) (*Anonymizer, error) {
+
+	a := &Anonymizer{
+		v4Prefix: int(cfg.IPv4Prefix),
+		v6Prefix: int(cfg.IPv6Prefix),
+	}
+
+	switch cfg.Mode {
+	case "hash":
+		k, err := hex.DecodeString(cfg.Key)
+		if err != nil || len(k) != 16 {
+			return nil, errKey
+		}
+		a.hash = true
+		a.k0 = binary.LittleEndian.Uint64(k[:8])
+		a.k1 = binary.LittleEndian.Uint64(k[8:])
+	case "truncate":
+		if a.v4Prefix == 0 {
+			a.v4Prefix = defaultTruncateIPv4Prefix
+		}
+		if a.v6Prefix == 0 {
+			a.v6Prefix = defaultTruncateIPv6Prefix
+		}
+	default:
+		return nil, fmt.Errorf(errFmtMode, cfg.Mode)
+	}
+
+	if a.v4Prefix > 8*net.IPv4len || a.v6Prefix > 8*net.IPv6len {
+		return nil, errPrefix
+	}
+
+	return a, nil
+}
+
+// Anonymize returns the event with its addresses anonymized. The addresses
+// are replaced rather than modified, events are shared between sinks.
+func (a *Anonymizer) Anonymize(e bpf.Event) bpf.Event {
+	e.SrcAddr = a.Addr(e.SrcAddr)
+	e.DstAddr = a.Addr(e.DstAddr)
+	return e
+}
+
+// Addr returns the anonymized form of ip. The leading prefix bits of the
+// address are kept, the others are zeroed or replaced with bits of the
+// address' keyed hash.
+func (a *Anonymizer) Addr(ip net.IP) net.IP {
+
+	out, prefix := ip.To4(), a.v4Prefix
+	if out == nil {
+		out, prefix = ip.To16(), a.v6Prefix
+	}
+	if out == nil {
+		return ip
+	}
+
+	// Copy the address, ip may be shared with other events.
+	out = append(net.IP(nil), out...)
+
+	var h []byte
+	if a.hash {
+		// Two hashes cover the 128 bits of an IPv6 address.
+		h = make([]byte, 16)
+		binary.BigEndian.PutUint64(h[:8], sipHash(a.k0, a.k1, append(out, 0)))
+		binary.BigEndian.PutUint64(h[8:], sipHash(a.k0, a.k1, append(out, 1)))
+	}
+
+	for i := range out {
+		// Mask of the host bits of the byte.
+		var mask byte = 0xff
+		switch {
+		case prefix >= 8*(i+1):
+			continue
+		case prefix > 8*i:
+			mask >>= uint(prefix - 8*i)
+		}
+
+		out[i] &^= mask
+		if h != nil {
+			out[i] |= h[i] & mask
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,75 @@
+package anonymize
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f"
+
+func TestSipHash(t *testing.T) {
+
+	// Test vectors of the SipHash paper, key 00..0f and messages 00..(n-1).
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	assert.Equal(t, uint64(0x726fdb47dd0e0e31), sipHash(k0, k1, nil))
+	assert.Equal(t, uint64(0xa129ca6149be45e5), sipHash(k0, k1, msg))
+}
+
+func TestTruncate(t *testing.T) {
+
+	a, err := New(types.AnonymizeConfig{Mode: "truncate"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "192.0.2.0", a.Addr(net.ParseIP("192.0.2.123")).String())
+	assert.Equal(t, "2001:db8:1::", a.Addr(net.ParseIP("2001:db8:1:2::1")).String())
+
+	a, err = New(types.AnonymizeConfig{Mode: "truncate", IPv4Prefix: 20})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.16.0", a.Addr(net.ParseIP("192.0.31.255")).String())
+}
+
+func TestHash(t *testing.T) {
+
+	a, err := New(types.AnonymizeConfig{Mode: "hash", Key: testKey, IPv4Prefix: 16})
+	require.NoError(t, err)
+
+	src := net.ParseIP("192.0.2.1")
+	e := a.Anonymize(bpf.Event{SrcAddr: src, DstAddr: net.ParseIP("192.0.2.2")})
+
+	// Addresses hash consistently, keeping their prefix.
+	assert.Equal(t, e.SrcAddr, a.Addr(src))
+	assert.NotEqual(t, e.SrcAddr, e.DstAddr)
+	assert.True(t, (&net.IPNet{IP: net.IPv4(192, 0, 0, 0), Mask: net.CIDRMask(16, 32)}).Contains(e.SrcAddr))
+	assert.NotEqual(t, "192.0.2.1", e.SrcAddr.String())
+
+	// The original address is left alone.
+	assert.Equal(t, "192.0.2.1", src.String())
+
+	// Other keys yield other addresses.
+	b, err := New(types.AnonymizeConfig{Mode: "hash", Key: "0f0e0d0c0b0a09080706050403020100"})
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Addr(src), b.Addr(src))
+}
+
+func TestNewErrors(t *testing.T) {
+
+	_, err := New(types.AnonymizeConfig{Mode: "scramble"})
+	assert.Error(t, err)
+
+	_, err = New(types.AnonymizeConfig{Mode: "hash", Key: "00"})
+	assert.Equal(t, errKey, err)
+
+	_, err = New(types.AnonymizeConfig{Mode: "truncate", IPv4Prefix: 33})
+	assert.Equal(t, errPrefix, err)
+}
@@ -0,0 +1,10 @@
+package anonymize
+
+import "errors"
+
+var (
+	errKey    = errors.New("mode hash requires a hex-encoded 16-byte key")
+	errPrefix = errors.New("prefix length exceeds the address length")
+)
+
+const errFmtMode = "unknown anonymization mode '%s', expected hash or truncate"
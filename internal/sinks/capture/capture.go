@@ -0,0 +1,176 @@
+package capture
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	capfile "github.com/ti-mo/conntracct/internal/capture"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// CaptureSink is an accounting sink recording events to a capture file,
+// to be replayed through the pipeline later on. Tags and other fields set
+// in userspace are not recorded.
+type CaptureSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Capture file and its writer. Set closed when the sink is closed,
+	// events pushed afterwards are dropped.
+	mu     sync.Mutex
+	file   *os.File
+	writer *capfile.Writer
+	closed bool
+
+	// Sink stats.
+	stats types.SinkStats
+}
+
+// New returns a new capture sink.
+func New() CaptureSink {
+	return CaptureSink{}
+}
+
+// Init initializes the capture sink, truncating the capture file
+// if it already exists.
+func (s *CaptureSink) Init(sc types.SinkConfig) error {
+
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Path == "" {
+		return errEmptySinkPath
+	}
+	if sc.Type != types.Capture {
+		return errInvalidSinkType
+	}
+
+	f, err := os.OpenFile(sc.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "opening capture file")
+	}
+
+	w, err := capfile.NewWriter(f, boottime.Estimate())
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing capture header")
+	}
+
+	s.file = f
+	s.writer = w
+	s.config = sc
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push writes an accounting event to the capture file's buffer.
+func (s *CaptureSink) Push(e bpf.Event) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
+	if err := s.writer.Write(e); err != nil {
+		s.stats.IncrEventErrors()
+		return errors.Wrap(err, "writing event")
+	}
+
+	s.stats.IncrEventsPushed()
+
+	return nil
+}
+
+// PushBatch writes a batch of accounting events to the capture file's buffer.
+func (s *CaptureSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// Flush writes the buffered events to the capture file.
+func (s *CaptureSink) Flush(ctx context.Context) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSinkClosed
+	}
+
+	return s.flush()
+}
+
+// flush writes the buffered events to the capture file and records
+// the result in the sink's statistics. mu must be held.
+func (s *CaptureSink) flush() error {
+
+	// The writer also writes when its buffer fills up, so the
+	// flushed events aren't counted.
+	start := time.Now()
+	err := s.writer.Flush()
+	s.stats.ObserveFlush(time.Since(start), 0, err)
+	if err != nil {
+		s.stats.IncrBatchDropped()
+		return errors.Wrap(err, "writing capture file")
+	}
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// Close writes the buffered events and closes the capture file.
+// Must only be called once.
+func (s *CaptureSink) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	if err := s.flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	return s.file.Close()
+}
+
+// Name gets the name of the capture sink.
+func (s *CaptureSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the capture sink was successfully initialized.
+func (s *CaptureSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true, captures are replayed in full.
+func (s *CaptureSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, captures are replayed in full.
+func (s *CaptureSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the capture sink's statistics structure.
+func (s *CaptureSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
@@ -0,0 +1,10 @@
+package capture
+
+import "errors"
+
+var (
+	errEmptySinkName   = errors.New("empty sink name")
+	errEmptySinkPath   = errors.New("empty sink path")
+	errInvalidSinkType = errors.New("invalid sink type")
+	errSinkClosed      = errors.New("sink is closed")
+)
@@ -0,0 +1,257 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	// Register the ClickHouse native protocol driver with database/sql.
+	_ "github.com/kshvakov/clickhouse"
+
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultBatchSize     = 1024
+	defaultFlushInterval = time.Second
+	defaultWorkers       = 1
+	defaultTable         = "conntracct"
+)
+
+// ClickHouseSink is an accounting sink writing to ClickHouse
+// over its native TCP protocol.
+type ClickHouseSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Database handle.
+	db *sql.DB
+
+	// Columns of the table that are filled, and the INSERT statement
+	// used to write them.
+	columns []string
+	insert  string
+
+	// Row batch, handed to the send workers.
+	batch *types.Batcher[[][]interface{}]
+
+	// Sink stats.
+	stats types.SinkStats
+
+	// Receives the events of batches that failed to be written.
+	deadLetter types.DeadLetter
+}
+
+// New returns a new ClickHouse accounting sink.
+func New() ClickHouseSink {
+	return ClickHouseSink{}
+}
+
+// Init initializes the ClickHouse accounting sink. Creates the configured
+// table if it does not yet exist.
+func (s *ClickHouseSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Address == "" {
+		return errEmptySinkAddress
+	}
+	if sc.Type != types.ClickHouse {
+		return errInvalidSinkType
+	}
+	if sc.BatchSize == 0 {
+		sc.BatchSize = defaultBatchSize
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Workers == 0 {
+		sc.Workers = defaultWorkers
+	}
+
+	b, err := types.NewBatcher(sc, &s.stats, &s.deadLetter, newBatch, s.send)
+	if err != nil {
+		return err
+	}
+	s.batch = b
+	if sc.Table == "" {
+		sc.Table = defaultTable
+	}
+	if !validTable(sc.Table) {
+		return fmt.Errorf(errFmtInvalidTable, sc.Table)
+	}
+
+	cols := sc.Columns
+	if len(cols) == 0 {
+		cols = defaultColumns
+	}
+	for _, c := range cols {
+		if _, ok := columns[c]; !ok {
+			return fmt.Errorf(errFmtUnknownColumn, c)
+		}
+	}
+
+	// The driver verifies servers against the system's CAs.
+	if t := sc.TLS; t.CA != "" || t.Cert != "" || t.Key != "" || t.ServerName != "" {
+		return errTLSOptions
+	}
+
+	db, err := sql.Open("clickhouse", dsn(sc))
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createTableQuery(sc.Table, cols)); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("creating table '%s'", sc.Table))
+	}
+
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
+
+	s.db = db     // database handle
+	s.config = sc // config
+	s.columns = cols
+	s.insert = insertQuery(sc.Table, cols)
+
+	s.batch.Start()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push an accounting event into the buffer of the ClickHouse accounting sink.
+func (s *ClickHouseSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+// PushBatch pushes a batch of accounting events into the buffer of the
+// ClickHouse accounting sink.
+func (s *ClickHouseSink) PushBatch(es []bpf.Event) (int, error) {
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	if s.batch.Closed() {
+		s.stats.AddEventsDropped(len(es))
+		return len(es), errSinkClosed
+	}
+
+	for _, e := range es {
+		row := make([]interface{}, len(s.columns))
+		for i, c := range s.columns {
+			row[i] = columns[c].value(s, e)
+		}
+
+		// Add the row to the batch.
+		batch := s.batch.Batch()
+		*batch = append(*batch, row)
+		s.batch.Added(e)
+	}
+
+	return 0, nil
+}
+
+// Flush sends the sink's current batch to the send workers and waits until
+// it and all batches queued before it are written, or until ctx is done.
+// Returns the error of writing the current batch.
+func (s *ClickHouseSink) Flush(ctx context.Context) error {
+	return s.batch.Flush(ctx)
+}
+
+// CheckHealth checks if the ClickHouse server responds to pings.
+func (s *ClickHouseSink) CheckHealth(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close waits for the sink's current and all pending batches to be written
+// before closing the database handle. Must only be called once.
+func (s *ClickHouseSink) Close() error {
+	s.batch.Close()
+	return s.db.Close()
+}
+
+// SetDeadLetter sets the function receiving the events of batches
+// the sink failed to write.
+func (s *ClickHouseSink) SetDeadLetter(f types.DeadLetterFunc) {
+	s.deadLetter.Set(f)
+}
+
+// Name gets the name of the ClickHouse accounting sink.
+func (s *ClickHouseSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the ClickHouse accounting sink was successfully initialized.
+func (s *ClickHouseSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *ClickHouseSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, ClickHouse receives destroy events. (flow totals)
+func (s *ClickHouseSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the ClickHouse accounting sink's statistics structure.
+func (s *ClickHouseSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// newBatch allocates a new, empty row batch.
+func newBatch(watermark int) [][]interface{} {
+	return make([][]interface{}, 0, watermark)
+}
+
+// dsn builds a ClickHouse driver connection string from a SinkConfig.
+func dsn(sc types.SinkConfig) string {
+
+	q := url.Values{}
+	if sc.Username != "" {
+		q.Set("username", sc.Username)
+	}
+	if sc.Password != "" {
+		q.Set("password", sc.Password)
+	}
+	if sc.Database != "" {
+		q.Set("database", sc.Database)
+	}
+	if sc.Timeout != 0 {
+		// The driver expects timeouts in whole seconds.
+		q.Set("write_timeout", strconv.Itoa(int(sc.Timeout.Seconds())))
+	}
+	if sc.TLS.Active() {
+		q.Set("secure", "true")
+		q.Set("skip_verify", strconv.FormatBool(sc.TLS.InsecureSkipVerify))
+	}
+
+	u := url.URL{
+		Scheme:   "tcp",
+		Host:     sc.Address,
+		RawQuery: q.Encode(),
+	}
+
+	return u.String()
+}
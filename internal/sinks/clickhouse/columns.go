@@ -0,0 +1,99 @@
+package clickhouse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ti-mo/conntracct/internal/sinks/helpers"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// column is a column of the accounting table that can be filled
+// from an accounting event.
+type column struct {
+	// ClickHouse data type of the column.
+	typ string
+	// Extracts the column's value from an Event.
+	value func(s *ClickHouseSink, e bpf.Event) interface{}
+}
+
+// columns holds all columns that can be selected in the sink's configuration.
+var columns = map[string]column{
+	"timestamp": {"DateTime", func(s *ClickHouseSink, e bpf.Event) interface{} {
+		return e.Time(s.clock.Boot())
+	}},
+	"event_type":   {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Type() }},
+	"start":        {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Start }},
+	"duration":     {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return uint64(e.Duration) }},
+	"conn_id":      {"UInt32", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.ConnectionID }},
+	"connmark":     {"UInt32", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Connmark }},
+	"src_addr":     {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.SrcAddr.String() }},
+	"dst_addr":     {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.DstAddr.String() }},
+	"src_port":     {"UInt16", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.SrcPort }},
+	"dst_port":     {"UInt16", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.DstPort }},
+	"proto":        {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return helpers.ProtoIntStr(e.Proto) }},
+	"tcp_state":    {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return helpers.TCPStateStr(e.TCPState) }},
+	"icmp_id":      {"UInt16", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.ICMPID }},
+	"icmp_type":    {"UInt8", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.ICMPType }},
+	"icmp_code":    {"UInt8", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.ICMPCode }},
+	"family":       {"String", func(_ *ClickHouseSink, e bpf.Event) interface{} { return helpers.FamilyStr(e.Family) }},
+	"netns":        {"UInt32", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.NetNS }},
+	"ifindex":      {"UInt32", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Ifindex }},
+	"zone":         {"UInt16", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Zone }},
+	"sample_rate":  {"UInt32", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.SampleRate }},
+	"packets_orig": {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.PacketsOrig }},
+	"bytes_orig":   {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.BytesOrig }},
+	"packets_ret":  {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.PacketsRet }},
+	"bytes_ret":    {"UInt64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.BytesRet }},
+
+	// Throughput since the flow's previous event, zero if not computed.
+	"packets_orig_rate": {"Float64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Rate.PacketsOrig }},
+	"bytes_orig_rate":   {"Float64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Rate.BytesOrig }},
+	"packets_ret_rate":  {"Float64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Rate.PacketsRet }},
+	"bytes_ret_rate":    {"Float64", func(_ *ClickHouseSink, e bpf.Event) interface{} { return e.Rate.BytesRet }},
+}
+
+// tableName matches table names, optionally qualified by a database.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validTable returns true if table is a plain, optionally database-qualified
+// identifier, safe to use in queries without quoting.
+func validTable(table string) bool {
+	return tableName.MatchString(table)
+}
+
+// defaultColumns is the list of columns written when none are configured.
+var defaultColumns = []string{
+	"timestamp", "conn_id", "connmark",
+	"src_addr", "dst_addr", "dst_port", "proto", "netns",
+	"packets_orig", "bytes_orig", "packets_ret", "bytes_ret",
+}
+
+// createTableQuery returns a CREATE TABLE statement for the given table and columns.
+func createTableQuery(table string, cols []string) string {
+
+	defs := make([]string, 0, len(cols))
+	order := "tuple()"
+
+	for _, c := range cols {
+		defs = append(defs, fmt.Sprintf("%s %s", c, columns[c].typ))
+
+		// Order the table by time if the timestamp column is present.
+		if c == "timestamp" {
+			order = "timestamp"
+		}
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree() ORDER BY %s",
+		table, strings.Join(defs, ", "), order)
+}
+
+// insertQuery returns a parameterized INSERT statement for the given table and columns.
+func insertQuery(table string, cols []string) string {
+
+	params := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(cols, ", "), params)
+}
@@ -0,0 +1,118 @@
+package clickhouse
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestQueries(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		table  string
+		cols   []string
+		create string
+		insert string
+	}{
+		{
+			name:   "ordered by timestamp",
+			table:  "conntracct",
+			cols:   []string{"conn_id", "timestamp"},
+			create: "CREATE TABLE IF NOT EXISTS conntracct (conn_id UInt32, timestamp DateTime) ENGINE = MergeTree() ORDER BY timestamp",
+			insert: "INSERT INTO conntracct (conn_id, timestamp) VALUES (?, ?)",
+		},
+		{
+			name:   "unordered",
+			table:  "db.flows",
+			cols:   []string{"src_addr", "bytes_orig_rate", "proto"},
+			create: "CREATE TABLE IF NOT EXISTS db.flows (src_addr String, bytes_orig_rate Float64, proto String) ENGINE = MergeTree() ORDER BY tuple()",
+			insert: "INSERT INTO db.flows (src_addr, bytes_orig_rate, proto) VALUES (?, ?, ?)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.create, createTableQuery(tt.table, tt.cols))
+			assert.Equal(t, tt.insert, insertQuery(tt.table, tt.cols))
+		})
+	}
+}
+
+func TestValidTable(t *testing.T) {
+
+	for table, valid := range map[string]bool{
+		"conntracct":       true,
+		"_flows2":          true,
+		"db.flows":         true,
+		"":                 false,
+		"2flows":           false,
+		"db.":              false,
+		"a.b.c":            false,
+		"flows; DROP x":    false,
+		"`flows`":          false,
+		"flows (a String)": false,
+	} {
+		assert.Equal(t, valid, validTable(table), table)
+	}
+
+	// Invalid table names are rejected before connecting.
+	s := New()
+	err := s.Init(types.SinkConfig{
+		Name:    "test",
+		Type:    types.ClickHouse,
+		Address: "localhost:9000",
+		Table:   "flows; DROP TABLE flows",
+	})
+	assert.EqualError(t, err, fmt.Sprintf(errFmtInvalidTable, "flows; DROP TABLE flows"))
+
+	err = s.Init(types.SinkConfig{
+		Name:    "test",
+		Type:    types.ClickHouse,
+		Address: "localhost:9000",
+		Columns: []string{"conn_id", "bogus"},
+	})
+	assert.EqualError(t, err, fmt.Sprintf(errFmtUnknownColumn, "bogus"))
+}
+
+func TestColumns(t *testing.T) {
+
+	e := bpf.Event{
+		Destroy:      true,
+		ConnectionID: 42,
+		SrcAddr:      net.ParseIP("192.0.2.1"),
+		DstAddr:      net.ParseIP("2001:db8::1"),
+		DstPort:      443,
+		Proto:        6,
+		NetNS:        4026531993,
+		BytesOrig:    1000,
+		Rate:         bpf.Rate{BytesOrig: 12.5},
+	}
+
+	tests := map[string]interface{}{
+		"event_type":      "destroy",
+		"conn_id":         uint32(42),
+		"src_addr":        "192.0.2.1",
+		"dst_addr":        "2001:db8::1",
+		"dst_port":        uint16(443),
+		"proto":           "tcp",
+		"netns":           uint32(4026531993),
+		"bytes_orig":      uint64(1000),
+		"bytes_orig_rate": 12.5,
+	}
+
+	var s ClickHouseSink
+	for c, want := range tests {
+		assert.Equal(t, want, columns[c].value(&s, e), c)
+	}
+
+	// All default columns exist.
+	for _, c := range defaultColumns {
+		assert.Contains(t, columns, c)
+	}
+}
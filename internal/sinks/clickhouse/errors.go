@@ -0,0 +1,20 @@
+package clickhouse
+
+import (
+	"errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	errFmtUnknownColumn = "unknown column '%s'"
+	errFmtInvalidTable  = "invalid table name '%s'"
+)
+
+var (
+	errEmptySinkName    = errors.New("empty sink name")
+	errEmptySinkAddress = errors.New("empty sink address")
+	errInvalidSinkType  = errors.New("invalid sink type")
+	errSinkClosed       = types.ErrSinkClosed
+	errTLSOptions       = errors.New("tls: the driver only supports insecureSkipVerify, not ca, cert, key or serverName")
+)
@@ -0,0 +1,59 @@
+package clickhouse
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// send inserts a batch into the table in a single transaction.
+// The events of a batch that fails to be written are handed to the sink's
+// dead letter function.
+func (s *ClickHouseSink) send(b [][]interface{}, events []bpf.Event) error {
+
+	// Write the batch
+	start := time.Now()
+	err := s.write(b)
+	s.stats.ObserveFlush(time.Since(start), len(b), err)
+	if err != nil {
+		log.Errorf("ClickHouse sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		s.deadLetter.Send(s.config.Name, events)
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// write inserts a batch of rows into the sink's table. ClickHouse bulk
+// inserts are done by preparing and executing a statement within a transaction,
+// the rows are sent to the server as a single block on commit.
+func (s *ClickHouseSink) write(b [][]interface{}) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.insert)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, row := range b {
+		if _, err := stmt.Exec(row...); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
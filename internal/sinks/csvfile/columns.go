@@ -0,0 +1,69 @@
+package csvfile
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// column extracts the value of a CSV column from an event, given the
+// machine's boot time.
+type column func(e bpf.Event, boot time.Time) string
+
+// columns holds all columns that can be selected in the sink's configuration.
+// Names and values follow the ClickHouse sink.
+var columns = map[string]column{
+	"timestamp": func(e bpf.Event, boot time.Time) string {
+		return e.Time(boot).UTC().Format(time.RFC3339Nano)
+	},
+	"event_type": func(e bpf.Event, _ time.Time) string { return e.Type() },
+	"start": func(e bpf.Event, _ time.Time) string {
+		if e.Start == 0 {
+			return ""
+		}
+		return time.Unix(0, int64(e.Start)).UTC().Format(time.RFC3339Nano)
+	},
+	"duration":     func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.Duration)) },
+	"conn_id":      func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.ConnectionID)) },
+	"connmark":     func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.Connmark)) },
+	"src_addr":     func(e bpf.Event, _ time.Time) string { return e.SrcAddr.String() },
+	"dst_addr":     func(e bpf.Event, _ time.Time) string { return e.DstAddr.String() },
+	"src_port":     func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.SrcPort)) },
+	"dst_port":     func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.DstPort)) },
+	"proto":        func(e bpf.Event, _ time.Time) string { return bpf.ProtoName(e.Proto) },
+	"tcp_state":    func(e bpf.Event, _ time.Time) string { return bpf.TCPStateName(e.TCPState) },
+	"icmp_id":      func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.ICMPID)) },
+	"icmp_type":    func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.ICMPType)) },
+	"icmp_code":    func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.ICMPCode)) },
+	"family":       func(e bpf.Event, _ time.Time) string { return bpf.FamilyName(e.Family) },
+	"netns":        func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.NetNS)) },
+	"ifindex":      func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.Ifindex)) },
+	"zone":         func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.Zone)) },
+	"sample_rate":  func(e bpf.Event, _ time.Time) string { return uintStr(uint64(e.SampleRate)) },
+	"packets_orig": func(e bpf.Event, _ time.Time) string { return uintStr(e.PacketsOrig) },
+	"bytes_orig":   func(e bpf.Event, _ time.Time) string { return uintStr(e.BytesOrig) },
+	"packets_ret":  func(e bpf.Event, _ time.Time) string { return uintStr(e.PacketsRet) },
+	"bytes_ret":    func(e bpf.Event, _ time.Time) string { return uintStr(e.BytesRet) },
+
+	// Throughput since the flow's previous event, zero if not computed.
+	"packets_orig_rate": func(e bpf.Event, _ time.Time) string { return floatStr(e.Rate.PacketsOrig) },
+	"bytes_orig_rate":   func(e bpf.Event, _ time.Time) string { return floatStr(e.Rate.BytesOrig) },
+	"packets_ret_rate":  func(e bpf.Event, _ time.Time) string { return floatStr(e.Rate.PacketsRet) },
+	"bytes_ret_rate":    func(e bpf.Event, _ time.Time) string { return floatStr(e.Rate.BytesRet) },
+}
+
+// defaultColumns is the list of columns written when none are configured.
+var defaultColumns = []string{
+	"timestamp", "event_type", "conn_id", "connmark",
+	"src_addr", "dst_addr", "dst_port", "proto", "netns",
+	"packets_orig", "bytes_orig", "packets_ret", "bytes_ret",
+}
+
+func uintStr(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func floatStr(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
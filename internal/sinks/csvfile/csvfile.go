@@ -0,0 +1,279 @@
+package csvfile
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Maximum time events are buffered before being written to the file.
+const defaultFlushInterval = time.Second
+
+// CSVSink is an accounting sink writing events to a CSV file, one row per
+// event with a header naming the selected columns. The file is rotated after
+// a configured interval or size, rotated files are optionally compressed
+// with gzip.
+type CSVSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Selected columns.
+	columns []string
+	values  []column
+
+	// Active file and its writers. Set closed when the sink is closed,
+	// events pushed afterwards are dropped.
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+	closed bool
+
+	// Time the active file was opened, the amount of bytes written to it,
+	// the amount of rows not yet written and the time it's due for rotation.
+	opened  time.Time
+	size    int64
+	pending int
+	rotate  time.Time
+
+	// Row being encoded, reused between events.
+	row []string
+
+	// Closed by Close to stop the tick worker.
+	done chan struct{}
+
+	// Tracks compression of rotated files, waited on by Close.
+	wg sync.WaitGroup
+
+	// Sink stats.
+	stats types.SinkStats
+}
+
+// New returns a new CSV sink.
+func New() CSVSink {
+	return CSVSink{}
+}
+
+// Init initializes the CSV sink. A non-empty file left at the sink's path,
+// eg. by a previous run, is rotated first.
+func (s *CSVSink) Init(sc types.SinkConfig) error {
+
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Path == "" {
+		return errEmptySinkPath
+	}
+	if sc.Type != types.CSV {
+		return errInvalidSinkType
+	}
+
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+
+	cols := sc.Columns
+	if len(cols) == 0 {
+		cols = defaultColumns
+	}
+	for _, c := range cols {
+		v, ok := columns[c]
+		if !ok {
+			return fmt.Errorf(errFmtUnknownColumn, c)
+		}
+		s.values = append(s.values, v)
+	}
+
+	s.config = sc
+	s.columns = cols
+	s.row = make([]string, len(cols))
+
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
+
+	if fi, err := os.Stat(sc.Path); err == nil && fi.Size() > 0 {
+		p, err := s.moveAside(fi.ModTime())
+		if err != nil {
+			return errors.Wrap(err, "rotating existing file")
+		}
+		s.compress(p)
+	}
+
+	if err := s.open(time.Now()); err != nil {
+		return err
+	}
+
+	s.done = make(chan struct{})
+	go s.tickWorker()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push writes an accounting event to the CSV file's buffer.
+func (s *CSVSink) Push(e bpf.Event) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
+	// Rotate by size once the written rows filled up the file.
+	if s.config.RotateSize != 0 && uint64(s.size) >= s.config.RotateSize {
+		if err := s.rotateFile(time.Now()); err != nil {
+			s.stats.IncrEventErrors()
+			return err
+		}
+	}
+
+	boot := s.clock.Boot()
+	for i, v := range s.values {
+		s.row[i] = v(e, boot)
+	}
+
+	if err := s.writer.Write(s.row); err != nil {
+		s.stats.IncrEventErrors()
+		return errors.Wrap(err, "writing event")
+	}
+	s.pending++
+
+	s.stats.IncrEventsPushed()
+
+	return nil
+}
+
+// PushBatch writes a batch of accounting events to the CSV file's buffer.
+func (s *CSVSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// Flush writes the buffered events to the CSV file.
+func (s *CSVSink) Flush(ctx context.Context) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errSinkClosed
+	}
+
+	return s.flush()
+}
+
+// flush writes the buffered events to the CSV file and records
+// the result in the sink's statistics. mu must be held.
+func (s *CSVSink) flush() error {
+
+	if s.pending == 0 {
+		return nil
+	}
+	n := s.pending
+	s.pending = 0
+
+	start := time.Now()
+	s.writer.Flush()
+	err := s.writer.Error()
+	s.stats.ObserveFlush(time.Since(start), n, err)
+	if err != nil {
+		s.stats.IncrBatchDropped()
+		return errors.Wrap(err, "writing CSV file")
+	}
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// Close writes the buffered events and closes the CSV file. Waits for
+// rotated files to be compressed. Must only be called once.
+func (s *CSVSink) Close() error {
+
+	close(s.done)
+
+	s.mu.Lock()
+	s.closed = true
+	err := s.flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+// Name gets the name of the CSV sink.
+func (s *CSVSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the CSV sink was successfully initialized.
+func (s *CSVSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *CSVSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, CSV receives destroy events. (flow totals)
+func (s *CSVSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the CSV sink's statistics structure.
+func (s *CSVSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// tickWorker periodically writes the buffered events to the CSV file and
+// rotates it when it's due. Exits when the sink is closed.
+func (s *CSVSink) tickWorker() {
+
+	t := time.NewTicker(s.config.FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				return
+			}
+			if err := s.flush(); err != nil {
+				log.Errorf("CSV sink '%s': %s", s.config.Name, err)
+			}
+			if !s.rotate.IsZero() && !now.Before(s.rotate) {
+				if err := s.rotateFile(now); err != nil {
+					log.Errorf("CSV sink '%s': rotating file: %s", s.config.Name, err)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package csvfile
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestCSVSink(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flows.csv")
+
+	// Left by a previous run.
+	require.NoError(t, ioutil.WriteFile(path, []byte("old\n"), 0600))
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:    "csv",
+		Type:    types.CSV,
+		Path:    path,
+		Columns: []string{"event_type", "src_addr", "dst_port", "proto", "bytes_orig"},
+		Gzip:    true,
+	}))
+
+	e := bpf.Event{SrcAddr: net.IPv4(10, 0, 0, 1), DstPort: 443, Proto: bpf.ProtoTCP, BytesOrig: 100}
+	require.NoError(t, s.Push(e))
+
+	s.mu.Lock()
+	require.NoError(t, s.rotateFile(time.Now()))
+	s.mu.Unlock()
+
+	e.Destroy, e.BytesOrig = true, 200
+	require.NoError(t, s.Push(e))
+	require.NoError(t, s.Close())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "event_type,src_addr,dst_port,proto,bytes_orig\ndestroy,10.0.0.1,443,tcp,200\n", string(b))
+
+	// The old file and the first rotated file are compressed.
+	gzs, err := filepath.Glob(filepath.Join(dir, "flows-*.csv.gz"))
+	require.NoError(t, err)
+	require.Len(t, gzs, 2)
+
+	var rows []string
+	for _, p := range gzs {
+		f, err := os.Open(p)
+		require.NoError(t, err)
+		r, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		f.Close()
+		rows = append(rows, string(b))
+	}
+	assert.ElementsMatch(t, []string{"old\n",
+		"event_type,src_addr,dst_port,proto,bytes_orig\nupdate,10.0.0.1,443,tcp,100\n"}, rows)
+
+	left, err := filepath.Glob(filepath.Join(dir, "flows-*.csv"))
+	require.NoError(t, err)
+	assert.Empty(t, left, "uncompressed rotated files")
+
+	s2 := New()
+	assert.EqualError(t, s2.Init(types.SinkConfig{Name: "csv", Type: types.CSV, Path: path, Columns: []string{"nope"}}),
+		"unknown column 'nope'")
+}
+
+func TestRotatedPath(t *testing.T) {
+
+	dir := t.TempDir()
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	p := rotatedPath(filepath.Join(dir, "flows.csv"), ts)
+	assert.Equal(t, filepath.Join(dir, "flows-20200102T030405Z.csv"), p)
+
+	require.NoError(t, ioutil.WriteFile(p+".gz", nil, 0600))
+	assert.Equal(t, filepath.Join(dir, "flows-20200102T030405Z-1.csv"), rotatedPath(filepath.Join(dir, "flows.csv"), ts))
+}
@@ -0,0 +1,14 @@
+package csvfile
+
+import "errors"
+
+const (
+	errFmtUnknownColumn = "unknown column '%s'"
+)
+
+var (
+	errEmptySinkName   = errors.New("empty sink name")
+	errEmptySinkPath   = errors.New("empty sink path")
+	errInvalidSinkType = errors.New("invalid sink type")
+	errSinkClosed      = errors.New("sink is closed")
+)
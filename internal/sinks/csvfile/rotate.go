@@ -0,0 +1,168 @@
+package csvfile
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Format of the time stamp in the names of rotated files.
+const rotateTimeFormat = "20060102T150405Z"
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	*c.n += int64(n)
+	return n, err
+}
+
+// open creates the active file at the sink's path and writes the header.
+// mu must be held.
+func (s *CSVSink) open(now time.Time) error {
+
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "opening CSV file")
+	}
+
+	s.file = f
+	s.size = 0
+	s.writer = csv.NewWriter(countWriter{w: f, n: &s.size})
+	s.opened = now
+
+	// Rotate on multiples of the interval, eg. at midnight UTC for 24h.
+	s.rotate = time.Time{}
+	if i := s.config.RotateInterval; i != 0 {
+		s.rotate = now.Truncate(i).Add(i)
+	}
+
+	if err := s.writer.Write(s.columns); err != nil {
+		return errors.Wrap(err, "writing CSV header")
+	}
+	s.pending++
+
+	return nil
+}
+
+// rotateFile moves the active file aside and opens a new one. If the file
+// can't be moved, writing to it continues. mu must be held.
+func (s *CSVSink) rotateFile(now time.Time) error {
+
+	p, err := s.moveAside(s.opened)
+	if err != nil {
+		return err
+	}
+
+	// The open file handle keeps writing to the moved file.
+	if err := s.flush(); err != nil {
+		log.Errorf("CSV sink '%s': %s", s.config.Name, err)
+	}
+	if err := s.file.Close(); err != nil {
+		log.Errorf("CSV sink '%s': closing rotated file: %s", s.config.Name, err)
+	}
+
+	s.compress(p)
+
+	return s.open(now)
+}
+
+// moveAside renames the file at the sink's path to its rotated name,
+// named after the time it was opened. Returns the new path.
+func (s *CSVSink) moveAside(opened time.Time) (string, error) {
+
+	p := rotatedPath(s.config.Path, opened)
+	if err := os.Rename(s.config.Path, p); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// compress compresses the rotated file at path in the background,
+// if enabled.
+func (s *CSVSink) compress(path string) {
+
+	if !s.config.Gzip {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := gzipFile(path); err != nil {
+			log.Errorf("CSV sink '%s': compressing '%s': %s", s.config.Name, path, err)
+		}
+	}()
+}
+
+// rotatedPath returns the path of a file rotated from path, which was
+// opened at the given time, eg. flows-20200102T030405Z.csv for flows.csv.
+// A counter is appended if a file rotated at the same time exists.
+func rotatedPath(path string, opened time.Time) string {
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + opened.UTC().Format(rotateTimeFormat)
+
+	p := base + ext
+	for i := 1; exists(p) || exists(p+".gz"); i++ {
+		p = base + "-" + strconv.Itoa(i) + ext
+	}
+
+	return p
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzipFile writes the gzip-compressed contents of the file at path to
+// path.gz and removes the original.
+func gzipFile(path string) error {
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Only expose the compressed file once it's complete.
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
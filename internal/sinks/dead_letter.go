@@ -0,0 +1,115 @@
+package sinks
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// deadLetterSource is implemented by sink drivers that can hand over
+// the events they failed to deliver.
+type deadLetterSource interface {
+	SetDeadLetter(types.DeadLetterFunc)
+}
+
+// deadLetterTarget is implemented by sink drivers that can receive
+// events other sinks failed to deliver. A sink receiving dead-lettered
+// events doesn't receive events from the pipeline.
+type deadLetterTarget interface {
+	PushDeadLetter(sink string, events []bpf.Event) error
+	SetDeadLetterOnly(bool)
+}
+
+// ConnectDeadLetters connects the given sinks to the dead-letter sinks named
+// in their configurations, and disconnects the sinks that don't name one.
+// Dead-letter sinks only receive dead-lettered events. Returns the sinks
+// ordered so dead-letter sinks come after the sinks feeding them, so closing
+// the sinks in order doesn't lose the events of final batches.
+func ConnectDeadLetters(ss []Sink, cfgs []types.SinkConfig) ([]Sink, error) {
+
+	byName := make(map[string]Sink, len(ss))
+	for _, s := range ss {
+		byName[s.Name()] = s
+	}
+
+	targets := make(map[string]bool)
+
+	for _, sc := range cfgs {
+		s, ok := byName[sc.Name]
+		if !ok {
+			continue
+		}
+
+		src, ok := driver(s).(deadLetterSource)
+		if sc.DeadLetter == "" {
+			if ok {
+				// Spilling sinks write undelivered events back into their spool.
+				var f types.DeadLetterFunc
+				if q := queue(s); q != nil && q.spool != nil {
+					f = q.respool
+				}
+				src.SetDeadLetter(f)
+			}
+			continue
+		}
+		if !ok {
+			return nil, errors.Wrapf(errNoDeadLetterSource, "sink '%s'", sc.Name)
+		}
+
+		t, ok := byName[sc.DeadLetter]
+		if !ok {
+			return nil, errors.Errorf("sink '%s': unknown dead-letter sink '%s'", sc.Name, sc.DeadLetter)
+		}
+		dst, ok := driver(t).(deadLetterTarget)
+		if !ok {
+			return nil, errors.Wrapf(errNoDeadLetterTarget, "sink '%s': dead-letter sink '%s'", sc.Name, sc.DeadLetter)
+		}
+
+		name := sc.DeadLetter
+		src.SetDeadLetter(func(sink string, events []bpf.Event) {
+			if err := dst.PushDeadLetter(sink, events); err != nil {
+				log.Errorf("Sink '%s': Error writing %d events to dead-letter sink '%s': %s", sink, len(events), name, err)
+			}
+		})
+
+		targets[name] = true
+	}
+
+	out := make([]Sink, 0, len(ss))
+	for _, s := range ss {
+		if dst, ok := driver(s).(deadLetterTarget); ok {
+			dst.SetDeadLetterOnly(targets[s.Name()])
+		}
+		if !targets[s.Name()] {
+			out = append(out, s)
+		}
+	}
+	for _, s := range ss {
+		if targets[s.Name()] {
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
+
+// driver returns the sink driver wrapped by the given sink's queue, filter
+// and anonymization.
+func driver(s Sink) Sink {
+	for {
+		switch w := s.(type) {
+		case *filteredSink:
+			s = w.Sink
+		case *anonymizedSink:
+			s = w.Sink
+		case *transformedSink:
+			s = w.Sink
+		case *queuedSink:
+			s = w.Sink
+		default:
+			return s
+		}
+	}
+}
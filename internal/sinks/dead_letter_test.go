@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/stdout"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// deadLetterSink is a testSink handing over events to a dead-letter sink.
+type deadLetterSink struct {
+	testSink
+	dl types.DeadLetter
+}
+
+func (s *deadLetterSink) SetDeadLetter(f types.DeadLetterFunc) { s.dl.Set(f) }
+
+func TestConnectDeadLetters(t *testing.T) {
+
+	src := &deadLetterSink{}
+
+	std := stdout.New()
+	require.NoError(t, std.Init(types.SinkConfig{Name: "dl", Type: types.StdErr}))
+	dst := newQueuedSink(&std, 0, QueueBlock, nil)
+	defer dst.Close()
+
+	cfgs := []types.SinkConfig{
+		{Name: "dl", Type: types.StdErr},
+		{Name: "test", DeadLetter: "dl"},
+	}
+
+	ss, err := ConnectDeadLetters([]Sink{dst, src}, cfgs)
+	require.NoError(t, err)
+
+	// The dead-letter sink is closed last and receives no pipeline events.
+	assert.Equal(t, []Sink{src, dst}, ss)
+	assert.False(t, dst.WantUpdate())
+	assert.False(t, dst.WantDestroy())
+
+	assert.True(t, src.dl.Enabled())
+	src.dl.Send("test", []bpf.Event{{ConnectionID: 1}})
+	assert.EqualValues(t, 1, dst.Stats().EventsPushed)
+
+	// Disconnecting the sinks turns the dead-letter sink back into a regular sink.
+	cfgs[1].DeadLetter = ""
+	_, err = ConnectDeadLetters([]Sink{dst, src}, cfgs)
+	require.NoError(t, err)
+	assert.False(t, src.dl.Enabled())
+	assert.True(t, dst.WantUpdate())
+
+	cfgs[1].DeadLetter = "missing"
+	_, err = ConnectDeadLetters([]Sink{dst, src}, cfgs)
+	assert.Error(t, err)
+
+	// Sinks without a dead-letter function can't name a dead-letter sink.
+	cfgs[0].DeadLetter = "test"
+	cfgs[1].DeadLetter = ""
+	_, err = ConnectDeadLetters([]Sink{dst, src}, cfgs)
+	assert.Error(t, err)
+}
@@ -0,0 +1,19 @@
+package sinks
+
+import "errors"
+
+var (
+	errQueueClosed = errors.New("sink queue is closed")
+	errQueueFull   = errors.New("sink queue is full, event dropped")
+
+	errEmptySpoolDir = errors.New("queue policy spill requires a spool directory")
+
+	errNoDeadLetterSource = errors.New("sink type does not support dead-lettering")
+	errNoDeadLetterTarget = errors.New("sink type cannot receive dead-lettered events")
+
+	errNoTLS = errors.New("sink type does not support tls")
+
+	errNoWorkers = errors.New("sink type does not support multiple workers")
+
+	errNoFailover = errors.New("sink cannot be part of a failover group")
+)
@@ -0,0 +1,246 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	defaultHealthInterval  = 10 * time.Second
+	defaultHealthThreshold = 3
+)
+
+// healthChecker is implemented by sink drivers that can check whether
+// their backing storage is reachable.
+type healthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// failoverGroup is a primary sink and its standbys, of which only the
+// active one receives events. The group's health checks activate the first
+// healthy sink in order of preference, so the primary takes over again
+// once it recovers. If none of the sinks are healthy, the active one is kept.
+type failoverGroup struct {
+	// Name of the primary sink.
+	name string
+
+	// Primary sink followed by its standbys. Only accessed by the monitor.
+	members []*groupMember
+	active  int
+
+	interval  time.Duration
+	threshold int
+
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// groupMember is the health check state of a sink in a failoverGroup.
+type groupMember struct {
+	queue *queuedSink
+
+	healthy bool
+
+	// Amount of consecutive health checks disagreeing with healthy.
+	streak int
+
+	// Statistics of the sink at the previous health check.
+	last types.SinkStatsData
+}
+
+// ConnectFailover groups the given sinks with the standby sinks named in
+// their configurations, and ungroups the sinks that don't name any. Groups
+// start out with their primary sink active, standby sinks only receive
+// events while the sinks preferred over them fail their health checks.
+func ConnectFailover(ss []Sink, cfgs []types.SinkConfig) error {
+
+	byName := make(map[string]Sink, len(ss))
+	for _, s := range ss {
+		byName[s.Name()] = s
+	}
+
+	// Validate all groups first, so running groups are left alone
+	// when the configuration is invalid.
+	groupOf := make(map[string]string)
+	var groups []types.SinkConfig
+
+	for _, sc := range cfgs {
+		if _, ok := byName[sc.Name]; !ok || len(sc.Standby) == 0 {
+			continue
+		}
+
+		for _, name := range append([]string{sc.Name}, sc.Standby...) {
+			s, ok := byName[name]
+			if !ok {
+				return errors.Errorf("sink '%s': unknown standby sink '%s'", sc.Name, name)
+			}
+			if queue(s) == nil {
+				return errors.Wrapf(errNoFailover, "sink '%s'", name)
+			}
+			if p, ok := groupOf[name]; ok {
+				return errors.Errorf("sink '%s': sink '%s' is already in the failover group of sink '%s'", sc.Name, name, p)
+			}
+			groupOf[name] = sc.Name
+		}
+
+		groups = append(groups, sc)
+	}
+
+	// Stop the health checks of the sinks' previous groups, including
+	// groups of sinks about to be removed.
+	for _, s := range ss {
+		if q := queue(s); q != nil {
+			q.setGroup(nil)
+			_, member := groupOf[s.Name()]
+			q.setStandby(member && groupOf[s.Name()] != s.Name())
+		}
+	}
+
+	for _, sc := range groups {
+		g := &failoverGroup{
+			name:      sc.Name,
+			interval:  sc.HealthInterval,
+			threshold: int(sc.HealthThreshold),
+		}
+		if g.interval == 0 {
+			g.interval = defaultHealthInterval
+		}
+		if g.threshold == 0 {
+			g.threshold = defaultHealthThreshold
+		}
+
+		for _, name := range append([]string{sc.Name}, sc.Standby...) {
+			q := queue(byName[name])
+			q.setGroup(g)
+			g.members = append(g.members, &groupMember{queue: q, healthy: true, last: q.Stats()})
+		}
+
+		g.start()
+	}
+
+	return nil
+}
+
+// start starts the group's health checks.
+func (g *failoverGroup) start() {
+	g.done = make(chan struct{})
+	g.wg.Add(1)
+	go g.monitor()
+}
+
+// stop stops the group's health checks and waits for them to return.
+// Safe to call multiple times.
+func (g *failoverGroup) stop() {
+	g.stopOnce.Do(func() { close(g.done) })
+	g.wg.Wait()
+}
+
+// monitor runs the group's health checks every interval until the group
+// is stopped.
+func (g *failoverGroup) monitor() {
+
+	defer g.wg.Done()
+
+	t := time.NewTicker(g.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			g.check()
+		case <-g.done:
+			return
+		}
+	}
+}
+
+// check runs the health checks of all members of the group and activates
+// the first healthy one.
+func (g *failoverGroup) check() {
+
+	for _, m := range g.members {
+		err := g.checkMember(m)
+		if (err == nil) == m.healthy {
+			m.streak = 0
+			continue
+		}
+
+		m.streak++
+		if m.streak < g.threshold {
+			continue
+		}
+		m.healthy, m.streak = err == nil, 0
+
+		if err != nil {
+			log.Warnf("Failover group '%s': Sink '%s' is down: %s", g.name, m.queue.Name(), err)
+		} else {
+			log.Infof("Failover group '%s': Sink '%s' is up", g.name, m.queue.Name())
+		}
+	}
+
+	next := g.active
+	for i, m := range g.members {
+		if m.healthy {
+			next = i
+			break
+		}
+	}
+	if next == g.active {
+		return
+	}
+
+	log.Warnf("Failover group '%s': Switching from sink '%s' to sink '%s'",
+		g.name, g.members[g.active].queue.Name(), g.members[next].queue.Name())
+
+	// Activate the next sink first, so no events are missed in between.
+	g.members[next].queue.setStandby(false)
+	g.members[g.active].queue.setStandby(true)
+	g.active = next
+}
+
+// checkMember returns an error if the sink failed all of its writes since
+// the previous check, or if its driver can't reach its backing storage.
+func (g *failoverGroup) checkMember(m *groupMember) error {
+
+	s := m.queue.Stats()
+	last := m.last
+	m.last = s
+
+	if s.SendErrors > last.SendErrors && s.BatchesSent == last.BatchesSent {
+		return fmt.Errorf("%d failed writes since the previous check", s.SendErrors-last.SendErrors)
+	}
+
+	if hc, ok := driver(m.queue).(healthChecker); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), g.interval)
+		defer cancel()
+		return hc.CheckHealth(ctx)
+	}
+
+	return nil
+}
+
+// queue returns the queue wrapping the given sink's driver, or nil.
+func queue(s Sink) *queuedSink {
+	for {
+		switch w := s.(type) {
+		case *filteredSink:
+			s = w.Sink
+		case *anonymizedSink:
+			s = w.Sink
+		case *transformedSink:
+			s = w.Sink
+		case *queuedSink:
+			return w
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+// healthSink is a testSink with a name and a settable health check result.
+type healthSink struct {
+	testSink
+
+	name string
+
+	hmu sync.Mutex
+	err error
+}
+
+func (s *healthSink) Name() string { return s.name }
+
+func (s *healthSink) CheckHealth(context.Context) error {
+	s.hmu.Lock()
+	defer s.hmu.Unlock()
+	return s.err
+}
+
+func (s *healthSink) setHealth(err error) {
+	s.hmu.Lock()
+	s.err = err
+	s.hmu.Unlock()
+}
+
+func TestConnectFailover(t *testing.T) {
+
+	hp, hs := &healthSink{name: "primary"}, &healthSink{name: "standby"}
+	p := newQueuedSink(hp, 16, QueueBlock, nil)
+	s := newQueuedSink(hs, 16, QueueBlock, nil)
+	defer p.Close()
+	defer s.Close()
+
+	ss := []Sink{p, s}
+	cfgs := []types.SinkConfig{
+		{Name: "primary", Standby: []string{"standby"}, HealthThreshold: 2},
+		{Name: "standby"},
+	}
+
+	require.NoError(t, ConnectFailover(ss, cfgs))
+	assert.True(t, p.WantUpdate())
+	assert.False(t, s.WantUpdate())
+	assert.True(t, s.Stats().Standby)
+
+	// Run the group's health checks by hand.
+	g := p.group
+	require.NotNil(t, g)
+	g.stop()
+
+	// Fail over after two failed checks.
+	hp.setHealth(errors.New("connection refused"))
+	g.check()
+	assert.True(t, p.WantUpdate())
+	g.check()
+	assert.False(t, p.WantDestroy())
+	assert.True(t, s.WantDestroy())
+
+	// Fail back once the primary recovers.
+	hp.setHealth(nil)
+	g.check()
+	g.check()
+	assert.True(t, p.WantUpdate())
+	assert.False(t, s.WantUpdate())
+
+	// Keep the active sink when no sink is healthy.
+	hp.setHealth(errors.New("connection refused"))
+	hs.setHealth(errors.New("connection refused"))
+	g.check()
+	g.check()
+	assert.True(t, p.WantUpdate())
+
+	// Ungrouped sinks all receive events.
+	require.NoError(t, ConnectFailover(ss, []types.SinkConfig{{Name: "primary"}, {Name: "standby"}}))
+	assert.True(t, p.WantUpdate())
+	assert.True(t, s.WantUpdate())
+	assert.Nil(t, p.group)
+
+	// Invalid groups are rejected.
+	assert.Error(t, ConnectFailover(ss, []types.SinkConfig{
+		{Name: "primary", Standby: []string{"unknown"}},
+	}))
+	assert.Error(t, ConnectFailover(ss, []types.SinkConfig{
+		{Name: "primary", Standby: []string{"standby"}},
+		{Name: "standby", Standby: []string{"primary"}},
+	}))
+}
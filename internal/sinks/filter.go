@@ -0,0 +1,61 @@
+package sinks
+
+import (
+	"fmt"
+
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// filteredSink wraps a Sink, only pushing events that match the sink's
+// filter expression and are of the kinds of events selected for the sink.
+type filteredSink struct {
+	Sink
+
+	// Nil if the sink has no filter expression.
+	filter *filter.Filter
+
+	update, destroy bool
+}
+
+// parseEvents parses a sink's selection of event types, either all (or
+// empty), update or destroy.
+func parseEvents(s string) (update, destroy bool, err error) {
+	switch s {
+	case "", "all":
+		return true, true, nil
+	case "update":
+		return true, false, nil
+	case "destroy":
+		return false, true, nil
+	default:
+		return false, false, fmt.Errorf("unknown event selection '%s', expected all, update or destroy", s)
+	}
+}
+
+// Push an accounting event into the underlying sink if it matches the filter.
+func (s *filteredSink) Push(e bpf.Event) error {
+	if s.filter == nil || s.filter.Match(e) {
+		return s.Sink.Push(e)
+	}
+	return nil
+}
+
+// PushBatch pushes the events of a batch matching the filter into the
+// underlying sink.
+func (s *filteredSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// WantUpdate returns true if update events are selected for the sink
+// and the underlying sink wants them.
+func (s *filteredSink) WantUpdate() bool {
+	return s.update && s.Sink.WantUpdate()
+}
+
+// WantDestroy returns true if destroy events are selected for the sink
+// and the underlying sink wants them.
+func (s *filteredSink) WantDestroy() bool {
+	return s.destroy && s.Sink.WantDestroy()
+}
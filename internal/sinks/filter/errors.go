@@ -0,0 +1,5 @@
+package filter
+
+const (
+	errFmtTransformResult = "transform expression returned %T, expected a map, bool or nil"
+)
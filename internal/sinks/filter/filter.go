@@ -0,0 +1,137 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/internal/enrich/scope"
+	"github.com/ti-mo/conntracct/internal/sinks/helpers"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Filter is a compiled filter expression that can be evaluated against
+// accounting events. Match is safe for concurrent use.
+type Filter struct {
+	prog *vm.Program
+
+	nets netCache
+}
+
+// netCache caches the networks parsed by the cidr() expression function.
+type netCache struct {
+	m sync.Map
+}
+
+// env holds the variables an expression can refer to.
+type env struct {
+	ConnectionID uint32 `expr:"conn_id"`
+	Connmark     uint32 `expr:"connmark"`
+	SrcAddr      string `expr:"src_addr"`
+	DstAddr      string `expr:"dst_addr"`
+	SrcPort      uint16 `expr:"src_port"`
+	DstPort      uint16 `expr:"dst_port"`
+	Proto        uint8  `expr:"proto"`
+	ProtoName    string `expr:"proto_name"`
+	Family       string `expr:"family"`
+	TCPState     string `expr:"tcp_state"`
+	NetNS        uint32 `expr:"netns"`
+	Zone         uint16 `expr:"zone"`
+	Ifindex      uint32 `expr:"ifindex"`
+	PacketsOrig  uint64 `expr:"packets_orig"`
+	BytesOrig    uint64 `expr:"bytes_orig"`
+	PacketsRet   uint64 `expr:"packets_ret"`
+	BytesRet     uint64 `expr:"bytes_ret"`
+
+	// Traffic that doesn't leave the host, see scope.HostInternal.
+	HostInternal bool `expr:"host_internal"`
+
+	Tags map[string]string `expr:"tags"`
+}
+
+// New compiles a filter expression like `proto == 6 && dst_port == 443`,
+// `cidr(src_addr, "10.0.0.0/8")` or `scope(dst_addr) == "public"`, with the
+// scopes of package scope. The expression must evaluate to a boolean.
+func New(s string) (*Filter, error) {
+
+	f := &Filter{}
+
+	prog, err := expr.Compile(s,
+		expr.Env(env{}),
+		expr.AsBool(),
+		expr.Function("cidr", f.nets.cidr, new(func(string, string) bool)),
+		expr.Function("scope", addrScope, new(func(string) string)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "compiling filter expression")
+	}
+
+	f.prog = prog
+
+	return f, nil
+}
+
+// Match returns true if the Event matches the filter. Events that fail to
+// evaluate do not match.
+func (f *Filter) Match(e bpf.Event) bool {
+
+	out, err := expr.Run(f.prog, newEnv(e))
+	if err != nil {
+		return false
+	}
+
+	return out.(bool)
+}
+
+// newEnv returns the expression variables of the Event.
+func newEnv(e bpf.Event) env {
+	return env{
+		ConnectionID: e.ConnectionID,
+		Connmark:     e.Connmark,
+		SrcAddr:      e.SrcAddr.String(),
+		DstAddr:      e.DstAddr.String(),
+		SrcPort:      e.SrcPort,
+		DstPort:      e.DstPort,
+		Proto:        e.Proto,
+		ProtoName:    helpers.ProtoIntStr(e.Proto),
+		Family:       helpers.FamilyStr(e.Family),
+		TCPState:     helpers.TCPStateStr(e.TCPState),
+		NetNS:        e.NetNS,
+		Zone:         e.Zone,
+		Ifindex:      e.Ifindex,
+		PacketsOrig:  e.PacketsOrig,
+		BytesOrig:    e.BytesOrig,
+		PacketsRet:   e.PacketsRet,
+		BytesRet:     e.BytesRet,
+		HostInternal: scope.HostInternal(e.SrcAddr, e.DstAddr),
+		Tags:         e.Tags,
+	}
+}
+
+// cidr implements the cidr(addr, network) expression function,
+// reporting whether addr is contained in network.
+func (c *netCache) cidr(params ...interface{}) (interface{}, error) {
+
+	addr, prefix := params[0].(string), params[1].(string)
+
+	n, ok := c.m.Load(prefix)
+	if !ok {
+		_, ipn, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s': %s", prefix, err)
+		}
+		n, _ = c.m.LoadOrStore(prefix, ipn)
+	}
+
+	return n.(*net.IPNet).Contains(net.ParseIP(addr)), nil
+}
+
+// addrScope implements the scope(addr) expression function,
+// returning the scope of addr.
+func addrScope(params ...interface{}) (interface{}, error) {
+	return scope.Of(net.ParseIP(params[0].(string))), nil
+}
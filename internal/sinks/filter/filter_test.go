@@ -0,0 +1,96 @@
+package filter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestFilterMatch(t *testing.T) {
+
+	e := bpf.Event{
+		SrcAddr: net.IPv4(10, 1, 2, 3),
+		DstAddr: net.ParseIP("2001:db8::1"),
+		DstPort: 443,
+		Proto:   6,
+	}
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`proto == 6 && dst_port == 443`, true},
+		{`proto_name == "udp"`, false},
+		{`cidr(src_addr, "10.0.0.0/8")`, true},
+		{`cidr(dst_addr, "2001:db8::/32") && !cidr(src_addr, "192.168.0.0/16")`, true},
+		{`src_addr == "10.1.2.3" && bytes_orig > 0`, false},
+		{`scope(src_addr) == "private" && scope(dst_addr) == "public"`, true},
+		{`host_internal`, false},
+
+		// Invalid networks fail evaluation and don't match.
+		{`cidr(src_addr, "10.0.0.0")`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := New(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.match, f.Match(e))
+		})
+	}
+}
+
+func TestFilterCompile(t *testing.T) {
+
+	_, err := New(`dst_port`)
+	assert.Error(t, err, "non-boolean expression")
+
+	_, err = New(`unknown == 1`)
+	assert.Error(t, err, "unknown variable")
+}
+
+func TestTransform(t *testing.T) {
+
+	e := bpf.Event{
+		SrcAddr: net.IPv4(10, 1, 2, 3),
+		DstPort: 443,
+		Tags:    map[string]string{"interface": "eth0", "pod": "web"},
+	}
+
+	tr, err := NewTransform(`{"site": cidr(src_addr, "10.1.0.0/16") ? "ams" : "fra", "port": dst_port, "pod": nil}`)
+	require.NoError(t, err)
+
+	out, ok, err := tr.Apply(e)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"interface": "eth0", "site": "ams", "port": "443"}, out.Tags)
+
+	// The original tag map is left alone.
+	assert.Equal(t, map[string]string{"interface": "eth0", "pod": "web"}, e.Tags)
+
+	tr, err = NewTransform(`{"src_scope": scope(src_addr)}`)
+	require.NoError(t, err)
+	out, _, err = tr.Apply(e)
+	require.NoError(t, err)
+	assert.Equal(t, "private", out.Tags["src_scope"])
+
+	tr, err = NewTransform(`dst_port == 443 ? false : nil`)
+	require.NoError(t, err)
+	_, ok, err = tr.Apply(e)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	tr, err = NewTransform(`dst_port`)
+	require.NoError(t, err)
+	out, ok, err = tr.Apply(e)
+	assert.Error(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, e, out)
+
+	_, err = NewTransform(`{"a": unknown}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Transform is a compiled transform expression computing tags of accounting
+// events or dropping them. It can refer to the same variables and functions
+// as a Filter. Apply is safe for concurrent use.
+type Transform struct {
+	prog *vm.Program
+
+	nets netCache
+}
+
+// NewTransform compiles a transform expression. The expression evaluates to
+// a map of tags to set on the event, eg. `{"site": cidr(src_addr,
+// "10.1.0.0/16") ? "ams" : "fra"}`, where nil values remove a tag, to nil
+// to leave the event unchanged, or to a boolean, false dropping the event.
+func NewTransform(s string) (*Transform, error) {
+
+	t := &Transform{}
+
+	prog, err := expr.Compile(s,
+		expr.Env(env{}),
+		expr.Function("cidr", t.nets.cidr, new(func(string, string) bool)),
+		expr.Function("scope", addrScope, new(func(string) string)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "compiling transform expression")
+	}
+
+	t.prog = prog
+
+	return t, nil
+}
+
+// Apply evaluates the transform against the Event, returning the transformed
+// Event, or false if the Event is dropped. The Event's tag map is copied
+// before it is modified. On error, the Event is returned unchanged.
+func (t *Transform) Apply(e bpf.Event) (bpf.Event, bool, error) {
+
+	out, err := expr.Run(t.prog, newEnv(e))
+	if err != nil {
+		return e, true, err
+	}
+
+	switch o := out.(type) {
+	case nil:
+		return e, true, nil
+	case bool:
+		return e, o, nil
+	case map[string]interface{}:
+		tags := make(map[string]string, len(e.Tags)+len(o))
+		for k, v := range e.Tags {
+			tags[k] = v
+		}
+		for k, v := range o {
+			if v == nil {
+				delete(tags, k)
+				continue
+			}
+			tags[k] = fmt.Sprint(v)
+		}
+		e.Tags = tags
+		return e, true, nil
+	default:
+		return e, true, fmt.Errorf(errFmtTransformResult, out)
+	}
+}
@@ -0,0 +1,23 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+func TestSinkEvents(t *testing.T) {
+
+	s, err := New(types.SinkConfig{Name: "out", Type: types.StdErr, Events: "destroy"})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.False(t, s.WantUpdate())
+	assert.True(t, s.WantDestroy())
+
+	_, err = New(types.SinkConfig{Name: "out", Type: types.StdErr, Events: "final"})
+	assert.Error(t, err)
+}
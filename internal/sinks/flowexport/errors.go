@@ -0,0 +1,7 @@
+package flowexport
+
+import "errors"
+
+var (
+	errPayloadTooSmall = errors.New("udp payload size too small to hold a single record")
+)
@@ -0,0 +1,243 @@
+package flowexport
+
+import (
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	// Length of a set (FlowSet in NetFlow v9) header.
+	setHeaderLen = 4
+
+	// Flows that haven't received an event in this amount of time are evicted
+	// from the exporter's flow table.
+	flowTimeout = time.Hour
+)
+
+// Protocol implements the message framing of a flow export protocol.
+type Protocol interface {
+	// HeaderLen returns the length of the message header.
+	HeaderLen() int
+
+	// TemplateSetID returns the set ID of a template set.
+	TemplateSetID() uint16
+
+	// SetAlignment returns the boundary sets are padded to. Zero or one
+	// means sets are not padded.
+	SetAlignment() int
+
+	// PutHeader writes the header into the first HeaderLen bytes of the
+	// complete message msg, given the amount of data and template records
+	// it holds. Is called once for every message that's sent, so the Protocol
+	// can keep track of its sequence numbers.
+	PutHeader(msg []byte, records, templates int)
+}
+
+// Exporter builds flow export messages from accounting events
+// and hands them to a send function. Not safe for concurrent use.
+type Exporter struct {
+	proto Protocol
+
+	// Maximum length of a message.
+	payloadSize int
+
+	// Interval at which templates are retransmitted.
+	refresh time.Duration
+
+	// Called with every message that is ready to be sent.
+	send func([]byte)
+
+	// Last-known counters of all flows.
+	table table
+
+	// Data sets of the pending message, one for each template.
+	templates []Template
+	sets      []dataSet
+
+	// Encoded template set and the last time it was sent.
+	templateSet  []byte
+	templateSent time.Time
+}
+
+// dataSet holds the encoded data records of a single template.
+type dataSet struct {
+	template Template
+	records  []byte
+	count    int
+}
+
+// NewExporter returns a new Exporter for the given Protocol. Messages are
+// at most payloadSize bytes long and are passed to send when they're full,
+// or when Flush is called. Templates are included in a message at least
+// every refresh interval. The timeFields are appended to the flow record
+// templates, they hold the flow start and end times in the protocol's
+// preferred encoding.
+func NewExporter(p Protocol, payloadSize int, refresh time.Duration, send func([]byte), timeFields ...Field) (*Exporter, error) {
+
+	x := Exporter{
+		proto:       p,
+		payloadSize: payloadSize,
+		refresh:     refresh,
+		send:        send,
+		table:       newTable(),
+		templates:   flowTemplates(timeFields...),
+	}
+
+	for _, t := range x.templates {
+		x.sets = append(x.sets, dataSet{template: t})
+	}
+
+	x.templateSet = x.marshalTemplateSet()
+
+	// The message needs to be able to hold templates and a single record of every kind.
+	l := p.HeaderLen() + len(x.templateSet)
+	for _, t := range x.templates {
+		l += x.pad(setHeaderLen + t.RecordLen())
+	}
+	if payloadSize < l {
+		return nil, errPayloadTooSmall
+	}
+
+	return &x, nil
+}
+
+// Push adds the flow records of an Event with absolute time stamp ts
+// to the pending message.
+func (x *Exporter) Push(e bpf.Event, ts time.Time) {
+	for _, r := range x.table.update(e, ts) {
+		x.add(r)
+	}
+}
+
+// Pending returns the amount of data records in the pending message.
+func (x *Exporter) Pending() int {
+	var n int
+	for _, ds := range x.sets {
+		n += ds.count
+	}
+	return n
+}
+
+// Flush sends the pending message. Includes the template set if it's due for
+// retransmission, sending a message with only templates if necessary.
+func (x *Exporter) Flush() {
+
+	tmpl := x.templateDue()
+	if !tmpl && x.Pending() == 0 {
+		return
+	}
+
+	msg := make([]byte, x.proto.HeaderLen(), x.messageLen())
+
+	var templates int
+	if tmpl {
+		msg = append(msg, x.templateSet...)
+		templates = len(x.templates)
+		x.templateSent = time.Now()
+	}
+
+	for i := range x.sets {
+		ds := &x.sets[i]
+		if ds.count == 0 {
+			continue
+		}
+
+		l := setHeaderLen + len(ds.records)
+		msg = AppendUint16(msg, ds.template.ID)
+		msg = AppendUint16(msg, uint16(x.pad(l)))
+		msg = append(msg, ds.records...)
+		msg = append(msg, make([]byte, x.pad(l)-l)...)
+	}
+
+	x.proto.PutHeader(msg, x.Pending(), templates)
+
+	for i := range x.sets {
+		x.sets[i].records = x.sets[i].records[:0]
+		x.sets[i].count = 0
+	}
+
+	x.send(msg)
+}
+
+// Evict removes stale flows from the Exporter's flow table.
+func (x *Exporter) Evict() {
+	x.table.evict(flowTimeout)
+}
+
+// add adds a record to the data set of its address family. Flushes
+// the pending message first if the record would not fit.
+func (x *Exporter) add(r Record) {
+
+	ds := &x.sets[1]
+	if r.IPv4() {
+		ds = &x.sets[0]
+	}
+
+	// Set header is only written for non-empty sets.
+	l := x.pad(setHeaderLen+len(ds.records)+ds.template.RecordLen()) - x.setLen(ds)
+
+	if x.messageLen()+l > x.payloadSize {
+		x.Flush()
+	}
+
+	ds.records = ds.template.appendRecord(ds.records, r)
+	ds.count++
+}
+
+// messageLen returns the length of the pending message.
+func (x *Exporter) messageLen() int {
+
+	l := x.proto.HeaderLen()
+	for i := range x.sets {
+		l += x.setLen(&x.sets[i])
+	}
+	if x.templateDue() {
+		l += len(x.templateSet)
+	}
+
+	return l
+}
+
+// setLen returns the length of a data set when written to a message,
+// including padding. An empty set is not written.
+func (x *Exporter) setLen(ds *dataSet) int {
+	if ds.count == 0 {
+		return 0
+	}
+	return x.pad(setHeaderLen + len(ds.records))
+}
+
+// templateDue returns true if the templates need to be sent
+// along with the next message.
+func (x *Exporter) templateDue() bool {
+	return time.Since(x.templateSent) >= x.refresh
+}
+
+// marshalTemplateSet returns a template set containing all templates.
+func (x *Exporter) marshalTemplateSet() []byte {
+
+	l := setHeaderLen
+	for _, t := range x.templates {
+		l += t.templateLen()
+	}
+
+	b := make([]byte, 0, x.pad(l))
+	b = AppendUint16(b, x.proto.TemplateSetID())
+	b = AppendUint16(b, uint16(x.pad(l)))
+
+	for _, t := range x.templates {
+		b = t.appendTemplate(b)
+	}
+
+	return append(b, make([]byte, x.pad(l)-l)...)
+}
+
+// pad rounds l up to the Protocol's set alignment.
+func (x *Exporter) pad(l int) int {
+	a := x.proto.SetAlignment()
+	if a <= 1 {
+		return l
+	}
+	return (l + a - 1) / a * a
+}
@@ -0,0 +1,137 @@
+package flowexport
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// testProtocol is a Protocol with an 8-byte header holding the message
+// length and the record counts, with sets padded to 4 bytes.
+type testProtocol struct{}
+
+func (testProtocol) HeaderLen() int        { return 8 }
+func (testProtocol) TemplateSetID() uint16 { return 2 }
+func (testProtocol) SetAlignment() int     { return 4 }
+func (testProtocol) PutHeader(msg []byte, records, templates int) {
+	binary.BigEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.BigEndian.PutUint16(msg[4:6], uint16(records))
+	binary.BigEndian.PutUint16(msg[6:8], uint16(templates))
+}
+
+func newTestExporter(t *testing.T, payloadSize int) (*Exporter, *[][]byte) {
+
+	var out [][]byte
+	x, err := NewExporter(testProtocol{}, payloadSize, time.Hour, func(b []byte) { out = append(out, b) })
+	require.NoError(t, err)
+
+	return x, &out
+}
+
+func TestExporterTemplates(t *testing.T) {
+
+	x, out := newTestExporter(t, 1400)
+
+	// Flush without records sends the templates.
+	x.Flush()
+	require.Len(t, *out, 1)
+
+	msg := (*out)[0]
+	assert.EqualValues(t, len(msg), binary.BigEndian.Uint32(msg[0:4]), "message length")
+	assert.EqualValues(t, 0, binary.BigEndian.Uint16(msg[4:6]), "data records")
+	assert.EqualValues(t, 2, binary.BigEndian.Uint16(msg[6:8]), "template records")
+
+	assert.EqualValues(t, 2, binary.BigEndian.Uint16(msg[8:10]), "template set id")
+	assert.EqualValues(t, len(msg)-8, binary.BigEndian.Uint16(msg[10:12]), "template set length")
+	assert.Zero(t, len(msg)%4, "template set padded")
+
+	// Templates are not resent before the refresh interval.
+	x.Flush()
+	assert.Len(t, *out, 1)
+}
+
+func TestExporterDeltas(t *testing.T) {
+
+	x, out := newTestExporter(t, 1400)
+	x.templateSent = time.Now()
+
+	e := bpf.Event{
+		ConnectionID: 1,
+		SrcAddr:      net.IPv4(10, 0, 0, 1),
+		DstAddr:      net.IPv4(10, 0, 0, 2),
+		PacketsOrig:  2,
+		BytesOrig:    200,
+		Proto:        17,
+	}
+
+	// Flow only saw traffic in the original direction.
+	x.Push(e, time.Now())
+	assert.Equal(t, 1, x.Pending())
+
+	// Second event carries traffic in both directions.
+	e.PacketsOrig, e.BytesOrig = 3, 300
+	e.PacketsRet, e.BytesRet = 1, 50
+	x.Push(e, time.Now())
+	assert.Equal(t, 3, x.Pending())
+
+	x.Flush()
+	require.Len(t, *out, 1)
+
+	msg := (*out)[0]
+	rl := x.templates[0].RecordLen()
+
+	assert.EqualValues(t, 3, binary.BigEndian.Uint16(msg[4:6]), "data records")
+	assert.EqualValues(t, templateIPv4, binary.BigEndian.Uint16(msg[8:10]), "data set id")
+	assert.EqualValues(t, x.pad(setHeaderLen+3*rl), binary.BigEndian.Uint16(msg[10:12]), "data set length")
+
+	// Octet delta count of the second record, located after the flow ID,
+	// addresses, ports and protocol.
+	off := 12 + rl + 8 + 4 + 4 + 2 + 2 + 1
+	assert.EqualValues(t, 100, binary.BigEndian.Uint64(msg[off:off+8]), "octet delta")
+	assert.EqualValues(t, EndActiveTimeout, msg[off+16], "flow end reason")
+
+	// Destroy events without new traffic still end the flow.
+	e.Destroy = true
+	x.Push(e, time.Now())
+	assert.Equal(t, 1, x.Pending())
+	assert.Empty(t, x.table.flows)
+
+	x.Flush()
+	require.Len(t, *out, 2)
+
+	off = 12 + 8 + 4 + 4 + 2 + 2 + 1
+	msg = (*out)[1]
+	assert.EqualValues(t, 0, binary.BigEndian.Uint64(msg[off:off+8]), "octet delta")
+	assert.EqualValues(t, EndOfFlow, msg[off+16], "flow end reason")
+}
+
+func TestExporterPayloadSize(t *testing.T) {
+
+	_, err := NewExporter(testProtocol{}, 64, time.Hour, func([]byte) {})
+	assert.EqualError(t, err, errPayloadTooSmall.Error())
+
+	x, out := newTestExporter(t, 512)
+	x.templateSent = time.Now()
+
+	// Push more records than fit in a single message.
+	for i := uint32(0); i < 20; i++ {
+		x.Push(bpf.Event{
+			ConnectionID: i,
+			SrcAddr:      net.IPv4(10, 0, 0, 1),
+			DstAddr:      net.IPv4(10, 0, 0, 2),
+			PacketsOrig:  1,
+		}, time.Now())
+	}
+	x.Flush()
+
+	require.True(t, len(*out) > 1, "records split over multiple messages")
+	for _, msg := range *out {
+		assert.True(t, len(msg) <= 512, "message exceeds payload size")
+	}
+}
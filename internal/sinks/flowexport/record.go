@@ -0,0 +1,171 @@
+package flowexport
+
+import (
+	"net"
+	"time"
+)
+
+// Information Element identifiers shared by IPFIX (IANA registry)
+// and NetFlow v9. (RFC 3954 field types)
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowEndReason            = 136
+	ieFlowID                   = 148
+)
+
+// Values of flowEndReason telling the records of update and destroy
+// events apart. (IANA IPFIX flowEndReason sub-registry)
+const (
+	// The flow is still active, the record holds its traffic since the
+	// previous record.
+	EndActiveTimeout = 0x02
+	// The flow was removed from the conntrack table, the record is its last.
+	EndOfFlow = 0x03
+)
+
+// Template IDs of the records exported by the sinks. Data sets carry
+// the ID of the template describing their records.
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// Record is a unidirectional flow record.
+type Record struct {
+	FlowID  uint64
+	SrcAddr net.IP
+	DstAddr net.IP
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+	Octets  uint64
+	Packets uint64
+	Start   time.Time
+	End     time.Time
+
+	// EndActiveTimeout for records of update events,
+	// EndOfFlow for records of destroy events.
+	EndReason uint8
+}
+
+// IPv4 returns true if both of the record's addresses are IPv4 addresses.
+func (r Record) IPv4() bool {
+	return r.SrcAddr.To4() != nil && r.DstAddr.To4() != nil
+}
+
+// Field is a field specifier in a template record, along with a function
+// that appends the field's value in a record to a byte slice.
+type Field struct {
+	ID     uint16
+	Length uint16
+	Append func(b []byte, r Record) []byte
+}
+
+// Template is a template record, describing the layout of a data record.
+type Template struct {
+	ID     uint16
+	Fields []Field
+}
+
+// RecordLen returns the length of a data record described by the Template.
+func (t Template) RecordLen() int {
+	var l int
+	for _, f := range t.Fields {
+		l += int(f.Length)
+	}
+	return l
+}
+
+// appendRecord appends the binary representation of a record to b.
+func (t Template) appendRecord(b []byte, r Record) []byte {
+	for _, f := range t.Fields {
+		b = f.Append(b, r)
+	}
+	return b
+}
+
+// appendTemplate appends the binary representation of the template record to b.
+func (t Template) appendTemplate(b []byte) []byte {
+
+	b = AppendUint16(b, t.ID)
+	b = AppendUint16(b, uint16(len(t.Fields)))
+
+	for _, f := range t.Fields {
+		b = AppendUint16(b, f.ID)
+		b = AppendUint16(b, f.Length)
+	}
+
+	return b
+}
+
+// templateLen returns the length of the template record.
+func (t Template) templateLen() int {
+	return 4 + 4*len(t.Fields)
+}
+
+// flowTemplates returns the IPv4 and IPv6 flow record templates.
+// The timeFields are appended to both templates, since their encoding
+// differs between export protocols.
+func flowTemplates(timeFields ...Field) []Template {
+
+	v4 := []Field{
+		{ieSourceIPv4Address, net.IPv4len, func(b []byte, r Record) []byte { return append(b, r.SrcAddr.To4()...) }},
+		{ieDestinationIPv4Address, net.IPv4len, func(b []byte, r Record) []byte { return append(b, r.DstAddr.To4()...) }},
+	}
+
+	v6 := []Field{
+		{ieSourceIPv6Address, net.IPv6len, func(b []byte, r Record) []byte { return append(b, r.SrcAddr.To16()...) }},
+		{ieDestinationIPv6Address, net.IPv6len, func(b []byte, r Record) []byte { return append(b, r.DstAddr.To16()...) }},
+	}
+
+	return []Template{
+		{templateIPv4, flowFields(v4, timeFields)},
+		{templateIPv6, flowFields(v6, timeFields)},
+	}
+}
+
+// flowFields returns the list of fields of a flow record
+// given the address fields of its address family.
+func flowFields(addrFields []Field, timeFields []Field) []Field {
+
+	f := []Field{
+		{ieFlowID, 8, func(b []byte, r Record) []byte { return AppendUint64(b, r.FlowID) }},
+	}
+
+	f = append(f, addrFields...)
+	f = append(f, []Field{
+		{ieSourceTransportPort, 2, func(b []byte, r Record) []byte { return AppendUint16(b, r.SrcPort) }},
+		{ieDestinationTransportPort, 2, func(b []byte, r Record) []byte { return AppendUint16(b, r.DstPort) }},
+		{ieProtocolIdentifier, 1, func(b []byte, r Record) []byte { return append(b, r.Proto) }},
+		{ieOctetDeltaCount, 8, func(b []byte, r Record) []byte { return AppendUint64(b, r.Octets) }},
+		{iePacketDeltaCount, 8, func(b []byte, r Record) []byte { return AppendUint64(b, r.Packets) }},
+		{ieFlowEndReason, 1, func(b []byte, r Record) []byte { return append(b, r.EndReason) }},
+	}...)
+
+	return append(f, timeFields...)
+}
+
+// AppendUint16 appends the big-endian representation of v to b.
+func AppendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// AppendUint32 appends the big-endian representation of v to b.
+func AppendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// AppendUint64 appends the big-endian representation of v to b.
+func AppendUint64(b []byte, v uint64) []byte {
+	return append(b,
+		byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
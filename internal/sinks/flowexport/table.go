@@ -0,0 +1,152 @@
+package flowexport
+
+import (
+	"net"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// table keeps the last-known counters of all flows, used for calculating
+// the deltas exported in flow records. Not safe for concurrent use.
+type table struct {
+	flows map[flowKey]*flowState
+}
+
+// flowKey identifies a flow in the table. The connection ID alone
+// is not sufficient, since the kernel reuses them.
+type flowKey struct {
+	id      uint32
+	netns   uint32
+	proto   uint8
+	srcPort uint16
+	dstPort uint16
+	srcAddr [net.IPv6len]byte
+	dstAddr [net.IPv6len]byte
+}
+
+// flowState holds the counters of a flow at its last event.
+type flowState struct {
+	packetsOrig uint64
+	bytesOrig   uint64
+	packetsRet  uint64
+	bytesRet    uint64
+
+	// Estimated start time of the flow.
+	start time.Time
+	// Time of the flow's last event, for evicting stale entries.
+	seen time.Time
+}
+
+func newTable() table {
+	return table{flows: make(map[flowKey]*flowState)}
+}
+
+// update records the counters of an Event with absolute time stamp ts in the
+// table and returns the flow records describing the traffic since the flow's
+// previous event. An event yields up to two records, one for each direction
+// of the connection. Directions that saw no traffic don't yield a record,
+// except for the original direction of a destroy event, so the end of every
+// flow is exported. Destroyed flows are removed from the table.
+func (t *table) update(e bpf.Event, ts time.Time) []Record {
+
+	k := newFlowKey(e)
+	f, ok := t.flows[k]
+
+	// Start tracking the flow if it's new, or if its counters went backwards,
+	// meaning the connection ID was reused for a different flow.
+	if !ok || e.PacketsOrig < f.packetsOrig || e.PacketsRet < f.packetsRet {
+		f = &flowState{start: ts}
+		t.flows[k] = f
+	}
+
+	// Destroy events carry the flow start time recorded by conntrack.
+	if e.Start != 0 {
+		f.start = time.Unix(0, int64(e.Start))
+	}
+
+	out := make([]Record, 0, 2)
+
+	reason := uint8(EndActiveTimeout)
+	if e.Destroy {
+		reason = EndOfFlow
+	}
+
+	// Like most exporters, put the ICMP type and code of the request
+	// into the destination port of the original direction.
+	dstPort := e.DstPort
+	if e.ICMP() {
+		dstPort = uint16(e.ICMPType)<<8 | uint16(e.ICMPCode)
+	}
+
+	if po := e.PacketsOrig - f.packetsOrig; po != 0 || e.Destroy {
+		out = append(out, Record{
+			FlowID:    uint64(e.ConnectionID),
+			SrcAddr:   e.SrcAddr,
+			DstAddr:   e.DstAddr,
+			SrcPort:   e.SrcPort,
+			DstPort:   dstPort,
+			Proto:     e.Proto,
+			Octets:    e.BytesOrig - f.bytesOrig,
+			Packets:   po,
+			Start:     f.start,
+			End:       ts,
+			EndReason: reason,
+		})
+	}
+
+	// Records of the reply direction have their addresses and ports swapped.
+	if pr := e.PacketsRet - f.packetsRet; pr != 0 {
+		out = append(out, Record{
+			FlowID:    uint64(e.ConnectionID),
+			SrcAddr:   e.DstAddr,
+			DstAddr:   e.SrcAddr,
+			SrcPort:   e.DstPort,
+			DstPort:   e.SrcPort,
+			Proto:     e.Proto,
+			Octets:    e.BytesRet - f.bytesRet,
+			Packets:   pr,
+			Start:     f.start,
+			End:       ts,
+			EndReason: reason,
+		})
+	}
+
+	if e.Destroy {
+		delete(t.flows, k)
+		return out
+	}
+
+	f.packetsOrig, f.bytesOrig = e.PacketsOrig, e.BytesOrig
+	f.packetsRet, f.bytesRet = e.PacketsRet, e.BytesRet
+	f.seen = time.Now()
+
+	return out
+}
+
+// evict removes all flows that haven't received an event within
+// the given timeout from the table.
+func (t *table) evict(timeout time.Duration) {
+	for k, f := range t.flows {
+		if time.Since(f.seen) > timeout {
+			delete(t.flows, k)
+		}
+	}
+}
+
+// newFlowKey returns the flowKey of an Event.
+func newFlowKey(e bpf.Event) flowKey {
+
+	k := flowKey{
+		id:      e.ConnectionID,
+		netns:   e.NetNS,
+		proto:   e.Proto,
+		srcPort: e.SrcPort,
+		dstPort: e.DstPort,
+	}
+
+	copy(k.srcAddr[:], e.SrcAddr.To16())
+	copy(k.dstAddr[:], e.DstAddr.To16())
+
+	return k
+}
@@ -0,0 +1,11 @@
+package graphite
+
+import "errors"
+
+var (
+	errEmptySinkName    = errors.New("empty sink name")
+	errEmptySinkAddress = errors.New("empty sink address")
+	errInvalidSinkType  = errors.New("invalid sink type")
+	errInvalidPrefix    = errors.New("metric prefix must not contain spaces or start or end with a dot")
+	errSinkClosed       = errors.New("sink is closed")
+)
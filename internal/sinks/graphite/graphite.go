@@ -0,0 +1,256 @@
+package graphite
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultPrefix        = "conntracct"
+	defaultFlushInterval = 10 * time.Second
+	defaultTimeout       = 5 * time.Second
+)
+
+// GraphiteSink is an accounting sink writing metrics in the Graphite
+// plaintext protocol over TCP. Instead of individual flows, it sends the
+// traffic of all flows rolled up by protocol and destination port, as
+// '<prefix>.<proto>.<dst_port>.<metric> <value> <timestamp>' lines.
+type GraphiteSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Connection to the Graphite server, redialed by the send worker
+	// after a write error. Only accessed by the send worker.
+	conn net.Conn
+
+	// TLS configuration of the connection, nil for plaintext.
+	tls *tls.Config
+
+	// Channel the send worker receives batches of metric lines on.
+	sendChan chan sendReq
+
+	// Rolls up the traffic of flows between flushes, protected by mu.
+	mu     sync.Mutex
+	rollup rollup
+
+	// Set when the sink is closed, protected by mu.
+	// Events pushed afterwards are dropped.
+	closed bool
+
+	// Closed by Close to stop the tick worker.
+	done chan struct{}
+
+	// Tracks the send worker, waited on by Close.
+	wg sync.WaitGroup
+
+	// Sink stats.
+	stats types.SinkStats
+}
+
+// sendReq is a batch of metric lines to be written by the send worker.
+// If done is set, the result of the write is sent on it.
+type sendReq struct {
+	msg  []byte
+	done chan error
+}
+
+// New returns a new Graphite accounting sink.
+func New() GraphiteSink {
+	return GraphiteSink{}
+}
+
+// Init initializes the Graphite accounting sink.
+func (s *GraphiteSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Address == "" {
+		return errEmptySinkAddress
+	}
+	if sc.Type != types.Graphite {
+		return errInvalidSinkType
+	}
+	if sc.Prefix == "" {
+		sc.Prefix = defaultPrefix
+	}
+	if strings.ContainsAny(sc.Prefix, " \t\n") ||
+		strings.HasPrefix(sc.Prefix, ".") || strings.HasSuffix(sc.Prefix, ".") {
+		return errInvalidPrefix
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Timeout == 0 {
+		sc.Timeout = defaultTimeout
+	}
+
+	tc, err := sc.TLS.Config()
+	if err != nil {
+		return err
+	}
+	s.tls = tc
+	s.config = sc
+
+	// Fail early if the server is unreachable, later connection
+	// errors are retried on every flush.
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	s.conn = c             // server connection
+	s.rollup = newRollup() // per-port counters
+	s.sendChan = make(chan sendReq, 16)
+	s.done = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.sendWorker()
+	go s.tickWorker()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push adds the traffic of an accounting event to the rollup of
+// its protocol and destination port.
+func (s *GraphiteSink) Push(e bpf.Event) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
+	s.rollup.update(e)
+
+	// Record statistics.
+	s.stats.SetBatchLength(s.rollup.pending())
+	s.stats.IncrEventsPushed()
+
+	return nil
+}
+
+// PushBatch adds the traffic of a batch of accounting events to the rollups.
+func (s *GraphiteSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// Flush sends the current rollups to the send worker and waits until they
+// and all batches queued before them are written, or until ctx is done.
+func (s *GraphiteSink) Flush(ctx context.Context) error {
+
+	done := make(chan error, 1)
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return errSinkClosed
+	}
+
+	s.sendChan <- sendReq{msg: s.flush(time.Now()), done: done}
+
+	s.mu.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckHealth checks if a connection to the Graphite server
+// can be established.
+func (s *GraphiteSink) CheckHealth(ctx context.Context) error {
+
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	return c.Close()
+}
+
+// Close sends the current rollups and waits for all pending batches to be
+// written before closing the server connection. Must only be called once.
+func (s *GraphiteSink) Close() error {
+
+	close(s.done)
+
+	s.mu.Lock()
+	s.sendChan <- sendReq{msg: s.flush(time.Now())}
+	s.closed = true
+	close(s.sendChan)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// flush returns the metric lines of the current rollups with time stamp ts
+// and resets them. mu must be held.
+func (s *GraphiteSink) flush(ts time.Time) []byte {
+	b := s.rollup.appendMetrics(nil, s.config.Prefix, ts)
+	s.stats.SetBatchLength(0)
+	return b
+}
+
+// Name gets the name of the Graphite accounting sink.
+func (s *GraphiteSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the Graphite accounting sink was successfully initialized.
+func (s *GraphiteSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true, update events carry the traffic
+// of flows that are still alive.
+func (s *GraphiteSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, Graphite receives destroy events
+// for the traffic of flows since their last update.
+func (s *GraphiteSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the Graphite accounting sink's statistics structure.
+func (s *GraphiteSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// dial connects to the Graphite server, over TLS if configured.
+func (s *GraphiteSink) dial() (net.Conn, error) {
+
+	d := &net.Dialer{Timeout: s.config.Timeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(d, "tcp", s.config.Address, s.tls)
+	}
+
+	return d.Dial("tcp", s.config.Address)
+}
@@ -0,0 +1,211 @@
+package graphite
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Flows that haven't received an event in this amount of time are evicted
+// from the rollup's flow table.
+const flowTimeout = time.Hour
+
+// rollup sums up the traffic of flows since their previous event by protocol
+// and destination port. Not safe for concurrent use.
+type rollup struct {
+	// Last-known counters of all flows.
+	flows map[flowKey]*flowState
+
+	// Traffic since the last flush.
+	ports map[portKey]*portStats
+
+	// Incremented on every flush, for counting flows once per interval.
+	interval uint64
+}
+
+// flowKey identifies a flow in the rollup. The connection ID alone
+// is not sufficient, since the kernel reuses them.
+type flowKey struct {
+	id      uint32
+	netns   uint32
+	proto   uint8
+	srcPort uint16
+	dstPort uint16
+	srcAddr [net.IPv6len]byte
+	dstAddr [net.IPv6len]byte
+}
+
+// flowState holds the counters of a flow at its last event.
+type flowState struct {
+	packetsOrig uint64
+	bytesOrig   uint64
+	packetsRet  uint64
+	bytesRet    uint64
+
+	// Time of the flow's last event, for evicting stale entries.
+	seen time.Time
+
+	// Interval the flow was last counted in.
+	interval uint64
+}
+
+// portKey is the protocol and destination port metrics are rolled up by.
+type portKey struct {
+	proto uint8
+	port  uint16
+}
+
+// portStats holds the traffic of a portKey since the last flush.
+type portStats struct {
+	// Amount of flows that received events, and amount of flows destroyed.
+	flows     uint64
+	destroyed uint64
+
+	packetsOrig uint64
+	bytesOrig   uint64
+	packetsRet  uint64
+	bytesRet    uint64
+}
+
+func newRollup() rollup {
+	return rollup{
+		flows:    make(map[flowKey]*flowState),
+		ports:    make(map[portKey]*portStats),
+		interval: 1,
+	}
+}
+
+// update adds the traffic of a flow since its previous event to the stats
+// of its protocol and destination port. Counters of sampled events are
+// scaled by their sample rate, estimating the traffic of all flows.
+func (r *rollup) update(e bpf.Event) {
+
+	k := newFlowKey(e)
+	f, ok := r.flows[k]
+
+	// Start tracking the flow if it's new, or if its counters went backwards,
+	// meaning the connection ID was reused for a different flow.
+	if !ok || e.PacketsOrig < f.packetsOrig || e.PacketsRet < f.packetsRet {
+		f = &flowState{}
+		r.flows[k] = f
+	}
+
+	pk := portKey{proto: e.Proto, port: e.DstPort}
+	ps, ok := r.ports[pk]
+	if !ok {
+		ps = &portStats{}
+		r.ports[pk] = ps
+	}
+
+	scale := uint64(1)
+	if e.SampleRate > 1 {
+		scale = uint64(e.SampleRate)
+	}
+
+	if f.interval != r.interval {
+		ps.flows += scale
+		f.interval = r.interval
+	}
+	ps.packetsOrig += (e.PacketsOrig - f.packetsOrig) * scale
+	ps.bytesOrig += (e.BytesOrig - f.bytesOrig) * scale
+	ps.packetsRet += (e.PacketsRet - f.packetsRet) * scale
+	ps.bytesRet += (e.BytesRet - f.bytesRet) * scale
+
+	// Destroy events carry a flow's final counters.
+	if e.Destroy {
+		ps.destroyed += scale
+		delete(r.flows, k)
+		return
+	}
+
+	f.packetsOrig, f.bytesOrig = e.PacketsOrig, e.BytesOrig
+	f.packetsRet, f.bytesRet = e.PacketsRet, e.BytesRet
+	f.seen = time.Now()
+}
+
+// pending returns the amount of rolled up protocol and port pairs.
+func (r *rollup) pending() int {
+	return len(r.ports)
+}
+
+// appendMetrics appends the plaintext metric lines of all rollups with time
+// stamp ts to b, sorted by protocol and port, and resets the rollups.
+// A flow counts towards the flows metric of every interval it received
+// an event in.
+func (r *rollup) appendMetrics(b []byte, prefix string, ts time.Time) []byte {
+
+	keys := make([]portKey, 0, len(r.ports))
+	for k := range r.ports {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].proto != keys[j].proto {
+			return keys[i].proto < keys[j].proto
+		}
+		return keys[i].port < keys[j].port
+	})
+
+	t := ts.Unix()
+	for _, k := range keys {
+		ps := r.ports[k]
+
+		// Protocols without a name are given by their number.
+		proto := bpf.ProtoName(k.proto)
+		if proto == "unknown" {
+			proto = strconv.FormatUint(uint64(k.proto), 10)
+		}
+		path := prefix + "." + proto + "." + strconv.FormatUint(uint64(k.port), 10) + "."
+
+		b = appendMetric(b, path+"flows", ps.flows, t)
+		b = appendMetric(b, path+"flows_destroyed", ps.destroyed, t)
+		b = appendMetric(b, path+"packets_orig", ps.packetsOrig, t)
+		b = appendMetric(b, path+"bytes_orig", ps.bytesOrig, t)
+		b = appendMetric(b, path+"packets_ret", ps.packetsRet, t)
+		b = appendMetric(b, path+"bytes_ret", ps.bytesRet, t)
+	}
+
+	r.ports = make(map[portKey]*portStats)
+	r.interval++
+
+	return b
+}
+
+// evict removes all flows that haven't received an event within
+// the given timeout from the rollup.
+func (r *rollup) evict(timeout time.Duration) {
+	for k, f := range r.flows {
+		if time.Since(f.seen) > timeout {
+			delete(r.flows, k)
+		}
+	}
+}
+
+// appendMetric appends a single plaintext metric line to b.
+func appendMetric(b []byte, path string, v uint64, ts int64) []byte {
+	b = append(b, path...)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, v, 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts, 10)
+	return append(b, '\n')
+}
+
+// newFlowKey returns the flowKey of an Event.
+func newFlowKey(e bpf.Event) flowKey {
+
+	k := flowKey{
+		id:      e.ConnectionID,
+		netns:   e.NetNS,
+		proto:   e.Proto,
+		srcPort: e.SrcPort,
+		dstPort: e.DstPort,
+	}
+
+	copy(k.srcAddr[:], e.SrcAddr.To16())
+	copy(k.dstAddr[:], e.DstAddr.To16())
+
+	return k
+}
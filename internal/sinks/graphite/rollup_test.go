@@ -0,0 +1,65 @@
+package graphite
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestRollup(t *testing.T) {
+
+	r := newRollup()
+
+	e := bpf.Event{
+		ConnectionID: 1,
+		SrcAddr:      net.IPv4(10, 0, 0, 1),
+		DstAddr:      net.IPv4(10, 0, 0, 2),
+		SrcPort:      40000,
+		DstPort:      443,
+		Proto:        bpf.ProtoTCP,
+		PacketsOrig:  10,
+		BytesOrig:    1000,
+		PacketsRet:   5,
+		BytesRet:     500,
+	}
+	r.update(e)
+
+	// Later events only add the traffic since the flow's previous event.
+	e.PacketsOrig, e.BytesOrig, e.Destroy = 12, 1200, true
+	r.update(e)
+
+	// Another flow to the same port, sampled.
+	e2 := e
+	e2.ConnectionID, e2.SrcPort, e2.Destroy, e2.SampleRate = 2, 40001, false, 4
+	e2.PacketsOrig, e2.BytesOrig, e2.PacketsRet, e2.BytesRet = 1, 60, 0, 0
+	r.update(e2)
+
+	r.update(bpf.Event{ConnectionID: 3, Proto: 253, PacketsOrig: 1, BytesOrig: 40})
+
+	assert.Equal(t, 2, r.pending())
+
+	ts := time.Unix(1600000000, 0)
+	assert.Equal(t, ""+
+		"ct.tcp.443.flows 5 1600000000\n"+
+		"ct.tcp.443.flows_destroyed 1 1600000000\n"+
+		"ct.tcp.443.packets_orig 16 1600000000\n"+
+		"ct.tcp.443.bytes_orig 1440 1600000000\n"+
+		"ct.tcp.443.packets_ret 5 1600000000\n"+
+		"ct.tcp.443.bytes_ret 500 1600000000\n"+
+		"ct.253.0.flows 1 1600000000\n"+
+		"ct.253.0.flows_destroyed 0 1600000000\n"+
+		"ct.253.0.packets_orig 1 1600000000\n"+
+		"ct.253.0.bytes_orig 40 1600000000\n"+
+		"ct.253.0.packets_ret 0 1600000000\n"+
+		"ct.253.0.bytes_ret 0 1600000000\n",
+		string(r.appendMetrics(nil, "ct", ts)))
+
+	// Rollups are reset on flush, destroyed flows are forgotten.
+	assert.Zero(t, r.pending())
+	assert.Len(t, r.flows, 2)
+	assert.Empty(t, r.appendMetrics(nil, "ct", ts))
+}
@@ -0,0 +1,100 @@
+package graphite
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interval at which stale flows are evicted from the rollup's flow table.
+const evictInterval = time.Minute
+
+// sendWorker receives batches from the sink's send channel
+// and writes them to the server. Exits when the send channel is closed.
+func (s *GraphiteSink) sendWorker() {
+
+	defer s.wg.Done()
+
+	for r := range s.sendChan {
+		err := s.send(r.msg)
+		if r.done != nil {
+			r.done <- err
+		}
+	}
+}
+
+// send writes a batch to the server, dialing it if the previous write
+// failed. Empty batches are not written.
+func (s *GraphiteSink) send(b []byte) error {
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	if s.conn == nil {
+		c, err := s.dial()
+		if err != nil {
+			log.Errorf("Graphite sink '%s': Error connecting: %s. Batch dropped.", s.config.Name, err)
+			s.stats.ObserveFlush(0, 0, err)
+			s.stats.IncrBatchDropped()
+			return err
+		}
+		s.conn = c
+	}
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.config.Timeout)); err != nil {
+		log.Errorf("Graphite sink '%s': Error setting write deadline: %s", s.config.Name, err)
+	}
+
+	// Write the batch. Its metrics are rolled up from many events,
+	// which aren't counted.
+	start := time.Now()
+	_, err := s.conn.Write(b)
+	s.stats.ObserveFlush(time.Since(start), 0, err)
+	if err != nil {
+		log.Errorf("Graphite sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
+
+		// Reconnect on the next batch, the connection may have been
+		// closed by the server.
+		s.conn.Close()
+		s.conn = nil
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// tickWorker starts a ticker that sends the rollups every flush interval
+// and evicts stale flows. Exits when the sink is closed.
+func (s *GraphiteSink) tickWorker() {
+
+	t := time.NewTicker(s.config.FlushInterval)
+	defer t.Stop()
+	e := time.NewTicker(evictInterval)
+	defer e.Stop()
+
+	for {
+		select {
+		case ts := <-t.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.sendChan <- sendReq{msg: s.flush(ts)}
+			}
+			s.mu.Unlock()
+		case <-e.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.rollup.evict(flowTimeout)
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -1,22 +1,39 @@
 package helpers
 
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
 // ProtoIntStr is a fast conversion of a protocol number into a string.
 // Only the types known in nf_conntrack_tuple_common.h are included.
 func ProtoIntStr(i uint8) string {
-	switch i {
-	case 1:
-		return "icmp"
-	case 6:
-		return "tcp"
-	case 17:
-		return "udp"
-	case 33:
-		return "dccp"
-	case 47:
-		return "gre"
-	case 132:
-		return "sctp"
+	return bpf.ProtoName(i)
+}
+
+// ParseProto parses a protocol name known to ProtoIntStr or a protocol number.
+func ParseProto(s string) (uint8, error) {
+
+	if n, ok := bpf.ProtoNumber(s); ok {
+		return n, nil
 	}
 
-	return "unknown"
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol '%s'", s)
+	}
+
+	return uint8(n), nil
+}
+
+// FamilyStr converts an Event's address family into a string.
+func FamilyStr(f uint8) string {
+	return bpf.FamilyName(f)
+}
+
+// TCPStateStr converts a conntrack TCP state into a string.
+func TCPStateStr(s uint8) string {
+	return bpf.TCPStateName(s)
 }
@@ -1,9 +1,30 @@
 package influxdb
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
 
 var (
 	errEmptySinkName    = errors.New("empty sink name")
 	errEmptySinkAddress = errors.New("empty sink address")
 	errInvalidSinkType  = errors.New("invalid sink type")
+	errEmptyDatabase    = errors.New("empty database name")
+	errEmptyOrg         = errors.New("empty organization")
+	errEmptyBucket      = errors.New("empty bucket")
+	errTLSScheme        = errors.New("tls requires an https address")
+	errTagLists         = errors.New("includeTags and excludeTags are mutually exclusive")
+	errSinkClosed       = types.ErrSinkClosed
+	errEmptyTagKey      = errors.New("empty tag key")
+	errInvalidFloat     = errors.New("NaN and infinite field values are not supported")
+	errMaxKeyLength     = errors.New("max key length exceeded")
+)
+
+const (
+	errFmtScheme = "unsupported address scheme '%s', expected http or https"
+	errFmtStatus = "unexpected response status %d: %s"
+
+	errFmtPayloadSize = "udp payload size %d exceeds the maximum of %d"
+	errFmtLineSize    = "point of %d bytes exceeds the udp payload size of %d"
 )
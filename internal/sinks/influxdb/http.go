@@ -0,0 +1,279 @@
+package influxdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	defaultMaxRetries   = 5
+	defaultRetryBackoff = time.Second
+	defaultTimeout      = 10 * time.Second
+
+	// Upper bound of the time between two write attempts.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// httpClient writes batches to InfluxDB's HTTP write API. Request bodies
+// are optionally gzip-compressed. Writes that fail due to network or server
+// errors are retried with an exponential backoff, client errors (4xx) are
+// not retried.
+type httpClient struct {
+	name string
+
+	// Write endpoint, including query parameters.
+	url string
+
+	// Ping endpoint, answered by both InfluxDB 1.x and 2.x.
+	pingURL string
+
+	username string
+	password string
+	token    string
+	gzip     bool
+
+	maxRetries int
+	backoff    time.Duration
+
+	// Statistics of the sink owning the client, counting retries if set.
+	stats *types.SinkStats
+
+	client *http.Client
+}
+
+// newHTTPClient returns an httpClient writing to the InfluxDB 1.x write API.
+func newHTTPClient(sc types.SinkConfig) (*httpClient, error) {
+
+	if sc.Database == "" {
+		return nil, errEmptyDatabase
+	}
+
+	q := url.Values{}
+	q.Set("db", sc.Database)
+
+	return newWriteClient(sc, "write", q)
+}
+
+// newHTTPClientV2 returns an httpClient writing to the InfluxDB 2.x write API,
+// authenticating with the configured token.
+func newHTTPClientV2(sc types.SinkConfig) (*httpClient, error) {
+
+	if sc.Org == "" {
+		return nil, errEmptyOrg
+	}
+	if sc.Bucket == "" {
+		return nil, errEmptyBucket
+	}
+
+	q := url.Values{}
+	q.Set("org", sc.Org)
+	q.Set("bucket", sc.Bucket)
+
+	return newWriteClient(sc, "api/v2/write", q)
+}
+
+// newWriteClient returns an httpClient posting to the given endpoint relative
+// to the sink's address, with the given query parameters.
+func newWriteClient(sc types.SinkConfig, endpoint string, q url.Values) (*httpClient, error) {
+
+	u, err := url.Parse(sc.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf(errFmtScheme, u.Scheme)
+	}
+
+	tc, err := sc.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	if tc != nil && u.Scheme != "https" {
+		return nil, errTLSScheme
+	}
+
+	q.Set("precision", "ns")
+
+	pu := *u
+	pu.Path = path.Join(u.Path, "ping")
+
+	u.Path = path.Join(u.Path, endpoint)
+	u.RawQuery = q.Encode()
+
+	c := httpClient{
+		name:       sc.Name,
+		url:        u.String(),
+		pingURL:    pu.String(),
+		username:   sc.Username,
+		password:   sc.Password,
+		token:      sc.Token,
+		gzip:       sc.Gzip,
+		maxRetries: int(sc.MaxRetries),
+		backoff:    sc.RetryBackoff,
+		client:     &http.Client{Timeout: sc.Timeout},
+	}
+
+	if tc != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tc
+		c.client.Transport = t
+	}
+
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	}
+	if c.backoff == 0 {
+		c.backoff = defaultRetryBackoff
+	}
+	if c.client.Timeout == 0 {
+		c.client.Timeout = defaultTimeout
+	}
+
+	return &c, nil
+}
+
+// Write writes the batch to InfluxDB, gzip-compressed if enabled.
+// Blocks until the batch is written or all retries are exhausted.
+func (c *httpClient) Write(b *batch) error {
+
+	body := b.buf
+	if c.gzip {
+		buf := gzipPool.Get().(*gzipBuffer)
+		defer gzipPool.Put(buf)
+
+		if err := buf.compress(b.buf); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.write(body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt == c.maxRetries {
+			return err
+		}
+
+		if c.stats != nil {
+			c.stats.IncrRetries()
+		}
+
+		log.Warnf("InfluxDB sink '%s': Error writing batch: %s. Retrying in %s.", c.name, err, backoff)
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// Close releases the idle connections held by the http.Client.
+func (c *httpClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// Ping checks if InfluxDB responds to requests.
+func (c *httpClient) Ping(ctx context.Context) error {
+
+	req, err := http.NewRequest(http.MethodGet, c.pingURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf(errFmtStatus, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// write makes a single write request to InfluxDB with the given body.
+// Returns true if the write failed and should be retried.
+func (c *httpClient) write(body []byte) (bool, error) {
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Network errors are usually transient.
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf(errFmtStatus, resp.StatusCode, bytes.TrimSpace(msg))
+
+	// Only retry server errors, the request itself is not the problem.
+	return resp.StatusCode >= http.StatusInternalServerError, err
+}
+
+// gzipBuffer holds a gzip-compressed request body. Buffers and their
+// compressors are reused through gzipPool.
+type gzipBuffer struct {
+	bytes.Buffer
+	w *gzip.Writer
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		var b gzipBuffer
+		b.w = gzip.NewWriter(&b.Buffer)
+		return &b
+	},
+}
+
+// compress replaces the buffer's contents with p, gzip-compressed.
+func (b *gzipBuffer) compress(p []byte) error {
+
+	b.Reset()
+	b.w.Reset(&b.Buffer)
+
+	if _, err := b.w.Write(p); err != nil {
+		return err
+	}
+
+	return b.w.Close()
+}
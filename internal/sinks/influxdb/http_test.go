@@ -0,0 +1,120 @@
+package influxdb
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+func testBatch(t *testing.T) *batch {
+
+	enc := getEncoder()
+	defer putEncoder(enc)
+
+	enc.setString("proto", "tcp")
+	require.NoError(t, enc.begin("ct_acct"))
+	enc.intField("bytes_orig", 1)
+	require.NoError(t, enc.end(1))
+
+	b := getBatch()
+	b.buf = append(b.buf, enc.line...)
+	b.points++
+
+	return b
+}
+
+func TestHTTPClientRetry(t *testing.T) {
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "conntracct", r.URL.Query().Get("db"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, "ct_acct,proto=tcp bytes_orig=1i 1\n", string(b))
+
+		// Fail the first attempt with a server error.
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := newHTTPClient(types.SinkConfig{
+		Address:      srv.URL,
+		Database:     "conntracct",
+		Gzip:         true,
+		RetryBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, c.Write(testBatch(t)))
+	assert.Equal(t, 2, calls)
+}
+
+func TestHTTPClientNoRetry(t *testing.T) {
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c, err := newHTTPClient(types.SinkConfig{
+		Address:      srv.URL,
+		Database:     "conntracct",
+		RetryBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// Client errors are returned immediately.
+	assert.EqualError(t, c.Write(testBatch(t)), "unexpected response status 400: bad request")
+	assert.Equal(t, 1, calls)
+
+	_, err = newHTTPClient(types.SinkConfig{Address: srv.URL})
+	assert.EqualError(t, err, errEmptyDatabase.Error())
+}
+
+func TestHTTPClientV2(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, "my-org", r.URL.Query().Get("org"))
+		assert.Equal(t, "conntracct", r.URL.Query().Get("bucket"))
+		assert.Equal(t, "ns", r.URL.Query().Get("precision"))
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := newHTTPClientV2(types.SinkConfig{
+		Address: srv.URL,
+		Org:     "my-org",
+		Bucket:  "conntracct",
+		Token:   "secret",
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, c.Write(testBatch(t)))
+
+	_, err = newHTTPClientV2(types.SinkConfig{Address: srv.URL, Org: "my-org"})
+	assert.EqualError(t, err, errEmptyBucket.Error())
+}
@@ -1,11 +1,12 @@
 package influxdb
 
 import (
-	"strconv"
-	"sync"
+	"context"
+	"fmt"
 	"time"
 
-	influx "github.com/influxdata/influxdb/client/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/ti-mo/conntracct/internal/sinks/helpers"
 	"github.com/ti-mo/conntracct/internal/sinks/types"
@@ -14,9 +15,24 @@ import (
 )
 
 const (
-	defaultBatchSize = 128
+	defaultBatchSize     = 128
+	defaultFlushInterval = time.Second
+	defaultWorkers       = 1
+	defaultMeasurement   = "ct_acct"
+
+	// Prefix of enricher tags clashing with the sink's own tags.
+	enricherTagPrefix = "tag_"
 )
 
+// coreTags holds the tags set by the sink from the fields of an event.
+// Enricher tags with the same keys are prefixed, so they can't replace them.
+var coreTags = map[string]bool{
+	"conn_id": true, "src_addr": true, "dst_addr": true, "src_port": true,
+	"dst_port": true, "proto": true, "connmark": true, "netns": true,
+	"zone": true, "event_type": true, "tcp_state": true, "icmp_type": true,
+	"icmp_code": true, "family": true,
+}
+
 // InfluxSink is an accounting sink implementing an InfluxDB client.
 type InfluxSink struct {
 
@@ -26,21 +42,40 @@ type InfluxSink struct {
 	// Sink's configuration object.
 	config types.SinkConfig
 
-	// Boot time of the machine. (estimated)
-	bootTime time.Time
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
 
-	// Influx driver client handle.
-	client influx.Client
+	// Tags written to points if include is set, otherwise the tags that
+	// are not written to points. Nil if all tags are written.
+	tagFilter map[string]bool
+	include   bool
 
-	// Channel the network workers receive influx batches on.
-	sendChan chan influx.BatchPoints
+	// Client handle writing batches to the database.
+	client writer
 
-	// Data point batch.
-	batchMu sync.Mutex
-	batch   influx.BatchPoints
+	// Maximum length of an encoded point, zero if unlimited.
+	maxLine int
+
+	// Data point batch, handed to the send workers.
+	batch *types.Batcher[*batch]
 
 	// Sink stats.
 	stats types.SinkStats
+
+	// Receives the events of batches that failed to be written.
+	deadLetter types.DeadLetter
+}
+
+// writer writes batches of line protocol to InfluxDB. It is implemented
+// by udpClient and httpClient.
+type writer interface {
+	Write(*batch) error
+	Close() error
+}
+
+// pinger is implemented by writers that can check if InfluxDB is reachable.
+type pinger interface {
+	Ping(ctx context.Context) error
 }
 
 // New returns a new InfluxDB accounting sink.
@@ -61,51 +96,80 @@ func (s *InfluxSink) Init(sc types.SinkConfig) error {
 	if sc.BatchSize == 0 {
 		sc.BatchSize = defaultBatchSize
 	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Workers == 0 {
+		sc.Workers = defaultWorkers
+	}
+	if sc.Measurement == "" {
+		sc.Measurement = defaultMeasurement
+	}
 
-	var c influx.Client
-	var err error
+	b, err := types.NewBatcher(sc, &s.stats, &s.deadLetter, newBatch, s.send)
+	if err != nil {
+		return err
+	}
+	s.batch = b
+
+	if len(sc.IncludeTags) != 0 && len(sc.ExcludeTags) != 0 {
+		return errTagLists
+	}
+	s.include = len(sc.IncludeTags) != 0
+	for _, t := range append(sc.IncludeTags, sc.ExcludeTags...) {
+		if s.tagFilter == nil {
+			s.tagFilter = make(map[string]bool)
+		}
+		s.tagFilter[t] = true
+	}
+
+	var c writer
 
 	switch sc.Type {
 	case types.InfluxUDP:
-		// Construct InfluxDB UDP configuration and client.
-		conf := influx.UDPConfig{
-			Addr:        sc.Address,
-			PayloadSize: int(sc.UDPPayloadSize),
+		if sc.UDPPayloadSize == 0 {
+			sc.UDPPayloadSize = defaultUDPPayloadSize
+		}
+		if sc.UDPPayloadSize > maxUDPPayloadSize {
+			return fmt.Errorf(errFmtPayloadSize, sc.UDPPayloadSize, maxUDPPayloadSize)
 		}
 
-		c, err = influx.NewUDPClient(conf)
+		// Construct a UDP client splitting batches into datagrams.
+		uc, err := newUDPClient(sc.Address, int(sc.UDPPayloadSize))
 		if err != nil {
 			return err
 		}
+		c = uc
+
+		// Points are never split across datagrams.
+		s.maxLine = int(sc.UDPPayloadSize)
 	case types.InfluxHTTP:
-		// Construct InfluxDB HTTP configuration and client.
-		conf := influx.HTTPConfig{
-			Addr:     sc.Address,
-			Username: sc.Username,
-			Password: sc.Password,
-			Timeout:  sc.Timeout,
+		// Construct an HTTP client with support for compression and retries.
+		hc, err := newHTTPClient(sc)
+		if err != nil {
+			return err
 		}
-
-		c, err = influx.NewHTTPClient(conf)
+		hc.stats = &s.stats
+		c = hc
+	case types.InfluxV2:
+		// Construct an HTTP client speaking the InfluxDB 2.x write API.
+		hc, err := newHTTPClientV2(sc)
 		if err != nil {
 			return err
 		}
+		hc.stats = &s.stats
+		c = hc
 	default:
 		return errInvalidSinkType
 	}
 
-	// Estimate the machine's boot time, for absolute event timestamps.
-	s.bootTime = boottime.Estimate()
-
-	// Make a buffered channel for sendworkers.
-	s.sendChan = make(chan influx.BatchPoints, 64)
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
 
-	s.newBatch()  // initial empty batch
-	s.client = c  // client handle
 	s.config = sc // config
+	s.client = c  // client handle
 
-	go s.sendWorker()
-	go s.tickWorker()
+	s.batch.Start()
 
 	// Mark the sink as initialized.
 	s.init = true
@@ -114,62 +178,210 @@ func (s *InfluxSink) Init(sc types.SinkConfig) error {
 }
 
 // Push an accounting event into the buffer of the InfluxDB accounting sink.
-// Adds data points to the InfluxDB client buffer in a thread-safe manner.
-func (s *InfluxSink) Push(e bpf.Event) {
+func (s *InfluxSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
 
-	// Create a point and add to batch.
-	tags := map[string]string{
-		"conn_id":  strconv.FormatUint(uint64(e.ConnectionID), 10),
-		"src_addr": e.SrcAddr.String(),
-		"dst_addr": e.DstAddr.String(),
-		"dst_port": strconv.FormatUint(uint64(e.DstPort), 10),
-		"proto":    helpers.ProtoIntStr(e.Proto),
-		"connmark": strconv.FormatUint(uint64(e.Connmark), 16),
-		"netns":    strconv.FormatUint(uint64(e.NetNS), 10),
+// PushBatch pushes a batch of accounting events into the buffer of the
+// InfluxDB accounting sink. Events are encoded to line protocol using pooled
+// scratch space and appended to the current batch in a thread-safe manner.
+func (s *InfluxSink) PushBatch(es []bpf.Event) (int, error) {
+
+	enc := getEncoder()
+	defer putEncoder(enc)
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	if s.batch.Closed() {
+		s.stats.AddEventsDropped(len(es))
+		return len(es), errSinkClosed
 	}
 
+	var (
+		dropped int
+		first   error
+	)
+
+	for i := range es {
+		e := &es[i]
+
+		// Drop events that can't be represented as a point, eg. due to invalid
+		// tag values from enrichers, instead of failing the whole sink.
+		enc.reset()
+		if err := s.encode(enc, e); err != nil {
+			s.stats.IncrEventErrors()
+			log.Errorf("InfluxDB sink '%s': Error creating point: %s. Event dropped.", s.config.Name, err)
+			if first == nil {
+				first = errors.Wrap(err, "creating point")
+			}
+			dropped++
+			continue
+		}
+
+		// Add the point to the batch.
+		b := *s.batch.Batch()
+		b.buf = append(b.buf, enc.line...)
+		b.points++
+		s.batch.Added(*e)
+	}
+
+	return dropped, first
+}
+
+// encode encodes e to a line of line protocol in enc. Returns an error
+// if the line exceeds the sink's maximum line length.
+func (s *InfluxSink) encode(enc *encoder, e *bpf.Event) error {
+
+	enc.setUint("conn_id", uint64(e.ConnectionID), 10)
+	enc.setIP("src_addr", e.SrcAddr)
+	enc.setIP("dst_addr", e.DstAddr)
+	enc.setUint("dst_port", uint64(e.DstPort), 10)
+	enc.setString("proto", helpers.ProtoIntStr(e.Proto))
+	enc.setUint("connmark", uint64(e.Connmark), 16)
+	enc.setUint("netns", uint64(e.NetNS), 10)
+	enc.setUint("zone", uint64(e.Zone), 10)
+
+	// Query only destroy events when summing flow totals,
+	// update events carry the same cumulative counters.
+	enc.setString("event_type", e.Type())
+
 	// Optionally set flows' source ports (since they're random in most cases)
 	if s.config.EnableSrcPort {
-		tags["src_port"] = strconv.FormatUint(uint64(e.SrcPort), 10)
+		enc.setUint("src_port", uint64(e.SrcPort), 10)
+	}
+
+	// Tag TCP flows with their connection state.
+	if e.Proto == 6 {
+		enc.setString("tcp_state", helpers.TCPStateStr(e.TCPState))
+	}
+
+	// Tag ICMP flows with the type and code of their request.
+	if e.ICMP() {
+		enc.setUint("icmp_type", uint64(e.ICMPType), 10)
+		enc.setUint("icmp_code", uint64(e.ICMPCode), 10)
+	}
+
+	// Add metadata attached by enrichers, without replacing the event's own
+	// tags, even if they're disabled.
+	for k, v := range e.Tags {
+		if coreTags[k] {
+			k = enricherTagPrefix + k
+		}
+		enc.setString(k, v)
+	}
+
+	// Optionally tag flows with their address family, for per-family breakdowns.
+	if s.config.EnableFamily {
+		enc.setString("family", helpers.FamilyStr(e.Family))
+	}
+
+	// Leave out tags that blow up series cardinality.
+	if s.tagFilter != nil {
+		enc.filter(s.tagFilter, s.include)
+	}
+
+	for k, v := range s.config.ExtraTags {
+		enc.setString(k, v)
+	}
+
+	if err := enc.begin(s.config.Measurement); err != nil {
+		return err
 	}
 
 	// https://github.com/influxdata/influxdb/issues/7801
-	// The InfluxDB wire protocol and Go client supports uints and will mark them as such,
-	// though the current version (1.6) has this behind a build flag as it's not yet
-	// generally available. Only send signed ints for now until this is more widely deployed.
-	fields := map[string]interface{}{
-		"bytes_orig":   int64(e.BytesOrig),
-		"bytes_ret":    int64(e.BytesRet),
-		"packets_orig": int64(e.PacketsOrig),
-		"packets_ret":  int64(e.PacketsRet),
+	// The InfluxDB wire protocol supports uints, though the current version (1.6)
+	// has this behind a build flag as it's not yet generally available.
+	// Only send signed ints for now until this is more widely deployed.
+	// Fields are written in lexical order, like the InfluxDB client does.
+	enc.intField("bytes_orig", int64(e.BytesOrig))
+
+	// Throughput since the flow's previous event, if computed by the pipeline.
+	rate := e.Rate.Interval != 0
+	if rate {
+		if err := enc.floatField("bytes_orig_rate", e.Rate.BytesOrig); err != nil {
+			return err
+		}
 	}
 
-	// To obtain the absolute time stamp of an event in kernel space,
-	// we add its (monotonic) time stamp to the estimated boot time of the kernel.
-	ts := s.bootTime.Add(time.Duration(e.Timestamp))
+	enc.intField("bytes_ret", int64(e.BytesRet))
 
-	pt, err := influx.NewPoint("ct_acct", tags, fields, ts)
-	if err != nil {
-		panic(err.Error())
+	if rate {
+		if err := enc.floatField("bytes_ret_rate", e.Rate.BytesRet); err != nil {
+			return err
+		}
+	}
+
+	// Lifetime of the flow in nanoseconds, only known on destroy events.
+	if e.Duration != 0 {
+		enc.intField("duration", int64(e.Duration))
+	}
+
+	enc.intField("packets_orig", int64(e.PacketsOrig))
+
+	if rate {
+		if err := enc.floatField("packets_orig_rate", e.Rate.PacketsOrig); err != nil {
+			return err
+		}
+	}
+
+	enc.intField("packets_ret", int64(e.PacketsRet))
+
+	if rate {
+		if err := enc.floatField("packets_ret_rate", e.Rate.PacketsRet); err != nil {
+			return err
+		}
 	}
 
-	// Add the point to the batch.
-	s.batchMu.Lock()
-	s.batch.AddPoint(pt)
+	// 1 in sample_rate flows are reported, counters are not scaled.
+	if e.SampleRate != 0 {
+		enc.intField("sample_rate", int64(e.SampleRate))
+	}
 
-	batchLen := len(s.batch.Points())
+	// To obtain the absolute time stamp of an event in kernel space, we add
+	// its (monotonic) time stamp to the estimated boot time of the kernel,
+	// unless the kernel recorded its wall clock time.
+	ts := e.Time(s.clock.Boot())
 
-	// Record statistics.
-	s.stats.SetBatchLength(batchLen)
-	s.stats.IncrEventsPushed()
+	if err := enc.end(ts.UnixNano()); err != nil {
+		return err
+	}
 
-	// Flush the batch when the watermark is reached.
-	if batchLen >= int(s.config.BatchSize) {
-		s.sendChan <- s.batch
-		s.newBatch()
+	if s.maxLine != 0 && len(enc.line) > s.maxLine {
+		return fmt.Errorf(errFmtLineSize, len(enc.line), s.maxLine)
 	}
 
-	s.batchMu.Unlock()
+	return nil
+}
+
+// Flush sends the sink's current batch to the send workers and waits until
+// it and all batches queued before it are written, or until ctx is done.
+// Returns the error of writing the current batch.
+func (s *InfluxSink) Flush(ctx context.Context) error {
+	return s.batch.Flush(ctx)
+}
+
+// Close waits for the sink's current and all pending batches to be written
+// before closing the client. Must only be called once.
+func (s *InfluxSink) Close() error {
+	s.batch.Close()
+	return s.client.Close()
+}
+
+// SetDeadLetter sets the function receiving the events of batches
+// the sink failed to write.
+func (s *InfluxSink) SetDeadLetter(f types.DeadLetterFunc) {
+	s.deadLetter.Set(f)
+}
+
+// CheckHealth pings InfluxDB, only for HTTP-based sinks. UDP writes
+// can't be checked.
+func (s *InfluxSink) CheckHealth(ctx context.Context) error {
+	if p, ok := s.client.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
 }
 
 // Name gets the name of the InfluxDB accounting sink.
@@ -197,16 +409,7 @@ func (s *InfluxSink) Stats() types.SinkStatsData {
 	return s.stats.Get()
 }
 
-// newBatch writes a new InfluxDB client batch to the sink.
-func (s *InfluxSink) newBatch() {
-
-	b, err := influx.NewBatchPoints(influx.BatchPointsConfig{
-		Precision: "ns", // nanosecond precision timestamps
-	})
-
-	if err != nil {
-		panic(err)
-	}
-
-	s.batch = b
+// newBatch takes a new, empty batch from the pool.
+func newBatch(int) *batch {
+	return getBatch()
 }
@@ -0,0 +1,195 @@
+package influxdb
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestInfluxSinkClose(t *testing.T) {
+
+	var lines int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines += strings.Count(string(b), "\n")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:      "test",
+		Type:      types.InfluxHTTP,
+		Address:   srv.URL,
+		Database:  "conntracct",
+		BatchSize: 100,
+	}))
+
+	e := bpf.Event{SrcAddr: net.ParseIP("192.0.2.1"), DstAddr: net.ParseIP("192.0.2.2")}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, s.Push(e))
+	}
+
+	// Flush writes the partial batch before returning.
+	require.NoError(t, s.Flush(context.Background()))
+	assert.Equal(t, 2, lines)
+
+	// The partial batch is written when the sink is closed.
+	require.NoError(t, s.Push(e))
+	require.NoError(t, s.Close())
+	assert.Equal(t, 3, lines)
+	assert.EqualValues(t, 2, s.Stats().BatchesSent)
+
+	// Events pushed after closing are dropped.
+	assert.Equal(t, errSinkClosed, s.Push(e))
+	assert.EqualValues(t, 1, s.Stats().EventsDropped)
+}
+
+func TestInfluxSinkWorkers(t *testing.T) {
+
+	var lines, active, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&lines, int32(strings.Count(string(b), "\n")))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:      "test",
+		Type:      types.InfluxHTTP,
+		Address:   srv.URL,
+		Database:  "conntracct",
+		BatchSize: 1,
+		Workers:   4,
+	}))
+	defer s.Close()
+
+	e := bpf.Event{SrcAddr: net.ParseIP("192.0.2.1"), DstAddr: net.ParseIP("192.0.2.2")}
+	for i := 0; i < 8; i++ {
+		require.NoError(t, s.Push(e))
+	}
+
+	// Flush waits for the batches being written by all workers.
+	require.NoError(t, s.Flush(context.Background()))
+	assert.EqualValues(t, 8, atomic.LoadInt32(&lines))
+	assert.Greater(t, atomic.LoadInt32(&peak), int32(1))
+}
+
+func TestInfluxSinkPointError(t *testing.T) {
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:    "test",
+		Type:    types.InfluxUDP,
+		Address: "localhost:8089",
+	}))
+	defer s.Close()
+
+	// A tag exceeding the line protocol's maximum key length.
+	e := bpf.Event{SrcAddr: net.ParseIP("192.0.2.1"), DstAddr: net.ParseIP("192.0.2.2")}
+	e.SetTag("label", strings.Repeat("a", 1<<16))
+
+	assert.Error(t, s.Push(e))
+
+	st := s.Stats()
+	assert.EqualValues(t, 1, st.EventErrors)
+	assert.EqualValues(t, 1, st.EventsDropped)
+	assert.EqualValues(t, 0, st.EventsPushed)
+
+	// Points are not split across datagrams, longer points are rejected.
+	s2 := New()
+	require.NoError(t, s2.Init(types.SinkConfig{
+		Name:           "test",
+		Type:           types.InfluxUDP,
+		Address:        "localhost:8089",
+		UDPPayloadSize: 64,
+	}))
+	defer s2.Close()
+
+	e.Tags = nil
+	assert.Error(t, s2.Push(e))
+	assert.EqualValues(t, 1, s2.Stats().EventErrors)
+
+	s3 := New()
+	assert.EqualError(t, s3.Init(types.SinkConfig{
+		Name:           "test",
+		Type:           types.InfluxUDP,
+		Address:        "localhost:8089",
+		UDPPayloadSize: 65535,
+	}), "udp payload size 65535 exceeds the maximum of 65507")
+}
+
+func TestInfluxSinkTags(t *testing.T) {
+
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sc := types.SinkConfig{
+		Name:        "test",
+		Type:        types.InfluxHTTP,
+		Address:     srv.URL,
+		Database:    "conntracct",
+		Measurement: "flows",
+		IncludeTags: []string{"dst_port", "proto", "pod"},
+		ExtraTags:   map[string]string{"site": "ams1"},
+	}
+
+	s := New()
+	require.NoError(t, s.Init(sc))
+
+	e := bpf.Event{SrcAddr: net.ParseIP("192.0.2.1"), DstAddr: net.ParseIP("192.0.2.2"), DstPort: 443, Proto: 6}
+	e.SetTag("pod", "web")
+	e.SetTag("node", "n1")
+	require.NoError(t, s.Push(e))
+	require.NoError(t, s.Close())
+
+	assert.True(t, strings.HasPrefix(body, "flows,dst_port=443,pod=web,proto=tcp,site=ams1 "), body)
+
+	// Excluded tags are left out, the others are written.
+	sc.IncludeTags, sc.ExcludeTags = nil, []string{"conn_id", "src_addr", "node"}
+	s2 := New()
+	require.NoError(t, s2.Init(sc))
+	require.NoError(t, s2.Push(e))
+	require.NoError(t, s2.Close())
+
+	assert.NotContains(t, body, "conn_id=")
+	assert.NotContains(t, body, "src_addr=")
+	assert.NotContains(t, body, "node=")
+	assert.Contains(t, body, "dst_addr=192.0.2.2")
+
+	sc.IncludeTags = []string{"proto"}
+	s3 := New()
+	assert.Equal(t, errTagLists, s3.Init(sc))
+}
@@ -0,0 +1,246 @@
+package influxdb
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Maximum length of a series key (measurement, tags and field name)
+// accepted by InfluxDB.
+const maxKeyLength = 65535
+
+// Characters escaped in measurements and in tag keys, tag values and
+// field keys.
+const (
+	escMeasurement = ", "
+	escKey         = ",= "
+)
+
+// batch is a batch of points encoded to line protocol, one point per line.
+// Batches are reused through batchPool once they're written.
+type batch struct {
+	buf    []byte
+	points int
+}
+
+var batchPool = sync.Pool{
+	New: func() interface{} { return &batch{} },
+}
+
+// getBatch returns an empty batch from the pool.
+func getBatch() *batch {
+	b := batchPool.Get().(*batch)
+	b.buf = b.buf[:0]
+	b.points = 0
+	return b
+}
+
+// putBatch returns a batch to the pool. The batch must not be used afterwards.
+func putBatch(b *batch) {
+	batchPool.Put(b)
+}
+
+// tag is a key/value pair written to a point. Its value is either val,
+// or vals[off:end] of the encoder holding the tag if end is non-zero.
+type tag struct {
+	key      string
+	val      string
+	off, end int
+}
+
+// encoder is the scratch space used to encode an event to a line of line
+// protocol. Encoders are reused through encoderPool.
+type encoder struct {
+	tags []tag
+
+	// Backing storage for formatted tag values.
+	vals []byte
+
+	// The encoded line.
+	line []byte
+
+	// Length of the series key, the measurement and tags,
+	// and of the longest field name in the line.
+	key      int
+	maxField int
+}
+
+var encoderPool = sync.Pool{
+	New: func() interface{} { return &encoder{} },
+}
+
+// getEncoder returns an empty encoder from the pool.
+func getEncoder() *encoder {
+	enc := encoderPool.Get().(*encoder)
+	enc.reset()
+	return enc
+}
+
+// reset empties the encoder for encoding the next event.
+func (enc *encoder) reset() {
+	enc.tags = enc.tags[:0]
+	enc.vals = enc.vals[:0]
+	enc.line = enc.line[:0]
+	enc.key, enc.maxField = 0, 0
+}
+
+// putEncoder returns an encoder to the pool.
+func putEncoder(enc *encoder) {
+	encoderPool.Put(enc)
+}
+
+// set sets the tag with t's key to t, replacing an earlier value.
+func (enc *encoder) set(t tag) {
+	for i := range enc.tags {
+		if enc.tags[i].key == t.key {
+			enc.tags[i] = t
+			return
+		}
+	}
+	enc.tags = append(enc.tags, t)
+}
+
+// setString sets tag k to v.
+func (enc *encoder) setString(k, v string) {
+	enc.set(tag{key: k, val: v})
+}
+
+// setUint sets tag k to v formatted in the given base.
+func (enc *encoder) setUint(k string, v uint64, base int) {
+	off := len(enc.vals)
+	enc.vals = strconv.AppendUint(enc.vals, v, base)
+	enc.set(tag{key: k, off: off, end: len(enc.vals)})
+}
+
+// setIP sets tag k to the textual representation of ip, as
+// returned by net.IP's String method.
+func (enc *encoder) setIP(k string, ip net.IP) {
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		enc.setString(k, ip.String())
+		return
+	}
+
+	off := len(enc.vals)
+	enc.vals = a.Unmap().AppendTo(enc.vals)
+	enc.set(tag{key: k, off: off, end: len(enc.vals)})
+}
+
+// filter keeps the tags in m if include is set, otherwise
+// it removes them.
+func (enc *encoder) filter(m map[string]bool, include bool) {
+	tags := enc.tags[:0]
+	for _, t := range enc.tags {
+		if m[t.key] == include {
+			tags = append(tags, t)
+		}
+	}
+	enc.tags = tags
+}
+
+// begin starts the line with the measurement and the encoder's tags,
+// sorted by key. Tags with empty values are left out.
+func (enc *encoder) begin(measurement string) error {
+
+	slices.SortFunc(enc.tags, func(a, b tag) int {
+		return strings.Compare(a.key, b.key)
+	})
+
+	enc.line = appendEscaped(enc.line, measurement, escMeasurement)
+
+	for _, t := range enc.tags {
+		if t.key == "" {
+			return errEmptyTagKey
+		}
+
+		if t.end != 0 {
+			enc.line = append(enc.line, ',')
+			enc.line = appendEscaped(enc.line, t.key, escKey)
+			enc.line = append(enc.line, '=')
+			enc.line = appendEscaped(enc.line, enc.vals[t.off:t.end], escKey)
+			continue
+		}
+
+		if t.val == "" {
+			continue
+		}
+
+		enc.line = append(enc.line, ',')
+		enc.line = appendEscaped(enc.line, t.key, escKey)
+		enc.line = append(enc.line, '=')
+		enc.line = appendEscaped(enc.line, t.val, escKey)
+	}
+
+	enc.key = len(enc.line)
+
+	return nil
+}
+
+// field starts a field with key k, preceded by the separator
+// of the line's field set.
+func (enc *encoder) field(k string) {
+	if enc.maxField == 0 {
+		enc.line = append(enc.line, ' ')
+	} else {
+		enc.line = append(enc.line, ',')
+	}
+
+	enc.line = appendEscaped(enc.line, k, escKey)
+	enc.line = append(enc.line, '=')
+
+	if len(k) > enc.maxField {
+		enc.maxField = len(k)
+	}
+}
+
+// intField appends an integer field to the line.
+func (enc *encoder) intField(k string, v int64) {
+	enc.field(k)
+	enc.line = strconv.AppendInt(enc.line, v, 10)
+	enc.line = append(enc.line, 'i')
+}
+
+// floatField appends a float field to the line. InfluxDB can't
+// represent NaN and infinite values.
+func (enc *encoder) floatField(k string, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return errInvalidFloat
+	}
+
+	enc.field(k)
+	enc.line = strconv.AppendFloat(enc.line, v, 'f', -1, 64)
+
+	return nil
+}
+
+// end terminates the line with a timestamp in nanoseconds.
+func (enc *encoder) end(ts int64) error {
+
+	// InfluxDB joins the series key to a field name with a 4-byte separator.
+	if enc.key+4+enc.maxField > maxKeyLength {
+		return errMaxKeyLength
+	}
+
+	enc.line = append(enc.line, ' ')
+	enc.line = strconv.AppendInt(enc.line, ts, 10)
+	enc.line = append(enc.line, '\n')
+
+	return nil
+}
+
+// appendEscaped appends s to dst, escaping the characters in esc
+// with a backslash.
+func appendEscaped[T string | []byte](dst []byte, s T, esc string) []byte {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(esc, s[i]) >= 0 {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, s[i])
+	}
+	return dst
+}
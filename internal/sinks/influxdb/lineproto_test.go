@@ -0,0 +1,104 @@
+package influxdb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestInfluxSinkEncode(t *testing.T) {
+
+	s := InfluxSink{
+		config: types.SinkConfig{
+			Measurement: "ct acct",
+			ExtraTags:   map[string]string{"site": "ams 1"},
+		},
+		clock: boottime.FixedClock(time.Unix(0, 0)),
+	}
+
+	e := bpf.Event{
+		Timestamp: 5,
+		SrcAddr:   net.ParseIP("192.0.2.1"),
+		DstAddr:   net.ParseIP("2001:db8::1"),
+		DstPort:   53,
+		Proto:     17,
+		Connmark:  255,
+		BytesOrig: 10,
+		Rate:      bpf.Rate{Interval: time.Second, BytesOrig: 1.5},
+	}
+	e.SetTag("pod", "a,b=c")
+	e.SetTag("empty", "")
+
+	enc := getEncoder()
+	defer putEncoder(enc)
+
+	require.NoError(t, s.encode(enc, &e))
+	assert.Equal(t, "ct\\ acct,conn_id=0,connmark=ff,dst_addr=2001:db8::1,dst_port=53,event_type=update,"+
+		"netns=0,pod=a\\,b\\=c,proto=udp,site=ams\\ 1,src_addr=192.0.2.1,zone=0 "+
+		"bytes_orig=10i,bytes_orig_rate=1.5,bytes_ret=0i,bytes_ret_rate=0,packets_orig=0i,"+
+		"packets_orig_rate=0,packets_ret=0i,packets_ret_rate=0 5\n", string(enc.line))
+}
+
+func TestInfluxSinkEncodeTagClash(t *testing.T) {
+
+	s := InfluxSink{
+		config: types.SinkConfig{Measurement: "ct_acct"},
+		clock:  boottime.FixedClock(time.Unix(0, 0)),
+	}
+
+	e := bpf.Event{
+		SrcAddr: net.ParseIP("192.0.2.1"),
+		DstAddr: net.ParseIP("192.0.2.2"),
+		SrcPort: 1234,
+		DstPort: 443,
+		Proto:   6,
+	}
+
+	// Enricher tags don't replace the sink's own tags, also not the
+	// ones left out by the sink's configuration.
+	e.SetTag("proto", "https")
+	e.SetTag("src_addr", "web")
+	e.SetTag("src_port", "0")
+	e.SetTag("pod", "web-1")
+
+	enc := getEncoder()
+	defer putEncoder(enc)
+
+	require.NoError(t, s.encode(enc, &e))
+	assert.Equal(t, "ct_acct,conn_id=0,connmark=0,dst_addr=192.0.2.2,dst_port=443,event_type=update,"+
+		"netns=0,pod=web-1,proto=tcp,src_addr=192.0.2.1,tag_proto=https,tag_src_addr=web,tag_src_port=0,"+
+		"tcp_state=none,zone=0 bytes_orig=0i,bytes_ret=0i,packets_orig=0i,packets_ret=0i 0\n", string(enc.line))
+}
+
+func TestUDPClientSplit(t *testing.T) {
+
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer l.Close()
+
+	c, err := newUDPClient(l.LocalAddr().String(), 8)
+	require.NoError(t, err)
+	defer c.Close()
+
+	b := getBatch()
+	b.buf = append(b.buf, "a 1\nb 2\nlong line\nc 3\n"...)
+	require.NoError(t, c.Write(b))
+
+	// Datagrams hold whole lines, a line longer than the payload is sent alone.
+	buf := make([]byte, 64)
+	var got []string
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := l.Read(buf)
+		require.NoError(t, err)
+		got = append(got, string(buf[:n]))
+	}
+	assert.Equal(t, []string{"a 1\nb 2\n", "long line\n", "c 3\n"}, got)
+}
@@ -0,0 +1,71 @@
+package influxdb
+
+import (
+	"bytes"
+	"net"
+)
+
+const (
+	// Default maximum size of a UDP datagram written to InfluxDB, in bytes.
+	defaultUDPPayloadSize = 512
+
+	// Largest UDP payload that fits in an IPv4 packet.
+	maxUDPPayloadSize = 65507
+)
+
+// udpClient writes batches to InfluxDB's UDP listener. Batches are split
+// into datagrams of at most payloadSize bytes on line boundaries, InfluxDB
+// discards datagrams holding partial lines. UDP writes are not acknowledged,
+// so failures are only detected locally.
+type udpClient struct {
+	conn        *net.UDPConn
+	payloadSize int
+}
+
+// newUDPClient returns a udpClient writing to addr.
+func newUDPClient(addr string, payloadSize int) (*udpClient, error) {
+
+	ua, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, ua)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpClient{conn: conn, payloadSize: payloadSize}, nil
+}
+
+// Write writes the batch in one or more datagrams. The sink rejects
+// points longer than the payload size, should one slip through anyway
+// it's written in a datagram of its own.
+func (c *udpClient) Write(b *batch) error {
+
+	buf := b.buf
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > c.payloadSize {
+			n = bytes.LastIndexByte(buf[:c.payloadSize], '\n') + 1
+			if n == 0 {
+				n = len(buf)
+				if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+					n = i + 1
+				}
+			}
+		}
+
+		if _, err := c.conn.Write(buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+
+	return nil
+}
+
+// Close closes the client's socket.
+func (c *udpClient) Close() error {
+	return c.conn.Close()
+}
@@ -4,46 +4,32 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
-)
-
-// sendWorker receives batches from the sink's send channel
-// and uses the InfluxDB client to send it to the database.
-func (s *InfluxSink) sendWorker() {
 
-	for {
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
 
-		b := <-s.sendChan
+// send uses the InfluxDB client to write a batch to the database.
+// The events of a batch that fails to be written are handed to the sink's
+// dead letter function. The batch is returned to the pool afterwards.
+func (s *InfluxSink) send(b *batch, events []bpf.Event) error {
 
-		// Write the batch
-		if err := s.client.Write(b); err != nil {
-			log.Errorf("InfluxDB sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
+	defer putBatch(b)
 
-			// Increase dropped batch counter
-			s.stats.IncrBatchDropped()
-			continue
-		}
+	// Write the batch
+	start := time.Now()
+	err := s.client.Write(b)
+	s.stats.ObserveFlush(time.Since(start), b.points, err)
+	if err != nil {
+		log.Errorf("InfluxDB sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
 
-		// Increase sent batch counter
-		s.stats.IncrBatchSent()
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		s.deadLetter.Send(s.config.Name, events)
+		return err
 	}
-}
-
-// tickWorker starts a ticker that periodically flushes the active batch.
-// If the batch is empty when the ticker fires, no action is taken.
-func (s *InfluxSink) tickWorker() {
 
-	t := time.NewTicker(time.Second)
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
 
-	for {
-		<-t.C
-
-		s.batchMu.Lock()
-
-		if len(s.batch.Points()) != 0 {
-			s.sendChan <- s.batch
-			s.newBatch()
-		}
-
-		s.batchMu.Unlock()
-	}
+	return nil
 }
@@ -0,0 +1,10 @@
+package ipfix
+
+import "errors"
+
+var (
+	errEmptySinkName    = errors.New("empty sink name")
+	errEmptySinkAddress = errors.New("empty sink address")
+	errInvalidSinkType  = errors.New("invalid sink type")
+	errSinkClosed       = errors.New("sink is closed")
+)
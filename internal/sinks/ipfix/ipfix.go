@@ -0,0 +1,230 @@
+package ipfix
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/sinks/flowexport"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	// Maximum size of an IPFIX message, fits within a 1500-byte MTU.
+	defaultPayloadSize = 1400
+
+	// Interval at which templates are retransmitted to the collector.
+	defaultTemplateRefresh = 30 * time.Second
+
+	// Maximum time records are held in the pending message.
+	defaultFlushInterval = time.Second
+)
+
+// IPFIXSink is an accounting sink implementing an IPFIX exporting process
+// sending flow records over UDP.
+type IPFIXSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Connection to the collector.
+	conn net.Conn
+
+	// Channel the network worker receives IPFIX messages on.
+	sendChan chan sendReq
+
+	// Builds IPFIX messages from events, protected by mu.
+	mu       sync.Mutex
+	exporter *flowexport.Exporter
+
+	// Set when the sink is closed, protected by mu.
+	// Events pushed afterwards are dropped.
+	closed bool
+
+	// Closed by Close to stop the tick worker.
+	done chan struct{}
+
+	// Tracks the send worker, waited on by Close.
+	wg sync.WaitGroup
+
+	// Sink stats.
+	stats types.SinkStats
+}
+
+// sendReq is a message to be written by the send worker. If done is set,
+// the result of the write is sent on it.
+type sendReq struct {
+	msg  []byte
+	done chan error
+}
+
+// New returns a new IPFIX accounting sink.
+func New() IPFIXSink {
+	return IPFIXSink{}
+}
+
+// Init initializes the IPFIX accounting sink.
+func (s *IPFIXSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Address == "" {
+		return errEmptySinkAddress
+	}
+	if sc.Type != types.IPFIX {
+		return errInvalidSinkType
+	}
+	if sc.UDPPayloadSize == 0 {
+		sc.UDPPayloadSize = defaultPayloadSize
+	}
+	if sc.TemplateRefresh == 0 {
+		sc.TemplateRefresh = defaultTemplateRefresh
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+
+	// Make a buffered channel for sendworkers.
+	s.sendChan = make(chan sendReq, 64)
+	s.done = make(chan struct{})
+
+	x, err := flowexport.NewExporter(&protocol{domain: sc.DomainID}, int(sc.UDPPayloadSize),
+		sc.TemplateRefresh, func(b []byte) { s.sendChan <- sendReq{msg: b} }, timeFields...)
+	if err != nil {
+		return err
+	}
+
+	c, err := net.Dial("udp", sc.Address)
+	if err != nil {
+		return err
+	}
+
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
+
+	s.exporter = x // message builder
+	s.conn = c     // collector connection
+	s.config = sc  // config
+
+	s.wg.Add(1)
+	go s.sendWorker()
+	go s.tickWorker()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push an accounting event into the buffer of the IPFIX accounting sink.
+// An event yields up to two flow records, one for each direction of the
+// connection. Records are only emitted for directions that saw traffic since
+// the flow's previous event.
+func (s *IPFIXSink) Push(e bpf.Event) error {
+
+	// Absolute time stamp of the event, see the InfluxDB sink.
+	ts := e.Time(s.clock.Boot())
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
+	s.exporter.Push(e, ts)
+
+	// Record statistics.
+	s.stats.SetBatchLength(s.exporter.Pending())
+	s.stats.IncrEventsPushed()
+
+	s.mu.Unlock()
+
+	return nil
+}
+
+// PushBatch exports the flow records of a batch of accounting events.
+func (s *IPFIXSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// Flush sends the pending message to the send worker and waits until it
+// and all messages queued before it are written, or until ctx is done.
+// Write errors are only recorded in the sink's statistics.
+func (s *IPFIXSink) Flush(ctx context.Context) error {
+
+	done := make(chan error, 1)
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return errSinkClosed
+	}
+
+	s.exporter.Flush()
+	s.sendChan <- sendReq{done: done}
+
+	s.mu.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the pending message and waits for all pending messages
+// to be written before closing the collector connection. Must only be called once.
+func (s *IPFIXSink) Close() error {
+
+	close(s.done)
+
+	s.mu.Lock()
+	s.exporter.Flush()
+	s.closed = true
+	close(s.sendChan)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return s.conn.Close()
+}
+
+// Name gets the name of the IPFIX accounting sink.
+func (s *IPFIXSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the IPFIX accounting sink was successfully initialized.
+func (s *IPFIXSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *IPFIXSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, IPFIX receives destroy events. (flow totals)
+func (s *IPFIXSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the IPFIX accounting sink's statistics structure.
+func (s *IPFIXSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
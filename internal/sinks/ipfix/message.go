@@ -0,0 +1,73 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/sinks/flowexport"
+)
+
+// IPFIX protocol constants. (RFC 7011)
+const (
+	version = 10
+
+	// Length of the message header.
+	headerLen = 16
+
+	// Set ID of a template set.
+	templateSetID = 2
+)
+
+// Information Element identifiers of the flow time stamps. (IANA IPFIX registry)
+const (
+	ieFlowStartMilliseconds = 152
+	ieFlowEndMilliseconds   = 153
+)
+
+// timeFields holds the flow time stamps of a record as absolute
+// millisecond-precision Unix time stamps.
+var timeFields = []flowexport.Field{
+	{ID: ieFlowStartMilliseconds, Length: 8, Append: func(b []byte, r flowexport.Record) []byte {
+		return flowexport.AppendUint64(b, uint64(r.Start.UnixNano()/int64(time.Millisecond)))
+	}},
+	{ID: ieFlowEndMilliseconds, Length: 8, Append: func(b []byte, r flowexport.Record) []byte {
+		return flowexport.AppendUint64(b, uint64(r.End.UnixNano()/int64(time.Millisecond)))
+	}},
+}
+
+// protocol implements the IPFIX message framing.
+type protocol struct {
+	// Observation domain ID of the exporting process.
+	domain uint32
+
+	// Amount of data records exported so far.
+	seq uint32
+}
+
+// HeaderLen returns the length of an IPFIX message header.
+func (p *protocol) HeaderLen() int {
+	return headerLen
+}
+
+// TemplateSetID returns the set ID of an IPFIX template set.
+func (p *protocol) TemplateSetID() uint16 {
+	return templateSetID
+}
+
+// SetAlignment returns 0, IPFIX sets are not padded.
+func (p *protocol) SetAlignment() int {
+	return 0
+}
+
+// PutHeader writes an IPFIX message header into msg.
+func (p *protocol) PutHeader(msg []byte, records, templates int) {
+
+	binary.BigEndian.PutUint16(msg[0:2], version)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)))
+	binary.BigEndian.PutUint32(msg[4:8], uint32(time.Now().Unix()))
+	binary.BigEndian.PutUint32(msg[8:12], p.seq)
+	binary.BigEndian.PutUint32(msg[12:16], p.domain)
+
+	// Sequence number counts all data records exported before the message.
+	p.seq += uint32(records)
+}
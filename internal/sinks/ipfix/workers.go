@@ -0,0 +1,76 @@
+package ipfix
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sendWorker receives messages from the sink's send channel
+// and writes them to the collector. Exits when the send channel is closed.
+func (s *IPFIXSink) sendWorker() {
+
+	defer s.wg.Done()
+
+	for r := range s.sendChan {
+		err := s.send(r.msg)
+		if r.done != nil {
+			r.done <- err
+		}
+	}
+}
+
+// send writes a message to the collector. Empty messages are not written.
+func (s *IPFIXSink) send(b []byte) error {
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	// Write the message. Its flow records aren't counted as events.
+	start := time.Now()
+	_, err := s.conn.Write(b)
+	s.stats.ObserveFlush(time.Since(start), 0, err)
+	if err != nil {
+		log.Errorf("IPFIX sink '%s': Error writing message: %s. Message dropped.", s.config.Name, err)
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// tickWorker starts a ticker that periodically flushes the pending message,
+// retransmits templates when they're due and evicts stale flows.
+// Exits when the sink is closed.
+func (s *IPFIXSink) tickWorker() {
+
+	t := time.NewTicker(s.config.FlushInterval)
+	defer t.Stop()
+	e := time.NewTicker(time.Minute)
+	defer e.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.exporter.Flush()
+			}
+			s.mu.Unlock()
+		case <-e.C:
+			s.mu.Lock()
+			if !s.closed {
+				s.exporter.Evict()
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
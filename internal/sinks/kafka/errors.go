@@ -0,0 +1,17 @@
+package kafka
+
+import (
+	"errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+var (
+	errEmptySinkName    = errors.New("empty sink name")
+	errEmptySinkAddress = errors.New("empty sink address")
+	errEmptyTopic       = errors.New("empty topic")
+	errEncoding         = errors.New("encoding must be 'json' or 'protobuf'")
+	errEncodingAvro     = errors.New("avro encoding is not supported, use protobuf for schema-based consumers")
+	errInvalidSinkType  = errors.New("invalid sink type")
+	errSinkClosed       = types.ErrSinkClosed
+)
@@ -0,0 +1,287 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	kafka "github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+const (
+	defaultBatchSize     = 128
+	defaultFlushInterval = time.Second
+	defaultWorkers       = 1
+
+	// Content type of protobuf-encoded messages, naming their schema.
+	protoContentType = "application/x-protobuf; messageType=conntracct.v1.Event"
+)
+
+// KafkaSink is an accounting sink producing JSON- or protobuf-encoded events
+// to a Kafka topic.
+type KafkaSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Kafka producer handle.
+	writer *kafka.Writer
+
+	// Encodes events into message values, and the headers of every message.
+	encode  func(bpf.Event) ([]byte, error)
+	headers []kafka.Header
+
+	// Message batch, handed to the send workers.
+	batch *types.Batcher[[]kafka.Message]
+
+	// Sink stats.
+	stats types.SinkStats
+
+	// Receives the events of batches that failed to be written.
+	deadLetter types.DeadLetter
+}
+
+// New returns a new Kafka accounting sink.
+func New() KafkaSink {
+	return KafkaSink{}
+}
+
+// Init initializes the Kafka accounting sink.
+func (s *KafkaSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Address == "" {
+		return errEmptySinkAddress
+	}
+	if sc.Topic == "" {
+		return errEmptyTopic
+	}
+	if sc.Type != types.Kafka {
+		return errInvalidSinkType
+	}
+	if sc.BatchSize == 0 {
+		sc.BatchSize = defaultBatchSize
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Workers == 0 {
+		sc.Workers = defaultWorkers
+	}
+
+	b, err := types.NewBatcher(sc, &s.stats, &s.deadLetter, newBatch, s.send)
+	if err != nil {
+		return err
+	}
+	s.batch = b
+
+	switch sc.Encoding {
+	case "", "json":
+		s.encode = s.encodeJSON
+	case "protobuf":
+		s.encode = s.encodeProto
+		s.headers = []kafka.Header{{Key: "content-type", Value: []byte(protoContentType)}}
+	case "avro":
+		// Avro needs a schema registry to be useful to consumers,
+		// which the sink doesn't talk to.
+		return errEncodingAvro
+	default:
+		return errEncoding
+	}
+
+	// Address holds a comma-separated list of bootstrap brokers.
+	brokers := strings.Split(sc.Address, ",")
+	for i, b := range brokers {
+		brokers[i] = strings.TrimSpace(b)
+	}
+
+	tc, err := sc.TLS.Config()
+	if err != nil {
+		return err
+	}
+
+	s.writer = &kafka.Writer{
+		Addr:  kafka.TCP(brokers...),
+		Topic: sc.Topic,
+
+		// Messages are keyed by connection ID, so all events
+		// of a flow end up in the same partition.
+		Balancer: &kafka.Hash{},
+
+		// Batching is done by the sink, don't let the writer hold back
+		// (smaller) batches waiting for more messages to arrive.
+		BatchSize:    s.batch.MaxLen(),
+		BatchTimeout: 10 * time.Millisecond,
+		WriteTimeout: sc.Timeout,
+	}
+	if tc != nil {
+		s.writer.Transport = &kafka.Transport{TLS: tc}
+	}
+
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
+
+	s.config = sc // config
+
+	s.batch.Start()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// encodeJSON returns the JSON representation of an event.
+func (s *KafkaSink) encodeJSON(e bpf.Event) ([]byte, error) {
+
+	// Optionally set flows' source ports (since they're random in most cases)
+	if !s.config.EnableSrcPort {
+		e.SrcPort = 0
+	}
+
+	// Optionally set flows' address family.
+	if !s.config.EnableFamily {
+		e.Family = 0
+	}
+
+	return e.MarshalJSON()
+}
+
+// encodeProto returns the protobuf representation of an event,
+// a conntracct.v1.Event.
+func (s *KafkaSink) encodeProto(e bpf.Event) ([]byte, error) {
+
+	pe := schema.FromEvent(e, s.clock.Boot())
+
+	// Fields disabled for the JSON encoding are left unset.
+	if !s.config.EnableSrcPort {
+		pe.SrcPort = 0
+	}
+	if !s.config.EnableFamily {
+		pe.Family = schema.Event_FAMILY_UNSPECIFIED
+	}
+
+	return proto.Marshal(pe)
+}
+
+// Push an accounting event into the buffer of the Kafka accounting sink.
+func (s *KafkaSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+// PushBatch pushes a batch of accounting events into the buffer of the
+// Kafka accounting sink.
+func (s *KafkaSink) PushBatch(es []bpf.Event) (int, error) {
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	if s.batch.Closed() {
+		s.stats.AddEventsDropped(len(es))
+		return len(es), errSinkClosed
+	}
+
+	var (
+		dropped int
+		first   error
+	)
+
+	for _, e := range es {
+		b, err := s.encode(e)
+		if err != nil {
+			s.stats.IncrEventErrors()
+			log.Errorf("Kafka sink '%s': Error encoding event: %s. Event dropped.", s.config.Name, err)
+			if first == nil {
+				first = errors.Wrap(err, "encoding event")
+			}
+			dropped++
+			continue
+		}
+
+		// Add the message to the batch.
+		batch := s.batch.Batch()
+		*batch = append(*batch, kafka.Message{
+			Key:     messageKey(e),
+			Value:   b,
+			Headers: s.headers,
+		})
+		s.batch.Added(e)
+	}
+
+	return dropped, first
+}
+
+// Flush sends the sink's current batch to the send workers and waits until
+// it and all batches queued before it are written, or until ctx is done.
+// Returns the error of writing the current batch.
+func (s *KafkaSink) Flush(ctx context.Context) error {
+	return s.batch.Flush(ctx)
+}
+
+// Close waits for the sink's current and all pending batches to be written
+// before closing the writer. Must only be called once.
+func (s *KafkaSink) Close() error {
+	s.batch.Close()
+	return s.writer.Close()
+}
+
+// SetDeadLetter sets the function receiving the events of batches
+// the sink failed to write.
+func (s *KafkaSink) SetDeadLetter(f types.DeadLetterFunc) {
+	s.deadLetter.Set(f)
+}
+
+// Name gets the name of the Kafka accounting sink.
+func (s *KafkaSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the Kafka accounting sink was successfully initialized.
+func (s *KafkaSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *KafkaSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, Kafka receives destroy events. (flow totals)
+func (s *KafkaSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the Kafka accounting sink's statistics structure.
+func (s *KafkaSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// messageKey returns the key of the message holding e, its connection ID.
+// Messages are partitioned by key, so all events of a flow are kept in order.
+func messageKey(e bpf.Event) []byte {
+	return []byte(strconv.FormatUint(uint64(e.ConnectionID), 10))
+}
+
+// newBatch allocates a new, empty message batch.
+func newBatch(watermark int) []kafka.Message {
+	return make([]kafka.Message, 0, watermark)
+}
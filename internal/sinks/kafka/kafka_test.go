@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"net"
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+var testEvent = bpf.Event{
+	ConnectionID: 42,
+	SrcAddr:      net.ParseIP("192.0.2.1").To4(),
+	DstAddr:      net.ParseIP("192.0.2.2").To4(),
+	SrcPort:      51234,
+	DstPort:      443,
+	Proto:        6,
+	Family:       bpf.FamilyIPv4,
+	BytesOrig:    1000,
+}
+
+func TestInitEncoding(t *testing.T) {
+
+	sc := types.SinkConfig{
+		Name:    "test",
+		Type:    types.Kafka,
+		Address: "localhost:9092",
+		Topic:   "conntracct",
+	}
+
+	s := New()
+	sc.Encoding = "avro"
+	assert.Equal(t, errEncodingAvro, s.Init(sc))
+
+	sc.Encoding = "xml"
+	assert.Equal(t, errEncoding, s.Init(sc))
+}
+
+func TestEncode(t *testing.T) {
+
+	s := KafkaSink{clock: boottime.NewClock()}
+
+	// Source ports and families are left out unless enabled.
+	b, err := s.encodeJSON(testEvent)
+	require.NoError(t, err)
+
+	var e bpf.Event
+	require.NoError(t, e.UnmarshalJSON(b))
+	assert.EqualValues(t, 42, e.ConnectionID)
+	assert.EqualValues(t, 443, e.DstPort)
+	assert.EqualValues(t, 1000, e.BytesOrig)
+	assert.Zero(t, e.SrcPort)
+	assert.Zero(t, e.Family)
+
+	b, err = s.encodeProto(testEvent)
+	require.NoError(t, err)
+
+	var pe schema.Event
+	require.NoError(t, proto.Unmarshal(b, &pe))
+	assert.EqualValues(t, 42, pe.ConnectionId)
+	assert.EqualValues(t, 443, pe.DstPort)
+	assert.Zero(t, pe.SrcPort)
+	assert.Equal(t, schema.Event_FAMILY_UNSPECIFIED, pe.Family)
+
+	s.config.EnableSrcPort = true
+	s.config.EnableFamily = true
+
+	b, err = s.encodeProto(testEvent)
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(b, &pe))
+	assert.EqualValues(t, 51234, pe.SrcPort)
+	assert.Equal(t, schema.Event_FAMILY_IPV4, pe.Family)
+}
+
+func TestMessageKey(t *testing.T) {
+
+	assert.Equal(t, []byte("42"), messageKey(testEvent))
+
+	// All events of a flow are produced to the same partition.
+	partitions := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	bal := &kafka.Hash{}
+
+	update := testEvent
+	destroy := testEvent
+	destroy.Destroy = true
+	destroy.BytesOrig = 2000
+
+	p := bal.Balance(kafka.Message{Key: messageKey(update)}, partitions...)
+	assert.Equal(t, p, bal.Balance(kafka.Message{Key: messageKey(destroy)}, partitions...))
+
+	// Different flows are spread over partitions.
+	seen := make(map[int]bool)
+	for id := uint32(0); id < 64; id++ {
+		seen[bal.Balance(kafka.Message{Key: messageKey(bpf.Event{ConnectionID: id})}, partitions...)] = true
+	}
+	assert.Greater(t, len(seen), 1)
+}
@@ -0,0 +1,35 @@
+package kafka
+
+import (
+	"context"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// send uses the Kafka writer to produce a batch to the topic.
+// The events of a batch that fails to be written are handed to the sink's
+// dead letter function.
+func (s *KafkaSink) send(b []kafka.Message, events []bpf.Event) error {
+
+	// Write the batch
+	start := time.Now()
+	err := s.writer.WriteMessages(context.Background(), b...)
+	s.stats.ObserveFlush(time.Since(start), len(b), err)
+	if err != nil {
+		log.Errorf("Kafka sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		s.deadLetter.Send(s.config.Name, events)
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
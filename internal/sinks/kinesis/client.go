@@ -0,0 +1,295 @@
+package kinesis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	defaultMaxRetries   = 5
+	defaultRetryBackoff = 100 * time.Millisecond
+	defaultTimeout      = 10 * time.Second
+
+	// Upper bound of the time between two write attempts.
+	maxRetryBackoff = 10 * time.Second
+)
+
+// record is a single Kinesis or Firehose record, holding the newline-delimited
+// JSON form of one or more events.
+type record struct {
+	data []byte
+
+	// Amount of events in the record.
+	count int
+
+	// Index of the record's first event in the events of its batch.
+	first int
+}
+
+// api describes the PutRecords operation of one of the services.
+type api struct {
+	// Service name used for the endpoint and for signing requests.
+	service string
+	target  string
+
+	// Name of the request field holding the stream name.
+	streamField string
+}
+
+var (
+	streamsAPI  = api{service: "kinesis", target: "Kinesis_20131202.PutRecords", streamField: "StreamName"}
+	firehoseAPI = api{service: "firehose", target: "Firehose_20150804.PutRecordBatch", streamField: "DeliveryStreamName"}
+)
+
+// client writes batches of records to a Kinesis data stream or a Firehose
+// delivery stream. Throttled requests and records are retried with an
+// exponential backoff with jitter, only retrying the records that failed.
+type client struct {
+	name   string
+	stream string
+	region string
+	api    api
+
+	// Endpoint URL of the service.
+	url string
+
+	maxRetries int
+	backoff    time.Duration
+
+	// Statistics of the sink owning the client, counting retries if set.
+	stats *types.SinkStats
+
+	// Partition key of the next record written to a data stream, shared by
+	// all send workers. Records hold the events of many flows, so they're
+	// spread over all shards.
+	partition uint64
+
+	creds  *credentialSource
+	client *http.Client
+}
+
+// newClient returns a client for the service of the given sink type.
+func newClient(sc types.SinkConfig) (*client, error) {
+
+	tc, err := sc.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	c := client{
+		name:       sc.Name,
+		stream:     sc.Topic,
+		region:     sc.Region,
+		api:        streamsAPI,
+		url:        sc.Address,
+		maxRetries: int(sc.MaxRetries),
+		backoff:    sc.RetryBackoff,
+		creds:      newCredentialSource(),
+		client:     &http.Client{Timeout: sc.Timeout},
+	}
+
+	if sc.Type == types.Firehose {
+		c.api = firehoseAPI
+	}
+	if c.url == "" {
+		c.url = fmt.Sprintf("https://%s.%s.amazonaws.com/", c.api.service, c.region)
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	}
+	if c.backoff == 0 {
+		c.backoff = defaultRetryBackoff
+	}
+	if c.client.Timeout == 0 {
+		c.client.Timeout = defaultTimeout
+	}
+	if tc != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tc
+		c.client.Transport = t
+	}
+
+	return &c, nil
+}
+
+// Write writes a batch of records to the stream. Blocks until all records
+// are written or all retries are exhausted. Returns the records that failed
+// to be written.
+func (c *client) Write(records []record) ([]record, error) {
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		failed, retry, err := c.write(records)
+		if err == nil {
+			return nil, nil
+		}
+		records = failed
+
+		if !retry || attempt == c.maxRetries {
+			return records, err
+		}
+
+		if c.stats != nil {
+			c.stats.IncrRetries()
+		}
+
+		// Full jitter keeps throttled writers from retrying in lockstep.
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		log.Warnf("Kinesis sink '%s': Error writing %d records: %s. Retrying in %s.", c.name, len(records), err, wait)
+
+		time.Sleep(wait)
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// Close releases the idle connections held by the http.Client.
+func (c *client) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// putRecord is a record in the body of a PutRecords request.
+type putRecord struct {
+	Data         []byte
+	PartitionKey string `json:",omitempty"`
+}
+
+// putResult is the outcome of a single record of a PutRecords request.
+type putResult struct {
+	ErrorCode    string
+	ErrorMessage string
+}
+
+// write makes a single PutRecords request with the given records. Returns
+// the records that failed to be written and whether they should be retried.
+func (c *client) write(records []record) ([]record, bool, error) {
+
+	prs := make([]putRecord, 0, len(records))
+	for _, r := range records {
+		pr := putRecord{Data: r.data}
+		if c.api.service == streamsAPI.service {
+			pr.PartitionKey = strconv.FormatUint(atomic.AddUint64(&c.partition, 1)-1, 10)
+		}
+		prs = append(prs, pr)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		c.api.streamField: c.stream,
+		"Records":         prs,
+	})
+	if err != nil {
+		return records, false, err
+	}
+
+	creds, err := c.creds.get()
+	if err != nil {
+		// The instance metadata service may be briefly unavailable.
+		return records, true, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return records, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", c.api.target)
+	signRequest(req, body, creds, c.region, c.api.service, time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Network errors are usually transient.
+		return records, true, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return records, true, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(b, &e)
+
+		// Server errors and throttling are retried, the request
+		// itself is not the problem.
+		retry := resp.StatusCode >= http.StatusInternalServerError || throttled(e.Type)
+
+		msg := strings.TrimSpace(string(b))
+		if e.Type != "" {
+			msg = e.Type + ": " + e.Message
+		}
+		return records, retry, fmt.Errorf(errFmtStatus, resp.StatusCode, msg)
+	}
+
+	// Data streams and Firehose name the fields of their results differently.
+	var out struct {
+		FailedRecordCount int
+		FailedPutCount    int
+		Records           []putResult
+		RequestResponses  []putResult
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return records, false, err
+	}
+
+	n := out.FailedRecordCount + out.FailedPutCount
+	if n == 0 {
+		return nil, false, nil
+	}
+
+	results := append(out.Records, out.RequestResponses...)
+	if len(results) != len(records) {
+		return records, true, fmt.Errorf(errFmtFailed, n, "", "malformed response")
+	}
+
+	// Individual records fail when a shard or delivery stream is throttled
+	// or on internal errors, both are worth retrying.
+	var failed []record
+	var last putResult
+	for i, res := range results {
+		if res.ErrorCode != "" {
+			failed = append(failed, records[i])
+			last = res
+		}
+	}
+
+	return failed, true, fmt.Errorf(errFmtFailed, len(failed), last.ErrorCode, last.ErrorMessage)
+}
+
+// throttled returns true if the error type of a response means the request
+// was rate limited.
+func throttled(typ string) bool {
+
+	// Types may be prefixed with a namespace, eg. 'com.amazon.coral.service#'.
+	if i := strings.LastIndexByte(typ, '#'); i >= 0 {
+		typ = typ[i+1:]
+	}
+
+	switch typ {
+	case "ThrottlingException", "LimitExceededException",
+		"ProvisionedThroughputExceededException", "ServiceUnavailableException":
+		return true
+	}
+
+	return false
+}
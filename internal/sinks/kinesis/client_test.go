@@ -0,0 +1,80 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+func TestClientWrite(t *testing.T) {
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var reqs []string
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Firehose_20150804.PutRecordBatch", r.Header.Get("X-Amz-Target"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/firehose/aws4_request")
+
+		var body struct {
+			DeliveryStreamName string
+			Records            []putRecord
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "conntracct", body.DeliveryStreamName)
+
+		for _, pr := range body.Records {
+			reqs = append(reqs, string(pr.Data))
+		}
+
+		n++
+		switch n {
+		case 1:
+			// Whole request throttled.
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ThrottlingException","message":"slow down"}`))
+		case 2:
+			// Second record throttled.
+			w.Write([]byte(`{"FailedPutCount":1,"RequestResponses":[{"RecordId":"1"},` +
+				`{"ErrorCode":"ServiceUnavailableException","ErrorMessage":"slow down"}]}`))
+		default:
+			w.Write([]byte(`{"FailedPutCount":0,"RequestResponses":[{"RecordId":"2"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := newClient(types.SinkConfig{
+		Type:         types.Firehose,
+		Topic:        "conntracct",
+		Region:       "eu-west-1",
+		Address:      srv.URL,
+		RetryBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	failed, err := c.Write([]record{{data: []byte("a")}, {data: []byte("b")}})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	// Only the throttled record is retried.
+	assert.Equal(t, []string{"a", "b", "a", "b", "b"}, reqs)
+
+	// Client errors are not retried.
+	c.url = srv.URL + "/nonexistent"
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"no such stream"}`))
+	})
+
+	failed, err = c.Write([]record{{data: []byte("c")}})
+	assert.EqualError(t, err, "unexpected status code 400: ResourceNotFoundException: no such stream")
+	assert.Len(t, failed, 1)
+}
@@ -0,0 +1,153 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Base URL of the EC2 instance metadata service.
+	imdsURL = "http://169.254.169.254/latest"
+
+	// Instance profile credentials are refreshed this long before they expire.
+	credentialsRefresh = 5 * time.Minute
+)
+
+// credentials sign requests to AWS.
+type credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+
+	// Zero if the credentials don't expire.
+	expires time.Time
+}
+
+// credentialSource provides the credentials of the process, read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables, or else from the instance profile of the EC2 instance using
+// IMDSv2. Instance profile credentials are cached until shortly before they
+// expire. Safe for concurrent use.
+type credentialSource struct {
+	imds   string
+	client *http.Client
+
+	mu    sync.Mutex
+	creds credentials
+}
+
+func newCredentialSource() *credentialSource {
+	return &credentialSource{
+		imds:   imdsURL,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// get returns valid credentials, fetching new ones if necessary.
+func (cs *credentialSource) get() (credentials, error) {
+
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return credentials{
+			accessKeyID:     id,
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.creds.accessKeyID != "" && time.Until(cs.creds.expires) > credentialsRefresh {
+		return cs.creds, nil
+	}
+
+	c, err := cs.instanceProfile()
+	if err != nil {
+		return credentials{}, errors.Wrap(errNoCredentials, err.Error())
+	}
+	cs.creds = c
+
+	return c, nil
+}
+
+// instanceProfile fetches the credentials of the instance's IAM role
+// from the instance metadata service.
+func (cs *credentialSource) instanceProfile() (credentials, error) {
+
+	token, err := cs.metadata(http.MethodPut, "/api/token", "")
+	if err != nil {
+		return credentials{}, errors.Wrap(err, "getting metadata token")
+	}
+
+	role, err := cs.metadata(http.MethodGet, "/meta-data/iam/security-credentials/", token)
+	if err != nil {
+		return credentials{}, errors.Wrap(err, "getting instance role")
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+
+	b, err := cs.metadata(http.MethodGet, "/meta-data/iam/security-credentials/"+role, token)
+	if err != nil {
+		return credentials{}, errors.Wrapf(err, "getting credentials of role '%s'", role)
+	}
+
+	var out struct {
+		Code            string
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(b), &out); err != nil {
+		return credentials{}, errors.Wrap(err, "decoding instance credentials")
+	}
+	if out.Code != "Success" {
+		return credentials{}, fmt.Errorf("instance credentials: %s", out.Code)
+	}
+
+	return credentials{
+		accessKeyID:     out.AccessKeyID,
+		secretAccessKey: out.SecretAccessKey,
+		sessionToken:    out.Token,
+		expires:         out.Expiration,
+	}, nil
+}
+
+// metadata makes a request to the instance metadata service, authenticated
+// with the given session token unless it's empty.
+func (cs *credentialSource) metadata(method, path, token string) (string, error) {
+
+	req, err := http.NewRequest(method, cs.imds+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if token == "" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	} else {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+
+	resp, err := cs.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(errFmtStatus, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	return string(b), nil
+}
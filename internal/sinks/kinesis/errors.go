@@ -0,0 +1,21 @@
+package kinesis
+
+import (
+	"errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	errFmtStatus = "unexpected status code %d: %s"
+	errFmtFailed = "%d records failed, last error: %s: %s"
+)
+
+var (
+	errEmptySinkName   = errors.New("empty sink name")
+	errEmptyStream     = errors.New("empty stream name (topic)")
+	errEmptyRegion     = errors.New("empty region, set region or AWS_REGION")
+	errInvalidSinkType = errors.New("invalid sink type")
+	errSinkClosed      = types.ErrSinkClosed
+	errNoCredentials   = errors.New("no AWS credentials in the environment or instance metadata")
+)
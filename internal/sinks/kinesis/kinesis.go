@@ -0,0 +1,243 @@
+package kinesis
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+	defaultWorkers       = 1
+
+	// Limits of a PutRecords request, the lower of both services. A record's
+	// size includes its partition key, leave some room for it.
+	maxRecordSize   = 1000 << 10
+	maxBatchRecords = 500
+	maxBatchSize    = 4 << 20
+)
+
+// KinesisSink is an accounting sink writing JSON-encoded events to an AWS
+// Kinesis data stream or a Kinesis Data Firehose delivery stream. Events are
+// aggregated into records as newline-delimited JSON, keeping the amount of
+// records (and the cost of the stream) down.
+type KinesisSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Kinesis or Firehose API client.
+	client *client
+
+	// Record batch, handed to the send workers.
+	batch *types.Batcher[*batch]
+
+	// Scratch space encoding events, protected by the batch's lock.
+	buf []byte
+
+	// Sink stats.
+	stats types.SinkStats
+
+	// Receives the events of records that failed to be written.
+	deadLetter types.DeadLetter
+}
+
+// batch is a batch of records, the last record is being appended to.
+type batch struct {
+	records []record
+
+	// Size of the records' data in bytes.
+	size int
+}
+
+// New returns a new Kinesis accounting sink.
+func New() KinesisSink {
+	return KinesisSink{}
+}
+
+// Init initializes the Kinesis accounting sink.
+func (s *KinesisSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Topic == "" {
+		return errEmptyStream
+	}
+	if sc.Type != types.Kinesis && sc.Type != types.Firehose {
+		return errInvalidSinkType
+	}
+	if sc.Region == "" {
+		sc.Region = os.Getenv("AWS_REGION")
+	}
+	if sc.Region == "" {
+		sc.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if sc.Region == "" {
+		return errEmptyRegion
+	}
+	if sc.BatchSize == 0 {
+		sc.BatchSize = defaultBatchSize
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Workers == 0 {
+		sc.Workers = defaultWorkers
+	}
+
+	b, err := types.NewBatcher(sc, &s.stats, &s.deadLetter, newBatch, s.send)
+	if err != nil {
+		return err
+	}
+	s.batch = b
+
+	c, err := newClient(sc)
+	if err != nil {
+		return err
+	}
+	c.stats = &s.stats
+
+	s.client = c  // API client
+	s.config = sc // config
+
+	s.batch.Start()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// Push an accounting event into the buffer of the Kinesis accounting sink.
+func (s *KinesisSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+// PushBatch pushes a batch of accounting events into the buffer of the
+// Kinesis accounting sink.
+func (s *KinesisSink) PushBatch(es []bpf.Event) (int, error) {
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	if s.batch.Closed() {
+		s.stats.AddEventsDropped(len(es))
+		return len(es), errSinkClosed
+	}
+
+	for _, e := range es {
+		s.add(e)
+	}
+
+	return 0, nil
+}
+
+// add appends an accounting event to the current batch.
+// The batch's lock must be held.
+func (s *KinesisSink) add(e bpf.Event) {
+
+	// Optionally set flows' source ports (since they're random in most cases)
+	if !s.config.EnableSrcPort {
+		e.SrcPort = 0
+	}
+
+	// Optionally set flows' address family.
+	if !s.config.EnableFamily {
+		e.Family = 0
+	}
+
+	s.buf = append(e.AppendJSON(s.buf[:0], false), '\n')
+	b := s.buf
+
+	// Send the batch first if the event doesn't fit in it.
+	if (*s.batch.Batch()).size+len(b) > maxBatchSize {
+		s.batch.Send()
+	}
+
+	// Start a new record if the event doesn't fit in the last one.
+	bt := *s.batch.Batch()
+	n := len(bt.records)
+	if n == 0 || len(bt.records[n-1].data)+len(b) > maxRecordSize {
+		if n == maxBatchRecords {
+			s.batch.Send()
+			bt = *s.batch.Batch()
+		}
+		bt.records = append(bt.records, record{first: s.batch.Len()})
+		n = len(bt.records)
+	}
+
+	r := &bt.records[n-1]
+	r.data = append(r.data, b...)
+	r.count++
+	bt.size += len(b)
+
+	s.batch.Added(e)
+}
+
+// Flush sends the sink's current batch to the send workers and waits until
+// it and all batches queued before it are written, or until ctx is done.
+// Returns the error of writing the current batch.
+func (s *KinesisSink) Flush(ctx context.Context) error {
+	return s.batch.Flush(ctx)
+}
+
+// Close waits for the sink's current and all pending batches to be written.
+// Must only be called once.
+func (s *KinesisSink) Close() error {
+
+	s.batch.Close()
+
+	if err := s.client.Close(); err != nil {
+		log.Errorf("Kinesis sink '%s': Error closing client: %s", s.config.Name, err)
+	}
+
+	return nil
+}
+
+// SetDeadLetter sets the function receiving the events of records
+// the sink failed to write.
+func (s *KinesisSink) SetDeadLetter(f types.DeadLetterFunc) {
+	s.deadLetter.Set(f)
+}
+
+// Name gets the name of the Kinesis accounting sink.
+func (s *KinesisSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the Kinesis accounting sink was successfully initialized.
+func (s *KinesisSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *KinesisSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, Kinesis receives destroy events. (flow totals)
+func (s *KinesisSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the Kinesis accounting sink's statistics structure.
+func (s *KinesisSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// newBatch allocates a new, empty record batch.
+func newBatch(int) *batch {
+	return &batch{}
+}
@@ -0,0 +1,58 @@
+package kinesis
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// send uses the API client to write a batch to the stream. The events of
+// records that fail to be written are handed to the sink's dead letter
+// function, events holds the batch's events if dead-lettering is enabled.
+func (s *KinesisSink) send(bt *batch, events []bpf.Event) error {
+
+	b := bt.records
+
+	// Write the batch
+	start := time.Now()
+	failed, err := s.client.Write(b)
+
+	// Only the events of failed records are lost.
+	total, lost := countEvents(b), countEvents(failed)
+	s.stats.ObserveFlush(time.Since(start), lost, err)
+	s.stats.AddEventsSent(total - lost)
+
+	if err != nil {
+		log.Errorf("Kinesis sink '%s': Error writing batch: %s. %d of %d records dropped.",
+			s.config.Name, err, len(failed), len(b))
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+
+		if events != nil {
+			var dead []bpf.Event
+			for _, r := range failed {
+				dead = append(dead, events[r.first:r.first+r.count]...)
+			}
+			s.deadLetter.Send(s.config.Name, dead)
+		}
+
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// countEvents returns the amount of events in the given records.
+func countEvents(rs []record) int {
+	var n int
+	for _, r := range rs {
+		n += r.count
+	}
+	return n
+}
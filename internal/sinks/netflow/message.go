@@ -0,0 +1,92 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/sinks/flowexport"
+)
+
+// NetFlow v9 protocol constants. (RFC 3954)
+const (
+	version = 9
+
+	// Length of the packet header.
+	headerLen = 20
+
+	// FlowSet ID of a template FlowSet.
+	templateSetID = 0
+
+	// FlowSets are padded to a 32-bit boundary.
+	setAlignment = 4
+)
+
+// Field types of the flow time stamps.
+const (
+	fieldLastSwitched  = 21
+	fieldFirstSwitched = 22
+)
+
+// timeFields returns the flow time stamps of a record as the system uptime
+// in milliseconds, relative to the given boot time.
+func timeFields(boot time.Time) []flowexport.Field {
+	return []flowexport.Field{
+		{ID: fieldFirstSwitched, Length: 4, Append: func(b []byte, r flowexport.Record) []byte {
+			return flowexport.AppendUint32(b, uptimeMillis(boot, r.Start))
+		}},
+		{ID: fieldLastSwitched, Length: 4, Append: func(b []byte, r flowexport.Record) []byte {
+			return flowexport.AppendUint32(b, uptimeMillis(boot, r.End))
+		}},
+	}
+}
+
+// protocol implements the NetFlow v9 packet framing.
+type protocol struct {
+	// Source ID of the exporter.
+	source uint32
+
+	// Boot time of the machine, reference for sysUptime.
+	boot time.Time
+
+	// Amount of export packets sent so far.
+	seq uint32
+}
+
+// HeaderLen returns the length of a NetFlow v9 packet header.
+func (p *protocol) HeaderLen() int {
+	return headerLen
+}
+
+// TemplateSetID returns the FlowSet ID of a NetFlow v9 template FlowSet.
+func (p *protocol) TemplateSetID() uint16 {
+	return templateSetID
+}
+
+// SetAlignment returns the boundary FlowSets are padded to.
+func (p *protocol) SetAlignment() int {
+	return setAlignment
+}
+
+// PutHeader writes a NetFlow v9 packet header into msg.
+func (p *protocol) PutHeader(msg []byte, records, templates int) {
+
+	now := time.Now()
+
+	binary.BigEndian.PutUint16(msg[0:2], version)
+	// Count holds the total amount of template and data records in the packet.
+	binary.BigEndian.PutUint16(msg[2:4], uint16(records+templates))
+	binary.BigEndian.PutUint32(msg[4:8], uptimeMillis(p.boot, now))
+	binary.BigEndian.PutUint32(msg[8:12], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[12:16], p.seq)
+	binary.BigEndian.PutUint32(msg[16:20], p.source)
+
+	// Sequence number counts all export packets sent before this one.
+	p.seq++
+}
+
+// uptimeMillis returns the amount of milliseconds elapsed between boot and t.
+// Wraps around after 49.7 days, collectors take care of this using the
+// packet's sysUptime and UNIX seconds.
+func uptimeMillis(boot, t time.Time) uint32 {
+	return uint32(t.Sub(boot) / time.Millisecond)
+}
@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+)
+
+const (
+	errFmtHandshake = "malformed handshake '%s', expected '<protocol version>|<network>|<address>'"
+	errFmtVersion   = "unsupported protocol version %s, expected %d"
+)
+
+var (
+	errEmptySinkName    = errors.New("empty sink name")
+	errInvalidSinkType  = errors.New("invalid sink type")
+	errSinkClosed       = types.ErrSinkClosed
+	errNoPlugin         = errors.New("either command or address is required")
+	errPluginAndAddress = errors.New("command and address are mutually exclusive")
+	errHandshakeTimeout = errors.New("timed out waiting for handshake")
+	errExited           = errors.New("plugin exited before completing the handshake")
+	errKilled           = errors.New("plugin killed after not exiting on SIGTERM")
+)
@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/boottime"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/plugin"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+const (
+	defaultBatchSize     = 1024
+	defaultFlushInterval = time.Second
+	defaultTimeout       = 10 * time.Second
+	defaultWorkers       = 1
+)
+
+// PluginSink is an accounting sink writing to an out-of-process sink,
+// either an executable it starts or a plugin listening on Address.
+type PluginSink struct {
+
+	// Sink had Init() called on it successfully.
+	init bool
+
+	// Sink's configuration object.
+	config types.SinkConfig
+
+	// Converts event time stamps to absolute time.
+	clock *boottime.Clock
+
+	// Plugin process, nil if the plugin was started separately.
+	proc *process
+
+	// Connection to the plugin.
+	conn *grpc.ClientConn
+
+	// Event batch, handed to the send workers.
+	batch *types.Batcher[[]*schema.Event]
+
+	// Sink stats.
+	stats types.SinkStats
+
+	// Receives the events of batches that failed to be written.
+	deadLetter types.DeadLetter
+}
+
+// New returns a new plugin accounting sink.
+func New() PluginSink {
+	return PluginSink{}
+}
+
+// Init initializes the plugin accounting sink. Starts the plugin if a
+// command is configured, connects to it and passes it the sink's options.
+func (s *PluginSink) Init(sc types.SinkConfig) error {
+
+	// Validate / sanitize input.
+	if sc.Name == "" {
+		return errEmptySinkName
+	}
+	if sc.Command == "" && sc.Address == "" {
+		return errNoPlugin
+	}
+	if sc.Command != "" && sc.Address != "" {
+		return errPluginAndAddress
+	}
+	if sc.Type != types.Plugin {
+		return errInvalidSinkType
+	}
+	if sc.BatchSize == 0 {
+		sc.BatchSize = defaultBatchSize
+	}
+	if sc.FlushInterval == 0 {
+		sc.FlushInterval = defaultFlushInterval
+	}
+	if sc.Timeout == 0 {
+		sc.Timeout = defaultTimeout
+	}
+	if sc.Workers == 0 {
+		sc.Workers = defaultWorkers
+	}
+
+	b, err := types.NewBatcher(sc, &s.stats, &s.deadLetter, newBatch, s.send)
+	if err != nil {
+		return err
+	}
+	s.batch = b
+
+	target := sc.Address
+	if sc.Command != "" {
+		p, t, err := startProcess(sc.Name, sc.Command, sc.Args, sc.Timeout)
+		if err != nil {
+			return errors.Wrapf(err, "starting plugin '%s'", sc.Command)
+		}
+		s.proc, target = p, t
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		s.stopProcess()
+		return errors.Wrap(err, "connecting to plugin")
+	}
+	s.conn = conn
+
+	if err := s.configure(sc); err != nil {
+		conn.Close()
+		s.stopProcess()
+		return errors.Wrap(err, "configuring plugin")
+	}
+
+	// Track the machine's boot time, for absolute event timestamps.
+	s.clock = boottime.NewClock()
+
+	s.config = sc // config
+
+	s.batch.Start()
+
+	// Mark the sink as initialized.
+	s.init = true
+
+	return nil
+}
+
+// configure passes the sink's name and options to the plugin.
+func (s *PluginSink) configure(sc types.SinkConfig) error {
+
+	opts := make(map[string]interface{}, len(sc.Options))
+	for k, v := range sc.Options {
+		opts[k] = v
+	}
+
+	req, err := structpb.NewStruct(map[string]interface{}{
+		"name":    sc.Name,
+		"options": opts,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sc.Timeout)
+	defer cancel()
+
+	return s.conn.Invoke(ctx, plugin.MethodConfigure, req, new(emptypb.Empty), grpc.WaitForReady(true))
+}
+
+// Push an accounting event into the buffer of the plugin accounting sink.
+func (s *PluginSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+// PushBatch pushes a batch of accounting events into the buffer of the
+// plugin accounting sink.
+func (s *PluginSink) PushBatch(es []bpf.Event) (int, error) {
+
+	s.batch.Lock()
+	defer s.batch.Unlock()
+
+	if s.batch.Closed() {
+		s.stats.AddEventsDropped(len(es))
+		return len(es), errSinkClosed
+	}
+
+	for _, e := range es {
+
+		// Add the event to the batch.
+		batch := s.batch.Batch()
+		*batch = append(*batch, schema.FromEvent(e, s.clock.Boot()))
+		s.batch.Added(e)
+	}
+
+	return 0, nil
+}
+
+// Flush sends the sink's current batch to the send workers and waits until
+// it and all batches queued before it are written, or until ctx is done.
+// Returns the error of writing the current batch.
+func (s *PluginSink) Flush(ctx context.Context) error {
+	return s.batch.Flush(ctx)
+}
+
+// CheckHealth checks if the plugin responds to pings, and whether it can
+// reach its backing storage if the plugin checks that.
+func (s *PluginSink) CheckHealth(ctx context.Context) error {
+	return s.conn.Invoke(ctx, plugin.MethodPing, &emptypb.Empty{}, new(emptypb.Empty))
+}
+
+// Close waits for the sink's current and all pending batches to be written
+// before disconnecting from the plugin and stopping it. Must only be called
+// once.
+func (s *PluginSink) Close() error {
+
+	s.batch.Close()
+
+	err := s.conn.Close()
+	if perr := s.stopProcess(); err == nil {
+		err = perr
+	}
+
+	return err
+}
+
+// stopProcess stops the plugin process, if the sink started one.
+func (s *PluginSink) stopProcess() error {
+	if s.proc == nil {
+		return nil
+	}
+	return s.proc.stop()
+}
+
+// SetDeadLetter sets the function receiving the events of batches
+// the sink failed to write.
+func (s *PluginSink) SetDeadLetter(f types.DeadLetterFunc) {
+	s.deadLetter.Set(f)
+}
+
+// Name gets the name of the plugin accounting sink.
+func (s *PluginSink) Name() string {
+	return s.config.Name
+}
+
+// IsInit checks if the plugin accounting sink was successfully initialized.
+func (s *PluginSink) IsInit() bool {
+	return s.init
+}
+
+// WantUpdate always returns true.
+func (s *PluginSink) WantUpdate() bool {
+	return true
+}
+
+// WantDestroy always returns true, plugins receive destroy events. (flow totals)
+func (s *PluginSink) WantDestroy() bool {
+	return true
+}
+
+// Stats returns the plugin accounting sink's statistics structure.
+func (s *PluginSink) Stats() types.SinkStatsData {
+	return s.stats.Get()
+}
+
+// newBatch allocates a new, empty event batch.
+func newBatch(watermark int) []*schema.Event {
+	return make([]*schema.Event, 0, watermark)
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/plugin"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+// testSink is a plugin recording the events written to it.
+type testSink struct {
+	mu     sync.Mutex
+	name   string
+	fail   bool
+	events []*schema.Event
+}
+
+func (s *testSink) Configure(_ context.Context, name string, opts map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name, s.fail = name, opts["fail"] == "true"
+	return nil
+}
+
+func (s *testSink) Write(_ context.Context, events []*schema.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("backend down")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *testSink) Close() error { return nil }
+
+// The test binary serves a testSink when started as a plugin.
+func TestMain(m *testing.M) {
+	if os.Getenv(plugin.MagicCookieKey) != "" {
+		if err := plugin.Serve(&testSink{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestPluginSinkAddress(t *testing.T) {
+
+	sock := filepath.Join(t.TempDir(), "plugin.sock")
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+
+	ts := &testSink{}
+	go plugin.Listen(ts, l)
+	defer l.Close()
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:    "billing",
+		Type:    types.Plugin,
+		Address: "unix://" + sock,
+	}))
+
+	require.NoError(t, s.Push(bpf.Event{SrcPort: 1234, Destroy: true}))
+	require.NoError(t, s.Push(bpf.Event{SrcPort: 5678}))
+	require.NoError(t, s.Flush(context.Background()))
+	require.NoError(t, s.CheckHealth(context.Background()))
+
+	ts.mu.Lock()
+	assert.Equal(t, "billing", ts.name)
+	require.Len(t, ts.events, 2)
+	assert.EqualValues(t, 1234, ts.events[0].SrcPort)
+	assert.Equal(t, schema.Event_TYPE_DESTROY, ts.events[0].Type)
+	ts.mu.Unlock()
+
+	assert.EqualValues(t, 2, s.Stats().EventsSent)
+	require.NoError(t, s.Close())
+}
+
+func TestPluginSinkCommand(t *testing.T) {
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+
+	s := New()
+	require.NoError(t, s.Init(types.SinkConfig{
+		Name:    "billing",
+		Type:    types.Plugin,
+		Command: exe,
+		Args:    []string{"-test.run=^$"},
+		Options: map[string]string{"fail": "true"},
+	}))
+
+	require.NoError(t, s.Push(bpf.Event{}))
+	assert.Error(t, s.Flush(context.Background()))
+
+	st := s.Stats()
+	assert.EqualValues(t, 1, st.BatchesDropped)
+	assert.EqualValues(t, 1, st.EventsLost)
+
+	require.NoError(t, s.CheckHealth(context.Background()))
+	require.NoError(t, s.Close())
+}
+
+func TestParseHandshake(t *testing.T) {
+
+	target, err := parseHandshake("1|unix|/tmp/p/plugin.sock\n")
+	require.NoError(t, err)
+	assert.Equal(t, "unix:///tmp/p/plugin.sock", target)
+
+	target, err = parseHandshake("1|tcp|127.0.0.1:4000")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:4000", target)
+
+	_, err = parseHandshake("2|tcp|127.0.0.1:4000")
+	assert.Error(t, err)
+	_, err = parseHandshake("listening on 127.0.0.1:4000")
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/pkg/plugin"
+)
+
+// Time given to a plugin to exit after SIGTERM before it's killed.
+const stopTimeout = 5 * time.Second
+
+// process is a running plugin executable.
+type process struct {
+	cmd *exec.Cmd
+
+	// Closed when the process has exited.
+	exited chan struct{}
+}
+
+// startProcess starts a plugin executable and waits up to timeout for its
+// handshake. Returns the gRPC target of the plugin. The plugin's output
+// after the handshake is logged.
+func startProcess(name, command string, args []string, timeout time.Duration) (*process, string, error) {
+
+	cmd := exec.Command(command, args...)
+	cmd.Env = append(os.Environ(), plugin.MagicCookieKey+"="+plugin.MagicCookieValue)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Wait returns once stderr is copied into the pipe, unlike StderrPipe.
+	stderr, stderrW := io.Pipe()
+	cmd.Stderr = stderrW
+
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+
+	p := &process{cmd: cmd, exited: make(chan struct{})}
+
+	go logOutput(name, stderr)
+
+	lines := make(chan string, 1)
+	go func() {
+		r := bufio.NewReader(stdout)
+		l, err := r.ReadString('\n')
+		if err != nil {
+			close(lines)
+		} else {
+			lines <- l
+		}
+		logOutput(name, r)
+
+		// Stdout must be read to completion before calling Wait.
+		_ = cmd.Wait()
+		stderrW.Close()
+		close(p.exited)
+	}()
+
+	var line string
+	select {
+	case l, ok := <-lines:
+		if !ok {
+			p.stop()
+			return nil, "", errExited
+		}
+		line = l
+	case <-time.After(timeout):
+		p.stop()
+		return nil, "", errHandshakeTimeout
+	}
+
+	target, err := parseHandshake(line)
+	if err != nil {
+		p.stop()
+		return nil, "", err
+	}
+
+	return p, target, nil
+}
+
+// stop sends SIGTERM to the process and waits for it to exit, killing it
+// if it doesn't exit within stopTimeout.
+func (p *process) stop() error {
+
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// Process has already exited.
+		<-p.exited
+		return nil
+	}
+
+	select {
+	case <-p.exited:
+		return nil
+	case <-time.After(stopTimeout):
+	}
+
+	if err := p.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-p.exited
+
+	return errKilled
+}
+
+// parseHandshake parses a plugin's handshake line, returning the gRPC target
+// of the address it's listening on.
+func parseHandshake(line string) (string, error) {
+
+	line = strings.TrimSpace(line)
+
+	parts := strings.Split(line, "|")
+	if len(parts) != 3 {
+		return "", fmt.Errorf(errFmtHandshake, line)
+	}
+
+	if v, err := strconv.Atoi(parts[0]); err != nil || v != plugin.ProtocolVersion {
+		return "", fmt.Errorf(errFmtVersion, parts[0], plugin.ProtocolVersion)
+	}
+
+	switch parts[1] {
+	case "unix":
+		return "unix://" + parts[2], nil
+	case "tcp":
+		return parts[2], nil
+	default:
+		return "", fmt.Errorf(errFmtHandshake, line)
+	}
+}
+
+// logOutput logs the lines read from r until it's closed.
+func logOutput(name string, r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		log.Infof("Plugin sink '%s': %s", name, s.Text())
+	}
+}
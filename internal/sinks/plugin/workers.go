@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+	"github.com/ti-mo/conntracct/pkg/plugin"
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+// send writes a batch to the plugin. The events of a batch that fails to be
+// written are handed to the sink's dead letter function.
+func (s *PluginSink) send(b []*schema.Event, events []bpf.Event) error {
+
+	// Write the batch
+	start := time.Now()
+	err := s.write(b)
+	s.stats.ObserveFlush(time.Since(start), len(b), err)
+	if err != nil {
+		log.Errorf("Plugin sink '%s': Error writing batch: %s. Batch dropped.", s.config.Name, err)
+
+		// Increase dropped batch counter
+		s.stats.IncrBatchDropped()
+		s.deadLetter.Send(s.config.Name, events)
+		return err
+	}
+
+	// Increase sent batch counter
+	s.stats.IncrBatchSent()
+
+	return nil
+}
+
+// write streams a batch of events to the plugin in a single Write call,
+// which returns once the plugin has written the batch.
+func (s *PluginSink) write(b []*schema.Event) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	stream, err := s.conn.NewStream(ctx, &plugin.WriteStreamDesc, plugin.MethodWrite)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range b {
+		if err := stream.SendMsg(e); err != nil {
+			// The server's error is only returned by RecvMsg.
+			break
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	return stream.RecvMsg(new(emptypb.Empty))
+}
@@ -0,0 +1,500 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/spool"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// QueuePolicy decides what happens to an event pushed into a full sink queue.
+type QueuePolicy uint8
+
+// Supported queue policies.
+const (
+	// Block the pipeline until the sink accepts the event.
+	QueueBlock QueuePolicy = iota
+	// Drop the event being pushed.
+	QueueDropNewest
+	// Drop the oldest event in the queue to make room for the new one.
+	QueueDropOldest
+	// Write the event to an on-disk spool, replayed when the queue has room.
+	QueueSpill
+)
+
+const defaultQueueSize = 4096
+
+// Maximum amount of queued events pushed into a sink at once by its worker.
+const queueBatchSize = 64
+
+// Interval at which a sink holding back the replay of its spool, after
+// failing to write a batch, checks whether it recovered.
+const spoolRetryInterval = 5 * time.Second
+
+// ParseQueuePolicy parses the name of a QueuePolicy. An empty name
+// selects QueueBlock.
+func ParseQueuePolicy(s string) (QueuePolicy, error) {
+	switch s {
+	case "", "block":
+		return QueueBlock, nil
+	case "drop-newest":
+		return QueueDropNewest, nil
+	case "drop-oldest":
+		return QueueDropOldest, nil
+	case "spill":
+		return QueueSpill, nil
+	default:
+		return 0, fmt.Errorf("unknown queue policy '%s'", s)
+	}
+}
+
+// queueItem is an event in a sink's queue. If done is set, the item is a
+// marker closed by the worker once all events queued before it are handled.
+type queueItem struct {
+	event bpf.Event
+	done  chan struct{}
+}
+
+// queuedSink wraps a Sink with a bounded queue and a worker pushing events
+// from the queue into the sink, so a slow sink doesn't stall the pipeline.
+type queuedSink struct {
+	Sink
+
+	policy QueuePolicy
+
+	// Protects closed and sending on queue.
+	mu     sync.RWMutex
+	queue  chan queueItem
+	closed bool
+
+	wg sync.WaitGroup
+
+	// Events taken from the queue by the worker, pushed into the sink
+	// as a batch.
+	batch []bpf.Event
+
+	// Overflow of the queue with QueueSpill. The worker is woken up
+	// on spooled when events are added to the spool.
+	spool   *spool.Spool
+	spooled chan struct{}
+
+	// Set when the sink failed to write a batch that was written back into
+	// the spool, accessed atomically. Replaying the spool is held back until
+	// the sink's health check passes, or it writes a batch since it had
+	// written heldSent batches.
+	held     uint32
+	heldSent uint64
+
+	// Amount of events dropped by the queue policy.
+	dropped uint64
+
+	// Set while the sink is an inactive member of a failover group,
+	// accessed atomically. The group's health checks are stopped when
+	// any of its members is closed or regrouped. group is protected by mu.
+	standby uint32
+	group   *failoverGroup
+}
+
+// newQueuedSink wraps s with a queue of the given size and starts its worker.
+// sp must be non-nil for QueueSpill.
+func newQueuedSink(s Sink, size int, policy QueuePolicy, sp *spool.Spool) *queuedSink {
+
+	if size == 0 {
+		size = defaultQueueSize
+	}
+
+	q := &queuedSink{
+		Sink:    s,
+		policy:  policy,
+		queue:   make(chan queueItem, size),
+		spool:   sp,
+		spooled: make(chan struct{}, 1),
+	}
+
+	// Batches the sink fails to write go back into the spool.
+	if src, ok := s.(deadLetterSource); ok && sp != nil {
+		src.SetDeadLetter(q.respool)
+	}
+
+	q.wg.Add(1)
+	go q.worker()
+
+	return q
+}
+
+// Push enqueues an accounting event according to the queue's policy.
+func (q *queuedSink) Push(e bpf.Event) error {
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	return q.enqueue(queueItem{event: e})
+}
+
+// PushBatch enqueues a batch of accounting events according to the queue's
+// policy, holding the queue's lock once for the whole batch.
+func (q *queuedSink) PushBatch(es []bpf.Event) (int, error) {
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return len(es), errQueueClosed
+	}
+
+	var (
+		dropped int
+		first   error
+	)
+
+	for _, e := range es {
+		if err := q.enqueue(queueItem{event: e}); err != nil {
+			if first == nil {
+				first = err
+			}
+			dropped++
+		}
+	}
+
+	return dropped, first
+}
+
+// enqueue adds a queue item to the queue according to the queue's policy.
+// mu must be held for reading and the queue must not be closed.
+func (q *queuedSink) enqueue(qi queueItem) error {
+
+	switch q.policy {
+	case QueueBlock:
+		q.queue <- qi
+		return nil
+	case QueueDropNewest:
+		select {
+		case q.queue <- qi:
+			return nil
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+			return errQueueFull
+		}
+	case QueueSpill:
+		return q.spill(qi)
+	}
+
+	// QueueDropOldest, make room for the event until it fits.
+	for {
+		select {
+		case q.queue <- qi:
+			return nil
+		default:
+		}
+
+		select {
+		case old := <-q.queue:
+			if old.done != nil {
+				// Don't drop flush markers, the events before them are gone.
+				close(old.done)
+				continue
+			}
+			atomic.AddUint64(&q.dropped, 1)
+		default:
+		}
+	}
+}
+
+// spill enqueues an event, or appends it to the spool if the queue is full.
+// Events are spooled as long as the spool is not empty, to keep them in order.
+func (q *queuedSink) spill(qi queueItem) error {
+
+	if q.spool.Empty() {
+		select {
+		case q.queue <- qi:
+			return nil
+		default:
+		}
+	}
+
+	if err := q.spool.Append(qi.event); err != nil {
+		atomic.AddUint64(&q.dropped, 1)
+		return errors.Wrap(err, "spooling event")
+	}
+
+	q.wake()
+
+	return nil
+}
+
+// respool writes the events of a batch the sink failed to write back into
+// the spool, and holds back replaying the spool until the sink recovers.
+// Used as the DeadLetterFunc of the sink's driver.
+func (q *queuedSink) respool(sink string, events []bpf.Event) {
+
+	atomic.StoreUint64(&q.heldSent, q.Sink.Stats().BatchesSent)
+	atomic.StoreUint32(&q.held, 1)
+
+	for i, e := range events {
+		if err := q.spool.Append(e); err != nil {
+			atomic.AddUint64(&q.dropped, uint64(len(events)-i))
+			log.Errorf("Sink '%s': Error spooling %d undelivered events: %s", sink, len(events)-i, err)
+			break
+		}
+	}
+
+	q.wake()
+}
+
+// wake wakes up the worker if it's waiting for the queue.
+func (q *queuedSink) wake() {
+	select {
+	case q.spooled <- struct{}{}:
+	default:
+	}
+}
+
+// replayHeld returns true while replaying the spool is held back after the
+// sink failed to write a batch. Released once the sink wrote a batch since.
+func (q *queuedSink) replayHeld() bool {
+
+	if atomic.LoadUint32(&q.held) == 0 {
+		return false
+	}
+
+	if q.Sink.Stats().BatchesSent > atomic.LoadUint64(&q.heldSent) {
+		atomic.StoreUint32(&q.held, 0)
+		return false
+	}
+
+	return true
+}
+
+// retryReplay releases the replay of the spool held back after the sink
+// failed to write a batch if the sink's health check passes. Sinks without
+// health checks retry replaying the spool, holding it back again if the
+// write fails.
+func (q *queuedSink) retryReplay() {
+
+	if hc, ok := q.Sink.(healthChecker); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), spoolRetryInterval)
+		defer cancel()
+
+		if err := hc.CheckHealth(ctx); err != nil {
+			log.Debugf("Sink '%s': Holding back spool replay: %s", q.Name(), err)
+			return
+		}
+	}
+
+	atomic.StoreUint32(&q.held, 0)
+}
+
+// Flush waits for all queued events to be pushed into the sink and flushes it.
+// Does not wait for spooled events to be replayed.
+func (q *queuedSink) Flush(ctx context.Context) error {
+
+	done := make(chan struct{})
+
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return errQueueClosed
+	}
+	q.queue <- queueItem{done: done}
+	q.mu.RUnlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return q.Sink.Flush(ctx)
+}
+
+// Close waits for all queued events to be pushed into the sink and closes it.
+// Spooled events are kept on disk and replayed when the sink is recreated,
+// including the events of final batches the sink fails to write.
+func (q *queuedSink) Close() error {
+
+	q.mu.Lock()
+	q.closed = true
+	close(q.queue)
+	g := q.group
+	q.group = nil
+	q.mu.Unlock()
+
+	if g != nil {
+		g.stop()
+	}
+
+	q.wg.Wait()
+
+	err := q.Sink.Close()
+
+	if q.spool != nil {
+		if serr := q.spool.Close(); serr != nil {
+			return errors.Wrap(serr, "closing spool")
+		}
+	}
+
+	return err
+}
+
+// Stats returns the sink's statistics, including those of its queue.
+func (q *queuedSink) Stats() types.SinkStatsData {
+
+	s := q.Sink.Stats()
+	s.QueueLength = uint64(len(q.queue))
+	s.QueueDropped = atomic.LoadUint64(&q.dropped)
+	if q.spool != nil {
+		s.SpoolBytes = uint64(q.spool.Size())
+	}
+	s.Standby = atomic.LoadUint32(&q.standby) == 1
+
+	return s
+}
+
+// WantUpdate returns false while the sink is an inactive member
+// of a failover group.
+func (q *queuedSink) WantUpdate() bool {
+	return atomic.LoadUint32(&q.standby) == 0 && q.Sink.WantUpdate()
+}
+
+// WantDestroy returns false while the sink is an inactive member
+// of a failover group.
+func (q *queuedSink) WantDestroy() bool {
+	return atomic.LoadUint32(&q.standby) == 0 && q.Sink.WantDestroy()
+}
+
+// setStandby marks the sink as an inactive member of a failover group,
+// or as active.
+func (q *queuedSink) setStandby(standby bool) {
+	var v uint32
+	if standby {
+		v = 1
+	}
+	atomic.StoreUint32(&q.standby, v)
+}
+
+// setGroup makes the sink a member of the given failover group, stopping
+// the health checks of its previous group. A nil group only stops them.
+func (q *queuedSink) setGroup(g *failoverGroup) {
+
+	q.mu.Lock()
+	old := q.group
+	q.group = g
+	q.mu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+}
+
+// worker pushes events from the queue into the sink until the queue is closed.
+// Spooled events are replayed when the queue is empty, unless the replay is
+// held back after the sink failed to write a batch.
+func (q *queuedSink) worker() {
+
+	defer q.wg.Done()
+
+	t := time.NewTicker(spoolRetryInterval)
+	defer t.Stop()
+
+	for {
+		// Queued events are older than spooled ones.
+		select {
+		case qi, ok := <-q.queue:
+			if !ok {
+				return
+			}
+			q.drain(qi)
+			continue
+		default:
+		}
+
+		held := q.spool != nil && q.replayHeld()
+
+		if q.spool != nil && !q.spool.Empty() && !held {
+			e, ok, err := q.spool.Next()
+			if err != nil {
+				log.Errorf("Sink '%s': Error reading spool: %s", q.Name(), err)
+			}
+			if ok {
+				// Errors are recorded in the sink's statistics.
+				_ = q.Sink.Push(e)
+			}
+			continue
+		}
+
+		var retry <-chan time.Time
+		if held {
+			retry = t.C
+		}
+
+		select {
+		case qi, ok := <-q.queue:
+			if !ok {
+				return
+			}
+			q.drain(qi)
+		case <-q.spooled:
+		case <-retry:
+			q.retryReplay()
+		}
+	}
+}
+
+// drain pushes a queue item and the events queued behind it into the sink
+// in a batch of up to queueBatchSize events. Flush markers are signaled
+// once the events queued before them are pushed.
+func (q *queuedSink) drain(qi queueItem) {
+
+loop:
+	for {
+		if qi.done != nil {
+			q.pushBatch()
+			close(qi.done)
+			return
+		}
+
+		q.batch = append(q.batch, qi.event)
+		if len(q.batch) == queueBatchSize {
+			break loop
+		}
+
+		var ok bool
+		select {
+		case qi, ok = <-q.queue:
+			if !ok {
+				break loop
+			}
+		default:
+			break loop
+		}
+	}
+
+	q.pushBatch()
+}
+
+// pushBatch pushes the events taken from the queue into the sink.
+func (q *queuedSink) pushBatch() {
+
+	if len(q.batch) == 0 {
+		return
+	}
+
+	// Errors are recorded in the sink's statistics.
+	_, _ = q.Sink.PushBatch(q.batch)
+
+	// Don't pin the events' addresses and tags until the next batch.
+	clear(q.batch)
+	q.batch = q.batch[:0]
+}
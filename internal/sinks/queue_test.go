@@ -0,0 +1,259 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/spool"
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// testSink records pushed events and the lengths of pushed batches,
+// optionally blocking until unblocked.
+type testSink struct {
+	mu      sync.Mutex
+	events  []bpf.Event
+	batches []int
+	block   chan struct{}
+	closed  bool
+}
+
+func (s *testSink) Init(types.SinkConfig) error { return nil }
+func (s *testSink) IsInit() bool                { return true }
+func (s *testSink) Name() string                { return "test" }
+func (s *testSink) WantUpdate() bool            { return true }
+func (s *testSink) WantDestroy() bool           { return true }
+func (s *testSink) Stats() types.SinkStatsData  { return types.SinkStatsData{} }
+func (s *testSink) Flush(context.Context) error { return nil }
+
+func (s *testSink) Push(e bpf.Event) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *testSink) PushBatch(es []bpf.Event) (int, error) {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(es))
+	s.mu.Unlock()
+	return types.PushEach(s.Push, es)
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *testSink) pushed() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func (s *testSink) ids() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []uint32
+	for _, e := range s.events {
+		out = append(out, e.ConnectionID)
+	}
+	return out
+}
+
+func TestQueuedSinkPolicies(t *testing.T) {
+
+	tests := []struct {
+		policy  QueuePolicy
+		dropped uint64
+		ids     []uint32
+	}{
+		// The worker holds event 1 while blocked, events 2 and 3 are queued.
+		{QueueDropNewest, 2, []uint32{1, 2, 3}},
+		{QueueDropOldest, 2, []uint32{1, 4, 5}},
+	}
+
+	for _, tt := range tests {
+		ts := &testSink{block: make(chan struct{})}
+		q := newQueuedSink(ts, 2, tt.policy, nil)
+
+		// Wait for the worker to push the first event.
+		require.NoError(t, q.Push(bpf.Event{ConnectionID: 1}))
+		require.Eventually(t, func() bool { return len(ts.pushed()) == 1 }, time.Second, time.Millisecond)
+
+		for i := uint32(2); i <= 5; i++ {
+			_ = q.Push(bpf.Event{ConnectionID: i})
+		}
+
+		assert.EqualValues(t, tt.dropped, q.Stats().QueueDropped)
+		assert.EqualValues(t, 2, q.Stats().QueueLength)
+
+		close(ts.block)
+		require.NoError(t, q.Flush(context.Background()))
+		assert.Equal(t, tt.ids, ts.ids())
+
+		require.NoError(t, q.Close())
+		assert.True(t, ts.closed)
+		assert.Equal(t, errQueueClosed, q.Push(bpf.Event{}))
+	}
+}
+
+func TestQueuedSinkBatch(t *testing.T) {
+
+	ts := &testSink{block: make(chan struct{})}
+	q := newQueuedSink(ts, 8, QueueBlock, nil)
+
+	require.NoError(t, q.Push(bpf.Event{ConnectionID: 1}))
+	require.Eventually(t, func() bool { return len(ts.pushed()) == 1 }, time.Second, time.Millisecond)
+
+	dropped, err := q.PushBatch([]bpf.Event{{ConnectionID: 2}, {ConnectionID: 3}, {ConnectionID: 4}})
+	require.NoError(t, err)
+	assert.Zero(t, dropped)
+
+	// Events queued while the sink is busy are pushed as one batch.
+	close(ts.block)
+	require.NoError(t, q.Flush(context.Background()))
+	assert.Equal(t, []uint32{1, 2, 3, 4}, ts.ids())
+	assert.Equal(t, []int{1, 3}, ts.pushed())
+
+	require.NoError(t, q.Close())
+
+	dropped, err = q.PushBatch(make([]bpf.Event, 2))
+	assert.Equal(t, errQueueClosed, err)
+	assert.Equal(t, 2, dropped)
+}
+
+func TestQueuedSinkSpill(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "conntracct-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := spool.Open(dir, 0)
+	require.NoError(t, err)
+
+	ts := &testSink{block: make(chan struct{})}
+	q := newQueuedSink(ts, 2, QueueSpill, sp)
+
+	require.NoError(t, q.Push(bpf.Event{ConnectionID: 1}))
+	require.Eventually(t, func() bool { return len(ts.pushed()) == 1 }, time.Second, time.Millisecond)
+
+	// Events 2 and 3 are queued, the others are spooled.
+	for i := uint32(2); i <= 5; i++ {
+		require.NoError(t, q.Push(bpf.Event{ConnectionID: i}))
+	}
+
+	assert.EqualValues(t, 0, q.Stats().QueueDropped)
+	assert.NotZero(t, q.Stats().SpoolBytes)
+
+	close(ts.block)
+	require.Eventually(t, func() bool { return len(ts.ids()) == 5 }, time.Second, time.Millisecond)
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5}, ts.ids())
+	assert.Zero(t, q.Stats().SpoolBytes)
+
+	require.NoError(t, q.Close())
+}
+
+// failingSink is a testSink handing the events it's pushed to its
+// DeadLetterFunc while failing, also failing its health checks.
+type failingSink struct {
+	testSink
+	deadLetter types.DeadLetter
+	failing    uint32
+	sent       uint64
+}
+
+func (s *failingSink) SetDeadLetter(f types.DeadLetterFunc) { s.deadLetter.Set(f) }
+
+func (s *failingSink) Stats() types.SinkStatsData {
+	return types.SinkStatsData{BatchesSent: atomic.LoadUint64(&s.sent)}
+}
+
+func (s *failingSink) CheckHealth(context.Context) error {
+	if atomic.LoadUint32(&s.failing) == 1 {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func (s *failingSink) Push(e bpf.Event) error {
+	_, err := s.PushBatch([]bpf.Event{e})
+	return err
+}
+
+func (s *failingSink) PushBatch(es []bpf.Event) (int, error) {
+	if atomic.LoadUint32(&s.failing) == 1 {
+		s.deadLetter.Send(s.Name(), es)
+		return 0, nil
+	}
+	atomic.AddUint64(&s.sent, 1)
+	return s.testSink.PushBatch(es)
+}
+
+func TestQueuedSinkRespool(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "conntracct-spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sp, err := spool.Open(dir, 0)
+	require.NoError(t, err)
+
+	fs := &failingSink{failing: 1}
+	q := newQueuedSink(fs, 8, QueueSpill, sp)
+
+	// Batches failing to be written go back into the spool, which isn't
+	// replayed while the sink is failing.
+	_, err = q.PushBatch([]bpf.Event{{ConnectionID: 1}, {ConnectionID: 2}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return q.Stats().SpoolBytes != 0 }, time.Second, time.Millisecond)
+	assert.True(t, q.replayHeld())
+
+	q.retryReplay()
+	assert.True(t, q.replayHeld())
+	assert.Empty(t, fs.ids())
+
+	// The replay is released once the sink passes its health check.
+	atomic.StoreUint32(&fs.failing, 0)
+	q.retryReplay()
+	q.wake()
+	require.Eventually(t, func() bool { return len(fs.ids()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []uint32{1, 2}, fs.ids())
+	assert.Zero(t, q.Stats().SpoolBytes)
+
+	// Or once the sink writes a batch.
+	q.respool("test", nil)
+	assert.True(t, q.replayHeld())
+	atomic.AddUint64(&fs.sent, 1)
+	assert.False(t, q.replayHeld())
+
+	require.NoError(t, q.Close())
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+
+	p, err := ParseQueuePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, QueueBlock, p)
+
+	p, err = ParseQueuePolicy("drop-oldest")
+	require.NoError(t, err)
+	assert.Equal(t, QueueDropOldest, p)
+
+	_, err = ParseQueuePolicy("drop-everything")
+	assert.Error(t, err)
+}
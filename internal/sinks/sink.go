@@ -1,9 +1,24 @@
 package sinks
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/internal/sinks/anonymize"
+	"github.com/ti-mo/conntracct/internal/sinks/capture"
+	"github.com/ti-mo/conntracct/internal/sinks/clickhouse"
+	"github.com/ti-mo/conntracct/internal/sinks/csvfile"
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/internal/sinks/graphite"
 	"github.com/ti-mo/conntracct/internal/sinks/influxdb"
+	"github.com/ti-mo/conntracct/internal/sinks/ipfix"
+	"github.com/ti-mo/conntracct/internal/sinks/kafka"
+	"github.com/ti-mo/conntracct/internal/sinks/kinesis"
+	"github.com/ti-mo/conntracct/internal/sinks/netflow"
+	"github.com/ti-mo/conntracct/internal/sinks/plugin"
+	"github.com/ti-mo/conntracct/internal/sinks/spool"
 	"github.com/ti-mo/conntracct/internal/sinks/stdout"
 	"github.com/ti-mo/conntracct/internal/sinks/types"
 	"github.com/ti-mo/conntracct/pkg/bpf"
@@ -26,12 +41,26 @@ type Sink interface {
 	WantUpdate() bool
 	WantDestroy() bool
 
-	// Enqueue an accounting event to the sink driver.
-	// Implementation MUST be thread-safe.
-	Push(bpf.Event)
+	// Enqueue an accounting event to the sink driver. Returns an error
+	// if the event was dropped. Implementation MUST be thread-safe.
+	Push(bpf.Event) error
+
+	// Enqueue a batch of accounting events to the sink driver, amortizing
+	// its locking over the batch. Returns the amount of events dropped and
+	// the error of the first one. The slice must not be retained after
+	// returning. Implementation MUST be thread-safe.
+	PushBatch([]bpf.Event) (int, error)
 
 	// Get a snapshot copy of the sink's performance statistics.
 	Stats() types.SinkStatsData
+
+	// Send buffered events to the sink's backing storage and wait
+	// for them to be written, or until the context is done.
+	Flush(context.Context) error
+
+	// Flush buffered events, wait for them to be written and release
+	// the sink's resources. Events pushed after calling Close are dropped.
+	Close() error
 }
 
 // New returns a new, initialized Sink based on the type of
@@ -40,9 +69,59 @@ func New(cfg types.SinkConfig) (Sink, error) {
 
 	var sink Sink
 
+	// Compile the transforms and filter, parse the queue policy and event
+	// selection and set up anonymization before initializing the sink, so no
+	// resources are allocated for a sink with an invalid config.
+	var ts []*filter.Transform
+	for i, expr := range cfg.Transform {
+		t, err := filter.NewTransform(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sink '%s': transform %d", cfg.Name, i)
+		}
+		ts = append(ts, t)
+	}
+
+	var f *filter.Filter
+	if cfg.Filter != "" {
+		var err error
+		if f, err = filter.New(cfg.Filter); err != nil {
+			return nil, errors.Wrapf(err, "sink '%s'", cfg.Name)
+		}
+	}
+
+	qp, err := ParseQueuePolicy(cfg.QueuePolicy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sink '%s'", cfg.Name)
+	}
+
+	update, destroy, err := parseEvents(cfg.Events)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sink '%s'", cfg.Name)
+	}
+
+	var anon *anonymize.Anonymizer
+	if cfg.Anonymize.Active() {
+		if anon, err = anonymize.New(cfg.Anonymize); err != nil {
+			return nil, errors.Wrapf(err, "sink '%s'", cfg.Name)
+		}
+	}
+	if qp == QueueSpill && cfg.SpoolDir == "" {
+		return nil, errors.Wrapf(errEmptySpoolDir, "sink '%s'", cfg.Name)
+	}
+
+	// Don't silently send events in plaintext when TLS is configured.
+	if cfg.TLS.Active() && !supportsTLS(cfg.Type) {
+		return nil, errors.Wrapf(errNoTLS, "sink '%s'", cfg.Name)
+	}
+
+	// Sinks writing messages in sequence or to a single file have one worker.
+	if cfg.Workers > 1 && !supportsWorkers(cfg.Type) {
+		return nil, errors.Wrapf(errNoWorkers, "sink '%s'", cfg.Name)
+	}
+
 	switch cfg.Type {
-	// InfluxDB driver handles UDP and TCP modes internally.
-	case types.InfluxUDP, types.InfluxHTTP:
+	// InfluxDB driver handles UDP, HTTP and 2.x modes internally.
+	case types.InfluxUDP, types.InfluxHTTP, types.InfluxV2:
 		idb := influxdb.New()
 		if err := idb.Init(cfg); err != nil {
 			return nil, err
@@ -55,9 +134,122 @@ func New(cfg types.SinkConfig) (Sink, error) {
 			return nil, err
 		}
 		sink = &std
+	// Kafka driver produces JSON-encoded events to a topic.
+	case types.Kafka:
+		k := kafka.New()
+		if err := k.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &k
+	// ClickHouse driver uses the native protocol with batched inserts.
+	case types.ClickHouse:
+		ch := clickhouse.New()
+		if err := ch.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &ch
+	// IPFIX driver exports flow records to a collector over UDP.
+	case types.IPFIX:
+		ipf := ipfix.New()
+		if err := ipf.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &ipf
+	// NetFlow driver exports v9 flow records for legacy collectors.
+	case types.NetFlow:
+		nf := netflow.New()
+		if err := nf.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &nf
+	// Capture driver records events to a file for replaying them later.
+	case types.Capture:
+		c := capture.New()
+		if err := c.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &c
+	// CSV driver writes events to rotated, optionally compressed files.
+	case types.CSV:
+		c := csvfile.New()
+		if err := c.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &c
+	// Graphite driver sends metrics rolled up by protocol and port over TCP.
+	case types.Graphite:
+		g := graphite.New()
+		if err := g.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &g
+	// Kinesis driver writes aggregated records to data streams and Firehose.
+	case types.Kinesis, types.Firehose:
+		k := kinesis.New()
+		if err := k.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &k
+	// Plugin driver writes events to an out-of-process sink over gRPC.
+	case types.Plugin:
+		pl := plugin.New()
+		if err := pl.Init(cfg); err != nil {
+			return nil, err
+		}
+		sink = &pl
 	default:
 		return nil, fmt.Errorf("sink type '%s' not implemented", cfg.Type)
 	}
 
+	// Open the spool holding events that didn't fit in the sink's queue,
+	// replaying events spooled by a previous instance of the sink.
+	var sp *spool.Spool
+	if qp == QueueSpill {
+		if sp, err = spool.Open(cfg.SpoolDir, int64(cfg.SpoolMaxSize)); err != nil {
+			_ = sink.Close()
+			return nil, errors.Wrapf(err, "opening spool of sink '%s'", cfg.Name)
+		}
+	}
+
+	// Give every sink its own queue, so a slow sink doesn't hold up the others.
+	sink = newQueuedSink(sink, int(cfg.QueueSize), qp, sp)
+
+	// Anonymize events before they're queued or spooled.
+	if anon != nil {
+		sink = &anonymizedSink{Sink: sink, anon: anon}
+	}
+
+	// Filter events before they're queued, on their original addresses.
+	if f != nil || !update || !destroy {
+		sink = &filteredSink{Sink: sink, filter: f, update: update, destroy: destroy}
+	}
+
+	// Transform events before filtering, so filters can select on derived tags.
+	if len(ts) != 0 {
+		sink = &transformedSink{Sink: sink, transforms: ts}
+	}
+
 	return sink, nil
 }
+
+// supportsWorkers returns true if sinks of the given type can write
+// batches using multiple send workers.
+func supportsWorkers(t types.SinkType) bool {
+	switch t {
+	case types.InfluxUDP, types.InfluxHTTP, types.InfluxV2, types.Kafka,
+		types.ClickHouse, types.Kinesis, types.Firehose, types.Plugin:
+		return true
+	}
+	return false
+}
+
+// supportsTLS returns true if sinks of the given type connect to their
+// backing storage over TCP or HTTP, and can do so using TLS.
+func supportsTLS(t types.SinkType) bool {
+	switch t {
+	case types.InfluxHTTP, types.InfluxV2, types.Kafka, types.ClickHouse,
+		types.Graphite, types.Kinesis, types.Firehose:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+package spool
+
+import "errors"
+
+var (
+	errSpoolFull = errors.New("spool is full")
+)
@@ -0,0 +1,310 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+const (
+	segmentExt = ".seg"
+
+	// File storing the read position in the first segment when closing.
+	cursorFile = "cursor"
+
+	// Size after which a segment is sealed and a new one is started.
+	defaultSegmentSize = 16 << 20
+)
+
+// Spool is an on-disk FIFO queue of events, stored in a directory as a
+// sequence of segment files holding one JSON-encoded event per line.
+// Segments are removed once all their events have been read. Events left in
+// the directory when a Spool is closed are read after reopening it. Events
+// may be read twice if the process exits without closing the Spool.
+// Safe for concurrent use.
+type Spool struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+
+	mu sync.Mutex
+
+	// IDs of the segments on disk, oldest first. The last segment
+	// is being written to if w is non-nil.
+	segments []uint64
+	size     int64
+
+	// Segment being appended to.
+	w     *os.File
+	wbuf  *bufio.Writer
+	wsize int64
+
+	// Segment being read from, always the first segment, and the amount
+	// of bytes read from it.
+	r    *os.File
+	rbuf *bufio.Scanner
+	roff int64
+}
+
+// Open opens or creates a Spool in the given directory. Appending to the
+// Spool fails when its segments take up more than maxSize bytes,
+// zero means no limit.
+func Open(dir string, maxSize int64) (*Spool, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: defaultSegmentSize,
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, id)
+		s.size += fi.Size()
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if err := s.loadCursor(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append adds an event to the end of the Spool.
+func (s *Spool) Append(e bpf.Event) error {
+
+	// The raw form keeps time stamps and numeric fields as they were read.
+	b := append(e.AppendJSON(nil, true), '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize != 0 && s.size+int64(len(b)) > s.maxSize {
+		return errSpoolFull
+	}
+
+	if s.w == nil || s.wsize >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.wbuf.Write(b)
+	s.wsize += int64(n)
+	s.size += int64(n)
+
+	return err
+}
+
+// Next removes and returns the event at the front of the Spool.
+// Returns false if the Spool is empty.
+func (s *Spool) Next() (bpf.Event, bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.r == nil {
+			if len(s.segments) == 0 {
+				return bpf.Event{}, false, nil
+			}
+
+			// Seal the segment being written to before reading it.
+			if s.w != nil && len(s.segments) == 1 {
+				if s.wsize == 0 {
+					return bpf.Event{}, false, nil
+				}
+				if err := s.seal(); err != nil {
+					return bpf.Event{}, false, err
+				}
+			}
+
+			f, err := os.Open(s.path(s.segments[0]))
+			if err != nil {
+				return bpf.Event{}, false, err
+			}
+			if _, err := f.Seek(s.roff, io.SeekStart); err != nil {
+				f.Close()
+				return bpf.Event{}, false, err
+			}
+			s.r = f
+			s.rbuf = bufio.NewScanner(f)
+			s.rbuf.Buffer(make([]byte, 64*1024), 1<<20)
+		}
+
+		if s.rbuf.Scan() {
+			line := s.rbuf.Bytes()
+			s.size -= int64(len(line) + 1)
+			s.roff += int64(len(line) + 1)
+
+			var e bpf.Event
+			if err := json.Unmarshal(line, &e); err != nil {
+				// Skip events that were only partially written, eg. on a crash.
+				continue
+			}
+			return e, true, nil
+		}
+
+		// End of the segment, remove it and move on to the next one.
+		err := s.rbuf.Err()
+		s.r.Close()
+		s.r, s.rbuf, s.roff = nil, nil, 0
+
+		if rerr := os.Remove(s.path(s.segments[0])); rerr != nil && err == nil {
+			err = rerr
+		}
+		s.segments = s.segments[1:]
+
+		if len(s.segments) == 0 {
+			s.size = 0
+		}
+
+		if err != nil {
+			return bpf.Event{}, false, err
+		}
+	}
+}
+
+// Empty returns true if there are no events in the Spool.
+func (s *Spool) Empty() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size <= 0
+}
+
+// Size returns the amount of bytes taken up by the Spool's events.
+func (s *Spool) Size() int64 {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+// Close writes all buffered events and the read position to disk
+// and closes the Spool's segments.
+func (s *Spool) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r != nil {
+		s.r.Close()
+		s.r, s.rbuf = nil, nil
+	}
+
+	if err := s.seal(); err != nil {
+		return err
+	}
+
+	if s.roff == 0 || len(s.segments) == 0 {
+		return nil
+	}
+
+	c := fmt.Sprintf("%d %d\n", s.segments[0], s.roff)
+	return ioutil.WriteFile(filepath.Join(s.dir, cursorFile), []byte(c), 0600)
+}
+
+// loadCursor restores the read position saved by Close, and removes it
+// so a crash doesn't cause events to be skipped.
+func (s *Spool) loadCursor() error {
+
+	p := filepath.Join(s.dir, cursorFile)
+
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var id uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &id, &off); err == nil &&
+		len(s.segments) != 0 && s.segments[0] == id {
+		s.roff = off
+		s.size -= off
+	}
+
+	return os.Remove(p)
+}
+
+// rotate seals the segment being written to and starts a new one.
+// mu must be held.
+func (s *Spool) rotate() error {
+
+	if err := s.seal(); err != nil {
+		return err
+	}
+
+	var id uint64
+	if len(s.segments) != 0 {
+		id = s.segments[len(s.segments)-1] + 1
+	}
+
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, id)
+	s.w = f
+	s.wbuf = bufio.NewWriter(f)
+	s.wsize = 0
+
+	return nil
+}
+
+// seal flushes and closes the segment being written to, if any.
+// mu must be held.
+func (s *Spool) seal() error {
+
+	if s.w == nil {
+		return nil
+	}
+
+	err := s.wbuf.Flush()
+	if cerr := s.w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	s.w, s.wbuf = nil, nil
+
+	return err
+}
+
+// path returns the path of the segment with the given id.
+func (s *Spool) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
@@ -0,0 +1,77 @@
+package spool
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestSpool(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0)
+	require.NoError(t, err)
+
+	// Rotate after every event.
+	s.segmentSize = 1
+
+	e := bpf.Event{SrcAddr: net.ParseIP("192.0.2.1"), DstAddr: net.ParseIP("2001:db8::1")}
+	e.SetTag("pod", "web")
+
+	for i := uint32(1); i <= 3; i++ {
+		e.ConnectionID = i
+		require.NoError(t, s.Append(e))
+	}
+	assert.False(t, s.Empty())
+
+	got, ok, err := s.Next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.EqualValues(t, 1, got.ConnectionID)
+	assert.Equal(t, "web", got.Tags["pod"])
+	assert.True(t, got.DstAddr.Equal(e.DstAddr))
+
+	// Remaining events are read again after reopening.
+	require.NoError(t, s.Close())
+	s, err = Open(dir, 0)
+	require.NoError(t, err)
+
+	for _, id := range []uint32{2, 3} {
+		got, ok, err := s.Next()
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, id, got.ConnectionID)
+	}
+
+	_, ok, err = s.Next()
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, s.Empty())
+
+	fis, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, fis, "read segments are removed")
+	require.NoError(t, s.Close())
+}
+
+func TestSpoolFull(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 10)
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, errSpoolFull, s.Append(bpf.Event{}))
+}
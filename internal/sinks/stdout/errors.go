@@ -5,4 +5,6 @@ import "errors"
 var (
 	errEmptySinkName   = errors.New("empty sink name")
 	errInvalidSinkType = errors.New("invalid sink type")
+	errSinkClosed      = errors.New("sink is closed")
+	errEventDropped    = errors.New("event channel full, event dropped")
 )
@@ -2,7 +2,10 @@ package stdout
 
 import (
 	"bufio"
+	"context"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"github.com/ti-mo/conntracct/internal/sinks/types"
 	"github.com/ti-mo/conntracct/pkg/bpf"
@@ -22,12 +25,29 @@ type StdOut struct {
 
 	// Internal buffered event channel. BatchLength configuration parameter
 	// is used as the buffer size of the channel.
-	events chan bpf.Event
+	events chan outEvent
+
+	// Set when the sink is closed, events pushed afterwards are dropped.
+	mu     sync.RWMutex
+	closed bool
+
+	// Set when the sink only receives dead-lettered events. Accessed atomically.
+	deadLetterOnly uint32
+
+	// Tracks the output worker, waited on by Close.
+	wg sync.WaitGroup
 
 	// Stdout/err writer.
 	writer *bufio.Writer
 }
 
+// outEvent is an event to be written by the output worker. deadLetter holds
+// the name of the sink that failed to deliver the event, if any.
+type outEvent struct {
+	bpf.Event
+	deadLetter string
+}
+
 // New returns a new StdOut.
 func New() StdOut {
 	return StdOut{}
@@ -55,9 +75,10 @@ func (s *StdOut) Init(sc types.SinkConfig) error {
 		return errInvalidSinkType
 	}
 
-	s.events = make(chan bpf.Event, sc.BatchSize)
+	s.events = make(chan outEvent, sc.BatchSize)
 	s.config = sc
 
+	s.wg.Add(1)
 	go s.outWorker()
 
 	// Mark the sink as initialized.
@@ -67,15 +88,81 @@ func (s *StdOut) Init(sc types.SinkConfig) error {
 }
 
 // Push an accounting event into the buffer of the StdOut accounting sink.
-func (s *StdOut) Push(e bpf.Event) {
+func (s *StdOut) Push(e bpf.Event) error {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
 	// Non-blocking send on event channel.
 	select {
-	case s.events <- e:
+	case s.events <- outEvent{Event: e}:
 		s.stats.IncrEventsPushed()
 		s.stats.SetBatchLength(len(s.events))
 	default:
 		s.stats.IncrEventsDropped()
+		return errEventDropped
 	}
+
+	return nil
+}
+
+// PushBatch pushes a batch of accounting events into the StdOut sink.
+func (s *StdOut) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
+
+// PushDeadLetter writes events the named sink failed to deliver, marked
+// with the sink's name. Blocks until all events are buffered.
+func (s *StdOut) PushDeadLetter(sink string, events []bpf.Event) error {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		s.stats.IncrEventsDropped()
+		return errSinkClosed
+	}
+
+	for _, e := range events {
+		s.events <- outEvent{Event: e, deadLetter: sink}
+		s.stats.IncrEventsPushed()
+	}
+
+	return nil
+}
+
+// SetDeadLetterOnly sets whether the sink only receives dead-lettered events,
+// or also events from the pipeline.
+func (s *StdOut) SetDeadLetterOnly(only bool) {
+	var v uint32
+	if only {
+		v = 1
+	}
+	atomic.StoreUint32(&s.deadLetterOnly, v)
+}
+
+// Flush is a no-op, events are written as soon as the output worker
+// receives them.
+func (s *StdOut) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close waits for all buffered events to be written. Must only be called once.
+func (s *StdOut) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return nil
 }
 
 // Name gets the name of the StdOut.
@@ -88,14 +175,15 @@ func (s *StdOut) IsInit() bool {
 	return s.init
 }
 
-// WantUpdate always returns true.
+// WantUpdate returns true unless the sink only receives dead-lettered events.
 func (s *StdOut) WantUpdate() bool {
-	return true
+	return atomic.LoadUint32(&s.deadLetterOnly) == 0
 }
 
-// WantDestroy always returns true, StdOut receives destroy events. (flow totals)
+// WantDestroy returns true unless the sink only receives dead-lettered events,
+// StdOut receives destroy events. (flow totals)
 func (s *StdOut) WantDestroy() bool {
-	return true
+	return atomic.LoadUint32(&s.deadLetterOnly) == 0
 }
 
 // Stats returns the StdOut's statistics structure.
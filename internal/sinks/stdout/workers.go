@@ -1,26 +1,36 @@
 package stdout
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
 // outWorker receives events from the sink's event channel
-// and prints them to stdout/stderr.
+// and prints them to stdout/stderr, prefixed with their event type.
+// Dead-lettered events are prefixed with the name of the sink that failed
+// to deliver them. Exits when the event channel is closed.
 func (s *StdOut) outWorker() {
 
-	for {
+	defer s.wg.Done()
 
-		e := <-s.events
+	for e := range s.events {
 
-		if _, err := s.writer.WriteString(e.String() + "\n"); err != nil {
-			s.stats.IncrBatchDropped()
-			log.Errorf("StdOut sink '%s': error writing: %s", s.config.Name, err)
-			continue
+		line := "event_type=" + e.Type() + " " + e.String()
+		if e.deadLetter != "" {
+			line = "dead_letter=" + e.deadLetter + " " + line
 		}
 
-		if err := s.writer.Flush(); err != nil {
+		start := time.Now()
+		_, err := s.writer.WriteString(line + "\n")
+		if err == nil {
+			err = s.writer.Flush()
+		}
+		s.stats.ObserveFlush(time.Since(start), 1, err)
+
+		if err != nil {
 			s.stats.IncrBatchDropped()
-			log.Errorf("StdOut sink '%s': error flushing writer: %s", s.config.Name, err)
+			log.Errorf("StdOut sink '%s': error writing: %s", s.config.Name, err)
 			continue
 		}
 
@@ -0,0 +1,69 @@
+package sinks
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// transformedSink wraps a Sink, applying the sink's transform expressions
+// to events in order before pushing them.
+type transformedSink struct {
+	Sink
+
+	transforms []*filter.Transform
+}
+
+// Push an accounting event into the underlying sink after applying the
+// transforms, unless one of them drops it.
+func (s *transformedSink) Push(e bpf.Event) error {
+
+	e, ok := s.apply(e)
+	if !ok {
+		return nil
+	}
+
+	return s.Sink.Push(e)
+}
+
+// PushBatch transforms a batch of accounting events and pushes the events
+// that weren't dropped into the underlying sink as a single batch.
+func (s *transformedSink) PushBatch(es []bpf.Event) (int, error) {
+
+	// Batches may be shared with other sinks, don't transform them in place.
+	out := make([]bpf.Event, 0, len(es))
+	for _, e := range es {
+		if e, ok := s.apply(e); ok {
+			out = append(out, e)
+		}
+	}
+
+	if len(out) == 0 {
+		return 0, nil
+	}
+
+	return s.Sink.PushBatch(out)
+}
+
+// apply applies the transforms to an event in order, returning the
+// transformed event or false if one of them drops it. Transforms failing
+// to evaluate leave the event unchanged.
+func (s *transformedSink) apply(e bpf.Event) (bpf.Event, bool) {
+
+	for i, t := range s.transforms {
+		var (
+			ok  bool
+			err error
+		)
+		e, ok, err = t.Apply(e)
+		if err != nil {
+			log.Debugf("Sink '%s': error evaluating transform %d: %s", s.Name(), i, err)
+		}
+		if !ok {
+			return e, false
+		}
+	}
+
+	return e, true
+}
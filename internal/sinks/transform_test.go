@@ -0,0 +1,37 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/internal/sinks/filter"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestTransformedSinkPushBatch(t *testing.T) {
+
+	tf, err := filter.NewTransform(`conn_id == 2 ? false : {"site": "ams"}`)
+	require.NoError(t, err)
+
+	ts := &testSink{}
+	s := &transformedSink{Sink: ts, transforms: []*filter.Transform{tf}}
+
+	es := []bpf.Event{{ConnectionID: 1}, {ConnectionID: 2}, {ConnectionID: 3}}
+	dropped, err := s.PushBatch(es)
+	require.NoError(t, err)
+	assert.Zero(t, dropped)
+
+	// The transformed events are pushed as one batch, the pushed batch
+	// is left unchanged.
+	assert.Equal(t, []int{2}, ts.pushed())
+	assert.Equal(t, []uint32{1, 3}, ts.ids())
+	assert.Equal(t, map[string]string{"site": "ams"}, ts.events[0].Tags)
+	assert.Nil(t, es[0].Tags)
+
+	// Batches dropped entirely aren't pushed.
+	_, err = s.PushBatch([]bpf.Event{{ConnectionID: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, ts.pushed())
+}
@@ -0,0 +1,24 @@
+package types
+
+// AnonymizeConfig configures the anonymization of the addresses of the
+// events pushed to a sink.
+type AnonymizeConfig struct {
+
+	// Either hash, replacing the host bits of addresses with a keyed hash
+	// of the address, or truncate, zeroing them. Empty disables anonymization.
+	Mode string `mapstructure:"mode"`
+
+	// Hex-encoded 128-bit SipHash key, only for mode hash. Addresses hash to
+	// the same value across restarts and sinks sharing the key.
+	Key string `mapstructure:"key"`
+
+	// Amount of leading bits of IPv4 and IPv6 addresses kept. Defaults to
+	// /24 and /48 for truncate, and to hashing whole addresses for hash.
+	IPv4Prefix uint8 `mapstructure:"ipv4Prefix"`
+	IPv6Prefix uint8 `mapstructure:"ipv6Prefix"`
+}
+
+// Active returns true if the sink's addresses are anonymized.
+func (a AnonymizeConfig) Active() bool {
+	return a.Mode != ""
+}
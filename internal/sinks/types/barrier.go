@@ -0,0 +1,39 @@
+package types
+
+import "sync"
+
+// Barrier lets a sink wait for all batches handed to its send workers
+// before the barrier was. A barrier is sent to each worker, which blocks on
+// it until all workers have received it, so every worker receives exactly
+// one, after finishing the batches it took before.
+type Barrier struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewBarrier returns a Barrier for the given amount of workers.
+func NewBarrier(workers int) *Barrier {
+
+	b := &Barrier{done: make(chan struct{})}
+	b.wg.Add(workers)
+
+	go func() {
+		b.wg.Wait()
+		close(b.done)
+	}()
+
+	return b
+}
+
+// Wait is called by a worker receiving the barrier. Blocks until
+// all workers have received it.
+func (b *Barrier) Wait() {
+	b.wg.Done()
+	<-b.done
+}
+
+// Done returns a channel that's closed when all workers have received
+// the barrier.
+func (b *Barrier) Done() <-chan struct{} {
+	return b.done
+}
@@ -0,0 +1,279 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// ErrSinkClosed is returned when pushing to or flushing a closed sink.
+var ErrSinkClosed = errors.New("sink is closed")
+
+// Batcher holds the current batch of a batching sink, of type B, and hands
+// batches to the sink's send workers when they reach the sink's Watermark,
+// every FlushInterval so batches don't grow old during quiet periods, and
+// when the sink is flushed or closed.
+//
+// Batcher is safe for concurrent use. Sinks adding to the current batch hold
+// its lock, taken with Lock.
+type Batcher[B any] struct {
+	workers  int
+	interval time.Duration
+
+	// Allocates a new batch for the given watermark, and writes a batch
+	// with its events if dead-lettering is enabled.
+	newBatch func(watermark int) B
+	write    func(b B, events []bpf.Event) error
+
+	stats      *SinkStats
+	deadLetter *DeadLetter
+
+	// Current batch and the amount of events in it. Set closed when the
+	// sink is closed, events pushed afterwards are dropped.
+	mu     sync.Mutex
+	batch  B
+	n      int
+	closed bool
+
+	// Batch length at which the batch is sent.
+	watermark Watermark
+
+	// Events in the batch, only kept if dead-lettering is enabled.
+	events []bpf.Event
+
+	// Channel the send workers receive batches on.
+	sendChan chan batchReq[B]
+
+	// Closed by Close to stop the tick worker.
+	done chan struct{}
+
+	// Tracks the send workers, waited on by Close.
+	wg sync.WaitGroup
+}
+
+// batchReq is a batch to be written by a send worker. If done is set, the
+// result of the write is sent on it. Requests holding a barrier hold
+// nothing else.
+type batchReq[B any] struct {
+	batch  B
+	n      int
+	events []bpf.Event
+	done   chan error
+
+	barrier *Barrier
+}
+
+// NewBatcher returns a Batcher for a sink configured by sc, whose BatchSize,
+// FlushInterval and Workers must be set. Its workers are started by Start.
+// newBatch allocates an empty batch for the current watermark. write is
+// called by the send workers with every non-empty batch and its events if
+// dead-lettering is enabled in dl. Statistics about batches are kept in stats.
+func NewBatcher[B any](sc SinkConfig, stats *SinkStats, dl *DeadLetter,
+	newBatch func(watermark int) B, write func(b B, events []bpf.Event) error) (*Batcher[B], error) {
+
+	wm, err := NewWatermark(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Batcher[B]{
+		workers:    int(sc.Workers),
+		interval:   sc.FlushInterval,
+		newBatch:   newBatch,
+		write:      write,
+		stats:      stats,
+		deadLetter: dl,
+		watermark:  wm,
+		sendChan:   make(chan batchReq[B], 64),
+		done:       make(chan struct{}),
+	}
+	b.reset() // initial empty batch
+
+	return b, nil
+}
+
+// Start starts the Batcher's send workers and the worker sending the current
+// batch every FlushInterval. Must be called once before adding events.
+func (b *Batcher[B]) Start() {
+
+	b.wg.Add(b.workers)
+	for i := 0; i < b.workers; i++ {
+		go b.sendWorker()
+	}
+	go b.tickWorker()
+}
+
+// Lock locks the current batch.
+func (b *Batcher[B]) Lock() {
+	b.mu.Lock()
+}
+
+// Unlock unlocks the current batch.
+func (b *Batcher[B]) Unlock() {
+	b.mu.Unlock()
+}
+
+// Closed returns true if the sink was closed. The lock must be held.
+func (b *Batcher[B]) Closed() bool {
+	return b.closed
+}
+
+// Batch returns the current batch, for adding an event to it. The lock
+// must be held, the batch is only valid until Added or Send is called.
+func (b *Batcher[B]) Batch() *B {
+	return &b.batch
+}
+
+// Added is called after adding event e to the current batch, sending the
+// batch when it reaches the watermark. The lock must be held.
+func (b *Batcher[B]) Added(e bpf.Event) {
+
+	if b.deadLetter.Enabled() {
+		b.events = append(b.events, e)
+	}
+	b.n++
+
+	// Record statistics.
+	b.stats.SetBatchLength(b.n)
+	b.stats.IncrEventsPushed()
+
+	// Flush the batch when the watermark is reached.
+	if b.watermark.Reached(b.n) {
+		b.Send()
+	}
+}
+
+// Len returns the amount of events in the current batch. The lock must be held.
+func (b *Batcher[B]) Len() int {
+	return b.n
+}
+
+// MaxLen returns the largest amount of events a batch can hold when sent
+// on reaching the watermark.
+func (b *Batcher[B]) MaxLen() int {
+	return b.watermark.Max()
+}
+
+// Send sends the current batch to the send workers if it's not empty.
+// The lock must be held.
+func (b *Batcher[B]) Send() {
+	if b.closed || b.n == 0 {
+		return
+	}
+
+	b.sendChan <- batchReq[B]{batch: b.batch, n: b.n, events: b.events}
+	b.reset()
+}
+
+// Flush sends the current batch to the send workers and waits until it and
+// all batches queued before it are written, or until ctx is done. Returns
+// the error of writing the current batch.
+func (b *Batcher[B]) Flush(ctx context.Context) error {
+
+	done := make(chan error, 1)
+
+	b.mu.Lock()
+
+	if b.closed {
+		b.mu.Unlock()
+		return ErrSinkClosed
+	}
+
+	// The batch is enqueued even if it's empty. It's followed by a barrier,
+	// so all batches queued before it are written when the barrier is passed.
+	b.sendChan <- batchReq[B]{batch: b.batch, n: b.n, events: b.events, done: done}
+	b.reset()
+
+	br := NewBarrier(b.workers)
+	for i := 0; i < b.workers; i++ {
+		b.sendChan <- batchReq[B]{barrier: br}
+	}
+
+	b.mu.Unlock()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-br.Done():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the current batch and waits for all pending batches to be
+// written. Events pushed afterwards are dropped. Must only be called once.
+func (b *Batcher[B]) Close() {
+
+	close(b.done)
+
+	b.mu.Lock()
+	b.Send()
+	b.closed = true
+	close(b.sendChan)
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
+
+// reset makes a new, empty batch the current batch.
+func (b *Batcher[B]) reset() {
+	b.watermark.Observe(b.n)
+	b.stats.SetBatchWatermark(b.watermark.Value())
+
+	b.events = nil
+	b.n = 0
+	b.batch = b.newBatch(b.watermark.Value())
+}
+
+// sendWorker receives batches from the send channel and writes them.
+// Barriers block the worker until all of the sink's workers received them.
+// Exits when the send channel is closed.
+func (b *Batcher[B]) sendWorker() {
+
+	defer b.wg.Done()
+
+	for r := range b.sendChan {
+		if r.barrier != nil {
+			r.barrier.Wait()
+			continue
+		}
+
+		// Empty batches are not written.
+		var err error
+		if r.n != 0 {
+			err = b.write(r.batch, r.events)
+		}
+		if r.done != nil {
+			r.done <- err
+		}
+	}
+}
+
+// tickWorker sends the current batch every FlushInterval, bounding the age
+// of a batch during quiet periods. Empty batches are not sent. Exits when
+// the sink is closed.
+func (b *Batcher[B]) tickWorker() {
+
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			b.mu.Lock()
+			b.Send()
+			b.mu.Unlock()
+		case <-b.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestBatcher(t *testing.T) {
+
+	var (
+		mu      sync.Mutex
+		batches [][]uint32
+		events  [][]bpf.Event
+		fail    error
+	)
+
+	write := func(b []uint32, es []bpf.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, b)
+		events = append(events, es)
+		return fail
+	}
+	newBatch := func(wm int) []uint32 {
+		return make([]uint32, 0, wm)
+	}
+
+	// add adds an event to the current batch.
+	add := func(b *Batcher[[]uint32], id uint32) {
+		b.Lock()
+		batch := b.Batch()
+		*batch = append(*batch, id)
+		b.Added(bpf.Event{ConnectionID: id})
+		b.Unlock()
+	}
+
+	var (
+		stats SinkStats
+		dl    DeadLetter
+	)
+	dl.Set(func(string, []bpf.Event) {})
+
+	_, err := NewBatcher(SinkConfig{BatchSize: 2, BatchSizeMin: 3, BatchSizeMax: 1}, &stats, &dl, newBatch, write)
+	assert.Equal(t, errBatchSizeRange, err)
+
+	b, err := NewBatcher(SinkConfig{BatchSize: 2, FlushInterval: time.Hour, Workers: 2}, &stats, &dl, newBatch, write)
+	require.NoError(t, err)
+	assert.Equal(t, 2, b.MaxLen())
+	b.Start()
+
+	// The batch is sent when reaching the watermark.
+	add(b, 1)
+	add(b, 2)
+	add(b, 3)
+
+	// Flushing sends the current batch, waiting for all batches to be
+	// written, and returns the error of writing it.
+	fail = errors.New("write failed")
+	assert.Equal(t, fail, b.Flush(context.Background()))
+
+	mu.Lock()
+	assert.ElementsMatch(t, [][]uint32{{1, 2}, {3}}, batches)
+	assert.ElementsMatch(t, [][]bpf.Event{
+		{{ConnectionID: 1}, {ConnectionID: 2}},
+		{{ConnectionID: 3}},
+	}, events)
+	fail = nil
+	mu.Unlock()
+
+	// Empty batches are not written.
+	require.NoError(t, b.Flush(context.Background()))
+	assert.Len(t, batches, 2)
+
+	// Closing sends the current batch.
+	add(b, 4)
+	b.Close()
+	assert.Len(t, batches, 3)
+	assert.Equal(t, []uint32{4}, batches[2])
+
+	b.Lock()
+	assert.True(t, b.Closed())
+	b.Unlock()
+	assert.Equal(t, ErrSinkClosed, b.Flush(context.Background()))
+
+	assert.EqualValues(t, 4, stats.Get().EventsPushed)
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"sync/atomic"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// DeadLetterFunc receives the events the named sink failed to deliver.
+type DeadLetterFunc func(sink string, events []bpf.Event)
+
+// DeadLetter is an embeddable struct holding a sink's DeadLetterFunc.
+// Safe for concurrent use.
+type DeadLetter struct {
+	fn atomic.Value
+}
+
+// Set sets the function receiving undelivered events. A nil f disables
+// dead-lettering.
+func (d *DeadLetter) Set(f DeadLetterFunc) {
+	d.fn.Store(f)
+}
+
+// Enabled returns true if a DeadLetterFunc is set.
+func (d *DeadLetter) Enabled() bool {
+	f, _ := d.fn.Load().(DeadLetterFunc)
+	return f != nil
+}
+
+// Send hands the events the named sink failed to deliver
+// to the DeadLetterFunc, if any.
+func (d *DeadLetter) Send(sink string, events []bpf.Event) {
+	if f, _ := d.fn.Load().(DeadLetterFunc); f != nil && len(events) != 0 {
+		f(sink, events)
+	}
+}
@@ -0,0 +1,25 @@
+package types
+
+import "github.com/ti-mo/conntracct/pkg/bpf"
+
+// PushEach pushes a batch of events one at a time using push, for sinks that
+// don't batch events. Returns the amount of events dropped and the error of
+// the first one dropped.
+func PushEach(push func(bpf.Event) error, es []bpf.Event) (int, error) {
+
+	var (
+		dropped int
+		first   error
+	)
+
+	for _, e := range es {
+		if err := push(e); err != nil {
+			if first == nil {
+				first = err
+			}
+			dropped++
+		}
+	}
+
+	return dropped, first
+}
@@ -2,7 +2,9 @@ package types
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -14,6 +16,12 @@ type SinkConfig struct {
 	// Flush batch when it holds this many points.
 	BatchSize uint32 `mapstructure:"batchSize"`
 
+	// Bounds of the batch size of batching sinks adapting it to their event
+	// rate, starting at BatchSize. The batch size is static if BatchSizeMax
+	// is zero, BatchSizeMin defaults to 1.
+	BatchSizeMin uint32 `mapstructure:"batchSizeMin"`
+	BatchSizeMax uint32 `mapstructure:"batchSizeMax"`
+
 	// Maximum network payload size, only for UDP-based sinks.
 	UDPPayloadSize uint16 `mapstructure:"udpPayloadSize"`
 
@@ -23,12 +31,81 @@ type SinkConfig struct {
 	// Whether or not the sink should receive the flows' source ports.
 	EnableSrcPort bool `mapstructure:"enableSrcPort"`
 
+	// Whether or not the sink should receive the flows' address family.
+	EnableFamily bool `mapstructure:"enableFamily"`
+
 	// Name of the sink.
 	Name string `mapstructure:"-"`
 
+	// Expression selecting the events pushed to the sink, eg. 'proto == 6'.
+	// All events are pushed when empty.
+	Filter string `mapstructure:"filter"`
+
+	// Kinds of events pushed to the sink: all (default), update or destroy.
+	// Every sink writes the type of its events, so destroy events can be
+	// told apart as the flows' final totals.
+	Events string `mapstructure:"events"`
+
+	// Expressions computing tags of the events pushed to the sink or
+	// dropping them, applied in order before Filter.
+	Transform []string `mapstructure:"transform"`
+
+	// Anonymization of the addresses of events pushed to the sink, applied
+	// after Filter.
+	Anonymize AnonymizeConfig `mapstructure:"anonymize"`
+
+	// Amount of events buffered in front of the sink.
+	QueueSize uint32 `mapstructure:"queueSize"`
+
+	// What to do when the sink's queue is full: block, drop-newest,
+	// drop-oldest or spill.
+	QueuePolicy string `mapstructure:"queuePolicy"`
+
+	// Directory of the on-disk overflow queue of the sink, for queue policy spill.
+	// Must not be shared with other sinks.
+	SpoolDir string `mapstructure:"spoolDir"`
+
+	// Maximum size in bytes of the sink's spool, zero means no limit.
+	SpoolMaxSize uint64 `mapstructure:"spoolMaxSize"`
+
+	// Name of the sink receiving events this sink failed to deliver,
+	// only for batching sinks. Must be a stdout or stderr sink, which then
+	// stops receiving events from the pipeline.
+	DeadLetter string `mapstructure:"deadLetter"`
+
+	// Names of the sinks taking over from this sink when it fails its health
+	// checks, in order of preference. Events are pushed to the first healthy
+	// sink of the group, standby sinks don't receive events otherwise.
+	Standby []string `mapstructure:"standby"`
+
+	// Interval between health checks of the sink and its standbys.
+	HealthInterval time.Duration `mapstructure:"healthInterval"`
+
+	// Amount of consecutive failed or passed health checks before a sink of
+	// a failover group is considered down or up again.
+	HealthThreshold uint8 `mapstructure:"healthThreshold"`
+
 	// Target address of the sink's backing storage.
 	Address string `mapstructure:"address"`
 
+	// Executable started as the sink's plugin, only for plugin sinks. The
+	// plugin at Address is used instead when empty.
+	Command string `mapstructure:"command"`
+	// Arguments passed to Command.
+	Args []string `mapstructure:"args"`
+	// Options passed to the plugin when it's configured, only for plugin sinks.
+	Options map[string]string `mapstructure:"options"`
+	// File written by the sink, only for file-based sinks.
+	Path string `mapstructure:"path"`
+
+	// Rotate the sink's file on multiples of this interval, eg. at midnight
+	// UTC for 24h, only for rotated file-based sinks.
+	RotateInterval time.Duration `mapstructure:"rotateInterval"`
+
+	// Rotate the sink's file when it holds this many bytes, only for rotated
+	// file-based sinks.
+	RotateSize uint64 `mapstructure:"rotateSize"`
+
 	// Username of the sink's backing storage.
 	Username string `mapstructure:"username"`
 
@@ -37,23 +114,112 @@ type SinkConfig struct {
 
 	// Write timeout of the sink's backing storage.
 	Timeout time.Duration `mapstructure:"timeout"`
+
+	// TLS settings of the connection to the sink's backing storage,
+	// only for TCP- and HTTP-based sinks.
+	TLS TLSConfig `mapstructure:"tls"`
+
+	// API token used to authenticate against the sink's backing storage.
+	Token string `mapstructure:"token"`
+
+	// Organization owning the bucket, only for InfluxDB 2.x.
+	Org string `mapstructure:"org"`
+
+	// Bucket to write events to, only for InfluxDB 2.x.
+	Bucket string `mapstructure:"bucket"`
+
+	// Measurement points are written to, only for InfluxDB sinks.
+	Measurement string `mapstructure:"measurement"`
+
+	// Tags of the event written to points, all tags when empty. Mutually
+	// exclusive with ExcludeTags. Includes tags set by enrichers.
+	IncludeTags []string `mapstructure:"includeTags"`
+
+	// Tags of the event not written to points.
+	ExcludeTags []string `mapstructure:"excludeTags"`
+
+	// Static tags added to every point, eg. the name of the site. Take
+	// precedence over the tags of the event. Keys are lower-cased.
+	ExtraTags map[string]string `mapstructure:"extraTags"`
+
+	// Compress request bodies with gzip, only for HTTP-based sinks.
+	// Compress rotated files for rotated file-based sinks.
+	Gzip bool `mapstructure:"gzip"`
+
+	// Amount of times a failed write is retried, only for HTTP-based sinks.
+	MaxRetries uint8 `mapstructure:"maxRetries"`
+
+	// Initial wait time between retries, doubled on every attempt.
+	RetryBackoff time.Duration `mapstructure:"retryBackoff"`
+
+	// Topic to produce events to, only for message queue sinks.
+	// Name of the data stream or delivery stream for Kinesis sinks.
+	Topic string `mapstructure:"topic"`
+
+	// AWS region of the stream, only for Kinesis sinks.
+	// Taken from AWS_REGION or AWS_DEFAULT_REGION if empty.
+	Region string `mapstructure:"region"`
+
+	// Encoding of produced events, only for message queue sinks. Either json
+	// (default) or protobuf, a conntracct.v1.Event as defined in
+	// proto/conntracct/v1/event.proto. Avro is not supported.
+	Encoding string `mapstructure:"encoding"`
+
+	// Database of the sink's backing storage.
+	Database string `mapstructure:"database"`
+
+	// Table to insert events into, only for SQL-based sinks. Optionally
+	// qualified by a database, eg. 'db.table'.
+	Table string `mapstructure:"table"`
+
+	// Columns of the table to fill, only for SQL-based sinks.
+	Columns []string `mapstructure:"columns"`
+
+	// Observation domain (source) ID of exported flow records, only for flow export sinks.
+	DomainID uint32 `mapstructure:"domainId"`
+
+	// Interval at which templates are resent to the collector, only for flow export sinks.
+	TemplateRefresh time.Duration `mapstructure:"templateRefresh"`
+
+	// Prefix of metric names, only for metric sinks.
+	Prefix string `mapstructure:"prefix"`
+
+	// Maximum age of a batch before it's sent, regardless of BatchSize.
+	// Interval at which aggregated metrics are sent for metric sinks.
+	FlushInterval time.Duration `mapstructure:"flushInterval"`
+
+	// Amount of goroutines writing batches concurrently, only for InfluxDB,
+	// Kafka, ClickHouse, Kinesis and plugin sinks. Batches may be written
+	// out of order.
+	Workers uint8 `mapstructure:"workers"`
 }
 
 // DecodeSinkConfigMap extracts a map of SinkConfigs from configuration data.
 // The value of the string map is expected to be a nested string-map-interface
-// with the annotated fields of a SinkConfig.
+// with the annotated fields of a SinkConfig. Every sink needs a type, unknown
+// keys are rejected so typos don't silently fall back to defaults. Any number
+// of sinks can share a type, but not a spool directory or file. SinkConfigs
+// are returned sorted by name.
 func DecodeSinkConfigMap(cfg map[string]interface{}) ([]SinkConfig, error) {
 
 	out := make([]SinkConfig, 0, len(cfg))
 
 	for name, params := range cfg {
+		if !hasType(params) {
+			return nil, fmt.Errorf("sink '%s': missing type", name)
+		}
+
 		sc := SinkConfig{
 			Name: name, // ignored by mapstructure, use map key as name
 		}
 
 		d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-			DecodeHook: stringToSinkTypeHookFunc(), // decode strings to SinkTypes
-			Result:     &sc,                        // destination struct of decode operation
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				stringToSinkTypeHookFunc(),                  // decode strings to SinkTypes
+				mapstructure.StringToTimeDurationHookFunc(), // decode strings like '5s' to Durations
+			),
+			ErrorUnused: true, // reject unknown keys
+			Result:      &sc,  // destination struct of decode operation
 		})
 		if err != nil {
 			panic(err)
@@ -61,15 +227,64 @@ func DecodeSinkConfigMap(cfg map[string]interface{}) ([]SinkConfig, error) {
 
 		// Decode sink configuration map into SinkConfig.
 		if err := d.Decode(params); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("sink '%s': %v", name, err)
 		}
 
 		out = append(out, sc)
 	}
 
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	if err := checkShared(out); err != nil {
+		return nil, err
+	}
+
 	return out, nil
 }
 
+// checkShared returns an error if sinks share a spool directory or a file,
+// eg. after copying a sink's configuration for a second instance.
+func checkShared(scs []SinkConfig) error {
+
+	spools := make(map[string]string)
+	paths := make(map[string]string)
+
+	for _, sc := range scs {
+		if sc.SpoolDir != "" {
+			d := filepath.Clean(sc.SpoolDir)
+			if other, ok := spools[d]; ok {
+				return fmt.Errorf("sinks '%s' and '%s': shared spoolDir '%s'", other, sc.Name, sc.SpoolDir)
+			}
+			spools[d] = sc.Name
+		}
+
+		if sc.Path != "" {
+			p := filepath.Clean(sc.Path)
+			if other, ok := paths[p]; ok {
+				return fmt.Errorf("sinks '%s' and '%s': shared path '%s'", other, sc.Name, sc.Path)
+			}
+			paths[p] = sc.Name
+		}
+	}
+
+	return nil
+}
+
+// hasType returns true if the given sink parameters contain a type key.
+func hasType(params interface{}) bool {
+
+	switch m := params.(type) {
+	case map[string]interface{}:
+		_, ok := m["type"]
+		return ok
+	case map[interface{}]interface{}:
+		_, ok := m["type"]
+		return ok
+	}
+
+	return false
+}
+
 // stringToSinkTypeHookFunc returns a mapstructure.DecodeHookFunc that converts
 // strings to SinkTypes.
 func stringToSinkTypeHookFunc() mapstructure.DecodeHookFunc {
@@ -93,8 +308,30 @@ func stringToSinkTypeHookFunc() mapstructure.DecodeHookFunc {
 			return InfluxUDP, nil
 		case "influxdb-http":
 			return InfluxHTTP, nil
+		case "influxdb-v2", "influxdb2":
+			return InfluxV2, nil
 		case "elastic", "elasticsearch":
 			return Elastic, nil
+		case "kafka":
+			return Kafka, nil
+		case "clickhouse":
+			return ClickHouse, nil
+		case "ipfix":
+			return IPFIX, nil
+		case "netflow", "netflow-v9":
+			return NetFlow, nil
+		case "capture":
+			return Capture, nil
+		case "graphite":
+			return Graphite, nil
+		case "kinesis":
+			return Kinesis, nil
+		case "firehose", "kinesis-firehose":
+			return Firehose, nil
+		case "csv":
+			return CSV, nil
+		case "plugin":
+			return Plugin, nil
 		default:
 			return SinkType(0), fmt.Errorf("failed parsing sink type %v", data)
 		}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSinkConfigMap(t *testing.T) {
+
+	// Viper lowercases all keys read from configuration files.
+	scs, err := DecodeSinkConfigMap(map[string]interface{}{
+		"influx": map[string]interface{}{
+			"type":          "influxdb-http",
+			"address":       "http://localhost:8086",
+			"batchsize":     200,
+			"enablesrcport": true,
+			"retrybackoff":  "2s",
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, scs, 1)
+
+	assert.Equal(t, SinkConfig{
+		Name:          "influx",
+		Type:          InfluxHTTP,
+		Address:       "http://localhost:8086",
+		BatchSize:     200,
+		EnableSrcPort: true,
+		RetryBackoff:  2 * time.Second,
+	}, scs[0])
+
+	_, err = DecodeSinkConfigMap(map[string]interface{}{
+		"typo": map[string]interface{}{"type": "stdout", "sourcePorts": true},
+	})
+	assert.Error(t, err, "unknown key")
+
+	_, err = DecodeSinkConfigMap(map[string]interface{}{
+		"untyped": map[string]interface{}{"address": "localhost:8089"},
+	})
+	assert.Error(t, err, "missing type")
+}
+
+func TestTLSConfig(t *testing.T) {
+
+	scs, err := DecodeSinkConfigMap(map[string]interface{}{
+		"kafka": map[string]interface{}{
+			"type": "kafka",
+			"tls":  map[string]interface{}{"servername": "broker", "insecureskipverify": true},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, TLSConfig{ServerName: "broker", InsecureSkipVerify: true}, scs[0].TLS)
+
+	c, err := scs[0].TLS.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "broker", c.ServerName)
+	assert.True(t, c.InsecureSkipVerify)
+
+	c, err = TLSConfig{}.Config()
+	assert.NoError(t, err)
+	assert.Nil(t, c, "inactive")
+
+	_, err = TLSConfig{Cert: "client.pem"}.Config()
+	assert.Equal(t, errTLSKeyPair, err)
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, []byte("not a certificate"), 0600))
+	_, err = TLSConfig{CA: ca}.Config()
+	assert.Error(t, err, "empty ca")
+}
+
+func TestDecodeSinkConfigMapInstances(t *testing.T) {
+
+	// Instances of the same sink type only differ by name and address.
+	scs, err := DecodeSinkConfigMap(map[string]interface{}{
+		"influx_primary": map[string]interface{}{"type": "influxdb-http", "address": "http://primary:8086"},
+		"influx_dr":      map[string]interface{}{"type": "influxdb-http", "address": "http://dr:8086"},
+	})
+	require.NoError(t, err)
+	require.Len(t, scs, 2)
+	assert.Equal(t, "influx_dr", scs[0].Name)
+	assert.Equal(t, "http://dr:8086", scs[0].Address)
+	assert.Equal(t, "influx_primary", scs[1].Name)
+
+	_, err = DecodeSinkConfigMap(map[string]interface{}{
+		"a": map[string]interface{}{"type": "influxdb-http", "queuepolicy": "spill", "spooldir": "/var/spool/ct"},
+		"b": map[string]interface{}{"type": "influxdb-http", "queuepolicy": "spill", "spooldir": "/var/spool/ct/"},
+	})
+	assert.EqualError(t, err, "sinks 'a' and 'b': shared spoolDir '/var/spool/ct/'")
+}
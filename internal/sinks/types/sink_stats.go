@@ -1,10 +1,34 @@
 package types
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FlushLatencyBuckets are the upper bounds of the buckets of the
+// flush latency histogram.
+var FlushLatencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
 
 // SinkStats is an embeddable struct holding an SinkStatsData.
 type SinkStats struct {
 	data SinkStatsData
+
+	// Flush latency histogram, one counter per bucket of FlushLatencyBuckets
+	// and one for flushes slower than the last bucket.
+	flushBuckets [len(FlushLatencyBuckets) + 1]uint64
+	flushCount   uint64
+	flushSum     uint64
 }
 
 // SinkStatsData holds performance metrics about the the accounting sink.
@@ -13,13 +37,49 @@ type SinkStatsData struct {
 	EventsPushed uint64 `json:"events_pushed"`
 	// Amount of events failed to be Push()ed into the sink.
 	EventsDropped uint64 `json:"events_dropped"`
+	// Amount of events the sink failed to encode, included in EventsDropped.
+	EventErrors uint64 `json:"event_errors"`
+
+	// Current length of the sink's queue.
+	QueueLength uint64 `json:"queue_length"`
+	// Amount of events dropped by the queue policy.
+	QueueDropped uint64 `json:"queue_dropped"`
+	// Amount of bytes of events in the sink's spool.
+	SpoolBytes uint64 `json:"spool_bytes"`
+	// Set while the sink is an inactive member of a failover group.
+	Standby bool `json:"standby"`
 
 	// Current batch length of the sink.
 	BatchLength uint64 `json:"batch_length"`
+	// Batch length at which the sink sends its batch.
+	BatchWatermark uint64 `json:"batch_watermark"`
 	// Amount of batches sent.
 	BatchesSent uint64 `json:"batches_sent"`
 	// Amount of batches failed to be sent.
 	BatchesDropped uint64 `json:"batches_dropped"`
+
+	// Amount of events in batches written to the backend.
+	EventsSent uint64 `json:"events_sent"`
+	// Amount of events in batches failed to be written to the backend.
+	EventsLost uint64 `json:"events_lost"`
+	// Amount of failed write attempts, including retried ones.
+	SendErrors uint64 `json:"send_errors"`
+	// Amount of write attempts retried after an error.
+	Retries uint64 `json:"retries"`
+
+	// Time taken by the sink to write its batches.
+	FlushLatency LatencyHistogram `json:"flush_latency"`
+}
+
+// LatencyHistogram is a snapshot of a latency histogram.
+type LatencyHistogram struct {
+	// Amount of observations per bucket of FlushLatencyBuckets, not
+	// cumulative. The last element counts observations above the last bucket.
+	Buckets []uint64 `json:"buckets"`
+	// Amount of observations.
+	Count uint64 `json:"count"`
+	// Sum of all observations in nanoseconds.
+	Sum uint64 `json:"sum_ns"`
 }
 
 // IncrEventsPushed atomically increases the sink's event counter by one.
@@ -32,11 +92,28 @@ func (s *SinkStats) IncrEventsDropped() {
 	atomic.AddUint64(&s.data.EventsDropped, 1)
 }
 
+// AddEventsDropped atomically increases the sink's dropped event counter by n.
+func (s *SinkStats) AddEventsDropped(n int) {
+	atomic.AddUint64(&s.data.EventsDropped, uint64(n))
+}
+
+// IncrEventErrors atomically increases the sink's event error counter by one.
+// The event is also counted as dropped.
+func (s *SinkStats) IncrEventErrors() {
+	atomic.AddUint64(&s.data.EventErrors, 1)
+	atomic.AddUint64(&s.data.EventsDropped, 1)
+}
+
 // SetBatchLength sets the length of the current batch.
 func (s *SinkStats) SetBatchLength(l int) {
 	atomic.StoreUint64(&s.data.BatchLength, uint64(l))
 }
 
+// SetBatchWatermark sets the batch length at which the sink sends its batch.
+func (s *SinkStats) SetBatchWatermark(l int) {
+	atomic.StoreUint64(&s.data.BatchWatermark, uint64(l))
+}
+
 // IncrBatchDropped atomically increases the sink's dropped batch counter by one.
 func (s *SinkStats) IncrBatchDropped() {
 	atomic.AddUint64(&s.data.BatchesDropped, 1)
@@ -47,13 +124,70 @@ func (s *SinkStats) IncrBatchSent() {
 	atomic.AddUint64(&s.data.BatchesSent, 1)
 }
 
+// IncrRetries atomically increases the sink's retry counter by one.
+// The failed attempt is also counted as a send error.
+func (s *SinkStats) IncrRetries() {
+	atomic.AddUint64(&s.data.Retries, 1)
+	atomic.AddUint64(&s.data.SendErrors, 1)
+}
+
+// AddEventsSent atomically increases the sink's sent event counter by n.
+func (s *SinkStats) AddEventsSent(n int) {
+	atomic.AddUint64(&s.data.EventsSent, uint64(n))
+}
+
+// ObserveFlush records the outcome of writing a batch of n events to the
+// backend, taking duration d. If err is non-nil, the batch's events are
+// counted as lost and the attempt as a send error.
+func (s *SinkStats) ObserveFlush(d time.Duration, n int, err error) {
+
+	if err != nil {
+		atomic.AddUint64(&s.data.SendErrors, 1)
+		atomic.AddUint64(&s.data.EventsLost, uint64(n))
+	} else {
+		atomic.AddUint64(&s.data.EventsSent, uint64(n))
+	}
+
+	i := 0
+	for i < len(FlushLatencyBuckets) && d > FlushLatencyBuckets[i] {
+		i++
+	}
+
+	atomic.AddUint64(&s.flushBuckets[i], 1)
+	atomic.AddUint64(&s.flushSum, uint64(d))
+	atomic.AddUint64(&s.flushCount, 1)
+}
+
 // Get returns a non-atomic snapshot of the stats data.
 func (s *SinkStats) Get() SinkStatsData {
 	return SinkStatsData{
 		EventsPushed:   atomic.LoadUint64(&s.data.EventsPushed),
 		EventsDropped:  atomic.LoadUint64(&s.data.EventsDropped),
+		EventErrors:    atomic.LoadUint64(&s.data.EventErrors),
 		BatchLength:    atomic.LoadUint64(&s.data.BatchLength),
+		BatchWatermark: atomic.LoadUint64(&s.data.BatchWatermark),
 		BatchesSent:    atomic.LoadUint64(&s.data.BatchesSent),
 		BatchesDropped: atomic.LoadUint64(&s.data.BatchesDropped),
+		EventsSent:     atomic.LoadUint64(&s.data.EventsSent),
+		EventsLost:     atomic.LoadUint64(&s.data.EventsLost),
+		SendErrors:     atomic.LoadUint64(&s.data.SendErrors),
+		Retries:        atomic.LoadUint64(&s.data.Retries),
+		FlushLatency:   s.flushLatency(),
+	}
+}
+
+// flushLatency returns a snapshot of the flush latency histogram.
+func (s *SinkStats) flushLatency() LatencyHistogram {
+
+	h := LatencyHistogram{
+		Buckets: make([]uint64, len(s.flushBuckets)),
+		Count:   atomic.LoadUint64(&s.flushCount),
+		Sum:     atomic.LoadUint64(&s.flushSum),
+	}
+
+	for i := range s.flushBuckets {
+		h.Buckets[i] = atomic.LoadUint64(&s.flushBuckets[i])
 	}
+
+	return h
 }
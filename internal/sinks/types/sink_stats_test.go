@@ -0,0 +1,34 @@
+package types
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSinkStatsFlush(t *testing.T) {
+
+	var s SinkStats
+
+	s.ObserveFlush(500*time.Microsecond, 10, nil)
+	s.ObserveFlush(time.Millisecond, 5, nil)
+	s.ObserveFlush(time.Minute, 3, errors.New("timeout"))
+	s.IncrRetries()
+
+	d := s.Get()
+	assert.Equal(t, uint64(15), d.EventsSent)
+	assert.Equal(t, uint64(3), d.EventsLost)
+	assert.Equal(t, uint64(2), d.SendErrors)
+	assert.Equal(t, uint64(1), d.Retries)
+
+	h := d.FlushLatency
+	assert.Len(t, h.Buckets, len(FlushLatencyBuckets)+1)
+	assert.Equal(t, uint64(3), h.Count)
+	assert.Equal(t, uint64(time.Minute+1500*time.Microsecond), h.Sum)
+
+	// Bucket bounds are inclusive, slow flushes land in the overflow bucket.
+	assert.Equal(t, uint64(2), h.Buckets[0])
+	assert.Equal(t, uint64(1), h.Buckets[len(FlushLatencyBuckets)])
+}
@@ -11,4 +11,15 @@ const (
 	InfluxUDP
 	InfluxHTTP
 	Elastic
+	Kafka
+	ClickHouse
+	IPFIX
+	NetFlow
+	InfluxV2
+	Capture
+	Graphite
+	Kinesis
+	Firehose
+	CSV
+	Plugin
 )
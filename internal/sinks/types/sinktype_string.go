@@ -4,9 +4,9 @@ package types
 
 import "strconv"
 
-const _SinkType_name = "StdOutStdErrInfluxUDPInfluxHTTPElastic"
+const _SinkType_name = "StdOutStdErrInfluxUDPInfluxHTTPElasticKafkaClickHouseIPFIXNetFlowInfluxV2CaptureGraphiteKinesisFirehoseCSVPlugin"
 
-var _SinkType_index = [...]uint8{0, 6, 12, 21, 31, 38}
+var _SinkType_index = [...]uint8{0, 6, 12, 21, 31, 38, 43, 53, 58, 65, 73, 80, 88, 95, 103, 106, 112}
 
 func (i SinkType) String() string {
 	if i >= SinkType(len(_SinkType_index)-1) {
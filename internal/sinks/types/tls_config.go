@@ -0,0 +1,81 @@
+package types
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+var errTLSKeyPair = errors.New("tls: cert and key must be set together")
+
+// TLSConfig configures the TLS connections of a network sink to its
+// backing storage.
+type TLSConfig struct {
+
+	// Connect over TLS. Implied by setting any of the other fields.
+	// HTTP-based sinks use TLS for https addresses instead.
+	Enabled bool `mapstructure:"enabled"`
+
+	// PEM file of the CAs verifying the server's certificate.
+	// The system's CAs are used when empty.
+	CA string `mapstructure:"ca"`
+
+	// PEM files of the client certificate and key presented to the server.
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+
+	// Name the server's certificate is verified against,
+	// the host of the sink's address when empty.
+	ServerName string `mapstructure:"serverName"`
+
+	// Don't verify the server's certificate.
+	InsecureSkipVerify bool `mapstructure:"insecureSkipVerify"`
+}
+
+// Active returns true if the sink should connect over TLS.
+func (t TLSConfig) Active() bool {
+	return t != TLSConfig{}
+}
+
+// Config returns a tls.Config built from the TLSConfig, reading the CA and
+// client certificate files. Returns nil if TLS is not Active.
+func (t TLSConfig) Config() (*tls.Config, error) {
+
+	if !t.Active() {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if t.CA != "" {
+		b, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, errors.Wrap(err, "tls: reading ca")
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("tls: no certificates in ca '%s'", t.CA)
+		}
+	}
+
+	if (t.Cert == "") != (t.Key == "") {
+		return nil, errTLSKeyPair
+	}
+	if t.Cert != "" {
+		kp, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "tls: loading client certificate")
+		}
+		c.Certificates = []tls.Certificate{kp}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+// Weight of the latest batch in the moving average of the event rate.
+const rateWeight = 0.5
+
+var errBatchSizeRange = errors.New("batchSizeMin must not exceed batchSizeMax")
+
+// Watermark is the batch length at which a batching sink sends its batch.
+// It's the sink's BatchSize, unless BatchSizeMax is set. The watermark then
+// follows the sink's event rate, aiming to send batches at half the maximum
+// batch age (FlushInterval): it grows under high throughput so fewer, larger
+// batches are written, and shrinks when the rate drops, so batches are sent
+// early instead of waiting for the maximum age.
+//
+// A Watermark is not safe for concurrent use, sinks guard it with the
+// lock of their batch.
+type Watermark struct {
+	value    int
+	min, max int
+
+	// Age at which batches are aimed to be sent, zero if static.
+	target time.Duration
+
+	// Moving average of the event rate in events per second.
+	rate float64
+
+	// Time the current batch was started.
+	start time.Time
+}
+
+// NewWatermark returns the Watermark of a sink. sc's BatchSize and
+// FlushInterval must be set.
+func NewWatermark(sc SinkConfig) (Watermark, error) {
+
+	w := Watermark{value: int(sc.BatchSize)}
+	if sc.BatchSizeMax == 0 {
+		return w, nil
+	}
+
+	w.min, w.max = int(sc.BatchSizeMin), int(sc.BatchSizeMax)
+	if w.min == 0 {
+		w.min = 1
+	}
+	if w.min > w.max {
+		return Watermark{}, errBatchSizeRange
+	}
+
+	w.target = sc.FlushInterval / 2
+	w.value = w.clamp(w.value)
+
+	return w, nil
+}
+
+// Value returns the current watermark.
+func (w *Watermark) Value() int {
+	return w.value
+}
+
+// Max returns the largest the watermark can get.
+func (w *Watermark) Max() int {
+	if w.target == 0 {
+		return w.value
+	}
+	return w.max
+}
+
+// Reached returns true if a batch holding n events is to be sent.
+func (w *Watermark) Reached(n int) bool {
+	return n >= w.value
+}
+
+// Observe is called when the sink starts a new batch, after sending one
+// holding n events. The rate at which the batch was filled adjusts an
+// adaptive watermark.
+func (w *Watermark) Observe(n int) {
+
+	if w.target == 0 {
+		return
+	}
+
+	now := time.Now()
+	if !w.start.IsZero() {
+		if age := now.Sub(w.start); age > 0 {
+			r := float64(n) / age.Seconds()
+			if w.rate == 0 {
+				w.rate = r
+			} else {
+				w.rate += (r - w.rate) * rateWeight
+			}
+
+			w.value = w.clamp(int(w.rate * w.target.Seconds()))
+
+			// Don't remember bursts beyond the maximum, so the
+			// watermark shrinks as soon as the rate drops.
+			if w.value == w.max {
+				w.rate = float64(w.max) / w.target.Seconds()
+			}
+		}
+	}
+
+	w.start = now
+}
+
+// clamp bounds n to the watermark's range.
+func (w *Watermark) clamp(n int) int {
+	if n < w.min {
+		return w.min
+	}
+	if n > w.max {
+		return w.max
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatermark(t *testing.T) {
+
+	// Without a maximum, the watermark is the batch size.
+	w, err := NewWatermark(SinkConfig{BatchSize: 100, FlushInterval: time.Second})
+	require.NoError(t, err)
+	w.Observe(1)
+	assert.True(t, w.Reached(100))
+	assert.False(t, w.Reached(99))
+	assert.Equal(t, 100, w.Max())
+
+	_, err = NewWatermark(SinkConfig{BatchSize: 100, BatchSizeMin: 10, BatchSizeMax: 5})
+	assert.Equal(t, errBatchSizeRange, err)
+
+	w, err = NewWatermark(SinkConfig{
+		BatchSize:     100,
+		BatchSizeMin:  10,
+		BatchSizeMax:  1000,
+		FlushInterval: 2 * time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 100, w.Value())
+	assert.Equal(t, 1000, w.Max())
+
+	// fill observes a batch of n events taking d to fill.
+	fill := func(n int, d time.Duration) {
+		w.start = time.Now().Add(-d)
+		w.Observe(n)
+	}
+
+	// 400 events per second, batches are aimed to be sent after a second.
+	fill(100, 250*time.Millisecond)
+	assert.InDelta(t, 400, w.Value(), 5)
+
+	// Growing under high throughput, bounded by the maximum.
+	for i := 0; i < 10; i++ {
+		fill(w.Value(), 10*time.Millisecond)
+	}
+	assert.Equal(t, 1000, w.Value())
+
+	// Batches flushed by age shrink the watermark, bounded by the minimum.
+	for i := 0; i < 10; i++ {
+		fill(1, 2*time.Second)
+	}
+	assert.Equal(t, 10, w.Value())
+}
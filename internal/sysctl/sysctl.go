@@ -7,20 +7,25 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Apply sets a given map of sysctls on the machine.
-func Apply(ctls map[string]string, verbose bool) error {
+// Apply sets a given map of sysctls on the machine. Returns the previous
+// values of the sysctls it changed, which can be passed to Apply to restore
+// them. On error, the sysctls changed so far are returned.
+func Apply(ctls map[string]string, verbose bool) (map[string]string, error) {
+
+	prev := make(map[string]string)
 
 	for ctl, v := range ctls {
 		cur, err := sysctl.Get(ctl)
 		if err != nil {
-			return errors.Wrap(err, errSysctlGet)
+			return prev, errors.Wrap(err, errSysctlGet)
 		}
 
 		if cur != v {
 			err = sysctl.Set(ctl, v)
 			if err != nil {
-				return errors.Wrap(err, errSysctlSet)
+				return prev, errors.Wrap(err, errSysctlSet)
 			}
+			prev[ctl] = cur
 
 			if verbose {
 				log.Infof("Applied sysctl %s=%s", ctl, v)
@@ -28,5 +33,16 @@ func Apply(ctls map[string]string, verbose bool) error {
 		}
 	}
 
-	return nil
+	return prev, nil
+}
+
+// Get returns the current value of a sysctl.
+func Get(ctl string) (string, error) {
+
+	v, err := sysctl.Get(ctl)
+	if err != nil {
+		return "", errors.Wrap(err, errSysctlGet)
+	}
+
+	return v, nil
 }
@@ -0,0 +1,6 @@
+package systemd
+
+const (
+	errFmtWatchdogUSec = "invalid WATCHDOG_USEC '%s'"
+	errFmtListenFDs    = "invalid LISTEN_FDS '%s'"
+)
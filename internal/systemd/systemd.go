@@ -0,0 +1,122 @@
+// Package systemd implements the parts of the systemd service protocols used
+// by conntracct: readiness and watchdog notifications for Type=notify units,
+// and sockets passed by socket activation.
+//
+// All functions are no-ops when the process isn't run by systemd.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// States sent with Notify.
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// First file descriptor passed by socket activation, SD_LISTEN_FDS_START.
+const listenFDsStart = 3
+
+// Notify sends a state to the service manager, eg. Ready. No-op if the
+// service manager doesn't expect notifications.
+func Notify(state string) error {
+
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// Abstract sockets are prefixed with a NUL byte instead of an @.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "connecting to notify socket")
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte(state)); err != nil {
+		return errors.Wrap(err, "sending notification")
+	}
+
+	return nil
+}
+
+// WatchdogInterval returns the interval after which the service manager
+// considers the process hung if it doesn't send a Watchdog notification.
+// Zero if the watchdog is disabled.
+func WatchdogInterval() (time.Duration, error) {
+
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// The watchdog is meant for another process, eg. a parent.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(usec, 10, 64)
+	if err != nil || n == 0 {
+		return 0, errors.Errorf(errFmtWatchdogUSec, usec)
+	}
+
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Listeners returns the sockets passed to the process by socket activation,
+// by their FileDescriptorName. Unnamed sockets are named after their socket
+// unit. Empty if no sockets were passed. The environment variables
+// describing the sockets are cleared, so subprocesses don't pick them up.
+func Listeners() (map[string]net.Listener, error) {
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, errors.Errorf(errFmtListenFDs, os.Getenv("LISTEN_FDS"))
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	ls := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "socket '%s' is not a listening socket", name)
+		}
+
+		ls[name] = l
+	}
+
+	return ls, nil
+}
@@ -0,0 +1,57 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, Notify(Ready))
+
+	path := filepath.Join(t.TempDir(), "notify")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer c.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	require.NoError(t, Notify(Ready))
+
+	b := make([]byte, 64)
+	n, err := c.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, Ready, string(b[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+
+	t.Setenv("WATCHDOG_USEC", "")
+	d, err := WatchdogInterval()
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	d, err = WatchdogInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, d)
+
+	// Meant for another process.
+	t.Setenv("WATCHDOG_PID", "1")
+	d, err = WatchdogInterval()
+	require.NoError(t, err)
+	assert.Zero(t, d)
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "soon")
+	_, err = WatchdogInterval()
+	assert.Error(t, err)
+}
@@ -78,7 +78,7 @@ func (Bpf) Build() error {
 		// Download and extract all kernels first.
 		mg.Deps(Bpf.Kernels)
 
-		if err := buildProbe(bpfAcctProbe, bpfObjectPath, k.Directory()); err != nil {
+		if err := buildProbe(bpfAcctProbe, bpfObjectPath, k); err != nil {
 			fmt.Println("Failed to build probe against kernel", k.Version)
 			return err
 		}
@@ -128,8 +128,10 @@ func (Bpf) Kernels() error {
 }
 
 // buildProbe builds a BPF program given its source file, destination object file
-// and directory of the kernel source tree the program is to be built against.
-func buildProbe(srcFile, destObj, kernelDir string) error {
+// and the kernel the program is to be built against.
+func buildProbe(srcFile, destObj string, k kernel.Kernel) error {
+
+	kernelDir := k.Directory()
 
 	clangParams := []string{
 		"-D__KERNEL__", "-D__BPF_TRACING__",
@@ -154,6 +156,21 @@ func buildProbe(srcFile, destObj, kernelDir string) error {
 		// "-I%s/include/generated/uapi",
 	}
 
+	// Deliver events over ring buffers on kernels that support them.
+	if k.RingBuf {
+		clangParams = append(clangParams, "-DACCT_RINGBUF")
+	}
+
+	// Record the wall clock time of events on kernels that can read it.
+	if k.RealTime {
+		clangParams = append(clangParams, "-DACCT_REALTIME")
+	}
+
+	// Build the tracing programs on kernels that can attach them.
+	if len(k.Fentry) > 0 {
+		clangParams = append(clangParams, "-DACCT_FENTRY")
+	}
+
 	// Resolve kernel directories in all include paths and append to clang params.
 	for _, d := range kdirs {
 		clangParams = append(clangParams, fmt.Sprintf(d, kernelDir))
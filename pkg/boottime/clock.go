@@ -0,0 +1,60 @@
+package boottime
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Interval at which a Clock re-estimates the boot time.
+const refreshInterval = time.Second
+
+// Clock converts time stamps of the monotonic clock to absolute time.
+//
+// The estimated boot time goes stale when the system is suspended, since the
+// monotonic clock stops while the wall clock doesn't, and when the wall clock
+// is stepped, eg. by NTP. A Clock re-estimates the boot time when it's used
+// at least a second after its previous estimate, so absolute time stamps
+// don't drift. Safe for concurrent use.
+type Clock struct {
+	// Estimated boot time in nanoseconds since the UNIX epoch.
+	boot int64
+
+	// Monotonic time after which the boot time is re-estimated,
+	// zero if it's never re-estimated.
+	next int64
+}
+
+// NewClock returns a Clock tracking the system's boot time.
+func NewClock() *Clock {
+	return &Clock{
+		boot: Estimate().UnixNano(),
+		next: nanotime() + int64(refreshInterval),
+	}
+}
+
+// FixedClock returns a Clock using the given boot time, which is never
+// re-estimated. Useful for converting time stamps recorded in the past.
+func FixedClock(boot time.Time) *Clock {
+	return &Clock{boot: boot.UnixNano()}
+}
+
+// Boot returns the current estimate of the system's boot time.
+func (c *Clock) Boot() time.Time {
+
+	next := atomic.LoadInt64(&c.next)
+	if next != 0 {
+		// Only one caller re-estimates, the others use the previous estimate.
+		now := nanotime()
+		if now >= next && atomic.CompareAndSwapInt64(&c.next, next, now+int64(refreshInterval)) {
+			atomic.StoreInt64(&c.boot, Estimate().UnixNano())
+		}
+	}
+
+	return time.Unix(0, atomic.LoadInt64(&c.boot))
+}
+
+// Time returns the absolute time of a time stamp of the monotonic clock,
+// eg. of a kernel event, in nanoseconds.
+func (c *Clock) Time(ktime uint64) time.Time {
+	return c.Boot().Add(time.Duration(ktime))
+}
@@ -0,0 +1,26 @@
+package boottime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+
+	c := NewClock()
+	assert.WithinDuration(t, time.Now(), c.Time(uint64(nanotime())), 10*time.Millisecond)
+
+	// A stale estimate, eg. after a suspend, is replaced once it's due.
+	c.boot -= int64(time.Minute)
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), c.Time(uint64(nanotime())), 10*time.Millisecond)
+
+	c.next = nanotime()
+	assert.WithinDuration(t, time.Now(), c.Time(uint64(nanotime())), 10*time.Millisecond)
+
+	// Fixed clocks are never re-estimated.
+	boot := time.Unix(100, 0)
+	f := FixedClock(boot)
+	assert.True(t, boot.Add(time.Second).Equal(f.Time(uint64(time.Second))))
+}
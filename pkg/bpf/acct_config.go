@@ -1,37 +1,620 @@
 package bpf
 
 import (
+	"net"
+	"sort"
 	"unsafe"
 
 	"github.com/iovisor/gobpf/elf"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 var (
 	// Map indices of configuration values for acct probe.
-	configCooldown = 0
+	configCooldown    = 0
+	configFilterSrc   = 1
+	configFilterDst   = 2
+	configFilterProto = 3
+	configFilterPort  = 4
+	configDisableIPv4 = 5
+	configDisableIPv6 = 6
+	configCooldownCls = 7
+	configMinBytes    = 8
+	configSampleRate  = 9
+	configRealTime    = 10
+	configFilterNetNS = 11
 )
 
 const (
 	bpfAny = 0 // BPF_ANY
+
+	filterSrcMap   = "filter_src"
+	filterDstMap   = "filter_dst"
+	filterProtoMap = "filter_proto"
+	filterPortMap  = "filter_port"
+	cooldownClsMap = "cooldown_class"
+	filterNetNSMap = "filter_netns"
+
+	// Amount of entries in the protocol and port allowlist arrays.
+	numProtos = 256
+	numPorts  = 65536
+
+	// Amount of entries in the cooldown class array,
+	// must match MAX_COOLDOWN_CLASSES in acct.c.
+	maxCooldownClasses = 4
+
+	// Cooldown of flows when none is configured, must match def_cd in acct.c.
+	defaultCooldownMillis = 2000
+
+	// Amount of entries in the network namespace allowlist,
+	// must match filter_netns in acct.c.
+	maxNetNS = 256
 )
 
 // Config is a configuration object for the acct BPF probe.
 type Config struct {
+	// Minimum time between update events of a flow.
+	// Can be changed after loading using Probe.SetCooldown.
+	CooldownMillis uint32
+
+	// Cooldowns of flows exceeding a byte rate, overriding CooldownMillis.
+	// A flow's rate is measured between its events, and the cooldown of the
+	// class with the highest matching MinRate is applied after each event.
+	// At most 4 classes are supported.
+	// Can be changed after loading using Probe.SetCooldownClasses.
+	CooldownClasses []CooldownClass
+
+	// Flows with less than MinBytes in both directions don't generate update
+	// events, they're only reported when they're destroyed.
+	// Can be changed after loading using Probe.SetMinBytes.
+	MinBytes uint64
+
+	// Only report 1 in SampleRate flows, selected by a hash of their
+	// connection ID. Events carry the rate in Event.SampleRate so sinks can
+	// scale their counters. Zero or one reports all flows.
+	// Can only be set when loading the probe.
+	SampleRate uint32
+
+	// Record the wall clock time of events in the kernel, in Event.RealTime,
+	// so their absolute time stamps don't depend on the estimated boot time.
+	// Only supported by probes for kernels 6.1 and later, ignored by others.
+	// Can only be set when loading the probe.
+	RealTime bool
+
+	// Directory on a BPF filesystem the probe pins the per-flow state of its
+	// cooldowns in, eg. /sys/fs/bpf/conntracct. A probe loaded with the same
+	// PinPath picks up the state, so flows aren't all reported again after a
+	// restart, except for flows without events for longer than the longest
+	// cooldown. The probe's tracing programs are pinned alongside, on kernels
+	// attaching them through fentry/fexit. The state stays pinned when the
+	// probe is closed. Empty disables pinning.
+	// Can only be set when loading the probe.
+	PinPath string
+
+	// Allowlists of networks installed into the probe. When non-empty,
+	// only flows with a source/destination address within one of the
+	// networks are sent to userspace.
+	SrcNets []*net.IPNet
+	DstNets []*net.IPNet
+
+	// Allowlists of protocol numbers and destination port ranges. When
+	// non-empty, only flows matching one of the entries are sent to userspace.
+	// The port allowlist only applies to TCP, UDP, SCTP and UDPLite flows.
+	// Can be changed after loading using Probe.SetProtoFilter/SetPortFilter.
+	Protos   []uint8
+	DstPorts []PortRange
+
+	// Allowlist of network namespaces by inode number. When non-empty,
+	// only flows in one of the namespaces are sent to userspace. At most
+	// 256 namespaces are supported.
+	// Can be changed after loading using Probe.SetNetNSFilter.
+	NetNS []uint32
+
+	// Don't send IPv4 or IPv6 flows to userspace.
+	DisableIPv4 bool
+	DisableIPv6 bool
+
+	// Size of the per-CPU perf buffers in pages, must be a power of two.
+	// Zero uses the default of 8 pages, like gobpf. Only for probes using perf
+	// buffers. The buffer of each CPU is read by its own goroutine.
+	PerfPageCount int
+
+	// Size of the BPF ring buffers in bytes, must be a power of two multiple
+	// of the page size. Zero uses the probe's default of 4MiB. Only for
+	// probes using ring buffers, on kernels 5.8 and later.
+	RingBufSize int
+
+	// Amount of bytes pending in a ring or perf buffer before it is read, so
+	// events are read in larger batches with fewer wakeups. Events are read
+	// at least every 100ms from ring buffers and every 50ms from perf buffers
+	// regardless. Zero reads events as soon as they're sent.
+	WakeupWatermark uint32
+}
+
+// CooldownClass is the cooldown of flows with a rate of at least MinRate
+// bytes per second, summed over both directions.
+type CooldownClass struct {
+	MinRate        uint64
 	CooldownMillis uint32
 }
 
+// PortRange is an inclusive range of port numbers.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+// Match checks an Event against the Config's address families and allowlists
+// like the probe does, for events that don't originate from the probe.
+func (c Config) Match(e Event) bool {
+
+	if (e.Family == FamilyIPv4 && c.DisableIPv4) || (e.Family == FamilyIPv6 && c.DisableIPv6) {
+		return false
+	}
+
+	if len(c.Protos) != 0 && !containsProto(c.Protos, e.Proto) {
+		return false
+	}
+
+	if len(c.DstPorts) != 0 && e.HasPorts() && !containsPort(c.DstPorts, e.DstPort) {
+		return false
+	}
+
+	if len(c.NetNS) != 0 && !containsNetNS(c.NetNS, e.NetNS) {
+		return false
+	}
+
+	if len(c.SrcNets) != 0 && !containsAddr(c.SrcNets, e.SrcAddr) {
+		return false
+	}
+
+	if len(c.DstNets) != 0 && !containsAddr(c.DstNets, e.DstAddr) {
+		return false
+	}
+
+	return true
+}
+
+// Sampled checks if the flow with the given connection ID is among the
+// 1 in rate flows reported by a sampling probe, like the probe does.
+func Sampled(cid uint32, rate uint32) bool {
+
+	if rate <= 1 {
+		return true
+	}
+
+	hash := uint32((uint64(cid) * 0x9E3779B97F4A7C15) >> 32)
+
+	return hash%rate == 0
+}
+
+// MatchUpdate checks an update Event against the Config like Match,
+// and additionally against its MinBytes threshold.
+func (c Config) MatchUpdate(e Event) bool {
+	return e.BytesOrig+e.BytesRet >= c.MinBytes && c.Match(e)
+}
+
+func containsProto(protos []uint8, p uint8) bool {
+	for _, v := range protos {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []PortRange, p uint16) bool {
+	for _, r := range ports {
+		if p >= r.First && p <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNetNS(netns []uint32, n uint32) bool {
+	for _, v := range netns {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddr(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lpmKey is the key of the probe's allowlist tries, struct lpm_key_t.
+type lpmKey struct {
+	prefixLen uint32
+	family    uint8
+	addr      [16]byte
+	_         [3]byte
+}
+
 // configureProbe sets configuration values in the probe's config map.
 func configureProbe(mod *elf.Module, cfg Config) error {
 
-	cm := mod.Map("config")
-
 	if cfg.CooldownMillis != 0 {
-		cd := cfg.CooldownMillis * 1000000 // 1 ms = 1 million ns
-		if err := mod.UpdateElement(cm, unsafe.Pointer(&configCooldown), unsafe.Pointer(&cd), bpfAny); err != nil {
+		if err := configureCooldown(mod, cfg.CooldownMillis); err != nil {
 			return errors.Wrap(err, "cooldown")
 		}
 	}
 
+	if cfg.MinBytes != 0 {
+		if err := configureMinBytes(mod, cfg.MinBytes); err != nil {
+			return errors.Wrap(err, "minimum bytes")
+		}
+	}
+
+	if cfg.SampleRate > 1 {
+		rate := uint64(cfg.SampleRate)
+		if err := mod.UpdateElement(mod.Map("config"), unsafe.Pointer(&configSampleRate), unsafe.Pointer(&rate), bpfAny); err != nil {
+			return errors.Wrap(err, "sample rate")
+		}
+	}
+
+	if len(cfg.CooldownClasses) != 0 {
+		if err := configureCooldownClasses(mod, cfg.CooldownClasses); err != nil {
+			return errors.Wrap(err, "cooldown classes")
+		}
+	}
+
+	if err := configureFilter(mod, filterSrcMap, configFilterSrc, cfg.SrcNets); err != nil {
+		return errors.Wrap(err, "source filter")
+	}
+
+	if err := configureFilter(mod, filterDstMap, configFilterDst, cfg.DstNets); err != nil {
+		return errors.Wrap(err, "destination filter")
+	}
+
+	if err := configureFlag(mod, configRealTime, cfg.RealTime); err != nil {
+		return errors.Wrap(err, "real time")
+	}
+
+	if err := configureFlag(mod, configDisableIPv4, cfg.DisableIPv4); err != nil {
+		return errors.Wrap(err, "disable ipv4")
+	}
+
+	if err := configureFlag(mod, configDisableIPv6, cfg.DisableIPv6); err != nil {
+		return errors.Wrap(err, "disable ipv6")
+	}
+
+	if len(cfg.Protos) != 0 {
+		if err := configureProtoFilter(mod, cfg.Protos); err != nil {
+			return errors.Wrap(err, "protocol filter")
+		}
+	}
+
+	if len(cfg.DstPorts) != 0 {
+		if err := configurePortFilter(mod, cfg.DstPorts); err != nil {
+			return errors.Wrap(err, "port filter")
+		}
+	}
+
+	if len(cfg.NetNS) != 0 {
+		if err := configureNetNSFilter(mod, nil, cfg.NetNS); err != nil {
+			return errors.Wrap(err, "netns filter")
+		}
+	}
+
 	return nil
 }
+
+// maxCooldown returns the longest cooldown of a flow in nanoseconds, of the
+// configured cooldown and cooldown classes.
+func (cfg Config) maxCooldown() uint64 {
+
+	cd := uint32(defaultCooldownMillis)
+	if cfg.CooldownMillis != 0 {
+		cd = cfg.CooldownMillis
+	}
+
+	for _, c := range cfg.CooldownClasses {
+		if c.CooldownMillis > cd {
+			cd = c.CooldownMillis
+		}
+	}
+
+	return uint64(cd) * 1000000
+}
+
+// configureCooldown sets the minimum time between update events of a flow.
+func configureCooldown(mod *elf.Module, millis uint32) error {
+	cd := uint64(millis) * 1000000 // 1 ms = 1 million ns
+	return mod.UpdateElement(mod.Map("config"), unsafe.Pointer(&configCooldown), unsafe.Pointer(&cd), bpfAny)
+}
+
+// configureMinBytes sets the amount of bytes a flow needs to generate update events.
+func configureMinBytes(mod *elf.Module, n uint64) error {
+	return mod.UpdateElement(mod.Map("config"), unsafe.Pointer(&configMinBytes), unsafe.Pointer(&n), bpfAny)
+}
+
+// cooldownClass is an entry of the probe's cooldown class array,
+// struct cooldown_class_t.
+type cooldownClass struct {
+	minRate  uint64
+	cooldown uint64
+}
+
+// configureCooldownClasses replaces the contents of the probe's cooldown
+// class array. Disables the classes if classes is empty.
+func configureCooldownClasses(mod *elf.Module, classes []CooldownClass) error {
+
+	entries, err := newCooldownClasses(classes)
+	if err != nil {
+		return err
+	}
+
+	cm := mod.Map("config")
+
+	// Disable the classes while they're being replaced.
+	var enabled uint64
+	if err := mod.UpdateElement(cm, unsafe.Pointer(&configCooldownCls), unsafe.Pointer(&enabled), bpfAny); err != nil {
+		return err
+	}
+
+	if len(classes) == 0 {
+		return nil
+	}
+
+	m := mod.Map(cooldownClsMap)
+	if m == nil {
+		return errors.Errorf("map %s not found in probe", cooldownClsMap)
+	}
+
+	for i := range entries {
+		k := uint32(i)
+		if err := mod.UpdateElement(m, unsafe.Pointer(&k), unsafe.Pointer(&entries[i]), bpfAny); err != nil {
+			return errors.Wrapf(err, "updating entry %d", i)
+		}
+	}
+
+	enabled = 1
+	return mod.UpdateElement(cm, unsafe.Pointer(&configCooldownCls), unsafe.Pointer(&enabled), bpfAny)
+}
+
+// newCooldownClasses builds the entries of the probe's cooldown class array,
+// sorted by descending minimum rate so the probe picks the first match.
+func newCooldownClasses(classes []CooldownClass) ([maxCooldownClasses]cooldownClass, error) {
+
+	var out [maxCooldownClasses]cooldownClass
+
+	if len(classes) > maxCooldownClasses {
+		return out, errCooldownClasses
+	}
+
+	sorted := append([]CooldownClass(nil), classes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinRate > sorted[j].MinRate })
+
+	for i, c := range sorted {
+		if c.CooldownMillis == 0 {
+			return out, errors.Errorf("zero cooldown for class with minimum rate %d", c.MinRate)
+		}
+		out[i] = cooldownClass{
+			minRate:  c.MinRate,
+			cooldown: uint64(c.CooldownMillis) * 1000000,
+		}
+	}
+
+	return out, nil
+}
+
+// configureFlag sets a boolean value in the probe's config map. No-op if v is false.
+func configureFlag(mod *elf.Module, idx int, v bool) error {
+
+	if !v {
+		return nil
+	}
+
+	var enabled uint64 = 1
+	return mod.UpdateElement(mod.Map("config"), unsafe.Pointer(&idx), unsafe.Pointer(&enabled), bpfAny)
+}
+
+// configureFilter inserts a list of networks into the allowlist trie with
+// the given name and enables the allowlist in the config map.
+// No-op if nets is empty.
+func configureFilter(mod *elf.Module, name string, idx int, nets []*net.IPNet) error {
+
+	if len(nets) == 0 {
+		return nil
+	}
+
+	m := mod.Map(name)
+	if m == nil {
+		return errors.Errorf("map %s not found in probe", name)
+	}
+
+	var allow uint8 = 1
+	for _, n := range nets {
+		k, err := newLPMKey(n)
+		if err != nil {
+			return err
+		}
+
+		if err := mod.UpdateElement(m, unsafe.Pointer(&k), unsafe.Pointer(&allow), bpfAny); err != nil {
+			return errors.Wrapf(err, "inserting %s", n)
+		}
+	}
+
+	// Enable the allowlist only after it was filled.
+	return configureFlag(mod, idx, true)
+}
+
+// newLPMKey builds an lpmKey from an IPNet. IPv4 networks are stored in the
+// first four bytes of the address, like the kernel's nf_inet_addr.
+func newLPMKey(n *net.IPNet) (lpmKey, error) {
+
+	var k lpmKey
+
+	ones, bits := n.Mask.Size()
+
+	switch {
+	case bits == 8*net.IPv4len && n.IP.To4() != nil:
+		k.family = FamilyIPv4
+		copy(k.addr[:], n.IP.To4())
+	case bits == 8*net.IPv6len && n.IP.To4() == nil:
+		k.family = FamilyIPv6
+		copy(k.addr[:], n.IP.To16())
+	default:
+		return k, errors.Errorf("invalid network %s", n)
+	}
+
+	// The family byte is part of the prefix.
+	k.prefixLen = uint32(8 + ones)
+
+	return k, nil
+}
+
+// configureProtoFilter replaces the contents of the probe's protocol allowlist.
+// Disables the allowlist if protos is empty.
+func configureProtoFilter(mod *elf.Module, protos []uint8) error {
+
+	var allow [numProtos]bool
+	for _, p := range protos {
+		allow[p] = true
+	}
+
+	return configureIndexFilter(mod, filterProtoMap, configFilterProto, allow[:], len(protos) != 0)
+}
+
+// configurePortFilter replaces the contents of the probe's destination port
+// allowlist. Disables the allowlist if ports is empty.
+func configurePortFilter(mod *elf.Module, ports []PortRange) error {
+
+	allow := make([]bool, numPorts)
+	for _, r := range ports {
+		if r.First > r.Last {
+			return errors.Errorf("invalid port range %d-%d", r.First, r.Last)
+		}
+		for p := int(r.First); p <= int(r.Last); p++ {
+			allow[p] = true
+		}
+	}
+
+	return configureIndexFilter(mod, filterPortMap, configFilterPort, allow, len(ports) != 0)
+}
+
+// configureNetNSFilter replaces the contents of the probe's network namespace
+// allowlist, holding the namespaces in old, with netns. New entries are added
+// before removing the old ones, so flows in namespaces in both lists keep
+// being sent. Disables the allowlist if netns is empty.
+func configureNetNSFilter(mod *elf.Module, old, netns []uint32) error {
+
+	if len(netns) > maxNetNS {
+		return errNetNSFilter
+	}
+
+	cm := mod.Map("config")
+
+	m := mod.Map(filterNetNSMap)
+	if m == nil {
+		return errors.Errorf("map %s not found in probe", filterNetNSMap)
+	}
+
+	var enabled uint64
+	if len(netns) == 0 {
+		if err := mod.UpdateElement(cm, unsafe.Pointer(&configFilterNetNS), unsafe.Pointer(&enabled), bpfAny); err != nil {
+			return err
+		}
+	}
+
+	var v uint8 = 1
+	for _, n := range netns {
+		k := n
+		if err := mod.UpdateElement(m, unsafe.Pointer(&k), unsafe.Pointer(&v), bpfAny); err != nil {
+			return errors.Wrapf(err, "adding netns %d", n)
+		}
+	}
+
+	for _, n := range old {
+		if containsNetNS(netns, n) {
+			continue
+		}
+		k := n
+		if err := mod.DeleteElement(m, unsafe.Pointer(&k)); err != nil {
+			return errors.Wrapf(err, "removing netns %d", n)
+		}
+	}
+
+	if len(netns) == 0 {
+		return nil
+	}
+
+	enabled = 1
+	return mod.UpdateElement(cm, unsafe.Pointer(&configFilterNetNS), unsafe.Pointer(&enabled), bpfAny)
+}
+
+// configureIndexFilter writes the values of allow into the allowlist array
+// with the given name, and enables or disables it in the config map.
+// Disabling an allowlist does not update its contents.
+func configureIndexFilter(mod *elf.Module, name string, idx int, allow []bool, enable bool) error {
+
+	cm := mod.Map("config")
+
+	var enabled uint64
+	if !enable {
+		return mod.UpdateElement(cm, unsafe.Pointer(&idx), unsafe.Pointer(&enabled), bpfAny)
+	}
+
+	m := mod.Map(name)
+	if m == nil {
+		return errors.Errorf("map %s not found in probe", name)
+	}
+
+	// Overwrite every entry so the allowlist doesn't need to be cleared
+	// first, which would briefly drop all flows if it is enabled.
+	for i, a := range allow {
+		k := uint32(i)
+		var v uint8
+		if a {
+			v = 1
+		}
+
+		if err := mod.UpdateElement(m, unsafe.Pointer(&k), unsafe.Pointer(&v), bpfAny); err != nil {
+			return errors.Wrapf(err, "updating entry %d", i)
+		}
+	}
+
+	enabled = 1
+	return mod.UpdateElement(cm, unsafe.Pointer(&idx), unsafe.Pointer(&enabled), bpfAny)
+}
+
+// sectionParams returns the gobpf load parameters of the probe's event maps
+// for the buffer sizes in the Config.
+func (c Config) sectionParams(ringBuf bool) (map[string]elf.SectionParams, error) {
+
+	params := make(map[string]elf.SectionParams)
+
+	if ringBuf {
+		if c.RingBufSize == 0 {
+			return params, nil
+		}
+		ps := unix.Getpagesize()
+		if c.RingBufSize < ps || c.RingBufSize&(c.RingBufSize-1) != 0 {
+			return nil, errRingBufSize
+		}
+		for _, m := range []string{ringUpdateMap, ringDestroyMap} {
+			params["maps/"+m] = elf.SectionParams{MapMaxEntries: c.RingBufSize}
+		}
+		return params, nil
+	}
+
+	if c.PerfPageCount < 0 || c.PerfPageCount&(c.PerfPageCount-1) != 0 {
+		return nil, errPerfPageCount
+	}
+
+	// The probe sets up its own perf buffers, see perfReader.
+	for _, m := range []string{perfUpdateMap, perfDestroyMap} {
+		params["maps/"+m] = elf.SectionParams{SkipPerfMapInitialization: true}
+	}
+
+	return params, nil
+}
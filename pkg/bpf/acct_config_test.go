@@ -0,0 +1,139 @@
+package bpf
+
+import (
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLPMKey(t *testing.T) {
+
+	// Key must match the layout of struct lpm_key_t.
+	assert.EqualValues(t, 24, unsafe.Sizeof(lpmKey{}))
+
+	_, n4, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+
+	k, err := newLPMKey(n4)
+	require.NoError(t, err)
+	assert.EqualValues(t, 8+16, k.prefixLen)
+	assert.EqualValues(t, FamilyIPv4, k.family)
+	assert.Equal(t, [16]byte{10, 1}, k.addr)
+
+	_, n6, err := net.ParseCIDR("2001:db8::/32")
+	require.NoError(t, err)
+
+	k, err = newLPMKey(n6)
+	require.NoError(t, err)
+	assert.EqualValues(t, 8+32, k.prefixLen)
+	assert.EqualValues(t, FamilyIPv6, k.family)
+	assert.Equal(t, [16]byte{0x20, 0x01, 0x0d, 0xb8}, k.addr)
+}
+
+func TestConfigMatch(t *testing.T) {
+
+	_, n, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	c := Config{
+		SrcNets:     []*net.IPNet{n},
+		Protos:      []uint8{6, 1},
+		DstPorts:    []PortRange{{First: 80, Last: 443}},
+		NetNS:       []uint32{4026531992},
+		DisableIPv6: true,
+	}
+
+	e := Event{Family: FamilyIPv4, SrcAddr: net.IPv4(10, 1, 2, 3), Proto: 6, DstPort: 443, NetNS: 4026531992}
+	assert.True(t, c.Match(e))
+
+	// The port allowlist doesn't apply to ICMP.
+	icmp := e
+	icmp.Proto, icmp.DstPort = 1, 0
+	assert.True(t, c.Match(icmp))
+
+	for _, f := range []func(*Event){
+		func(e *Event) { e.DstPort = 8080 },
+		func(e *Event) { e.Proto = 17 },
+		func(e *Event) { e.SrcAddr = net.IPv4(192, 168, 0, 1) },
+		func(e *Event) { e.Family = FamilyIPv6 },
+		func(e *Event) { e.NetNS = 4026532000 },
+	} {
+		m := e
+		f(&m)
+		assert.False(t, c.Match(m))
+	}
+}
+
+func TestConfigMatchUpdate(t *testing.T) {
+
+	c := Config{MinBytes: 1000, DisableIPv6: true}
+
+	e := Event{Family: FamilyIPv4, BytesOrig: 600, BytesRet: 400}
+	assert.True(t, c.MatchUpdate(e))
+
+	e.BytesRet = 399
+	assert.False(t, c.MatchUpdate(e))
+	assert.True(t, c.Match(e))
+
+	e.BytesRet, e.Family = 400, FamilyIPv6
+	assert.False(t, c.MatchUpdate(e))
+}
+
+func TestSampled(t *testing.T) {
+
+	assert.True(t, Sampled(1234, 0))
+	assert.True(t, Sampled(1234, 1))
+
+	// Connection IDs are kernel addresses, sample aligned values.
+	var n int
+	for i := uint32(0); i < 100000; i++ {
+		if Sampled(0xff000000+i*256, 100) {
+			n++
+		}
+	}
+	assert.InDelta(t, 1000, n, 200)
+}
+
+func TestConfigSectionParams(t *testing.T) {
+
+	p, err := Config{}.sectionParams(false)
+	require.NoError(t, err)
+	assert.True(t, p["maps/"+perfUpdateMap].SkipPerfMapInitialization)
+	assert.True(t, p["maps/"+perfDestroyMap].SkipPerfMapInitialization)
+
+	_, err = Config{PerfPageCount: 64}.sectionParams(false)
+	require.NoError(t, err)
+
+	_, err = Config{PerfPageCount: 48}.sectionParams(false)
+	assert.Equal(t, errPerfPageCount, err)
+
+	p, err = Config{RingBufSize: 1 << 24}.sectionParams(true)
+	require.NoError(t, err)
+	assert.Equal(t, 1<<24, p["maps/"+ringUpdateMap].MapMaxEntries)
+
+	_, err = Config{RingBufSize: 1000}.sectionParams(true)
+	assert.Equal(t, errRingBufSize, err)
+}
+
+func TestNewCooldownClasses(t *testing.T) {
+
+	c, err := newCooldownClasses([]CooldownClass{
+		{MinRate: 1000, CooldownMillis: 5000},
+		{MinRate: 1000000, CooldownMillis: 500},
+	})
+	require.NoError(t, err)
+
+	// Highest rate first, unused entries left zero.
+	assert.Equal(t, cooldownClass{minRate: 1000000, cooldown: 500000000}, c[0])
+	assert.Equal(t, cooldownClass{minRate: 1000, cooldown: 5000000000}, c[1])
+	assert.Zero(t, c[2])
+
+	_, err = newCooldownClasses(make([]CooldownClass, 5))
+	assert.Equal(t, errCooldownClasses, err)
+
+	_, err = newCooldownClasses([]CooldownClass{{MinRate: 1}})
+	assert.Error(t, err)
+}
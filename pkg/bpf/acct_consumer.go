@@ -1,5 +1,10 @@
 package bpf
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // ConsumerMode defines whether the consumer
 // receives updates, destroys, or both.
 type ConsumerMode uint8
@@ -11,14 +16,82 @@ const (
 	ConsumerAll     ConsumerMode = (ConsumerUpdate | ConsumerDestroy)
 )
 
+// LostReason is the reason events were lost by a Consumer.
+type LostReason uint8
+
+// Reasons events were lost.
+const (
+	// The Consumer's event channel was full.
+	LostChannelFull LostReason = iota
+	// The kernel dropped the events due to full perf buffers.
+	LostKernel
+)
+
+func (r LostReason) String() string {
+	if r == LostKernel {
+		return "kernel"
+	}
+	return "channel-full"
+}
+
+// Backpressure decides what happens to events sent to a Consumer
+// whose event channel is full.
+type Backpressure uint8
+
+// Backpressure policies.
+const (
+	// Drop the event and count it as lost. The probe's readers never wait
+	// for consumers, so the kernel's buffers keep being drained.
+	BackpressureDrop Backpressure = iota
+	// Block the probe's readers until the Consumer accepts the event. No
+	// events are lost between the probe and the Consumer, but the kernel
+	// drops events when its buffers fill up while the readers are blocked.
+	BackpressureBlock
+)
+
+func (b Backpressure) String() string {
+	if b == BackpressureBlock {
+		return "block"
+	}
+	return "drop"
+}
+
+// ParseBackpressure parses the name of a Backpressure policy, either drop
+// or block. An empty name selects BackpressureDrop.
+func ParseBackpressure(s string) (Backpressure, error) {
+	switch s {
+	case "", "drop":
+		return BackpressureDrop, nil
+	case "block":
+		return BackpressureBlock, nil
+	default:
+		return 0, fmt.Errorf(errFmtBackpressure, s)
+	}
+}
+
+// LostFunc is called with the amount of events lost by a Consumer.
+// It's called from the probe's workers, so it must not block.
+type LostFunc func(c *Consumer, reason LostReason, n uint64)
+
 // A Consumer of accounting events.
 type Consumer struct {
 	name string
 
 	events chan Event
-	lost   uint64
+
+	// Amount of events sent on the event channel, lost because it was full,
+	// and events of the kind the consumer subscribes to dropped by the kernel.
+	delivered  uint64
+	lost       uint64
+	lostKernel uint64
+
+	// Holds a LostFunc, called when events are lost.
+	onLost atomic.Value
 
 	mode ConsumerMode // bitfield for which events to subscribe to
+
+	// What happens to events when the event channel is full.
+	backpressure Backpressure
 }
 
 // NewConsumer returns a new Consumer.
@@ -47,6 +120,52 @@ func (ac *Consumer) WantDestroy() bool {
 	return (ac.mode & ConsumerDestroy) > 0
 }
 
+// Name returns the Consumer's name.
+func (ac *Consumer) Name() string {
+	return ac.name
+}
+
+// Lost returns the amount of events dropped because the Consumer's
+// event channel was full.
+func (ac *Consumer) Lost() uint64 {
+	return atomic.LoadUint64(&ac.lost)
+}
+
+// LostKernel returns the amount of events of the kind the Consumer subscribes
+// to that were dropped by the kernel due to full perf buffers. Always zero for
+// probes using BPF ring buffers, which don't report lost events.
+func (ac *Consumer) LostKernel() uint64 {
+	return atomic.LoadUint64(&ac.lostKernel)
+}
+
+// SetOnLost sets a function called whenever the Consumer loses events.
+// A nil function disables the callback.
+func (ac *Consumer) SetOnLost(f LostFunc) {
+	ac.onLost.Store(f)
+}
+
+// addLost records the loss of n events and calls the Consumer's LostFunc.
+func (ac *Consumer) addLost(reason LostReason, n uint64) {
+
+	if reason == LostKernel {
+		atomic.AddUint64(&ac.lostKernel, n)
+	} else {
+		atomic.AddUint64(&ac.lost, n)
+	}
+
+	if f, _ := ac.onLost.Load().(LostFunc); f != nil {
+		f(ac, reason, n)
+	}
+}
+
+// SetBackpressure sets what happens to events sent to the Consumer while its
+// event channel is full, BackpressureDrop by default. Blocking Consumers hold
+// up the delivery of events to all Consumers of the probe.
+// Must be called before the Consumer is registered to a Probe.
+func (ac *Consumer) SetBackpressure(b Backpressure) {
+	ac.backpressure = b
+}
+
 // Close closes the Consumer's event channel.
 func (ac *Consumer) Close() {
 	close(ac.events)
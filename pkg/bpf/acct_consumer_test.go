@@ -0,0 +1,114 @@
+package bpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerLost(t *testing.T) {
+
+	var ap Probe
+
+	uc := NewConsumer("update", make(chan Event, 1), ConsumerUpdate)
+	dc := NewConsumer("destroy", make(chan Event, 1), ConsumerDestroy)
+	require.NoError(t, ap.RegisterConsumer(uc))
+	require.NoError(t, ap.RegisterConsumer(dc))
+
+	var calls []LostReason
+	uc.SetOnLost(func(c *Consumer, r LostReason, n uint64) {
+		assert.Equal(t, uc, c)
+		calls = append(calls, r)
+	})
+
+	// The second update event doesn't fit in the channel.
+	ap.fanoutEvent(Event{}, true)
+	ap.fanoutEvent(Event{}, true)
+	assert.EqualValues(t, 1, uc.Lost())
+	assert.EqualValues(t, 0, dc.Lost())
+
+	ap.lostUpdateChan = make(chan uint64)
+	ap.lostDestroyChan = make(chan uint64)
+	done := make(chan struct{})
+	go func() {
+		lostWorker(&ap)
+		close(done)
+	}()
+
+	ap.lostUpdateChan <- 5
+	close(ap.lostUpdateChan)
+	close(ap.lostDestroyChan)
+	<-done
+
+	assert.EqualValues(t, 5, uc.LostKernel())
+	assert.EqualValues(t, 0, dc.LostKernel())
+	assert.EqualValues(t, 5, ap.Lost())
+	assert.Equal(t, []LostReason{LostChannelFull, LostKernel}, calls)
+}
+
+func TestConsumerBackpressure(t *testing.T) {
+
+	var ap Probe
+
+	c := NewConsumer("block", make(chan Event, 1), ConsumerAll)
+	c.SetBackpressure(BackpressureBlock)
+	require.NoError(t, ap.RegisterConsumer(c))
+
+	// The second event waits for the first one to be received.
+	done := make(chan struct{})
+	go func() {
+		ap.fanoutEvent(Event{ConnectionID: 1}, true)
+		ap.fanoutEvent(Event{ConnectionID: 2}, true)
+		close(done)
+	}()
+
+	assert.EqualValues(t, 1, (<-c.events).ConnectionID)
+	assert.EqualValues(t, 2, (<-c.events).ConnectionID)
+	<-done
+	assert.Zero(t, c.Lost())
+
+	b, err := ParseBackpressure("block")
+	require.NoError(t, err)
+	assert.Equal(t, BackpressureBlock, b)
+
+	b, err = ParseBackpressure("")
+	require.NoError(t, err)
+	assert.Equal(t, BackpressureDrop, b)
+
+	_, err = ParseBackpressure("spill")
+	assert.Error(t, err)
+}
+
+func TestProbeLostSamples(t *testing.T) {
+
+	var ap Probe
+
+	var cpus []int
+	ap.SetOnLostSamples(func(cpu int, n uint64) {
+		cpus = append(cpus, cpu)
+	})
+
+	ap.lostSamples(2, 3)
+	ap.lostSamples(0, 1)
+	ap.lostSamples(2, 4)
+
+	assert.Equal(t, map[int]uint64{0: 1, 2: 7}, ap.LostPerCPU())
+	assert.Equal(t, []int{2, 0, 2}, cpus)
+}
+
+func TestProbeStats(t *testing.T) {
+
+	var ap Probe
+
+	c := NewConsumer("update", make(chan Event, 1), ConsumerUpdate)
+	require.NoError(t, ap.RegisterConsumer(c))
+
+	ap.fanoutEvent(Event{}, true)
+	ap.fanoutEvent(Event{}, true)
+	ap.lostSamples(1, 2)
+
+	s := ap.Stats()
+	assert.Equal(t, map[int]uint64{1: 2}, s.LostPerCPU)
+	assert.Equal(t, map[string]ConsumerStats{"update": {Delivered: 1, Lost: 1}}, s.Consumers)
+}
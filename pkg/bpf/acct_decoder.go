@@ -0,0 +1,67 @@
+package bpf
+
+// Amount of events whose addresses are stored in one allocation by a Decoder.
+const decoderSlabEvents = 256
+
+// Amount of record buffers kept for reuse by a probe.
+const recordPoolSize = 4096
+
+// Decoder decodes binary Events without allocating per event. The addresses
+// of decoded events are stored in slabs shared by many events. Events don't
+// reference the decoded records, so these can be reused. Consumers retaining
+// events for long should retain a Clone, so they don't keep a slab alive.
+// A Decoder is not safe for concurrent use.
+type Decoder struct {
+	slab []byte
+}
+
+// Decode decodes the binary Event in b into e, overwriting all of its
+// fields set by the probe. Fields set in userspace are left untouched.
+func (d *Decoder) Decode(e *Event, b []byte) error {
+
+	if len(d.slab) < eventAddrsLen {
+		d.slab = make([]byte, decoderSlabEvents*eventAddrsLen)
+	}
+
+	if err := e.unmarshal(b, d.slab[:eventAddrsLen:eventAddrsLen]); err != nil {
+		return err
+	}
+	d.slab = d.slab[eventAddrsLen:]
+
+	return nil
+}
+
+// recordPool is a free list of the buffers of records read from the probe's
+// perf or ring buffers. Buffers are allocated when the list is empty and
+// dropped when it's full.
+type recordPool chan []byte
+
+// newRecordPool returns a recordPool holding at most size buffers.
+func newRecordPool(size int) recordPool {
+	return make(recordPool, size)
+}
+
+// get returns a buffer of n bytes, reusing a free one if possible.
+// Getting from a nil recordPool always allocates.
+func (p recordPool) get(n int) []byte {
+
+	var b []byte
+	select {
+	case b = <-p:
+	default:
+	}
+
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+
+	return b[:n]
+}
+
+// put returns a buffer to the pool, the caller must not use it afterwards.
+func (p recordPool) put(b []byte) {
+	select {
+	case p <- b:
+	default:
+	}
+}
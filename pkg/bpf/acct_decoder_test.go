@@ -0,0 +1,93 @@
+package bpf
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecoder(t *testing.T) {
+
+	in := Event{
+		SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.ParseIP("2001:db8::2"),
+		Proto: 6, Family: FamilyIPv4,
+	}
+	b, err := in.MarshalBinary()
+	require.NoError(t, err)
+
+	var d Decoder
+	var e1, e2 Event
+	require.NoError(t, d.Decode(&e1, b))
+
+	// Decoded events don't reference their record.
+	for i := range b {
+		b[i] = 0
+	}
+	assert.Equal(t, "10.0.0.1", e1.SrcAddr.String())
+
+	in.SrcAddr, in.DstAddr = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	in.Family = FamilyIPv6
+	b, err = in.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, d.Decode(&e2, b))
+
+	// Events don't share their addresses.
+	assert.Equal(t, "10.0.0.1", e1.SrcAddr.String())
+	assert.Equal(t, "2001:db8::1", e2.SrcAddr.String())
+	assert.Equal(t, "2001:db8::2", e2.DstAddr.String())
+
+	_ = append(e2.SrcAddr, 1)
+	assert.Equal(t, "2001:db8::2", e2.DstAddr.String())
+
+	// Decoding allocates once per slab.
+	var e Event
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = d.Decode(&e, b)
+	})
+	assert.Zero(t, allocs)
+
+	assert.Error(t, d.Decode(&e, b[:10]))
+}
+
+func TestEventClone(t *testing.T) {
+
+	var d Decoder
+	var e Event
+	b, err := (&Event{SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2), Family: FamilyIPv4}).MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, d.Decode(&e, b))
+	e.SetTag("pod", "web")
+
+	c := e.Clone()
+	assert.Equal(t, e.String(), c.String())
+
+	c.SrcAddr[15] = 9
+	c.SetTag("pod", "db")
+	assert.Equal(t, "10.0.0.1", e.SrcAddr.String())
+	assert.Equal(t, "web", e.Tags["pod"])
+	assert.Equal(t, "10.0.0.2", c.DstAddr.String())
+
+	// Events without addresses or tags are cloned as such.
+	c = (&Event{}).Clone()
+	assert.Nil(t, c.SrcAddr)
+	assert.Nil(t, c.Tags)
+}
+
+func TestRecordPool(t *testing.T) {
+
+	p := newRecordPool(1)
+
+	b := p.get(8)
+	assert.Len(t, b, 8)
+	p.put(b)
+	p.put(make([]byte, 4))
+
+	// Buffers are reused if they're large enough.
+	r := p.get(4)
+	assert.Len(t, r, 4)
+	assert.Equal(t, 8, cap(r))
+
+	assert.Len(t, recordPool(nil).get(2), 2)
+}
@@ -4,11 +4,51 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"time"
 	"unsafe"
 )
 
 // EventLength is the length of the struct sent by BPF.
-const EventLength = 104
+const EventLength = 128
+
+// eventLengthV1 is the length of events sent by probes built before
+// the interface index was added to the struct.
+const eventLengthV1 = 104
+
+// eventLengthV2 is the length of events sent by probes built before
+// the wall clock time stamp was added to the struct.
+const eventLengthV2 = 112
+
+// eventLengthV3 is the length of events sent by probes built before
+// sequence numbers were added to the struct.
+const eventLengthV3 = 120
+
+// eventAddrsLen is the length of the storage of an Event's addresses.
+const eventAddrsLen = 2 * net.IPv6len
+
+// Prefix of an IPv4 address in 16-byte form.
+var v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// Protocol numbers of ICMP flows, whose events carry an ICMP identifier,
+// type and code instead of ports.
+const (
+	ProtoICMP   uint8 = 1
+	ProtoICMPv6 uint8 = 58
+)
+
+// Protocol numbers of flows with ports.
+const (
+	ProtoTCP     uint8 = 6
+	ProtoUDP     uint8 = 17
+	ProtoSCTP    uint8 = 132
+	ProtoUDPLite uint8 = 136
+)
+
+// Address families of an Event.
+const (
+	FamilyIPv4 uint8 = 2  // AF_INET
+	FamilyIPv6 uint8 = 10 // AF_INET6
+)
 
 // Event is an accounting event delivered to userspace from the Probe.
 type Event struct {
@@ -26,13 +66,78 @@ type Event struct {
 	DstPort      uint16
 	NetNS        uint32
 	Proto        uint8
+	Family       uint8 // FamilyIPv4 or FamilyIPv6
+	Zone         uint16
+	TCPState     uint8 // enum tcp_conntrack, zero for other protocols
+
+	// Identifier, type and code of the request starting an ICMP or ICMPv6
+	// flow, zero for other protocols.
+	ICMPID   uint16
+	ICMPType uint8
+	ICMPCode uint8
+
+	// Interface of the last packet accounted in an update event, within the
+	// flow's network namespace. Always zero for destroy events.
+	Ifindex uint32
+
+	// Wall clock time of the event in nanoseconds since the UNIX epoch,
+	// recorded by the kernel when Config.RealTime is set. Zero if unknown,
+	// eg. for probes built for kernels before 6.1.
+	RealTime uint64
+
+	// Sequence number of the event among the update or destroy events sent
+	// by the probe on CPU, starting at 1 and skipping 0 when wrapping around.
+	// A gap between two events means the events in between were lost.
+	// Zero if unknown, eg. for probes built before sequence numbers.
+	Seq uint32
+	CPU uint32
+
+	// Set in userspace on destroy events, whose counters are the flow's
+	// final totals.
+	Destroy bool
+
+	// Lifetime of the flow on destroy events, from its start to the event.
+	// Zero if unknown, eg. when conntrack timestamping is disabled.
+	Duration time.Duration
+
+	// Set in userspace when the event's source reports 1 in SampleRate flows,
+	// zero if all flows are reported. Counters are not scaled.
+	SampleRate uint32
+
+	// Throughput of the flow since its previous event, computed in userspace.
+	// Zero if not computed, eg. for the first event of a flow.
+	Rate Rate
+
+	// Metadata attached to the event in userspace, eg. by enrichers.
+	// Nil until the first tag is set.
+	Tags map[string]string
+}
+
+// Rate is the throughput of a flow between two of its events, per second.
+type Rate struct {
+	// Time between the events, zero if the rate was not computed.
+	Interval time.Duration
+
+	PacketsOrig float64
+	BytesOrig   float64
+	PacketsRet  float64
+	BytesRet    float64
 }
 
 // UnmarshalBinary unmarshals a binary Event representation
 // into a struct, using the machine's native endianness.
+// The Event doesn't reference b afterwards.
 func (e *Event) UnmarshalBinary(b []byte) error {
+	return e.unmarshal(b, make([]byte, eventAddrsLen))
+}
 
-	if len(b) != EventLength {
+// unmarshal unmarshals a binary Event, storing its addresses in addrs,
+// which must be eventAddrsLen bytes long.
+func (e *Event) unmarshal(b, addrs []byte) error {
+
+	switch len(b) {
+	case EventLength, eventLengthV1, eventLengthV2, eventLengthV3:
+	default:
 		return fmt.Errorf("input byte array incorrect length %d", len(b))
 	}
 
@@ -41,39 +146,197 @@ func (e *Event) UnmarshalBinary(b []byte) error {
 	e.ConnectionID = *(*uint32)(unsafe.Pointer(&b[16]))
 	e.Connmark = *(*uint32)(unsafe.Pointer(&b[20]))
 
-	// Build an IPv4 address if only the first four bytes
-	// of the nf_inet_addr union are filled.
-	// Assigning 4 bytes directly into IP() is incorrect,
-	// an IPv4 is stored in the last 4 bytes of an IP().
-	if isIPv4(b[24:40]) {
-		e.SrcAddr = net.IPv4(b[24], b[25], b[26], b[27])
-	} else {
-		e.SrcAddr = net.IP(b[24:40])
+	// Probes built before the address family was added to the event
+	// leave it zero, guess the family from the source address instead.
+	// This misdetects IPv6 addresses with their last 12 bytes set to zero.
+	e.Family = b[97]
+	if e.Family == 0 {
+		e.Family = FamilyIPv6
+		if isIPv4(b[24:40]) {
+			e.Family = FamilyIPv4
+		}
 	}
 
-	if isIPv4(b[40:56]) {
-		e.DstAddr = net.IPv4(b[40], b[41], b[42], b[43])
+	// Build an IPv4 address from the first four bytes
+	// of the nf_inet_addr union.
+	// Assigning 4 bytes directly into IP() is incorrect,
+	// an IPv4 is stored in the last 4 bytes of an IP(),
+	// like net.IPv4 does.
+	if e.Family == FamilyIPv4 {
+		copy(addrs[0:12], v4InV6Prefix)
+		copy(addrs[12:16], b[24:28])
+		copy(addrs[16:28], v4InV6Prefix)
+		copy(addrs[28:32], b[40:44])
 	} else {
-		e.DstAddr = net.IP(b[40:56])
+		copy(addrs[0:16], b[24:40])
+		copy(addrs[16:32], b[40:56])
 	}
 
+	// Limit the capacity of the addresses, so appending
+	// to one doesn't overwrite the other.
+	e.SrcAddr = net.IP(addrs[0:16:16])
+	e.DstAddr = net.IP(addrs[16:32:32])
+
 	e.PacketsOrig = *(*uint64)(unsafe.Pointer(&b[56]))
 	e.BytesOrig = *(*uint64)(unsafe.Pointer(&b[64]))
 	e.PacketsRet = *(*uint64)(unsafe.Pointer(&b[72]))
 	e.BytesRet = *(*uint64)(unsafe.Pointer(&b[80]))
 
-	// Only extract ports for UDP and TCP.
+	// Only extract ports for protocols that have them. ICMP flows store the identifier
+	// in the source port and the type and code in the destination port.
 	e.Proto = b[96]
-	if e.Proto == 6 || e.Proto == 17 {
+	e.SrcPort, e.DstPort = 0, 0
+	e.ICMPID, e.ICMPType, e.ICMPCode = 0, 0, 0
+	switch {
+	case e.HasPorts():
 		e.SrcPort = binary.BigEndian.Uint16(b[88:90])
 		e.DstPort = binary.BigEndian.Uint16(b[90:92])
+	case e.ICMP():
+		e.ICMPID = binary.BigEndian.Uint16(b[88:90])
+		e.ICMPType = b[90]
+		e.ICMPCode = b[91]
 	}
 
 	e.NetNS = *(*uint32)(unsafe.Pointer(&b[92]))
+	e.Zone = *(*uint16)(unsafe.Pointer(&b[98]))
+	e.TCPState = b[100]
+
+	e.Ifindex = 0
+	if len(b) >= eventLengthV2 {
+		e.Ifindex = *(*uint32)(unsafe.Pointer(&b[104]))
+	}
+
+	e.RealTime = 0
+	if len(b) >= eventLengthV3 {
+		e.RealTime = *(*uint64)(unsafe.Pointer(&b[112]))
+	}
+
+	e.Seq, e.CPU = 0, 0
+	if len(b) == EventLength {
+		e.Seq = *(*uint32)(unsafe.Pointer(&b[120]))
+		e.CPU = *(*uint32)(unsafe.Pointer(&b[124]))
+	}
 
 	return nil
 }
 
+// Clone returns a deep copy of the Event. Events decoded by a Decoder
+// share the storage of their addresses with other events, consumers
+// retaining events for long should retain a clone instead, so they don't
+// keep that storage alive.
+func (e *Event) Clone() Event {
+
+	c := *e
+
+	addrs := make([]byte, 0, eventAddrsLen)
+	if e.SrcAddr != nil {
+		addrs = append(addrs, e.SrcAddr...)
+		c.SrcAddr = net.IP(addrs[0:len(e.SrcAddr):len(e.SrcAddr)])
+	}
+	if e.DstAddr != nil {
+		n := len(addrs)
+		addrs = append(addrs, e.DstAddr...)
+		c.DstAddr = net.IP(addrs[n:len(addrs):len(addrs)])
+	}
+
+	if e.Tags != nil {
+		c.Tags = make(map[string]string, len(e.Tags))
+		for k, v := range e.Tags {
+			c.Tags[k] = v
+		}
+	}
+
+	return c
+}
+
+// HasPorts returns true if the Event belongs to a TCP, UDP, SCTP or UDPLite
+// flow, the protocols with ports tracked by conntrack.
+func (e *Event) HasPorts() bool {
+	switch e.Proto {
+	case ProtoTCP, ProtoUDP, ProtoSCTP, ProtoUDPLite:
+		return true
+	}
+	return false
+}
+
+// ICMP returns true if the Event belongs to an ICMP or ICMPv6 flow.
+func (e *Event) ICMP() bool {
+	return e.Proto == ProtoICMP || e.Proto == ProtoICMPv6
+}
+
+// Type returns the type of the Event, 'update' or 'destroy'.
+func (e *Event) Type() string {
+	if e.Destroy {
+		return "destroy"
+	}
+	return "update"
+}
+
+// Time returns the absolute time of the Event. That's the wall clock time
+// recorded by the kernel if known, otherwise the event's ktime time stamp
+// made absolute using the machine's boot time.
+func (e *Event) Time(boot time.Time) time.Time {
+	if e.RealTime != 0 {
+		return time.Unix(0, int64(e.RealTime))
+	}
+	return boot.Add(time.Duration(e.Timestamp))
+}
+
+// MarshalBinary marshals an Event into the binary representation sent by
+// the probe, using the machine's native endianness. Fields set in userspace,
+// like Tags, Rate and SampleRate, are not included.
+func (e *Event) MarshalBinary() ([]byte, error) {
+
+	b := make([]byte, EventLength)
+
+	*(*uint64)(unsafe.Pointer(&b[0])) = e.Start
+	*(*uint64)(unsafe.Pointer(&b[8])) = e.Timestamp
+	*(*uint32)(unsafe.Pointer(&b[16])) = e.ConnectionID
+	*(*uint32)(unsafe.Pointer(&b[20])) = e.Connmark
+
+	// IPv4 addresses are stored in the first four bytes of nf_inet_addr.
+	if e.Family == FamilyIPv4 {
+		copy(b[24:28], e.SrcAddr.To4())
+		copy(b[40:44], e.DstAddr.To4())
+	} else {
+		copy(b[24:40], e.SrcAddr.To16())
+		copy(b[40:56], e.DstAddr.To16())
+	}
+
+	*(*uint64)(unsafe.Pointer(&b[56])) = e.PacketsOrig
+	*(*uint64)(unsafe.Pointer(&b[64])) = e.BytesOrig
+	*(*uint64)(unsafe.Pointer(&b[72])) = e.PacketsRet
+	*(*uint64)(unsafe.Pointer(&b[80])) = e.BytesRet
+
+	if e.ICMP() {
+		binary.BigEndian.PutUint16(b[88:90], e.ICMPID)
+		b[90], b[91] = e.ICMPType, e.ICMPCode
+	} else {
+		binary.BigEndian.PutUint16(b[88:90], e.SrcPort)
+		binary.BigEndian.PutUint16(b[90:92], e.DstPort)
+	}
+
+	*(*uint32)(unsafe.Pointer(&b[92])) = e.NetNS
+	b[96] = e.Proto
+	b[97] = e.Family
+	*(*uint16)(unsafe.Pointer(&b[98])) = e.Zone
+	b[100] = e.TCPState
+	*(*uint32)(unsafe.Pointer(&b[104])) = e.Ifindex
+	*(*uint64)(unsafe.Pointer(&b[112])) = e.RealTime
+	*(*uint32)(unsafe.Pointer(&b[120])) = e.Seq
+	*(*uint32)(unsafe.Pointer(&b[124])) = e.CPU
+
+	return b, nil
+}
+
+// SetTag sets a metadata tag on the Event, allocating its tag map if needed.
+func (e *Event) SetTag(k, v string) {
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+	e.Tags[k] = v
+}
+
 // String returns a readable string representation of the Event.
 func (e *Event) String() string {
 	return fmt.Sprintf("%+v", *e)
@@ -0,0 +1,381 @@
+package bpf
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ti-mo/conntracct/pkg/boottime"
+)
+
+// Clock of the machine for converting event time stamps to and from
+// their JSON form, created on first use.
+var (
+	jsonClockOnce sync.Once
+	jsonClock     *boottime.Clock
+)
+
+func bootTime() time.Time {
+	jsonClockOnce.Do(func() { jsonClock = boottime.NewClock() })
+	return jsonClock.Boot()
+}
+
+// MarshalJSON marshals an Event into its JSON form, an object with the fields
+//
+//	timestamp     time of the event, RFC 3339 with nanoseconds
+//	event_type    'update' or 'destroy'
+//	start         start of the flow, RFC 3339, omitted if unknown
+//	duration      lifetime of the flow on destroy events in nanoseconds, omitted if unknown
+//	sample_rate   omitted if all flows are reported
+//	conn_id, connmark
+//	src_addr, dst_addr
+//	src_port      omitted if zero, eg. for protocols without ports
+//	dst_port
+//	proto         protocol name like 'tcp'
+//	family        'ipv4' or 'ipv6', omitted if zero
+//	tcp_state     connection state like 'established', only for TCP flows
+//	netns, zone
+//	ifindex       omitted if zero
+//	packets_orig, bytes_orig, packets_ret, bytes_ret
+//	icmp          object with id, type and code, only for ICMP and ICMPv6 flows
+//	rate          object with interval_ms, packets_orig, bytes_orig, packets_ret
+//	              and bytes_ret, omitted if not computed
+//	tags          object, omitted if empty
+//
+// Protocols, families and TCP states without a name are given as numbers.
+// These names are stable, fields are only ever added.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return e.AppendJSON(make([]byte, 0, 512), false), nil
+}
+
+// AppendJSON appends the JSON form of the Event to b. If raw is set, the
+// numeric form is appended instead, which is cheaper to produce and parse
+// and reversible without loss: timestamp is the event's ktime time stamp,
+// real_ts its wall clock time stamp in nanoseconds since the epoch, omitted
+// if unknown, and start the flow's start in nanoseconds since the epoch,
+// proto, family and tcp_state are numbers and the rate's interval is given
+// in interval_ns. UnmarshalJSON accepts both forms.
+func (e Event) AppendJSON(b []byte, raw bool) []byte {
+
+	b = append(b, `{"timestamp":`...)
+	if raw {
+		b = strconv.AppendUint(b, e.Timestamp, 10)
+		if e.RealTime != 0 {
+			b = appendUint(b, "real_ts", e.RealTime)
+		}
+	} else {
+		b = appendTime(b, e.Time(bootTime()))
+	}
+
+	b = append(b, `,"event_type":"`...)
+	b = append(b, e.Type()...)
+	b = append(b, '"')
+
+	if e.Start != 0 {
+		b = append(b, `,"start":`...)
+		if raw {
+			b = strconv.AppendUint(b, e.Start, 10)
+		} else {
+			b = appendTime(b, time.Unix(0, int64(e.Start)))
+		}
+	}
+	if e.Duration != 0 {
+		b = append(b, `,"duration":`...)
+		b = strconv.AppendInt(b, int64(e.Duration), 10)
+	}
+	if e.SampleRate != 0 {
+		b = appendUint(b, "sample_rate", uint64(e.SampleRate))
+	}
+
+	b = appendUint(b, "conn_id", uint64(e.ConnectionID))
+	b = appendUint(b, "connmark", uint64(e.Connmark))
+	b = appendString(b, "src_addr", ipString(e.SrcAddr))
+	b = appendString(b, "dst_addr", ipString(e.DstAddr))
+	if e.SrcPort != 0 {
+		b = appendUint(b, "src_port", uint64(e.SrcPort))
+	}
+	b = appendUint(b, "dst_port", uint64(e.DstPort))
+
+	if raw {
+		b = appendUint(b, "proto", uint64(e.Proto))
+		if e.Family != 0 {
+			b = appendUint(b, "family", uint64(e.Family))
+		}
+		if e.Proto == ProtoTCP {
+			b = appendUint(b, "tcp_state", uint64(e.TCPState))
+		}
+	} else {
+		b = appendName(b, "proto", ProtoName(e.Proto), e.Proto)
+		if e.Family != 0 {
+			b = appendName(b, "family", FamilyName(e.Family), e.Family)
+		}
+		if e.Proto == ProtoTCP {
+			b = appendName(b, "tcp_state", TCPStateName(e.TCPState), e.TCPState)
+		}
+	}
+
+	b = appendUint(b, "netns", uint64(e.NetNS))
+	b = appendUint(b, "zone", uint64(e.Zone))
+	if e.Ifindex != 0 {
+		b = appendUint(b, "ifindex", uint64(e.Ifindex))
+	}
+
+	b = appendUint(b, "packets_orig", e.PacketsOrig)
+	b = appendUint(b, "bytes_orig", e.BytesOrig)
+	b = appendUint(b, "packets_ret", e.PacketsRet)
+	b = appendUint(b, "bytes_ret", e.BytesRet)
+
+	if e.ICMP() {
+		b = append(b, `,"icmp":{"id":`...)
+		b = strconv.AppendUint(b, uint64(e.ICMPID), 10)
+		b = appendUint(b, "type", uint64(e.ICMPType))
+		b = appendUint(b, "code", uint64(e.ICMPCode))
+		b = append(b, '}')
+	}
+
+	if e.Rate.Interval != 0 {
+		if raw {
+			b = append(b, `,"rate":{"interval_ns":`...)
+			b = strconv.AppendInt(b, int64(e.Rate.Interval), 10)
+		} else {
+			b = append(b, `,"rate":{"interval_ms":`...)
+			b = strconv.AppendInt(b, int64(e.Rate.Interval/time.Millisecond), 10)
+		}
+		b = appendFloat(b, "packets_orig", e.Rate.PacketsOrig)
+		b = appendFloat(b, "bytes_orig", e.Rate.BytesOrig)
+		b = appendFloat(b, "packets_ret", e.Rate.PacketsRet)
+		b = appendFloat(b, "bytes_ret", e.Rate.BytesRet)
+		b = append(b, '}')
+	}
+
+	if len(e.Tags) != 0 {
+		// Tags are arbitrary strings, leave escaping them to encoding/json.
+		// Marshaling a map[string]string never fails.
+		t, _ := json.Marshal(e.Tags)
+		b = append(b, `,"tags":`...)
+		b = append(b, t...)
+	}
+
+	return append(b, '}')
+}
+
+// eventJSON is the decoding target of both JSON forms of an Event.
+// Fields that differ between the forms are decoded later.
+type eventJSON struct {
+	Timestamp    json.RawMessage `json:"timestamp"`
+	RealTime     uint64          `json:"real_ts"`
+	EventType    string          `json:"event_type"`
+	Start        json.RawMessage `json:"start"`
+	Duration     int64           `json:"duration"`
+	SampleRate   uint32          `json:"sample_rate"`
+	ConnectionID uint32          `json:"conn_id"`
+	Connmark     uint32          `json:"connmark"`
+	SrcAddr      net.IP          `json:"src_addr"`
+	DstAddr      net.IP          `json:"dst_addr"`
+	SrcPort      uint16          `json:"src_port"`
+	DstPort      uint16          `json:"dst_port"`
+	Proto        json.RawMessage `json:"proto"`
+	Family       json.RawMessage `json:"family"`
+	TCPState     json.RawMessage `json:"tcp_state"`
+	NetNS        uint32          `json:"netns"`
+	Zone         uint16          `json:"zone"`
+	Ifindex      uint32          `json:"ifindex"`
+	PacketsOrig  uint64          `json:"packets_orig"`
+	BytesOrig    uint64          `json:"bytes_orig"`
+	PacketsRet   uint64          `json:"packets_ret"`
+	BytesRet     uint64          `json:"bytes_ret"`
+
+	ICMP *struct {
+		ID   uint16 `json:"id"`
+		Type uint8  `json:"type"`
+		Code uint8  `json:"code"`
+	} `json:"icmp"`
+
+	Rate *struct {
+		IntervalMs  int64   `json:"interval_ms"`
+		IntervalNs  int64   `json:"interval_ns"`
+		PacketsOrig float64 `json:"packets_orig"`
+		BytesOrig   float64 `json:"bytes_orig"`
+		PacketsRet  float64 `json:"packets_ret"`
+		BytesRet    float64 `json:"bytes_ret"`
+	} `json:"rate"`
+
+	Tags map[string]string `json:"tags"`
+}
+
+// UnmarshalJSON unmarshals either JSON form of an Event produced by AppendJSON.
+func (e *Event) UnmarshalJSON(b []byte) error {
+
+	var ej eventJSON
+	if err := json.Unmarshal(b, &ej); err != nil {
+		return err
+	}
+
+	*e = Event{
+		RealTime:     ej.RealTime,
+		Destroy:      ej.EventType == "destroy",
+		Duration:     time.Duration(ej.Duration),
+		SampleRate:   ej.SampleRate,
+		ConnectionID: ej.ConnectionID,
+		Connmark:     ej.Connmark,
+		SrcAddr:      ej.SrcAddr,
+		DstAddr:      ej.DstAddr,
+		SrcPort:      ej.SrcPort,
+		DstPort:      ej.DstPort,
+		NetNS:        ej.NetNS,
+		Zone:         ej.Zone,
+		Ifindex:      ej.Ifindex,
+		PacketsOrig:  ej.PacketsOrig,
+		BytesOrig:    ej.BytesOrig,
+		PacketsRet:   ej.PacketsRet,
+		BytesRet:     ej.BytesRet,
+		Tags:         ej.Tags,
+	}
+
+	ts, err := unmarshalTime(ej.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, "timestamp")
+	}
+	if d := ts.t.Sub(bootTime()); !ts.raw && !ts.t.IsZero() && d > 0 {
+		ts.ns = uint64(d)
+	}
+	e.Timestamp = ts.ns
+
+	start, err := unmarshalTime(ej.Start)
+	if err != nil {
+		return errors.Wrap(err, "start")
+	}
+	if !start.raw && !start.t.IsZero() {
+		start.ns = uint64(start.t.UnixNano())
+	}
+	e.Start = start.ns
+
+	if e.Proto, err = unmarshalName(ej.Proto, ProtoNumber); err != nil {
+		return errors.Wrap(err, "proto")
+	}
+	if e.Family, err = unmarshalName(ej.Family, familyNumber); err != nil {
+		return errors.Wrap(err, "family")
+	}
+	if e.TCPState, err = unmarshalName(ej.TCPState, tcpStateNumber); err != nil {
+		return errors.Wrap(err, "tcp_state")
+	}
+
+	if ej.ICMP != nil {
+		e.ICMPID, e.ICMPType, e.ICMPCode = ej.ICMP.ID, ej.ICMP.Type, ej.ICMP.Code
+	}
+
+	if r := ej.Rate; r != nil {
+		e.Rate = Rate{
+			Interval:    time.Duration(r.IntervalNs),
+			PacketsOrig: r.PacketsOrig,
+			BytesOrig:   r.BytesOrig,
+			PacketsRet:  r.PacketsRet,
+			BytesRet:    r.BytesRet,
+		}
+		if r.IntervalNs == 0 {
+			e.Rate.Interval = time.Duration(r.IntervalMs) * time.Millisecond
+		}
+	}
+
+	return nil
+}
+
+// jsonTime is a time stamp in either JSON form of an Event: raw
+// nanoseconds if raw is set, an RFC 3339 time otherwise.
+type jsonTime struct {
+	raw bool
+	ns  uint64
+	t   time.Time
+}
+
+func unmarshalTime(b json.RawMessage) (jsonTime, error) {
+
+	var jt jsonTime
+	if len(b) == 0 || string(b) == "null" {
+		return jt, nil
+	}
+
+	if b[0] != '"' {
+		jt.raw = true
+		return jt, json.Unmarshal(b, &jt.ns)
+	}
+
+	return jt, json.Unmarshal(b, &jt.t)
+}
+
+// unmarshalName unmarshals a number, or a name converted to a number by f.
+func unmarshalName(b json.RawMessage, f func(string) (uint8, bool)) (uint8, error) {
+
+	if len(b) == 0 || string(b) == "null" {
+		return 0, nil
+	}
+
+	if b[0] != '"' {
+		var n uint8
+		return n, json.Unmarshal(b, &n)
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return 0, err
+	}
+
+	n, ok := f(s)
+	if !ok {
+		return 0, errors.Errorf("unknown name '%s'", s)
+	}
+
+	return n, nil
+}
+
+func appendTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.UTC().AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+func appendUint(b []byte, name string, v uint64) []byte {
+	b = append(b, `,"`...)
+	b = append(b, name...)
+	b = append(b, `":`...)
+	return strconv.AppendUint(b, v, 10)
+}
+
+func appendFloat(b []byte, name string, v float64) []byte {
+	b = append(b, `,"`...)
+	b = append(b, name...)
+	b = append(b, `":`...)
+	return strconv.AppendFloat(b, v, 'g', -1, 64)
+}
+
+// appendName appends a field with the given name of value v,
+// or v itself if it has no name.
+func appendName(b []byte, field, name string, v uint8) []byte {
+	if name == "unknown" {
+		return appendUint(b, field, uint64(v))
+	}
+	return appendString(b, field, name)
+}
+
+// appendString appends a field with a string value that doesn't need
+// to be escaped.
+func appendString(b []byte, name, v string) []byte {
+	b = append(b, `,"`...)
+	b = append(b, name...)
+	b = append(b, `":"`...)
+	b = append(b, v...)
+	return append(b, '"')
+}
+
+// ipString returns the string representation of an address,
+// or an empty string if it's unset.
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,75 @@
+package bpf
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventJSON(t *testing.T) {
+
+	e := Event{
+		Start:        uint64(time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC).UnixNano()),
+		Timestamp:    uint64(time.Hour),
+		RealTime:     uint64(time.Date(2020, 1, 2, 4, 4, 5, 6, time.UTC).UnixNano()),
+		ConnectionID: 1,
+		SrcAddr:      net.IPv4(10, 0, 0, 1),
+		DstAddr:      net.IPv4(10, 0, 0, 2),
+		SrcPort:      1234,
+		DstPort:      80,
+		Proto:        ProtoTCP,
+		Family:       FamilyIPv4,
+		TCPState:     3,
+		PacketsOrig:  2,
+		BytesOrig:    120,
+		Destroy:      true,
+		Duration:     time.Second,
+		Rate:         Rate{Interval: 1500 * time.Microsecond, BytesOrig: 1.5},
+		Tags:         map[string]string{"k": "v"},
+	}
+
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, "destroy", m["event_type"])
+	assert.Equal(t, "2020-01-02T03:04:05.000000006Z", m["start"])
+	assert.Equal(t, "tcp", m["proto"])
+	assert.Equal(t, "ipv4", m["family"])
+	assert.Equal(t, "established", m["tcp_state"])
+	assert.Equal(t, "10.0.0.1", m["src_addr"])
+	assert.Equal(t, "2020-01-02T04:04:05.000000006Z", m["timestamp"])
+	assert.NotContains(t, m, "real_ts")
+
+	var got Event
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, e.Start, got.Start)
+	assert.Equal(t, e.Proto, got.Proto)
+	assert.Equal(t, e.TCPState, got.TCPState)
+	assert.Equal(t, e.Tags, got.Tags)
+	assert.True(t, e.SrcAddr.Equal(got.SrcAddr))
+
+	// The raw form is reversible without loss, including the wall clock time
+	// stamp.
+	got = Event{}
+	require.NoError(t, json.Unmarshal(e.AppendJSON(nil, true), &got))
+	assert.Equal(t, e, got)
+
+	got = Event{}
+	e.RealTime = 0
+	require.NoError(t, json.Unmarshal(e.AppendJSON(nil, true), &got))
+	assert.NotContains(t, string(e.AppendJSON(nil, true)), "real_ts")
+	assert.Equal(t, e, got)
+
+	// Protocols without a name are given as numbers.
+	e.Proto = 253
+	require.NoError(t, json.Unmarshal(e.AppendJSON(nil, false), &got))
+	assert.EqualValues(t, 253, got.Proto)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"proto":"bogus"}`), &got))
+}
@@ -0,0 +1,106 @@
+package bpf
+
+import "strings"
+
+// ProtoName is a fast conversion of a protocol number into a string.
+// Only the types known in nf_conntrack_tuple_common.h are included.
+func ProtoName(i uint8) string {
+	switch i {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 33:
+		return "dccp"
+	case 47:
+		return "gre"
+	case 58:
+		return "icmpv6"
+	case 132:
+		return "sctp"
+	case 136:
+		return "udplite"
+	}
+
+	return "unknown"
+}
+
+// protoNumbers maps protocol names to their numbers,
+// the inverse of ProtoName.
+var protoNumbers = map[string]uint8{
+	"icmp":    1,
+	"tcp":     6,
+	"udp":     17,
+	"dccp":    33,
+	"gre":     47,
+	"icmpv6":  58,
+	"sctp":    132,
+	"udplite": 136,
+}
+
+// ProtoNumber returns the number of a protocol name known to ProtoName.
+func ProtoNumber(s string) (uint8, bool) {
+	n, ok := protoNumbers[strings.ToLower(s)]
+	return n, ok
+}
+
+// FamilyName converts an Event's address family into a string.
+func FamilyName(f uint8) string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	}
+
+	return "unknown"
+}
+
+// familyNumber is the inverse of FamilyName.
+func familyNumber(s string) (uint8, bool) {
+	switch s {
+	case "ipv4":
+		return FamilyIPv4, true
+	case "ipv6":
+		return FamilyIPv6, true
+	}
+
+	return 0, false
+}
+
+// tcpStates holds the names of conntrack's TCP states, indexed by
+// their value in enum tcp_conntrack.
+var tcpStates = [...]string{
+	"none",
+	"syn_sent",
+	"syn_recv",
+	"established",
+	"fin_wait",
+	"close_wait",
+	"last_ack",
+	"time_wait",
+	"close",
+	"syn_sent2",
+}
+
+// TCPStateName converts a conntrack TCP state into a string.
+func TCPStateName(s uint8) string {
+	if int(s) < len(tcpStates) {
+		return tcpStates[s]
+	}
+
+	return "unknown"
+}
+
+// tcpStateNumber is the inverse of TCPStateName.
+func tcpStateNumber(s string) (uint8, bool) {
+	for i, n := range tcpStates {
+		if n == s {
+			return uint8(i), true
+		}
+	}
+
+	return 0, false
+}
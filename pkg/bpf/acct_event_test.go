@@ -0,0 +1,95 @@
+package bpf
+
+import (
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventUnmarshal(t *testing.T) {
+
+	b := make([]byte, EventLength)
+
+	// IPv6 address that has all but its first 4 bytes set to zero.
+	src := net.ParseIP("2001:db8::")
+	copy(b[24:40], src)
+	copy(b[40:56], net.ParseIP("2001:db8::1"))
+	b[97] = FamilyIPv6
+	*(*uint16)(unsafe.Pointer(&b[98])) = 42
+	b[100] = 3 // TCP_CONNTRACK_ESTABLISHED
+	*(*uint32)(unsafe.Pointer(&b[104])) = 7
+	*(*uint64)(unsafe.Pointer(&b[112])) = 1500000000e9
+	*(*uint32)(unsafe.Pointer(&b[120])) = 5
+	*(*uint32)(unsafe.Pointer(&b[124])) = 2
+
+	var e Event
+	require.NoError(t, e.UnmarshalBinary(b))
+	assert.Equal(t, FamilyIPv6, e.Family)
+	assert.EqualValues(t, 42, e.Zone)
+	assert.EqualValues(t, 3, e.TCPState)
+	assert.EqualValues(t, 7, e.Ifindex)
+	assert.True(t, time.Unix(1500000000, 0).Equal(e.Time(time.Time{})))
+	assert.True(t, src.Equal(e.SrcAddr), e.SrcAddr.String())
+	assert.EqualValues(t, 5, e.Seq)
+	assert.EqualValues(t, 2, e.CPU)
+
+	// Probes without sequence numbers leave them zero.
+	require.NoError(t, e.UnmarshalBinary(b[:eventLengthV3]))
+	assert.NotZero(t, e.RealTime)
+	assert.Zero(t, e.Seq)
+	assert.Zero(t, e.CPU)
+
+	// Probes without the wall clock time stamp leave it zero.
+	require.NoError(t, e.UnmarshalBinary(b[:eventLengthV2]))
+	assert.EqualValues(t, 7, e.Ifindex)
+	assert.Zero(t, e.RealTime)
+
+	// Older probes without the family field fall back to guessing.
+	b = make([]byte, eventLengthV1)
+	copy(b[24:28], net.IPv4(10, 0, 0, 1).To4())
+
+	require.NoError(t, e.UnmarshalBinary(b))
+	assert.Equal(t, FamilyIPv4, e.Family)
+	assert.Equal(t, "10.0.0.1", e.SrcAddr.String())
+	assert.Zero(t, e.Ifindex)
+}
+
+func TestEventMarshal(t *testing.T) {
+
+	for _, e := range []Event{
+		{
+			Start: 1, Timestamp: 2, ConnectionID: 3, Connmark: 4,
+			SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2),
+			PacketsOrig: 5, BytesOrig: 6, PacketsRet: 7, BytesRet: 8,
+			SrcPort: 1234, DstPort: 80, NetNS: 9, Proto: 6, Family: FamilyIPv4,
+			Zone: 10, TCPState: 3, Ifindex: 11, RealTime: 12,
+			Seq: 13, CPU: 14,
+		},
+		{
+			SrcAddr: net.ParseIP("2001:db8::1"), DstAddr: net.ParseIP("2001:db8::2"),
+			Proto: 58, Family: FamilyIPv6,
+			ICMPID: 4321, ICMPType: 128,
+		},
+		{
+			SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2),
+			Proto: 1, Family: FamilyIPv4,
+			ICMPID: 1, ICMPType: 3, ICMPCode: 4,
+		},
+		{
+			SrcAddr: net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2),
+			SrcPort: 2905, DstPort: 2905, Proto: 132, Family: FamilyIPv4,
+		},
+	} {
+		b, err := e.MarshalBinary()
+		require.NoError(t, err)
+
+		var out Event
+		require.NoError(t, out.UnmarshalBinary(b))
+		assert.Equal(t, e.String(), out.String())
+		assert.Equal(t, e.RealTime, out.RealTime)
+	}
+}
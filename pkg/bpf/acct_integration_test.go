@@ -42,7 +42,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Set the required sysctl's for the probe to gather accounting data.
-	err = Sysctls(false)
+	_, err = Sysctls(false)
 	if err != nil {
 		log.Fatal(err)
 	}
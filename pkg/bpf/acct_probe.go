@@ -2,6 +2,7 @@ package bpf
 
 import (
 	"fmt"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/iovisor/gobpf/elf"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 const perfUpdateMap = "perf_acct_update"
@@ -18,27 +20,95 @@ const perfDestroyMap = "perf_acct_end"
 // Probe is an instance of a BPF probe running in the kernel.
 type Probe struct {
 
-	// gobpf/elf objects.
-	module      *elf.Module
-	perfUpdate  *elf.PerfMap
-	perfDestroy *elf.PerfMap
+	// gobpf/elf module.
+	module *elf.Module
+
+	// Tracing programs attached through fentry/fexit trampolines instead of
+	// the module's kprobes, nil if the probe attaches through kprobes.
+	// tracingErr holds the reason the probe fell back to kprobes.
+	tracing    *tracingProgs
+	tracingErr error
+
+	// Directory the probe's state and tracing programs are pinned in,
+	// empty if not pinned.
+	pinPath string
+
+	// Perf buffer readers, reading the buffer of each CPU concurrently.
+	perfUpdate  *perfReader
+	perfDestroy *perfReader
+
+	// Ring buffer readers, used instead of perf maps if supported by the probe.
+	ringUpdate  *ringReader
+	ringDestroy *ringReader
 
 	// Target kernel of the loaded probe.
 	kernel kernel.Kernel
 
+	// Size of the ring buffers and amount of bytes pending before reading
+	// them, zero for defaults.
+	ringSize  int
+	watermark uint32
+
+	// Size of the per-CPU perf buffers in pages, zero for the default.
+	perfPages int
+
+	// 1 in sampleRate flows are reported, zero if all are.
+	sampleRate uint32
+
+	// The probe records the wall clock time of events on the kernel's TAI
+	// clock, which is ahead of UTC by taiOffset nanoseconds.
+	realTime  bool
+	taiOffset int64
+
+	// During a handover, events raised before takeover or at or after
+	// handover are reported by another probe and dropped. Zero if unset.
+	takeover uint64
+	handover uint64
+
 	// List of event consumers of the probe.
 	consumerMu sync.RWMutex
 	consumers  []*Consumer
 
-	// Amount of lost BPF perf events.
-	lostChan chan uint64
-	lost     uint64
+	// Amount of events and bytes read by the perfWorker.
+	eventsRead uint64
+	bytesRead  uint64
+
+	// Amount of lost BPF perf events, reported by the update and
+	// destroy perf maps.
+	lostUpdateChan  chan uint64
+	lostDestroyChan chan uint64
+	lost            uint64
+
+	// Amount of lost BPF perf events by CPU, and a LostSamplesFunc
+	// called when the kernel reports lost events.
+	lostCPUMu     sync.Mutex
+	lostCPU       map[int]uint64
+	onLostSamples atomic.Value
+
+	// Amount of events that left gaps in the sequence numbers of the events
+	// read, in total and by CPU, whose map is guarded by lostCPUMu, and a
+	// SeqGapFunc called on every gap.
+	seqLost    uint64
+	seqLostCPU map[int]uint64
+	onSeqGap   atomic.Value
 
 	// Communication channels with the perfWorker.
 	perfUpdateChan  chan []byte
 	perfDestroyChan chan []byte
 	errChan         chan error
 
+	// Buffers of the records sent to the perfWorker, reused once
+	// they're decoded.
+	records recordPool
+
+	// Tracks the perfWorker, waited on by Stop.
+	workers sync.WaitGroup
+
+	// Serializes updates to the probe's filter maps, and the
+	// namespaces in its netns allowlist.
+	filterMu sync.Mutex
+	netns    []uint32
+
 	// Started status of the probe.
 	startMu sync.Mutex
 	started bool
@@ -46,6 +116,8 @@ type Probe struct {
 
 // NewProbe instantiates an Probe using the given Config.
 // Loads the BPF program into the kernel but does not attach its kprobes yet.
+// On kernels with BTF, the probe's tracing programs are loaded as well, to be
+// attached through fentry/fexit trampolines instead of the kprobes.
 func NewProbe(cfg Config) (*Probe, error) {
 
 	kr, err := kernelRelease()
@@ -59,81 +131,204 @@ func NewProbe(cfg Config) (*Probe, error) {
 		return nil, errors.Wrap(err, "selecting BPF probe")
 	}
 
+	params, err := cfg.sectionParams(k.RingBuf)
+	if err != nil {
+		return nil, err
+	}
+
 	// Instantiate Probe with selected target kernel struct.
 	ap := Probe{
-		kernel: k,
+		kernel:    k,
+		ringSize:  cfg.RingBufSize,
+		watermark: cfg.WakeupWatermark,
+		perfPages: cfg.PerfPageCount,
 	}
 
+	if cfg.SampleRate > 1 {
+		ap.sampleRate = cfg.SampleRate
+	}
+
+	// Probes for older kernels can't read the wall clock, the time stamps of
+	// their events are made absolute using the estimated boot time instead.
+	if !k.RealTime {
+		cfg.RealTime = false
+	}
+	ap.realTime = cfg.RealTime
+
 	// Scan kallsyms before attempting BPF load to avoid arcane error output from eBPF attach.
 	err = checkProbeKsyms(k.Probes)
 	if err != nil {
 		return nil, err
 	}
 
+	// Loading fails with a generic permission error without the capabilities.
+	if caps, err := effectiveCaps(); err == nil {
+		if m := missingCaps(kr, caps); len(m) > 0 {
+			return nil, &PermissionError{Missing: m}
+		}
+	}
+
+	// Older kernels charge the probe's maps to the locked memory limit,
+	// failing to load them with a permission error when it's exceeded.
+	var need, limit uint64
+	if !kernelAtLeast(kr, memcgRelease) {
+		need = memlockRequired(k, cfg, runtime.NumCPU(), unix.Getpagesize())
+		if limit, err = raiseMemlock(need); err != nil {
+			return nil, errors.Wrap(err, "raising memlock limit")
+		}
+	}
+
 	// Load the module from the bytes.Reader and insert into the kernel.
 	ap.module = elf.NewModuleFromReader(br)
-	if err := ap.module.Load(nil); err != nil {
+	if err := ap.module.Load(params); err != nil {
 		// Error string from go-bpf can contain many NUL characters and need to be trimmed.
 		err = errors.New(strings.TrimRight(err.Error(), "\x00"))
-		return nil, errors.Wrap(err, fmt.Sprintf("failed to load ELF binary version %s", k.Version))
+		err = errors.Wrap(err, fmt.Sprintf("failed to load ELF binary version %s", k.Version))
+		if limit < need {
+			return nil, &MemlockError{Limit: limit, Required: need, Err: err}
+		}
+		return nil, err
+	}
+
+	// Tracing programs share the module's maps. Failing to load them, eg.
+	// on kernels without BTF, falls back to attaching the kprobes.
+	if len(k.Fentry) > 0 {
+		ap.tracing, ap.tracingErr = loadTracing(br, ap.moduleMap, k.Fentry)
 	}
 
 	// Apply probe configuration.
 	if err := configureProbe(ap.module, cfg); err != nil {
 		return nil, errors.Wrap(err, "configuring BPF probe")
 	}
+	ap.netns = cfg.NetNS
+
+	if cfg.PinPath != "" {
+		// Flows without events for longer than the longest cooldown likely
+		// ended while no probe was running.
+		var since uint64
+		if now, cd := monotonicNow(), cfg.maxCooldown(); now > cd {
+			since = now - cd
+		}
+		if err := pinState(ap.module, cfg.PinPath, since, ap.tracing); err != nil {
+			return nil, errors.Wrap(err, "pinning BPF maps")
+		}
+		ap.pinPath = cfg.PinPath
+	}
 
 	return &ap, nil
 }
 
-// Start attaches the BPF program's kprobes and starts polling the perf ring buffer.
+// Start attaches the BPF program's kprobes, or its tracing programs if loaded,
+// and starts polling the perf or BPF ring buffers, depending on the target
+// kernel of the probe.
 func (ap *Probe) Start() error {
+	_, err := ap.start(false)
+	return err
+}
+
+// TakeOver starts the probe like Start, taking over from another running
+// probe, eg. of a conntracct instance being upgraded. It returns the handover
+// time stamp: events raised before it are dropped, the other probe is to
+// report them and drop later ones by calling HandOver with the time stamp.
+// It's taken after attaching the kprobes, so every event is reported by
+// exactly one of the probes.
+func (ap *Probe) TakeOver() (uint64, error) {
+	return ap.start(true)
+}
+
+// HandOver makes the running probe drop events raised at or after the given
+// time stamp, returned by TakeOver of the probe taking over. The probe can
+// be stopped afterwards without losing events.
+func (ap *Probe) HandOver(ts uint64) {
+	atomic.StoreUint64(&ap.handover, ts)
+}
+
+// start starts the probe, returning the time stamp since which it reports
+// events if takeover is set.
+func (ap *Probe) start(takeover bool) (uint64, error) {
 
 	ap.startMu.Lock()
 	defer ap.startMu.Unlock()
 
 	if ap.started {
-		return errProbeStarted
+		return 0, errProbeStarted
+	}
+
+	// Attach the tracing programs if loaded, falling back to the kprobes if
+	// the kernel can't attach them.
+	if ap.tracing != nil {
+		if err := ap.tracing.attach(); err != nil {
+			ap.tracing.close()
+			ap.tracing, ap.tracingErr = nil, err
+
+			// Don't leave the unused programs pinned.
+			if ap.pinPath != "" {
+				if err := pinProgs(ap.pinPath, nil); err != nil {
+					return 0, err
+				}
+			}
+		}
 	}
 
 	// Enable all kprobes in target kernel's probe list.
-	for _, p := range ap.kernel.Probes {
-		if err := ap.module.EnableKprobe(p, 0); err != nil {
-			return errors.Wrap(err, "enabling kprobe")
+	if ap.tracing == nil {
+		for _, p := range ap.kernel.Probes {
+			if err := ap.module.EnableKprobe(p, 0); err != nil {
+				return 0, errors.Wrap(err, "enabling kprobe")
+			}
 		}
 	}
 
+	// Read by the perfWorker started below.
+	if takeover {
+		ap.takeover = monotonicNow()
+	}
+
 	ap.perfUpdateChan = make(chan []byte, 1024)
 	ap.perfDestroyChan = make(chan []byte, 1024)
-	ap.lostChan = make(chan uint64)
+	ap.lostUpdateChan = make(chan uint64)
+	ap.lostDestroyChan = make(chan uint64)
 	ap.errChan = make(chan error)
+	ap.records = newRecordPool(recordPoolSize)
 
-	// Set up perf maps with an event and lost channel.
-	um, err := elf.InitPerfMap(ap.module, perfUpdateMap, ap.perfUpdateChan, ap.lostChan)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("InitPerfMap %s", perfUpdateMap))
+	// The TAI offset only changes on leap seconds, read it once.
+	if ap.realTime {
+		off, err := taiOffset()
+		if err != nil {
+			return 0, errors.Wrap(err, "reading TAI offset")
+		}
+		ap.taiOffset = off
 	}
-	ap.perfUpdate = um
 
-	dm, err := elf.InitPerfMap(ap.module, perfDestroyMap, ap.perfDestroyChan, ap.lostChan)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("InitPerfMap %s", perfUpdateMap))
+	if ap.kernel.RingBuf {
+		if err := ap.initRingBufs(); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := ap.initPerfMaps(); err != nil {
+			return 0, err
+		}
 	}
-	ap.perfDestroy = dm
 
 	// Start the event message decoder and fanout worker.
+	ap.workers.Add(1)
 	go perfWorker(ap)
 
 	// Start worker counting the amount of lost messages.
 	go lostWorker(ap)
 
-	// Start polling the BPF perf ring buffer, into update and destroy chans.
-	um.PollStart()
-	dm.PollStart()
+	// Start polling the BPF perf or ring buffers, into update and destroy chans.
+	if ap.kernel.RingBuf {
+		ap.ringUpdate.PollStart()
+		ap.ringDestroy.PollStart()
+	} else {
+		ap.perfUpdate.PollStart()
+		ap.perfDestroy.PollStart()
+	}
 
 	ap.started = true
 
-	return nil
+	return ap.takeover, nil
 }
 
 // Stop stops the BPF program and releases all its related resources.
@@ -147,24 +342,237 @@ func (ap *Probe) Stop() error {
 		return errProbeNotStarted
 	}
 
-	// Releases all gobpf-internal resources, including the perfMap poller.
-	if err := ap.module.Close(); err != nil {
-		return err
+	// Readers need to be stopped before their maps are closed.
+	if ap.kernel.RingBuf {
+		ap.ringUpdate.PollStop()
+		ap.ringDestroy.PollStop()
+	} else {
+		ap.perfUpdate.PollStop()
+		ap.perfDestroy.PollStop()
 	}
 
-	close(ap.lostChan)
+	// Detach and unload the tracing programs before the maps they use.
+	if ap.tracing != nil {
+		ap.tracing.close()
+	}
+
+	// Releases all gobpf-internal resources. Removing the kprobes fails
+	// without root, eg. after dropping privileges, the events already read
+	// are delivered regardless.
+	cerr := ap.module.Close()
+
+	close(ap.lostUpdateChan)
+	close(ap.lostDestroyChan)
 	close(ap.perfUpdateChan)
 	close(ap.perfDestroyChan)
+
+	// Wait for all received events to be sent to consumers.
+	ap.workers.Wait()
+
 	close(ap.errChan)
 
+	return cerr
+}
+
+// initPerfMaps sets up readers for the probe's perf maps with an event and
+// lost channel. The per-CPU buffers of a map are read concurrently, their
+// events are merged in order of their timestamps.
+func (ap *Probe) initPerfMaps() error {
+
+	ur, err := newPerfReader(ap.module, perfUpdateMap, ap.perfPages, ap.watermark, ap.perfUpdateChan, ap.lostUpdateChan, ap.records)
+	if err != nil {
+		return errors.Wrap(err, perfUpdateMap)
+	}
+
+	dr, err := newPerfReader(ap.module, perfDestroyMap, ap.perfPages, ap.watermark, ap.perfDestroyChan, ap.lostDestroyChan, ap.records)
+	if err != nil {
+		ur.close()
+		return errors.Wrap(err, perfDestroyMap)
+	}
+
+	ur.onLost = ap.lostSamples
+	dr.onLost = ap.lostSamples
+
+	ap.perfUpdate = ur
+	ap.perfDestroy = dr
+
+	return nil
+}
+
+// initRingBufs sets up readers for the probe's ring buffers. Ring buffers
+// don't report lost events, the kernel drops them when the buffer is full.
+func (ap *Probe) initRingBufs() error {
+
+	um := ap.module.Map(ringUpdateMap)
+	if um == nil {
+		return fmt.Errorf("map %s not found in probe", ringUpdateMap)
+	}
+	ur, err := newRingReader(um.Fd(), ap.ringSize, ap.watermark, ap.perfUpdateChan, ap.records)
+	if err != nil {
+		return errors.Wrap(err, ringUpdateMap)
+	}
+
+	dm := ap.module.Map(ringDestroyMap)
+	if dm == nil {
+		ur.PollStop()
+		return fmt.Errorf("map %s not found in probe", ringDestroyMap)
+	}
+	dr, err := newRingReader(dm.Fd(), ap.ringSize, ap.watermark, ap.perfDestroyChan, ap.records)
+	if err != nil {
+		ur.PollStop()
+		return errors.Wrap(err, ringDestroyMap)
+	}
+
+	ap.ringUpdate = ur
+	ap.ringDestroy = dr
+
 	return nil
 }
 
+// SetProtoFilter replaces the probe's protocol allowlist while it is running.
+// An empty list disables the allowlist.
+func (ap *Probe) SetProtoFilter(protos []uint8) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	return configureProtoFilter(ap.module, protos)
+}
+
+// SetPortFilter replaces the probe's destination port allowlist while it is
+// running. Flows matching either the old or the new allowlist can be sent
+// while the update is in progress. An empty list disables the allowlist.
+func (ap *Probe) SetPortFilter(ports []PortRange) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	return configurePortFilter(ap.module, ports)
+}
+
+// SetNetNSFilter replaces the probe's network namespace allowlist while it
+// is running. An empty list disables the allowlist.
+func (ap *Probe) SetNetNSFilter(netns []uint32) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	if err := configureNetNSFilter(ap.module, ap.netns, netns); err != nil {
+		return err
+	}
+	ap.netns = netns
+
+	return nil
+}
+
+// SetCooldown sets the minimum time between update events of a flow while
+// the probe is running.
+func (ap *Probe) SetCooldown(millis uint32) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	return configureCooldown(ap.module, millis)
+}
+
+// SetMinBytes sets the amount of bytes a flow needs to generate update
+// events while the probe is running.
+func (ap *Probe) SetMinBytes(n uint64) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	return configureMinBytes(ap.module, n)
+}
+
+// SetCooldownClasses replaces the probe's rate-based cooldown overrides
+// while it is running. An empty list disables them.
+func (ap *Probe) SetCooldownClasses(classes []CooldownClass) error {
+
+	ap.filterMu.Lock()
+	defer ap.filterMu.Unlock()
+
+	return configureCooldownClasses(ap.module, classes)
+}
+
+// Lost returns the amount of events lost by the kernel because the probe's
+// perf buffers were full. Always zero for ring buffer probes.
+func (ap *Probe) Lost() uint64 {
+	return atomic.LoadUint64(&ap.lost)
+}
+
+// LostPerCPU returns a snapshot of the amount of events lost by the kernel
+// because the probe's perf buffers were full, by CPU. Only holds CPUs that
+// lost events. Always empty for ring buffer probes.
+func (ap *Probe) LostPerCPU() map[int]uint64 {
+
+	ap.lostCPUMu.Lock()
+	defer ap.lostCPUMu.Unlock()
+
+	m := make(map[int]uint64, len(ap.lostCPU))
+	for cpu, n := range ap.lostCPU {
+		m[cpu] = n
+	}
+
+	return m
+}
+
+// LostSamplesFunc is called with the amount of events the kernel dropped on a
+// CPU due to its full perf buffer. It's called from the probe's perf buffer
+// readers, so it must not block.
+type LostSamplesFunc func(cpu int, n uint64)
+
+// SetOnLostSamples sets a function called whenever the kernel reports lost
+// events. Never called for ring buffer probes, which don't report lost events.
+// A nil function disables the callback.
+func (ap *Probe) SetOnLostSamples(f LostSamplesFunc) {
+	ap.onLostSamples.Store(f)
+}
+
+// lostSamples records the loss of n events on a CPU and calls the probe's
+// LostSamplesFunc, if any.
+func (ap *Probe) lostSamples(cpu int, n uint64) {
+
+	ap.lostCPUMu.Lock()
+	if ap.lostCPU == nil {
+		ap.lostCPU = make(map[int]uint64)
+	}
+	ap.lostCPU[cpu] += n
+	ap.lostCPUMu.Unlock()
+
+	if f, _ := ap.onLostSamples.Load().(LostSamplesFunc); f != nil {
+		f(cpu, n)
+	}
+}
+
 // Kernel returns the target kernel structure of the selected probe.
 func (ap *Probe) Kernel() kernel.Kernel {
 	return ap.kernel
 }
 
+// Trampolines returns true if the probe attaches through fentry/fexit
+// trampolines instead of kprobes. Otherwise, the returned error holds the
+// reason the kernel can't attach the probe's tracing programs, nil if the
+// selected probe has none.
+func (ap *Probe) Trampolines() (bool, error) {
+
+	ap.startMu.Lock()
+	defer ap.startMu.Unlock()
+
+	return ap.tracing != nil, ap.tracingErr
+}
+
+// moduleMap returns the fd of the module's map with the given name.
+func (ap *Probe) moduleMap(name string) (int, bool) {
+
+	m := ap.module.Map(name)
+	if m == nil {
+		return 0, false
+	}
+
+	return m.Fd(), true
+}
+
 // ErrChan returns an initialized Probe's unbuffered error channel.
 // The error channel is unbuffered because it doesn't make sense to have
 // stale error data. If there is no ready consumer on the channel, errors
@@ -187,49 +595,111 @@ func (ap *Probe) sendError(err error) bool {
 }
 
 // perfWorker reads binary events from the Probe's event channel,
-// unmarshals the events into Events and sends them on all registered
-// consumers' event channels. Exits if perfUpdateChan or perfDestroyChan are closed.
+// decodes the events into Events and sends them on all registered
+// consumers' event channels. Exits when both perfUpdateChan and perfDestroyChan
+// are closed and drained.
 func perfWorker(ap *Probe) {
 
+	defer ap.workers.Done()
+
 	var eb []byte
 	var ok bool
 	var update bool
+	var dec Decoder
+
+	// Sequence numbers of the update and destroy streams by CPU.
+	seqUpdate, seqDestroy := make(seqTracker), make(seqTracker)
+
+	uc, dc := ap.perfUpdateChan, ap.perfDestroyChan
 
-	for {
+	for uc != nil || dc != nil {
 		select {
-		case eb, ok = <-ap.perfUpdateChan:
+		case eb, ok = <-uc:
 			update = true
-		case eb, ok = <-ap.perfDestroyChan:
+			if !ok {
+				// Channel closed, stop selecting on it.
+				uc = nil
+				continue
+			}
+		case eb, ok = <-dc:
 			update = false
+			if !ok {
+				dc = nil
+				continue
+			}
 		}
 
-		if !ok {
-			// Channel closed.
-			return
-		}
+		atomic.AddUint64(&ap.eventsRead, 1)
+		atomic.AddUint64(&ap.bytesRead, uint64(len(eb)))
 
 		var ae Event
-		if err := ae.UnmarshalBinary(eb); err != nil {
+		if err := dec.Decode(&ae, eb); err != nil {
 			ap.sendError(errors.Wrap(err, "error unmarshaling Event byte array"))
 		}
 
+		// Events dropped during a handover below are read, not lost.
+		seq := seqDestroy
+		if update {
+			seq = seqUpdate
+		}
+		if g, ok := seq.gap(&ae); ok {
+			g.Update = update
+			ap.seqGap(g)
+		}
+
+		// Drop events reported by another probe during a handover.
+		if h := atomic.LoadUint64(&ap.handover); ae.Timestamp < ap.takeover || (h != 0 && ae.Timestamp >= h) {
+			ap.records.put(eb)
+			continue
+		}
+
+		ae.SampleRate = ap.sampleRate
+		if ae.RealTime != 0 {
+			ae.RealTime -= uint64(ap.taiOffset)
+		}
+
+		// Decoded events don't reference their record.
+		ap.records.put(eb)
+
 		// Fanout to all registered consumers.
 		ap.fanoutEvent(ae, update)
 	}
 }
 
-// lostWorker increments the Probe's lost field for every message
-// received on its lostChan. Exits if lostChan is closed.
+// lostWorker adds the amount of lost events reported by the perf maps to the
+// Probe's lost field and to the consumers of the lost kind of events.
+// Exits when both lost channels are closed.
 func lostWorker(ap *Probe) {
 
-	for {
-		_, ok := <-ap.lostChan
-		if !ok {
-			// Channel closed.
-			return
+	uc, dc := ap.lostUpdateChan, ap.lostDestroyChan
+
+	for uc != nil || dc != nil {
+		var n uint64
+		var ok, update bool
+
+		select {
+		case n, ok = <-uc:
+			update = true
+			if !ok {
+				uc = nil
+				continue
+			}
+		case n, ok = <-dc:
+			if !ok {
+				dc = nil
+				continue
+			}
 		}
 
-		atomic.AddUint64(&ap.lost, 1)
+		atomic.AddUint64(&ap.lost, n)
+
+		ap.consumerMu.RLock()
+		for _, c := range ap.consumers {
+			if (update && c.WantUpdate()) || (!update && c.WantDestroy()) {
+				c.addLost(LostKernel, n)
+			}
+		}
+		ap.consumerMu.RUnlock()
 	}
 }
 
@@ -246,13 +716,20 @@ func (ap *Probe) fanoutEvent(ae Event, update bool) {
 		// Require the update/destroy condition of the event to match
 		// the requested event type of the consumer.
 		if (update && c.WantUpdate()) || (!update && c.WantDestroy()) {
+			if c.backpressure == BackpressureBlock {
+				c.events <- ae
+				atomic.AddUint64(&c.delivered, 1)
+				continue
+			}
+
 			// Non-blocking send to the consumer's event channel.
 			select {
 			case c.events <- ae:
+				atomic.AddUint64(&c.delivered, 1)
 			default:
 				// If the channel can't be written to immediately,
 				// increment the consumer's lost counter.
-				atomic.AddUint64(&c.lost, 1)
+				c.addLost(LostChannelFull, 1)
 			}
 		}
 	}
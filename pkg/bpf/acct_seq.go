@@ -0,0 +1,123 @@
+package bpf
+
+import (
+	"sync/atomic"
+)
+
+// SeqGap is a gap in the sequence numbers of the update or destroy events
+// sent by the probe on a CPU, left by events lost in between.
+type SeqGap struct {
+	CPU    uint32
+	Update bool
+
+	// Amount of events lost.
+	Lost uint64
+
+	// ktime time stamps of the events before and after the gap, between which
+	// the events were lost. Since is zero if the events were lost before the
+	// first event read on the CPU.
+	Since uint64
+	Until uint64
+}
+
+// SeqGapFunc is called with the gaps in the sequence numbers of the events
+// read from the probe. It's called from the probe's event decoder, so it
+// must not block.
+type SeqGapFunc func(g SeqGap)
+
+// seqPos is the sequence number and time stamp of the last event read
+// on a CPU.
+type seqPos struct {
+	seq uint32
+	ts  uint64
+}
+
+// seqTracker detects gaps in the sequence numbers of the events of one
+// stream, by CPU. A seqTracker is not safe for concurrent use.
+type seqTracker map[uint32]seqPos
+
+// gap records the event's sequence number and returns the gap since the
+// previous event on the event's CPU, and whether there is one. Events
+// without a sequence number never leave a gap.
+func (t seqTracker) gap(e *Event) (SeqGap, bool) {
+
+	if e.Seq == 0 {
+		return SeqGap{}, false
+	}
+
+	prev := t[e.CPU]
+	t[e.CPU] = seqPos{seq: e.Seq, ts: e.Timestamp}
+
+	n := e.Seq - prev.seq - 1
+	if e.Seq < prev.seq {
+		// The sequence skips zero when wrapping around.
+		n--
+	}
+	if n == 0 {
+		return SeqGap{}, false
+	}
+
+	return SeqGap{CPU: e.CPU, Lost: uint64(n), Since: prev.ts, Until: e.Timestamp}, true
+}
+
+// SeqLost returns the amount of events that left gaps in the sequence
+// numbers of the events read from the probe. Unlike Lost, this includes
+// events the kernel failed to write to ring buffers, and events dropped by
+// older kernels without reporting them. Always zero for probes built
+// before sequence numbers.
+func (ap *Probe) SeqLost() uint64 {
+	return atomic.LoadUint64(&ap.seqLost)
+}
+
+// SeqLostPerCPU returns a snapshot of the amount of events that left gaps
+// in the sequence numbers of the events read from the probe, by CPU. Only
+// holds CPUs that lost events.
+func (ap *Probe) SeqLostPerCPU() map[int]uint64 {
+
+	ap.lostCPUMu.Lock()
+	defer ap.lostCPUMu.Unlock()
+
+	m := make(map[int]uint64, len(ap.seqLostCPU))
+	for cpu, n := range ap.seqLostCPU {
+		m[cpu] = n
+	}
+
+	return m
+}
+
+// SetOnSeqGap sets a function called whenever a gap is detected in the
+// sequence numbers of the events read from the probe. A nil function
+// disables the callback.
+func (ap *Probe) SetOnSeqGap(f SeqGapFunc) {
+	ap.onSeqGap.Store(f)
+}
+
+// seqGap records a gap in the sequence numbers of the events read from the
+// probe and calls the probe's SeqGapFunc, if any. Ring buffers don't report
+// lost events, so on ring buffer probes the events are also counted as lost
+// by the kernel for the consumers of their kind.
+func (ap *Probe) seqGap(g SeqGap) {
+
+	atomic.AddUint64(&ap.seqLost, g.Lost)
+
+	ap.lostCPUMu.Lock()
+	if ap.seqLostCPU == nil {
+		ap.seqLostCPU = make(map[int]uint64)
+	}
+	ap.seqLostCPU[int(g.CPU)] += g.Lost
+	ap.lostCPUMu.Unlock()
+
+	if ap.kernel.RingBuf {
+		ap.consumerMu.RLock()
+		for _, c := range ap.consumers {
+			if (g.Update && c.WantUpdate()) || (!g.Update && c.WantDestroy()) {
+				c.addLost(LostKernel, g.Lost)
+			}
+		}
+		ap.consumerMu.RUnlock()
+	}
+
+	if f, _ := ap.onSeqGap.Load().(SeqGapFunc); f != nil {
+		f(g)
+	}
+}
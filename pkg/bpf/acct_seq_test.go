@@ -0,0 +1,64 @@
+package bpf
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeqTracker(t *testing.T) {
+
+	st := make(seqTracker)
+
+	// Events without sequence numbers never leave gaps.
+	_, ok := st.gap(&Event{Timestamp: 1})
+	assert.False(t, ok)
+
+	_, ok = st.gap(&Event{Seq: 1, CPU: 0, Timestamp: 2})
+	assert.False(t, ok)
+
+	// Events lost before the first event read on a CPU.
+	g, ok := st.gap(&Event{Seq: 3, CPU: 1, Timestamp: 3})
+	assert.True(t, ok)
+	assert.Equal(t, SeqGap{CPU: 1, Lost: 2, Until: 3}, g)
+
+	g, ok = st.gap(&Event{Seq: 5, CPU: 0, Timestamp: 4})
+	assert.True(t, ok)
+	assert.Equal(t, SeqGap{CPU: 0, Lost: 3, Since: 2, Until: 4}, g)
+
+	// The sequence skips zero when wrapping around.
+	st[2] = seqPos{seq: math.MaxUint32 - 1}
+	_, ok = st.gap(&Event{Seq: math.MaxUint32, CPU: 2})
+	assert.False(t, ok)
+	_, ok = st.gap(&Event{Seq: 1, CPU: 2})
+	assert.False(t, ok)
+	g, ok = st.gap(&Event{Seq: 4, CPU: 2})
+	assert.True(t, ok)
+	assert.EqualValues(t, 2, g.Lost)
+}
+
+func TestProbeSeqGap(t *testing.T) {
+
+	var ap Probe
+	ap.kernel.RingBuf = true
+
+	c := NewConsumer("destroy", make(chan Event, 1), ConsumerDestroy)
+	require.NoError(t, ap.RegisterConsumer(c))
+
+	var gaps []SeqGap
+	ap.SetOnSeqGap(func(g SeqGap) {
+		gaps = append(gaps, g)
+	})
+
+	ap.seqGap(SeqGap{CPU: 1, Update: true, Lost: 2})
+	ap.seqGap(SeqGap{CPU: 3, Lost: 4})
+
+	assert.Len(t, gaps, 2)
+	assert.EqualValues(t, 6, ap.SeqLost())
+	assert.Equal(t, map[int]uint64{1: 2, 3: 4}, ap.SeqLostPerCPU())
+
+	// Ring buffers don't report lost events, only the gaps do.
+	assert.EqualValues(t, 4, c.Stats().LostKernel)
+}
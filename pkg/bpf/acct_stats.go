@@ -0,0 +1,102 @@
+package bpf
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds statistics about the events a Probe read from the kernel
+// and delivered to its consumers.
+type Stats struct {
+	// Amount of events and bytes read from the probe's perf or ring buffers.
+	EventsRead uint64 `json:"events_read"`
+	BytesRead  uint64 `json:"bytes_read"`
+
+	// Amount of events dropped by the kernel due to full perf buffers,
+	// in total and by CPU. Always zero for ring buffer probes.
+	LostSamples uint64         `json:"lost_samples"`
+	LostPerCPU  map[int]uint64 `json:"lost_per_cpu"`
+
+	// Amount of events that left gaps in the sequence numbers of the events
+	// read, in total and by CPU. Covers events lost by ring buffer probes.
+	SeqLost       uint64         `json:"seq_lost"`
+	SeqLostPerCPU map[int]uint64 `json:"seq_lost_per_cpu"`
+
+	// Statistics of the probe's consumers by name.
+	Consumers map[string]ConsumerStats `json:"consumers"`
+}
+
+// ConsumerStats holds statistics about the events sent to a Consumer.
+type ConsumerStats struct {
+	// Amount of events sent on the Consumer's event channel.
+	Delivered uint64 `json:"delivered"`
+
+	// Amount of events dropped because the event channel was full.
+	Lost uint64 `json:"lost"`
+
+	// Amount of events of the kind the Consumer subscribes to
+	// dropped by the kernel.
+	LostKernel uint64 `json:"lost_kernel"`
+}
+
+// Stats returns a snapshot of the Probe's statistics.
+func (ap *Probe) Stats() Stats {
+
+	s := Stats{
+		EventsRead:    atomic.LoadUint64(&ap.eventsRead),
+		BytesRead:     atomic.LoadUint64(&ap.bytesRead),
+		LostSamples:   ap.Lost(),
+		LostPerCPU:    ap.LostPerCPU(),
+		SeqLost:       ap.SeqLost(),
+		SeqLostPerCPU: ap.SeqLostPerCPU(),
+		Consumers:     make(map[string]ConsumerStats),
+	}
+
+	ap.consumerMu.RLock()
+	for _, c := range ap.consumers {
+		s.Consumers[c.Name()] = c.Stats()
+	}
+	ap.consumerMu.RUnlock()
+
+	return s
+}
+
+// PollAge returns the time since the least recently read of the probe's perf
+// or ring buffers was last read. Buffers are read at least every 100ms, a
+// larger age means their readers are stalled, eg. blocked on a full queue.
+// Zero if the probe wasn't started.
+func (ap *Probe) PollAge() time.Duration {
+
+	ap.startMu.Lock()
+	defer ap.startMu.Unlock()
+
+	if !ap.started {
+		return 0
+	}
+
+	var u, d uint64
+	if ap.kernel.RingBuf {
+		u, d = atomic.LoadUint64(&ap.ringUpdate.polled), atomic.LoadUint64(&ap.ringDestroy.polled)
+	} else {
+		u, d = ap.perfUpdate.lastPoll(), ap.perfDestroy.lastPoll()
+	}
+	if d < u {
+		u = d
+	}
+
+	// Not read yet, right after starting.
+	if u == 0 {
+		return 0
+	}
+
+	return time.Duration(monotonicNow() - u)
+}
+
+// Stats returns a snapshot of the Consumer's statistics.
+func (ac *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Delivered:  atomic.LoadUint64(&ac.delivered),
+		Lost:       ac.Lost(),
+		LostKernel: ac.LostKernel(),
+	}
+}
@@ -0,0 +1,329 @@
+package bpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Directory holding the BTF of the running kernel and its modules.
+const sysBTFDir = "/sys/kernel/btf"
+
+// Magic number of a BTF blob, BTF_MAGIC.
+const btfMagic = 0xeb9f
+
+// bpf() commands used for looking up kernel BTF, from enum bpf_cmd.
+const (
+	bpfBTFGetFDByID = 19
+	bpfBTFGetNextID = 23
+)
+
+// Kinds of BTF types with trailing data, and BTF_KIND_FUNC.
+const (
+	btfKindInt       = 1
+	btfKindArray     = 3
+	btfKindStruct    = 4
+	btfKindUnion     = 5
+	btfKindEnum      = 6
+	btfKindFunc      = 12
+	btfKindFuncProto = 13
+	btfKindVar       = 14
+	btfKindDatasec   = 15
+	btfKindDeclTag   = 17
+	btfKindEnum64    = 19
+)
+
+// btfSpec holds the functions described by a BTF blob of the kernel or one
+// of its modules.
+type btfSpec struct {
+
+	// Amount of types and length of the string section, including those of
+	// the base BTF for the BTF of a module.
+	types  uint32
+	strLen uint32
+
+	// String sections of the base BTF and the blob itself.
+	baseStrings []byte
+	strings     []byte
+
+	// Type IDs of the described functions by name.
+	funcs map[string]uint32
+}
+
+// parseBTF parses the functions of a BTF blob. The BTF of a module is split
+// BTF: its type IDs and string offsets continue after those of base, the BTF
+// of the kernel itself.
+func parseBTF(b []byte, base *btfSpec) (*btfSpec, error) {
+
+	// Kernel BTF is in host byte order, detect it from the magic number.
+	var bo binary.ByteOrder = binary.LittleEndian
+	if len(b) < 24 {
+		return nil, errBTFHeader
+	}
+	if bo.Uint16(b) != btfMagic {
+		bo = binary.BigEndian
+		if bo.Uint16(b) != btfMagic {
+			return nil, errBTFHeader
+		}
+	}
+
+	// struct btf_header, offsets of the sections are relative to its end.
+	hdrLen := bo.Uint32(b[4:])
+	typeOff, typeLen := bo.Uint32(b[8:]), bo.Uint32(b[12:])
+	strOff, strLen := bo.Uint32(b[16:]), bo.Uint32(b[20:])
+
+	if uint64(hdrLen)+uint64(typeOff)+uint64(typeLen) > uint64(len(b)) ||
+		uint64(hdrLen)+uint64(strOff)+uint64(strLen) > uint64(len(b)) {
+		return nil, errBTFHeader
+	}
+
+	spec := &btfSpec{
+		strings: b[hdrLen+strOff : hdrLen+strOff+strLen],
+		strLen:  strLen,
+		funcs:   make(map[string]uint32),
+	}
+	if base != nil {
+		spec.types = base.types
+		spec.strLen += base.strLen
+		spec.baseStrings = base.strings
+	}
+
+	types := b[hdrLen+typeOff : hdrLen+typeOff+typeLen]
+	for len(types) > 0 {
+		// struct btf_type.
+		if len(types) < 12 {
+			return nil, errBTFTypes
+		}
+		nameOff, info := bo.Uint32(types), bo.Uint32(types[4:])
+		kind, vlen := (info>>24)&0x1f, info&0xffff
+
+		spec.types++
+
+		if kind == btfKindFunc {
+			name, err := spec.name(nameOff)
+			if err != nil {
+				return nil, err
+			}
+			spec.funcs[name] = spec.types
+		}
+
+		// Skip the type and the data following it.
+		n := 12 + btfTrailer(kind, vlen)
+		if n > len(types) {
+			return nil, errBTFTypes
+		}
+		types = types[n:]
+	}
+
+	return spec, nil
+}
+
+// btfTrailer returns the size of the data following a BTF type of the given
+// kind and vlen.
+func btfTrailer(kind, vlen uint32) int {
+	switch kind {
+	case btfKindInt, btfKindVar, btfKindDeclTag:
+		return 4
+	case btfKindArray:
+		return 12
+	case btfKindStruct, btfKindUnion, btfKindDatasec, btfKindEnum64:
+		return int(vlen) * 12
+	case btfKindEnum, btfKindFuncProto:
+		return int(vlen) * 8
+	}
+
+	return 0
+}
+
+// name returns the NUL-terminated string at offset off of the string section.
+func (s *btfSpec) name(off uint32) (string, error) {
+
+	strs := s.strings
+	if base := uint32(len(s.baseStrings)); off < base {
+		strs = s.baseStrings
+	} else {
+		off -= base
+	}
+
+	if off >= uint32(len(strs)) {
+		return "", errBTFString
+	}
+
+	end := bytes.IndexByte(strs[off:], 0)
+	if end < 0 {
+		return "", errBTFString
+	}
+
+	return string(strs[off : off+uint32(end)]), nil
+}
+
+// btfTarget is a kernel function a tracing program can be attached to.
+type btfTarget struct {
+
+	// Type ID of the function in the BTF of the kernel or the module
+	// holding it.
+	id uint32
+
+	// Name of the module holding the function, empty if it's part of the
+	// kernel itself.
+	module string
+}
+
+// kernelFuncs looks up the given functions in the BTF of the running kernel
+// and its loaded modules.
+func kernelFuncs(names []string) (map[string]btfTarget, error) {
+	return findFuncs(sysBTFDir, names)
+}
+
+// findFuncs looks up the given functions in the BTF of the kernel and its
+// modules in dir, returning errFmtNoBTFFunc if any is missing.
+func findFuncs(dir string, names []string) (map[string]btfTarget, error) {
+
+	b, err := os.ReadFile(filepath.Join(dir, "vmlinux"))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading kernel BTF")
+	}
+	vmlinux, err := parseBTF(b, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing kernel BTF")
+	}
+
+	out := make(map[string]btfTarget, len(names))
+	missing := 0
+	for _, n := range names {
+		if id, ok := vmlinux.funcs[n]; ok {
+			out[n] = btfTarget{id: id}
+			continue
+		}
+		missing++
+	}
+
+	// Only read the BTF of modules for functions missing in the kernel's.
+	if missing > 0 {
+		mods, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing module BTF")
+		}
+
+		for _, m := range mods {
+			if m.Name() == "vmlinux" {
+				continue
+			}
+
+			b, err := os.ReadFile(filepath.Join(dir, m.Name()))
+			if err != nil {
+				return nil, errors.Wrap(err, "reading module BTF")
+			}
+			spec, err := parseBTF(b, vmlinux)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing BTF of module %s", m.Name())
+			}
+
+			for _, n := range names {
+				if _, ok := out[n]; ok {
+					continue
+				}
+				if id, ok := spec.funcs[n]; ok {
+					out[n] = btfTarget{id: id, module: m.Name()}
+				}
+			}
+		}
+	}
+
+	for _, n := range names {
+		if _, ok := out[n]; !ok {
+			return nil, errors.Errorf(errFmtNoBTFFunc, n)
+		}
+	}
+
+	return out, nil
+}
+
+// moduleBTF opens the BTF object of the loaded kernel module with the given
+// name. The caller needs to close the returned fd.
+func moduleBTF(module string) (int, error) {
+
+	var id uint32
+	for {
+		// Leading fields of union bpf_attr used by BPF_BTF_GET_NEXT_ID and
+		// BPF_BTF_GET_FD_BY_ID.
+		attr := struct {
+			id, next, flags uint32
+		}{
+			id: id,
+		}
+
+		if _, _, errno := unix.Syscall(unix.SYS_BPF, bpfBTFGetNextID,
+			uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr)); errno != 0 {
+			if errno == unix.ENOENT {
+				return 0, errors.Errorf(errFmtNoModuleBTF, module)
+			}
+			return 0, errors.Wrap(errno, "iterating BTF objects")
+		}
+		id = attr.next
+
+		attr.id, attr.next = id, 0
+		r, _, errno := unix.Syscall(unix.SYS_BPF, bpfBTFGetFDByID,
+			uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		if errno == unix.ENOENT {
+			// Unloaded while iterating.
+			continue
+		}
+		if errno != 0 {
+			return 0, errors.Wrap(errno, "opening BTF object")
+		}
+		fd := int(r)
+
+		name, kernel, err := btfInfo(fd)
+		if err != nil {
+			unix.Close(fd)
+			return 0, err
+		}
+		if kernel && name == module {
+			return fd, nil
+		}
+
+		unix.Close(fd)
+	}
+}
+
+// btfInfo returns the name of the BTF object with the given fd, and whether
+// it describes the kernel or one of its modules.
+func btfInfo(fd int) (string, bool, error) {
+
+	var name [64]byte
+
+	// struct bpf_btf_info.
+	info := struct {
+		btf     uint64
+		btfSize uint32
+		id      uint32
+		name    uint64
+		nameLen uint32
+		kernel  uint32
+	}{
+		name:    uint64(uintptr(unsafe.Pointer(&name[0]))),
+		nameLen: uint32(len(name)),
+	}
+
+	attr := struct {
+		fd, len uint32
+		info    uint64
+	}{
+		fd:   uint32(fd),
+		len:  uint32(unsafe.Sizeof(info)),
+		info: uint64(uintptr(unsafe.Pointer(&info))),
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, bpfObjGetInfoByFD,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr)); errno != 0 {
+		return "", false, errors.Wrap(errno, "getting BTF object info")
+	}
+
+	return unix.ByteSliceToString(name[:]), info.kernel != 0, nil
+}
@@ -0,0 +1,111 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// btfType is a BTF type written by testBTF.
+type btfType struct {
+	name       uint32
+	kind, vlen uint32
+}
+
+// testBTF builds a little-endian BTF blob of the given types and strings.
+// Types are followed by a zeroed trailer of their kind.
+func testBTF(types []btfType, strs string) []byte {
+
+	var tb []byte
+	for _, t := range types {
+		tb = binary.LittleEndian.AppendUint32(tb, t.name)
+		tb = binary.LittleEndian.AppendUint32(tb, t.kind<<24|t.vlen)
+		tb = binary.LittleEndian.AppendUint32(tb, 0)
+		tb = append(tb, make([]byte, btfTrailer(t.kind, t.vlen))...)
+	}
+
+	b := binary.LittleEndian.AppendUint16(nil, btfMagic)
+	b = append(b, 1, 0)
+	for _, v := range []uint32{24, 0, uint32(len(tb)), uint32(len(tb)), uint32(len(strs))} {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+
+	return append(append(b, tb...), strs...)
+}
+
+func TestParseBTF(t *testing.T) {
+
+	// int, a struct with two members, a func proto with one parameter and
+	// two funcs.
+	base := testBTF([]btfType{
+		{name: 1, kind: btfKindInt},
+		{name: 5, kind: btfKindStruct, vlen: 2},
+		{kind: btfKindFuncProto, vlen: 1},
+		{name: 13, kind: btfKindFunc},
+		{name: 31, kind: btfKindFunc},
+	}, "\x00int\x00nf_conn\x00nf_conntrack_free\x00tcp_v4_rcv\x00")
+
+	vmlinux, err := parseBTF(base, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, vmlinux.types)
+	assert.Equal(t, map[string]uint32{"nf_conntrack_free": 4, "tcp_v4_rcv": 5}, vmlinux.funcs)
+
+	// Split BTF continues the type IDs and string offsets of its base, and
+	// can refer to the strings of the base.
+	split := testBTF([]btfType{
+		{kind: btfKindFuncProto},
+		{name: uint32(len(vmlinux.strings)) + 1, kind: btfKindFunc},
+		{name: 13, kind: btfKindFunc},
+	}, "\x00__nf_ct_refresh_acct\x00")
+
+	mod, err := parseBTF(split, vmlinux)
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, mod.types)
+	assert.Equal(t, map[string]uint32{"__nf_ct_refresh_acct": 7, "nf_conntrack_free": 8}, mod.funcs)
+
+	_, err = parseBTF(base[:20], nil)
+	assert.Equal(t, errBTFHeader, err)
+
+	_, err = parseBTF(testBTF([]btfType{{name: 100, kind: btfKindFunc}}, "\x00"), nil)
+	assert.Equal(t, errBTFString, err)
+}
+
+func TestFindFuncs(t *testing.T) {
+
+	dir := t.TempDir()
+
+	vmlinux := testBTF([]btfType{
+		{name: 1, kind: btfKindFunc},
+	}, "\x00tcp_v4_rcv\x00")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vmlinux"), vmlinux, 0o644))
+
+	tf := func(names ...string) (map[string]btfTarget, error) {
+		return findFuncs(dir, names)
+	}
+
+	fs, err := tf("tcp_v4_rcv")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]btfTarget{"tcp_v4_rcv": {id: 1}}, fs)
+
+	// Functions of modules are looked up in the BTF of the module.
+	_, err = tf("nf_conntrack_free")
+	assert.EqualError(t, err, fmt.Sprintf(errFmtNoBTFFunc, "nf_conntrack_free"))
+
+	// String offsets of split BTF start after the base's 12 bytes.
+	conntrack := testBTF([]btfType{
+		{name: 13, kind: btfKindFunc},
+	}, "\x00nf_conntrack_free\x00")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nf_conntrack"), conntrack, 0o644))
+
+	fs, err = tf("tcp_v4_rcv", "nf_conntrack_free")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]btfTarget{
+		"tcp_v4_rcv":        {id: 1},
+		"nf_conntrack_free": {id: 2, module: "nf_conntrack"},
+	}, fs)
+}
@@ -0,0 +1,156 @@
+package bpf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"golang.org/x/sys/unix"
+)
+
+// Capability numbers, from linux/capability.h.
+const (
+	capSysAdmin    = 21
+	capSysResource = 24
+	capPerfmon     = 38
+	capBPF         = 39
+)
+
+// Kernels 5.8 and later split CAP_BPF and CAP_PERFMON off CAP_SYS_ADMIN,
+// kernels 5.11 and later charge BPF maps to the memory cgroup instead of
+// the locked memory limit.
+const (
+	capBPFRelease = "5.8.0"
+	memcgRelease  = "5.11.0"
+)
+
+// PermissionError is returned by NewProbe if the process lacks the
+// capabilities needed to load and attach the probe.
+type PermissionError struct {
+	// Names of the missing capabilities, eg. cap_bpf.
+	Missing []string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("missing capabilities to load the BPF probe: %s", strings.Join(e.Missing, ", "))
+}
+
+// MemlockError is returned by NewProbe if the probe failed to load on a
+// kernel charging BPF maps to the locked memory limit, and the limit is
+// below the amount of memory needed by the probe's maps.
+type MemlockError struct {
+	Limit    uint64
+	Required uint64
+
+	// Error of loading the probe.
+	Err error
+}
+
+func (e *MemlockError) Error() string {
+	return fmt.Sprintf("locked memory limit of %d bytes is below the %d bytes needed by the probe: %s",
+		e.Limit, e.Required, e.Err)
+}
+
+// Unwrap returns the error of loading the probe.
+func (e *MemlockError) Unwrap() error {
+	return e.Err
+}
+
+// kernelAtLeast returns true if kernel release kr is v or later.
+func kernelAtLeast(kr, v string) bool {
+	kv, err := semver.ParseTolerant(kr)
+	return err == nil && kv.GTE(semver.MustParse(v))
+}
+
+// effectiveCaps returns the effective capabilities of the process.
+func effectiveCaps() (uint64, error) {
+
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return parseCapEff(f)
+}
+
+// parseCapEff returns the effective capabilities in the contents of
+// /proc/<pid>/status.
+func parseCapEff(r io.Reader) (uint64, error) {
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if v := strings.TrimPrefix(s.Text(), "CapEff:"); v != s.Text() {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, errNoCapEff
+}
+
+// hasCap returns true if capability c is in the set caps.
+func hasCap(caps uint64, c uint) bool {
+	return caps&(1<<c) != 0
+}
+
+// missingCaps returns the names of the capabilities needed to load and
+// attach the probe on kernel release kr that are missing from caps.
+func missingCaps(kr string, caps uint64) []string {
+
+	if hasCap(caps, capSysAdmin) {
+		return nil
+	}
+
+	if !kernelAtLeast(kr, capBPFRelease) {
+		return []string{"cap_sys_admin"}
+	}
+
+	// Loading a kprobe program and attaching it to a perf event.
+	var m []string
+	if !hasCap(caps, capBPF) {
+		m = append(m, "cap_bpf")
+	}
+	if !hasCap(caps, capPerfmon) {
+		m = append(m, "cap_perfmon")
+	}
+
+	return m
+}
+
+// raiseMemlock raises the locked memory limit of the process to at least
+// need bytes, raising the hard limit too if the process has
+// cap_sys_resource. Otherwise, the soft limit is raised as far as the hard
+// limit allows. Returns the resulting soft limit.
+func raiseMemlock(need uint64) (uint64, error) {
+
+	var rl unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rl); err != nil {
+		return 0, err
+	}
+
+	if rl.Cur == unix.RLIM_INFINITY || rl.Cur >= need {
+		return rl.Cur, nil
+	}
+
+	want := unix.Rlimit{Cur: need, Max: rl.Max}
+	if rl.Max != unix.RLIM_INFINITY && rl.Max < need {
+		want.Max = need
+	}
+	if err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, &want); err == nil {
+		return need, nil
+	}
+
+	want = unix.Rlimit{Cur: rl.Max, Max: rl.Max}
+	if err := unix.Setrlimit(unix.RLIMIT_MEMLOCK, &want); err != nil {
+		return rl.Cur, nil
+	}
+
+	return rl.Max, nil
+}
@@ -0,0 +1,31 @@
+package bpf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingCaps(t *testing.T) {
+
+	caps, err := parseCapEff(strings.NewReader("Name:\tconntracct\nCapInh:\t0000000000000000\nCapEff:\t000000c000000000\n"))
+	require.NoError(t, err)
+	assert.True(t, hasCap(caps, capBPF))
+	assert.True(t, hasCap(caps, capPerfmon))
+
+	_, err = parseCapEff(strings.NewReader("Name:\tconntracct\n"))
+	assert.Equal(t, errNoCapEff, err)
+
+	// CAP_BPF and CAP_PERFMON suffice on 5.8 and later.
+	assert.Empty(t, missingCaps("5.8", caps))
+	assert.Equal(t, []string{"cap_sys_admin"}, missingCaps("5.4.0", caps))
+	assert.Equal(t, []string{"cap_perfmon"}, missingCaps("6.1.0", 1<<capBPF))
+
+	// CAP_SYS_ADMIN allows everything.
+	assert.Empty(t, missingCaps("4.9.0", 1<<capSysAdmin))
+
+	err = &PermissionError{Missing: []string{"cap_bpf", "cap_perfmon"}}
+	assert.EqualError(t, err, "missing capabilities to load the BPF probe: cap_bpf, cap_perfmon")
+}
@@ -6,11 +6,24 @@ const (
 	errFmtSplitKprobe = "expected string of format 'k(ret)probe/<kernel-symbol>': %s"
 	errFmtSymNotFound = "kernel symbol '%s' not found"
 	errKernelRelease  = "invalid kernel release version '%s'"
+
+	errFmtBackpressure = "unknown backpressure policy '%s', expected drop or block"
+
+	errFmtMapNotFound = "map '%s' not found in probe"
+	errFmtPinPathFS   = "pin path '%s' is not on a BPF filesystem"
+
+	errFmtTracingSection = "expected section of format 'fentry|fexit/<kernel-function>': %s"
+	errFmtNoSection      = "section '%s' not found in probe"
+	errFmtRelocation     = "unsupported relocation of instruction at offset %d"
+	errFmtNoBTFFunc      = "kernel function '%s' not found in kernel or module BTF"
+	errFmtNoModuleBTF    = "BTF of kernel module '%s' not loaded"
 )
 
 var (
 	errNotInRange = errors.New("range check did not match any version")
 
+	errNoCapEff = errors.New("no effective capabilities in process status")
+
 	errProbeStarted    = errors.New("Probe already running")
 	errProbeNotStarted = errors.New("Probe is not running")
 
@@ -18,4 +31,14 @@ var (
 	errNoConsumer  = errors.New("could not find the Consumer to delete")
 
 	errConsumerNil = errors.New("given Consumer is nil")
+
+	errCooldownClasses = errors.New("at most 4 cooldown classes are supported")
+	errNetNSFilter     = errors.New("at most 256 network namespaces are supported")
+
+	errPerfPageCount = errors.New("perf buffer page count must be a power of two")
+	errRingBufSize   = errors.New("ring buffer size must be a power of two multiple of the page size")
+
+	errBTFHeader = errors.New("invalid BTF header")
+	errBTFTypes  = errors.New("truncated BTF type section")
+	errBTFString = errors.New("invalid BTF string offset")
 )
@@ -0,0 +1,352 @@
+package bpf
+
+import (
+	"debug/elf"
+	"io"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// bpf() commands used for loading and attaching tracing programs, from
+// enum bpf_cmd.
+const (
+	bpfProgLoad          = 5
+	bpfRawTracepointOpen = 17
+)
+
+const (
+	// BPF_PROG_TYPE_TRACING.
+	bpfProgTypeTracing = 26
+
+	// BPF_TRACE_FENTRY and BPF_TRACE_FEXIT.
+	bpfTraceFentry = 24
+	bpfTraceFexit  = 25
+
+	// Opcode of the first half of a 64-bit immediate load, BPF_LD | BPF_IMM
+	// | BPF_DW, and src_reg of a load of a map fd, BPF_PSEUDO_MAP_FD.
+	bpfLdImm64     = 0x18
+	bpfPseudoMapFD = 1
+
+	// Size of a BPF instruction and of an Elf64_Rel.
+	insnSize = 8
+	relSize  = 16
+)
+
+// Size of the verifier log read when a tracing program fails to load.
+const verifierLogSize = 1 << 20
+
+// License of the tracing programs, the license section of acct.c. Tracing
+// programs need a GPL-compatible license.
+var tracingLicense = []byte("GPL\x00")
+
+// tracingProg is an fentry or fexit program of the probe.
+type tracingProg struct {
+
+	// Name of the program's ELF section, eg. 'fentry/nf_conntrack_free'.
+	section string
+
+	// Trampoline the program is attached to, BPF_TRACE_FENTRY or
+	// BPF_TRACE_FEXIT, and the traced kernel function.
+	attachType uint32
+	target     string
+
+	// Instructions of the program, with map references resolved.
+	insns []byte
+
+	// Fds of the loaded program and of its link once attached, zero if the
+	// program is not loaded or attached.
+	fd, link int
+}
+
+// tracingProgs is a set of tracing programs attached through fentry/fexit
+// trampolines instead of kprobes.
+type tracingProgs struct {
+	progs []*tracingProg
+}
+
+// loadTracing loads the fentry and fexit programs in the given sections of
+// the probe's ELF object r into the kernel, resolving their map references
+// using the maps of the loaded module. The programs are attached by attach.
+func loadTracing(r io.ReaderAt, maps func(string) (int, bool), sections []string) (*tracingProgs, error) {
+
+	names := make([]string, 0, len(sections))
+	for _, s := range sections {
+		_, t, err := tracingTarget(s)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, t)
+	}
+
+	// Functions of modules, like conntrack itself, can only be traced if
+	// the module was built with BTF.
+	targets, err := kernelFuncs(names)
+	if err != nil {
+		return nil, err
+	}
+
+	progs, err := readTracing(r, maps, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := &tracingProgs{progs: progs}
+	for _, p := range tp.progs {
+		if err := p.load(targets[p.target]); err != nil {
+			tp.close()
+			return nil, errors.Wrap(err, p.section)
+		}
+	}
+
+	return tp, nil
+}
+
+// readTracing reads the instructions of the tracing programs in the given
+// sections of the ELF object r, resolving their map references by name.
+func readTracing(r io.ReaderAt, maps func(string) (int, bool), sections []string) ([]*tracingProg, error) {
+
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading ELF object")
+	}
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading ELF symbols")
+	}
+
+	var progs []*tracingProg
+	for _, s := range sections {
+		at, target, err := tracingTarget(s)
+		if err != nil {
+			return nil, err
+		}
+
+		sec := f.Section(s)
+		if sec == nil {
+			return nil, errors.Errorf(errFmtNoSection, s)
+		}
+		insns, err := sec.Data()
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading section %s", s)
+		}
+
+		// Relocations of a program's section are in a section of type
+		// SHT_REL linked to the program's section.
+		for _, rs := range f.Sections {
+			if rs.Type != elf.SHT_REL || int(rs.Info) >= len(f.Sections) || f.Sections[rs.Info] != sec {
+				continue
+			}
+
+			rels, err := rs.Data()
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading section %s", rs.Name)
+			}
+			if err := relocate(f, syms, insns, rels, maps); err != nil {
+				return nil, errors.Wrap(err, s)
+			}
+		}
+
+		progs = append(progs, &tracingProg{
+			section:    s,
+			attachType: at,
+			target:     target,
+			insns:      insns,
+		})
+	}
+
+	return progs, nil
+}
+
+// relocate points the 64-bit immediate loads referencing maps in insns to
+// the fds of the loaded maps, using the relocations in rels. The probe's maps
+// are in sections named 'maps/<name>'.
+func relocate(f *elf.File, syms []elf.Symbol, insns, rels []byte, maps func(string) (int, bool)) error {
+
+	for ; len(rels) >= relSize; rels = rels[relSize:] {
+		off := f.ByteOrder.Uint64(rels)
+		info := f.ByteOrder.Uint64(rels[8:])
+
+		// Symbols omits the null symbol at index 0.
+		idx := elf.R_SYM64(info)
+		if idx == 0 || int(idx) > len(syms) {
+			return errors.Errorf(errFmtRelocation, off)
+		}
+		sym := syms[idx-1]
+
+		if int(sym.Section) >= len(f.Sections) {
+			return errors.Errorf(errFmtRelocation, off)
+		}
+		name := strings.TrimPrefix(f.Sections[sym.Section].Name, "maps/")
+		if name == f.Sections[sym.Section].Name {
+			return errors.Errorf(errFmtRelocation, off)
+		}
+
+		if off%insnSize != 0 || off+insnSize > uint64(len(insns)) || insns[off] != bpfLdImm64 {
+			return errors.Errorf(errFmtRelocation, off)
+		}
+
+		fd, ok := maps(name)
+		if !ok {
+			return errors.Errorf(errFmtMapNotFound, name)
+		}
+
+		// Keep dst_reg, in the low nibble of the register byte.
+		insns[off+1] = insns[off+1]&0x0f | bpfPseudoMapFD<<4
+		f.ByteOrder.PutUint32(insns[off+4:], uint32(fd))
+	}
+
+	return nil
+}
+
+// tracingTarget returns the attach type and the traced kernel function of
+// the tracing program in the given section.
+func tracingTarget(section string) (uint32, string, error) {
+
+	ps := strings.Split(section, "/")
+	if len(ps) != 2 || ps[1] == "" {
+		return 0, "", errors.Errorf(errFmtTracingSection, section)
+	}
+
+	switch ps[0] {
+	case "fentry":
+		return bpfTraceFentry, ps[1], nil
+	case "fexit":
+		return bpfTraceFexit, ps[1], nil
+	}
+
+	return 0, "", errors.Errorf(errFmtTracingSection, section)
+}
+
+// load loads the program into the kernel, to be attached to the given
+// function.
+func (p *tracingProg) load(t btfTarget) error {
+
+	// Functions of modules are described by the BTF of their module.
+	var obj int
+	if t.module != "" {
+		fd, err := moduleBTF(t.module)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+		obj = fd
+	}
+
+	fd, err := progLoad(p, t.id, obj, nil)
+	if err != nil {
+		// Load again with the verifier's log to report why it failed.
+		log := make([]byte, verifierLogSize)
+		if fd, err = progLoad(p, t.id, obj, log); err != nil {
+			if s := strings.TrimSpace(unix.ByteSliceToString(log)); s != "" {
+				return errors.Wrapf(err, "loading tracing program, verifier log:\n%s", s)
+			}
+			return errors.Wrap(err, "loading tracing program")
+		}
+	}
+
+	p.fd = fd
+
+	return nil
+}
+
+// progLoad loads the program with BPF_PROG_LOAD, writing the verifier's log
+// to log if not nil.
+func progLoad(p *tracingProg, btfID uint32, btfObj int, log []byte) (int, error) {
+
+	// Leading fields of union bpf_attr used by BPF_PROG_LOAD, up to
+	// attach_btf_obj_fd.
+	attr := struct {
+		progType, insnCnt          uint32
+		insns, license             uint64
+		logLevel, logSize          uint32
+		logBuf                     uint64
+		kernVersion, progFlags     uint32
+		progName                   [16]byte
+		progIfindex, attachType    uint32
+		progBTFFD, funcInfoRecSize uint32
+		funcInfo                   uint64
+		funcInfoCnt, lineRecSize   uint32
+		lineInfo                   uint64
+		lineInfoCnt, attachBTFID   uint32
+		attachBTFObjFD             uint32
+	}{
+		progType:       bpfProgTypeTracing,
+		insnCnt:        uint32(len(p.insns) / insnSize),
+		insns:          uint64(uintptr(unsafe.Pointer(&p.insns[0]))),
+		license:        uint64(uintptr(unsafe.Pointer(&tracingLicense[0]))),
+		attachType:     p.attachType,
+		attachBTFID:    btfID,
+		attachBTFObjFD: uint32(btfObj),
+	}
+
+	// Named after the traced function, truncated to BPF_OBJ_NAME_LEN.
+	copy(attr.progName[:len(attr.progName)-1], p.target)
+
+	if log != nil {
+		attr.logLevel = 1
+		attr.logSize = uint32(len(log))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+	}
+
+	r, _, errno := unix.Syscall(unix.SYS_BPF, bpfProgLoad,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(r), nil
+}
+
+// attach attaches the loaded programs to their trampolines in order,
+// detaching them all if one fails to attach.
+func (tp *tracingProgs) attach() error {
+
+	for _, p := range tp.progs {
+		// Leading fields of union bpf_attr used by BPF_RAW_TRACEPOINT_OPEN,
+		// tracing programs are attached without a tracepoint name.
+		attr := struct {
+			name   uint64
+			progFD uint32
+		}{
+			progFD: uint32(p.fd),
+		}
+
+		r, _, errno := unix.Syscall(unix.SYS_BPF, bpfRawTracepointOpen,
+			uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+		if errno != 0 {
+			tp.detach()
+			return errors.Wrapf(errno, "attaching %s", p.section)
+		}
+
+		p.link = int(r)
+	}
+
+	return nil
+}
+
+// detach detaches the programs from their trampolines by closing their links.
+func (tp *tracingProgs) detach() {
+	for _, p := range tp.progs {
+		if p.link != 0 {
+			unix.Close(p.link)
+			p.link = 0
+		}
+	}
+}
+
+// close detaches and unloads the programs.
+func (tp *tracingProgs) close() {
+
+	tp.detach()
+
+	for _, p := range tp.progs {
+		if p.fd != 0 {
+			unix.Close(p.fd)
+			p.fd = 0
+		}
+	}
+}
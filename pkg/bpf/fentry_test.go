@@ -0,0 +1,76 @@
+package bpf
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracingTarget(t *testing.T) {
+
+	at, fn, err := tracingTarget("fentry/nf_conntrack_free")
+	require.NoError(t, err)
+	assert.EqualValues(t, bpfTraceFentry, at)
+	assert.Equal(t, "nf_conntrack_free", fn)
+
+	at, fn, err = tracingTarget("fexit/__nf_ct_refresh_acct")
+	require.NoError(t, err)
+	assert.EqualValues(t, bpfTraceFexit, at)
+	assert.Equal(t, "__nf_ct_refresh_acct", fn)
+
+	for _, s := range []string{"kprobe/nf_conntrack_free", "fentry/", "fentry", "fexit/a/b"} {
+		_, _, err := tracingTarget(s)
+		assert.EqualError(t, err, fmt.Sprintf(errFmtTracingSection, s), s)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+
+	f := &elf.File{
+		FileHeader: elf.FileHeader{ByteOrder: binary.LittleEndian},
+		Sections: []*elf.Section{
+			{},
+			{SectionHeader: elf.SectionHeader{Name: "maps/nextupd"}},
+			{SectionHeader: elf.SectionHeader{Name: ".text"}},
+		},
+	}
+	syms := []elf.Symbol{
+		{Name: "nextupd", Section: 1},
+		{Name: "helper", Section: 2},
+	}
+	maps := func(name string) (int, bool) {
+		return 7, name == "nextupd"
+	}
+
+	// rel returns an Elf64_Rel of the instruction at off referencing the
+	// symbol at index sym.
+	rel := func(off uint64, sym uint32) []byte {
+		b := binary.LittleEndian.AppendUint64(nil, off)
+		return binary.LittleEndian.AppendUint64(b, elf.R_INFO(sym, 1))
+	}
+
+	// r1 = map nextupd, ll; r0 = 0; exit.
+	insns := []byte{
+		0x18, 0x01, 0, 0, 0, 0, 0, 0,
+		0x00, 0x00, 0, 0, 0, 0, 0, 0,
+		0xb7, 0x00, 0, 0, 0, 0, 0, 0,
+		0x95, 0x00, 0, 0, 0, 0, 0, 0,
+	}
+
+	// The load's src_reg is set to BPF_PSEUDO_MAP_FD, its immediate to the
+	// map's fd.
+	require.NoError(t, relocate(f, syms, insns, rel(0, 1), maps))
+	assert.Equal(t, []byte{0x18, 0x11, 0, 0, 7, 0, 0, 0}, insns[:8])
+
+	// References to anything but maps can't be resolved.
+	assert.EqualError(t, relocate(f, syms, insns, rel(0, 2), maps), fmt.Sprintf(errFmtRelocation, 0))
+	assert.EqualError(t, relocate(f, syms, insns, rel(16, 1), maps), fmt.Sprintf(errFmtRelocation, 16))
+	assert.EqualError(t, relocate(f, syms, insns, rel(0, 3), maps), fmt.Sprintf(errFmtRelocation, 0))
+
+	syms[0].Name, f.Sections[1].Name = "currct", "maps/currct"
+	assert.EqualError(t, relocate(f, syms, insns, rel(0, 1), maps), fmt.Sprintf(errFmtMapNotFound, "currct"))
+}
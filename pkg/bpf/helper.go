@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ti-mo/conntracct/pkg/kallsyms"
 	"golang.org/x/sys/unix"
@@ -34,6 +35,18 @@ func kernelRelease() (string, error) {
 	return out[1], nil
 }
 
+// taiOffset returns the offset of the kernel's TAI clock from UTC in
+// nanoseconds, the amount of leap seconds known to the kernel.
+func taiOffset() (int64, error) {
+
+	var tx unix.Timex
+	if _, err := unix.Adjtimex(&tx); err != nil {
+		return 0, err
+	}
+
+	return int64(tx.Tai) * int64(time.Second), nil
+}
+
 // checkProbeKsyms checks whether a list of k(ret)probes have their target functions
 // present in the kernel. Expects strings in the format of k(ret)probe/<kernel-symbol>.
 func checkProbeKsyms(probes []string) error {
@@ -0,0 +1,555 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/iovisor/gobpf/elf"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Default size of the per-CPU perf buffers in pages, like gobpf.
+	defaultPerfPageCount = 8
+
+	// Offsets of data_head and data_tail in struct perf_event_mmap_page.
+	perfDataHeadOffset = 1024
+	perfDataTailOffset = 1032
+
+	perfHeaderLen = 8
+
+	// Interval at which idle CPU readers report their progress to the merger.
+	perfPollTimeoutMillis = 50
+
+	// Time after which a record is committed to its perf buffer, at most.
+	// Records can't be merged in order if they're committed later.
+	perfReorderWindow = 5 * time.Millisecond
+
+	cpuOnlinePath = "/sys/devices/system/cpu/online"
+)
+
+// perfReader reads records from a BPF_MAP_TYPE_PERF_EVENT_ARRAY map, reading
+// the perf buffer of each CPU from its own goroutine. Records of all CPUs are
+// merged in order of their kernel timestamp and sent on a channel, like
+// gobpf's PerfMap. Lost records are reported on a second channel.
+type perfReader struct {
+	cpus []*cpuReader
+
+	// Batches of records read from the CPUs' buffers.
+	batches chan perfBatch
+
+	out  chan []byte
+	lost chan uint64
+
+	// Called with the amount of records lost on a CPU, if set.
+	// Set before calling PollStart.
+	onLost func(cpu int, n uint64)
+
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	mergeWg sync.WaitGroup
+}
+
+// cpuReader reads the perf buffer of a single CPU.
+type cpuReader struct {
+	cpu  int
+	fd   int
+	epfd int
+
+	// Metadata page followed by the data pages.
+	mmap []byte
+	data []byte
+	mask uint64
+
+	// Buffers of records are taken from pool.
+	pool recordPool
+
+	// Monotonic time the poller last read the buffer, in nanoseconds.
+	polled uint64
+}
+
+// perfBatch holds the records read from the buffer of a CPU in one read,
+// after which the CPU can only produce records newer than mark.
+type perfBatch struct {
+	cpu     int
+	records [][]byte
+	mark    uint64
+}
+
+// newPerfReader opens perf buffers of the given amount of pages on all online
+// CPUs and installs them into the named perf event array map. Records are sent
+// on out and the amount of lost records on lost after calling PollStart.
+// Their buffers are taken from pool. Readers are woken up once watermark bytes
+// are pending in a buffer, or on every record if watermark is zero.
+func newPerfReader(m *elf.Module, name string, pages int, watermark uint32, out chan []byte, lost chan uint64, pool recordPool) (*perfReader, error) {
+
+	mp := m.Map(name)
+	if mp == nil {
+		return nil, errors.Errorf("map %s not found in probe", name)
+	}
+
+	cpus, err := onlineCPUs()
+	if err != nil {
+		return nil, err
+	}
+
+	if pages == 0 {
+		pages = defaultPerfPageCount
+	}
+
+	pr := &perfReader{
+		batches: make(chan perfBatch, 4*len(cpus)),
+		out:     out,
+		lost:    lost,
+		stop:    make(chan struct{}),
+	}
+
+	for _, cpu := range cpus {
+		cr, err := newCPUReader(cpu, pages, watermark)
+		if err != nil {
+			pr.close()
+			return nil, errors.Wrapf(err, "cpu %d", cpu)
+		}
+		cr.pool = pool
+		pr.cpus = append(pr.cpus, cr)
+
+		key, fd := uint32(cpu), uint32(cr.fd)
+		if err := m.UpdateElement(mp, unsafe.Pointer(&key), unsafe.Pointer(&fd), 0); err != nil {
+			pr.close()
+			return nil, errors.Wrapf(err, "installing perf buffer of cpu %d", cpu)
+		}
+	}
+
+	return pr, nil
+}
+
+// newCPUReader opens and memory-maps a perf buffer on the given CPU. The
+// reader is woken up once watermark bytes are pending, or on every record
+// if watermark is zero.
+func newCPUReader(cpu, pages int, watermark uint32) (*cpuReader, error) {
+
+	attr := unix.PerfEventAttr{
+		Type:        unix.PERF_TYPE_SOFTWARE,
+		Config:      unix.PERF_COUNT_SW_BPF_OUTPUT,
+		Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+		Sample_type: unix.PERF_SAMPLE_RAW,
+		Sample:      1,
+		Wakeup:      1,
+	}
+
+	// Wakeup holds an amount of bytes instead of records when the
+	// watermark bit is set. The kernel caps it at the buffer's size.
+	if watermark != 0 {
+		attr.Bits |= unix.PerfBitWatermark
+		attr.Wakeup = watermark
+	}
+
+	fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "perf event open")
+	}
+
+	ps := unix.Getpagesize()
+	mm, err := unix.Mmap(fd, 0, ps*(pages+1), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "mmap perf buffer")
+	}
+
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		unix.Munmap(mm)
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "epoll create")
+	}
+
+	cr := &cpuReader{
+		cpu:  cpu,
+		fd:   fd,
+		epfd: epfd,
+		mmap: mm,
+		data: mm[ps:],
+		mask: uint64(ps*pages - 1),
+	}
+
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		cr.close()
+		return nil, errors.Wrap(err, "epoll ctl")
+	}
+
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		cr.close()
+		return nil, errors.Wrap(err, "enabling perf event")
+	}
+
+	return cr, nil
+}
+
+// PollStart starts reading records from the perf buffers.
+func (pr *perfReader) PollStart() {
+
+	pr.mergeWg.Add(1)
+	go pr.merge()
+
+	pr.wg.Add(len(pr.cpus))
+	for _, cr := range pr.cpus {
+		go pr.poll(cr)
+	}
+}
+
+// PollStop stops the readers and releases the perf buffers. Records read
+// before stopping are sent on the output channel. Blocks until all
+// goroutines have exited.
+func (pr *perfReader) PollStop() {
+
+	close(pr.stop)
+	pr.wg.Wait()
+
+	close(pr.batches)
+	pr.mergeWg.Wait()
+
+	pr.close()
+}
+
+// close releases the resources of all CPU readers.
+func (pr *perfReader) close() {
+	for _, cr := range pr.cpus {
+		cr.close()
+	}
+}
+
+// lastPoll returns the monotonic time the least recently read CPU buffer
+// was last read, zero if any of them wasn't read yet.
+func (pr *perfReader) lastPoll() uint64 {
+
+	var last uint64
+	for i, cr := range pr.cpus {
+		if t := atomic.LoadUint64(&cr.polled); i == 0 || t < last {
+			last = t
+		}
+	}
+
+	return last
+}
+
+// poll reads the CPU's perf buffer whenever the kernel signals new records,
+// or at least every perfPollTimeoutMillis, until PollStop is called.
+func (pr *perfReader) poll(cr *cpuReader) {
+
+	defer pr.wg.Done()
+
+	events := make([]unix.EpollEvent, 1)
+
+	for {
+		// Records committed after reading the buffer are at most
+		// perfReorderWindow older than the time the read started.
+		now := monotonicNow()
+		atomic.StoreUint64(&cr.polled, now)
+		mark := now - uint64(perfReorderWindow)
+
+		records, lost := cr.read()
+
+		if lost != 0 {
+			if pr.onLost != nil {
+				pr.onLost(cr.cpu, lost)
+			}
+
+			select {
+			case pr.lost <- lost:
+			case <-pr.stop:
+				return
+			}
+		}
+
+		select {
+		case pr.batches <- perfBatch{cpu: cr.cpu, records: records, mark: mark}:
+		case <-pr.stop:
+			return
+		}
+
+		if _, err := unix.EpollWait(cr.epfd, events, perfPollTimeoutMillis); err != nil && err != unix.EINTR {
+			return
+		}
+
+		select {
+		case <-pr.stop:
+			return
+		default:
+		}
+	}
+}
+
+// merge sends the records read by the CPU readers on the output channel in
+// order of their timestamp. A record is sent once all CPUs have been read
+// past its timestamp. Exits when the batch channel is closed, after sending
+// all pending records.
+func (pr *perfReader) merge() {
+
+	defer pr.mergeWg.Done()
+
+	var m perfMerger
+	m.init(pr.cpus)
+
+	for b := range pr.batches {
+		for _, rec := range m.add(b) {
+			pr.out <- rec
+		}
+	}
+
+	for _, rec := range m.drain() {
+		pr.out <- rec
+	}
+}
+
+// read consumes all records in the CPU's perf buffer. Returns the raw data
+// of the samples read and the amount of records the kernel reported lost.
+func (cr *cpuReader) read() ([][]byte, uint64) {
+
+	headPos := (*uint64)(unsafe.Pointer(&cr.mmap[perfDataHeadOffset]))
+	tailPos := (*uint64)(unsafe.Pointer(&cr.mmap[perfDataTailOffset]))
+
+	head := atomic.LoadUint64(headPos)
+	tail := atomic.LoadUint64(tailPos)
+
+	var records [][]byte
+	var lost uint64
+
+	var hdr [perfHeaderLen]byte
+	for tail < head {
+		cr.copyAt(hdr[:], tail)
+		typ := binary.LittleEndian.Uint32(hdr[0:4])
+		size := uint64(binary.LittleEndian.Uint16(hdr[6:8]))
+		if size < perfHeaderLen {
+			// Corrupt header, skip the records in the buffer.
+			tail = head
+			break
+		}
+
+		switch typ {
+		case unix.PERF_RECORD_SAMPLE:
+			var sz [4]byte
+			cr.copyAt(sz[:], tail+perfHeaderLen)
+
+			// The raw data is padded by the kernel, leave out the padding
+			// like gobpf does. Events are a multiple of 8 bytes long.
+			n := int(binary.LittleEndian.Uint32(sz[:])) - 4
+			if n > 0 {
+				rec := cr.pool.get(n)
+				cr.copyAt(rec, tail+perfHeaderLen+4)
+				records = append(records, rec)
+			}
+		case unix.PERF_RECORD_LOST:
+			var n [8]byte
+			cr.copyAt(n[:], tail+perfHeaderLen+8)
+			lost += binary.LittleEndian.Uint64(n[:])
+		}
+
+		tail += size
+	}
+
+	atomic.StoreUint64(tailPos, tail)
+
+	return records, lost
+}
+
+// copyAt copies len(dst) bytes at position pos of the perf buffer to dst,
+// wrapping around the end of the buffer.
+func (cr *cpuReader) copyAt(dst []byte, pos uint64) {
+	off := pos & cr.mask
+	n := copy(dst, cr.data[off:])
+	copy(dst[n:], cr.data)
+}
+
+// close releases the CPU reader's perf event and buffer.
+func (cr *cpuReader) close() {
+	unix.Close(cr.epfd)
+	unix.Munmap(cr.mmap)
+	unix.Close(cr.fd)
+}
+
+// perfMerger orders the records read from multiple CPUs by timestamp.
+type perfMerger struct {
+	pending perfHeap
+
+	// Per CPU, the timestamp before which it has no more records.
+	marks map[int]uint64
+
+	// Order of the records added to the merger, keeping records with
+	// equal timestamps in the order they were read.
+	seq uint64
+
+	// Records returned by add, reused between calls.
+	out [][]byte
+}
+
+func (m *perfMerger) init(cpus []*cpuReader) {
+	m.marks = make(map[int]uint64, len(cpus))
+	for _, cr := range cpus {
+		m.marks[cr.cpu] = 0
+	}
+}
+
+// add adds a batch of records and returns the records that can be sent,
+// in order. The returned slice is only valid until the next call.
+func (m *perfMerger) add(b perfBatch) [][]byte {
+
+	for _, rec := range b.records {
+		m.pending.push(perfRecord{ts: recordTimestamp(rec), seq: m.seq, rec: rec})
+		m.seq++
+	}
+
+	if b.mark > m.marks[b.cpu] {
+		m.marks[b.cpu] = b.mark
+	}
+
+	low := ^uint64(0)
+	for _, mark := range m.marks {
+		if mark < low {
+			low = mark
+		}
+	}
+
+	m.out = m.out[:0]
+	for len(m.pending) > 0 && m.pending[0].ts <= low {
+		m.out = append(m.out, m.pending.pop().rec)
+	}
+
+	return m.out
+}
+
+// drain returns all pending records, in order.
+func (m *perfMerger) drain() [][]byte {
+	out := make([][]byte, 0, len(m.pending))
+	for len(m.pending) > 0 {
+		out = append(out, m.pending.pop().rec)
+	}
+	return out
+}
+
+// perfRecord is a record pending in a perfMerger.
+type perfRecord struct {
+	ts  uint64
+	seq uint64
+	rec []byte
+}
+
+// perfHeap is a min-heap of perfRecords, ordered by timestamp. It doesn't
+// use container/heap, so records aren't boxed into interfaces.
+type perfHeap []perfRecord
+
+func (h perfHeap) less(i, j int) bool {
+	if h[i].ts != h[j].ts {
+		return h[i].ts < h[j].ts
+	}
+	return h[i].seq < h[j].seq
+}
+
+// push adds r to the heap.
+func (h *perfHeap) push(r perfRecord) {
+	*h = append(*h, r)
+
+	s := *h
+	for i := len(s) - 1; i > 0; {
+		p := (i - 1) / 2
+		if !s.less(i, p) {
+			break
+		}
+		s[i], s[p] = s[p], s[i]
+		i = p
+	}
+}
+
+// pop removes and returns the oldest record of the heap.
+func (h *perfHeap) pop() perfRecord {
+	s := *h
+	r := s[0]
+
+	n := len(s) - 1
+	s[0] = s[n]
+	s[n] = perfRecord{}
+	s = s[:n]
+
+	for i := 0; ; {
+		c := 2*i + 1
+		if c >= n {
+			break
+		}
+		if c+1 < n && s.less(c+1, c) {
+			c++
+		}
+		if !s.less(c, i) {
+			break
+		}
+		s[i], s[c] = s[c], s[i]
+		i = c
+	}
+
+	*h = s
+	return r
+}
+
+// recordTimestamp returns the kernel timestamp of a binary Event, zero if
+// the record is too short to hold one.
+func recordTimestamp(rec []byte) uint64 {
+	if len(rec) < 16 {
+		return 0
+	}
+	return *(*uint64)(unsafe.Pointer(&rec[8]))
+}
+
+// monotonicNow returns the current time of the clock used for the
+// timestamps of events, in nanoseconds.
+func monotonicNow() uint64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return uint64(ts.Nano())
+}
+
+// onlineCPUs returns the IDs of the machine's online CPUs.
+func onlineCPUs() ([]int, error) {
+
+	b, err := ioutil.ReadFile(cpuOnlinePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCPUList(strings.TrimSpace(string(b)))
+}
+
+// parseCPUList parses a list of CPU IDs in the kernel's format, eg. '0-3,5'.
+func parseCPUList(s string) ([]int, error) {
+
+	var cpus []int
+
+	for _, r := range strings.Split(s, ",") {
+		first, last := r, r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			first, last = r[:i], r[i+1:]
+		}
+
+		f, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing cpu list '%s'", s)
+		}
+		l, err := strconv.Atoi(last)
+		if err != nil || l < f {
+			return nil, errors.Errorf("parsing cpu list '%s': invalid range '%s'", s, r)
+		}
+
+		for c := f; c <= l; c++ {
+			cpus = append(cpus, c)
+		}
+	}
+
+	return cpus, nil
+}
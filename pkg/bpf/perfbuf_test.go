@@ -0,0 +1,99 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestCPUReaderRead(t *testing.T) {
+
+	ps := unix.Getpagesize()
+
+	cr := cpuReader{
+		mmap: make([]byte, 2*ps),
+		mask: uint64(ps - 1),
+	}
+	cr.data = cr.mmap[ps:]
+
+	// put writes b at position pos of the buffer, wrapping around its end.
+	pos := uint64(ps - 16)
+	put := func(b []byte) {
+		for _, c := range b {
+			cr.data[pos&cr.mask] = c
+			pos++
+		}
+	}
+
+	// A sample wrapping around the end of the buffer, followed by a lost record.
+	sample := make([]byte, 24)
+	binary.LittleEndian.PutUint32(sample[0:], unix.PERF_RECORD_SAMPLE)
+	binary.LittleEndian.PutUint16(sample[6:], 24)
+	binary.LittleEndian.PutUint32(sample[8:], 12)
+	copy(sample[12:], "eventpad")
+	put(sample)
+
+	lost := make([]byte, 24)
+	binary.LittleEndian.PutUint32(lost[0:], unix.PERF_RECORD_LOST)
+	binary.LittleEndian.PutUint16(lost[6:], 24)
+	binary.LittleEndian.PutUint64(lost[16:], 3)
+	put(lost)
+
+	binary.LittleEndian.PutUint64(cr.mmap[perfDataTailOffset:], uint64(ps-16))
+	binary.LittleEndian.PutUint64(cr.mmap[perfDataHeadOffset:], pos)
+
+	recs, n := cr.read()
+	require.Len(t, recs, 1)
+	assert.Equal(t, []byte("eventpad"), recs[0])
+	assert.EqualValues(t, 3, n)
+
+	// The tail is moved up to the head.
+	assert.Equal(t, pos, binary.LittleEndian.Uint64(cr.mmap[perfDataTailOffset:]))
+}
+
+func TestPerfMerger(t *testing.T) {
+
+	rec := func(ts uint64) []byte {
+		b := make([]byte, 16)
+		*(*uint64)(unsafe.Pointer(&b[8])) = ts
+		return b
+	}
+
+	var m perfMerger
+	m.init([]*cpuReader{{cpu: 0}, {cpu: 1}})
+
+	// Records are held back until all CPUs were read past them.
+	assert.Empty(t, m.add(perfBatch{cpu: 0, records: [][]byte{rec(10), rec(30)}, mark: 40}))
+
+	out := m.add(perfBatch{cpu: 1, records: [][]byte{rec(20)}, mark: 25})
+	require.Len(t, out, 2)
+	assert.EqualValues(t, 10, recordTimestamp(out[0]))
+	assert.EqualValues(t, 20, recordTimestamp(out[1]))
+
+	// An idle CPU moves its mark forward.
+	out = m.add(perfBatch{cpu: 1, mark: 50})
+	require.Len(t, out, 1)
+	assert.EqualValues(t, 30, recordTimestamp(out[0]))
+
+	assert.Empty(t, m.add(perfBatch{cpu: 0, records: [][]byte{rec(60), rec(55)}, mark: 50}))
+	out = m.drain()
+	require.Len(t, out, 2)
+	assert.EqualValues(t, 55, recordTimestamp(out[0]))
+}
+
+func TestParseCPUList(t *testing.T) {
+
+	cpus, err := parseCPUList("0-3,5,7-8")
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 5, 7, 8}, cpus)
+
+	_, err = parseCPUList("3-1")
+	assert.Error(t, err)
+
+	_, err = parseCPUList("a")
+	assert.Error(t, err)
+}
@@ -0,0 +1,292 @@
+package bpf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/iovisor/gobpf/elf"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Magic number of the BPF filesystem, BPF_FS_MAGIC.
+const bpfFSMagic = 0xcafe4a11
+
+// bpf() commands used for pinning, from enum bpf_cmd.
+const (
+	bpfMapLookupElem  = 1
+	bpfMapUpdateElem  = 2
+	bpfMapGetNextKey  = 4
+	bpfObjPin         = 6
+	bpfObjGet         = 7
+	bpfObjGetInfoByFD = 15
+)
+
+// Prefix of the names the probe's tracing programs are pinned under.
+const pinProgPrefix = "prog_"
+
+// pinnedMap is a map holding per-flow state of the probe, pinned when
+// Config.PinPath is set.
+type pinnedMap struct {
+	name               string
+	keySize, valueSize uint32
+
+	// fresh returns false for entries of flows without events since the
+	// given monotonic time stamp, which aren't restored.
+	fresh func(value []byte, since uint64) bool
+}
+
+// Maps pinned by the probe, sizes must match acct.c.
+var pinnedMaps = []pinnedMap{
+	{
+		name:      "nextupd",
+		keySize:   4,
+		valueSize: uint32(unsafe.Sizeof(flowState{})),
+		fresh: func(value []byte, since uint64) bool {
+			return (*flowState)(unsafe.Pointer(&value[0])).ts >= since
+		},
+	},
+}
+
+// flowState is the sampling state of a flow, struct flow_state_t.
+type flowState struct {
+	next, ts, bytes, cooldown uint64
+}
+
+// pinState pins the per-flow state maps of the loaded module in dir. The
+// state pinned by a previous probe is copied into the module's maps first,
+// after which the previous maps are unpinned and replaced. Entries of flows
+// without events since the given monotonic time stamp aren't copied, eg. of
+// flows that ended while no probe was running.
+//
+// The probe's tracing programs, if any, are pinned in dir as well, replacing
+// those of the previous probe. Programs attached through kprobes can't be
+// pinned, the kprobes are detached when the process exits regardless.
+// Keeping the state of flows means a restarted probe honors their cooldowns
+// instead of reporting all flows again right away.
+func pinState(mod *elf.Module, dir string, since uint64, tp *tracingProgs) error {
+
+	ok, err := isBPFFS(dir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf(errFmtPinPathFS, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	for _, pm := range pinnedMaps {
+		m := mod.Map(pm.name)
+		if m == nil {
+			return errors.Errorf(errFmtMapNotFound, pm.name)
+		}
+
+		p := filepath.Join(dir, pm.name)
+		if err := restoreMap(m.Fd(), pm, p, since); err != nil {
+			return errors.Wrapf(err, "restoring %s", pm.name)
+		}
+
+		if err := objPin(m.Fd(), p); err != nil {
+			return errors.Wrapf(err, "pinning %s", pm.name)
+		}
+	}
+
+	return pinProgs(dir, tp)
+}
+
+// pinProgs pins the loaded tracing programs in dir, named after their
+// section, eg. prog_fentry_nf_conntrack_free. The programs pinned by a
+// previous probe are unpinned first. Only unpins when tp is nil.
+func pinProgs(dir string, tp *tracingProgs) error {
+
+	old, err := filepath.Glob(filepath.Join(dir, pinProgPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, p := range old {
+		if err := os.Remove(p); err != nil {
+			return errors.Wrap(err, "unpinning program")
+		}
+	}
+
+	if tp == nil {
+		return nil
+	}
+
+	for _, p := range tp.progs {
+		name := pinProgPrefix + strings.ReplaceAll(p.section, "/", "_")
+		if err := objPin(p.fd, filepath.Join(dir, name)); err != nil {
+			return errors.Wrapf(err, "pinning %s", p.section)
+		}
+	}
+
+	return nil
+}
+
+// restoreMap copies the fresh entries of the map pinned at path p into the
+// map with the given fd and unpins it. No-op if no map is pinned at p. The
+// entries of a map with other key or value sizes, eg. pinned by another
+// version of the probe, are dropped.
+func restoreMap(mfd int, pm pinnedMap, p string, since uint64) error {
+
+	fd, err := objGet(p)
+	if err == unix.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	keySize, valueSize, err := mapSizes(fd)
+	if err != nil {
+		return err
+	}
+
+	if keySize == pm.keySize && valueSize == pm.valueSize {
+		key := make([]byte, keySize)
+		next := make([]byte, keySize)
+		value := make([]byte, valueSize)
+
+		// Iteration starts at the first key when given no key.
+		var cur unsafe.Pointer
+		for {
+			if err := mapCmd(bpfMapGetNextKey, fd, cur, unsafe.Pointer(&next[0])); err != nil {
+				if err == unix.ENOENT {
+					break
+				}
+				return errors.Wrap(err, "iterating pinned map")
+			}
+			copy(key, next)
+			cur = unsafe.Pointer(&key[0])
+
+			// Entries can be deleted while iterating.
+			if err := mapCmd(bpfMapLookupElem, fd, cur, unsafe.Pointer(&value[0])); err != nil {
+				if err == unix.ENOENT {
+					continue
+				}
+				return errors.Wrap(err, "looking up pinned entry")
+			}
+
+			if pm.fresh != nil && !pm.fresh(value, since) {
+				continue
+			}
+
+			if err := mapCmd(bpfMapUpdateElem, mfd, cur, unsafe.Pointer(&value[0])); err != nil {
+				return errors.Wrap(err, "restoring entry")
+			}
+		}
+	}
+
+	return os.Remove(p)
+}
+
+// mapCmd runs a bpf() command on a key and value of the map with the given fd.
+func mapCmd(cmd int, fd int, key, value unsafe.Pointer) error {
+
+	// Leading fields of union bpf_attr used by map element commands.
+	attr := struct {
+		fd         uint32
+		_          uint32
+		key, value uint64
+		flags      uint64
+	}{
+		fd:    uint32(fd),
+		key:   uint64(uintptr(key)),
+		value: uint64(uintptr(value)),
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd),
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr)); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// mapSizes returns the key and value sizes of the map with the given fd.
+func mapSizes(fd int) (uint32, uint32, error) {
+
+	// Leading fields of struct bpf_map_info.
+	var info struct {
+		mapType, id, keySize, valueSize uint32
+	}
+
+	attr := struct {
+		fd, len uint32
+		info    uint64
+	}{
+		fd:   uint32(fd),
+		len:  uint32(unsafe.Sizeof(info)),
+		info: uint64(uintptr(unsafe.Pointer(&info))),
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, bpfObjGetInfoByFD,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr)); errno != 0 {
+		return 0, 0, errors.Wrap(errno, "getting pinned map info")
+	}
+
+	return info.keySize, info.valueSize, nil
+}
+
+// objPin pins the BPF object with the given fd at path p.
+func objPin(fd int, p string) error {
+
+	_, err := objCmd(bpfObjPin, fd, p)
+
+	return err
+}
+
+// objGet opens the BPF object pinned at path p.
+func objGet(p string) (int, error) {
+	return objCmd(bpfObjGet, 0, p)
+}
+
+// objCmd runs a bpf() command on a pinned path.
+func objCmd(cmd int, fd int, p string) (int, error) {
+
+	b, err := unix.BytePtrFromString(p)
+	if err != nil {
+		return 0, err
+	}
+
+	// Leading fields of union bpf_attr used by BPF_OBJ_*.
+	attr := struct {
+		pathname uint64
+		fd       uint32
+		flags    uint32
+	}{
+		pathname: uint64(uintptr(unsafe.Pointer(b))),
+		fd:       uint32(fd),
+	}
+
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd),
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(r), nil
+}
+
+// isBPFFS returns true if the directory at path p, or its closest existing
+// parent, is on a BPF filesystem.
+func isBPFFS(p string) (bool, error) {
+
+	for {
+		var st unix.Statfs_t
+		err := unix.Statfs(p, &st)
+		if err == nil {
+			return uint32(st.Type) == bpfFSMagic, nil
+		}
+		if err != unix.ENOENT || p == filepath.Dir(p) {
+			return false, err
+		}
+		p = filepath.Dir(p)
+	}
+}
@@ -0,0 +1,175 @@
+package bpf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPinnedMaps(t *testing.T) {
+
+	// struct flow_state_t holds four u64s.
+	assert.EqualValues(t, 32, pinnedMaps[0].valueSize)
+
+	// Flows are fresh if they had an event since the given time stamp.
+	fs := flowState{next: 200, ts: 100}
+	b := (*[32]byte)(unsafe.Pointer(&fs))[:]
+	assert.True(t, pinnedMaps[0].fresh(b, 100))
+	assert.False(t, pinnedMaps[0].fresh(b, 101))
+
+	// Non-existent directories are checked at their closest parent.
+	ok, err := isBPFFS(filepath.Join(t.TempDir(), "conntracct", "state"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRestoreMap(t *testing.T) {
+
+	dir := testBPFFS(t)
+	pm := pinnedMaps[0]
+	p := filepath.Join(dir, pm.name)
+
+	put := func(fd int, key uint32, fs flowState) {
+		require.NoError(t, mapCmd(bpfMapUpdateElem, fd, unsafe.Pointer(&key), unsafe.Pointer(&fs)))
+	}
+	get := func(fd int, key uint32) (flowState, error) {
+		var fs flowState
+		err := mapCmd(bpfMapLookupElem, fd, unsafe.Pointer(&key), unsafe.Pointer(&fs))
+		return fs, err
+	}
+
+	prev := testMap(t, pm.keySize, pm.valueSize)
+	put(prev, 1, flowState{next: 200, ts: 100, bytes: 1000, cooldown: 100})
+	put(prev, 2, flowState{next: 150, ts: 50})
+	require.NoError(t, objPin(prev, p))
+
+	// Entries of flows without events since the given time stamp are
+	// dropped, the previous map is unpinned.
+	cur := testMap(t, pm.keySize, pm.valueSize)
+	require.NoError(t, restoreMap(cur, pm, p, 80))
+
+	fs, err := get(cur, 1)
+	require.NoError(t, err)
+	assert.Equal(t, flowState{next: 200, ts: 100, bytes: 1000, cooldown: 100}, fs)
+
+	_, err = get(cur, 2)
+	assert.Equal(t, unix.ENOENT, err)
+
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err))
+
+	// No-op without a pinned map.
+	require.NoError(t, restoreMap(cur, pm, p, 80))
+
+	// The entries of maps with other sizes are dropped.
+	other := testMap(t, pm.keySize, 8)
+	key, value := uint32(3), uint64(1)
+	require.NoError(t, mapCmd(bpfMapUpdateElem, other, unsafe.Pointer(&key), unsafe.Pointer(&value)))
+	require.NoError(t, objPin(other, p))
+
+	require.NoError(t, restoreMap(cur, pm, p, 0))
+	_, err = get(cur, 3)
+	assert.Equal(t, unix.ENOENT, err)
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPinProgs(t *testing.T) {
+
+	dir := testBPFFS(t)
+
+	// Maps pinned alongside are left alone.
+	m := testMap(t, 4, 8)
+	require.NoError(t, objPin(m, filepath.Join(dir, "nextupd")))
+
+	tp := &tracingProgs{progs: []*tracingProg{
+		{section: "fentry/nf_conntrack_free", fd: testProg(t)},
+		{section: "fexit/__nf_ct_refresh_acct", fd: testProg(t)},
+	}}
+
+	pinned := func() []string {
+		es, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, e := range es {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	require.NoError(t, pinProgs(dir, tp))
+	assert.ElementsMatch(t, []string{"nextupd", "prog_fentry_nf_conntrack_free", "prog_fexit___nf_ct_refresh_acct"}, pinned())
+
+	// The programs of a previous probe are replaced.
+	tp.progs = tp.progs[:1]
+	require.NoError(t, pinProgs(dir, tp))
+	assert.ElementsMatch(t, []string{"nextupd", "prog_fentry_nf_conntrack_free"}, pinned())
+
+	require.NoError(t, pinProgs(dir, nil))
+	assert.Equal(t, []string{"nextupd"}, pinned())
+}
+
+// testBPFFS mounts a BPF filesystem for the test and returns a directory on
+// it, skipping the test if mounting it is not permitted.
+func testBPFFS(t *testing.T) string {
+
+	mnt := t.TempDir()
+	if err := unix.Mount("bpf", mnt, "bpf", 0, ""); err != nil {
+		t.Skipf("mounting BPF filesystem: %s", err)
+	}
+	t.Cleanup(func() { unix.Unmount(mnt, unix.MNT_DETACH) })
+
+	// The root of the filesystem can hold files of the kernel.
+	dir := filepath.Join(mnt, "conntracct")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+
+	return dir
+}
+
+// testMap creates a BPF hash map with the given key and value sizes.
+func testMap(t *testing.T, keySize, valueSize uint32) int {
+
+	// Leading fields of union bpf_attr used by BPF_MAP_CREATE, of a
+	// BPF_MAP_TYPE_HASH.
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries uint32
+	}{1, keySize, valueSize, 16}
+
+	r, _, errno := unix.Syscall(unix.SYS_BPF, 0, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	require.Zero(t, errno, "creating map")
+	t.Cleanup(func() { unix.Close(int(r)) })
+
+	return int(r)
+}
+
+// testProg loads a BPF socket filter returning zero.
+func testProg(t *testing.T) int {
+
+	insns := []byte{
+		0xb7, 0, 0, 0, 0, 0, 0, 0, // r0 = 0
+		0x95, 0, 0, 0, 0, 0, 0, 0, // exit
+	}
+
+	// Leading fields of union bpf_attr used by BPF_PROG_LOAD, of a
+	// BPF_PROG_TYPE_SOCKET_FILTER.
+	attr := struct {
+		progType, insnCnt uint32
+		insns, license    uint64
+	}{
+		progType: 1,
+		insnCnt:  2,
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&tracingLicense[0]))),
+	}
+
+	r, _, errno := unix.Syscall(unix.SYS_BPF, bpfProgLoad, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	require.Zero(t, errno, "loading program")
+	t.Cleanup(func() { unix.Close(int(r)) })
+
+	return int(r)
+}
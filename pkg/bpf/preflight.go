@@ -0,0 +1,258 @@
+package bpf
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/ti-mo/conntracct/internal/sysctl"
+	"github.com/ti-mo/conntracct/pkg/kernel"
+)
+
+// Approximate amount of locked memory taken up by the probe's maps
+// other than its event buffers, mostly the port allowlist.
+const otherMapsMemlock = 1 << 20
+
+// Check is the outcome of one of the checks run by Preflight.
+type Check struct {
+	Name string
+
+	// Description of what was found, eg. the selected probe.
+	Detail string
+
+	// Reason the check failed, nil if it passed.
+	Err error
+
+	// Suggestion for resolving a failure.
+	Hint string
+
+	// Failures of optional checks don't prevent the probe from loading,
+	// but disable some of its features.
+	Optional bool
+}
+
+// Preflight checks whether the machine can run a Probe with the given Config:
+// whether the kernel version is supported, the conntrack accounting sysctls
+// are enabled, the kernel symbols targeted by the probe are present, the
+// process has the capabilities to load the probe, bpf() can be called, the
+// locked memory limit fits the probe's buffers and the pin path, if any, is
+// on a BPF filesystem.
+// All checks are run, even if earlier ones fail.
+func Preflight(cfg Config) []Check {
+
+	var out []Check
+
+	kr, err := kernelRelease()
+	if err != nil {
+		return append(out, Check{Name: "kernel version", Err: err})
+	}
+
+	kc, k := checkKernel(kr)
+	out = append(out, kc)
+
+	out = append(out,
+		checkSysctl("net.netfilter.nf_conntrack_acct", false,
+			"conntrack doesn't count packets and bytes of flows without it"),
+		checkSysctl("net.netfilter.nf_conntrack_timestamp", true,
+			"events of destroyed flows won't carry their start time and duration"),
+	)
+
+	ksyms := Check{Name: "kernel symbols", Detail: fmt.Sprintf("%d probe targets found", len(k.Probes))}
+	if len(k.Probes) == 0 {
+		ksyms.Err = errors.New("no probe selected for the kernel")
+	} else if err := checkProbeKsyms(k.Probes); err != nil {
+		ksyms.Err = err
+		ksyms.Hint = "load the conntrack module with 'modprobe nf_conntrack' and make sure kprobes are enabled"
+	}
+	out = append(out, ksyms)
+
+	out = append(out, checkCaps(kr), checkBPF(), checkMemlock(kr, k, cfg))
+
+	if cfg.PinPath != "" {
+		out = append(out, checkPinPath(cfg.PinPath))
+	}
+
+	return out
+}
+
+// checkKernel checks if there's a probe for the given kernel release.
+func checkKernel(kr string) (Check, kernel.Kernel) {
+
+	c := Check{Name: "kernel version"}
+
+	k, err := findProbe(kr, kernel.Builds)
+	if err != nil {
+		c.Err = err
+		return c, k
+	}
+
+	buf := "perf buffers"
+	if k.RingBuf {
+		buf = "ring buffers"
+	}
+	c.Detail = fmt.Sprintf("kernel %s, using probe %s with %s", kr, k.Version, buf)
+
+	// The lowest probe is returned when no probe is old enough.
+	if semver.MustParse(k.Version).GT(semver.MustParse(kr)) {
+		c.Err = fmt.Errorf("kernel %s is older than the oldest supported kernel %s", kr, k.Version)
+		c.Hint = "upgrade the kernel, or use the netlink fallback"
+	}
+
+	return c, k
+}
+
+// checkSysctl checks if the sysctl with the given name is enabled.
+func checkSysctl(name string, optional bool, impact string) Check {
+
+	c := Check{Name: name, Optional: optional, Detail: "enabled"}
+
+	v, err := sysctl.Get(name)
+	if err != nil {
+		c.Err = err
+		c.Hint = "load the conntrack module with 'modprobe nf_conntrack'"
+		return c
+	}
+
+	if v != "1" {
+		c.Err = fmt.Errorf("disabled, %s", impact)
+		c.Hint = fmt.Sprintf("enable it with 'sysctl -w %s=1'", name)
+	}
+
+	return c
+}
+
+// checkCaps checks if the process has the capabilities to load and attach
+// the probe on kernel release kr.
+func checkCaps(kr string) Check {
+
+	c := Check{Name: "capabilities", Detail: "allowed to load and attach the probe"}
+
+	caps, err := effectiveCaps()
+	if err != nil {
+		c.Err = err
+		return c
+	}
+
+	if m := missingCaps(kr, caps); len(m) > 0 {
+		c.Err = &PermissionError{Missing: m}
+		c.Hint = fmt.Sprintf("run as root or grant %s, eg. with AmbientCapabilities in the systemd unit", strings.Join(m, " and "))
+	}
+
+	return c
+}
+
+// checkBPF checks if the process is allowed to call bpf() by creating
+// and closing a small BPF map.
+func checkBPF() Check {
+
+	c := Check{Name: "bpf permissions", Detail: "allowed to create BPF maps"}
+
+	// Leading fields of union bpf_attr used by BPF_MAP_CREATE.
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries uint32
+	}{
+		mapType:    2, // BPF_MAP_TYPE_ARRAY
+		keySize:    4,
+		valueSize:  4,
+		maxEntries: 1,
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, 0, // BPF_MAP_CREATE
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		c.Err = errors.Wrap(errno, "creating BPF map")
+		c.Hint = "run as root or with cap_sys_admin, and check kernel.unprivileged_bpf_disabled and any seccomp policy"
+		return c
+	}
+	unix.Close(int(fd))
+
+	return c
+}
+
+// checkPinPath checks if the probe's pin path is on a BPF filesystem.
+func checkPinPath(dir string) Check {
+
+	c := Check{Name: "pin path", Detail: fmt.Sprintf("%s is on a BPF filesystem", dir)}
+
+	ok, err := isBPFFS(dir)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+
+	if !ok {
+		c.Err = fmt.Errorf(errFmtPinPathFS, dir)
+		c.Hint = "mount it with 'mount -t bpf bpf /sys/fs/bpf', or clear the pin path"
+	}
+
+	return c
+}
+
+// checkMemlock checks if the locked memory limit of the process fits the
+// probe's maps. Kernels 5.11 and later charge BPF maps to the memory cgroup
+// instead of the limit.
+func checkMemlock(kr string, k kernel.Kernel, cfg Config) Check {
+
+	c := Check{Name: "memlock limit"}
+
+	if kernelAtLeast(kr, memcgRelease) {
+		c.Detail = "not used, the kernel charges BPF maps to the memory cgroup"
+		return c
+	}
+
+	var rl unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &rl); err != nil {
+		c.Err = err
+		return c
+	}
+
+	if rl.Cur == unix.RLIM_INFINITY {
+		c.Detail = "unlimited"
+		return c
+	}
+
+	need := memlockRequired(k, cfg, runtime.NumCPU(), unix.Getpagesize())
+	c.Detail = fmt.Sprintf("%d bytes, the probe needs about %d", rl.Cur, need)
+
+	if rl.Cur >= need {
+		return c
+	}
+
+	// The probe raises the limit when loading if it can.
+	caps, _ := effectiveCaps()
+	if rl.Max == unix.RLIM_INFINITY || rl.Max >= need || hasCap(caps, capSysResource) {
+		c.Detail += ", raised when loading"
+		return c
+	}
+
+	c.Err = errors.New(c.Detail)
+	c.Hint = "raise it with 'ulimit -l unlimited' or LimitMEMLOCK=infinity in the systemd unit"
+
+	return c
+}
+
+// memlockRequired returns the approximate amount of locked memory used by
+// the maps of a probe for kernel k with the given Config.
+func memlockRequired(k kernel.Kernel, cfg Config, cpus, pageSize int) uint64 {
+
+	if k.RingBuf {
+		size := cfg.RingBufSize
+		if size == 0 {
+			size = ringBufSize
+		}
+		return 2*uint64(size) + otherMapsMemlock
+	}
+
+	// gobpf allocates 8 pages per CPU by default, plus one metadata page.
+	pages := cfg.PerfPageCount
+	if pages == 0 {
+		pages = 8
+	}
+
+	return 2*uint64(cpus)*uint64(pages+1)*uint64(pageSize) + otherMapsMemlock
+}
@@ -0,0 +1,23 @@
+package bpf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/pkg/kernel"
+)
+
+func TestMemlockRequired(t *testing.T) {
+
+	// Two default ring buffers.
+	assert.EqualValues(t, 2<<22+otherMapsMemlock,
+		memlockRequired(kernel.Kernel{RingBuf: true}, Config{}, 4, 4096))
+
+	// Two perf buffers of 8 pages and a metadata page per CPU.
+	assert.EqualValues(t, 2*4*9*4096+otherMapsMemlock,
+		memlockRequired(kernel.Kernel{}, Config{}, 4, 4096))
+
+	assert.EqualValues(t, 2*4*65*4096+otherMapsMemlock,
+		memlockRequired(kernel.Kernel{}, Config{PerfPageCount: 64}, 4, 4096))
+}
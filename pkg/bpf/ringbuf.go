@@ -0,0 +1,230 @@
+package bpf
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ringUpdateMap  = "ringbuf_acct_update"
+	ringDestroyMap = "ringbuf_acct_end"
+
+	// Default size of the probe's ring buffers, must match RINGBUF_SIZE in acct.c.
+	ringBufSize = 1 << 22
+
+	// Flags in the length field of a ring buffer record header.
+	ringBusyBit    = 1 << 31
+	ringDiscardBit = 1 << 30
+
+	ringHeaderLen = 8
+
+	// Interval at which the poller checks if it needs to stop, and the longest
+	// time records are held back waiting for the watermark.
+	ringPollTimeoutMillis = 100
+
+	// Interval at which the poller checks if the watermark is reached.
+	// The buffer's fd stays readable while records are pending, so the
+	// poller can't wait on it until they're read.
+	ringWatermarkInterval = 10 * time.Millisecond
+)
+
+// ringReader reads records from a BPF_MAP_TYPE_RINGBUF map and sends them
+// on a channel, like gobpf's PerfMap.
+type ringReader struct {
+	epfd int
+
+	// Consumer position page, writable.
+	cons []byte
+	// Producer position page followed by the data pages, which are mapped
+	// twice so records wrapping around the end of the buffer are contiguous.
+	prod []byte
+
+	mask uint64
+	out  chan []byte
+
+	// Buffers of records are taken from pool.
+	pool recordPool
+
+	// Amount of bytes pending before the buffer is read.
+	watermark uint64
+
+	// Monotonic time the poller last checked the buffer, in nanoseconds.
+	polled uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newRingReader memory-maps the ring buffer map with the given fd and size,
+// zero meaning the default size. Records are sent on out after calling
+// PollStart, once watermark bytes are pending or the buffer is idle.
+// Their buffers are taken from pool.
+func newRingReader(fd, size int, watermark uint32, out chan []byte, pool recordPool) (*ringReader, error) {
+
+	ps := unix.Getpagesize()
+
+	if size == 0 {
+		size = ringBufSize
+	}
+
+	cons, err := unix.Mmap(fd, 0, ps, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "mmap consumer page")
+	}
+
+	prod, err := unix.Mmap(fd, int64(ps), ps+2*size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		unix.Munmap(cons)
+		return nil, errors.Wrap(err, "mmap producer pages")
+	}
+
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		unix.Munmap(cons)
+		unix.Munmap(prod)
+		return nil, errors.Wrap(err, "epoll create")
+	}
+
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+	if err := unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		unix.Close(epfd)
+		unix.Munmap(cons)
+		unix.Munmap(prod)
+		return nil, errors.Wrap(err, "epoll ctl")
+	}
+
+	return &ringReader{
+		epfd:      epfd,
+		cons:      cons,
+		prod:      prod,
+		mask:      uint64(size - 1),
+		out:       out,
+		pool:      pool,
+		watermark: uint64(watermark),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// PollStart starts reading records from the ring buffer.
+func (r *ringReader) PollStart() {
+	r.wg.Add(1)
+	go r.poll()
+}
+
+// PollStop stops the poller and releases the ring buffer's mappings.
+// Blocks until the poller has exited.
+func (r *ringReader) PollStop() {
+	close(r.stop)
+	r.wg.Wait()
+
+	unix.Close(r.epfd)
+	unix.Munmap(r.cons)
+	unix.Munmap(r.prod)
+}
+
+// poll waits for the kernel to signal new records and reads them
+// until PollStop is called.
+func (r *ringReader) poll() {
+
+	defer r.wg.Done()
+
+	events := make([]unix.EpollEvent, 1)
+
+	// Time records below the watermark have been held back since.
+	var held time.Time
+
+	t := time.NewTicker(ringWatermarkInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		atomic.StoreUint64(&r.polled, monotonicNow())
+
+		// Read before waiting, the kernel doesn't always wake up the reader
+		// when records are added to a non-empty buffer. With a watermark,
+		// only read once enough records are pending or they were held back
+		// for a poll timeout.
+		p := r.pending()
+		if p >= r.watermark || (!held.IsZero() && time.Since(held) >= ringPollTimeoutMillis*time.Millisecond) {
+			r.read()
+			held = time.Time{}
+			p = r.pending()
+		}
+
+		// The buffer's fd is readable while records are pending, waiting on
+		// it would return immediately. Check the watermark on a ticker instead.
+		if p != 0 {
+			if held.IsZero() {
+				held = time.Now()
+			}
+			select {
+			case <-t.C:
+			case <-r.stop:
+				return
+			}
+			continue
+		}
+
+		if _, err := unix.EpollWait(r.epfd, events, ringPollTimeoutMillis); err != nil && err != unix.EINTR {
+			return
+		}
+	}
+}
+
+// pending returns the amount of bytes in the ring buffer not yet consumed.
+func (r *ringReader) pending() uint64 {
+	cons := atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.cons[0])))
+	prod := atomic.LoadUint64((*uint64)(unsafe.Pointer(&r.prod[0])))
+	return prod - cons
+}
+
+// read consumes all committed records in the ring buffer.
+func (r *ringReader) read() {
+
+	consPos := (*uint64)(unsafe.Pointer(&r.cons[0]))
+	prodPos := (*uint64)(unsafe.Pointer(&r.prod[0]))
+
+	// Data pages start after the producer position page.
+	data := r.prod[unix.Getpagesize():]
+
+	cons := atomic.LoadUint64(consPos)
+	prod := atomic.LoadUint64(prodPos)
+
+	for cons < prod {
+		off := cons & r.mask
+
+		hdr := atomic.LoadUint32((*uint32)(unsafe.Pointer(&data[off])))
+		if hdr&ringBusyBit != 0 {
+			// Record is still being written by the producer.
+			break
+		}
+
+		l := uint64(hdr &^ (ringBusyBit | ringDiscardBit))
+
+		if hdr&ringDiscardBit == 0 {
+			start := off + ringHeaderLen
+			rec := r.pool.get(int(l))
+			copy(rec, data[start:start+l])
+
+			select {
+			case r.out <- rec:
+			case <-r.stop:
+				return
+			}
+		}
+
+		// Records are padded to 8 bytes.
+		cons += (l + ringHeaderLen + 7) &^ 7
+		atomic.StoreUint64(consPos, cons)
+	}
+}
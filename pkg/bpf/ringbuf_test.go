@@ -0,0 +1,107 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestRingReaderRead(t *testing.T) {
+
+	ps := unix.Getpagesize()
+
+	out := make(chan []byte, 4)
+	r := ringReader{
+		cons: make([]byte, ps),
+		prod: make([]byte, ps+2*ringBufSize),
+		mask: ringBufSize - 1,
+		out:  out,
+	}
+
+	data := r.prod[ps:]
+
+	// A committed 5-byte record, a discarded record and a busy record.
+	binary.LittleEndian.PutUint32(data[0:], 5)
+	copy(data[8:], "hello")
+	binary.LittleEndian.PutUint32(data[16:], 3|ringDiscardBit)
+	binary.LittleEndian.PutUint32(data[32:], 4|ringBusyBit)
+
+	binary.LittleEndian.PutUint64(r.prod[0:], 48)
+
+	r.read()
+
+	assert.Len(t, out, 1)
+	assert.Equal(t, []byte("hello"), <-out)
+
+	// Consumer stops in front of the busy record.
+	assert.EqualValues(t, 32, binary.LittleEndian.Uint64(r.cons[0:]))
+}
+
+func TestRingReaderWatermark(t *testing.T) {
+
+	ps := unix.Getpagesize()
+
+	// The fd of a ring buffer is readable while records are pending,
+	// like an eventfd with a non-zero counter.
+	efd, err := unix.Eventfd(1, unix.EFD_CLOEXEC)
+	require.NoError(t, err)
+	defer unix.Close(efd)
+
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	require.NoError(t, err)
+	defer unix.Close(epfd)
+
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(efd)}
+	require.NoError(t, unix.EpollCtl(epfd, unix.EPOLL_CTL_ADD, efd, &ev))
+
+	out := make(chan []byte, 4)
+	r := ringReader{
+		epfd:      epfd,
+		cons:      make([]byte, ps),
+		prod:      make([]byte, ps+2*ringBufSize),
+		mask:      ringBufSize - 1,
+		out:       out,
+		watermark: 64,
+		stop:      make(chan struct{}),
+	}
+
+	data := r.prod[ps:]
+
+	// A record below the watermark is held back for a poll timeout.
+	binary.LittleEndian.PutUint32(data[0:], 5)
+	copy(data[8:], "hello")
+	binary.LittleEndian.PutUint64(r.prod[0:], 16)
+
+	start := time.Now()
+	r.PollStart()
+
+	// The buffers aren't mapped, only stop the poller.
+	defer func() {
+		close(r.stop)
+		r.wg.Wait()
+	}()
+
+	select {
+	case rec := <-out:
+		assert.Equal(t, []byte("hello"), rec)
+		assert.True(t, time.Since(start) >= ringPollTimeoutMillis*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("record held back below the watermark was never read")
+	}
+
+	// Records reaching the watermark are read without waiting for the timeout.
+	binary.LittleEndian.PutUint32(data[16:], 5)
+	binary.LittleEndian.PutUint32(data[32:], 48)
+	binary.LittleEndian.PutUint64(r.prod[0:], 88)
+
+	select {
+	case <-out:
+		assert.Len(t, <-out, 48)
+	case <-time.After(time.Second):
+		t.Fatal("records reaching the watermark were never read")
+	}
+}
@@ -2,9 +2,11 @@ package bpf
 
 import "github.com/ti-mo/conntracct/internal/sysctl"
 
-// Sysctls applies a list of sysctls on the machine.
-// When verbose is true, logs any changes made to stdout.
-func Sysctls(verbose bool) error {
+// Sysctls enables the conntrack sysctls needed by the probe on the machine.
+// When verbose is true, logs any changes made to stdout. The returned function
+// restores the sysctls that were changed to their previous values, it is
+// non-nil even if an error is returned.
+func Sysctls(verbose bool) (restore func() error, err error) {
 
 	sysctls := map[string]string{
 
@@ -18,5 +20,12 @@ func Sysctls(verbose bool) error {
 		"net.netfilter.nf_conntrack_timestamp": "1",
 	}
 
-	return sysctl.Apply(sysctls, verbose)
+	prev, err := sysctl.Apply(sysctls, verbose)
+
+	restore = func() error {
+		_, err := sysctl.Apply(prev, verbose)
+		return err
+	}
+
+	return restore, err
 }
@@ -0,0 +1,311 @@
+// Package conntracct embeds conntracct's accounting probe and pipeline into
+// other programs. Events are delivered to Go callbacks registered as
+// consumers on a Builder, optionally passing through middleware that
+// modifies or drops them first:
+//
+//	ct, err := conntracct.NewBuilder().
+//		WithConfig(bpf.Config{CooldownMillis: 2000}).
+//		WithMiddleware("no-dns", func(e bpf.Event) (bpf.Event, bool) {
+//			return e, e.DstPort != 53
+//		}).
+//		WithConsumer("log", bpf.ConsumerDestroy, func(e bpf.Event) {
+//			log.Println(e.String())
+//		}).
+//		Build()
+//	if err != nil {
+//		return err
+//	}
+//
+//	// Blocks until ctx is done.
+//	return ct.Run(ctx)
+package conntracct
+
+import (
+	"context"
+	"time"
+
+	"github.com/ti-mo/conntracct/internal/pipeline"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Handler is called with every event received by a consumer. Handlers are
+// called from the pipeline's workers and hold up the pipeline until they
+// return, so they must not block. Events must not be modified.
+type Handler func(bpf.Event)
+
+// Middleware is called with every event before it is delivered to the
+// consumers, and returns the event to pass on or false to drop it.
+// Middleware is called from the pipeline's workers concurrently, so it
+// must be thread-safe, and should not block.
+type Middleware func(bpf.Event) (bpf.Event, bool)
+
+// Builder configures an embedded accounting pipeline.
+type Builder struct {
+	config     bpf.Config
+	fallback   bool
+	rates      bool
+	workers    int
+	queues     [2]int // update, destroy
+	backpress  bpf.Backpressure
+	onLost     bpf.LostFunc
+	onSamples  bpf.LostSamplesFunc
+	onSeqGap   bpf.SeqGapFunc
+	middleware []namedMiddleware
+	consumers  []*handlerSink
+	built      bool
+	err        error
+}
+
+// namedMiddleware is a Middleware added to a Builder.
+type namedMiddleware struct {
+	name string
+	fn   Middleware
+}
+
+// NewBuilder returns a Builder with the probe's default configuration.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// WithConfig sets the configuration of the accounting probe.
+func (b *Builder) WithConfig(cfg bpf.Config) *Builder {
+	b.config = cfg
+	return b
+}
+
+// WithNetlinkFallback sets whether conntrack events are received over
+// netlink when the BPF probe can't be loaded, eg. on kernels without
+// kprobe support. Netlink events are sent less often than the probe's.
+func (b *Builder) WithNetlinkFallback(enable bool) *Builder {
+	b.fallback = enable
+	return b
+}
+
+// WithRates sets whether the throughput of flows since their previous event
+// is computed, delivered to consumers in Event.Rate.
+func (b *Builder) WithRates(enable bool) *Builder {
+	b.rates = enable
+	return b
+}
+
+// WithWorkers sets the amount of workers delivering events to the consumers
+// in parallel. Events of a flow are always delivered by the same worker, in
+// order. Handlers are called concurrently if n is larger than one.
+func (b *Builder) WithWorkers(n int) *Builder {
+	b.workers = n
+	return b
+}
+
+// WithQueues sets the amount of update and destroy events buffered between
+// the probe and the pipeline, 1024 by default, and whether events are dropped
+// (default) or the probe waits when a queue is full because consumers can't
+// keep up. Waiting loses no events unless the kernel's buffers overflow.
+func (b *Builder) WithQueues(update, destroy int, bp bpf.Backpressure) *Builder {
+	b.queues = [2]int{update, destroy}
+	b.backpress = bp
+	return b
+}
+
+// WithOnLost sets a function called whenever the probe loses events, either
+// in the kernel due to full perf buffers or because the pipeline can't keep
+// up. Called with the probe's internal consumers, AcctUpdate and AcctDestroy.
+func (b *Builder) WithOnLost(f bpf.LostFunc) *Builder {
+	b.onLost = f
+	return b
+}
+
+// WithOnLostSamples sets a function called whenever the kernel reports events
+// lost due to a full perf buffer of the probe, with the CPU of the buffer.
+// Never called for probes using ring buffers.
+func (b *Builder) WithOnLostSamples(f bpf.LostSamplesFunc) *Builder {
+	b.onSamples = f
+	return b
+}
+
+// WithOnSeqGap sets a function called whenever a gap is detected in the
+// sequence numbers of the probe's events, with the CPU, amount and time
+// span of the lost events. Also called for probes using ring buffers.
+func (b *Builder) WithOnSeqGap(f bpf.SeqGapFunc) *Builder {
+	b.onSeqGap = f
+	return b
+}
+
+// WithConsumer adds a consumer calling h with the events selected by mode.
+// Consumer names must be unique.
+func (b *Builder) WithConsumer(name string, mode bpf.ConsumerMode, h Handler) *Builder {
+
+	if b.err != nil {
+		return b
+	}
+
+	switch {
+	case name == "":
+		b.err = errEmptyName
+		return b
+	case h == nil:
+		b.err = errNilHandler
+		return b
+	}
+
+	for _, c := range b.consumers {
+		if c.name == name {
+			b.err = errDupConsumer
+			return b
+		}
+	}
+
+	if mode == 0 {
+		mode = bpf.ConsumerAll
+	}
+
+	b.consumers = append(b.consumers, &handlerSink{name: name, mode: mode, handler: h})
+
+	return b
+}
+
+// WithMiddleware adds a Middleware stage run on every event before it is
+// delivered to the consumers. Stages run in the order they were added,
+// each receiving the event returned by the previous one. Middleware names
+// must be unique.
+func (b *Builder) WithMiddleware(name string, m Middleware) *Builder {
+
+	if b.err != nil {
+		return b
+	}
+
+	switch {
+	case name == "":
+		b.err = errEmptyName
+		return b
+	case m == nil:
+		b.err = errNilMiddleware
+		return b
+	}
+
+	for _, nm := range b.middleware {
+		if nm.name == name {
+			b.err = errDupMiddleware
+			return b
+		}
+	}
+
+	b.middleware = append(b.middleware, namedMiddleware{name, m})
+
+	return b
+}
+
+// Build loads the accounting probe and returns a Conntracct ready to be run.
+// Returns the first error encountered while configuring the Builder.
+// A Builder can only be built once.
+func (b *Builder) Build() (*Conntracct, error) {
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.built {
+		return nil, errAlreadyBuilt
+	}
+	if len(b.consumers) == 0 {
+		return nil, errNoConsumers
+	}
+	b.built = true
+
+	p := pipeline.New(b.config)
+	p.SetNetlinkFallback(b.fallback)
+	p.SetRates(b.rates)
+	p.SetWorkers(b.workers)
+	p.SetQueues(b.queues[0], b.queues[1], b.backpress)
+	p.SetOnLost(b.onLost)
+	p.SetOnLostSamples(b.onSamples)
+	p.SetOnSeqGap(b.onSeqGap)
+
+	for _, m := range b.middleware {
+		if err := p.RegisterMiddleware(m.name, pipeline.Middleware(m.fn)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range b.consumers {
+		if err := p.RegisterSink(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.Init(); err != nil {
+		return nil, err
+	}
+
+	return &Conntracct{pipe: p}, nil
+}
+
+// Conntracct is an embedded accounting pipeline.
+type Conntracct struct {
+	pipe *pipeline.Pipeline
+}
+
+// Stats holds statistics about the events received by a Conntracct.
+type Stats struct {
+	// Amount of update and destroy events received from the kernel.
+	EventsUpdate  uint64
+	EventsDestroy uint64
+
+	// Amount of events lost between the kernel and the pipeline, eg.
+	// due to full perf buffers or queues.
+	Lost uint64
+
+	// Statistics of the middleware stages, in order of execution.
+	Middleware []MiddlewareStats
+}
+
+// MiddlewareStats holds statistics about a middleware stage.
+type MiddlewareStats struct {
+	Name string
+
+	// Amount of events the stage was called with and amount of events it dropped.
+	Events  uint64
+	Dropped uint64
+
+	// Total time spent in the stage.
+	Duration time.Duration
+}
+
+// Run starts the accounting probe and delivers events to the consumers
+// until ctx is done, then stops the probe. Can only be called once.
+func (c *Conntracct) Run(ctx context.Context) error {
+
+	if err := c.pipe.Start(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return c.pipe.Stop()
+}
+
+// Reconfigure applies the allowlists, cooldowns and byte threshold of cfg
+// to the running probe.
+// Address allowlists can't be changed after Build.
+func (c *Conntracct) Reconfigure(cfg bpf.Config) error {
+	return c.pipe.Reconfigure(cfg)
+}
+
+// Stats returns a snapshot of the Conntracct's statistics.
+func (c *Conntracct) Stats() Stats {
+
+	ps := c.pipe.ProbeStats()
+	st := c.pipe.Stats()
+
+	s := Stats{
+		EventsUpdate:  st.EventsUpdate,
+		EventsDestroy: st.EventsDestroy,
+		Lost:          ps.LostSamples,
+	}
+	for _, l := range ps.LostConsumer {
+		s.Lost += l
+	}
+	for _, m := range c.pipe.MiddlewareStats() {
+		s.Middleware = append(s.Middleware, MiddlewareStats(m))
+	}
+
+	return s
+}
@@ -0,0 +1,42 @@
+package conntracct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestBuilderErrors(t *testing.T) {
+
+	h := func(bpf.Event) {}
+
+	_, err := NewBuilder().Build()
+	assert.Equal(t, errNoConsumers, err)
+
+	_, err = NewBuilder().WithConsumer("a", 0, nil).Build()
+	assert.Equal(t, errNilHandler, err)
+
+	_, err = NewBuilder().
+		WithConsumer("a", bpf.ConsumerUpdate, h).
+		WithConsumer("a", bpf.ConsumerDestroy, h).
+		Build()
+	assert.Equal(t, errDupConsumer, err)
+
+	m := func(e bpf.Event) (bpf.Event, bool) { return e, true }
+
+	_, err = NewBuilder().WithMiddleware("m", nil).WithConsumer("a", 0, h).Build()
+	assert.Equal(t, errNilMiddleware, err)
+
+	_, err = NewBuilder().
+		WithMiddleware("m", m).
+		WithMiddleware("m", m).
+		WithConsumer("a", 0, h).
+		Build()
+	assert.Equal(t, errDupMiddleware, err)
+
+	s := &handlerSink{mode: bpf.ConsumerAll, handler: h}
+	assert.True(t, s.WantUpdate())
+	assert.True(t, s.WantDestroy())
+}
@@ -0,0 +1,13 @@
+package conntracct
+
+import "errors"
+
+var (
+	errNoConsumers   = errors.New("no consumers added")
+	errNilHandler    = errors.New("nil consumer handler")
+	errEmptyName     = errors.New("empty consumer or middleware name")
+	errDupConsumer   = errors.New("duplicate consumer name")
+	errNilMiddleware = errors.New("nil middleware")
+	errDupMiddleware = errors.New("duplicate middleware name")
+	errAlreadyBuilt  = errors.New("builder was already used")
+)
@@ -0,0 +1,38 @@
+package conntracct
+
+import (
+	"context"
+
+	"github.com/ti-mo/conntracct/internal/sinks/types"
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// handlerSink is a pipeline sink calling a consumer's Handler.
+type handlerSink struct {
+	name    string
+	mode    bpf.ConsumerMode
+	handler Handler
+
+	stats types.SinkStats
+}
+
+func (s *handlerSink) Init(types.SinkConfig) error { return nil }
+func (s *handlerSink) IsInit() bool                { return true }
+func (s *handlerSink) Name() string                { return s.name }
+func (s *handlerSink) WantUpdate() bool            { return s.mode&bpf.ConsumerUpdate != 0 }
+func (s *handlerSink) WantDestroy() bool           { return s.mode&bpf.ConsumerDestroy != 0 }
+func (s *handlerSink) Stats() types.SinkStatsData  { return s.stats.Get() }
+func (s *handlerSink) Flush(context.Context) error { return nil }
+func (s *handlerSink) Close() error                { return nil }
+
+// Push calls the consumer's Handler with the event.
+func (s *handlerSink) Push(e bpf.Event) error {
+	s.handler(e)
+	s.stats.IncrEventsPushed()
+	return nil
+}
+
+// PushBatch calls the consumer's Handler with each event of the batch.
+func (s *handlerSink) PushBatch(es []bpf.Event) (int, error) {
+	return types.PushEach(s.Push, es)
+}
@@ -0,0 +1,174 @@
+// Package generator produces synthetic accounting events resembling the
+// traffic of a busy gateway, for benchmarking sinks and sizing hosts.
+package generator
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// Config configures a Generator.
+type Config struct {
+
+	// Amount of concurrent flows. Defaults to 1000.
+	Flows int
+
+	// Share of IPv6 flows, between 0 and 1.
+	IPv6 float64
+
+	// Seed of the random number generator, for reproducible streams.
+	Seed int64
+}
+
+// Average amount of update events of a flow before it's destroyed.
+const meanUpdates = 20
+
+// service is a destination port with its protocol and typical packet size.
+type service struct {
+	port   uint16
+	proto  uint8
+	size   int
+	weight int
+}
+
+// Destination ports of generated flows, weighted by how common they are.
+var services = []service{
+	{443, 6, 1200, 50},
+	{80, 6, 900, 15},
+	{53, 17, 120, 15},
+	{443, 17, 1250, 8}, // QUIC
+	{22, 6, 200, 4},
+	{123, 17, 90, 3},
+	{25, 6, 600, 3},
+	{3306, 6, 400, 2},
+}
+
+// flow is a generated flow.
+type flow struct {
+	event bpf.Event
+	size  int
+
+	// Amount of updates left before the flow is destroyed.
+	updates int
+}
+
+// Generator produces accounting events of a fixed amount of concurrent flows.
+// Flows receive update events with increasing counters until they're
+// destroyed and replaced by a new flow. Not safe for concurrent use.
+type Generator struct {
+	cfg Config
+	rnd *rand.Rand
+
+	// Picks destination hosts, a few of which receive most flows.
+	hosts *rand.Zipf
+
+	flows  []flow
+	nextID uint32
+
+	weights int
+}
+
+// New returns a Generator for the given configuration.
+func New(cfg Config) *Generator {
+
+	if cfg.Flows <= 0 {
+		cfg.Flows = 1000
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+
+	g := &Generator{
+		cfg:   cfg,
+		rnd:   rnd,
+		hosts: rand.NewZipf(rnd, 1.2, 1, 1<<16-1),
+		flows: make([]flow, cfg.Flows),
+	}
+
+	for _, s := range services {
+		g.weights += s.weight
+	}
+
+	for i := range g.flows {
+		g.flows[i] = g.newFlow()
+	}
+
+	return g
+}
+
+// Next returns the next event, stamped with the given ktime time stamp.
+// Returns a destroy event with Destroy set for flows at the end of their
+// lifetime, after which they are replaced by a new flow.
+func (g *Generator) Next(ts uint64) bpf.Event {
+
+	f := &g.flows[g.rnd.Intn(len(g.flows))]
+
+	// Heavy-tailed amount of packets in both directions.
+	po := uint64(1 + g.rnd.ExpFloat64()*10)
+	pr := uint64(1 + g.rnd.ExpFloat64()*15)
+
+	e := &f.event
+	e.Timestamp = ts
+	e.PacketsOrig += po
+	e.BytesOrig += po * uint64(60+g.rnd.Intn(f.size/4+1))
+	e.PacketsRet += pr
+	e.BytesRet += pr * uint64(f.size/2+g.rnd.Intn(f.size/2+1))
+
+	out := *e
+
+	f.updates--
+	if f.updates <= 0 {
+		out.Destroy = true
+		*f = g.newFlow()
+	}
+
+	return out
+}
+
+// newFlow returns a new flow with random addresses and a weighted service.
+func (g *Generator) newFlow() flow {
+
+	g.nextID++
+
+	w := g.rnd.Intn(g.weights)
+	var s service
+	for _, s = range services {
+		if w < s.weight {
+			break
+		}
+		w -= s.weight
+	}
+
+	e := bpf.Event{
+		Start:        uint64(time.Now().UnixNano()),
+		ConnectionID: g.nextID,
+		SrcPort:      uint16(32768 + g.rnd.Intn(28232)), // ip_local_port_range
+		DstPort:      s.port,
+		Proto:        s.proto,
+	}
+
+	// Clients in private ranges, servers in documentation ranges.
+	h := g.hosts.Uint64()
+	if g.rnd.Float64() < g.cfg.IPv6 {
+		e.Family = bpf.FamilyIPv6
+		e.SrcAddr = net.IP{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(g.rnd.Intn(256)), byte(g.rnd.Intn(256))}
+		e.DstAddr = net.IP{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, byte(h >> 8), byte(h)}
+	} else {
+		e.Family = bpf.FamilyIPv4
+		e.SrcAddr = net.IPv4(10, byte(g.rnd.Intn(256)), byte(g.rnd.Intn(256)), byte(1+g.rnd.Intn(254)))
+		e.DstAddr = net.IPv4(198, 18, byte(h>>8), byte(h))
+	}
+
+	// TCP flows are mostly established.
+	if s.proto == 6 {
+		e.TCPState = 3 // TCP_CONNTRACK_ESTABLISHED
+	}
+
+	return flow{
+		event:   e,
+		size:    s.size,
+		updates: 1 + int(g.rnd.ExpFloat64()*meanUpdates),
+	}
+}
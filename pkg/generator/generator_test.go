@@ -0,0 +1,32 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator(t *testing.T) {
+
+	g := New(Config{Flows: 10, IPv6: 0.5, Seed: 1})
+
+	last := make(map[uint32]uint64)
+	destroyed := make(map[uint32]bool)
+
+	for i := uint64(1); i <= 10000; i++ {
+		e := g.Next(i)
+		assert.EqualValues(t, i, e.Timestamp)
+		assert.False(t, destroyed[e.ConnectionID], "event after destroy")
+
+		// Counters are cumulative.
+		assert.True(t, e.BytesOrig > last[e.ConnectionID])
+		last[e.ConnectionID] = e.BytesOrig
+
+		if e.Destroy {
+			destroyed[e.ConnectionID] = true
+		}
+	}
+
+	assert.NotEmpty(t, destroyed)
+	assert.True(t, len(last) > 10)
+}
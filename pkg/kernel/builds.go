@@ -27,6 +27,24 @@ var Builds = map[string]Kernel{
 		Params:  params["MarkNFTNat"],
 		Probes:  kprobes["acct_v1"],
 	},
+	// 5.8 introduced BPF ring buffers, used instead of per-CPU perf buffers.
+	"5.8.18": {
+		Version: "5.8.18",
+		URL:     "https://cdn.kernel.org/pub/linux/kernel/v5.x/linux-5.8.18.tar.xz",
+		Params:  params["MarkNFTNat"],
+		Probes:  kprobes["acct_v1"],
+		RingBuf: true,
+	},
+	// 6.1 introduced bpf_ktime_get_tai_ns, for recording wall clock time stamps.
+	"6.1.55": {
+		Version:  "6.1.55",
+		URL:      "https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.55.tar.xz",
+		Params:   params["MarkNFTNat"],
+		Probes:   kprobes["acct_v1"],
+		RingBuf:  true,
+		RealTime: true,
+		Fentry:   fentry["acct_v1"],
+	},
 }
 
 var params = map[string]Params{
@@ -37,6 +55,9 @@ var params = map[string]Params{
 		"CONFIG_NF_CONNTRACK":      "m",
 		"CONFIG_NF_CONNTRACK_MARK": "y",
 
+		// adds the zone to nf_conn, enabled by most distributions
+		"CONFIG_NF_CONNTRACK_ZONES": "y",
+
 		// changes alignment of the ct extensions enum for timestamp
 		"CONFIG_NF_CONNTRACK_EVENTS":    "y",
 		"CONFIG_NF_CONNTRACK_TIMESTAMP": "y",
@@ -56,3 +77,11 @@ var kprobes = map[string]Probes{
 		"kprobe/__nf_ct_refresh_acct",
 	},
 }
+
+var fentry = map[string]Probes{
+	// Attached in the sequence listed here, like kprobes.
+	"acct_v1": Probes{
+		"fentry/nf_conntrack_free",
+		"fexit/__nf_ct_refresh_acct",
+	},
+}
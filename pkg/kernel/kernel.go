@@ -22,6 +22,19 @@ type Kernel struct {
 	URL     string
 	Params  Params
 	Probes  Probes
+
+	// Probe delivers events over BPF ring buffers instead of perf buffers.
+	// Requires 5.8 or later.
+	RingBuf bool
+
+	// Probe can record the wall clock time of events using
+	// bpf_ktime_get_tai_ns. Requires 6.1 or later.
+	RealTime bool
+
+	// Tracing programs of the probe attached through fentry/fexit
+	// trampolines instead of Probes, if the running kernel has BTF.
+	// Tracing functions of modules requires 5.11 or later.
+	Fentry Probes
 }
 
 // ArchiveName returns the file name of the archive based on its URL.
@@ -0,0 +1,5 @@
+package plugin
+
+import "errors"
+
+var errNotStarted = errors.New("this executable is a conntracct plugin and must be started by conntracct")
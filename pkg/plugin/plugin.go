@@ -0,0 +1,130 @@
+// Package plugin implements out-of-process sinks, executables conntracct
+// starts and sends its events to over gRPC. This lets sinks be built and
+// shipped separately from conntracct:
+//
+//	type billing struct{}
+//
+//	func (billing) Configure(ctx context.Context, name string, opts map[string]string) error { ... }
+//	func (billing) Write(ctx context.Context, events []*schema.Event) error { ... }
+//	func (billing) Close() error { ... }
+//
+//	func main() {
+//		if err := plugin.Serve(billing{}); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// configured as a sink of type plugin:
+//
+//	sinks:
+//	  billing:
+//	    type: plugin
+//	    command: /usr/local/bin/conntracct-billing
+//	    options:
+//	      account: "1234"
+//
+// The protocol is described in proto/conntracct/plugin/v1/sink.proto.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+const (
+	// ProtocolVersion is the version of the plugin protocol implemented
+	// by this package.
+	ProtocolVersion = 1
+
+	// MagicCookieKey and MagicCookieValue are set in the environment of
+	// plugins started by conntracct.
+	MagicCookieKey   = "CONNTRACCT_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "b2a6c0f1-conntracct-sink"
+)
+
+// Sink is implemented by plugins.
+type Sink interface {
+	// Configure is called once with the sink's name and options before
+	// any events are written.
+	Configure(ctx context.Context, name string, options map[string]string) error
+
+	// Write writes a batch of events, returning an error if the batch could
+	// not be written. Called concurrently if the sink has multiple workers.
+	Write(ctx context.Context, events []*schema.Event) error
+
+	// Close is called when conntracct stops the plugin.
+	Close() error
+}
+
+// Pinger is optionally implemented by plugins that can check whether their
+// backing storage is reachable, used for the sink's health checks.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Serve serves the Sink on a unix socket and writes the handshake to stdout,
+// then blocks until conntracct stops the plugin with SIGTERM or SIGINT.
+// Returns an error if the executable was not started by conntracct.
+func Serve(s Sink) error {
+
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return errNotStarted
+	}
+
+	dir, err := os.MkdirTemp("", "conntracct-plugin")
+	if err != nil {
+		return errors.Wrap(err, "creating socket directory")
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "plugin.sock"))
+	if err != nil {
+		return errors.Wrap(err, "listening on socket")
+	}
+
+	if _, err := fmt.Fprintf(os.Stdout, "%d|%s|%s\n", ProtocolVersion, l.Addr().Network(), l.Addr()); err != nil {
+		l.Close()
+		return errors.Wrap(err, "writing handshake")
+	}
+
+	return Listen(s, l)
+}
+
+// Listen serves the Sink on the given listener without a handshake, eg. for
+// plugins started separately from conntracct and configured by their address.
+// Blocks until the process receives SIGTERM or SIGINT, then stops serving
+// and closes the Sink. Also returns when the listener is closed.
+func Listen(s Sink, l net.Listener) error {
+
+	srv := grpc.NewServer()
+	srv.RegisterService(&serviceDesc, &server{sink: s})
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		if _, ok := <-sig; ok {
+			srv.GracefulStop()
+		}
+	}()
+
+	err := srv.Serve(l)
+	signal.Stop(sig)
+	close(sig)
+
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	schema "github.com/ti-mo/conntracct/pkg/schema/v1"
+)
+
+// ServiceName is the fully-qualified name of the Sink service in sink.proto.
+const ServiceName = "conntracct.plugin.v1.Sink"
+
+// Full names of the Sink service's methods, for calling them on a
+// grpc.ClientConn.
+const (
+	MethodConfigure = "/" + ServiceName + "/Configure"
+	MethodWrite     = "/" + ServiceName + "/Write"
+	MethodPing      = "/" + ServiceName + "/Ping"
+)
+
+// WriteStreamDesc describes the client-streaming Write method to
+// grpc.ClientConn.NewStream.
+var WriteStreamDesc = grpc.StreamDesc{StreamName: "Write", ClientStreams: true}
+
+// server implements the Sink service on top of a plugin's Sink.
+type server struct {
+	sink Sink
+}
+
+// serviceDesc describes the Sink service to the gRPC server.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Configure", Handler: func(srv interface{}, ctx context.Context,
+			dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(structpb.Struct)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return srv.(*server).configure(ctx, req)
+		}},
+		{MethodName: "Ping", Handler: func(srv interface{}, ctx context.Context,
+			dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			if err := dec(new(emptypb.Empty)); err != nil {
+				return nil, err
+			}
+			return srv.(*server).ping(ctx)
+		}},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Write",
+			ClientStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*server).write(stream)
+			},
+		},
+	},
+	Metadata: "conntracct/plugin/v1/sink.proto",
+}
+
+// configure configures the plugin's Sink with the request's name and options.
+func (s *server) configure(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+
+	fields := req.GetFields()
+
+	opts := make(map[string]string)
+	for k, v := range fields["options"].GetStructValue().GetFields() {
+		opts[k] = v.GetStringValue()
+	}
+
+	if err := s.sink.Configure(ctx, fields["name"].GetStringValue(), opts); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ping checks the health of the plugin's Sink if it implements Pinger.
+func (s *server) ping(ctx context.Context) (*emptypb.Empty, error) {
+
+	if p, ok := s.sink.(Pinger); ok {
+		if err := p.Ping(ctx); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// write receives a batch of events and writes it to the plugin's Sink.
+func (s *server) write(stream grpc.ServerStream) error {
+
+	var events []*schema.Event
+	for {
+		e := new(schema.Event)
+		err := stream.RecvMsg(e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		events = append(events, e)
+	}
+
+	if err := s.sink.Write(stream.Context(), events); err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return stream.SendMsg(&emptypb.Empty{})
+}
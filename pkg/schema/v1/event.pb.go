@@ -0,0 +1,729 @@
+// Schema of the accounting events produced by conntracct, eg. by the Kafka
+// sink with encoding 'protobuf' and by the gRPC API's event stream.
+//
+// Fields are never renumbered or changed in type within a version of the
+// package. Incompatible changes are made in a new version, conntracct.v2.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
+// source: conntracct/v1/event.proto
+
+package schema
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Type of an event.
+type Event_Type int32
+
+const (
+	Event_TYPE_UNSPECIFIED Event_Type = 0
+	// The flow's current counters.
+	Event_TYPE_UPDATE Event_Type = 1
+	// The flow's final counters, sent when it's removed from the table.
+	Event_TYPE_DESTROY Event_Type = 2
+)
+
+// Enum value maps for Event_Type.
+var (
+	Event_Type_name = map[int32]string{
+		0: "TYPE_UNSPECIFIED",
+		1: "TYPE_UPDATE",
+		2: "TYPE_DESTROY",
+	}
+	Event_Type_value = map[string]int32{
+		"TYPE_UNSPECIFIED": 0,
+		"TYPE_UPDATE":      1,
+		"TYPE_DESTROY":     2,
+	}
+)
+
+func (x Event_Type) Enum() *Event_Type {
+	p := new(Event_Type)
+	*p = x
+	return p
+}
+
+func (x Event_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Event_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_conntracct_v1_event_proto_enumTypes[0].Descriptor()
+}
+
+func (Event_Type) Type() protoreflect.EnumType {
+	return &file_conntracct_v1_event_proto_enumTypes[0]
+}
+
+func (x Event_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Event_Type.Descriptor instead.
+func (Event_Type) EnumDescriptor() ([]byte, []int) {
+	return file_conntracct_v1_event_proto_rawDescGZIP(), []int{0, 0}
+}
+
+// Address family of a flow.
+type Event_Family int32
+
+const (
+	Event_FAMILY_UNSPECIFIED Event_Family = 0
+	Event_FAMILY_IPV4        Event_Family = 1
+	Event_FAMILY_IPV6        Event_Family = 2
+)
+
+// Enum value maps for Event_Family.
+var (
+	Event_Family_name = map[int32]string{
+		0: "FAMILY_UNSPECIFIED",
+		1: "FAMILY_IPV4",
+		2: "FAMILY_IPV6",
+	}
+	Event_Family_value = map[string]int32{
+		"FAMILY_UNSPECIFIED": 0,
+		"FAMILY_IPV4":        1,
+		"FAMILY_IPV6":        2,
+	}
+)
+
+func (x Event_Family) Enum() *Event_Family {
+	p := new(Event_Family)
+	*p = x
+	return p
+}
+
+func (x Event_Family) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Event_Family) Descriptor() protoreflect.EnumDescriptor {
+	return file_conntracct_v1_event_proto_enumTypes[1].Descriptor()
+}
+
+func (Event_Family) Type() protoreflect.EnumType {
+	return &file_conntracct_v1_event_proto_enumTypes[1]
+}
+
+func (x Event_Family) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Event_Family.Descriptor instead.
+func (Event_Family) EnumDescriptor() ([]byte, []int) {
+	return file_conntracct_v1_event_proto_rawDescGZIP(), []int{0, 1}
+}
+
+// Event is an accounting event of a conntrack flow.
+type Event struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type Event_Type `protobuf:"varint,1,opt,name=type,proto3,enum=conntracct.v1.Event_Type" json:"type,omitempty"`
+	// Time of the event.
+	Timestamp *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Start of the flow, unset if conntrack timestamping is disabled.
+	Start *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=start,proto3" json:"start,omitempty"`
+	// Lifetime of the flow on destroy events, unset if unknown.
+	Duration *durationpb.Duration `protobuf:"bytes,4,opt,name=duration,proto3" json:"duration,omitempty"`
+	// Identifier of the flow, unique among the live flows.
+	ConnectionId uint32 `protobuf:"varint,5,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	Connmark     uint32 `protobuf:"varint,6,opt,name=connmark,proto3" json:"connmark,omitempty"`
+	// Addresses of the flow's original direction, 4 bytes for IPv4 and
+	// 16 bytes for IPv6.
+	SrcAddr []byte `protobuf:"bytes,7,opt,name=src_addr,json=srcAddr,proto3" json:"src_addr,omitempty"`
+	DstAddr []byte `protobuf:"bytes,8,opt,name=dst_addr,json=dstAddr,proto3" json:"dst_addr,omitempty"`
+	// Ports of the flow's original direction, zero for protocols without ports.
+	SrcPort uint32 `protobuf:"varint,9,opt,name=src_port,json=srcPort,proto3" json:"src_port,omitempty"`
+	DstPort uint32 `protobuf:"varint,10,opt,name=dst_port,json=dstPort,proto3" json:"dst_port,omitempty"`
+	// IP protocol number, eg. 6 for TCP.
+	Proto  uint32       `protobuf:"varint,11,opt,name=proto,proto3" json:"proto,omitempty"`
+	Family Event_Family `protobuf:"varint,12,opt,name=family,proto3,enum=conntracct.v1.Event_Family" json:"family,omitempty"`
+	// TCP connection state (enum tcp_conntrack), zero for other protocols.
+	TcpState uint32 `protobuf:"varint,13,opt,name=tcp_state,json=tcpState,proto3" json:"tcp_state,omitempty"`
+	// Network namespace inode and conntrack zone of the flow.
+	Netns uint32 `protobuf:"varint,14,opt,name=netns,proto3" json:"netns,omitempty"`
+	Zone  uint32 `protobuf:"varint,15,opt,name=zone,proto3" json:"zone,omitempty"`
+	// Interface of the last packet accounted in an update event, within the
+	// flow's network namespace.
+	Ifindex uint32 `protobuf:"varint,16,opt,name=ifindex,proto3" json:"ifindex,omitempty"`
+	// Counters of the flow's original and return directions.
+	PacketsOrig uint64 `protobuf:"varint,17,opt,name=packets_orig,json=packetsOrig,proto3" json:"packets_orig,omitempty"`
+	BytesOrig   uint64 `protobuf:"varint,18,opt,name=bytes_orig,json=bytesOrig,proto3" json:"bytes_orig,omitempty"`
+	PacketsRet  uint64 `protobuf:"varint,19,opt,name=packets_ret,json=packetsRet,proto3" json:"packets_ret,omitempty"`
+	BytesRet    uint64 `protobuf:"varint,20,opt,name=bytes_ret,json=bytesRet,proto3" json:"bytes_ret,omitempty"`
+	// Set when 1 in sample_rate flows is reported, zero if all flows are
+	// reported. Counters are not scaled.
+	SampleRate uint32 `protobuf:"varint,21,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	// Set on ICMP and ICMPv6 flows.
+	Icmp *Event_ICMP `protobuf:"bytes,22,opt,name=icmp,proto3" json:"icmp,omitempty"`
+	// Set if the throughput of the flow was computed.
+	Rate *Event_Rate `protobuf:"bytes,23,opt,name=rate,proto3" json:"rate,omitempty"`
+	// Metadata attached to the event, eg. by enrichers.
+	Tags map[string]string `protobuf:"bytes,24,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_conntracct_v1_event_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_conntracct_v1_event_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_conntracct_v1_event_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetType() Event_Type {
+	if x != nil {
+		return x.Type
+	}
+	return Event_TYPE_UNSPECIFIED
+}
+
+func (x *Event) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Event) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *Event) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+func (x *Event) GetConnectionId() uint32 {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return 0
+}
+
+func (x *Event) GetConnmark() uint32 {
+	if x != nil {
+		return x.Connmark
+	}
+	return 0
+}
+
+func (x *Event) GetSrcAddr() []byte {
+	if x != nil {
+		return x.SrcAddr
+	}
+	return nil
+}
+
+func (x *Event) GetDstAddr() []byte {
+	if x != nil {
+		return x.DstAddr
+	}
+	return nil
+}
+
+func (x *Event) GetSrcPort() uint32 {
+	if x != nil {
+		return x.SrcPort
+	}
+	return 0
+}
+
+func (x *Event) GetDstPort() uint32 {
+	if x != nil {
+		return x.DstPort
+	}
+	return 0
+}
+
+func (x *Event) GetProto() uint32 {
+	if x != nil {
+		return x.Proto
+	}
+	return 0
+}
+
+func (x *Event) GetFamily() Event_Family {
+	if x != nil {
+		return x.Family
+	}
+	return Event_FAMILY_UNSPECIFIED
+}
+
+func (x *Event) GetTcpState() uint32 {
+	if x != nil {
+		return x.TcpState
+	}
+	return 0
+}
+
+func (x *Event) GetNetns() uint32 {
+	if x != nil {
+		return x.Netns
+	}
+	return 0
+}
+
+func (x *Event) GetZone() uint32 {
+	if x != nil {
+		return x.Zone
+	}
+	return 0
+}
+
+func (x *Event) GetIfindex() uint32 {
+	if x != nil {
+		return x.Ifindex
+	}
+	return 0
+}
+
+func (x *Event) GetPacketsOrig() uint64 {
+	if x != nil {
+		return x.PacketsOrig
+	}
+	return 0
+}
+
+func (x *Event) GetBytesOrig() uint64 {
+	if x != nil {
+		return x.BytesOrig
+	}
+	return 0
+}
+
+func (x *Event) GetPacketsRet() uint64 {
+	if x != nil {
+		return x.PacketsRet
+	}
+	return 0
+}
+
+func (x *Event) GetBytesRet() uint64 {
+	if x != nil {
+		return x.BytesRet
+	}
+	return 0
+}
+
+func (x *Event) GetSampleRate() uint32 {
+	if x != nil {
+		return x.SampleRate
+	}
+	return 0
+}
+
+func (x *Event) GetIcmp() *Event_ICMP {
+	if x != nil {
+		return x.Icmp
+	}
+	return nil
+}
+
+func (x *Event) GetRate() *Event_Rate {
+	if x != nil {
+		return x.Rate
+	}
+	return nil
+}
+
+func (x *Event) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+// Identifier, type and code of the request starting an ICMP or ICMPv6 flow.
+type Event_ICMP struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id   uint32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type uint32 `protobuf:"varint,2,opt,name=type,proto3" json:"type,omitempty"`
+	Code uint32 `protobuf:"varint,3,opt,name=code,proto3" json:"code,omitempty"`
+}
+
+func (x *Event_ICMP) Reset() {
+	*x = Event_ICMP{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_conntracct_v1_event_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Event_ICMP) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event_ICMP) ProtoMessage() {}
+
+func (x *Event_ICMP) ProtoReflect() protoreflect.Message {
+	mi := &file_conntracct_v1_event_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event_ICMP.ProtoReflect.Descriptor instead.
+func (*Event_ICMP) Descriptor() ([]byte, []int) {
+	return file_conntracct_v1_event_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *Event_ICMP) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Event_ICMP) GetType() uint32 {
+	if x != nil {
+		return x.Type
+	}
+	return 0
+}
+
+func (x *Event_ICMP) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+// Throughput of a flow since its previous event, per second.
+type Event_Rate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Time between the events.
+	Interval    *durationpb.Duration `protobuf:"bytes,1,opt,name=interval,proto3" json:"interval,omitempty"`
+	PacketsOrig float64              `protobuf:"fixed64,2,opt,name=packets_orig,json=packetsOrig,proto3" json:"packets_orig,omitempty"`
+	BytesOrig   float64              `protobuf:"fixed64,3,opt,name=bytes_orig,json=bytesOrig,proto3" json:"bytes_orig,omitempty"`
+	PacketsRet  float64              `protobuf:"fixed64,4,opt,name=packets_ret,json=packetsRet,proto3" json:"packets_ret,omitempty"`
+	BytesRet    float64              `protobuf:"fixed64,5,opt,name=bytes_ret,json=bytesRet,proto3" json:"bytes_ret,omitempty"`
+}
+
+func (x *Event_Rate) Reset() {
+	*x = Event_Rate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_conntracct_v1_event_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Event_Rate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event_Rate) ProtoMessage() {}
+
+func (x *Event_Rate) ProtoReflect() protoreflect.Message {
+	mi := &file_conntracct_v1_event_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event_Rate.ProtoReflect.Descriptor instead.
+func (*Event_Rate) Descriptor() ([]byte, []int) {
+	return file_conntracct_v1_event_proto_rawDescGZIP(), []int{0, 1}
+}
+
+func (x *Event_Rate) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+func (x *Event_Rate) GetPacketsOrig() float64 {
+	if x != nil {
+		return x.PacketsOrig
+	}
+	return 0
+}
+
+func (x *Event_Rate) GetBytesOrig() float64 {
+	if x != nil {
+		return x.BytesOrig
+	}
+	return 0
+}
+
+func (x *Event_Rate) GetPacketsRet() float64 {
+	if x != nil {
+		return x.PacketsRet
+	}
+	return 0
+}
+
+func (x *Event_Rate) GetBytesRet() float64 {
+	if x != nil {
+		return x.BytesRet
+	}
+	return 0
+}
+
+var File_conntracct_v1_event_proto protoreflect.FileDescriptor
+
+var file_conntracct_v1_event_proto_rawDesc = []byte{
+	0x0a, 0x19, 0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2f, 0x76, 0x31, 0x2f,
+	0x65, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0d, 0x63, 0x6f, 0x6e,
+	0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2e, 0x76, 0x31, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xa3, 0x0a, 0x0a, 0x05,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x2d, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74,
+	0x2e, 0x76, 0x31, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04,
+	0x74, 0x79, 0x70, 0x65, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x30,
+	0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74,
+	0x12, 0x35, 0x0a, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x64,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x23, 0x0a, 0x0d, 0x63, 0x6f, 0x6e, 0x6e, 0x65,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c,
+	0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08,
+	0x63, 0x6f, 0x6e, 0x6e, 0x6d, 0x61, 0x72, 0x6b, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08,
+	0x63, 0x6f, 0x6e, 0x6e, 0x6d, 0x61, 0x72, 0x6b, 0x12, 0x19, 0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f,
+	0x61, 0x64, 0x64, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x73, 0x72, 0x63, 0x41,
+	0x64, 0x64, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x73, 0x74, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x64, 0x73, 0x74, 0x41, 0x64, 0x64, 0x72, 0x12, 0x19,
+	0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x07, 0x73, 0x72, 0x63, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x73, 0x74,
+	0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x64, 0x73, 0x74,
+	0x50, 0x6f, 0x72, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x05, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x33, 0x0a, 0x06, 0x66, 0x61,
+	0x6d, 0x69, 0x6c, 0x79, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x63, 0x6f, 0x6e,
+	0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74,
+	0x2e, 0x46, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x52, 0x06, 0x66, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x12,
+	0x1b, 0x0a, 0x09, 0x74, 0x63, 0x70, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x0d, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x08, 0x74, 0x63, 0x70, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x6e, 0x65, 0x74, 0x6e, 0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x6e, 0x65, 0x74,
+	0x6e, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x69, 0x66, 0x69, 0x6e, 0x64, 0x65,
+	0x78, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x69, 0x66, 0x69, 0x6e, 0x64, 0x65, 0x78,
+	0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x5f, 0x6f, 0x72, 0x69, 0x67,
+	0x18, 0x11, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x4f,
+	0x72, 0x69, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x79, 0x74, 0x65, 0x73, 0x5f, 0x6f, 0x72, 0x69,
+	0x67, 0x18, 0x12, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x62, 0x79, 0x74, 0x65, 0x73, 0x4f, 0x72,
+	0x69, 0x67, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x5f, 0x72, 0x65,
+	0x74, 0x18, 0x13, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0a, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73,
+	0x52, 0x65, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x79, 0x74, 0x65, 0x73, 0x5f, 0x72, 0x65, 0x74,
+	0x18, 0x14, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x62, 0x79, 0x74, 0x65, 0x73, 0x52, 0x65, 0x74,
+	0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x18,
+	0x15, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x61, 0x74,
+	0x65, 0x12, 0x2d, 0x0a, 0x04, 0x69, 0x63, 0x6d, 0x70, 0x18, 0x16, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x19, 0x2e, 0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2e, 0x76, 0x31, 0x2e,
+	0x45, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x49, 0x43, 0x4d, 0x50, 0x52, 0x04, 0x69, 0x63, 0x6d, 0x70,
+	0x12, 0x2d, 0x0a, 0x04, 0x72, 0x61, 0x74, 0x65, 0x18, 0x17, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19,
+	0x2e, 0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x2e, 0x52, 0x61, 0x74, 0x65, 0x52, 0x04, 0x72, 0x61, 0x74, 0x65, 0x12,
+	0x32, 0x0a, 0x04, 0x74, 0x61, 0x67, 0x73, 0x18, 0x18, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1e, 0x2e,
+	0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x2e, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x04, 0x74,
+	0x61, 0x67, 0x73, 0x1a, 0x3e, 0x0a, 0x04, 0x49, 0x43, 0x4d, 0x50, 0x12, 0x0e, 0x0a, 0x02, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74,
+	0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x63,
+	0x6f, 0x64, 0x65, 0x1a, 0xbd, 0x01, 0x0a, 0x04, 0x52, 0x61, 0x74, 0x65, 0x12, 0x35, 0x0a, 0x08,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x76, 0x61, 0x6c, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x5f, 0x6f,
+	0x72, 0x69, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x73, 0x4f, 0x72, 0x69, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x79, 0x74, 0x65, 0x73, 0x5f,
+	0x6f, 0x72, 0x69, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x62, 0x79, 0x74, 0x65,
+	0x73, 0x4f, 0x72, 0x69, 0x67, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73,
+	0x5f, 0x72, 0x65, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x70, 0x61, 0x63, 0x6b,
+	0x65, 0x74, 0x73, 0x52, 0x65, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x79, 0x74, 0x65, 0x73, 0x5f,
+	0x72, 0x65, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x62, 0x79, 0x74, 0x65, 0x73,
+	0x52, 0x65, 0x74, 0x1a, 0x37, 0x0a, 0x09, 0x54, 0x61, 0x67, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3f, 0x0a, 0x04,
+	0x54, 0x79, 0x70, 0x65, 0x12, 0x14, 0x0a, 0x10, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x55, 0x4e, 0x53,
+	0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0f, 0x0a, 0x0b, 0x54, 0x59,
+	0x50, 0x45, 0x5f, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x10, 0x01, 0x12, 0x10, 0x0a, 0x0c, 0x54,
+	0x59, 0x50, 0x45, 0x5f, 0x44, 0x45, 0x53, 0x54, 0x52, 0x4f, 0x59, 0x10, 0x02, 0x22, 0x42, 0x0a,
+	0x06, 0x46, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x12, 0x16, 0x0a, 0x12, 0x46, 0x41, 0x4d, 0x49, 0x4c,
+	0x59, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
+	0x0f, 0x0a, 0x0b, 0x46, 0x41, 0x4d, 0x49, 0x4c, 0x59, 0x5f, 0x49, 0x50, 0x56, 0x34, 0x10, 0x01,
+	0x12, 0x0f, 0x0a, 0x0b, 0x46, 0x41, 0x4d, 0x49, 0x4c, 0x59, 0x5f, 0x49, 0x50, 0x56, 0x36, 0x10,
+	0x02, 0x42, 0x32, 0x5a, 0x30, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x74, 0x69, 0x2d, 0x6d, 0x6f, 0x2f, 0x63, 0x6f, 0x6e, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x63, 0x74,
+	0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x2f, 0x76, 0x31, 0x3b, 0x73,
+	0x63, 0x68, 0x65, 0x6d, 0x61, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_conntracct_v1_event_proto_rawDescOnce sync.Once
+	file_conntracct_v1_event_proto_rawDescData = file_conntracct_v1_event_proto_rawDesc
+)
+
+func file_conntracct_v1_event_proto_rawDescGZIP() []byte {
+	file_conntracct_v1_event_proto_rawDescOnce.Do(func() {
+		file_conntracct_v1_event_proto_rawDescData = protoimpl.X.CompressGZIP(file_conntracct_v1_event_proto_rawDescData)
+	})
+	return file_conntracct_v1_event_proto_rawDescData
+}
+
+var file_conntracct_v1_event_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_conntracct_v1_event_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_conntracct_v1_event_proto_goTypes = []interface{}{
+	(Event_Type)(0),               // 0: conntracct.v1.Event.Type
+	(Event_Family)(0),             // 1: conntracct.v1.Event.Family
+	(*Event)(nil),                 // 2: conntracct.v1.Event
+	(*Event_ICMP)(nil),            // 3: conntracct.v1.Event.ICMP
+	(*Event_Rate)(nil),            // 4: conntracct.v1.Event.Rate
+	nil,                           // 5: conntracct.v1.Event.TagsEntry
+	(*timestamppb.Timestamp)(nil), // 6: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 7: google.protobuf.Duration
+}
+var file_conntracct_v1_event_proto_depIdxs = []int32{
+	0, // 0: conntracct.v1.Event.type:type_name -> conntracct.v1.Event.Type
+	6, // 1: conntracct.v1.Event.timestamp:type_name -> google.protobuf.Timestamp
+	6, // 2: conntracct.v1.Event.start:type_name -> google.protobuf.Timestamp
+	7, // 3: conntracct.v1.Event.duration:type_name -> google.protobuf.Duration
+	1, // 4: conntracct.v1.Event.family:type_name -> conntracct.v1.Event.Family
+	3, // 5: conntracct.v1.Event.icmp:type_name -> conntracct.v1.Event.ICMP
+	4, // 6: conntracct.v1.Event.rate:type_name -> conntracct.v1.Event.Rate
+	5, // 7: conntracct.v1.Event.tags:type_name -> conntracct.v1.Event.TagsEntry
+	7, // 8: conntracct.v1.Event.Rate.interval:type_name -> google.protobuf.Duration
+	9, // [9:9] is the sub-list for method output_type
+	9, // [9:9] is the sub-list for method input_type
+	9, // [9:9] is the sub-list for extension type_name
+	9, // [9:9] is the sub-list for extension extendee
+	0, // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_conntracct_v1_event_proto_init() }
+func file_conntracct_v1_event_proto_init() {
+	if File_conntracct_v1_event_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_conntracct_v1_event_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_conntracct_v1_event_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event_ICMP); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_conntracct_v1_event_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Event_Rate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_conntracct_v1_event_proto_rawDesc,
+			NumEnums:      2,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_conntracct_v1_event_proto_goTypes,
+		DependencyIndexes: file_conntracct_v1_event_proto_depIdxs,
+		EnumInfos:         file_conntracct_v1_event_proto_enumTypes,
+		MessageInfos:      file_conntracct_v1_event_proto_msgTypes,
+	}.Build()
+	File_conntracct_v1_event_proto = out.File
+	file_conntracct_v1_event_proto_rawDesc = nil
+	file_conntracct_v1_event_proto_goTypes = nil
+	file_conntracct_v1_event_proto_depIdxs = nil
+}
@@ -0,0 +1,94 @@
+// Package schema holds version 1 of the protobuf schema of conntracct's
+// accounting events, generated from proto/conntracct/v1/event.proto.
+package schema
+
+//go:generate protoc -I ../../../proto --go_out=../../.. --go_opt=module=github.com/ti-mo/conntracct conntracct/v1/event.proto
+
+import (
+	"net"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+// FromEvent converts an accounting event to its protobuf representation.
+// The event's ktime time stamp is made absolute using the machine's bootTime,
+// unless the kernel recorded its wall clock time.
+func FromEvent(e bpf.Event, bootTime time.Time) *Event {
+
+	pe := &Event{
+		Type:         Event_TYPE_UPDATE,
+		Timestamp:    timestamppb.New(e.Time(bootTime)),
+		ConnectionId: e.ConnectionID,
+		Connmark:     e.Connmark,
+		SrcAddr:      addr(e.SrcAddr, e.Family),
+		DstAddr:      addr(e.DstAddr, e.Family),
+		SrcPort:      uint32(e.SrcPort),
+		DstPort:      uint32(e.DstPort),
+		Proto:        uint32(e.Proto),
+		TcpState:     uint32(e.TCPState),
+		Netns:        e.NetNS,
+		Zone:         uint32(e.Zone),
+		Ifindex:      e.Ifindex,
+		PacketsOrig:  e.PacketsOrig,
+		BytesOrig:    e.BytesOrig,
+		PacketsRet:   e.PacketsRet,
+		BytesRet:     e.BytesRet,
+		SampleRate:   e.SampleRate,
+		Tags:         e.Tags,
+	}
+
+	if e.Destroy {
+		pe.Type = Event_TYPE_DESTROY
+	}
+
+	switch e.Family {
+	case bpf.FamilyIPv4:
+		pe.Family = Event_FAMILY_IPV4
+	case bpf.FamilyIPv6:
+		pe.Family = Event_FAMILY_IPV6
+	}
+
+	if e.Start != 0 {
+		pe.Start = timestamppb.New(time.Unix(0, int64(e.Start)))
+	}
+	if e.Duration != 0 {
+		pe.Duration = durationpb.New(e.Duration)
+	}
+
+	if e.ICMP() {
+		pe.Icmp = &Event_ICMP{
+			Id:   uint32(e.ICMPID),
+			Type: uint32(e.ICMPType),
+			Code: uint32(e.ICMPCode),
+		}
+	}
+
+	if e.Rate.Interval != 0 {
+		pe.Rate = &Event_Rate{
+			Interval:    durationpb.New(e.Rate.Interval),
+			PacketsOrig: e.Rate.PacketsOrig,
+			BytesOrig:   e.Rate.BytesOrig,
+			PacketsRet:  e.Rate.PacketsRet,
+			BytesRet:    e.Rate.BytesRet,
+		}
+	}
+
+	return pe
+}
+
+// addr returns the 4- or 16-byte representation of an address
+// of the given family.
+func addr(ip net.IP, family uint8) []byte {
+
+	if family == bpf.FamilyIPv4 {
+		if v4 := ip.To4(); v4 != nil {
+			return v4
+		}
+	}
+
+	return ip.To16()
+}
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntracct/pkg/bpf"
+)
+
+func TestFromEvent(t *testing.T) {
+
+	boot := time.Unix(1000, 0)
+
+	pe := FromEvent(bpf.Event{
+		Timestamp: uint64(time.Second),
+		SrcAddr:   net.IPv4(10, 0, 0, 1), DstAddr: net.IPv4(10, 0, 0, 2),
+		DstPort: 443, Proto: 6, Family: bpf.FamilyIPv4, BytesOrig: 100,
+		Destroy: true, Duration: time.Minute,
+		Tags: map[string]string{"pod": "web"},
+	}, boot)
+
+	assert.Equal(t, Event_TYPE_DESTROY, pe.GetType())
+	assert.True(t, boot.Add(time.Second).Equal(pe.GetTimestamp().AsTime()))
+	assert.Nil(t, pe.GetStart())
+	assert.Equal(t, time.Minute, pe.GetDuration().AsDuration())
+	assert.Equal(t, []byte{10, 0, 0, 1}, pe.GetSrcAddr())
+	assert.EqualValues(t, 443, pe.GetDstPort())
+	assert.Equal(t, Event_FAMILY_IPV4, pe.GetFamily())
+	assert.EqualValues(t, 100, pe.GetBytesOrig())
+	assert.Equal(t, "web", pe.GetTags()["pod"])
+	assert.Nil(t, pe.GetIcmp())
+}